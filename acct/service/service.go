@@ -6,12 +6,38 @@ import (
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	acctStore "github.com/wolfinger/varangian/acct/store"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/page"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+type acctBasis struct {
+	Cash    string
+	Accrual string
+}
+
+var (
+	// AcctBasis defines the accounting bases an account can be kept on. cash basis accounts recognize income
+	// when it's paid, while accrual basis accounts recognize it when it's earned. accounts without a basis
+	// are kept on a cash basis
+	AcctBasis = acctBasis{
+		Cash:    "cash",
+		Accrual: "accrual"}
+)
+
+// validBasis checks a basis is in the list of account bases
+func validBasis(basis string) bool {
+	switch basis {
+	case "", AcctBasis.Cash, AcctBasis.Accrual:
+		return true
+	}
+	return false
+}
+
 // Service interface used for implementing the Account service
 type Service interface {
 	v1.AcctServiceServer
@@ -54,25 +80,29 @@ func (s *AcctServiceImpl) GetAcct(ctx context.Context, request *v1.GetAcctReques
 
 // ListAccts lists an array of accounts from the Account service
 func (s *AcctServiceImpl) ListAccts(ctx context.Context, request *v1.ListAcctsRequest) (*v1.ListAcctsResponse, error) {
-	accts, err := s.acctStore.ListAccts(ctx)
+	accts, nextPageToken, err := s.acctStore.ListAccts(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 
 	return &v1.ListAcctsResponse{
-		Accts: accts,
+		Accts:         accts,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
 // UpdateAcct updates an account via the Account service
 func (s *AcctServiceImpl) UpdateAcct(ctx context.Context, request *v1.UpdateAcctRequest) (*v1.UpdateAcctResponse, error) {
 	request.GetAcct().Id = request.GetId()
+	if !validBasis(request.GetAcct().GetBasis()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid basis %s", request.GetAcct().GetBasis())
+	}
 
 	if err := s.acctStore.UpdateAcct(ctx, request.GetAcct(), request.GetUpdateMask().GetPaths()); err != nil {
 		return nil, err
 	}
 
-	return &v1.UpdateAcctResponse{}, nil
+	return &v1.UpdateAcctResponse{Version: request.GetAcct().GetVersion()}, nil
 }
 
 // CreateAcct creates a new account via the Account service
@@ -83,6 +113,9 @@ func (s *AcctServiceImpl) CreateAcct(ctx context.Context, request *v1.CreateAcct
 	if request.GetAcct().GetId() != "" {
 		return nil, status.Error(codes.InvalidArgument, "acct id is not expected in POST")
 	}
+	if !validBasis(request.GetAcct().GetBasis()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid basis %s", request.GetAcct().GetBasis())
+	}
 
 	acct, err := s.acctStore.CreateAcct(ctx, request.GetAcct())
 	if err != nil {
@@ -101,3 +134,107 @@ func (s *AcctServiceImpl) DeleteAcct(ctx context.Context, request *v1.DeleteAcct
 	}
 	return &v1.DeleteAcctResponse{}, nil
 }
+
+// BatchCreateAccts creates a set of accounts via the Account service. the accounts are returned in the order
+// given, and any that failed in a partial batch are returned without an id
+func (s *AcctServiceImpl) BatchCreateAccts(ctx context.Context, request *v1.BatchCreateAcctsRequest) (*v1.BatchCreateAcctsResponse, error) {
+	accts := request.GetAccts()
+	errs, err := batch.Run(len(accts), request.GetPartial(),
+		func(i int) error {
+			if accts[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "acct id is not expected in POST")
+			}
+			if !validBasis(accts[i].GetBasis()) {
+				return status.Errorf(codes.InvalidArgument, "invalid basis %s", accts[i].GetBasis())
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchAccts := make([]*storage.Acct, len(idx))
+			for j, i := range idx {
+				batchAccts[j] = accts[i]
+			}
+			_, err := s.acctStore.CreateAccts(ctx, batchAccts)
+			return err
+		},
+		func(i int) error {
+			_, err := s.acctStore.CreateAccts(ctx, accts[i:i+1])
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchCreateAcctsResponse{
+		Accts:  accts,
+		Errors: errs,
+	}, nil
+}
+
+// BatchUpdateAccts updates a set of accounts via the Account service
+func (s *AcctServiceImpl) BatchUpdateAccts(ctx context.Context, request *v1.BatchUpdateAcctsRequest) (*v1.BatchUpdateAcctsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			acct := requests[i].GetAcct()
+			if acct == nil {
+				return status.Error(codes.InvalidArgument, "acct required in update")
+			}
+			if !validBasis(acct.GetBasis()) {
+				return status.Errorf(codes.InvalidArgument, "invalid basis %s", acct.GetBasis())
+			}
+			acct.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			accts := make([]*storage.Acct, len(idx))
+			fieldMasks := make([][]string, len(idx))
+			for j, i := range idx {
+				accts[j] = requests[i].GetAcct()
+				fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
+			}
+			return s.acctStore.UpdateAccts(ctx, accts, fieldMasks)
+		},
+		func(i int) error {
+			return s.acctStore.UpdateAcct(ctx, requests[i].GetAcct(), requests[i].GetUpdateMask().GetPaths())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdateAcctsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetAcct().GetVersion()
+		}),
+	}, nil
+}
+
+// BatchDeleteAccts removes a set of accounts from the Account service
+func (s *AcctServiceImpl) BatchDeleteAccts(ctx context.Context, request *v1.BatchDeleteAcctsRequest) (*v1.BatchDeleteAcctsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			if ids[i] == "" {
+				return status.Error(codes.InvalidArgument, "acct id required in delete")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchIDs := make([]string, len(idx))
+			for j, i := range idx {
+				batchIDs[j] = ids[i]
+			}
+			return s.acctStore.DeleteAccts(ctx, batchIDs)
+		},
+		func(i int) error {
+			return s.acctStore.DeleteAcct(ctx, ids[i])
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchDeleteAcctsResponse{
+		Errors: errs,
+	}, nil
+}
@@ -5,8 +5,13 @@ import (
 	"fmt"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,10 +22,13 @@ import (
 // Store interface used for implementing the Account store
 type Store interface {
 	GetAcct(ctx context.Context, id string) (*storage.Acct, error)
-	ListAccts(ctx context.Context) ([]*storage.Acct, error)
+	ListAccts(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Acct, string, error)
 	UpdateAcct(ctx context.Context, strat *storage.Acct, fieldMask []string) error
 	CreateAcct(ctx context.Context, strat *storage.Acct) (*storage.Acct, error)
 	DeleteAcct(ctx context.Context, id string) error
+	CreateAccts(ctx context.Context, accts []*storage.Acct) ([]*storage.Acct, error)
+	UpdateAccts(ctx context.Context, accts []*storage.Acct, fieldMasks [][]string) error
+	DeleteAccts(ctx context.Context, ids []string) error
 }
 
 // NewStore encapsulates Account database operations
@@ -34,15 +42,32 @@ type storeImpl struct {
 	conn *pg.DB
 }
 
+// acctKeys are the keys accounts are listed in order of
+var acctKeys = []page.Key{{Col: "id"}}
+
+// acctOrderCols are the columns accounts can be listed in order of
+var acctOrderCols = []string{"id", "name", "basis", "ext_ref"}
+
+// acctFields are the fields accounts can be filtered on
+var acctFields = filterPkg.NewFields(&storage.Acct{}, map[string]string{
+	"id":        vxid.PfxMap.Account,
+	"parent_id": vxid.PfxMap.Account,
+})
+
 // GetAcct gets an account from the Account store
 func (s *storeImpl) GetAcct(ctx context.Context, id string) (*storage.Acct, error) {
+	return getAcct(ctx, s.conn, id)
+}
+
+// getAcct gets an account with either a connection or a database transaction
+func getAcct(ctx context.Context, db orm.DB, id string) (*storage.Acct, error) {
 	vid, err := vxid.Decode(id)
 	if err != nil {
 		return nil, err
 	}
 
 	var acct storage.Acct
-	err = s.conn.ModelContext(ctx, &acct).Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &acct).Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "account with id %s not found", id)
@@ -62,39 +87,113 @@ func (s *storeImpl) GetAcct(ctx context.Context, id string) (*storage.Acct, erro
 	return &acct, err
 }
 
-// ListAccts lists an array of accounts from the Account store
-func (s *storeImpl) ListAccts(ctx context.Context) ([]*storage.Acct, error) {
+// ListAccts lists a page of the accounts matching a filter from the Account store, in order of id unless
+// ordered otherwise. a page size of 0 lists every account
+func (s *storeImpl) ListAccts(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Acct, string, error) {
+	keys, err := page.Order(orderBy, acctKeys, acctOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var accts []*storage.Acct
-	err := s.conn.ModelContext(ctx, &accts).Select()
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &accts), filter, acctFields)
 	if err != nil {
-		return nil, fmt.Errorf("listing accounts %w", err)
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter)
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing accounts %w", err)
+	}
+
+	// the next page starts after the last account of this one
+	var nextPageToken string
+	n, more := p.Next(len(accts))
+	accts = accts[:n]
+	if more {
+		nextPageToken = p.Token(acctVals(accts[n-1], keys)...)
 	}
 
 	for _, acct := range accts {
 		acct.Id, err = vxid.Encode(acct.GetId(), vxid.PfxMap.Account)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if acct.GetParentId() != "" {
 			acct.ParentId, err = vxid.Encode(acct.GetParentId(), vxid.PfxMap.Account)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 	}
 
-	return accts, nil
+	return accts, nextPageToken, nil
+}
+
+// acctVals gets the values of the keys accounts are listed in order of from a account, for page tokens
+func acctVals(acct *storage.Acct, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = acct.GetId()
+		case "name":
+			vals[i] = acct.GetName()
+		case "basis":
+			vals[i] = acct.GetBasis()
+		case "ext_ref":
+			vals[i] = acct.GetExtRef()
+		}
+	}
+
+	return vals
 }
 
 // UpdateAcct updates an account via the Account store
 func (s *storeImpl) UpdateAcct(ctx context.Context, acct *storage.Acct, fieldMask []string) error {
+	if err := updateAcct(ctx, s.conn, acct, fieldMask); err != nil {
+		return err
+	}
+	acct.Version++
+
+	return nil
+}
+
+// UpdateAccts updates a set of accounts via the Account store, each with its own field mask. either every
+// account is updated or none are
+func (s *storeImpl) UpdateAccts(ctx context.Context, accts []*storage.Acct, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for i, acct := range accts {
+			if err := updateAcct(ctx, tx, acct, fieldMasks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, acct := range accts {
+		acct.Version++
+	}
+
+	return nil
+}
+
+// updateAcct updates an account with either a connection or a database transaction. the account passed in
+// isn't changed
+func updateAcct(ctx context.Context, db orm.DB, acct *storage.Acct, fieldMask []string) error {
 	var err error
-	tgtAcct := acct
+	tgtAcct := proto.Clone(acct).(*storage.Acct)
 
 	// copy over only the fields passed in from the field mask (if provided)
 	if fieldMask != nil {
 		// get original acct object to update
-		tgtAcct, err = s.GetAcct(ctx, acct.GetId())
+		tgtAcct, err = getAcct(ctx, db, acct.GetId())
 		if err != nil {
 			return err
 		}
@@ -118,10 +217,10 @@ func (s *storeImpl) UpdateAcct(ctx context.Context, acct *storage.Acct, fieldMas
 		}
 	}
 
-	// update account in datastore
-	_, err = s.conn.ModelContext(ctx, tgtAcct).WherePK().Update()
-	if err != nil {
-		return fmt.Errorf("update account %s %w", acct.GetId(), err)
+	// update account in datastore if it's still at the version the caller read
+	tgtAcct.Version = acct.GetVersion() + 1
+	if err = version.Update(ctx, db, tgtAcct, acct.GetVersion(), "account "+acct.GetId()); err != nil {
+		return err
 	}
 
 	return nil
@@ -160,6 +259,44 @@ func (s *storeImpl) CreateAcct(ctx context.Context, acct *storage.Acct) (*storag
 	return acct, nil
 }
 
+// CreateAccts creates a set of accounts via the Account store with a single insert. either every
+// account is created or none are, and the accounts passed in are only changed once they are
+func (s *storeImpl) CreateAccts(ctx context.Context, accts []*storage.Acct) ([]*storage.Acct, error) {
+	if len(accts) == 0 {
+		return accts, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Acct, len(accts))
+	for i, acct := range accts {
+		rows[i] = proto.Clone(acct).(*storage.Acct)
+		if acct.GetParentId() != "" {
+			var err error
+			rows[i].ParentId, err = vxid.Decode(acct.GetParentId())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// insert accts into datastore
+	if _, err := s.conn.ModelContext(ctx, &rows).Insert(); err != nil {
+		return nil, fmt.Errorf("creating accts: %w", err)
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Account)
+		if err != nil {
+			return nil, err
+		}
+		accts[i].Id = id
+		accts[i].Version = row.GetVersion()
+	}
+
+	return accts, nil
+}
+
 // DeleteAcct removes an account from the Account store
 func (s *storeImpl) DeleteAcct(ctx context.Context, id string) error {
 	vid, err := vxid.Decode(id)
@@ -174,3 +311,22 @@ func (s *storeImpl) DeleteAcct(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// DeleteAccts removes a set of accounts from the Account store with a single delete
+func (s *storeImpl) DeleteAccts(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.Acct)(nil)).Where("id IN (?)", pg.In(vids)).Delete(); err != nil {
+		return fmt.Errorf("deleting accts %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	bmkStore "github.com/wolfinger/varangian/bmk/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/benchmark"
+	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/perf"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fieldmask_utils "github.com/mennanov/fieldmask-utils"
+)
+
+// Service interface used for implementing the Benchmark service
+type Service interface {
+	v1.BmkServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Benchmark service
+func NewService(bmkStore bmkStore.Store, builder *benchmark.Builder) *BmkServiceImpl {
+	return &BmkServiceImpl{
+		bmkStore: bmkStore,
+		builder:  builder,
+	}
+}
+
+// BmkServiceImpl data structure for implementing the Benchmark service
+type BmkServiceImpl struct {
+	bmkStore bmkStore.Store
+	builder  *benchmark.Builder
+}
+
+// RegisterServer registers the Benchmark service server
+func (s *BmkServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterBmkServiceServer(server, s)
+}
+
+// RegisterHandler registers the Benchmark service handler
+func (s *BmkServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterBmkServiceHandler(ctx, mux, conn)
+}
+
+// validateBmk checks a benchmark is either a single instrument or a basket of weighted constituents
+func validateBmk(bmk *storage.Bmk) error {
+	if (bmk.GetInstId() == "") == (len(bmk.GetConsts()) == 0) {
+		return status.Error(codes.InvalidArgument, "bmk expects exactly one of inst_id or consts")
+	}
+	for _, c := range bmk.GetConsts() {
+		if c.GetInstId() == "" || c.GetRebalDt() == "" {
+			return status.Error(codes.InvalidArgument, "bmk consts expect an inst_id and rebal_dt")
+		}
+		if c.GetWeight() < 0 {
+			return status.Errorf(codes.InvalidArgument, "bmk const %s has a negative weight", c.GetInstId())
+		}
+	}
+
+	return nil
+}
+
+// GetBmk gets a benchmark from the Benchmark service
+func (s *BmkServiceImpl) GetBmk(ctx context.Context, request *v1.GetBmkRequest) (*v1.GetBmkResponse, error) {
+	bmk, err := s.bmkStore.GetBmk(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetBmkResponse{
+		Bmk: bmk,
+	}, nil
+}
+
+// ListBmks lists an array of benchmarks from the Benchmark service
+func (s *BmkServiceImpl) ListBmks(ctx context.Context, request *v1.ListBmksRequest) (*v1.ListBmksResponse, error) {
+	bmks, nextPageToken, err := s.bmkStore.ListBmks(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListBmksResponse{
+		Bmks:          bmks,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// UpdateBmk updates a benchmark via the Benchmark service
+func (s *BmkServiceImpl) UpdateBmk(ctx context.Context, request *v1.UpdateBmkRequest) (*v1.UpdateBmkResponse, error) {
+	bmk := request.GetBmk()
+	if bmk == nil {
+		return nil, status.Error(codes.InvalidArgument, "bmk required in PATCH")
+	}
+	bmk.Id = request.GetId()
+
+	// with a mask, the benchmark left once the masked fields are updated has to be valid
+	updated := bmk
+	if request.GetUpdateMask() != nil {
+		var err error
+		updated, err = s.maskedBmk(ctx, bmk, request.GetUpdateMask().GetPaths())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := validateBmk(updated); err != nil {
+		return nil, err
+	}
+	if err := s.bmkStore.UpdateBmk(ctx, bmk, request.GetUpdateMask().GetPaths()); err != nil {
+		return nil, err
+	}
+
+	return &v1.UpdateBmkResponse{Version: bmk.GetVersion()}, nil
+}
+
+// maskedBmk gets a benchmark as it would be once the fields in a field mask are copied over to it
+func (s *BmkServiceImpl) maskedBmk(ctx context.Context, bmk *storage.Bmk, fieldMask []string) (*storage.Bmk, error) {
+	tgtBmk, err := s.bmkStore.GetBmk(ctx, bmk.GetId())
+	if err != nil {
+		return nil, err
+	}
+	mask, err := fieldmask_utils.MaskFromPaths(fieldMask, casing.Camel)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid update mask: %v", err)
+	}
+	if err = fieldmask_utils.StructToStruct(mask, bmk, tgtBmk); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid update mask: %v", err)
+	}
+
+	return tgtBmk, nil
+}
+
+// CreateBmk creates a new benchmark via the Benchmark service
+func (s *BmkServiceImpl) CreateBmk(ctx context.Context, request *v1.CreateBmkRequest) (*v1.CreateBmkResponse, error) {
+	if request.GetBmk() == nil {
+		return nil, status.Error(codes.InvalidArgument, "bmk required in POST")
+	}
+	if request.GetBmk().GetId() != "" {
+		return nil, status.Error(codes.InvalidArgument, "bmk id is not expected in POST")
+	}
+	if err := validateBmk(request.GetBmk()); err != nil {
+		return nil, err
+	}
+	bmk, err := s.bmkStore.CreateBmk(ctx, request.GetBmk())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateBmkResponse{
+		Bmk: bmk,
+	}, nil
+}
+
+// DeleteBmk removes a benchmark from the Benchmark service
+func (s *BmkServiceImpl) DeleteBmk(ctx context.Context, request *v1.DeleteBmkRequest) (*v1.DeleteBmkResponse, error) {
+	if err := s.bmkStore.DeleteBmk(ctx, request.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteBmkResponse{}, nil
+}
+
+// GetBmkRets gets the daily return series of a benchmark between two dates
+func (s *BmkServiceImpl) GetBmkRets(ctx context.Context, request *v1.GetBmkRetsRequest) (*v1.GetBmkRetsResponse, error) {
+	if request.GetRptCcyId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reporting currency expected in GET")
+	}
+	start, err := time.Parse(config.APIFormats.DateFmt, request.GetStartDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid start date %s", request.GetStartDt())
+	}
+	end, err := time.Parse(config.APIFormats.DateFmt, request.GetEndDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end date %s", request.GetEndDt())
+	}
+	if end.Before(start) {
+		return nil, status.Error(codes.InvalidArgument, "end date is before the start date")
+	}
+
+	days, err := s.builder.Days(ctx, request.GetId(), start, end, request.GetRptCcyId())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.GetBmkRetsResponse{}
+	rets := make([]float64, len(days))
+	for i, day := range days {
+		rets[i] = day.Ret
+		response.Rets = append(response.Rets, &v1.BmkRet{
+			Dt:  day.Dt.Format(config.APIFormats.DateFmt),
+			Ret: day.Ret,
+		})
+	}
+	response.CumRet = perf.Link(rets)
+
+	return response, nil
+}
+
+// AssignBmk assigns a benchmark to a portfolio or strategy from an effective date via the Benchmark service
+func (s *BmkServiceImpl) AssignBmk(ctx context.Context, request *v1.AssignBmkRequest) (*v1.AssignBmkResponse, error) {
+	bmkAssign := request.GetBmkAssign()
+	if bmkAssign == nil {
+		return nil, status.Error(codes.InvalidArgument, "bmk_assign required in POST")
+	}
+	if bmkAssign.GetId() != "" {
+		return nil, status.Error(codes.InvalidArgument, "bmk_assign id is not expected in POST")
+	}
+	if bmkAssign.GetBmkId() == "" || bmkAssign.GetEffDt() == "" {
+		return nil, status.Error(codes.InvalidArgument, "bmk_assign expects a bmk_id and eff_dt")
+	}
+	if (bmkAssign.GetPortId() == "") == (bmkAssign.GetStratId() == "") {
+		return nil, status.Error(codes.InvalidArgument, "bmk_assign expects exactly one of port_id or strat_id")
+	}
+
+	// make sure the benchmark exists
+	if _, err := s.bmkStore.GetBmk(ctx, bmkAssign.GetBmkId()); err != nil {
+		return nil, err
+	}
+
+	bmkAssign, err := s.bmkStore.AssignBmk(ctx, bmkAssign)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.AssignBmkResponse{
+		BmkAssign: bmkAssign,
+	}, nil
+}
+
+// ListBmkAssigns lists benchmark assignments from the Benchmark service
+func (s *BmkServiceImpl) ListBmkAssigns(ctx context.Context, request *v1.ListBmkAssignsRequest) (*v1.ListBmkAssignsResponse, error) {
+	bmkAssigns, nextPageToken, err := s.bmkStore.ListBmkAssigns(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetPortId(), request.GetStratId(), request.GetDt())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListBmkAssignsResponse{
+		BmkAssigns:    bmkAssigns,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// UnassignBmk removes a benchmark assignment from the Benchmark service
+func (s *BmkServiceImpl) UnassignBmk(ctx context.Context, request *v1.UnassignBmkRequest) (*v1.UnassignBmkResponse, error) {
+	if err := s.bmkStore.UnassignBmk(ctx, request.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &v1.UnassignBmkResponse{}, nil
+}
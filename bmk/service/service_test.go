@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	bmkStore "github.com/wolfinger/varangian/bmk/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// fakeBmkStore keeps a single benchmark, counting the updates made to it. it embeds the interface for the
+// methods updating doesn't use
+type fakeBmkStore struct {
+	bmkStore.Store
+	bmk     *storage.Bmk
+	updates int
+}
+
+func (f *fakeBmkStore) GetBmk(ctx context.Context, id string) (*storage.Bmk, error) {
+	if id != f.bmk.GetId() {
+		return nil, status.Errorf(codes.NotFound, "bmk with id %s not found", id)
+	}
+	return proto.Clone(f.bmk).(*storage.Bmk), nil
+}
+
+func (f *fakeBmkStore) UpdateBmk(ctx context.Context, bmk *storage.Bmk, fieldMask []string) error {
+	f.updates++
+	return nil
+}
+
+func TestUpdateBmkMask(t *testing.T) {
+	store := &fakeBmkStore{bmk: &storage.Bmk{Id: "bmk_a", Name: "60/40", Consts: []*storage.BmkConst{
+		{RebalDt: "2021-01-04", InstId: "inst_a", Weight: 0.6},
+		{RebalDt: "2021-01-04", InstId: "inst_b", Weight: 0.4},
+	}}}
+	s := NewService(store, nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		bmk   *storage.Bmk
+		paths []string
+		code  codes.Code
+	}{
+		{"name", &storage.Bmk{Name: "balanced"}, []string{"name"}, codes.OK},
+		{"inst and consts", &storage.Bmk{InstId: "inst_c"}, []string{"inst_id"}, codes.InvalidArgument},
+		{"inst for consts", &storage.Bmk{InstId: "inst_c"}, []string{"inst_id", "consts"}, codes.OK},
+		{"negative weight", &storage.Bmk{Consts: []*storage.BmkConst{{RebalDt: "2021-01-04", InstId: "inst_a", Weight: -0.1}}},
+			[]string{"consts"}, codes.InvalidArgument},
+		{"const without a rebal date", &storage.Bmk{Consts: []*storage.BmkConst{{InstId: "inst_a", Weight: 1}}},
+			[]string{"consts"}, codes.InvalidArgument},
+		{"no inst or consts", &storage.Bmk{}, []string{"consts"}, codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		updates := store.updates
+		_, err := s.UpdateBmk(ctx, &v1.UpdateBmkRequest{Id: "bmk_a", Bmk: test.bmk, UpdateMask: &fieldmaskpb.FieldMask{Paths: test.paths}})
+		if status.Code(err) != test.code {
+			t.Errorf("%s got: %v, want: %v", test.name, err, test.code)
+		}
+		if updated := store.updates > updates; updated != (test.code == codes.OK) {
+			t.Errorf("%s updated the store: %t, want: %t", test.name, updated, test.code == codes.OK)
+		}
+	}
+
+	// without a mask the whole benchmark is replaced, so it's validated as is
+	if _, err := s.UpdateBmk(ctx, &v1.UpdateBmkRequest{Id: "bmk_a", Bmk: &storage.Bmk{Name: "balanced"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("replacing with no inst or consts got: %v, want: %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,438 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fieldmask_utils "github.com/mennanov/fieldmask-utils"
+)
+
+// Store interface used for implementing the Benchmark store
+type Store interface {
+	GetBmk(ctx context.Context, id string) (*storage.Bmk, error)
+	ListBmks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Bmk, string, error)
+	UpdateBmk(ctx context.Context, bmk *storage.Bmk, fieldMask []string) error
+	CreateBmk(ctx context.Context, bmk *storage.Bmk) (*storage.Bmk, error)
+	DeleteBmk(ctx context.Context, id string) error
+	AssignBmk(ctx context.Context, bmkAssign *storage.BmkAssign) (*storage.BmkAssign, error)
+	ListBmkAssigns(ctx context.Context, pageSize int32, pageToken string, portID string, stratID string, dt string) ([]*storage.BmkAssign, string, error)
+	UnassignBmk(ctx context.Context, id string) error
+}
+
+// NewStore encapsulates Benchmark database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn *pg.DB
+}
+
+var (
+	// bmkKeys are the keys benchmarks are listed in order of
+	bmkKeys = []page.Key{{Col: "id"}}
+
+	// bmkAssignKeys are the keys benchmark assignments are listed in order of
+	bmkAssignKeys = []page.Key{{Col: "port_id"}, {Col: "strat_id"}, {Col: "eff_dt"}, {Col: "id"}}
+
+	// bmkAssignOnKeys are the keys the benchmark assignments in effect on a date are listed in order of, as
+	// there's one for each portfolio and strategy
+	bmkAssignOnKeys = []page.Key{{Col: "port_id"}, {Col: "strat_id"}}
+)
+
+// encodeConsts converts the vids of a set of benchmark constituents to vxids
+func encodeConsts(consts []*storage.BmkConst) error {
+	var err error
+	for _, c := range consts {
+		c.BmkId, err = vxid.Encode(c.GetBmkId(), vxid.PfxMap.Benchmark)
+		if err != nil {
+			return err
+		}
+		c.InstId, err = vxid.Encode(c.GetInstId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertConsts inserts a benchmark's constituents, converting vxids to vids on the way in
+func insertConsts(ctx context.Context, tx *pg.Tx, bmkVid string, consts []*storage.BmkConst) error {
+	if len(consts) == 0 {
+		return nil
+	}
+
+	vConsts := make([]*storage.BmkConst, len(consts))
+	for i, c := range consts {
+		instVid, err := vxid.Decode(c.GetInstId())
+		if err != nil {
+			return err
+		}
+		vConsts[i] = &storage.BmkConst{
+			BmkId:   bmkVid,
+			RebalDt: c.GetRebalDt(),
+			InstId:  instVid,
+			Weight:  c.GetWeight(),
+		}
+	}
+
+	if _, err := tx.ModelContext(ctx, &vConsts).Insert(); err != nil {
+		return fmt.Errorf("inserting bmk consts: %w", err)
+	}
+
+	return nil
+}
+
+// GetBmk gets a benchmark and its constituents from the Benchmark store
+func (s *storeImpl) GetBmk(ctx context.Context, id string) (*storage.Bmk, error) {
+	// convert vxids to vids
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var bmk storage.Bmk
+	err = s.conn.ModelContext(ctx, &bmk).Where("id = ?", vid).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "bmk with id %s not found", id)
+		}
+		return nil, err
+	}
+	bmk.Id = id
+
+	err = s.conn.ModelContext(ctx, &bmk.Consts).ColumnExpr("*, rebal_dt::date").Where("bmk_id = ?", vid).OrderExpr("bmk_const.rebal_dt, inst_id").Select()
+	if err != nil {
+		return nil, fmt.Errorf("listing bmk consts: %w", err)
+	}
+
+	// convert vids to vxids
+	if bmk.GetInstId() != "" {
+		bmk.InstId, err = vxid.Encode(bmk.GetInstId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = encodeConsts(bmk.GetConsts()); err != nil {
+		return nil, err
+	}
+
+	return &bmk, nil
+}
+
+// ListBmks lists a page of benchmarks and their constituents from the Benchmark store in order of id. a page
+// size of 0 lists every benchmark
+func (s *storeImpl) ListBmks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Bmk, string, error) {
+	var bmks []*storage.Bmk
+	p := page.NewPager(pageSize, bmkKeys, "")
+	q, err := p.Apply(s.conn.ModelContext(ctx, &bmks), pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing bmks: %w", err)
+	}
+
+	// the next page starts after the last benchmark of this one
+	var nextPageToken string
+	n, more := p.Next(len(bmks))
+	bmks = bmks[:n]
+	if more {
+		nextPageToken = p.Token(bmks[n-1].GetId())
+	}
+	if len(bmks) == 0 {
+		return bmks, "", nil
+	}
+
+	// get the constituents of the page's benchmarks in one pass
+	vids := make([]string, len(bmks))
+	for i, bmk := range bmks {
+		vids[i] = bmk.GetId()
+	}
+	var consts []*storage.BmkConst
+	err = s.conn.ModelContext(ctx, &consts).ColumnExpr("*, rebal_dt::date").Where("bmk_id IN (?)", pg.In(vids)).
+		OrderExpr("bmk_id, bmk_const.rebal_dt, inst_id").Select()
+	if err != nil {
+		return nil, "", fmt.Errorf("listing bmk consts: %w", err)
+	}
+	constMap := make(map[string][]*storage.BmkConst)
+	for _, c := range consts {
+		constMap[c.GetBmkId()] = append(constMap[c.GetBmkId()], c)
+	}
+
+	for _, bmk := range bmks {
+		bmk.Consts = constMap[bmk.GetId()]
+
+		// convert vids to vxids
+		bmk.Id, err = vxid.Encode(bmk.GetId(), vxid.PfxMap.Benchmark)
+		if err != nil {
+			return nil, "", err
+		}
+		if bmk.GetInstId() != "" {
+			bmk.InstId, err = vxid.Encode(bmk.GetInstId(), vxid.PfxMap.Instrument)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		if err = encodeConsts(bmk.GetConsts()); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return bmks, nextPageToken, nil
+}
+
+// UpdateBmk updates a benchmark via the Benchmark store. constituents passed in replace all existing
+// constituents
+func (s *storeImpl) UpdateBmk(ctx context.Context, bmk *storage.Bmk, fieldMask []string) error {
+	var err error
+	tgtBmk := bmk
+
+	// copy over only the fields passed in from the field mask (if provided)
+	if fieldMask != nil {
+
+		// get original bmk object to update
+		tgtBmk, err = s.GetBmk(ctx, bmk.GetId())
+		if err != nil {
+			return err
+		}
+
+		mask, err := fieldmask_utils.MaskFromPaths(fieldMask, casing.Camel)
+		if err != nil {
+			return err
+		}
+		fieldmask_utils.StructToStruct(mask, bmk, tgtBmk)
+	}
+
+	// convert vxids to vids
+	vid, err := vxid.Decode(tgtBmk.GetId())
+	if err != nil {
+		return err
+	}
+	instVid, err := vxid.Decode(tgtBmk.GetInstId())
+	if err != nil {
+		return err
+	}
+	vBmk := &storage.Bmk{
+		Id:      vid,
+		Name:    tgtBmk.GetName(),
+		InstId:  instVid,
+		Version: bmk.GetVersion() + 1,
+	}
+
+	// update bmk, if it's still at the version the caller read, and replace its constituents in datastore
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := version.Update(ctx, tx, vBmk, bmk.GetVersion(), "bmk "+bmk.GetId()); err != nil {
+			return err
+		}
+		if _, err := tx.ModelContext(ctx, (*storage.BmkConst)(nil)).Where("bmk_id = ?", vid).Delete(); err != nil {
+			return fmt.Errorf("deleting bmk consts for %s: %w", bmk.GetId(), err)
+		}
+		return insertConsts(ctx, tx, vid, tgtBmk.GetConsts())
+	})
+	if err != nil {
+		return err
+	}
+	bmk.Version = vBmk.GetVersion()
+
+	return nil
+}
+
+// CreateBmk creates a new benchmark and its constituents via the Benchmark store
+func (s *storeImpl) CreateBmk(ctx context.Context, bmk *storage.Bmk) (*storage.Bmk, error) {
+	// convert vxids to vids
+	instVid, err := vxid.Decode(bmk.GetInstId())
+	if err != nil {
+		return nil, err
+	}
+	vBmk := &storage.Bmk{
+		Name:   bmk.GetName(),
+		InstId: instVid,
+	}
+
+	// insert benchmark and its constituents into datastore
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if _, err := tx.ModelContext(ctx, vBmk).Insert(); err != nil {
+			return err
+		}
+		return insertConsts(ctx, tx, vBmk.GetId(), bmk.GetConsts())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// convert vids to vxids
+	bmk.Id, err = vxid.Encode(vBmk.GetId(), vxid.PfxMap.Benchmark)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range bmk.GetConsts() {
+		c.BmkId = bmk.GetId()
+	}
+
+	return bmk, nil
+}
+
+// DeleteBmk removes a benchmark, its constituents, and its assignments from the Benchmark store
+func (s *storeImpl) DeleteBmk(ctx context.Context, id string) error {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if _, err := tx.ModelContext(ctx, (*storage.BmkAssign)(nil)).Where("bmk_id = ?", vid).Delete(); err != nil {
+			return fmt.Errorf("deleting bmk assigns for %s: %w", id, err)
+		}
+		if _, err := tx.ModelContext(ctx, (*storage.BmkConst)(nil)).Where("bmk_id = ?", vid).Delete(); err != nil {
+			return fmt.Errorf("deleting bmk consts for %s: %w", id, err)
+		}
+		if _, err := tx.ModelContext(ctx, (*storage.Bmk)(nil)).Where("id = ?", vid).Delete(); err != nil {
+			return fmt.Errorf("deleting bmk %s: %w", id, err)
+		}
+		return nil
+	})
+}
+
+// AssignBmk assigns a benchmark to a portfolio or strategy from an effective date via the Benchmark store
+func (s *storeImpl) AssignBmk(ctx context.Context, bmkAssign *storage.BmkAssign) (*storage.BmkAssign, error) {
+	var err error
+
+	// save off vxids before converting them to vids to save some cycles
+	var xBmkAssign storage.BmkAssign
+	xBmkAssign.BmkId = bmkAssign.GetBmkId()
+	xBmkAssign.PortId = bmkAssign.GetPortId()
+	xBmkAssign.StratId = bmkAssign.GetStratId()
+
+	// convert vxids to vids
+	bmkAssign.BmkId, err = vxid.Decode(bmkAssign.GetBmkId())
+	if err != nil {
+		return nil, err
+	}
+	bmkAssign.PortId, err = vxid.Decode(bmkAssign.GetPortId())
+	if err != nil {
+		return nil, err
+	}
+	bmkAssign.StratId, err = vxid.Decode(bmkAssign.GetStratId())
+	if err != nil {
+		return nil, err
+	}
+
+	// insert assignment into datastore
+	_, err = s.conn.ModelContext(ctx, bmkAssign).Insert()
+	if err != nil {
+		return nil, fmt.Errorf("assigning bmk %s: %w", xBmkAssign.GetBmkId(), err)
+	}
+
+	// convert vids to vxids
+	bmkAssign.Id, err = vxid.Encode(bmkAssign.GetId(), vxid.PfxMap.BmkAssign)
+	if err != nil {
+		return nil, err
+	}
+	bmkAssign.BmkId = xBmkAssign.GetBmkId()
+	bmkAssign.PortId = xBmkAssign.GetPortId()
+	bmkAssign.StratId = xBmkAssign.GetStratId()
+
+	return bmkAssign, nil
+}
+
+// ListBmkAssigns lists a page of benchmark assignments from the Benchmark store in order of portfolio and
+// strategy, optionally for a portfolio or strategy. if a date is passed in, only the assignments in effect on
+// that date are listed. a page size of 0 lists every assignment
+func (s *storeImpl) ListBmkAssigns(ctx context.Context, pageSize int32, pageToken string, portID string, stratID string, dt string) ([]*storage.BmkAssign, string, error) {
+	var bmkAssigns []*storage.BmkAssign
+
+	q := s.conn.ModelContext(ctx, &bmkAssigns).ColumnExpr("*, eff_dt::date")
+	if portID != "" {
+		vid, err := vxid.Decode(portID)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("port_id = ?", vid)
+	}
+	if stratID != "" {
+		vid, err := vxid.Decode(stratID)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("strat_id = ?", vid)
+	}
+
+	// the assignment in effect is the latest one on or before the date, for each portfolio and strategy
+	keys := bmkAssignKeys
+	if dt != "" {
+		keys = bmkAssignOnKeys
+		q.DistinctOn("port_id, strat_id").Where("eff_dt <= ?", dt)
+	}
+	p := page.NewPager(pageSize, keys, page.Query(portID, stratID, dt)).Dates("eff_dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if dt != "" {
+		q.OrderExpr("bmk_assign.eff_dt DESC")
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing bmk assigns: %w", err)
+	}
+
+	// the next page starts after the last assignment of this one
+	var nextPageToken string
+	n, more := p.Next(len(bmkAssigns))
+	bmkAssigns = bmkAssigns[:n]
+	if more {
+		last := bmkAssigns[n-1]
+		vals := []string{last.GetPortId(), last.GetStratId(), last.GetEffDt(), last.GetId()}
+		nextPageToken = p.Token(vals[:len(keys)]...)
+	}
+
+	for _, bmkAssign := range bmkAssigns {
+		// convert vids to vxids
+		bmkAssign.Id, err = vxid.Encode(bmkAssign.GetId(), vxid.PfxMap.BmkAssign)
+		if err != nil {
+			return nil, "", err
+		}
+		bmkAssign.BmkId, err = vxid.Encode(bmkAssign.GetBmkId(), vxid.PfxMap.Benchmark)
+		if err != nil {
+			return nil, "", err
+		}
+		bmkAssign.PortId, err = vxid.Encode(bmkAssign.GetPortId(), vxid.PfxMap.Portfolio)
+		if err != nil {
+			return nil, "", err
+		}
+		bmkAssign.StratId, err = vxid.Encode(bmkAssign.GetStratId(), vxid.PfxMap.Strategy)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return bmkAssigns, nextPageToken, nil
+}
+
+// UnassignBmk removes a benchmark assignment from the Benchmark store
+func (s *storeImpl) UnassignBmk(ctx context.Context, id string) error {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.BmkAssign)(nil)).Where("id = ?", vid).Delete(); err != nil {
+		return fmt.Errorf("deleting bmk assign %s: %w", id, err)
+	}
+
+	return nil
+}
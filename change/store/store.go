@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/vxid"
+)
+
+const (
+	// Channel is the postgres channel listeners are notified on when changes are committed
+	Channel = "changes"
+)
+
+// the resources changes are recorded for
+const (
+	Txn    = "txn"
+	LotBal = "lot_bal"
+)
+
+// the operations a change can be
+const (
+	Create  = "create"
+	Update  = "update"
+	Delete  = "delete"
+	Process = "process"
+)
+
+// prefixes of the ids changes refer to, by resource
+var refPfxs = map[string]string{
+	Txn:    vxid.PfxMap.Transaction,
+	LotBal: vxid.PfxMap.Lot,
+}
+
+// Store interface used for implementing the Change store
+type Store interface {
+	ListChanges(ctx context.Context, resource string, after int64, limit int) ([]*storage.Change, error)
+	FirstChange(ctx context.Context) (int64, error)
+	LastChange(ctx context.Context) (int64, error)
+	Listen(ctx context.Context, fn func()) error
+	PurgeChanges(ctx context.Context, retention time.Duration) (int, error)
+}
+
+// NewStore encapsulates Change database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn *pg.DB
+}
+
+// New builds a change to a resource, referring to what changed by its vid and, for lot balances, date
+func New(resource string, op string, refID string, refDt string) *storage.Change {
+	return &storage.Change{
+		Resource: resource,
+		Op:       op,
+		RefId:    refID,
+		RefDt:    refDt,
+	}
+}
+
+// Record records changes with the database transaction that made them. listeners are notified once it's
+// committed, so changes are only seen if it goes through
+func Record(ctx context.Context, tx *pg.Tx, changes ...*storage.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// changes are recorded one database transaction at a time, so they're committed in the order of their
+	// ids and a watcher that's seen a change has seen every change before it
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(?))", Channel); err != nil {
+		return fmt.Errorf("locking %s: %w", Channel, err)
+	}
+	if _, err := tx.ModelContext(ctx, &changes).Value("created_at", "now()").Insert(); err != nil {
+		return fmt.Errorf("recording %s %s change: %w", changes[0].GetResource(), changes[0].GetOp(), err)
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_notify(?, '')", Channel); err != nil {
+		return fmt.Errorf("notifying %s listeners: %w", Channel, err)
+	}
+
+	return nil
+}
+
+// ListChanges lists up to limit changes to a resource made after a change, in the order they were made
+func (s *storeImpl) ListChanges(ctx context.Context, resource string, after int64, limit int) ([]*storage.Change, error) {
+	var changes []*storage.Change
+	err := s.conn.ModelContext(ctx, &changes).
+		ColumnExpr("id, resource, op, ref_id, ref_dt::text AS ref_dt, created_at").
+		Where("resource = ?", resource).
+		Where("id > ?", after).
+		Order("id").
+		Limit(limit).
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("listing %s changes: %w", resource, err)
+	}
+
+	// convert vids to vxids
+	for _, change := range changes {
+		change.RefId, err = vxid.Encode(change.GetRefId(), refPfxs[resource])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// FirstChange gets the id of the oldest change kept, or 0 if there are none
+func (s *storeImpl) FirstChange(ctx context.Context) (int64, error) {
+	var id int64
+	err := s.conn.ModelContext(ctx, (*storage.Change)(nil)).ColumnExpr("coalesce(min(id), 0)").Select(&id)
+	if err != nil {
+		return 0, fmt.Errorf("getting first change: %w", err)
+	}
+
+	return id, nil
+}
+
+// LastChange gets the id of the latest change, or 0 if there have never been any. once every change has
+// been purged, it's the last id handed out
+func (s *storeImpl) LastChange(ctx context.Context) (int64, error) {
+	var id int64
+	err := s.conn.ModelContext(ctx, (*storage.Change)(nil)).
+		ColumnExpr("coalesce(max(id), pg_sequence_last_value(pg_get_serial_sequence('changes', 'id')), 0)").
+		Select(&id)
+	if err != nil {
+		return 0, fmt.Errorf("getting last change: %w", err)
+	}
+
+	return id, nil
+}
+
+// Listen calls fn each time changes are committed, until the context is done
+func (s *storeImpl) Listen(ctx context.Context, fn func()) error {
+	ln := s.conn.Listen(ctx, Channel)
+	defer ln.Close()
+
+	ch := ln.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("listening on %s: listener closed", Channel)
+			}
+			fn()
+		}
+	}
+}
+
+// PurgeChanges removes the changes that have outlived the retention window, returning how many were removed
+func (s *storeImpl) PurgeChanges(ctx context.Context, retention time.Duration) (int, error) {
+	res, err := s.conn.ModelContext(ctx, (*storage.Change)(nil)).
+		Where("created_at < now() - ? * interval '1 second'", retention.Seconds()).
+		Delete()
+	if err != nil {
+		return 0, fmt.Errorf("purging changes: %w", err)
+	}
+
+	return res.RowsAffected(), nil
+}
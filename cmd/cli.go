@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	acctStore "github.com/wolfinger/varangian/acct/store"
+	changeStore "github.com/wolfinger/varangian/change/store"
+	exportService "github.com/wolfinger/varangian/export/service"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	glStore "github.com/wolfinger/varangian/gl/store"
+	instStore "github.com/wolfinger/varangian/inst/store"
+	"github.com/wolfinger/varangian/internal/feed"
+	"github.com/wolfinger/varangian/internal/fix"
+	"github.com/wolfinger/varangian/internal/guard"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	reconService "github.com/wolfinger/varangian/recon/service"
+	reconStore "github.com/wolfinger/varangian/recon/store"
+	txnService "github.com/wolfinger/varangian/txn/service"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc/metadata"
+)
+
+const cliUsage = `usage: varangian <command> [flags]
+
+commands:
+  import txns    import a csv, ofx/qfx, FIX log, or SWIFT MT536 file of txns
+  load swift     load custodian positions and cash from a SWIFT MT535/MT950 file
+  export         export lots, lot_bals, or txns as csv or parquet
+  fix accept     accept FIX drop copy sessions, importing their executions
+`
+
+// runCommand runs a command line command against the database instead of starting the server
+func runCommand(conn *pg.DB, args []string) error {
+	if len(args) >= 2 && args[0] == "import" && args[1] == "txns" {
+		return importTxns(conn, args[2:])
+	}
+	if len(args) >= 2 && args[0] == "load" && args[1] == "swift" {
+		return loadSwift(conn, args[2:])
+	}
+	if len(args) >= 2 && args[0] == "export" {
+		return exportData(conn, args[1], args[2:])
+	}
+	if len(args) >= 2 && args[0] == "fix" && args[1] == "accept" {
+		return acceptFIX(conn, args[2:])
+	}
+
+	fmt.Fprint(os.Stderr, cliUsage)
+	return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
+
+// newTxnService creates a Transaction service for command line use
+func newTxnService(conn *pg.DB) *txnService.TxnServiceImpl {
+	lockStore := lockStore.NewStore(conn)
+	return txnService.NewService(
+		txnStore.NewStore(conn),
+		lotStore.NewStore(conn),
+		glStore.NewStore(conn),
+		acctStore.NewStore(conn),
+		instStore.NewStore(conn),
+		guard.NewGuard(lockStore, lockOverrideUsers()),
+		feed.NewFeed(changeStore.NewStore(conn), changeRetention()),
+	)
+}
+
+// cliContext creates a context carrying the caller and lock override reason the services expect in
+// incoming grpc metadata
+func cliContext(user string, override string) context.Context {
+	md := metadata.MD{}
+	if user != "" {
+		md.Set(guard.UserKey, user)
+	}
+	if override != "" {
+		md.Set(guard.OverrideKey, override)
+	}
+
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+// importTxns imports a csv, ofx/qfx, FIX log, or SWIFT file of txns, printing any problems found by line
+func importTxns(conn *pg.DB, args []string) error {
+	fs := flag.NewFlagSet("import txns", flag.ContinueOnError)
+	format := fs.String("format", "", "file format, csv, ofx, fix, or swift (default: from the file extension)")
+	mapFlag := fs.String("map", "", "csv column to txn field mapping (e.g., \"Trade Date=txn_dt,Symbol=ticker\")")
+	dryRun := fs.Bool("dry-run", false, "validate the file without importing it")
+	user := fs.String("user", os.Getenv("USER"), "user making the import")
+	override := fs.String("override", "", "reason for overriding lock dates")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: varangian import txns [flags] <file.csv|file.ofx|file.qfx|file.fix|file.fin>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one file")
+	}
+	if *format == "" {
+		*format = importFormat(fs.Arg(0))
+	}
+
+	mapping, err := parseMapping(*mapFlag)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	request := &v1.ImportTxnsRequest{
+		Mapping: mapping,
+		DryRun:  *dryRun,
+	}
+	switch *format {
+	case "csv":
+		request.Csv = string(data)
+	case "ofx":
+		request.Ofx = string(data)
+	case "fix":
+		request.Fix = string(data)
+	case "swift":
+		request.Swift = string(data)
+	default:
+		return fmt.Errorf("unknown format %q, expected csv, ofx, fix, or swift", *format)
+	}
+
+	response, err := newTxnService(conn).ImportTxns(cliContext(*user, *override), request)
+	if err != nil {
+		return err
+	}
+
+	return reportImport(response)
+}
+
+// reportImport prints the outcome of an import, returning an error if any row had a problem
+func reportImport(response *v1.ImportTxnsResponse) error {
+	for _, e := range response.GetErrors() {
+		if e.GetField() == "" {
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", e.GetLine(), e.GetMsg())
+		} else {
+			fmt.Fprintf(os.Stderr, "line %d: %s: %s\n", e.GetLine(), e.GetField(), e.GetMsg())
+		}
+	}
+	if len(response.GetErrors()) > 0 {
+		return fmt.Errorf("%d problems found, nothing imported", len(response.GetErrors()))
+	}
+
+	if response.GetCommitted() {
+		fmt.Printf("imported %d txns\n", len(response.GetTxns()))
+	} else {
+		fmt.Printf("%d txns are valid (dry run, nothing imported)\n", len(response.GetTxns()))
+	}
+	if response.GetSkipped() > 0 {
+		fmt.Printf("skipped %d txns already imported\n", response.GetSkipped())
+	}
+
+	return nil
+}
+
+// loadSwift loads a custodian's SWIFT statements of holdings and cash statements for reconciliation,
+// printing any problems found by line
+func loadSwift(conn *pg.DB, args []string) error {
+	fs := flag.NewFlagSet("load swift", flag.ContinueOnError)
+	src := fs.String("src", "", "custodian the statements are from")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: varangian load swift [flags] <file.fin>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one file")
+	}
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	service := reconService.NewService(
+		reconStore.NewStore(conn),
+		acctStore.NewStore(conn),
+		instStore.NewStore(conn),
+		lotStore.NewStore(conn),
+		txnStore.NewStore(conn),
+	)
+	response, err := service.LoadSwiftStmts(context.Background(), &v1.LoadSwiftStmtsRequest{
+		Swift: string(data),
+		Src:   *src,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range response.GetErrors() {
+		fmt.Fprintf(os.Stderr, "line %d: %s: %s\n", e.GetLine(), e.GetField(), e.GetMsg())
+	}
+	if len(response.GetErrors()) > 0 {
+		return fmt.Errorf("%d problems found, nothing loaded", len(response.GetErrors()))
+	}
+	fmt.Printf("loaded %d positions and %d cash txns\n", len(response.GetCustPoss()), len(response.GetCustCashTxns()))
+	if response.GetUnmapped() > 0 {
+		fmt.Printf("%d couldn't be mapped to accounts or instruments\n", response.GetUnmapped())
+	}
+
+	return nil
+}
+
+// exportData exports lots, lot balances, or txns to a file (or stdout) as csv or parquet
+func exportData(conn *pg.DB, resource string, args []string) error {
+	fs := flag.NewFlagSet("export "+resource, flag.ContinueOnError)
+	format := fs.String("format", "", "file format, csv or parquet (default: from the output file extension, else csv)")
+	filter := fs.String("filter", "", "filter, as for listing (e.g., 'acct_id = \"acct_...\"')")
+	startDt := fs.String("start", "", "first balance date (lot_bals only)")
+	endDt := fs.String("end", "", "last balance date (lot_bals only, default: the start date)")
+	out := fs.String("o", "", "output file (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: varangian export <lots|lot_bals|txns> [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" && strings.EqualFold(filepath.Ext(*out), ".parquet") {
+		*format = "parquet"
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	service := exportService.NewService(lotStore.NewStore(conn), txnStore.NewStore(conn))
+	ctx := context.Background()
+	var err error
+	switch resource {
+	case "lots":
+		err = service.WriteLots(ctx, &v1.ExportLotsRequest{Format: *format, Filter: *filter}, bw)
+	case "lot_bals":
+		if *endDt == "" {
+			*endDt = *startDt
+		}
+		err = service.WriteLotBals(ctx, &v1.ExportLotBalsRequest{Format: *format, StartDt: *startDt, EndDt: *endDt, Filter: *filter}, bw)
+	case "txns":
+		err = service.WriteTxns(ctx, &v1.ExportTxnsRequest{Format: *format, Filter: *filter}, bw)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown export %q, expected lots, lot_bals, or txns", resource)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// importFormat infers the format of an import file from its extension, treating qfx as ofx and fin (the
+// usual extension for MT messages) as swift
+func importFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ofx", ".qfx":
+		return "ofx"
+	case ".fix":
+		return "fix"
+	case ".fin", ".swift":
+		return "swift"
+	default:
+		return "csv"
+	}
+}
+
+// acceptFIX accepts FIX sessions, importing the fills of every ExecutionReport received as they come.
+// a fill that can't be imported is rejected back to the initiator
+func acceptFIX(conn *pg.DB, args []string) error {
+	fs := flag.NewFlagSet("fix accept", flag.ContinueOnError)
+	addr := fs.String("listen", "127.0.0.1:9878", "address to accept FIX sessions on")
+	compID := fs.String("comp-id", "VARANGIAN", "SenderCompID of the acceptor")
+	initiatorID := fs.String("initiator", "", "SenderCompID of the initiator allowed to log on (required)")
+	user := fs.String("user", os.Getenv("USER"), "user making the imports")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: varangian fix accept [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *initiatorID == "" {
+		fs.Usage()
+		return fmt.Errorf("-initiator is required")
+	}
+
+	svc := newTxnService(conn)
+	acceptor := &fix.Acceptor{
+		CompID:      *compID,
+		InitiatorID: *initiatorID,
+		Handler: func(m *fix.Message) error {
+			if m.Type() != fix.MsgType.ExecutionReport {
+				return fmt.Errorf("unsupported message type %s", m.Type())
+			}
+			response, err := svc.ImportTxns(cliContext(*user, ""), &v1.ImportTxnsRequest{Fix: string(m.Bytes())})
+			if err != nil {
+				log.Printf("importing execution %s: %v", m.Get(fix.Tag.ExecID), err)
+				return err
+			}
+			if len(response.GetErrors()) > 0 {
+				e := response.GetErrors()[0]
+				log.Printf("importing execution %s: %s: %s", m.Get(fix.Tag.ExecID), e.GetField(), e.GetMsg())
+				return fmt.Errorf("%s: %s", e.GetField(), e.GetMsg())
+			}
+			for _, txn := range response.GetTxns() {
+				log.Printf("imported execution %s as txn %s", txn.GetExtId(), txn.GetId())
+			}
+			return nil
+		},
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("accepting FIX sessions on %s", *addr)
+
+	return acceptor.Serve(l)
+}
+
+// parseMapping parses a comma separated list of column=field pairs
+func parseMapping(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expected column=field", pair)
+		}
+		mapping[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return mapping, nil
+}
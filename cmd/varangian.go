@@ -8,20 +8,48 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/go-pg/pg/v10"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	acctService "github.com/wolfinger/varangian/acct/service"
 	acctStore "github.com/wolfinger/varangian/acct/store"
+	bmkService "github.com/wolfinger/varangian/bmk/service"
+	bmkStore "github.com/wolfinger/varangian/bmk/store"
+	changeStore "github.com/wolfinger/varangian/change/store"
+	exportService "github.com/wolfinger/varangian/export/service"
+	fxService "github.com/wolfinger/varangian/fx/service"
+	fxStore "github.com/wolfinger/varangian/fx/store"
+	glService "github.com/wolfinger/varangian/gl/service"
+	glStore "github.com/wolfinger/varangian/gl/store"
+	idemStore "github.com/wolfinger/varangian/idem/store"
 	instService "github.com/wolfinger/varangian/inst/service"
 	instStore "github.com/wolfinger/varangian/inst/store"
+	"github.com/wolfinger/varangian/internal/benchmark"
+	"github.com/wolfinger/varangian/internal/feed"
+	"github.com/wolfinger/varangian/internal/guard"
+	"github.com/wolfinger/varangian/internal/idem"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/sse"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lockService "github.com/wolfinger/varangian/lock/service"
+	lockStore "github.com/wolfinger/varangian/lock/store"
 	lotService "github.com/wolfinger/varangian/lot/service"
 	lotStore "github.com/wolfinger/varangian/lot/store"
 	orgService "github.com/wolfinger/varangian/org/service"
 	orgStore "github.com/wolfinger/varangian/org/store"
+	perfService "github.com/wolfinger/varangian/perf/service"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	portService "github.com/wolfinger/varangian/port/service"
 	portStore "github.com/wolfinger/varangian/port/store"
+	posService "github.com/wolfinger/varangian/pos/service"
+	priceService "github.com/wolfinger/varangian/price/service"
+	priceStore "github.com/wolfinger/varangian/price/store"
+	reconService "github.com/wolfinger/varangian/recon/service"
+	reconStore "github.com/wolfinger/varangian/recon/store"
 	stratService "github.com/wolfinger/varangian/strat/service"
 	stratStore "github.com/wolfinger/varangian/strat/store"
 	txnService "github.com/wolfinger/varangian/txn/service"
@@ -58,6 +86,72 @@ func dbConn() (*pg.Options, error) {
 	return dbConn, nil
 }
 
+// set default base currency used to triangulate fx rates
+func baseCcyID() string {
+	return os.Getenv("BASE_CCY_ID")
+}
+
+// set users allowed to override lock dates (comma separated)
+func lockOverrideUsers() []string {
+	return strings.Split(os.Getenv("LOCK_OVERRIDE_USERS"), ",")
+}
+
+// set the key page tokens are signed with, shared by every server behind a load balancer
+func pageTokenKey() []byte {
+	return []byte(os.Getenv("PAGE_TOKEN_KEY"))
+}
+
+// set how long responses to requests with an idempotency key are kept for (e.g. 24h)
+func idemRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_RETENTION"))
+	if err != nil {
+		return idem.DefaultRetention
+	}
+	return retention
+}
+
+// set how long a request with an idempotency key has to finish before a retry can take the key over (e.g. 5m)
+func idemLease() time.Duration {
+	lease, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_LEASE"))
+	if err != nil {
+		return idem.DefaultLease
+	}
+	return lease
+}
+
+// set how long the changes watchers can resume from are kept for (e.g. 168h)
+func changeRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("CHANGE_RETENTION"))
+	if err != nil {
+		return feed.DefaultRetention
+	}
+	return retention
+}
+
+// set whether a trusted proxy in front of the gateway sets the caller header (e.g. true)
+func trustUserHeader() bool {
+	trust, _ := strconv.ParseBool(os.Getenv("TRUST_USER_HEADER"))
+	return trust
+}
+
+// forward the lock override, idempotency key, and last event id headers on to the grpc services. the caller
+// header is only forwarded when a trusted proxy sets it, and never from a Grpc-Metadata- header
+func headerMatcher(trustUser bool) runtime.HeaderMatcherFunc {
+	return func(key string) (string, bool) {
+		lower := strings.ToLower(key)
+		switch lower {
+		case guard.UserKey:
+			return lower, trustUser
+		case guard.OverrideKey, idem.Key, feed.LastEventKey:
+			return lower, true
+		}
+		if strings.TrimPrefix(lower, strings.ToLower(runtime.MetadataHeaderPrefix)) == guard.UserKey {
+			return "", false
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}
+}
+
 func runServer(conn *pg.DB) {
 	// create stores
 	instStore := instStore.NewStore(conn)
@@ -67,6 +161,24 @@ func runServer(conn *pg.DB) {
 	stratStore := stratStore.NewStore(conn)
 	lotStore := lotStore.NewStore(conn)
 	txnStore := txnStore.NewStore(conn)
+	priceStore := priceStore.NewStore(conn)
+	fxStore := fxStore.NewStore(conn)
+	bmkStore := bmkStore.NewStore(conn)
+	glStore := glStore.NewStore(conn)
+	lockStore := lockStore.NewStore(conn)
+	reconStore := reconStore.NewStore(conn)
+	idemStore := idemStore.NewStore(conn)
+	changeStore := changeStore.NewStore(conn)
+
+	// create helpers shared across services
+	valuer := valuation.NewValuer(lotStore, priceStore, fxStore, baseCcyID())
+	builder := benchmark.NewBuilder(bmkStore, valuer)
+	guard := guard.NewGuard(lockStore, lockOverrideUsers())
+	keeper := idem.NewKeeper(idemStore, idemRetention(), idemLease())
+	feed := feed.NewFeed(changeStore, changeRetention())
+	if key := pageTokenKey(); len(key) > 0 {
+		page.SetSecret(key)
+	}
 
 	// create services
 	services := []grpcPkg.Service{
@@ -75,8 +187,17 @@ func runServer(conn *pg.DB) {
 		acctService.NewService(acctStore),
 		portService.NewService(portStore),
 		stratService.NewService(stratStore),
-		lotService.NewService(lotStore),
-		txnService.NewService(txnStore, lotStore),
+		lotService.NewService(lotStore, guard, feed),
+		txnService.NewService(txnStore, lotStore, glStore, acctStore, instStore, guard, feed),
+		priceService.NewService(priceStore),
+		fxService.NewService(fxStore, lotStore, valuer),
+		posService.NewService(lotStore, txnStore, valuer),
+		perfService.NewService(lotStore, txnStore, instStore, bmkStore, builder, valuer),
+		bmkService.NewService(bmkStore, builder),
+		glService.NewService(glStore, orgStore, lotStore, lockStore, valuer),
+		lockService.NewService(lockStore),
+		reconService.NewService(reconStore, acctStore, instStore, lotStore, txnStore),
+		exportService.NewService(lotStore, txnStore),
 		versionService.NewService(),
 	}
 
@@ -84,7 +205,7 @@ func runServer(conn *pg.DB) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	server := grpc.NewServer()
+	server := grpc.NewServer(grpc.UnaryInterceptor(keeper.Interceptor))
 	for _, service := range services {
 		service.RegisterServer(server)
 	}
@@ -96,6 +217,18 @@ func runServer(conn *pg.DB) {
 		}
 	}()
 
+	go func() {
+		// purge expired idempotency keys and changes every hour
+		for range time.Tick(time.Hour) {
+			if _, err := keeper.Purge(context.Background()); err != nil {
+				log.Print(err)
+			}
+			if _, err := feed.Purge(context.Background()); err != nil {
+				log.Print(err)
+			}
+		}
+	}()
+
 	localConn, err := grpc.Dial(internalGRPCEndpoint, grpc.WithInsecure())
 	if err != nil {
 		log.Fatal(err)
@@ -104,11 +237,16 @@ func runServer(conn *pg.DB) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// wake watchers as changes are committed
+	go feed.Run(ctx)
+
 	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(headerMatcher(trustUserHeader())),
 		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
 			OrigName: false,
 			// EmitDefaults: true,
 		}),
+		runtime.WithMarshalerOption(sse.ContentType, &sse.Marshaler{}),
 	)
 	for _, service := range services {
 		if err := service.RegisterHandler(ctx, mux, localConn); err != nil {
@@ -146,6 +284,14 @@ func main() {
 	}
 	conn := pg.Connect(opt)
 	conn.Exec("SET TIMEZONE TO 'UTC'")
+
+	// run a command line command if one is passed in, otherwise start the server
+	if len(os.Args) > 1 {
+		if err := runCommand(conn, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	conn.AddQueryHook(dbLogger{})
 
 	go runServer(conn)
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/utilities"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/export"
+	"github.com/wolfinger/varangian/internal/parquet"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// chunkSize is the most data sent per streamed chunk, well under grpc's message size limit
+	chunkSize = 64 * 1024
+)
+
+var (
+	// http routes the exports are served on
+	patternExportLots    = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 2, 1}, []string{"v1", "lots"}, "export", runtime.AssumeColonVerbOpt(true)))
+	patternExportLotBals = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 2, 1}, []string{"v1", "lotbals"}, "export", runtime.AssumeColonVerbOpt(true)))
+	patternExportTxns    = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0, 2, 1}, []string{"v1", "txns"}, "export", runtime.AssumeColonVerbOpt(true)))
+)
+
+// Service interface used for implementing the Export service
+type Service interface {
+	v1.ExportServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Export service
+func NewService(lotStore lotStore.Store, txnStore txnStore.Store) *ExportServiceImpl {
+	return &ExportServiceImpl{
+		lotStore: lotStore,
+		txnStore: txnStore,
+	}
+}
+
+// ExportServiceImpl data structure for implementing the Export service
+type ExportServiceImpl struct {
+	lotStore lotStore.Store
+	txnStore txnStore.Store
+}
+
+// RegisterServer registers the Export service server
+func (s *ExportServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterExportServiceServer(server, s)
+}
+
+// RegisterHandler registers the Export service's http handlers. the gateway would wrap each streamed chunk
+// in json, so the handlers copy the chunks into the response as is
+func (s *ExportServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	client := v1.NewExportServiceClient(conn)
+	mux.Handle("GET", patternExportLots, exportHandler(mux, "lots",
+		func() exportRequest { return &v1.ExportLotsRequest{} },
+		func(ctx context.Context, req exportRequest) (chunkStream, error) {
+			return client.ExportLots(ctx, req.(*v1.ExportLotsRequest))
+		}))
+	mux.Handle("GET", patternExportLotBals, exportHandler(mux, "lot_bals",
+		func() exportRequest { return &v1.ExportLotBalsRequest{} },
+		func(ctx context.Context, req exportRequest) (chunkStream, error) {
+			return client.ExportLotBals(ctx, req.(*v1.ExportLotBalsRequest))
+		}))
+	mux.Handle("GET", patternExportTxns, exportHandler(mux, "txns",
+		func() exportRequest { return &v1.ExportTxnsRequest{} },
+		func(ctx context.Context, req exportRequest) (chunkStream, error) {
+			return client.ExportTxns(ctx, req.(*v1.ExportTxnsRequest))
+		}))
+
+	return nil
+}
+
+// ExportLots streams the lots matching a filter as a csv or parquet file via the Export service
+func (s *ExportServiceImpl) ExportLots(request *v1.ExportLotsRequest, stream v1.ExportService_ExportLotsServer) error {
+	c := &chunker{sender: stream}
+	if err := s.WriteLots(stream.Context(), request, c); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// ExportLotBals streams the balances between two dates of the lots matching a filter as a csv or parquet
+// file via the Export service
+func (s *ExportServiceImpl) ExportLotBals(request *v1.ExportLotBalsRequest, stream v1.ExportService_ExportLotBalsServer) error {
+	c := &chunker{sender: stream}
+	if err := s.WriteLotBals(stream.Context(), request, c); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// ExportTxns streams the txns matching a filter as a csv or parquet file via the Export service
+func (s *ExportServiceImpl) ExportTxns(request *v1.ExportTxnsRequest, stream v1.ExportService_ExportTxnsServer) error {
+	c := &chunker{sender: stream}
+	if err := s.WriteTxns(stream.Context(), request, c); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// WriteLots writes the lots matching a filter to a csv or parquet file
+func (s *ExportServiceImpl) WriteLots(ctx context.Context, request *v1.ExportLotsRequest, w io.Writer) error {
+	ew, err := newWriter(w, request.GetFormat(), export.LotCols)
+	if err != nil {
+		return err
+	}
+	err = s.lotStore.StreamLots(ctx, request.GetFilter(), func(lot *storage.Lot) error {
+		return ew.Write(export.LotRow(lot))
+	})
+	if err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+// WriteLotBals writes the balances between two dates (inclusive) of the lots matching a filter to a csv or
+// parquet file
+func (s *ExportServiceImpl) WriteLotBals(ctx context.Context, request *v1.ExportLotBalsRequest, w io.Writer) error {
+	startDt, err := time.Parse(config.APIFormats.DateFmt, request.GetStartDt())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid start_dt %q", request.GetStartDt())
+	}
+	endDt, err := time.Parse(config.APIFormats.DateFmt, request.GetEndDt())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid end_dt %q", request.GetEndDt())
+	}
+	if endDt.Before(startDt) {
+		return status.Error(codes.InvalidArgument, "end_dt is before start_dt")
+	}
+
+	ew, err := newWriter(w, request.GetFormat(), export.LotBalCols)
+	if err != nil {
+		return err
+	}
+	err = s.lotStore.StreamLotBals(ctx, request.GetStartDt(), request.GetEndDt(), request.GetFilter(), func(lotBal *storage.LotBal) error {
+		return ew.Write(export.LotBalRow(lotBal))
+	})
+	if err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+// WriteTxns writes the txns matching a filter to a csv or parquet file
+func (s *ExportServiceImpl) WriteTxns(ctx context.Context, request *v1.ExportTxnsRequest, w io.Writer) error {
+	ew, err := newWriter(w, request.GetFormat(), export.TxnCols)
+	if err != nil {
+		return err
+	}
+	err = s.txnStore.StreamTxns(ctx, request.GetFilter(), func(txn *storage.Txn) error {
+		return ew.Write(export.TxnRow(txn))
+	})
+	if err != nil {
+		return err
+	}
+
+	return ew.Close()
+}
+
+// newWriter starts an export in a format, csv by default
+func newWriter(w io.Writer, format string, cols []parquet.Column) (export.Writer, error) {
+	ew, err := export.NewWriter(w, exportFormat(format), cols)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return ew, nil
+}
+
+// exportFormat defaults an export's format to csv
+func exportFormat(format string) string {
+	if format == "" {
+		return export.Format.CSV
+	}
+	return format
+}
+
+// sender sends a chunk of an export to the client
+type sender interface {
+	Send(*v1.ExportChunk) error
+}
+
+// chunker sends what's written to it as chunks of up to chunkSize bytes. Flush sends the rest
+type chunker struct {
+	sender sender
+	buf    []byte
+}
+
+// Write buffers data, sending every full chunk
+func (c *chunker) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= chunkSize {
+		if err := c.sender.Send(&v1.ExportChunk{Data: c.buf[:chunkSize]}); err != nil {
+			return 0, err
+		}
+		c.buf = append(c.buf[:0], c.buf[chunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Flush sends whatever's buffered
+func (c *chunker) Flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.sender.Send(&v1.ExportChunk{Data: c.buf})
+	c.buf = nil
+	return err
+}
+
+// exportRequest is any of the export requests
+type exportRequest interface {
+	proto.Message
+	GetFormat() string
+}
+
+// chunkStream receives the chunks of an export from the grpc service
+type chunkStream interface {
+	Recv() (*v1.ExportChunk, error)
+}
+
+// exportHandler serves an export over http. the request is read from the query string and the chunks the
+// grpc service streams are copied into the response as they arrive. the first chunk is received before the
+// response starts, so a bad request still gets an error status; a failure after that aborts the response so
+// a partial file can't be mistaken for a whole one
+func exportHandler(mux *runtime.ServeMux, name string, newRequest func() exportRequest,
+	open func(ctx context.Context, req exportRequest) (chunkStream, error)) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+
+		req := newRequest()
+		if err := r.ParseForm(); err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, status.Errorf(codes.InvalidArgument, "%v", err))
+			return
+		}
+		if err := runtime.PopulateQueryParameters(req, r.Form, utilities.NewDoubleArray(nil)); err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, status.Errorf(codes.InvalidArgument, "%v", err))
+			return
+		}
+		ctx, err := runtime.AnnotateContext(ctx, mux, r)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		stream, err := open(ctx, req)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		chunk, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		format := exportFormat(req.GetFormat())
+		w.Header().Set("Content-Type", export.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+		flusher, _ := w.(http.Flusher)
+		for err == nil {
+			if _, err = w.Write(chunk.GetData()); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			chunk, err = stream.Recv()
+		}
+		if err != io.EOF {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
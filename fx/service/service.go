@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	fxStore "github.com/wolfinger/varangian/fx/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Service interface used for implementing the FX service
+type Service interface {
+	v1.FxServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new FX service
+func NewService(fxStore fxStore.Store, lotStore lotStore.Store, valuer *valuation.Valuer) *FxServiceImpl {
+	return &FxServiceImpl{
+		fxStore:  fxStore,
+		lotStore: lotStore,
+		valuer:   valuer,
+	}
+}
+
+// FxServiceImpl data structure for implementing the FX service
+type FxServiceImpl struct {
+	fxStore  fxStore.Store
+	lotStore lotStore.Store
+	valuer   *valuation.Valuer
+}
+
+// RegisterServer registers the FX service server
+func (s *FxServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterFxServiceServer(server, s)
+}
+
+// RegisterHandler registers the FX service handler
+func (s *FxServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterFxServiceHandler(ctx, mux, conn)
+}
+
+// GetFxRate gets the rate to convert one currency into another on or before a date from the FX service
+func (s *FxServiceImpl) GetFxRate(ctx context.Context, request *v1.GetFxRateRequest) (*v1.GetFxRateResponse, error) {
+	dt, err := rateDt(request.GetDt())
+	if err != nil {
+		return nil, err
+	}
+
+	viaID := request.GetViaCcyId()
+	if viaID == "" {
+		viaID = s.valuer.BaseCcyID()
+	}
+
+	fxRate, err := s.fxStore.GetFxRate(ctx, request.GetFromCcyId(), request.GetToCcyId(), dt, viaID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetFxRateResponse{
+		FxRate: fxRate,
+	}, nil
+}
+
+// ListFxRates lists the latest stored fx rates on or before a date from the FX service
+func (s *FxServiceImpl) ListFxRates(ctx context.Context, request *v1.ListFxRatesRequest) (*v1.ListFxRatesResponse, error) {
+	dt, err := rateDt(request.GetDt())
+	if err != nil {
+		return nil, err
+	}
+
+	fxRates, nextPageToken, err := s.fxStore.ListFxRates(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetCcyIds(), dt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListFxRatesResponse{
+		FxRates:       fxRates,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// UpsertFxRates inserts or replaces a set of fx rates via the FX service
+func (s *FxServiceImpl) UpsertFxRates(ctx context.Context, request *v1.UpsertFxRatesRequest) (*v1.UpsertFxRatesResponse, error) {
+	if len(request.GetFxRates()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "fx rates required in POST")
+	}
+
+	for i, fxRate := range request.GetFxRates() {
+		if fxRate.GetBaseCcyId() == "" || fxRate.GetQuoteCcyId() == "" || fxRate.GetRateDt() == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "fx rate %d requires a base_ccy_id, quote_ccy_id, and rate_dt", i)
+		}
+		if fxRate.GetBaseCcyId() == fxRate.GetQuoteCcyId() {
+			return nil, status.Errorf(codes.InvalidArgument, "fx rate %d has the same base and quote currency", i)
+		}
+		if fxRate.GetRate() <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "fx rate %d must be positive", i)
+		}
+		if _, err := time.Parse(config.APIFormats.DateFmt, fxRate.GetRateDt()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "fx rate %d has an invalid rate_dt %s", i, fxRate.GetRateDt())
+		}
+	}
+
+	if err := s.fxStore.UpsertFxRates(ctx, request.GetFxRates()); err != nil {
+		return nil, err
+	}
+
+	return &v1.UpsertFxRatesResponse{
+		Count: int32(len(request.GetFxRates())),
+	}, nil
+}
+
+// DeleteFxRate removes an fx rate from the FX service
+func (s *FxServiceImpl) DeleteFxRate(ctx context.Context, request *v1.DeleteFxRateRequest) (*v1.DeleteFxRateResponse, error) {
+	if request.GetDt() == "" {
+		return nil, status.Error(codes.InvalidArgument, "fx rate date expected in DELETE")
+	}
+
+	if err := s.fxStore.DeleteFxRate(ctx, request.GetBaseCcyId(), request.GetQuoteCcyId(), request.GetDt()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteFxRateResponse{}, nil
+}
+
+// ValueLotBals values a set of lot balances in a reporting currency. if a start date is passed in, the
+// change in value since the start date is split into local price, fx, and size effects
+func (s *FxServiceImpl) ValueLotBals(ctx context.Context, request *v1.ValueLotBalsRequest) (*v1.ValueLotBalsResponse, error) {
+	if request.GetDt() == "" {
+		return nil, status.Error(codes.InvalidArgument, "valuation date expected in POST")
+	}
+	if request.GetRptCcyId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reporting currency expected in POST")
+	}
+
+	lotIDs := request.GetLotIds()
+	if len(lotIDs) == 0 {
+		lotIDs = nil
+	}
+
+	// value lot balances as of the end date
+	lotBals, err := s.lotStore.ListLotBals(ctx, request.GetDt(), lotIDs)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := s.valuer.ValueLotBals(ctx, request.GetDt(), request.GetRptCcyId(), lotBals)
+	if err != nil {
+		return nil, err
+	}
+
+	// value lot balances as of the start date
+	startVals := make(map[string]*valuation.Val)
+	if request.GetStartDt() != "" {
+		startLotBals, err := s.lotStore.ListLotBals(ctx, request.GetStartDt(), lotIDs)
+		if err != nil {
+			return nil, err
+		}
+		svs, err := s.valuer.ValueLotBals(ctx, request.GetStartDt(), request.GetRptCcyId(), startLotBals)
+		if err != nil {
+			return nil, err
+		}
+		for _, sv := range svs {
+			startVals[sv.LotBal.GetLotId()] = sv
+		}
+	}
+
+	response := &v1.ValueLotBalsResponse{}
+	for _, val := range vals {
+		lbv := lotBalVal(val)
+
+		if sv, ok := startVals[val.LotBal.GetLotId()]; ok {
+			delete(startVals, val.LotBal.GetLotId())
+			addStartVal(lbv, sv)
+		}
+
+		response.Vals = append(response.Vals, lbv)
+	}
+
+	// lots that were closed out since the start date
+	for _, sv := range startVals {
+		lbv := &v1.LotBalVal{
+			LotId:   sv.LotBal.GetLotId(),
+			InstId:  sv.Lot.GetInstId(),
+			CcyId:   sv.CcyID,
+			Priced:  sv.Priced,
+			Px:      sv.Px,
+			FxRate:  sv.FxRate,
+			LotSize: 0,
+		}
+		addStartVal(lbv, sv)
+		response.Vals = append(response.Vals, lbv)
+	}
+
+	for _, lbv := range response.Vals {
+		response.RptMktVal += lbv.GetRptMktVal()
+		response.StartRptMktVal += lbv.GetStartRptMktVal()
+		response.LocalEffect += lbv.GetLocalEffect()
+		response.FxEffect += lbv.GetFxEffect()
+		response.SizeEffect += lbv.GetSizeEffect()
+	}
+
+	return response, nil
+}
+
+// lotBalVal converts a valuation into its api representation
+func lotBalVal(val *valuation.Val) *v1.LotBalVal {
+	return &v1.LotBalVal{
+		LotId:       val.LotBal.GetLotId(),
+		InstId:      val.Lot.GetInstId(),
+		CcyId:       val.CcyID,
+		Priced:      val.Priced,
+		LotSize:     val.LotBal.GetLotSize(),
+		Px:          val.Px,
+		FxRate:      val.FxRate,
+		LocalMktVal: val.LocalMktVal,
+		RptMktVal:   val.RptMktVal,
+	}
+}
+
+// addStartVal adds the start date valuation to a lot balance valuation and splits the change in value
+// into effects. local effect = start size * change in price * start fx, fx effect = start size * end
+// price * change in fx, and size effect = change in size * end price * end fx
+func addStartVal(lbv *v1.LotBalVal, sv *valuation.Val) {
+	lbv.StartLotSize = sv.LotBal.GetLotSize()
+	lbv.StartPx = sv.Px
+	lbv.StartFxRate = sv.FxRate
+	lbv.StartRptMktVal = sv.RptMktVal
+
+	if !lbv.GetPriced() || !sv.Priced {
+		return
+	}
+
+	lbv.LocalEffect = lbv.GetStartLotSize() * (lbv.GetPx() - lbv.GetStartPx()) * lbv.GetStartFxRate()
+	lbv.FxEffect = lbv.GetStartLotSize() * lbv.GetPx() * (lbv.GetFxRate() - lbv.GetStartFxRate())
+	lbv.SizeEffect = (lbv.GetLotSize() - lbv.GetStartLotSize()) * lbv.GetPx() * lbv.GetFxRate()
+}
+
+// rateDt validates a requested fx rate date, defaulting to today
+func rateDt(dt string) (string, error) {
+	if dt == "" {
+		return time.Now().Format(config.APIFormats.DateFmt), nil
+	}
+	if _, err := time.Parse(config.APIFormats.DateFmt, dt); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid fx rate date %s", dt)
+	}
+
+	return dt, nil
+}
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store interface used for implementing the FX Rate store
+type Store interface {
+	GetFxRate(ctx context.Context, fromID string, toID string, dt string, viaID string) (*storage.FxRate, error)
+	ListFxRates(ctx context.Context, pageSize int32, pageToken string, ccyIDs []string, dt string) ([]*storage.FxRate, string, error)
+	ListFxRateHist(ctx context.Context, ccyIDs []string, startDt string, endDt string) ([]*storage.FxRate, error)
+	UpsertFxRates(ctx context.Context, fxRates []*storage.FxRate) error
+	DeleteFxRate(ctx context.Context, baseID string, quoteID string, dt string) error
+}
+
+// NewStore encapsulates FX Rate database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn *pg.DB
+}
+
+// fxRateKeys are the keys the latest fx rates are listed in order of, as there's one for each currency pair
+var fxRateKeys = []page.Key{{Col: "base_ccy_id"}, {Col: "quote_ccy_id"}}
+
+// GetFxRate gets the latest rate on or before a given date to convert one unit of the from currency into
+// the to currency. the stored rate is used directly or inverted, otherwise the rate is triangulated
+// through the via currency
+func (s *storeImpl) GetFxRate(ctx context.Context, fromID string, toID string, dt string, viaID string) (*storage.FxRate, error) {
+	fxRate := &storage.FxRate{
+		BaseCcyId:  fromID,
+		QuoteCcyId: toID,
+		RateDt:     dt,
+		Rate:       1,
+	}
+
+	// no conversion needed for the same currency
+	if fromID == toID {
+		return fxRate, nil
+	}
+
+	// convert vxids to vids
+	fromVid, err := vxid.Decode(fromID)
+	if err != nil {
+		return nil, err
+	}
+	toVid, err := vxid.Decode(toID)
+	if err != nil {
+		return nil, err
+	}
+
+	// direct (or inverse) rate
+	rate, rateDt, err := s.directRate(ctx, fromVid, toVid, dt)
+	if err != nil {
+		return nil, err
+	}
+	if rate != 0 {
+		fxRate.Rate = rate
+		fxRate.RateDt = rateDt
+		return fxRate, nil
+	}
+
+	// triangulate through the via currency
+	if viaID != "" && viaID != fromID && viaID != toID {
+		viaVid, err := vxid.Decode(viaID)
+		if err != nil {
+			return nil, err
+		}
+		fromRate, fromDt, err := s.directRate(ctx, fromVid, viaVid, dt)
+		if err != nil {
+			return nil, err
+		}
+		toRate, toDt, err := s.directRate(ctx, viaVid, toVid, dt)
+		if err != nil {
+			return nil, err
+		}
+		if fromRate != 0 && toRate != 0 {
+			fxRate.Rate = fromRate * toRate
+			// report the stalest leg used in the cross rate
+			fxRate.RateDt = fromDt
+			if toDt < fromDt {
+				fxRate.RateDt = toDt
+			}
+			fxRate.Source = "cross"
+			return fxRate, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "fx rate from %s to %s on or before %s not found", fromID, toID, dt)
+}
+
+// directRate finds the latest stored rate between two currency vids (inverting it if stored the other way
+// around), returning a zero rate if none exists
+func (s *storeImpl) directRate(ctx context.Context, fromVid string, toVid string, dt string) (float64, string, error) {
+	var fxRate storage.FxRate
+	err := s.conn.ModelContext(ctx, &fxRate).ColumnExpr("*, rate_dt::date").
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			q.WhereOrGroup(func(q *orm.Query) (*orm.Query, error) {
+				return q.Where("base_ccy_id = ?", fromVid).Where("quote_ccy_id = ?", toVid), nil
+			})
+			q.WhereOrGroup(func(q *orm.Query) (*orm.Query, error) {
+				return q.Where("base_ccy_id = ?", toVid).Where("quote_ccy_id = ?", fromVid), nil
+			})
+			return q, nil
+		}).
+		Where("rate_dt <= ?", dt).
+		Where("rate <> 0").
+		OrderExpr("fx_rate.rate_dt DESC").Limit(1).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return 0, "", nil
+		}
+		return 0, "", fmt.Errorf("getting fx rate: %w", err)
+	}
+
+	if fxRate.GetBaseCcyId() == toVid {
+		return 1 / fxRate.GetRate(), fxRate.GetRateDt(), nil
+	}
+
+	return fxRate.GetRate(), fxRate.GetRateDt(), nil
+}
+
+// ListFxRates lists a page of the latest stored rates on or before a given date for each currency pair in order
+// of base and quote currency, optionally limited to pairs involving a set of currencies. a page size of 0 lists
+// every rate
+func (s *storeImpl) ListFxRates(ctx context.Context, pageSize int32, pageToken string, ccyIDs []string, dt string) ([]*storage.FxRate, string, error) {
+	var err error
+	var fxRates []*storage.FxRate
+
+	q := s.conn.ModelContext(ctx, &fxRates).DistinctOn("base_ccy_id, quote_ccy_id").ColumnExpr("*, rate_dt::date").Where("rate_dt <= ?", dt)
+	if len(ccyIDs) > 0 {
+		vids, err := vxid.Decodes(ccyIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		q.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.Where("base_ccy_id IN (?)", pg.In(vids)).WhereOr("quote_ccy_id IN (?)", pg.In(vids)), nil
+		})
+	}
+	p := page.NewPager(pageSize, fxRateKeys, page.Query(strings.Join(ccyIDs, ","), dt))
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	err = q.OrderExpr("fx_rate.rate_dt DESC").Select()
+	if err != nil {
+		return nil, "", fmt.Errorf("listing fx rates: %w", err)
+	}
+
+	// the next page starts after the last currency pair of this one
+	var nextPageToken string
+	n, more := p.Next(len(fxRates))
+	fxRates = fxRates[:n]
+	if more {
+		nextPageToken = p.Token(fxRates[n-1].GetBaseCcyId(), fxRates[n-1].GetQuoteCcyId())
+	}
+
+	for _, fxRate := range fxRates {
+		// convert vids to vxids
+		fxRate.BaseCcyId, err = vxid.Encode(fxRate.GetBaseCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, "", err
+		}
+		fxRate.QuoteCcyId, err = vxid.Encode(fxRate.GetQuoteCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return fxRates, nextPageToken, nil
+}
+
+// ListFxRateHist lists the stored rates between a set of currencies between two dates (inclusive), starting
+// from the latest rate on or before the start date for each pair, in date order
+func (s *storeImpl) ListFxRateHist(ctx context.Context, ccyIDs []string, startDt string, endDt string) ([]*storage.FxRate, error) {
+	if len(ccyIDs) == 0 {
+		return nil, nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ccyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var fxRates []*storage.FxRate
+	err = s.conn.ModelContext(ctx, &fxRates).ColumnExpr("*, rate_dt::date").
+		Where("base_ccy_id IN (?)", pg.In(vids)).
+		Where("quote_ccy_id IN (?)", pg.In(vids)).
+		Where("rate <> 0").
+		Where("rate_dt <= ?", endDt).
+		Where("rate_dt >= coalesce((SELECT max(r.rate_dt) FROM fx_rates AS r WHERE r.base_ccy_id = fx_rate.base_ccy_id AND r.quote_ccy_id = fx_rate.quote_ccy_id AND r.rate <> 0 AND r.rate_dt <= ?), ?)", startDt, startDt).
+		OrderExpr("fx_rate.rate_dt").Select()
+	if err != nil {
+		return nil, fmt.Errorf("listing fx rate hist: %w", err)
+	}
+
+	for _, fxRate := range fxRates {
+		// convert vids to vxids
+		fxRate.BaseCcyId, err = vxid.Encode(fxRate.GetBaseCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+		fxRate.QuoteCcyId, err = vxid.Encode(fxRate.GetQuoteCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fxRates, nil
+}
+
+// UpsertFxRates inserts or replaces a set of fx rates in bulk via the FX Rate store. the fx rates passed in
+// aren't changed
+func (s *storeImpl) UpsertFxRates(ctx context.Context, fxRates []*storage.FxRate) error {
+	if len(fxRates) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.FxRate, len(fxRates))
+	for i, fxRate := range fxRates {
+		rows[i] = proto.Clone(fxRate).(*storage.FxRate)
+		if err := decodeFxRateIDs(rows[i]); err != nil {
+			return err
+		}
+	}
+
+	// insert fx rates in datastore, replacing any existing rate for the same pair and date
+	_, err := s.conn.ModelContext(ctx, &rows).
+		OnConflict("(base_ccy_id, quote_ccy_id, rate_dt) DO UPDATE").
+		Set("rate = EXCLUDED.rate, source = EXCLUDED.source").
+		Insert()
+	if err != nil {
+		return fmt.Errorf("upserting fx rates: %w", err)
+	}
+
+	return nil
+}
+
+// decodeFxRateIDs converts the vxids on an fx rate to vids
+func decodeFxRateIDs(fxRate *storage.FxRate) error {
+	var err error
+
+	fxRate.BaseCcyId, err = vxid.Decode(fxRate.GetBaseCcyId())
+	if err != nil {
+		return err
+	}
+	fxRate.QuoteCcyId, err = vxid.Decode(fxRate.GetQuoteCcyId())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteFxRate removes an fx rate from the FX Rate store
+func (s *storeImpl) DeleteFxRate(ctx context.Context, baseID string, quoteID string, dt string) error {
+	// convert vxids to vids
+	baseVid, err := vxid.Decode(baseID)
+	if err != nil {
+		return err
+	}
+	quoteVid, err := vxid.Decode(quoteID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.ModelContext(ctx, (*storage.FxRate)(nil)).Where("base_ccy_id = ?", baseVid).Where("quote_ccy_id = ?", quoteVid).Where("rate_dt = ?", dt).Delete()
+	if err != nil {
+		return fmt.Errorf("deleting fx rate %s/%s on %s: %w", baseID, quoteID, dt, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testStore connects to the database in TEST_DB_CONN_STR, skipping the test if it isn't set. fx rates are
+// written to a temp table that shadows the real one, so the pool is kept to a single connection
+func testStore(t *testing.T) *storeImpl {
+	connStr := os.Getenv("TEST_DB_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+
+	opt, err := pg.ParseURL(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.PoolSize = 1
+
+	conn := pg.Connect(opt)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Exec(`CREATE TEMP TABLE fx_rates (
+		base_ccy_id uuid NOT NULL,
+		quote_ccy_id uuid NOT NULL,
+		rate_dt date NOT NULL,
+		rate float8,
+		source text,
+		PRIMARY KEY (base_ccy_id, quote_ccy_id, rate_dt))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &storeImpl{conn: conn}
+}
+
+func TestGetFxRate(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t)
+
+	usd, _ := vxid.Encode("0b5d8f6e-3c1a-4e27-9d4b-7a2e1f0c8b31", vxid.PfxMap.Instrument)
+	eur, _ := vxid.Encode("5e2a9c14-7b3d-4f80-a6e1-9c0d2b4f7a62", vxid.PfxMap.Instrument)
+	gbp, _ := vxid.Encode("9a7c3e51-2d6b-4c18-b0f4-3e8a5d1c6b93", vxid.PfxMap.Instrument)
+	jpy, _ := vxid.Encode("c4f1b8a2-6e9d-4a37-8c25-1b7e0d3a9f04", vxid.PfxMap.Instrument)
+
+	// eur and gbp are stored against usd, and usd against jpy
+	fxRates := []*storage.FxRate{
+		{BaseCcyId: eur, QuoteCcyId: usd, RateDt: "2021-01-04", Rate: 1.2, Source: "vendor"},
+		{BaseCcyId: eur, QuoteCcyId: usd, RateDt: "2021-01-06", Rate: 1.25, Source: "vendor"},
+		{BaseCcyId: gbp, QuoteCcyId: usd, RateDt: "2021-01-05", Rate: 1.36, Source: "vendor"},
+		{BaseCcyId: usd, QuoteCcyId: jpy, RateDt: "2021-01-06", Rate: 104, Source: "vendor"},
+	}
+	if err := s.UpsertFxRates(ctx, fxRates); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		from   string
+		to     string
+		dt     string
+		rate   float64
+		rateDt string
+		source string
+	}{
+		{"same currency", eur, eur, "2021-01-04", 1, "2021-01-04", ""},
+		{"stored", eur, usd, "2021-01-04", 1.2, "2021-01-04", ""},
+		{"inverted", usd, eur, "2021-01-04", 1 / 1.2, "2021-01-04", ""},
+		{"earlier date", eur, usd, "2021-01-05", 1.2, "2021-01-04", ""},
+		{"latest on or before", usd, eur, "2021-01-08", 1 / 1.25, "2021-01-06", ""},
+		{"cross", eur, gbp, "2021-01-06", 1.25 / 1.36, "2021-01-05", "cross"},
+		{"cross inverted", jpy, eur, "2021-01-08", 1 / 104.0 / 1.25, "2021-01-06", "cross"},
+		{"cross stalest leg", gbp, jpy, "2021-01-07", 1.36 * 104, "2021-01-05", "cross"},
+	}
+	for _, test := range tests {
+		fxRate, err := s.GetFxRate(ctx, test.from, test.to, test.dt, usd)
+		if err != nil {
+			t.Errorf("%s got: %v", test.name, err)
+			continue
+		}
+		if math.Abs(fxRate.GetRate()-test.rate) > 1e-9 || fxRate.GetRateDt() != test.rateDt || fxRate.GetSource() != test.source {
+			t.Errorf("%s got: %v on %s (%q), want: %v on %s (%q)", test.name, fxRate.GetRate(), fxRate.GetRateDt(),
+				fxRate.GetSource(), test.rate, test.rateDt, test.source)
+		}
+		if fxRate.GetBaseCcyId() != test.from || fxRate.GetQuoteCcyId() != test.to {
+			t.Errorf("%s got pair: %s/%s, want: %s/%s", test.name, fxRate.GetBaseCcyId(), fxRate.GetQuoteCcyId(), test.from, test.to)
+		}
+	}
+
+	// nothing is found before the first rate, and a cross rate needs both legs through the via currency
+	notFound := []struct {
+		name string
+		from string
+		to   string
+		dt   string
+		via  string
+	}{
+		{"before the first rate", eur, usd, "2021-01-03", usd},
+		{"cross missing a leg", eur, jpy, "2021-01-05", usd},
+		{"cross without a via currency", eur, gbp, "2021-01-06", ""},
+		{"cross through the wrong currency", eur, gbp, "2021-01-06", jpy},
+	}
+	for _, test := range notFound {
+		if _, err := s.GetFxRate(ctx, test.from, test.to, test.dt, test.via); status.Code(err) != codes.NotFound {
+			t.Errorf("%s got: %v, want: %v", test.name, err, codes.NotFound)
+		}
+	}
+}
@@ -0,0 +1,789 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	glStore "github.com/wolfinger/varangian/gl/store"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	orgStore "github.com/wolfinger/varangian/org/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type glRole struct {
+	SecuritiesCost string
+	Cash           string
+	Payable        string
+	Receivable     string
+	RealizedGain   string
+	Income         string
+	Fee            string
+	Capital        string
+}
+
+var (
+	// GlRole defines the list of roles a gl account can play in the chart of accounts. transactions are
+	// posted to the account with the role they affect
+	GlRole = glRole{
+		SecuritiesCost: "securities_cost",
+		Cash:           "cash",
+		Payable:        "payable",
+		Receivable:     "receivable",
+		RealizedGain:   "realized_gain",
+		Income:         "income",
+		Fee:            "fee",
+		Capital:        "capital"}
+)
+
+type glBasis struct {
+	Cash    string
+	Accrual string
+}
+
+type glSection struct {
+	Asset     string
+	Liability string
+	Equity    string
+	Income    string
+	Expense   string
+}
+
+var (
+	// GlSection defines the sections of the financial statements gl roles report under
+	GlSection = glSection{
+		Asset:     "asset",
+		Liability: "liability",
+		Equity:    "equity",
+		Income:    "income",
+		Expense:   "expense"}
+
+	// GlBasis defines the accounting bases journals can be posted under. journals without a basis are part
+	// of both
+	GlBasis = glBasis{
+		Cash:    "cash",
+		Accrual: "accrual"}
+)
+
+// roleSection maps a gl role to the financial statement section it reports under
+func roleSection(role string) string {
+	switch role {
+	case GlRole.SecuritiesCost, GlRole.Cash, GlRole.Receivable:
+		return GlSection.Asset
+	case GlRole.Payable:
+		return GlSection.Liability
+	case GlRole.Capital:
+		return GlSection.Equity
+	case GlRole.RealizedGain, GlRole.Income:
+		return GlSection.Income
+	case GlRole.Fee:
+		return GlSection.Expense
+	}
+	return ""
+}
+
+// validRole checks a role is in the list of gl roles
+func validRole(role string) bool {
+	switch role {
+	case GlRole.SecuritiesCost, GlRole.Cash, GlRole.Payable, GlRole.Receivable,
+		GlRole.RealizedGain, GlRole.Income, GlRole.Fee, GlRole.Capital:
+		return true
+	}
+	return false
+}
+
+// Service interface used for implementing the General Ledger service
+type Service interface {
+	v1.GlServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new General Ledger service
+func NewService(glStore glStore.Store, orgStore orgStore.Store, lotStore lotStore.Store, lockStore lockStore.Store, valuer *valuation.Valuer) *GlServiceImpl {
+	return &GlServiceImpl{
+		glStore:   glStore,
+		orgStore:  orgStore,
+		lotStore:  lotStore,
+		lockStore: lockStore,
+		valuer:    valuer,
+	}
+}
+
+// GlServiceImpl data structure for implementing the General Ledger service
+type GlServiceImpl struct {
+	glStore   glStore.Store
+	orgStore  orgStore.Store
+	lotStore  lotStore.Store
+	lockStore lockStore.Store
+	valuer    *valuation.Valuer
+}
+
+// RegisterServer registers the General Ledger service server
+func (s *GlServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterGlServiceServer(server, s)
+}
+
+// RegisterHandler registers the General Ledger service handler
+func (s *GlServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterGlServiceHandler(ctx, mux, conn)
+}
+
+// GetGlAcct gets a gl account from the General Ledger service
+func (s *GlServiceImpl) GetGlAcct(ctx context.Context, request *v1.GetGlAcctRequest) (*v1.GetGlAcctResponse, error) {
+	glAcct, err := s.glStore.GetGlAcct(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetGlAcctResponse{
+		GlAcct: glAcct,
+	}, nil
+}
+
+// ListGlAccts lists the chart of accounts from the General Ledger service
+func (s *GlServiceImpl) ListGlAccts(ctx context.Context, request *v1.ListGlAcctsRequest) (*v1.ListGlAcctsResponse, error) {
+	glAccts, nextPageToken, err := s.glStore.ListGlAccts(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListGlAcctsResponse{
+		GlAccts:       glAccts,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// UpdateGlAcct updates a gl account via the General Ledger service
+func (s *GlServiceImpl) UpdateGlAcct(ctx context.Context, request *v1.UpdateGlAcctRequest) (*v1.UpdateGlAcctResponse, error) {
+	glAcct := request.GetGlAcct()
+	if glAcct == nil {
+		return nil, status.Error(codes.InvalidArgument, "gl_acct required in PATCH")
+	}
+	glAcct.Id = request.GetId()
+	if glAcct.GetRole() != "" && !validRole(glAcct.GetRole()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid gl role %s", glAcct.GetRole())
+	}
+
+	if err := s.glStore.UpdateGlAcct(ctx, glAcct, request.GetUpdateMask().GetPaths()); err != nil {
+		return nil, err
+	}
+
+	return &v1.UpdateGlAcctResponse{Version: glAcct.GetVersion()}, nil
+}
+
+// CreateGlAcct creates a new gl account via the General Ledger service
+func (s *GlServiceImpl) CreateGlAcct(ctx context.Context, request *v1.CreateGlAcctRequest) (*v1.CreateGlAcctResponse, error) {
+	if request.GetGlAcct() == nil {
+		return nil, status.Error(codes.InvalidArgument, "gl_acct required in POST")
+	}
+	if request.GetGlAcct().GetId() != "" {
+		return nil, status.Error(codes.InvalidArgument, "gl_acct id is not expected in POST")
+	}
+	if !validRole(request.GetGlAcct().GetRole()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid gl role %s", request.GetGlAcct().GetRole())
+	}
+
+	glAcct, err := s.glStore.CreateGlAcct(ctx, request.GetGlAcct())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateGlAcctResponse{
+		GlAcct: glAcct,
+	}, nil
+}
+
+// DeleteGlAcct removes a gl account from the General Ledger service
+func (s *GlServiceImpl) DeleteGlAcct(ctx context.Context, request *v1.DeleteGlAcctRequest) (*v1.DeleteGlAcctResponse, error) {
+	if err := s.glStore.DeleteGlAcct(ctx, request.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteGlAcctResponse{}, nil
+}
+
+// GetJournal gets a journal from the General Ledger service
+func (s *GlServiceImpl) GetJournal(ctx context.Context, request *v1.GetJournalRequest) (*v1.GetJournalResponse, error) {
+	journal, err := s.glStore.GetJournal(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetJournalResponse{
+		Journal: journal,
+	}, nil
+}
+
+// ListJournals lists journals from the General Ledger service
+func (s *GlServiceImpl) ListJournals(ctx context.Context, request *v1.ListJournalsRequest) (*v1.ListJournalsResponse, error) {
+	journals, nextPageToken, err := s.glStore.ListJournals(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetTxnId(), request.GetLeOrgId(), request.GetStartDt(), request.GetEndDt())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListJournalsResponse{
+		Journals:      journals,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// ClosePeriod closes the books of a legal entity and the entities below it through a date. journals
+// can't be posted on or before the date once it's closed, and each legal entity's lock date is moved up to
+// the date so the transactions and lots behind the books can't change either. the periods and lock dates are
+// committed together, so a close that fails leaves nothing behind
+func (s *GlServiceImpl) ClosePeriod(ctx context.Context, request *v1.ClosePeriodRequest) (*v1.ClosePeriodResponse, error) {
+	if request.GetLeOrgId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "le_org_id required in POST")
+	}
+	end, err := parseDt(request.GetEndDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end date %s", request.GetEndDt())
+	}
+	endDt := end.Format(config.APIFormats.DateFmt)
+
+	leOrgIDs, err := s.leOrgTree(ctx, request.GetLeOrgId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.glStore.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		glTx := s.glStore.WithTx(tx)
+		lockTx := s.lockStore.WithTx(tx)
+
+		// periods are closed in order, for the entities below as well
+		for _, leOrgID := range leOrgIDs {
+			closedThru, err := glTx.ClosedThru(ctx, leOrgID)
+			if err != nil {
+				return err
+			}
+			if closedThru != "" && endDt <= closedThru {
+				return status.Errorf(codes.FailedPrecondition, "le org %s is already closed through %s", leOrgID, closedThru)
+			}
+		}
+		if err := glTx.ClosePeriod(ctx, leOrgIDs, endDt); err != nil {
+			return err
+		}
+
+		// lock dates only move forward on close
+		locks, _, err := lockTx.ListLocks(ctx, 0, "")
+		if err != nil {
+			return err
+		}
+		lockDts := make(map[string]string)
+		for _, lock := range locks {
+			if lock.GetLeOrgId() != "" {
+				lockDts[lock.GetLeOrgId()] = lock.GetLockDt()
+			}
+		}
+		for _, leOrgID := range leOrgIDs {
+			if lockDt := lockDts[leOrgID]; lockDt != "" && lockDt >= endDt {
+				continue
+			}
+			if _, err = lockTx.SetLock(ctx, &storage.Lock{LeOrgId: leOrgID, LockDt: endDt}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ClosePeriodResponse{
+		LeOrgIds: leOrgIDs,
+	}, nil
+}
+
+// ListGlPeriods lists the closed periods of a legal entity (or all legal entities) from the General Ledger
+// service
+func (s *GlServiceImpl) ListGlPeriods(ctx context.Context, request *v1.ListGlPeriodsRequest) (*v1.ListGlPeriodsResponse, error) {
+	glPeriods, nextPageToken, err := s.glStore.ListGlPeriods(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetLeOrgId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListGlPeriodsResponse{
+		GlPeriods:     glPeriods,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// GetTrialBalance gets the opening balance, debits, credits, and closing balance of each gl account for a
+// legal entity (rolled up with the entities below it) between two dates
+func (s *GlServiceImpl) GetTrialBalance(ctx context.Context, request *v1.GetTrialBalanceRequest) (*v1.GetTrialBalanceResponse, error) {
+	start, end, err := dtRange(request.GetStartDt(), request.GetEndDt())
+	if err != nil {
+		return nil, err
+	}
+	rpt, err := s.newReport(ctx, request.GetLeOrgId(), request.GetRptCcyId(), request.GetBasis())
+	if err != nil {
+		return nil, err
+	}
+	endDt := end.Format(config.APIFormats.DateFmt)
+
+	openBals, err := s.glStore.ListGlBals(ctx, rpt.leOrgIDs, "", start.AddDate(0, 0, -1).Format(config.APIFormats.DateFmt), rpt.basis, false)
+	if err != nil {
+		return nil, err
+	}
+	actBals, err := s.glStore.ListGlBals(ctx, rpt.leOrgIDs, start.Format(config.APIFormats.DateFmt), endDt, rpt.basis, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rowMap := make(map[string]*v1.TrialBalanceRow)
+	row := func(glBal *storage.GlBal) *v1.TrialBalanceRow {
+		key := glBal.GetGlAcctId() + "|" + glBal.GetCcyId()
+		r, ok := rowMap[key]
+		if !ok {
+			glAcct := rpt.glAcct(glBal.GetGlAcctId())
+			r = &v1.TrialBalanceRow{
+				GlAcctId: glBal.GetGlAcctId(),
+				Code:     glAcct.GetCode(),
+				Name:     glAcct.GetName(),
+				Role:     glAcct.GetRole(),
+				CcyId:    glBal.GetCcyId(),
+			}
+			rowMap[key] = r
+		}
+		return r
+	}
+	for _, glBal := range openBals {
+		amt, err := rpt.translate(ctx, endDt, glBal.GetCcyId(), glBal.GetDebit()-glBal.GetCredit())
+		if err != nil {
+			return nil, err
+		}
+		row(glBal).OpenBal += amt
+	}
+	response := &v1.GetTrialBalanceResponse{
+		LeOrgIds: rpt.leOrgIDs,
+		RptCcyId: rpt.rptCcyID,
+		Basis:    rpt.basis,
+	}
+	for _, glBal := range actBals {
+		debit, err := rpt.translate(ctx, endDt, glBal.GetCcyId(), glBal.GetDebit())
+		if err != nil {
+			return nil, err
+		}
+		credit, err := rpt.translate(ctx, endDt, glBal.GetCcyId(), glBal.GetCredit())
+		if err != nil {
+			return nil, err
+		}
+		r := row(glBal)
+		r.Debit += debit
+		r.Credit += credit
+		response.TotalDebit += debit
+		response.TotalCredit += credit
+	}
+
+	for _, r := range rowMap {
+		r.CloseBal = r.GetOpenBal() + r.GetDebit() - r.GetCredit()
+		response.Rows = append(response.Rows, r)
+	}
+	sort.Slice(response.Rows, func(i, j int) bool {
+		if response.Rows[i].GetCode() != response.Rows[j].GetCode() {
+			return response.Rows[i].GetCode() < response.Rows[j].GetCode()
+		}
+		return response.Rows[i].GetCcyId() < response.Rows[j].GetCcyId()
+	})
+
+	return response, nil
+}
+
+// GetBalanceSheet gets the assets, liabilities, and equity of a legal entity (rolled up with the entities
+// below it) on a date at both cost and market value. income and expenses to date are carried in equity as
+// retained earnings, and the difference between the market value and cost of securities as unrealized gains
+func (s *GlServiceImpl) GetBalanceSheet(ctx context.Context, request *v1.GetBalanceSheetRequest) (*v1.GetBalanceSheetResponse, error) {
+	dt, err := parseDt(request.GetDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid date %s", request.GetDt())
+	}
+	rpt, err := s.newReport(ctx, request.GetLeOrgId(), request.GetRptCcyId(), request.GetBasis())
+	if err != nil {
+		return nil, err
+	}
+	dtStr := dt.Format(config.APIFormats.DateFmt)
+
+	glBals, err := s.glStore.ListGlBals(ctx, rpt.leOrgIDs, "", dtStr, rpt.basis, false)
+	if err != nil {
+		return nil, err
+	}
+	mktVals, unrealized, err := s.mktVals(ctx, rpt, dtStr)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.GetBalanceSheetResponse{
+		LeOrgIds: rpt.leOrgIDs,
+		RptCcyId: rpt.rptCcyID,
+		Basis:    rpt.basis,
+	}
+	retained := 0.0
+	for _, glBal := range glBals {
+		glAcct := rpt.glAcct(glBal.GetGlAcctId())
+		section := roleSection(glAcct.GetRole())
+		bal, err := rpt.translate(ctx, dtStr, glBal.GetCcyId(), glBal.GetDebit()-glBal.GetCredit())
+		if err != nil {
+			return nil, err
+		}
+
+		line := rpt.line(section, glAcct, glBal.GetCcyId())
+		switch section {
+		case GlSection.Asset:
+			line.Cost = bal
+			line.MktVal = bal
+			if mktVal, ok := mktVals[glBal.GetGlAcctId()+"|"+glBal.GetCcyId()]; ok {
+				line.MktVal = mktVal
+			}
+			response.AssetsCost += line.GetCost()
+			response.AssetsMktVal += line.GetMktVal()
+		case GlSection.Liability:
+			line.Cost = -bal
+			line.MktVal = -bal
+			response.Liabs += line.GetCost()
+		case GlSection.Equity:
+			line.Cost = -bal
+			line.MktVal = -bal
+		default:
+			// income and expenses roll into retained earnings
+			retained -= bal
+			continue
+		}
+		response.Lines = append(response.Lines, line)
+	}
+	sortLines(response.Lines)
+
+	response.Lines = append(response.Lines,
+		&v1.ReportLine{
+			Section: GlSection.Equity,
+			Name:    "retained earnings",
+			CcyId:   rpt.rptCcyID,
+			Cost:    retained,
+			MktVal:  retained,
+		},
+		&v1.ReportLine{
+			Section: GlSection.Equity,
+			Name:    "unrealized gain",
+			CcyId:   rpt.rptCcyID,
+			MktVal:  unrealized,
+		})
+	for _, line := range response.Lines {
+		if line.GetSection() == GlSection.Equity {
+			response.EquityCost += line.GetCost()
+			response.EquityMktVal += line.GetMktVal()
+		}
+	}
+
+	return response, nil
+}
+
+// GetIncomeStatement gets the realized gains, income, fees, and change in unrealized gains of a legal
+// entity (rolled up with the entities below it) between two dates
+func (s *GlServiceImpl) GetIncomeStatement(ctx context.Context, request *v1.GetIncomeStatementRequest) (*v1.GetIncomeStatementResponse, error) {
+	start, end, err := dtRange(request.GetStartDt(), request.GetEndDt())
+	if err != nil {
+		return nil, err
+	}
+	rpt, err := s.newReport(ctx, request.GetLeOrgId(), request.GetRptCcyId(), request.GetBasis())
+	if err != nil {
+		return nil, err
+	}
+	prevDt := start.AddDate(0, 0, -1).Format(config.APIFormats.DateFmt)
+	endDt := end.Format(config.APIFormats.DateFmt)
+
+	glBals, err := s.glStore.ListGlBals(ctx, rpt.leOrgIDs, start.Format(config.APIFormats.DateFmt), endDt, rpt.basis, false)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.GetIncomeStatementResponse{
+		LeOrgIds: rpt.leOrgIDs,
+		RptCcyId: rpt.rptCcyID,
+		Basis:    rpt.basis,
+	}
+	for _, glBal := range glBals {
+		glAcct := rpt.glAcct(glBal.GetGlAcctId())
+		section := roleSection(glAcct.GetRole())
+		if section != GlSection.Income && section != GlSection.Expense {
+			continue
+		}
+		bal, err := rpt.translate(ctx, endDt, glBal.GetCcyId(), glBal.GetDebit()-glBal.GetCredit())
+		if err != nil {
+			return nil, err
+		}
+
+		line := rpt.line(section, glAcct, glBal.GetCcyId())
+		line.Cost = bal
+		if section == GlSection.Income {
+			line.Cost = -bal
+		}
+		line.MktVal = line.GetCost()
+		response.Lines = append(response.Lines, line)
+
+		switch glAcct.GetRole() {
+		case GlRole.RealizedGain:
+			response.RealizedGain += line.GetCost()
+		case GlRole.Income:
+			response.Income += line.GetCost()
+		case GlRole.Fee:
+			response.Fees += line.GetCost()
+		}
+	}
+	sortLines(response.Lines)
+
+	// unrealized gains at the end less those at the close of the day before the start
+	_, endUnrealized, err := s.mktVals(ctx, rpt, endDt)
+	if err != nil {
+		return nil, err
+	}
+	_, startUnrealized, err := s.mktVals(ctx, rpt, prevDt)
+	if err != nil {
+		return nil, err
+	}
+	response.UnrealizedChg = endUnrealized - startUnrealized
+	response.Lines = append(response.Lines, &v1.ReportLine{
+		Section: GlSection.Income,
+		Name:    "unrealized gain",
+		CcyId:   rpt.rptCcyID,
+		MktVal:  response.GetUnrealizedChg(),
+	})
+	response.NetIncome = response.GetRealizedGain() + response.GetIncome() - response.GetFees() + response.GetUnrealizedChg()
+
+	return response, nil
+}
+
+// report holds the legal entities, chart of accounts, reporting currency, and basis a report is built with
+type report struct {
+	leOrgIDs []string
+	rptCcyID string
+	basis    string
+	glAccts  map[string]*storage.GlAcct
+	valuer   *valuation.Valuer
+	fxRates  map[string]float64
+}
+
+// newReport sets up a report for a legal entity and the entities below it. all legal entities are reported
+// on if none is passed in, amounts are reported in the base currency if no reporting currency is, and
+// reports are on an accrual basis unless the cash basis is asked for
+func (s *GlServiceImpl) newReport(ctx context.Context, leOrgID string, rptCcyID string, basis string) (*report, error) {
+	switch basis {
+	case "":
+		basis = GlBasis.Accrual
+	case GlBasis.Cash, GlBasis.Accrual:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid basis %s", basis)
+	}
+	leOrgIDs, err := s.leOrgTree(ctx, leOrgID)
+	if err != nil {
+		return nil, err
+	}
+	glAccts, _, err := s.glStore.ListGlAccts(ctx, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	if rptCcyID == "" {
+		rptCcyID = s.valuer.BaseCcyID()
+	}
+
+	rpt := &report{
+		leOrgIDs: leOrgIDs,
+		rptCcyID: rptCcyID,
+		basis:    basis,
+		glAccts:  make(map[string]*storage.GlAcct, len(glAccts)),
+		valuer:   s.valuer,
+		fxRates:  make(map[string]float64),
+	}
+	for _, glAcct := range glAccts {
+		rpt.glAccts[glAcct.GetId()] = glAcct
+	}
+
+	return rpt, nil
+}
+
+// glAcct looks up a gl account in the report's chart of accounts
+func (r *report) glAcct(id string) *storage.GlAcct {
+	if glAcct, ok := r.glAccts[id]; ok {
+		return glAcct
+	}
+	return &storage.GlAcct{Id: id}
+}
+
+// line starts a report line for a gl account and currency
+func (r *report) line(section string, glAcct *storage.GlAcct, ccyID string) *v1.ReportLine {
+	return &v1.ReportLine{
+		Section:  section,
+		GlAcctId: glAcct.GetId(),
+		Code:     glAcct.GetCode(),
+		Name:     glAcct.GetName(),
+		Role:     glAcct.GetRole(),
+		CcyId:    ccyID,
+	}
+}
+
+// translate converts an amount in a currency into the reporting currency at the rate on a date
+func (r *report) translate(ctx context.Context, dt string, ccyID string, amt float64) (float64, error) {
+	if amt == 0 || ccyID == "" || ccyID == r.rptCcyID {
+		return amt, nil
+	}
+	key := ccyID + "|" + dt
+	rate, ok := r.fxRates[key]
+	if !ok {
+		var err error
+		rate, err = r.valuer.FxRate(ctx, ccyID, r.rptCcyID, dt)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return 0, status.Errorf(codes.FailedPrecondition, "no fx rate from %s to %s on %s", ccyID, r.rptCcyID, dt)
+			}
+			return 0, err
+		}
+		r.fxRates[key] = rate
+	}
+
+	return amt * rate, nil
+}
+
+// mktVals values the securities cost accounts on a date, returning the market value in the reporting
+// currency by gl account and currency along with the total unrealized gain. lots that can't be valued are
+// carried at cost
+func (s *GlServiceImpl) mktVals(ctx context.Context, rpt *report, dt string) (map[string]float64, float64, error) {
+	glBals, err := s.glStore.ListGlBals(ctx, rpt.leOrgIDs, "", dt, rpt.basis, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var secBals []*storage.GlBal
+	var lotIDs []string
+	for _, glBal := range glBals {
+		if rpt.glAcct(glBal.GetGlAcctId()).GetRole() != GlRole.SecuritiesCost {
+			continue
+		}
+		secBals = append(secBals, glBal)
+		if glBal.GetLotId() != "" {
+			lotIDs = append(lotIDs, glBal.GetLotId())
+		}
+	}
+
+	valMap := make(map[string]*valuation.Val)
+	if len(lotIDs) > 0 {
+		lotBals, err := s.lotStore.ListLotBals(ctx, dt, lotIDs)
+		if err != nil {
+			return nil, 0, err
+		}
+		vals, err := s.valuer.ValueLotBals(ctx, dt, rpt.rptCcyID, lotBals)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, val := range vals {
+			valMap[val.LotBal.GetLotId()] = val
+		}
+	}
+
+	mktVals := make(map[string]float64)
+	unrealized := 0.0
+	for _, glBal := range secBals {
+		cost, err := rpt.translate(ctx, dt, glBal.GetCcyId(), glBal.GetDebit()-glBal.GetCredit())
+		if err != nil {
+			return nil, 0, err
+		}
+		mktVal := cost
+		if val, ok := valMap[glBal.GetLotId()]; ok && val.Priced {
+			mktVal = val.RptMktVal
+		}
+		mktVals[glBal.GetGlAcctId()+"|"+glBal.GetCcyId()] += mktVal
+		unrealized += mktVal - cost
+	}
+
+	return mktVals, unrealized, nil
+}
+
+// leOrgTree gets a legal entity and all the entities below it in the org hierarchy. nil is returned if no
+// legal entity is passed in so reports cover all of them
+func (s *GlServiceImpl) leOrgTree(ctx context.Context, leOrgID string) ([]string, error) {
+	if leOrgID == "" {
+		return nil, nil
+	}
+
+	orgs, _, err := s.orgStore.ListOrgs(ctx, 0, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	children := make(map[string][]string)
+	for _, org := range orgs {
+		if org.GetId() == leOrgID {
+			found = true
+		}
+		if org.GetParentId() != "" {
+			children[org.GetParentId()] = append(children[org.GetParentId()], org.GetId())
+		}
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "org with id %s not found", leOrgID)
+	}
+
+	// walk down the hierarchy, guarding against cycles
+	leOrgIDs := []string{leOrgID}
+	seen := map[string]bool{leOrgID: true}
+	for i := 0; i < len(leOrgIDs); i++ {
+		for _, childID := range children[leOrgIDs[i]] {
+			if !seen[childID] {
+				seen[childID] = true
+				leOrgIDs = append(leOrgIDs, childID)
+			}
+		}
+	}
+
+	return leOrgIDs, nil
+}
+
+// sortLines orders report lines by gl account code and currency
+func sortLines(lines []*v1.ReportLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].GetCode() != lines[j].GetCode() {
+			return lines[i].GetCode() < lines[j].GetCode()
+		}
+		return lines[i].GetCcyId() < lines[j].GetCcyId()
+	})
+}
+
+// dtRange parses a start and end date, making sure they're in order
+func dtRange(startDt string, endDt string) (time.Time, time.Time, error) {
+	start, err := parseDt(startDt)
+	if err != nil {
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "invalid start date %s", startDt)
+	}
+	end, err := parseDt(endDt)
+	if err != nil {
+		return time.Time{}, time.Time{}, status.Errorf(codes.InvalidArgument, "invalid end date %s", endDt)
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, status.Error(codes.InvalidArgument, "end date is before the start date")
+	}
+
+	return start, end, nil
+}
+
+// parseDt parses an api date, ignoring any time portion
+func parseDt(dt string) (time.Time, error) {
+	if len(dt) > len(config.APIFormats.DateFmt) {
+		dt = dt[:len(config.APIFormats.DateFmt)]
+	}
+	t, err := time.Parse(config.APIFormats.DateFmt, dt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing date %s: %w", dt, err)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	fxStore "github.com/wolfinger/varangian/fx/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	glStore "github.com/wolfinger/varangian/gl/store"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	orgStore "github.com/wolfinger/varangian/org/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDB keeps the books of an org tree in memory. a database transaction snapshots the closed periods and
+// lock dates and puts the snapshot back if it fails, but lock dates set through a lock store that isn't bound
+// to the transaction commit on their own
+type fakeDB struct {
+	orgs     []*storage.Org
+	glAccts  []*storage.GlAcct
+	journals []*storage.Journal
+	periods  []*storage.GlPeriod
+	lockDts  map[string]string
+	unbound  map[string]string
+	failLock string
+}
+
+// newFakeDB creates books for org_a with org_b below it and org_c below that, along with an unrelated org_x
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		orgs: []*storage.Org{
+			{Id: "org_a"},
+			{Id: "org_b", ParentId: "org_a"},
+			{Id: "org_c", ParentId: "org_b"},
+			{Id: "org_x"},
+		},
+		glAccts: []*storage.GlAcct{
+			{Id: "gl_cash", Code: "1000", Name: "Cash", Role: GlRole.Cash},
+			{Id: "gl_cap", Code: "3000", Name: "Capital", Role: GlRole.Capital},
+			{Id: "gl_inc", Code: "4000", Name: "Income", Role: GlRole.Income},
+		},
+		lockDts: make(map[string]string),
+		unbound: make(map[string]string),
+	}
+}
+
+// post posts a journal debiting one gl account and crediting another
+func (db *fakeDB) post(leOrgID string, entryDt string, ccyID string, debitID string, creditID string, amt float64) {
+	db.journals = append(db.journals, &storage.Journal{
+		LeOrgId: leOrgID,
+		EntryDt: entryDt,
+		Lines: []*storage.JournalLine{
+			{GlAcctId: debitID, CcyId: ccyID, Debit: amt},
+			{GlAcctId: creditID, CcyId: ccyID, Credit: amt},
+		},
+	})
+}
+
+// closedThru gets the dates each legal entity is closed through
+func (db *fakeDB) closedThru() map[string]string {
+	closed := make(map[string]string)
+	for _, glPeriod := range db.periods {
+		if glPeriod.GetEndDt() > closed[glPeriod.GetLeOrgId()] {
+			closed[glPeriod.GetLeOrgId()] = glPeriod.GetEndDt()
+		}
+	}
+	return closed
+}
+
+// runInTx runs fn in a fake database transaction
+func (db *fakeDB) runInTx(fn func(tx *pg.Tx) error) error {
+	periods := append([]*storage.GlPeriod(nil), db.periods...)
+	lockDts := make(map[string]string, len(db.lockDts))
+	for leOrgID, lockDt := range db.lockDts {
+		lockDts[leOrgID] = lockDt
+	}
+	if err := fn(nil); err != nil {
+		db.periods = periods
+		db.lockDts = lockDts
+		for leOrgID, lockDt := range db.unbound {
+			db.lockDts[leOrgID] = lockDt
+		}
+		return err
+	}
+	return nil
+}
+
+// fakeGlStore keeps gl accounts, journals, and closed periods. it embeds the interface for the methods the
+// tests don't use
+type fakeGlStore struct {
+	glStore.Store
+	*fakeDB
+}
+
+func (f *fakeGlStore) ListGlAccts(ctx context.Context, pageSize int32, pageToken string) ([]*storage.GlAcct, string, error) {
+	return f.glAccts, "", nil
+}
+
+func (f *fakeGlStore) ListGlBals(ctx context.Context, leOrgIDs []string, startDt string, endDt string, basis string, byLot bool) ([]*storage.GlBal, error) {
+	leOrgs := make(map[string]bool)
+	for _, leOrgID := range leOrgIDs {
+		leOrgs[leOrgID] = true
+	}
+	balMap := make(map[string]*storage.GlBal)
+	var glBals []*storage.GlBal
+	for _, journal := range f.journals {
+		if len(leOrgs) > 0 && !leOrgs[journal.GetLeOrgId()] ||
+			startDt != "" && journal.GetEntryDt() < startDt || endDt != "" && journal.GetEntryDt() > endDt ||
+			journal.GetBasis() != "" && journal.GetBasis() != basis {
+			continue
+		}
+		for _, line := range journal.GetLines() {
+			key := line.GetGlAcctId() + "|" + line.GetCcyId()
+			glBal, ok := balMap[key]
+			if !ok {
+				glBal = &storage.GlBal{GlAcctId: line.GetGlAcctId(), CcyId: line.GetCcyId()}
+				balMap[key] = glBal
+				glBals = append(glBals, glBal)
+			}
+			glBal.Debit += line.GetDebit()
+			glBal.Credit += line.GetCredit()
+		}
+	}
+	return glBals, nil
+}
+
+func (f *fakeGlStore) ClosePeriod(ctx context.Context, leOrgIDs []string, endDt string) error {
+	for _, leOrgID := range leOrgIDs {
+		f.periods = append(f.periods, &storage.GlPeriod{LeOrgId: leOrgID, EndDt: endDt})
+	}
+	return nil
+}
+
+func (f *fakeGlStore) ClosedThru(ctx context.Context, leOrgID string) (string, error) {
+	return f.closedThru()[leOrgID], nil
+}
+
+func (f *fakeGlStore) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return f.runInTx(fn)
+}
+
+func (f *fakeGlStore) WithTx(tx *pg.Tx) glStore.Store {
+	return f
+}
+
+// fakeLockStore keeps a lock date per legal entity, failing to set the one in failLock
+type fakeLockStore struct {
+	lockStore.Store
+	*fakeDB
+	bound bool
+}
+
+func (f *fakeLockStore) ListLocks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Lock, string, error) {
+	var locks []*storage.Lock
+	for leOrgID, lockDt := range f.lockDts {
+		locks = append(locks, &storage.Lock{LeOrgId: leOrgID, LockDt: lockDt})
+	}
+	return locks, "", nil
+}
+
+func (f *fakeLockStore) SetLock(ctx context.Context, lock *storage.Lock) (*storage.Lock, error) {
+	if lock.GetLeOrgId() == f.failLock {
+		return nil, errors.New("setting lock failed")
+	}
+	f.lockDts[lock.GetLeOrgId()] = lock.GetLockDt()
+	if !f.bound {
+		f.unbound[lock.GetLeOrgId()] = lock.GetLockDt()
+	}
+	return lock, nil
+}
+
+func (f *fakeLockStore) WithTx(tx *pg.Tx) lockStore.Store {
+	return &fakeLockStore{fakeDB: f.fakeDB, bound: true}
+}
+
+// fakeOrgStore keeps the org tree
+type fakeOrgStore struct {
+	orgStore.Store
+	*fakeDB
+}
+
+func (f *fakeOrgStore) ListOrgs(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Org, string, error) {
+	return f.orgs, "", nil
+}
+
+// fakeFxStore keeps the rates stored directly, by currency pair and date
+type fakeFxStore struct {
+	fxStore.Store
+	rates map[string]float64
+}
+
+func (f *fakeFxStore) GetFxRate(ctx context.Context, fromID string, toID string, dt string, viaID string) (*storage.FxRate, error) {
+	rate, ok := f.rates[fromID+"/"+toID+"/"+dt]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "fx rate %s/%s not found on %s", fromID, toID, dt)
+	}
+	return &storage.FxRate{BaseCcyId: fromID, QuoteCcyId: toID, RateDt: dt, Rate: rate}, nil
+}
+
+// newFakeService creates a General Ledger service backed by a fake database, with eur rates into usd on the
+// dates the tests report on
+func newFakeService(db *fakeDB) *GlServiceImpl {
+	fx := &fakeFxStore{rates: map[string]float64{
+		"eur/usd/2021-01-20": 1.3,
+		"eur/usd/2021-02-28": 1.1,
+	}}
+	return NewService(&fakeGlStore{fakeDB: db}, &fakeOrgStore{fakeDB: db}, nil, &fakeLockStore{fakeDB: db},
+		valuation.NewValuer(nil, nil, fx, "usd"))
+}
+
+// findRow finds the trial balance row of a gl account in a currency
+func findRow(res *v1.GetTrialBalanceResponse, glAcctID string, ccyID string) *v1.TrialBalanceRow {
+	for _, row := range res.GetRows() {
+		if row.GetGlAcctId() == glAcctID && row.GetCcyId() == ccyID {
+			return row
+		}
+	}
+	return nil
+}
+
+func TestClosePeriod(t *testing.T) {
+	db := newFakeDB()
+	db.periods = []*storage.GlPeriod{{LeOrgId: "org_c", EndDt: "2021-03-31"}}
+	db.lockDts["org_a"] = "2021-01-31"
+	db.lockDts["org_b"] = "2021-12-31"
+	s := newFakeService(db)
+	ctx := context.Background()
+
+	// org_c is already closed through march, so closing the tree through february is rejected and nothing
+	// is closed for org_a or org_b either
+	if _, err := s.ClosePeriod(ctx, &v1.ClosePeriodRequest{LeOrgId: "org_a", EndDt: "2021-02-28"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("close before an entity below is closed through got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+	if len(db.periods) != 1 || db.lockDts["org_a"] != "2021-01-31" {
+		t.Errorf("rejected close got periods: %v, locks: %v, want them unchanged", db.periods, db.lockDts)
+	}
+
+	res, err := s.ClosePeriod(ctx, &v1.ClosePeriodRequest{LeOrgId: "org_a", EndDt: "2021-06-30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetLeOrgIds()) != 3 {
+		t.Errorf("closed le orgs got: %v, want org_a and the two below it", res.GetLeOrgIds())
+	}
+	closed := db.closedThru()
+	for _, leOrgID := range []string{"org_a", "org_b", "org_c"} {
+		if closed[leOrgID] != "2021-06-30" {
+			t.Errorf("%s closed through got: %s, want: 2021-06-30", leOrgID, closed[leOrgID])
+		}
+	}
+	if closed["org_x"] != "" {
+		t.Errorf("org_x closed through got: %s, want it left open", closed["org_x"])
+	}
+
+	// lock dates move up to the close but never back
+	want := map[string]string{"org_a": "2021-06-30", "org_b": "2021-12-31", "org_c": "2021-06-30"}
+	for leOrgID, lockDt := range want {
+		if db.lockDts[leOrgID] != lockDt {
+			t.Errorf("%s lock date got: %s, want: %s", leOrgID, db.lockDts[leOrgID], lockDt)
+		}
+	}
+
+	if _, err = s.ClosePeriod(ctx, &v1.ClosePeriodRequest{EndDt: "2021-06-30"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("close without a legal entity got: %v, want: %v", err, codes.InvalidArgument)
+	}
+	if _, err = s.ClosePeriod(ctx, &v1.ClosePeriodRequest{LeOrgId: "org_z", EndDt: "2021-06-30"}); status.Code(err) != codes.NotFound {
+		t.Errorf("close of an unknown legal entity got: %v, want: %v", err, codes.NotFound)
+	}
+}
+
+func TestClosePeriodLockFails(t *testing.T) {
+	db := newFakeDB()
+	db.lockDts["org_a"] = "2021-01-31"
+	db.failLock = "org_c"
+	s := newFakeService(db)
+
+	// the lock dates are moved in the same transaction as the periods are closed, so failing to move
+	// org_c's rolls back the close and org_a's lock date
+	if _, err := s.ClosePeriod(context.Background(), &v1.ClosePeriodRequest{LeOrgId: "org_a", EndDt: "2021-06-30"}); err == nil {
+		t.Fatal("close with a failed lock got no error")
+	}
+	if len(db.periods) != 0 {
+		t.Errorf("failed close got periods: %v, want none", db.periods)
+	}
+	if db.lockDts["org_a"] != "2021-01-31" || db.lockDts["org_b"] != "" {
+		t.Errorf("failed close got locks: %v, want them unchanged", db.lockDts)
+	}
+}
+
+func TestGetTrialBalance(t *testing.T) {
+	db := newFakeDB()
+	db.post("org_a", "2021-01-15", "usd", "gl_cash", "gl_cap", 1000)
+	db.post("org_c", "2021-01-20", "eur", "gl_cash", "gl_cap", 100)
+	db.post("org_b", "2021-02-10", "usd", "gl_cash", "gl_inc", 50)
+	db.post("org_x", "2021-02-10", "usd", "gl_cash", "gl_inc", 999)
+	s := newFakeService(db)
+	ctx := context.Background()
+
+	// org_b and org_c roll up into org_a through their parent_id, while org_x doesn't
+	res, err := s.GetTrialBalance(ctx, &v1.GetTrialBalanceRequest{LeOrgId: "org_a", StartDt: "2021-02-01", EndDt: "2021-02-28"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetLeOrgIds()) != 3 || res.GetRptCcyId() != "usd" || res.GetBasis() != GlBasis.Accrual {
+		t.Errorf("trial balance got le orgs: %v in %s on %s basis, want org_a and the two below it in usd on accrual basis",
+			res.GetLeOrgIds(), res.GetRptCcyId(), res.GetBasis())
+	}
+	if res.GetTotalDebit() != 50 || res.GetTotalCredit() != 50 {
+		t.Errorf("trial balance totals got: %v/%v, want org_b's 50 without org_x's", res.GetTotalDebit(), res.GetTotalCredit())
+	}
+	if row := findRow(res, "gl_cash", "usd"); row == nil || row.GetOpenBal() != 1000 || row.GetDebit() != 50 || row.GetCloseBal() != 1050 ||
+		row.GetCode() != "1000" {
+		t.Errorf("usd cash row got: %v, want 1000 opening plus 50", row)
+	}
+	if row := findRow(res, "gl_inc", "usd"); row == nil || row.GetOpenBal() != 0 || row.GetCredit() != 50 || row.GetCloseBal() != -50 {
+		t.Errorf("usd income row got: %v, want 50 credited", row)
+	}
+
+	// opening balances in eur are translated at the rate on the end date, not the date they were posted
+	if row := findRow(res, "gl_cash", "eur"); row == nil || math.Abs(row.GetOpenBal()-110) > 1e-9 || math.Abs(row.GetCloseBal()-110) > 1e-9 {
+		t.Errorf("eur cash row got: %v, want 100 eur opening at 1.1", row)
+	}
+	if row := findRow(res, "gl_cap", "eur"); row == nil || math.Abs(row.GetOpenBal()+110) > 1e-9 {
+		t.Errorf("eur capital row got: %v, want -100 eur opening at 1.1", row)
+	}
+	if len(res.GetRows()) != 5 || res.GetRows()[0].GetCode() != "1000" || res.GetRows()[0].GetCcyId() != "eur" {
+		t.Errorf("trial balance rows got: %v, want 5 ordered by code and currency", res.GetRows())
+	}
+
+	// without a rate on the end date, the opening balances can't be translated
+	if _, err = s.GetTrialBalance(ctx, &v1.GetTrialBalanceRequest{LeOrgId: "org_a", StartDt: "2021-02-01", EndDt: "2021-02-27"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("trial balance without an fx rate got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+}
@@ -0,0 +1,675 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/dbtx"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fieldmask_utils "github.com/mennanov/fieldmask-utils"
+)
+
+const (
+	// balanceTol is the tolerance debits and credits need to agree within for a journal to balance
+	balanceTol = 1e-6
+)
+
+// Store interface used for implementing the General Ledger store
+type Store interface {
+	GetGlAcct(ctx context.Context, id string) (*storage.GlAcct, error)
+	ListGlAccts(ctx context.Context, pageSize int32, pageToken string) ([]*storage.GlAcct, string, error)
+	UpdateGlAcct(ctx context.Context, glAcct *storage.GlAcct, fieldMask []string) error
+	CreateGlAcct(ctx context.Context, glAcct *storage.GlAcct) (*storage.GlAcct, error)
+	DeleteGlAcct(ctx context.Context, id string) error
+	ResolveGlAccts(ctx context.Context, leOrgID string) (map[string]string, error)
+	GetJournal(ctx context.Context, id string) (*storage.Journal, error)
+	ListJournals(ctx context.Context, pageSize int32, pageToken string, txnID string, leOrgID string, startDt string, endDt string) ([]*storage.Journal, string, error)
+	PostJournal(ctx context.Context, journal *storage.Journal) (*storage.Journal, error)
+	ListGlBals(ctx context.Context, leOrgIDs []string, startDt string, endDt string, basis string, byLot bool) ([]*storage.GlBal, error)
+	ClosePeriod(ctx context.Context, leOrgIDs []string, endDt string) error
+	ListGlPeriods(ctx context.Context, pageSize int32, pageToken string, leOrgID string) ([]*storage.GlPeriod, string, error)
+	ClosedThru(ctx context.Context, leOrgID string) (string, error)
+	RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error
+	WithTx(tx *pg.Tx) Store
+}
+
+// NewStore encapsulates General Ledger database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn dbtx.Conn
+}
+
+var (
+	// glAcctKeys are the keys gl accounts are listed in order of
+	glAcctKeys = []page.Key{{Col: "code"}, {Col: "id"}}
+
+	// journalKeys are the keys journals are listed in order of
+	journalKeys = []page.Key{{Col: "entry_dt"}, {Col: "id"}}
+
+	// glPeriodKeys are the keys gl periods are listed in order of
+	glPeriodKeys = []page.Key{{Col: "le_org_id"}, {Col: "end_dt"}}
+)
+
+// RunInTransaction runs fn in a database transaction that other stores can be bound to with WithTx
+func (s *storeImpl) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return s.conn.RunInTransaction(ctx, fn)
+}
+
+// WithTx gets a copy of the store bound to a database transaction, which its changes commit with
+func (s *storeImpl) WithTx(tx *pg.Tx) Store {
+	return &storeImpl{conn: dbtx.Shared(tx)}
+}
+
+// encodeGlAcct converts the vids of a gl account to vxids
+func encodeGlAcct(glAcct *storage.GlAcct) error {
+	var err error
+	glAcct.Id, err = vxid.Encode(glAcct.GetId(), vxid.PfxMap.GlAcct)
+	if err != nil {
+		return err
+	}
+	glAcct.LeOrgId, err = vxid.Encode(glAcct.GetLeOrgId(), vxid.PfxMap.Organization)
+	if err != nil {
+		return err
+	}
+	glAcct.ParentId, err = vxid.Encode(glAcct.GetParentId(), vxid.PfxMap.GlAcct)
+
+	return err
+}
+
+// decodeGlAcct converts the vxids of a gl account to vids
+func decodeGlAcct(glAcct *storage.GlAcct) error {
+	var err error
+	glAcct.Id, err = vxid.Decode(glAcct.GetId())
+	if err != nil {
+		return err
+	}
+	glAcct.LeOrgId, err = vxid.Decode(glAcct.GetLeOrgId())
+	if err != nil {
+		return err
+	}
+	glAcct.ParentId, err = vxid.Decode(glAcct.GetParentId())
+
+	return err
+}
+
+// encodeJournal converts the vids of a journal and its lines to vxids
+func encodeJournal(journal *storage.Journal) error {
+	var err error
+	journal.Id, err = vxid.Encode(journal.GetId(), vxid.PfxMap.Journal)
+	if err != nil {
+		return err
+	}
+	journal.TxnId, err = vxid.Encode(journal.GetTxnId(), vxid.PfxMap.Transaction)
+	if err != nil {
+		return err
+	}
+	journal.LeOrgId, err = vxid.Encode(journal.GetLeOrgId(), vxid.PfxMap.Organization)
+	if err != nil {
+		return err
+	}
+	journal.AcctId, err = vxid.Encode(journal.GetAcctId(), vxid.PfxMap.Account)
+	if err != nil {
+		return err
+	}
+	journal.PortId, err = vxid.Encode(journal.GetPortId(), vxid.PfxMap.Portfolio)
+	if err != nil {
+		return err
+	}
+	journal.StratId, err = vxid.Encode(journal.GetStratId(), vxid.PfxMap.Strategy)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range journal.GetLines() {
+		line.JournalId = journal.GetId()
+		line.GlAcctId, err = vxid.Encode(line.GetGlAcctId(), vxid.PfxMap.GlAcct)
+		if err != nil {
+			return err
+		}
+		line.LotId, err = vxid.Encode(line.GetLotId(), vxid.PfxMap.Lot)
+		if err != nil {
+			return err
+		}
+		line.CcyId, err = vxid.Encode(line.GetCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetGlAcct gets a gl account from the General Ledger store
+func (s *storeImpl) GetGlAcct(ctx context.Context, id string) (*storage.GlAcct, error) {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var glAcct storage.GlAcct
+	err = s.conn.ModelContext(ctx, &glAcct).Where("id = ?", vid).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "gl acct with id %s not found", id)
+		}
+		return nil, err
+	}
+
+	// convert vids to vxids
+	if err = encodeGlAcct(&glAcct); err != nil {
+		return nil, err
+	}
+
+	return &glAcct, nil
+}
+
+// ListGlAccts lists a page of the chart of accounts from the General Ledger store in order of code. a page size
+// of 0 lists every gl account
+func (s *storeImpl) ListGlAccts(ctx context.Context, pageSize int32, pageToken string) ([]*storage.GlAcct, string, error) {
+	var glAccts []*storage.GlAcct
+	p := page.NewPager(pageSize, glAcctKeys, "")
+	q, err := p.Apply(s.conn.ModelContext(ctx, &glAccts), pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing gl accts: %w", err)
+	}
+
+	// the next page starts after the last gl account of this one
+	var nextPageToken string
+	n, more := p.Next(len(glAccts))
+	glAccts = glAccts[:n]
+	if more {
+		nextPageToken = p.Token(glAccts[n-1].GetCode(), glAccts[n-1].GetId())
+	}
+
+	for _, glAcct := range glAccts {
+		// convert vids to vxids
+		if err = encodeGlAcct(glAcct); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return glAccts, nextPageToken, nil
+}
+
+// UpdateGlAcct updates a gl account via the General Ledger store
+func (s *storeImpl) UpdateGlAcct(ctx context.Context, glAcct *storage.GlAcct, fieldMask []string) error {
+	var err error
+	tgtGlAcct := glAcct
+
+	// copy over only the fields passed in from the field mask (if provided)
+	if fieldMask != nil {
+
+		// get original gl acct object to update
+		tgtGlAcct, err = s.GetGlAcct(ctx, glAcct.GetId())
+		if err != nil {
+			return err
+		}
+
+		mask, err := fieldmask_utils.MaskFromPaths(fieldMask, casing.Camel)
+		if err != nil {
+			return err
+		}
+		fieldmask_utils.StructToStruct(mask, glAcct, tgtGlAcct)
+	}
+
+	// convert vxids to vids
+	vGlAcct := &storage.GlAcct{
+		Id:       tgtGlAcct.GetId(),
+		Code:     tgtGlAcct.GetCode(),
+		Name:     tgtGlAcct.GetName(),
+		Role:     tgtGlAcct.GetRole(),
+		LeOrgId:  tgtGlAcct.GetLeOrgId(),
+		ParentId: tgtGlAcct.GetParentId(),
+		Version:  glAcct.GetVersion() + 1,
+	}
+	if err = decodeGlAcct(vGlAcct); err != nil {
+		return err
+	}
+
+	// update gl acct in datastore if it's still at the version the caller read
+	if err = version.Update(ctx, s.conn, vGlAcct, glAcct.GetVersion(), "gl acct "+glAcct.GetId()); err != nil {
+		return err
+	}
+	glAcct.Version = vGlAcct.GetVersion()
+
+	return nil
+}
+
+// CreateGlAcct creates a new gl account via the General Ledger store
+func (s *storeImpl) CreateGlAcct(ctx context.Context, glAcct *storage.GlAcct) (*storage.GlAcct, error) {
+	// convert vxids to vids
+	if err := decodeGlAcct(glAcct); err != nil {
+		return nil, err
+	}
+
+	// insert gl acct into datastore
+	if _, err := s.conn.ModelContext(ctx, glAcct).Insert(); err != nil {
+		return nil, fmt.Errorf("creating gl acct: %w", err)
+	}
+
+	// convert vids to vxids
+	if err := encodeGlAcct(glAcct); err != nil {
+		return nil, err
+	}
+
+	return glAcct, nil
+}
+
+// DeleteGlAcct removes a gl account from the General Ledger store. accounts with journal lines posted
+// to them can't be removed
+func (s *storeImpl) DeleteGlAcct(ctx context.Context, id string) error {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return err
+	}
+
+	n, err := s.conn.ModelContext(ctx, (*storage.JournalLine)(nil)).Where("gl_acct_id = ?", vid).Count()
+	if err != nil {
+		return fmt.Errorf("counting journal lines for gl acct %s: %w", id, err)
+	}
+	if n > 0 {
+		return status.Errorf(codes.FailedPrecondition, "gl acct %s has journal lines posted to it", id)
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.GlAcct)(nil)).Where("id = ?", vid).Delete(); err != nil {
+		return fmt.Errorf("deleting gl acct %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// ResolveGlAccts maps each role in the chart of accounts to the gl account vxid used for a legal entity.
+// accounts set up for the legal entity override accounts without a legal entity
+func (s *storeImpl) ResolveGlAccts(ctx context.Context, leOrgID string) (map[string]string, error) {
+	leVid, err := vxid.Decode(leOrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var glAccts []*storage.GlAcct
+	q := s.conn.ModelContext(ctx, &glAccts).DistinctOn("role")
+	if leVid != "" {
+		q.Where("le_org_id = ? OR le_org_id IS NULL", leVid).OrderExpr("role, le_org_id NULLS LAST, code")
+	} else {
+		q.Where("le_org_id IS NULL").OrderExpr("role, code")
+	}
+	if err = q.Select(); err != nil {
+		return nil, fmt.Errorf("resolving gl accts: %w", err)
+	}
+
+	roles := make(map[string]string)
+	for _, glAcct := range glAccts {
+		roles[glAcct.GetRole()], err = vxid.Encode(glAcct.GetId(), vxid.PfxMap.GlAcct)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return roles, nil
+}
+
+// GetJournal gets a journal and its lines from the General Ledger store
+func (s *storeImpl) GetJournal(ctx context.Context, id string) (*storage.Journal, error) {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var journal storage.Journal
+	err = s.conn.ModelContext(ctx, &journal).ColumnExpr("*, entry_dt::date").Where("id = ?", vid).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "journal with id %s not found", id)
+		}
+		return nil, err
+	}
+	err = s.conn.ModelContext(ctx, &journal.Lines).Where("journal_id = ?", vid).Order("line_no").Select()
+	if err != nil {
+		return nil, fmt.Errorf("listing journal lines: %w", err)
+	}
+
+	// convert vids to vxids
+	if err = encodeJournal(&journal); err != nil {
+		return nil, err
+	}
+
+	return &journal, nil
+}
+
+// ListJournals lists a page of journals and their lines from the General Ledger store in order of entry date,
+// optionally for a transaction, legal entity, and date range. a page size of 0 lists every journal
+func (s *storeImpl) ListJournals(ctx context.Context, pageSize int32, pageToken string, txnID string, leOrgID string, startDt string, endDt string) ([]*storage.Journal, string, error) {
+	var journals []*storage.Journal
+
+	q := s.conn.ModelContext(ctx, &journals).ColumnExpr("*, entry_dt::date")
+	if txnID != "" {
+		vid, err := vxid.Decode(txnID)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("txn_id = ?", vid)
+	}
+	if leOrgID != "" {
+		vid, err := vxid.Decode(leOrgID)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("le_org_id = ?", vid)
+	}
+	if startDt != "" {
+		q.Where("entry_dt >= ?", startDt)
+	}
+	if endDt != "" {
+		q.Where("entry_dt <= ?", endDt)
+	}
+	p := page.NewPager(pageSize, journalKeys, page.Query(txnID, leOrgID, startDt, endDt)).Dates("entry_dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing journals: %w", err)
+	}
+
+	// the next page starts after the last journal of this one
+	var nextPageToken string
+	n, more := p.Next(len(journals))
+	journals = journals[:n]
+	if more {
+		nextPageToken = p.Token(journals[n-1].GetEntryDt(), journals[n-1].GetId())
+	}
+	if len(journals) == 0 {
+		return journals, "", nil
+	}
+
+	// get the lines for all journals in one pass
+	vids := make([]string, len(journals))
+	for i, journal := range journals {
+		vids[i] = journal.GetId()
+	}
+	var lines []*storage.JournalLine
+	err = s.conn.ModelContext(ctx, &lines).Where("journal_id IN (?)", pg.In(vids)).Order("journal_id", "line_no").Select()
+	if err != nil {
+		return nil, "", fmt.Errorf("listing journal lines: %w", err)
+	}
+	lineMap := make(map[string][]*storage.JournalLine)
+	for _, line := range lines {
+		lineMap[line.GetJournalId()] = append(lineMap[line.GetJournalId()], line)
+	}
+
+	for _, journal := range journals {
+		journal.Lines = lineMap[journal.GetId()]
+
+		// convert vids to vxids
+		if err = encodeJournal(journal); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return journals, nextPageToken, nil
+}
+
+// PostJournal posts a journal and its lines to the General Ledger store in a single database transaction.
+// debits and credits need to balance in each currency
+func (s *storeImpl) PostJournal(ctx context.Context, journal *storage.Journal) (*storage.Journal, error) {
+	if len(journal.GetLines()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "journal has no lines")
+	}
+	bals := make(map[string]float64)
+	for _, line := range journal.GetLines() {
+		bals[line.GetCcyId()] += line.GetDebit() - line.GetCredit()
+	}
+	for ccyID, bal := range bals {
+		if math.Abs(bal) > balanceTol {
+			return nil, status.Errorf(codes.FailedPrecondition, "journal for txn %s out of balance by %f in ccy %s", journal.GetTxnId(), bal, ccyID)
+		}
+	}
+
+	// convert vxids to vids
+	var err error
+	vJournal := &storage.Journal{
+		EntryDt: journal.GetEntryDt(),
+		Memo:    journal.GetMemo(),
+		Basis:   journal.GetBasis(),
+	}
+	vJournal.TxnId, err = vxid.Decode(journal.GetTxnId())
+	if err != nil {
+		return nil, err
+	}
+	vJournal.LeOrgId, err = vxid.Decode(journal.GetLeOrgId())
+	if err != nil {
+		return nil, err
+	}
+	vJournal.AcctId, err = vxid.Decode(journal.GetAcctId())
+	if err != nil {
+		return nil, err
+	}
+	vJournal.PortId, err = vxid.Decode(journal.GetPortId())
+	if err != nil {
+		return nil, err
+	}
+	vJournal.StratId, err = vxid.Decode(journal.GetStratId())
+	if err != nil {
+		return nil, err
+	}
+	vLines := make([]*storage.JournalLine, len(journal.GetLines()))
+	for i, line := range journal.GetLines() {
+		vLine := &storage.JournalLine{
+			LineNo: int32(i + 1),
+			Debit:  line.GetDebit(),
+			Credit: line.GetCredit(),
+		}
+		vLine.GlAcctId, err = vxid.Decode(line.GetGlAcctId())
+		if err != nil {
+			return nil, err
+		}
+		vLine.LotId, err = vxid.Decode(line.GetLotId())
+		if err != nil {
+			return nil, err
+		}
+		vLine.CcyId, err = vxid.Decode(line.GetCcyId())
+		if err != nil {
+			return nil, err
+		}
+		vLines[i] = vLine
+	}
+
+	// insert journal and its lines into datastore
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// journals can't be posted into a closed period
+		if vJournal.GetLeOrgId() != "" {
+			n, err := tx.ModelContext(ctx, (*storage.GlPeriod)(nil)).Where("le_org_id = ?", vJournal.GetLeOrgId()).Where("end_dt >= ?", vJournal.GetEntryDt()).Count()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				return status.Errorf(codes.FailedPrecondition, "period for %s is closed", vJournal.GetEntryDt())
+			}
+		}
+
+		if _, err := tx.ModelContext(ctx, vJournal).Insert(); err != nil {
+			return err
+		}
+		for _, vLine := range vLines {
+			vLine.JournalId = vJournal.GetId()
+		}
+		_, err := tx.ModelContext(ctx, &vLines).Insert()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("posting journal for txn %s: %w", journal.GetTxnId(), err)
+	}
+
+	// convert vids to vxids
+	journal.Id, err = vxid.Encode(vJournal.GetId(), vxid.PfxMap.Journal)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range journal.GetLines() {
+		line.JournalId = journal.GetId()
+		line.LineNo = int32(i + 1)
+	}
+
+	return journal, nil
+}
+
+// ListGlBals sums journal lines by gl account and currency (and lot if byLot is set) for a set of legal
+// entities between two dates (inclusive). no start date sums from the beginning of time. if a basis is
+// passed in, journals for the other basis are left out
+func (s *storeImpl) ListGlBals(ctx context.Context, leOrgIDs []string, startDt string, endDt string, basis string, byLot bool) ([]*storage.GlBal, error) {
+	var glBals []*storage.GlBal
+
+	cols := "journal_line.gl_acct_id, journal_line.ccy_id"
+	if byLot {
+		cols += ", journal_line.lot_id"
+	}
+	q := s.conn.ModelContext(ctx, (*storage.JournalLine)(nil)).
+		ColumnExpr(cols + ", sum(journal_line.debit) AS debit, sum(journal_line.credit) AS credit").
+		Join("INNER JOIN journals AS j ON j.id = journal_line.journal_id").
+		GroupExpr(cols)
+	if len(leOrgIDs) > 0 {
+		vids, err := vxid.Decodes(leOrgIDs)
+		if err != nil {
+			return nil, err
+		}
+		q.Where("j.le_org_id IN (?)", pg.In(vids))
+	}
+	if startDt != "" {
+		q.Where("j.entry_dt >= ?", startDt)
+	}
+	if endDt != "" {
+		q.Where("j.entry_dt <= ?", endDt)
+	}
+	if basis != "" {
+		q.Where("coalesce(j.basis, '') IN ('', ?)", basis)
+	}
+	if err := q.Select(&glBals); err != nil {
+		return nil, fmt.Errorf("listing gl bals: %w", err)
+	}
+
+	var err error
+	for _, glBal := range glBals {
+		// convert vids to vxids
+		glBal.GlAcctId, err = vxid.Encode(glBal.GetGlAcctId(), vxid.PfxMap.GlAcct)
+		if err != nil {
+			return nil, err
+		}
+		glBal.LotId, err = vxid.Encode(glBal.GetLotId(), vxid.PfxMap.Lot)
+		if err != nil {
+			return nil, err
+		}
+		glBal.CcyId, err = vxid.Encode(glBal.GetCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return glBals, nil
+}
+
+// ClosePeriod closes the books through a date for a set of legal entities via the General Ledger store
+func (s *storeImpl) ClosePeriod(ctx context.Context, leOrgIDs []string, endDt string) error {
+	if len(leOrgIDs) == 0 {
+		return nil
+	}
+	vids, err := vxid.Decodes(leOrgIDs)
+	if err != nil {
+		return err
+	}
+
+	glPeriods := make([]*storage.GlPeriod, len(vids))
+	for i, vid := range vids {
+		glPeriods[i] = &storage.GlPeriod{
+			LeOrgId: vid,
+			EndDt:   endDt,
+		}
+	}
+
+	_, err = s.conn.ModelContext(ctx, &glPeriods).Value("closed_at", "now()").OnConflict("DO NOTHING").Insert()
+	if err != nil {
+		return fmt.Errorf("closing period through %s: %w", endDt, err)
+	}
+
+	return nil
+}
+
+// ListGlPeriods lists a page of the closed periods for a legal entity from the General Ledger store in order of
+// legal entity and end date. a page size of 0 lists every period
+func (s *storeImpl) ListGlPeriods(ctx context.Context, pageSize int32, pageToken string, leOrgID string) ([]*storage.GlPeriod, string, error) {
+	var glPeriods []*storage.GlPeriod
+
+	q := s.conn.ModelContext(ctx, &glPeriods).ColumnExpr("*, end_dt::date")
+	if leOrgID != "" {
+		vid, err := vxid.Decode(leOrgID)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("le_org_id = ?", vid)
+	}
+	p := page.NewPager(pageSize, glPeriodKeys, page.Query(leOrgID)).Dates("end_dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing gl periods: %w", err)
+	}
+
+	// the next page starts after the last period of this one
+	var nextPageToken string
+	n, more := p.Next(len(glPeriods))
+	glPeriods = glPeriods[:n]
+	if more {
+		nextPageToken = p.Token(glPeriods[n-1].GetLeOrgId(), glPeriods[n-1].GetEndDt())
+	}
+
+	for _, glPeriod := range glPeriods {
+		// convert vids to vxids
+		glPeriod.LeOrgId, err = vxid.Encode(glPeriod.GetLeOrgId(), vxid.PfxMap.Organization)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return glPeriods, nextPageToken, nil
+}
+
+// ClosedThru gets the date a legal entity's books are closed through, or an empty string if no period
+// has been closed
+func (s *storeImpl) ClosedThru(ctx context.Context, leOrgID string) (string, error) {
+	vid, err := vxid.Decode(leOrgID)
+	if err != nil {
+		return "", err
+	}
+	if vid == "" {
+		return "", nil
+	}
+
+	var endDt string
+	err = s.conn.ModelContext(ctx, (*storage.GlPeriod)(nil)).ColumnExpr("max(end_dt)::date::text").Where("le_org_id = ?", vid).Select(pg.Scan(&endDt))
+	if err != nil && err != pg.ErrNoRows {
+		return "", fmt.Errorf("getting closed thru date: %w", err)
+	}
+
+	return endDt, nil
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/google/uuid"
+	"github.com/wolfinger/varangian/generated/storage"
+)
+
+// Store interface used for implementing the Idempotency Key store
+type Store interface {
+	ReserveIdemKey(ctx context.Context, idemKey *storage.IdemKey, retention time.Duration, lease time.Duration) (*storage.IdemKey, bool, error)
+	SaveIdemKey(ctx context.Context, key string, leaseID string, resp []byte) error
+	DeleteIdemKey(ctx context.Context, key string, leaseID string) error
+	PurgeIdemKeys(ctx context.Context, retention time.Duration) (int, error)
+}
+
+// NewStore encapsulates Idempotency Key database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn *pg.DB
+}
+
+// ReserveIdemKey records a key before its request runs, leasing it to the request for the lease passed in.
+// if the key is already recorded and hasn't outlived the retention window, the recorded key is returned
+// instead and nothing is reserved, unless its request stopped without a response (e.g. the server running
+// it went down) and its lease ran out, in which case it's leased to this request. each lease gets its own
+// lease id, returned in the reserved key, which the request has to pass to save its response or release the key
+func (s *storeImpl) ReserveIdemKey(ctx context.Context, idemKey *storage.IdemKey, retention time.Duration, lease time.Duration) (*storage.IdemKey, bool, error) {
+	// a key past the retention window is free to be used again
+	_, err := s.conn.ModelContext(ctx, (*storage.IdemKey)(nil)).
+		Where("key = ?", idemKey.GetKey()).
+		Where("created_at < now() - ? * interval '1 second'", retention.Seconds()).
+		Delete()
+	if err != nil {
+		return nil, false, fmt.Errorf("expiring idempotency key %s: %w", idemKey.GetKey(), err)
+	}
+
+	idemKey.LeaseId = uuid.New().String()
+	res, err := s.conn.ModelContext(ctx, idemKey).
+		Value("created_at", "now()").
+		Value("reserved_until", "now() + ? * interval '1 second'", lease.Seconds()).
+		OnConflict("DO NOTHING").
+		Insert()
+	if err != nil {
+		return nil, false, fmt.Errorf("reserving idempotency key %s: %w", idemKey.GetKey(), err)
+	}
+	if res.RowsAffected() > 0 {
+		return idemKey, true, nil
+	}
+
+	// take over a key for the same request whose lease ran out before it got a response
+	res, err = s.conn.ModelContext(ctx, (*storage.IdemKey)(nil)).
+		Set("reserved_until = now() + ? * interval '1 second'", lease.Seconds()).
+		Set("lease_id = ?", idemKey.GetLeaseId()).
+		Where("key = ?", idemKey.GetKey()).
+		Where("req_hash = ?", idemKey.GetReqHash()).
+		Where("resp IS NULL").
+		Where("reserved_until < now()").
+		Update()
+	if err != nil {
+		return nil, false, fmt.Errorf("taking over idempotency key %s: %w", idemKey.GetKey(), err)
+	}
+	if res.RowsAffected() > 0 {
+		return idemKey, true, nil
+	}
+
+	var existing storage.IdemKey
+	err = s.conn.ModelContext(ctx, &existing).Where("key = ?", idemKey.GetKey()).Select()
+	if err != nil {
+		// the key was released between the insert and the select, so it's free again
+		if err == pg.ErrNoRows {
+			return s.ReserveIdemKey(ctx, idemKey, retention, lease)
+		}
+		return nil, false, fmt.Errorf("getting idempotency key %s: %w", idemKey.GetKey(), err)
+	}
+
+	return &existing, false, nil
+}
+
+// SaveIdemKey records the response of a reserved key's request. a request whose key was taken over by a retry
+// no longer holds the key's lease, so its response isn't recorded and the retry's stands
+func (s *storeImpl) SaveIdemKey(ctx context.Context, key string, leaseID string, resp []byte) error {
+	res, err := s.conn.ModelContext(ctx, (*storage.IdemKey)(nil)).
+		Set("resp = ?", resp).
+		Where("key = ?", key).
+		Where("lease_id = ?", leaseID).
+		Update()
+	if err != nil {
+		return fmt.Errorf("saving idempotency key %s: %w", key, err)
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("saving idempotency key %s: lease %s was taken over", key, leaseID)
+	}
+
+	return nil
+}
+
+// DeleteIdemKey releases a key, so its request can be run again. only the request holding the key's lease can
+// release it, so a request whose key was taken over leaves the retry's lease alone
+func (s *storeImpl) DeleteIdemKey(ctx context.Context, key string, leaseID string) error {
+	_, err := s.conn.ModelContext(ctx, (*storage.IdemKey)(nil)).
+		Where("key = ?", key).
+		Where("lease_id = ?", leaseID).
+		Delete()
+	if err != nil {
+		return fmt.Errorf("deleting idempotency key %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// PurgeIdemKeys removes the keys that have outlived the retention window, returning how many were removed
+func (s *storeImpl) PurgeIdemKeys(ctx context.Context, retention time.Duration) (int, error) {
+	res, err := s.conn.ModelContext(ctx, (*storage.IdemKey)(nil)).
+		Where("created_at < now() - ? * interval '1 second'", retention.Seconds()).
+		Delete()
+	if err != nil {
+		return 0, fmt.Errorf("purging idempotency keys: %w", err)
+	}
+
+	return res.RowsAffected(), nil
+}
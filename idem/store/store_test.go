@@ -0,0 +1,100 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+)
+
+// testStore connects to the database in TEST_DB_CONN_STR, skipping the test if it isn't set. keys are
+// written to a temp table that shadows the real one, so the pool is kept to a single connection
+func testStore(t *testing.T) *storeImpl {
+	connStr := os.Getenv("TEST_DB_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+
+	opt, err := pg.ParseURL(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.PoolSize = 1
+
+	conn := pg.Connect(opt)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Exec(`CREATE TEMP TABLE idem_keys (
+		key text PRIMARY KEY,
+		req_hash text NOT NULL,
+		resp bytea,
+		created_at timestamptz NOT NULL,
+		reserved_until timestamptz NOT NULL,
+		lease_id text NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &storeImpl{conn: conn}
+}
+
+func TestIdemKeys(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t)
+	lease := 50 * time.Millisecond
+
+	orig, reserved, err := s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_a", ReqHash: "hash_a"}, time.Hour, lease)
+	if err != nil || !reserved || orig.GetLeaseId() == "" {
+		t.Fatalf("ReserveIdemKey got: %v, %v, %v, want a new lease", orig, reserved, err)
+	}
+
+	// a second request with the key is turned away while the lease is held, whatever its request
+	for _, reqHash := range []string{"hash_a", "hash_b"} {
+		existing, reserved, err := s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_a", ReqHash: reqHash}, time.Hour, lease)
+		if err != nil || reserved || existing.GetReqHash() != "hash_a" {
+			t.Errorf("ReserveIdemKey(%s) while leased got: %v, %v, %v, want the leased key", reqHash, existing, reserved, err)
+		}
+	}
+
+	// once the lease runs out, a retry of the same request takes the key over
+	time.Sleep(2 * lease)
+	if _, reserved, _ := s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_a", ReqHash: "hash_b"}, time.Hour, lease); reserved {
+		t.Error("ReserveIdemKey took over a key for a different request")
+	}
+	retry, reserved, err := s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_a", ReqHash: "hash_a"}, time.Hour, time.Hour)
+	if err != nil || !reserved || retry.GetLeaseId() == orig.GetLeaseId() {
+		t.Fatalf("ReserveIdemKey after the lease ran out got: %v, %v, %v, want a new lease", retry, reserved, err)
+	}
+
+	// the original request no longer holds the lease, so it can neither release the key nor save over it
+	if err = s.DeleteIdemKey(ctx, "key_a", orig.GetLeaseId()); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SaveIdemKey(ctx, "key_a", orig.GetLeaseId(), []byte("orig")); err == nil {
+		t.Error("SaveIdemKey with a taken over lease expected an error")
+	}
+	if err = s.SaveIdemKey(ctx, "key_a", retry.GetLeaseId(), []byte("retry")); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, reserved, err := s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_a", ReqHash: "hash_a"}, time.Hour, lease)
+	if err != nil || reserved || !bytes.Equal(existing.GetResp(), []byte("retry")) {
+		t.Errorf("ReserveIdemKey after saving got: %v, %v, %v, want the retry's response", existing, reserved, err)
+	}
+
+	// the lease holder releasing the key frees it for the next request
+	released, reserved, err := s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_b", ReqHash: "hash_a"}, time.Hour, lease)
+	if err != nil || !reserved {
+		t.Fatalf("ReserveIdemKey got: %v, %v, want a new lease", reserved, err)
+	}
+	if err = s.DeleteIdemKey(ctx, "key_b", released.GetLeaseId()); err != nil {
+		t.Fatal(err)
+	}
+	if _, reserved, _ = s.ReserveIdemKey(ctx, &storage.IdemKey{Key: "key_b", ReqHash: "hash_b"}, time.Hour, lease); !reserved {
+		t.Error("ReserveIdemKey didn't reserve a released key")
+	}
+}
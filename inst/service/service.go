@@ -5,7 +5,10 @@ import (
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
 	instStore "github.com/wolfinger/varangian/inst/store"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/page"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -54,13 +57,14 @@ func (s *InstServiceImpl) GetInst(ctx context.Context, request *v1.GetInstReques
 
 // ListInsts lists an array of instruments from the Instrument service
 func (s *InstServiceImpl) ListInsts(ctx context.Context, request *v1.ListInstsRequest) (*v1.ListInstsResponse, error) {
-	insts, err := s.instStore.ListInsts(ctx)
+	insts, nextPageToken, err := s.instStore.ListInsts(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 
 	return &v1.ListInstsResponse{
-		Insts: insts,
+		Insts:         insts,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -72,7 +76,7 @@ func (s *InstServiceImpl) UpdateInst(ctx context.Context, request *v1.UpdateInst
 		return nil, err
 	}
 
-	return &v1.UpdateInstResponse{}, nil
+	return &v1.UpdateInstResponse{Version: request.GetInst().GetVersion()}, nil
 }
 
 // CreateInst creates a new instrument via the Instrument service
@@ -102,3 +106,101 @@ func (s *InstServiceImpl) DeleteInst(ctx context.Context, request *v1.DeleteInst
 
 	return &v1.DeleteInstResponse{}, nil
 }
+
+// BatchCreateInsts creates a set of instruments via the Instrument service. the instruments are returned in
+// the order given, and any that failed in a partial batch are returned without an id
+func (s *InstServiceImpl) BatchCreateInsts(ctx context.Context, request *v1.BatchCreateInstsRequest) (*v1.BatchCreateInstsResponse, error) {
+	insts := request.GetInsts()
+	errs, err := batch.Run(len(insts), request.GetPartial(),
+		func(i int) error {
+			if insts[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "instrument id is not expected in POST")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchInsts := make([]*storage.Inst, len(idx))
+			for j, i := range idx {
+				batchInsts[j] = insts[i]
+			}
+			_, err := s.instStore.CreateInsts(ctx, batchInsts)
+			return err
+		},
+		func(i int) error {
+			_, err := s.instStore.CreateInsts(ctx, insts[i:i+1])
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchCreateInstsResponse{
+		Insts:  insts,
+		Errors: errs,
+	}, nil
+}
+
+// BatchUpdateInsts updates a set of instruments via the Instrument service
+func (s *InstServiceImpl) BatchUpdateInsts(ctx context.Context, request *v1.BatchUpdateInstsRequest) (*v1.BatchUpdateInstsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			inst := requests[i].GetInst()
+			if inst == nil {
+				return status.Error(codes.InvalidArgument, "instrument required in update")
+			}
+			inst.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			insts := make([]*storage.Inst, len(idx))
+			fieldMasks := make([][]string, len(idx))
+			for j, i := range idx {
+				insts[j] = requests[i].GetInst()
+				fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
+			}
+			return s.instStore.UpdateInsts(ctx, insts, fieldMasks)
+		},
+		func(i int) error {
+			return s.instStore.UpdateInst(ctx, requests[i].GetInst(), requests[i].GetUpdateMask().GetPaths())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdateInstsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetInst().GetVersion()
+		}),
+	}, nil
+}
+
+// BatchDeleteInsts removes a set of instruments from the Instrument service
+func (s *InstServiceImpl) BatchDeleteInsts(ctx context.Context, request *v1.BatchDeleteInstsRequest) (*v1.BatchDeleteInstsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			if ids[i] == "" {
+				return status.Error(codes.InvalidArgument, "instrument id required in delete")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchIDs := make([]string, len(idx))
+			for j, i := range idx {
+				batchIDs[j] = ids[i]
+			}
+			return s.instStore.DeleteInsts(ctx, batchIDs)
+		},
+		func(i int) error {
+			return s.instStore.DeleteInst(ctx, ids[i])
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchDeleteInstsResponse{
+		Errors: errs,
+	}, nil
+}
@@ -5,8 +5,14 @@ import (
 	"fmt"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/dbtx"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,10 +23,14 @@ import (
 // Store interface used for implementing the Instrument store
 type Store interface {
 	GetInst(ctx context.Context, id string) (*storage.Inst, error)
-	ListInsts(ctx context.Context) ([]*storage.Inst, error)
+	ListInsts(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Inst, string, error)
 	UpdateInst(ctx context.Context, inst *storage.Inst, fieldMask []string) error
 	CreateInst(ctx context.Context, inst *storage.Inst) (*storage.Inst, error)
 	DeleteInst(ctx context.Context, id string) error
+	CreateInsts(ctx context.Context, insts []*storage.Inst) ([]*storage.Inst, error)
+	UpdateInsts(ctx context.Context, insts []*storage.Inst, fieldMasks [][]string) error
+	DeleteInsts(ctx context.Context, ids []string) error
+	WithTx(tx *pg.Tx) Store
 }
 
 // NewStore encapsulates Instrument database operations
@@ -31,11 +41,42 @@ func NewStore(conn *pg.DB) Store {
 }
 
 type storeImpl struct {
-	conn *pg.DB
+	conn dbtx.Conn
 }
 
+// WithTx gets a copy of the store bound to a database transaction, which its changes commit with
+func (s *storeImpl) WithTx(tx *pg.Tx) Store {
+	return &storeImpl{conn: dbtx.Shared(tx)}
+}
+
+// instKeys are the keys instruments are listed in order of
+var instKeys = []page.Key{{Col: "id"}}
+
+// instOrderCols are the columns instruments can be listed in order of
+var instOrderCols = []string{
+	"id",
+	"ticker_vgn",
+	"ticker_local",
+	"sector",
+	"country",
+	"asset_class",
+	"isin",
+	"cusip",
+}
+
+// instFields are the fields instruments can be filtered on
+var instFields = filterPkg.NewFields(&storage.Inst{}, map[string]string{
+	"id":         vxid.PfxMap.Instrument,
+	"proxy_inst": vxid.PfxMap.Instrument,
+})
+
 // GetInst gets an instrument from the Instrument store
 func (s *storeImpl) GetInst(ctx context.Context, id string) (*storage.Inst, error) {
+	return getInst(ctx, s.conn, id)
+}
+
+// getInst gets an instrument with either a connection or a database transaction
+func getInst(ctx context.Context, db orm.DB, id string) (*storage.Inst, error) {
 	// convert vxids to vids
 	vid, err := vxid.Decode(id)
 	if err != nil {
@@ -43,7 +84,7 @@ func (s *storeImpl) GetInst(ctx context.Context, id string) (*storage.Inst, erro
 	}
 
 	var inst storage.Inst
-	err = s.conn.ModelContext(ctx, &inst).Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &inst).Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "instrument with id %s not found", id)
@@ -63,40 +104,122 @@ func (s *storeImpl) GetInst(ctx context.Context, id string) (*storage.Inst, erro
 	return &inst, err
 }
 
-// ListInsts lists an array of instruments from the Instrument store
-func (s *storeImpl) ListInsts(ctx context.Context) ([]*storage.Inst, error) {
+// ListInsts lists a page of the instruments matching a filter from the Instrument store, in order of id
+// unless ordered otherwise. a page size of 0 lists every instrument
+func (s *storeImpl) ListInsts(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Inst, string, error) {
+	keys, err := page.Order(orderBy, instKeys, instOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var insts []*storage.Inst
-	err := s.conn.ModelContext(ctx, &insts).Select()
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &insts), filter, instFields)
 	if err != nil {
-		return nil, fmt.Errorf("listing instruments %w", err)
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter)
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing instruments %w", err)
+	}
+
+	// the next page starts after the last instrument of this one
+	var nextPageToken string
+	n, more := p.Next(len(insts))
+	insts = insts[:n]
+	if more {
+		nextPageToken = p.Token(instVals(insts[n-1], keys)...)
 	}
 
 	for _, inst := range insts {
 		// convert vids to vxids
 		inst.Id, err = vxid.Encode(inst.GetId(), vxid.PfxMap.Instrument)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if inst.GetProxyInst() != "" {
 			inst.ProxyInst, err = vxid.Encode(inst.GetProxyInst(), vxid.PfxMap.Instrument)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 	}
 
-	return insts, nil
+	return insts, nextPageToken, nil
+}
+
+// instVals gets the values of the keys instruments are listed in order of from a instrument, for page tokens
+func instVals(inst *storage.Inst, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = inst.GetId()
+		case "ticker_vgn":
+			vals[i] = inst.GetTickerVgn()
+		case "ticker_local":
+			vals[i] = inst.GetTickerLocal()
+		case "sector":
+			vals[i] = inst.GetSector()
+		case "country":
+			vals[i] = inst.GetCountry()
+		case "asset_class":
+			vals[i] = inst.GetAssetClass()
+		case "isin":
+			vals[i] = inst.GetIsin()
+		case "cusip":
+			vals[i] = inst.GetCusip()
+		}
+	}
+
+	return vals
 }
 
 // UpdateInst updates an instrument via the Instrument store
 func (s *storeImpl) UpdateInst(ctx context.Context, inst *storage.Inst, fieldMask []string) error {
+	if err := updateInst(ctx, s.conn, inst, fieldMask); err != nil {
+		return err
+	}
+	inst.Version++
+
+	return nil
+}
+
+// UpdateInsts updates a set of instruments via the Instrument store, each with its own field mask. either
+// every instrument is updated or none are
+func (s *storeImpl) UpdateInsts(ctx context.Context, insts []*storage.Inst, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for i, inst := range insts {
+			if err := updateInst(ctx, tx, inst, fieldMasks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, inst := range insts {
+		inst.Version++
+	}
+
+	return nil
+}
+
+// updateInst updates an instrument with either a connection or a database transaction. the instrument
+// passed in isn't changed
+func updateInst(ctx context.Context, db orm.DB, inst *storage.Inst, fieldMask []string) error {
 	var err error
-	tgtInst := inst
+	tgtInst := proto.Clone(inst).(*storage.Inst)
 
 	// copy over only the fields passed in from the field mask (if provided)
 	if fieldMask != nil {
 		// get original inst object to update
-		tgtInst, err = s.GetInst(ctx, inst.GetId())
+		tgtInst, err = getInst(ctx, db, inst.GetId())
 		if err != nil {
 			return err
 		}
@@ -121,10 +244,10 @@ func (s *storeImpl) UpdateInst(ctx context.Context, inst *storage.Inst, fieldMas
 		}
 	}
 
-	// update instrument in datastore
-	_, err = s.conn.ModelContext(ctx, tgtInst).WherePK().Update()
-	if err != nil {
-		return fmt.Errorf("update inst %s %w", tgtInst.GetId(), err)
+	// update instrument in datastore if it's still at the version the caller read
+	tgtInst.Version = inst.GetVersion() + 1
+	if err = version.Update(ctx, db, tgtInst, inst.GetVersion(), "inst "+inst.GetId()); err != nil {
+		return err
 	}
 
 	return nil
@@ -160,6 +283,44 @@ func (s *storeImpl) CreateInst(ctx context.Context, inst *storage.Inst) (*storag
 	return inst, nil
 }
 
+// CreateInsts creates a set of instruments via the Instrument store with a single insert. either every
+// instrument is created or none are, and the instruments passed in are only changed once they are
+func (s *storeImpl) CreateInsts(ctx context.Context, insts []*storage.Inst) ([]*storage.Inst, error) {
+	if len(insts) == 0 {
+		return insts, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Inst, len(insts))
+	for i, inst := range insts {
+		rows[i] = proto.Clone(inst).(*storage.Inst)
+		if inst.GetProxyInst() != "" {
+			var err error
+			rows[i].ProxyInst, err = vxid.Decode(inst.GetProxyInst())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// create insts in datastore
+	if _, err := s.conn.ModelContext(ctx, &rows).Insert(); err != nil {
+		return nil, fmt.Errorf("creating insts: %w", err)
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+		insts[i].Id = id
+		insts[i].Version = row.GetVersion()
+	}
+
+	return insts, nil
+}
+
 // DeleteInst removes an instrument from the Instrument store
 func (s *storeImpl) DeleteInst(ctx context.Context, id string) error {
 	// convert vxid to vid
@@ -174,3 +335,22 @@ func (s *storeImpl) DeleteInst(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// DeleteInsts removes a set of instruments from the Instrument store with a single delete
+func (s *storeImpl) DeleteInsts(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.Inst)(nil)).Where("id IN (?)", pg.In(vids)).Delete(); err != nil {
+		return fmt.Errorf("delete insts %w", err)
+	}
+
+	return nil
+}
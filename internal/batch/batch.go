@@ -0,0 +1,90 @@
+// Package batch runs the batch create, update, and delete rpcs. a batch is all or nothing unless the caller
+// asks for a partial batch, where the items that fail are reported and the rest are still written
+package batch
+
+import (
+	"sort"
+
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxItems is the most items a batch can have
+	MaxItems = 1000
+)
+
+// Run runs a batch of n items. every item is checked first, then the items are written together with all,
+// which writes every item it's given or none of them. a batch that isn't partial fails on the first error.
+// a partial batch leaves out the items that fail their check and, if all fails, writes the rest one at a
+// time with one, returning an error for each item that failed
+func Run(n int, partial bool, check func(i int) error, all func(idx []int) error, one func(i int) error) ([]*v1.BatchError, error) {
+	if n > MaxItems {
+		return nil, status.Errorf(codes.InvalidArgument, "a batch can have at most %d items, got %d", MaxItems, n)
+	}
+
+	var errs []*v1.BatchError
+	idx := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if err := check(i); err != nil {
+			if !partial {
+				return nil, ItemError(i, err)
+			}
+			errs = append(errs, newError(i, err))
+			continue
+		}
+		idx = append(idx, i)
+	}
+	if len(idx) == 0 {
+		return errs, nil
+	}
+
+	err := all(idx)
+	if err == nil {
+		return errs, nil
+	}
+	if !partial {
+		return nil, err
+	}
+	for _, i := range idx {
+		if err := one(i); err != nil {
+			errs = append(errs, newError(i, err))
+		}
+	}
+	sort.Slice(errs, func(a, b int) bool {
+		return errs[a].GetIndex() < errs[b].GetIndex()
+	})
+
+	return errs, nil
+}
+
+// ItemError reports which item of a batch that isn't partial failed. all can use it for an item that
+// fails while the items are written together
+func ItemError(i int, err error) error {
+	st := status.Convert(err)
+	return status.Errorf(st.Code(), "item %d: %s", i, st.Message())
+}
+
+// newError reports why an item failed
+func newError(i int, err error) *v1.BatchError {
+	st := status.Convert(err)
+	return &v1.BatchError{
+		Index: int32(i),
+		Code:  int32(st.Code()),
+		Msg:   st.Message(),
+	}
+}
+
+// Versions gets the new version of each item in an update batch, leaving 0 for the items that failed
+func Versions(n int, errs []*v1.BatchError, version func(i int) int64) []int64 {
+	versions := make([]int64, n)
+	for i := range versions {
+		versions[i] = version(i)
+	}
+	for _, e := range errs {
+		versions[e.GetIndex()] = 0
+	}
+
+	return versions
+}
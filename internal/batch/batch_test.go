@@ -0,0 +1,85 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// items is a fake store: item 1 fails its check and item 3 fails to write
+type items struct {
+	written map[int]bool
+}
+
+func (it *items) check(i int) error {
+	if i == 1 {
+		return status.Error(codes.InvalidArgument, "id is not expected")
+	}
+	return nil
+}
+
+func (it *items) all(idx []int) error {
+	for _, i := range idx {
+		if i == 3 {
+			return errors.New("insert failed")
+		}
+	}
+	for _, i := range idx {
+		it.written[i] = true
+	}
+	return nil
+}
+
+func (it *items) one(i int) error {
+	if i == 3 {
+		return fmt.Errorf("item %d failed", i)
+	}
+	it.written[i] = true
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	it := &items{written: map[int]bool{}}
+	errs, err := Run(5, true, it.check, it.all, it.one)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(errs) != 2 || errs[0].GetIndex() != 1 || errs[1].GetIndex() != 3 {
+		t.Fatalf("Run errors incorrect, got: %v", errs)
+	}
+	if codes.Code(errs[0].GetCode()) != codes.InvalidArgument || codes.Code(errs[1].GetCode()) != codes.Unknown {
+		t.Errorf("Run error codes incorrect, got: %v", errs)
+	}
+	if want := map[int]bool{0: true, 2: true, 4: true}; !reflect.DeepEqual(it.written, want) {
+		t.Errorf("Run wrote %v, want %v", it.written, want)
+	}
+
+	// all or nothing stops at the first failed check, keeping its code
+	it = &items{written: map[int]bool{}}
+	_, err = Run(5, false, it.check, it.all, it.one)
+	if status.Code(err) != codes.InvalidArgument || len(it.written) != 0 {
+		t.Errorf("Run expected an invalid argument error and no writes, got: %v and %v", err, it.written)
+	}
+	_, err = Run(5, false, func(int) error { return nil }, it.all, it.one)
+	if err == nil || len(it.written) != 0 {
+		t.Errorf("Run expected an error and no writes, got: %v and %v", err, it.written)
+	}
+
+	_, err = Run(MaxItems+1, true, it.check, it.all, it.one)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Run expected an error for too many items, got: %v", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	errs := []*v1.BatchError{{Index: 1}, {Index: 3}}
+	versions := Versions(4, errs, func(i int) int64 { return int64(i + 2) })
+	if want := []int64{2, 0, 4, 0}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Versions got: %v, want: %v", versions, want)
+	}
+}
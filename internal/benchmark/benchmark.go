@@ -0,0 +1,157 @@
+// Package benchmark builds daily benchmark return series from the Benchmark store and instrument prices
+package benchmark
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	bmkStore "github.com/wolfinger/varangian/bmk/store"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/perf"
+	"github.com/wolfinger/varangian/internal/valuation"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Day is the return of a benchmark for a single day
+type Day struct {
+	Dt  time.Time
+	Ret float64
+	// Wts are the constituent weights at the start of the day (the prior day's close)
+	Wts map[string]float64
+}
+
+// rebal is a set of constituent weights a benchmark rebalances to at the close of a date
+type rebal struct {
+	dt  time.Time
+	wts map[string]float64
+}
+
+// Builder builds benchmark return series using the Benchmark store and a Valuer
+type Builder struct {
+	bmkStore bmkStore.Store
+	valuer   *valuation.Valuer
+}
+
+// NewBuilder creates a new Builder
+func NewBuilder(bmkStore bmkStore.Store, valuer *valuation.Valuer) *Builder {
+	return &Builder{
+		bmkStore: bmkStore,
+		valuer:   valuer,
+	}
+}
+
+// rebals gets a benchmark's rebalances in date order. a single instrument benchmark is fully weighted in
+// the instrument from the start of time
+func (b *Builder) rebals(ctx context.Context, bmkID string) ([]*rebal, error) {
+	bmk, err := b.bmkStore.GetBmk(ctx, bmkID)
+	if err != nil {
+		return nil, err
+	}
+	if bmk.GetInstId() != "" {
+		return []*rebal{{wts: map[string]float64{bmk.GetInstId(): 1}}}, nil
+	}
+
+	rebalMap := make(map[time.Time]*rebal)
+	var rebals []*rebal
+	for _, c := range bmk.GetConsts() {
+		dtStr := c.GetRebalDt()
+		if len(dtStr) > len(config.APIFormats.DateFmt) {
+			dtStr = dtStr[:len(config.APIFormats.DateFmt)]
+		}
+		dt, err := time.Parse(config.APIFormats.DateFmt, dtStr)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := rebalMap[dt]
+		if !ok {
+			r = &rebal{dt: dt, wts: make(map[string]float64)}
+			rebalMap[dt] = r
+			rebals = append(rebals, r)
+		}
+		r.wts[c.GetInstId()] += c.GetWeight()
+	}
+	if len(rebals) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "bmk %s has no inst or constituents", bmkID)
+	}
+	sort.Slice(rebals, func(i, j int) bool { return rebals[i].dt.Before(rebals[j].dt) })
+	for _, r := range rebals {
+		r.wts = perf.Normalize(r.wts)
+	}
+
+	return rebals, nil
+}
+
+// Days builds the daily returns of a benchmark in the reporting currency for each day after the start date
+// through the end date. constituent weights drift with their returns between rebalances
+func (b *Builder) Days(ctx context.Context, bmkID string, start time.Time, end time.Time, rptCcyID string) ([]*Day, error) {
+	rebals, err := b.rebals(ctx, bmkID)
+	if err != nil {
+		return nil, err
+	}
+
+	// start from the last rebalance on or before the start date
+	i := sort.Search(len(rebals), func(i int) bool { return rebals[i].dt.After(start) }) - 1
+	if i < 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "bmk %s has no constituents on or before %s", bmkID, start.Format(config.APIFormats.DateFmt))
+	}
+	walkStart := rebals[i].dt
+	if walkStart.IsZero() {
+		walkStart = start
+	}
+
+	// load the prices and fx rates of every constituent for the whole walk up front
+	var instIDs []string
+	for _, r := range rebals[i:] {
+		for instID := range r.wts {
+			instIDs = append(instIDs, instID)
+		}
+	}
+	hist, err := b.valuer.LoadHist(ctx, instIDs, walkStart.Format(config.APIFormats.DateFmt), end.Format(config.APIFormats.DateFmt), rptCcyID)
+	if err != nil {
+		return nil, err
+	}
+	val := func(instID string, dt time.Time) (float64, error) {
+		dtStr := dt.Format(config.APIFormats.DateFmt)
+		v, ok := hist.ValueInst(dtStr, rptCcyID, instID, 1)
+		if !ok || v == 0 {
+			return 0, status.Errorf(codes.FailedPrecondition, "bmk inst %s has no price on %s", instID, dtStr)
+		}
+		return v, nil
+	}
+
+	wts := rebals[i].wts
+	i++
+
+	var days []*Day
+	for dt := walkStart.AddDate(0, 0, 1); !dt.After(end); dt = dt.AddDate(0, 0, 1) {
+		prev := dt.AddDate(0, 0, -1)
+		rets := make(map[string]float64, len(wts))
+		for instID := range wts {
+			bVal, err := val(instID, prev)
+			if err != nil {
+				return nil, err
+			}
+			eVal, err := val(instID, dt)
+			if err != nil {
+				return nil, err
+			}
+			rets[instID] = eVal/bVal - 1
+		}
+
+		ret, drifted := perf.Drift(wts, rets)
+		if dt.After(start) {
+			days = append(days, &Day{Dt: dt, Ret: ret, Wts: wts})
+		}
+		wts = drifted
+
+		// rebalance at the close
+		if i < len(rebals) && rebals[i].dt.Equal(dt) {
+			wts = rebals[i].wts
+			i++
+		}
+	}
+
+	return days, nil
+}
@@ -5,8 +5,16 @@ type apiFormats struct {
 	DateFmt string
 }
 
+type priceSettings struct {
+	SrcPriority []string
+}
+
 var (
 	// APIFormats struct containing all formats related to interacting with the Varangian API
 	APIFormats = apiFormats{
 		DateFmt: "2006-01-02"}
+
+	// PriceSettings struct containing the default price source hierarchy (highest priority first)
+	PriceSettings = priceSettings{
+		SrcPriority: []string{"manual", "exchange", "vendor", "model"}}
 )
@@ -0,0 +1,33 @@
+// Package dbtx lets stores share a database transaction, so a change that spans several stores either
+// commits as a whole or not at all. a store runs on a Conn, which is the database itself until the store is
+// bound to a shared transaction
+package dbtx
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+)
+
+// Conn is what a store runs its queries and transactions on
+type Conn interface {
+	orm.DB
+	RunInTransaction(ctx context.Context, fn func(*pg.Tx) error) error
+}
+
+// Shared wraps a database transaction so stores can share it. a transaction a store runs on it joins the
+// shared one instead of committing, leaving the commit (or rollback) to whoever began it
+func Shared(tx *pg.Tx) Conn {
+	return shared{tx}
+}
+
+type shared struct {
+	*pg.Tx
+}
+
+// RunInTransaction runs fn in the shared transaction. an error from fn fails the whole shared transaction
+// once it's returned to whoever began it
+func (s shared) RunInTransaction(ctx context.Context, fn func(*pg.Tx) error) error {
+	return fn(s.Tx)
+}
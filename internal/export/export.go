@@ -0,0 +1,227 @@
+// Package export writes lots, lot balances, and txns out in bulk as csv or parquet. every export of a
+// resource has the same columns in the same order, with ids as vxids, so notebooks can rely on the schema.
+// rows are written as they come rather than collected, so exports of any size run in bounded memory
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/parquet"
+)
+
+type format struct {
+	CSV     string
+	Parquet string
+}
+
+var (
+	// Format defines the file formats exported to
+	Format = format{
+		CSV:     "csv",
+		Parquet: "parquet"}
+
+	// LotCols are the columns of a lots export
+	LotCols = []parquet.Column{
+		{Name: "id", Type: parquet.Type.String},
+		{Name: "inst_id", Type: parquet.Type.String},
+		{Name: "src_txn_id", Type: parquet.Type.String},
+		{Name: "orig_dt", Type: parquet.Type.Date},
+		{Name: "orig_size", Type: parquet.Type.Double},
+		{Name: "orig_cost", Type: parquet.Type.Double},
+		{Name: "le_org_id", Type: parquet.Type.String},
+		{Name: "acct_id", Type: parquet.Type.String},
+		{Name: "port_id", Type: parquet.Type.String},
+		{Name: "strat_id", Type: parquet.Type.String},
+	}
+
+	// LotBalCols are the columns of a lot balances export
+	LotBalCols = []parquet.Column{
+		{Name: "lot_id", Type: parquet.Type.String},
+		{Name: "lot_dt", Type: parquet.Type.Date},
+		{Name: "lot_size", Type: parquet.Type.Double},
+		{Name: "settled_size", Type: parquet.Type.Double},
+		{Name: "unsettled_size", Type: parquet.Type.Double},
+	}
+
+	// TxnCols are the columns of a txns export
+	TxnCols = []parquet.Column{
+		{Name: "id", Type: parquet.Type.String},
+		{Name: "txn_dt", Type: parquet.Type.Date},
+		{Name: "settle_dt", Type: parquet.Type.Date},
+		{Name: "txn_type", Type: parquet.Type.String},
+		{Name: "txn_sub_type", Type: parquet.Type.String},
+		{Name: "txn_size", Type: parquet.Type.Double},
+		{Name: "inst_id", Type: parquet.Type.String},
+		{Name: "parent_id", Type: parquet.Type.String},
+		{Name: "src_lot_id", Type: parquet.Type.String},
+		{Name: "tgt_lot_id", Type: parquet.Type.String},
+		{Name: "state", Type: parquet.Type.String},
+		{Name: "trade_amt_ccy_id", Type: parquet.Type.String},
+		{Name: "trade_amt_gross", Type: parquet.Type.Double},
+		{Name: "trade_amt_net", Type: parquet.Type.Double},
+		{Name: "settle_amt_ccy_id", Type: parquet.Type.String},
+		{Name: "settle_amt_gross", Type: parquet.Type.Double},
+		{Name: "settle_amt_net", Type: parquet.Type.Double},
+		{Name: "acct_id", Type: parquet.Type.String},
+		{Name: "le_org_id", Type: parquet.Type.String},
+		{Name: "port_id", Type: parquet.Type.String},
+		{Name: "strat_id", Type: parquet.Type.String},
+		{Name: "ext_id", Type: parquet.Type.String},
+	}
+)
+
+// LotRow gets the values of a lot in the order of LotCols
+func LotRow(lot *storage.Lot) []interface{} {
+	return []interface{}{
+		lot.GetId(),
+		lot.GetInstId(),
+		lot.GetSrcTxnId(),
+		day(lot.GetOrigDt()),
+		lot.GetOrigSize(),
+		lot.GetOrigCost(),
+		lot.GetLeOrgId(),
+		lot.GetAcctId(),
+		lot.GetPortId(),
+		lot.GetStratId(),
+	}
+}
+
+// LotBalRow gets the values of a lot balance in the order of LotBalCols
+func LotBalRow(lotBal *storage.LotBal) []interface{} {
+	return []interface{}{
+		lotBal.GetLotId(),
+		day(lotBal.GetLotDt()),
+		lotBal.GetLotSize(),
+		lotBal.GetSettledSize(),
+		lotBal.GetUnsettledSize(),
+	}
+}
+
+// TxnRow gets the values of a txn in the order of TxnCols
+func TxnRow(txn *storage.Txn) []interface{} {
+	return []interface{}{
+		txn.GetId(),
+		day(txn.GetTxnDt()),
+		day(txn.GetSettleDt()),
+		txn.GetTxnType(),
+		txn.GetTxnSubType(),
+		txn.GetTxnSize(),
+		txn.GetInstId(),
+		txn.GetParentId(),
+		txn.GetSrcLotId(),
+		txn.GetTgtLotId(),
+		txn.GetState(),
+		txn.GetTradeAmtCcyId(),
+		txn.GetTradeAmtGross(),
+		txn.GetTradeAmtNet(),
+		txn.GetSettleAmtCcyId(),
+		txn.GetSettleAmtGross(),
+		txn.GetSettleAmtNet(),
+		txn.GetAcctId(),
+		txn.GetLeOrgId(),
+		txn.GetPortId(),
+		txn.GetStratId(),
+		txn.GetExtId(),
+	}
+}
+
+// day trims a date or timestamp to its date
+func day(dt string) string {
+	if len(dt) > 10 {
+		return dt[:10]
+	}
+	return dt
+}
+
+// Writer writes the rows of an export. Close finishes the file but doesn't close the underlying writer
+type Writer interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// NewWriter starts an export of a set of columns in a format
+func NewWriter(w io.Writer, format string, cols []parquet.Column) (Writer, error) {
+	switch format {
+	case Format.CSV:
+		cw, err := newCSVWriter(w, cols)
+		if err != nil {
+			return nil, err
+		}
+		return cw, nil
+	case Format.Parquet:
+		pw, err := parquet.NewWriter(w, cols, parquet.DefaultGroupRows)
+		if err != nil {
+			return nil, err
+		}
+		return pw, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q, expected csv or parquet", format)
+}
+
+// ContentType gets the media type of an export format
+func ContentType(format string) string {
+	if format == Format.Parquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// csvWriter writes an export as csv with a header line. nulls are empty and numbers are written in full
+type csvWriter struct {
+	w    *csv.Writer
+	cols int
+}
+
+// newCSVWriter starts a csv export, writing the header line
+func newCSVWriter(w io.Writer, cols []parquet.Column) (*csvWriter, error) {
+	cw := &csvWriter{
+		w:    csv.NewWriter(w),
+		cols: len(cols),
+	}
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.Name
+	}
+	if err := cw.w.Write(header); err != nil {
+		return nil, fmt.Errorf("writing csv: %w", err)
+	}
+
+	return cw, nil
+}
+
+// Write writes a row
+func (cw *csvWriter) Write(row []interface{}) error {
+	if len(row) != cw.cols {
+		return fmt.Errorf("row has %d values, expected %d", len(row), cw.cols)
+	}
+	rec := make([]string, len(row))
+	for i, val := range row {
+		switch v := val.(type) {
+		case nil:
+		case string:
+			rec[i] = v
+		case float64:
+			rec[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("unexpected %T value", val)
+		}
+	}
+	if err := cw.w.Write(rec); err != nil {
+		return fmt.Errorf("writing csv: %w", err)
+	}
+
+	return nil
+}
+
+// Close flushes the rows written
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		return fmt.Errorf("writing csv: %w", err)
+	}
+	return nil
+}
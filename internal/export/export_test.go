@@ -0,0 +1,61 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/parquet"
+)
+
+func TestRows(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		cols []parquet.Column
+		row  []interface{}
+	}{
+		{"lot", LotCols, LotRow(&storage.Lot{Id: "lot_1", InstId: "inst_1", OrigDt: "2021-03-01", OrigSize: 100})},
+		{"lot bal", LotBalCols, LotBalRow(&storage.LotBal{LotId: "lot_1", LotDt: "2021-03-01T00:00:00Z", LotSize: 100})},
+		{"txn", TxnCols, TxnRow(&storage.Txn{Id: "txn_1", TxnDt: "2021-03-01", TxnType: "trade", TxnSize: 100})},
+	} {
+		// parquet checks every value against its column's type
+		for _, format := range []string{Format.CSV, Format.Parquet} {
+			w, err := NewWriter(&bytes.Buffer{}, format, test.cols)
+			if err != nil {
+				t.Fatalf("NewWriter(%s) failed: %v", format, err)
+			}
+			if err := w.Write(test.row); err != nil {
+				t.Errorf("%s row doesn't fit its %s columns: %v", test.name, format, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Errorf("%s %s Close failed: %v", test.name, format, err)
+			}
+		}
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Format.CSV, LotBalCols)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := w.Write(LotBalRow(&storage.LotBal{LotId: "lot_1", LotDt: "2021-03-01", LotSize: 1234567.891, SettledSize: 0.1})); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Write([]interface{}{"x"}); err == nil {
+		t.Errorf("Write expected an error for a short row")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	want := "lot_id,lot_dt,lot_size,settled_size,unsettled_size\nlot_1,2021-03-01,1234567.891,0.1,0\n"
+	if buf.String() != want {
+		t.Errorf("csv incorrect, got: %q, want: %q", buf.String(), want)
+	}
+
+	if _, err := NewWriter(&buf, "json", LotBalCols); err == nil {
+		t.Errorf("NewWriter expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,167 @@
+// Package feed streams the changes recorded in the Change store to watchers. watchers pick up where their
+// cursor left off, so a watcher that drops can resume without missing or repeating a change. committed
+// changes wake the watchers through postgres LISTEN/NOTIFY, with polling as a backstop
+package feed
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	changeStore "github.com/wolfinger/varangian/change/store"
+	"github.com/wolfinger/varangian/generated/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// LastEventKey is the metadata key (and http header) a server-sent events client resumes from
+	LastEventKey = "last-event-id"
+	// DefaultRetention is how long changes are kept for when no retention window is set
+	DefaultRetention = 7 * 24 * time.Hour
+
+	// pageSize is how many changes are read at a time
+	pageSize = 500
+	// pollInterval is how often watchers check for changes they weren't woken for
+	pollInterval = 10 * time.Second
+	// retryInterval is how long to wait before listening again after losing the connection
+	retryInterval = 5 * time.Second
+)
+
+// Feed streams changes to watchers
+type Feed struct {
+	changeStore changeStore.Store
+	retention   time.Duration
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// NewFeed creates a new Feed, keeping changes for the retention window passed in
+func NewFeed(changeStore changeStore.Store, retention time.Duration) *Feed {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	return &Feed{
+		changeStore: changeStore,
+		retention:   retention,
+		wake:        make(chan struct{}),
+	}
+}
+
+// Run wakes the watchers each time changes are committed, until the context is done
+func (f *Feed) Run(ctx context.Context) {
+	for {
+		err := f.changeStore.Listen(ctx, f.notify)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Print(err)
+
+		// changes may have been missed while the connection was down
+		f.notify()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// Purge removes the changes that have outlived the retention window
+func (f *Feed) Purge(ctx context.Context) (int, error) {
+	return f.changeStore.PurgeChanges(ctx, f.retention)
+}
+
+// notify wakes every watcher waiting for changes
+func (f *Feed) notify() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	close(f.wake)
+	f.wake = make(chan struct{})
+}
+
+// woken gets a channel that's closed the next time changes are committed
+func (f *Feed) woken() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.wake
+}
+
+// Watch sends the changes to a resource made after a cursor to fn, in the order they were made, until the
+// context is done or fn fails. each change's id is the cursor to resume after it from. an empty cursor
+// watches from now, falling back on the last event id of a server-sent events client
+func (f *Feed) Watch(ctx context.Context, resource string, cursor string, fn func(change *storage.Change) error) error {
+	after, err := f.start(ctx, cursor)
+	if err != nil {
+		return err
+	}
+
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	for {
+		// get the wake channel before reading, so changes committed while reading aren't slept through
+		wake := f.woken()
+		changes, err := f.changeStore.ListChanges(ctx, resource, after, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err = fn(change); err != nil {
+				return err
+			}
+			after = change.GetId()
+		}
+		if len(changes) == pageSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-poll.C:
+		}
+	}
+}
+
+// start gets the change to start watching after
+func (f *Feed) start(ctx context.Context, cursor string) (int64, error) {
+	if cursor == "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(LastEventKey)) > 0 {
+			cursor = md.Get(LastEventKey)[0]
+		}
+	}
+	if cursor == "" {
+		return f.changeStore.LastChange(ctx)
+	}
+
+	after, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || after < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid cursor %q", cursor)
+	}
+
+	// a cursor from before the oldest change kept would miss the changes purged since. once every change
+	// has been purged, that's any cursor before the last change
+	first, err := f.changeStore.FirstChange(ctx)
+	if err != nil {
+		return 0, err
+	}
+	oldest := first - 1
+	if after > 0 && first == 0 {
+		if oldest, err = f.changeStore.LastChange(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if after > 0 && after < oldest {
+		return 0, status.Errorf(codes.OutOfRange, "cursor %s is older than the changes kept, list again and watch from now", cursor)
+	}
+
+	return after, nil
+}
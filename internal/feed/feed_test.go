@@ -0,0 +1,211 @@
+package feed
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	changeStore "github.com/wolfinger/varangian/change/store"
+	"github.com/wolfinger/varangian/generated/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStore keeps changes in memory the way the database does, with ids that carry on after a purge
+type fakeStore struct {
+	mu      sync.Mutex
+	changes []*storage.Change
+	last    int64
+	listed  int
+}
+
+// record records a change to a resource with the next id
+func (f *fakeStore) record(resource string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.last++
+	f.changes = append(f.changes, &storage.Change{Id: f.last, Resource: resource, Op: changeStore.Create})
+}
+
+// purge removes the changes up to and including an id
+func (f *fakeStore) purge(through int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var kept []*storage.Change
+	for _, change := range f.changes {
+		if change.GetId() > through {
+			kept = append(kept, change)
+		}
+	}
+	f.changes = kept
+}
+
+func (f *fakeStore) ListChanges(ctx context.Context, resource string, after int64, limit int) ([]*storage.Change, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.listed++
+	var changes []*storage.Change
+	for _, change := range f.changes {
+		if change.GetResource() == resource && change.GetId() > after && len(changes) < limit {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// listing checks if ListChanges has been called yet
+func (f *fakeStore) listing() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.listed > 0
+}
+
+func (f *fakeStore) FirstChange(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.changes) == 0 {
+		return 0, nil
+	}
+	return f.changes[0].GetId(), nil
+}
+
+func (f *fakeStore) LastChange(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.last, nil
+}
+
+func (f *fakeStore) Listen(ctx context.Context, fn func()) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeStore) PurgeChanges(ctx context.Context, retention time.Duration) (int, error) {
+	return 0, nil
+}
+
+var errDone = errors.New("done")
+
+// watch watches txn changes from a cursor until the change with the id passed in, returning the ids seen
+func watch(ctx context.Context, f *Feed, cursor string, until int64) ([]int64, error) {
+	var ids []int64
+	err := f.Watch(ctx, changeStore.Txn, cursor, func(change *storage.Change) error {
+		ids = append(ids, change.GetId())
+		if change.GetId() == until {
+			return errDone
+		}
+		return nil
+	})
+	if err == errDone {
+		err = nil
+	}
+	return ids, err
+}
+
+func TestResume(t *testing.T) {
+	store := &fakeStore{}
+	for _, resource := range []string{changeStore.Txn, changeStore.LotBal, changeStore.Txn, changeStore.Txn} {
+		store.record(resource)
+	}
+	f := NewFeed(store, 0)
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		cursor string
+		want   []int64
+	}{
+		{"from the oldest change", context.Background(), "0", []int64{1, 3, 4}},
+		{"after a cursor", context.Background(), "1", []int64{3, 4}},
+		{"after the last event id", metadata.NewIncomingContext(context.Background(), metadata.Pairs(LastEventKey, "3")), "", []int64{4}},
+	}
+	for _, test := range tests {
+		ids, err := watch(test.ctx, f, test.cursor, 4)
+		if err != nil || !equal(ids, test.want) {
+			t.Errorf("%s got: %v, %v, want: %v", test.name, ids, err, test.want)
+		}
+	}
+
+	if _, err := watch(context.Background(), f, "x", 4); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid cursor expected an invalid argument error, got: %v", err)
+	}
+}
+
+func TestOutOfRange(t *testing.T) {
+	store := &fakeStore{}
+	for i := 0; i < 5; i++ {
+		store.record(changeStore.Txn)
+	}
+	f := NewFeed(store, 0)
+
+	// with changes 1 and 2 purged, a cursor of 2 misses nothing but 1 misses change 2
+	store.purge(2)
+	if ids, err := watch(context.Background(), f, "2", 5); err != nil || !equal(ids, []int64{3, 4, 5}) {
+		t.Errorf("cursor at the last change purged got: %v, %v, want: [3 4 5]", ids, err)
+	}
+	if _, err := watch(context.Background(), f, "1", 5); status.Code(err) != codes.OutOfRange {
+		t.Errorf("cursor before the last change purged expected an out of range error, got: %v", err)
+	}
+
+	// with every change purged, only a cursor at the last change (or 0) misses nothing
+	store.purge(5)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	for _, cursor := range []string{"0", "5"} {
+		if ids, err := watch(ctx, f, cursor, 0); err != nil || len(ids) != 0 {
+			t.Errorf("cursor %s with every change purged got: %v, %v, want no changes", cursor, ids, err)
+		}
+	}
+	if _, err := watch(context.Background(), f, "4", 0); status.Code(err) != codes.OutOfRange {
+		t.Errorf("cursor before the last change with every change purged expected an out of range error, got: %v", err)
+	}
+}
+
+func TestWake(t *testing.T) {
+	store := &fakeStore{}
+	store.record(changeStore.Txn)
+	f := NewFeed(store, 0)
+
+	// a watcher from now skips the changes already made and is woken for the next one well before it polls
+	ctx, cancel := context.WithTimeout(context.Background(), pollInterval/2)
+	defer cancel()
+	done := make(chan struct{})
+	var ids []int64
+	var err error
+	go func() {
+		defer close(done)
+		ids, err = watch(ctx, f, "", 2)
+	}()
+
+	// the watcher has its starting point once it's listing changes
+	for !store.listing() {
+		time.Sleep(time.Millisecond)
+	}
+	store.record(changeStore.Txn)
+	f.notify()
+	<-done
+	if err != nil || !equal(ids, []int64{2}) {
+		t.Errorf("woken watcher got: %v, %v, want: [2]", ids, err)
+	}
+}
+
+func equal(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
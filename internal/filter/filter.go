@@ -0,0 +1,400 @@
+// Package filter parses list filters written in the AIP-160 filter language, e.g.
+// `txn_dt >= "2021-01-01" AND txn_type = "trade" AND inst_id:("inst_a" "inst_b")`, checks them against a
+// resource's fields, and compiles them to sql. values are always passed to the database as parameters, never
+// written into the sql itself
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the operators of an expression
+const (
+	And = "AND"
+	Or  = "OR"
+	Not = "NOT"
+	Eq  = "="
+	Neq = "!="
+	Lt  = "<"
+	Lte = "<="
+	Gt  = ">"
+	Gte = ">="
+	Has = ":"
+)
+
+// Expr is a node of a parsed filter: AND, OR, and NOT combine other expressions, while a comparator
+// restricts a field to values
+type Expr struct {
+	Op    string
+	Exprs []*Expr
+	Field string
+	Vals  []Val
+	Pos   int
+}
+
+// Val is a value a field is compared to. quoted values are always text, so `"null"` is the text null and
+// `null` is a null
+type Val struct {
+	Text   string
+	Quoted bool
+}
+
+// isNull tells if a value is a null
+func (v Val) isNull() bool {
+	return !v.Quoted && v.Text == "null"
+}
+
+// String writes an expression back out as a filter
+func (e *Expr) String() string {
+	switch e.Op {
+	case And, Or:
+		parts := make([]string, len(e.Exprs))
+		for i, x := range e.Exprs {
+			parts[i] = x.String()
+		}
+		return "(" + strings.Join(parts, " "+e.Op+" ") + ")"
+	case Not:
+		return "NOT " + e.Exprs[0].String()
+	}
+
+	vals := make([]string, len(e.Vals))
+	for i, v := range e.Vals {
+		vals[i] = v.Text
+		if v.Quoted {
+			vals[i] = Quote(v.Text)
+		}
+	}
+	switch {
+	case e.Op == Has && len(vals) > 1:
+		return e.Field + ":(" + strings.Join(vals, " ") + ")"
+	case e.Op == Has:
+		return e.Field + ":" + vals[0]
+	}
+	return e.Field + " " + e.Op + " " + vals[0]
+}
+
+// Quote quotes a value for a filter, escaping quotes and backslashes in it
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// In builds a filter restricting a field to a set of values
+func In(field string, vals ...string) string {
+	quoted := make([]string, len(vals))
+	for i, v := range vals {
+		quoted[i] = Quote(v)
+	}
+	return field + ":(" + strings.Join(quoted, " ") + ")"
+}
+
+// Compare builds a filter comparing a field to a value
+func Compare(field string, op string, val string) string {
+	return field + " " + op + " " + Quote(val)
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokWord
+	tokText
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+// token is a word, quoted text, comparator, or punctuation in a filter
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// lex splits a filter into tokens
+func lex(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+		case c == ',':
+			toks = append(toks, token{tokComma, ",", i})
+			i++
+		case c == ':' || c == '=':
+			toks = append(toks, token{tokOp, string(c), i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(s) && s[i+1] == '=' {
+				toks = append(toks, token{tokOp, s[i : i+2], i})
+				i += 2
+				continue
+			}
+			if c == '!' {
+				return nil, errorf(i, "expected != but got !")
+			}
+			toks = append(toks, token{tokOp, string(c), i})
+			i++
+		case c == '"':
+			var b strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, errorf(start, "text is missing its closing quote")
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				} else if s[i] == '"' {
+					break
+				}
+				b.WriteByte(s[i])
+			}
+			toks = append(toks, token{tokText, b.String(), start})
+			i++
+		default:
+			start := i
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && !strings.ContainsRune(`()=!<>:,"`, rune(s[i])) {
+				i++
+			}
+			toks = append(toks, token{tokWord, s[start:i], start})
+		}
+	}
+
+	return append(toks, token{tokEOF, "", len(s)}), nil
+}
+
+// parser parses a filter's tokens, following the AIP-160 grammar: AND binds looser than OR, and
+// expressions written next to each other are ANDed
+type parser struct {
+	toks []token
+	i    int
+}
+
+// Parse parses a filter into an expression. an empty filter parses to nil
+func Parse(s string) (*Expr, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	if toks[0].kind == tokEOF {
+		return nil, nil
+	}
+
+	p := &parser{toks: toks}
+	e, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, errorf(tok.pos, "unexpected %q", tok.text)
+	}
+
+	return e, nil
+}
+
+// peek gets the next token without using it up
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+// next uses up the next token
+func (p *parser) next() token {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+// isKeyword tells if a token is one of the keywords
+func isKeyword(tok token, words ...string) bool {
+	if tok.kind != tokWord {
+		return false
+	}
+	for _, w := range words {
+		if tok.text == w {
+			return true
+		}
+	}
+	return false
+}
+
+// expression parses sequences joined by AND
+func (p *parser) expression() (*Expr, error) {
+	e, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []*Expr{e}
+	for isKeyword(p.peek(), And) {
+		p.next()
+		e, err = p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+
+	return join(And, exprs), nil
+}
+
+// sequence parses factors written next to each other, which are ANDed
+func (p *parser) sequence() (*Expr, error) {
+	e, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []*Expr{e}
+	for {
+		tok := p.peek()
+		if tok.kind == tokEOF || tok.kind == tokRParen || isKeyword(tok, And) {
+			break
+		}
+		e, err = p.factor()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+
+	return join(And, exprs), nil
+}
+
+// factor parses terms joined by OR
+func (p *parser) factor() (*Expr, error) {
+	e, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	exprs := []*Expr{e}
+	for isKeyword(p.peek(), Or) {
+		p.next()
+		e, err = p.term()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+	}
+
+	return join(Or, exprs), nil
+}
+
+// term parses a simple expression, negated by a leading NOT or -
+func (p *parser) term() (*Expr, error) {
+	tok := p.peek()
+	negate := false
+	switch {
+	case isKeyword(tok, Not):
+		p.next()
+		negate = true
+	case tok.kind == tokWord && strings.HasPrefix(tok.text, "-") && len(tok.text) > 1:
+		p.toks[p.i].text = tok.text[1:]
+		p.toks[p.i].pos++
+		negate = true
+	}
+
+	e, err := p.simple()
+	if err != nil {
+		return nil, err
+	}
+	if negate {
+		return &Expr{Op: Not, Exprs: []*Expr{e}, Pos: tok.pos}, nil
+	}
+
+	return e, nil
+}
+
+// simple parses a restriction or an expression in parentheses
+func (p *parser) simple() (*Expr, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokLParen:
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if end := p.next(); end.kind != tokRParen {
+			return nil, errorf(end.pos, "expected ) to close the ( at position %d", tok.pos)
+		}
+		return e, nil
+	case tok.kind == tokEOF:
+		return nil, errorf(tok.pos, "expected a field but the filter ended")
+	case tok.kind != tokWord || isKeyword(tok, And, Or, Not):
+		return nil, errorf(tok.pos, "expected a field but got %q", tok.text)
+	}
+
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, errorf(op.pos, "expected a comparator after %s, e.g. %s = \"value\"", tok.text, tok.text)
+	}
+	e := &Expr{Op: op.text, Field: tok.text, Pos: tok.pos}
+	if op.text == Has && p.peek().kind == tokLParen {
+		open := p.next()
+		for {
+			if end := p.peek(); end.kind == tokRParen {
+				p.next()
+				break
+			}
+			if sep := p.peek(); sep.kind == tokComma || isKeyword(sep, Or) {
+				p.next()
+				continue
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			e.Vals = append(e.Vals, v)
+		}
+		if len(e.Vals) == 0 {
+			return nil, errorf(open.pos, "%s:() needs at least one value", tok.text)
+		}
+		return e, nil
+	}
+
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	e.Vals = []Val{v}
+
+	return e, nil
+}
+
+// value parses a value, quoted or not
+func (p *parser) value() (Val, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokText:
+		return Val{Text: tok.text, Quoted: true}, nil
+	case tok.kind == tokWord && !isKeyword(tok, And, Or, Not):
+		return Val{Text: tok.text}, nil
+	case tok.kind == tokEOF:
+		return Val{}, errorf(tok.pos, "expected a value but the filter ended")
+	}
+
+	return Val{}, errorf(tok.pos, "expected a value but got %q", tok.text)
+}
+
+// join combines expressions with an operator, leaving a single expression as it is
+func join(op string, exprs []*Expr) *Expr {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return &Expr{Op: op, Exprs: exprs, Pos: exprs[0].Pos}
+}
+
+// errorf reports a bad filter, with the position of the problem
+func errorf(pos int, format string, args ...interface{}) error {
+	return status.Errorf(codes.InvalidArgument, "invalid filter at position %d: %s", pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		`txn_type = "trade"`:                           `txn_type = "trade"`,
+		`a = 1 AND b = 2 OR c = 3`:                     `(a = 1 AND (b = 2 OR c = 3))`,
+		`a = 1 b = 2`:                                  `(a = 1 AND b = 2)`,
+		`(a = 1 AND b = 2) OR c = 3`:                   `((a = 1 AND b = 2) OR c = 3)`,
+		`NOT a = 1 -b:"x"`:                             `(NOT a = 1 AND NOT b:"x")`,
+		`inst_id:("inst_a", "inst_b" OR inst_c)`:       `inst_id:("inst_a" "inst_b" inst_c)`,
+		`txn_size<=-5 ext_id!="say \"hi\""`:            `(txn_size <= -5 AND ext_id != "say \"hi\"")`,
+		In("txn_type", "trade", "fee") + " " + "x = y": `(txn_type:("trade" "fee") AND x = y)`,
+	}
+	for filter, want := range tests {
+		e, err := Parse(filter)
+		if err != nil {
+			t.Errorf("Parse(%s) failed: %v", filter, err)
+			continue
+		}
+		if got := e.String(); got != want {
+			t.Errorf("Parse(%s) got: %s, want: %s", filter, got, want)
+		}
+	}
+
+	if e, err := Parse("  "); e != nil || err != nil {
+		t.Errorf("Parse of an empty filter got: %v, %v", e, err)
+	}
+	for _, bad := range []string{`txn_type`, `"trade"`, `a = `, `a = 1 AND`, `(a = 1`, `a = 1)`, `a ! 1`, `a = "x`, `a:()`, `a = AND`} {
+		if _, err := Parse(bad); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Parse(%s) expected an invalid argument error, got: %v", bad, err)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	fields := NewFields(&storage.Txn{}, map[string]string{
+		"id":      vxid.PfxMap.Transaction,
+		"inst_id": vxid.PfxMap.Instrument,
+	})
+	instID, _ := vxid.Encode("9dd05581-2562-4142-89b5-eaa601b8dcda", vxid.PfxMap.Instrument)
+
+	tests := map[string]string{
+		`txn_dt >= "2021-01-01" AND txn_type = "trade" AND inst_id:("` + instID + `")`: `("txn_dt" >= '2021-01-01' AND "txn_type" = 'trade' AND "inst_id" = '9dd05581-2562-4142-89b5-eaa601b8dcda')`,
+		`txn_size > 10 OR NOT state:(open processed)`:                                  `("txn_size" > 10 OR NOT "state" IN ('open','processed'))`,
+		`parent_id = null`:                   `"parent_id" IS NULL`,
+		`ext_id = "x'); DROP TABLE txns --"`: `"ext_id" = 'x''); DROP TABLE txns --'`,
+	}
+	for filter, want := range tests {
+		e, err := Parse(filter)
+		if err != nil {
+			t.Fatalf("Parse(%s) failed: %v", filter, err)
+		}
+		cond, params, err := fields.SQL(e)
+		if err != nil {
+			t.Errorf("SQL(%s) failed: %v", filter, err)
+			continue
+		}
+		if got := string(orm.NewFormatter().FormatQuery(nil, cond, params...)); got != want {
+			t.Errorf("SQL(%s) got: %s, want: %s", filter, got, want)
+		}
+	}
+
+	for _, bad := range []string{
+		`nope = 1`,
+		`txn_size = "ten"`,
+		`txn_dt > "01/02/2021"`,
+		`inst_id > "` + instID + `"`,
+		`inst_id = "acct_` + instID[len("inst_"):] + `"`,
+		`inst_id = "not an id"`,
+		`state < null`,
+	} {
+		e, err := Parse(bad)
+		if err != nil {
+			t.Fatalf("Parse(%s) failed: %v", bad, err)
+		}
+		if _, _, err := fields.SQL(e); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("SQL(%s) expected an invalid argument error, got: %v", bad, err)
+		}
+	}
+}
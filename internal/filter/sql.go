@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Kind is the kind of value a field holds, which decides how its values are checked
+type Kind int
+
+// the kinds of fields
+const (
+	Text Kind = iota
+	Number
+	Bool
+	Date
+	ID
+)
+
+// Field is a field of a resource that can be filtered on, stored in a column of the same name
+type Field struct {
+	Kind Kind
+	Pfx  string
+}
+
+// Fields are the fields of a resource that can be filtered on, by name
+type Fields map[string]Field
+
+// NewFields gets the fields of a resource from its storage message. ids maps the fields holding vxids to
+// their prefixes, and fields ending in _dt are dates. lists and nested messages can't be filtered on
+func NewFields(msg proto.Message, ids map[string]string) Fields {
+	fields := Fields{}
+	descs := proto.MessageReflect(msg).Descriptor().Fields()
+	for i := 0; i < descs.Len(); i++ {
+		desc := descs.Get(i)
+		if desc.IsList() || desc.IsMap() {
+			continue
+		}
+
+		name := string(desc.Name())
+		switch desc.Kind() {
+		case protoreflect.StringKind:
+			switch pfx, ok := ids[name]; {
+			case ok:
+				fields[name] = Field{Kind: ID, Pfx: pfx}
+			case strings.HasSuffix(name, "_dt"):
+				fields[name] = Field{Kind: Date}
+			default:
+				fields[name] = Field{Kind: Text}
+			}
+		case protoreflect.DoubleKind, protoreflect.FloatKind,
+			protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Sint32Kind, protoreflect.Sint64Kind,
+			protoreflect.Uint32Kind, protoreflect.Uint64Kind:
+			fields[name] = Field{Kind: Number}
+		case protoreflect.BoolKind:
+			fields[name] = Field{Kind: Bool}
+		}
+	}
+
+	return fields
+}
+
+// names lists the fields, for error messages
+func (f Fields) names() string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Apply parses a filter, checks it against a resource's fields, and adds it to a query
+func Apply(q *orm.Query, filter string, fields Fields) (*orm.Query, error) {
+	e, err := Parse(filter)
+	if err != nil || e == nil {
+		return q, err
+	}
+	cond, params, err := fields.SQL(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Where(cond, params...), nil
+}
+
+// SQL compiles an expression to a sql condition and its parameters, checking every field is one of the
+// resource's and every value suits its field. ids are decoded from vxids
+func (f Fields) SQL(e *Expr) (string, []interface{}, error) {
+	switch e.Op {
+	case And, Or:
+		parts := make([]string, len(e.Exprs))
+		var params []interface{}
+		for i, x := range e.Exprs {
+			cond, xParams, err := f.SQL(x)
+			if err != nil {
+				return "", nil, err
+			}
+			parts[i] = cond
+			params = append(params, xParams...)
+		}
+		return "(" + strings.Join(parts, " "+e.Op+" ") + ")", params, nil
+	case Not:
+		cond, params, err := f.SQL(e.Exprs[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + cond, params, nil
+	}
+
+	field, ok := f[e.Field]
+	if !ok {
+		return "", nil, errorf(e.Pos, "unknown field %s, expected one of %s", e.Field, f.names())
+	}
+	col := pg.Ident(e.Field)
+
+	// nulls can only be equal or not
+	if len(e.Vals) == 1 && e.Vals[0].isNull() {
+		switch e.Op {
+		case Eq, Has:
+			return "? IS NULL", []interface{}{col}, nil
+		case Neq:
+			return "? IS NOT NULL", []interface{}{col}, nil
+		}
+		return "", nil, errorf(e.Pos, "null can only be compared with =, != or :")
+	}
+	if (field.Kind == ID || field.Kind == Bool) && e.Op != Eq && e.Op != Neq && e.Op != Has {
+		return "", nil, errorf(e.Pos, "%s can only be compared with =, != or :", e.Field)
+	}
+
+	vals := make([]interface{}, len(e.Vals))
+	for i, v := range e.Vals {
+		if v.isNull() {
+			return "", nil, errorf(e.Pos, "null can't be in a list of values")
+		}
+		var err error
+		vals[i], err = field.value(e, v.Text)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if len(vals) > 1 {
+		return "? IN (?)", []interface{}{col, pg.In(vals)}, nil
+	}
+
+	op := e.Op
+	if op == Has {
+		op = Eq
+	}
+	return "? " + op + " ?", []interface{}{col, vals[0]}, nil
+}
+
+// value checks a value suits a field, converting it to what's stored
+func (field Field) value(e *Expr, v string) (interface{}, error) {
+	switch field.Kind {
+	case Number:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, errorf(e.Pos, "%s takes a number, got %q", e.Field, v)
+		}
+		return n, nil
+	case Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errorf(e.Pos, "%s takes true or false, got %q", e.Field, v)
+		}
+		return b, nil
+	case Date:
+		if _, err := time.Parse(config.APIFormats.DateFmt, v); err != nil {
+			return nil, errorf(e.Pos, "%s takes a date (yyyy-mm-dd), got %q", e.Field, v)
+		}
+		return v, nil
+	case ID:
+		if i := strings.LastIndex(v, "_"); i >= 0 && v[:i] != field.Pfx {
+			return nil, errorf(e.Pos, "%s takes %s ids, got %q", e.Field, field.Pfx, v)
+		}
+		vid, err := vxid.Decode(v)
+		if err != nil || vid == "" {
+			return nil, errorf(e.Pos, "%s takes an id, got %q", e.Field, v)
+		}
+		return vid, nil
+	}
+
+	return v, nil
+}
@@ -0,0 +1,256 @@
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sendingTimeFmt is the format of SendingTime (52), in utc
+const sendingTimeFmt = "20060102-15:04:05.000"
+
+// Handler handles an application message (e.g., an ExecutionReport) received by an Acceptor. an error
+// rejects the message back to the initiator, but keeps the session up
+type Handler func(m *Message) error
+
+// Acceptor accepts sessions from FIX initiators (e.g., an order management system sending a drop copy),
+// taking care of the session messages and handing every application message to its handler. sessions
+// are taken as they come: there's no store of sent messages to resend, and gaps in the initiator's
+// sequence numbers are asked to be resent rather than held up. only the initiator whose SenderCompID is
+// InitiatorID can log on, and only to CompID
+type Acceptor struct {
+	CompID      string
+	InitiatorID string
+	Handler     Handler
+}
+
+// Serve accepts connections from a listener until it fails, running a session on each
+func (a *Acceptor) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			_ = a.ServeConn(conn)
+		}()
+	}
+}
+
+// session is the state of a session with an initiator
+type session struct {
+	conn        net.Conn
+	compID      string
+	targetID    string
+	beginString string
+	mu          sync.Mutex
+	outSeq      int
+	inSeq       int
+}
+
+// ServeConn runs a session over a connection until the initiator logs out or the connection fails,
+// closing the connection when done. the first message has to be a Logon from InitiatorID to CompID
+func (a *Acceptor) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	r := NewReader(conn)
+	logon, err := r.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("reading logon: %w", err)
+	}
+	if logon.Type() != MsgType.Logon {
+		return fmt.Errorf("expected Logon (A), got %s", logon.Type())
+	}
+	heartBtInt, err := strconv.Atoi(logon.Get(Tag.HeartBtInt))
+	if err != nil || heartBtInt < 0 {
+		return fmt.Errorf("invalid HeartBtInt (108) %q", logon.Get(Tag.HeartBtInt))
+	}
+	s := &session{
+		conn:        conn,
+		compID:      a.CompID,
+		targetID:    logon.Get(Tag.SenderCompID),
+		beginString: logon.Get(Tag.BeginString),
+		outSeq:      1,
+		inSeq:       1,
+	}
+	if s.targetID != a.InitiatorID || logon.Get(Tag.TargetCompID) != a.CompID {
+		err = fmt.Errorf("logon from %s to %s not accepted", s.targetID, logon.Get(Tag.TargetCompID))
+		_ = s.send(MsgType.Logout, Field{Tag.Text, err.Error()})
+		return err
+	}
+	resendFrom, err := s.receive(logon)
+	if err != nil {
+		return err
+	}
+	if err = s.send(MsgType.Logon, Field{Tag.EncryptMethod, "0"}, Field{Tag.HeartBtInt, strconv.Itoa(heartBtInt)}); err != nil {
+		return err
+	}
+	if err = s.resend(resendFrom); err != nil {
+		return err
+	}
+
+	// heartbeat while the session is up. the initiator is given twice its heartbeat interval to send
+	// something before the session is dropped
+	done := make(chan struct{})
+	defer close(done)
+	if heartBtInt > 0 {
+		go s.heartbeat(time.Duration(heartBtInt)*time.Second, done)
+	}
+
+	for {
+		if heartBtInt > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Duration(heartBtInt) * time.Second))
+		}
+		m, err := r.ReadMessage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		resendFrom, err := s.receive(m)
+		if err != nil {
+			_ = s.send(MsgType.Logout, Field{Tag.Text, err.Error()})
+			return err
+		}
+		if err = s.resend(resendFrom); err != nil {
+			return err
+		}
+
+		switch m.Type() {
+		case MsgType.Heartbeat, MsgType.Reject:
+		case MsgType.TestRequest:
+			err = s.send(MsgType.Heartbeat, Field{Tag.TestReqID, m.Get(Tag.TestReqID)})
+		case MsgType.ResendRequest:
+			// nothing sent is worth resending, so the whole range is gap filled
+			err = s.sendSeqReset(m.Get(Tag.BeginSeqNo))
+		case MsgType.SequenceReset:
+			err = s.reset(m)
+		case MsgType.Logout:
+			return s.send(MsgType.Logout)
+		case MsgType.Logon:
+			err = s.send(MsgType.Reject, Field{Tag.RefSeqNum, m.Get(Tag.MsgSeqNum)}, Field{Tag.Text, "already logged on"})
+		default:
+			if handleErr := a.Handler(m); handleErr != nil {
+				err = s.send(MsgType.BusinessReject,
+					Field{Tag.RefSeqNum, m.Get(Tag.MsgSeqNum)},
+					Field{Tag.RefMsgType, m.Type()},
+					Field{Tag.BusinessRejectReason, "0"},
+					Field{Tag.Text, handleErr.Error()})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receive checks the sequence number of a message from the initiator, returning where a gap starts if
+// there is one. a number lower than expected is only allowed on a possible duplicate
+func (s *session) receive(m *Message) (int, error) {
+	seq, err := strconv.Atoi(m.Get(Tag.MsgSeqNum))
+	if err != nil {
+		return 0, fmt.Errorf("invalid MsgSeqNum (34) %q", m.Get(Tag.MsgSeqNum))
+	}
+	if seq < s.inSeq {
+		if m.Get(Tag.PossDupFlag) != "Y" {
+			return 0, fmt.Errorf("MsgSeqNum too low, expecting %d but received %d", s.inSeq, seq)
+		}
+		return 0, nil
+	}
+
+	resendFrom := 0
+	if seq > s.inSeq && m.Type() != MsgType.SequenceReset {
+		resendFrom = s.inSeq
+	}
+	s.inSeq = seq + 1
+
+	return resendFrom, nil
+}
+
+// resend asks the initiator to resend everything from a sequence number, if there's one
+func (s *session) resend(from int) error {
+	if from == 0 {
+		return nil
+	}
+	return s.send(MsgType.ResendRequest, Field{Tag.BeginSeqNo, strconv.Itoa(from)}, Field{Tag.EndSeqNo, "0"})
+}
+
+// reset moves the next expected sequence number forward on a SequenceReset
+func (s *session) reset(m *Message) error {
+	newSeq, err := strconv.Atoi(m.Get(Tag.NewSeqNo))
+	if err != nil {
+		return fmt.Errorf("invalid NewSeqNo (36) %q", m.Get(Tag.NewSeqNo))
+	}
+	if newSeq > s.inSeq {
+		s.inSeq = newSeq
+	}
+
+	return nil
+}
+
+// sendSeqReset gap fills a resend request from the initiator up to the next sequence number to be sent
+func (s *session) sendSeqReset(beginSeqNo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.header(MsgType.SequenceReset)
+	m.Set(Tag.MsgSeqNum, beginSeqNo)
+	m.Set(Tag.PossDupFlag, "Y")
+	m.Set(Tag.GapFillFlag, "Y")
+	m.Set(Tag.NewSeqNo, strconv.Itoa(s.outSeq))
+	_, err := s.conn.Write(m.Bytes())
+
+	return err
+}
+
+// heartbeat sends a Heartbeat every interval until done
+func (s *session) heartbeat(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.send(MsgType.Heartbeat); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send sends a message to the initiator with the next sequence number
+func (s *session) send(msgType string, fields ...Field) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.header(msgType)
+	m.Set(Tag.MsgSeqNum, strconv.Itoa(s.outSeq))
+	for _, f := range fields {
+		m.Set(f.Tag, f.Val)
+	}
+	if _, err := s.conn.Write(m.Bytes()); err != nil {
+		return err
+	}
+	s.outSeq++
+
+	return nil
+}
+
+// header starts a message to the initiator
+func (s *session) header(msgType string) *Message {
+	m := &Message{}
+	m.Set(Tag.BeginString, s.beginString)
+	m.Set(Tag.MsgType, msgType)
+	m.Set(Tag.SenderCompID, s.compID)
+	m.Set(Tag.TargetCompID, s.targetID)
+	m.Set(Tag.MsgSeqNum, "")
+	m.Set(Tag.SendingTime, time.Now().UTC().Format(sendingTimeFmt))
+
+	return m
+}
@@ -0,0 +1,132 @@
+package fix
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// initiator is the other end of a test session
+type initiator struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *Reader
+	seq    int
+	sender string
+	target string
+}
+
+// send sends a message with the next sequence number
+func (i *initiator) send(msgType string, fields ...Field) {
+	i.seq++
+	m := &Message{}
+	m.Set(Tag.BeginString, "FIX.4.4")
+	m.Set(Tag.MsgType, msgType)
+	m.Set(Tag.SenderCompID, i.sender)
+	m.Set(Tag.TargetCompID, i.target)
+	m.Set(Tag.MsgSeqNum, strconv.Itoa(i.seq))
+	for _, f := range fields {
+		m.Set(f.Tag, f.Val)
+	}
+	if _, err := i.conn.Write(m.Bytes()); err != nil {
+		i.t.Fatalf("initiator write failed: %v", err)
+	}
+}
+
+// expect reads the next message, checking its type
+func (i *initiator) expect(msgType string) *Message {
+	m, err := i.r.ReadMessage()
+	if err != nil {
+		i.t.Fatalf("initiator read failed: %v", err)
+	}
+	if m.Type() != msgType {
+		i.t.Fatalf("initiator expected message type %s, got: %v", msgType, m)
+	}
+	return m
+}
+
+func TestAcceptor(t *testing.T) {
+	var handled []string
+	acceptor := &Acceptor{
+		CompID:      "VGN",
+		InitiatorID: "OMS",
+		Handler: func(m *Message) error {
+			handled = append(handled, m.Get(Tag.ExecID))
+			if m.Get(Tag.ExecID) == "bad" {
+				return fmt.Errorf("unknown account")
+			}
+			return nil
+		},
+	}
+
+	server, client := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- acceptor.ServeConn(server)
+	}()
+	i := &initiator{t: t, conn: client, r: NewReader(client), sender: "OMS", target: "VGN"}
+
+	i.send(MsgType.Logon, Field{Tag.EncryptMethod, "0"}, Field{Tag.HeartBtInt, "0"})
+	logon := i.expect(MsgType.Logon)
+	if logon.Get(Tag.SenderCompID) != "VGN" || logon.Get(Tag.TargetCompID) != "OMS" || logon.Get(Tag.MsgSeqNum) != "1" {
+		t.Errorf("Acceptor logon incorrect, got: %v", logon)
+	}
+
+	i.send(MsgType.TestRequest, Field{Tag.TestReqID, "ping"})
+	if hb := i.expect(MsgType.Heartbeat); hb.Get(Tag.TestReqID) != "ping" {
+		t.Errorf("Acceptor heartbeat incorrect, got: %v", hb)
+	}
+
+	i.send(MsgType.ExecutionReport, Field{Tag.ExecID, "E1"})
+	i.send(MsgType.ExecutionReport, Field{Tag.ExecID, "bad"})
+	if reject := i.expect(MsgType.BusinessReject); reject.Get(Tag.RefSeqNum) != "4" || reject.Get(Tag.Text) != "unknown account" {
+		t.Errorf("Acceptor business reject incorrect, got: %v", reject)
+	}
+
+	// skip a sequence number
+	i.seq++
+	i.send(MsgType.ExecutionReport, Field{Tag.ExecID, "E3"})
+	if resend := i.expect(MsgType.ResendRequest); resend.Get(Tag.BeginSeqNo) != "5" {
+		t.Errorf("Acceptor resend request incorrect, got: %v", resend)
+	}
+
+	i.send(MsgType.Logout)
+	i.expect(MsgType.Logout)
+	if err := <-done; err != nil {
+		t.Errorf("Acceptor session failed: %v", err)
+	}
+	if fmt.Sprint(handled) != "[E1 bad E3]" {
+		t.Errorf("Acceptor handled incorrect, got: %v", handled)
+	}
+}
+
+func TestAcceptorLogon(t *testing.T) {
+	acceptor := &Acceptor{CompID: "VGN", InitiatorID: "OMS", Handler: func(m *Message) error { return nil }}
+	server, client := net.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- acceptor.ServeConn(server)
+	}()
+
+	i := &initiator{t: t, conn: client, r: NewReader(client), sender: "OMS", target: "VGN"}
+	i.send(MsgType.Heartbeat)
+	if err := <-done; err == nil {
+		t.Errorf("Acceptor expected an error for a session not starting with a logon")
+	}
+
+	// a logon from another initiator, or to another acceptor, is logged out
+	for _, ids := range [][2]string{{"EVE", "VGN"}, {"OMS", "OTHER"}} {
+		server, client := net.Pipe()
+		go func() {
+			done <- acceptor.ServeConn(server)
+		}()
+
+		i := &initiator{t: t, conn: client, r: NewReader(client), sender: ids[0], target: ids[1]}
+		i.send(MsgType.Logon, Field{Tag.EncryptMethod, "0"}, Field{Tag.HeartBtInt, "0"})
+		i.expect(MsgType.Logout)
+		if err := <-done; err == nil {
+			t.Errorf("Acceptor expected an error for a logon from %s to %s", ids[0], ids[1])
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package fix reads and writes FIX 4.2/4.4 tag=value messages and runs a minimal acceptor session. it
+// covers what a drop copy of executions needs, not the whole protocol
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const soh = '\x01'
+
+type tag struct {
+	Account              int
+	BeginSeqNo           int
+	BeginString          int
+	BodyLength           int
+	CheckSum             int
+	ClOrdID              int
+	Commission           int
+	CommType             int
+	Currency             int
+	EndSeqNo             int
+	ExecID               int
+	ExecTransType        int
+	IDSource             int
+	LastPx               int
+	LastShares           int
+	MsgSeqNum            int
+	MsgType              int
+	NewSeqNo             int
+	OrderID              int
+	PossDupFlag          int
+	RefSeqNum            int
+	SecurityID           int
+	SenderCompID         int
+	SendingTime          int
+	SettlDate            int
+	Side                 int
+	Symbol               int
+	TargetCompID         int
+	Text                 int
+	TransactTime         int
+	TradeDate            int
+	EncryptMethod        int
+	HeartBtInt           int
+	TestReqID            int
+	GapFillFlag          int
+	ExecType             int
+	RefMsgType           int
+	BusinessRejectReason int
+}
+
+type msgType struct {
+	Heartbeat       string
+	TestRequest     string
+	ResendRequest   string
+	Reject          string
+	SequenceReset   string
+	Logout          string
+	ExecutionReport string
+	Logon           string
+	BusinessReject  string
+}
+
+var (
+	// Tag defines the numbers of the fields used
+	Tag = tag{
+		Account:              1,
+		BeginSeqNo:           7,
+		BeginString:          8,
+		BodyLength:           9,
+		CheckSum:             10,
+		ClOrdID:              11,
+		Commission:           12,
+		CommType:             13,
+		Currency:             15,
+		EndSeqNo:             16,
+		ExecID:               17,
+		ExecTransType:        20,
+		IDSource:             22,
+		LastPx:               31,
+		LastShares:           32,
+		MsgSeqNum:            34,
+		MsgType:              35,
+		NewSeqNo:             36,
+		OrderID:              37,
+		PossDupFlag:          43,
+		RefSeqNum:            45,
+		SecurityID:           48,
+		SenderCompID:         49,
+		SendingTime:          52,
+		SettlDate:            64,
+		Side:                 54,
+		Symbol:               55,
+		TargetCompID:         56,
+		Text:                 58,
+		TransactTime:         60,
+		TradeDate:            75,
+		EncryptMethod:        98,
+		HeartBtInt:           108,
+		TestReqID:            112,
+		GapFillFlag:          123,
+		ExecType:             150,
+		RefMsgType:           372,
+		BusinessRejectReason: 380}
+
+	// MsgType defines the message types used
+	MsgType = msgType{
+		Heartbeat:       "0",
+		TestRequest:     "1",
+		ResendRequest:   "2",
+		Reject:          "3",
+		SequenceReset:   "4",
+		Logout:          "5",
+		ExecutionReport: "8",
+		Logon:           "A",
+		BusinessReject:  "j"}
+)
+
+// Field is a tag=value pair
+type Field struct {
+	Tag int
+	Val string
+}
+
+// Message is a FIX message, its fields in the order they were read or set. line is the line of the log
+// the message was read from, if any
+type Message struct {
+	Fields []Field
+	Line   int
+}
+
+// Get gets the value of a field, or "" if the message doesn't have it
+func (m *Message) Get(tag int) string {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Val
+		}
+	}
+	return ""
+}
+
+// Set sets the value of a field, adding it if the message doesn't have it
+func (m *Message) Set(tag int, val string) {
+	for i, f := range m.Fields {
+		if f.Tag == tag {
+			m.Fields[i].Val = val
+			return
+		}
+	}
+	m.Fields = append(m.Fields, Field{Tag: tag, Val: val})
+}
+
+// Type gets the message type
+func (m *Message) Type() string {
+	return m.Get(Tag.MsgType)
+}
+
+// Bytes encodes the message, working out its body length and checksum. the begin string and message type
+// lead the message whatever order they were set in
+func (m *Message) Bytes() []byte {
+	var body bytes.Buffer
+	writeField(&body, Tag.MsgType, m.Type())
+	for _, f := range m.Fields {
+		switch f.Tag {
+		case Tag.BeginString, Tag.BodyLength, Tag.CheckSum, Tag.MsgType:
+			continue
+		}
+		writeField(&body, f.Tag, f.Val)
+	}
+
+	var msg bytes.Buffer
+	writeField(&msg, Tag.BeginString, m.Get(Tag.BeginString))
+	writeField(&msg, Tag.BodyLength, strconv.Itoa(body.Len()))
+	msg.Write(body.Bytes())
+	writeField(&msg, Tag.CheckSum, fmt.Sprintf("%03d", checkSum(msg.Bytes())))
+
+	return msg.Bytes()
+}
+
+// String formats the message the way logs usually do, with | between fields
+func (m *Message) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{soh}, []byte{'|'}))
+}
+
+// writeField writes a tag=value field
+func writeField(b *bytes.Buffer, tag int, val string) {
+	b.WriteString(strconv.Itoa(tag))
+	b.WriteByte('=')
+	b.WriteString(val)
+	b.WriteByte(soh)
+}
+
+// checkSum sums the bytes of a message, modulo 256
+func checkSum(data []byte) int {
+	sum := 0
+	for _, b := range data {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+// Parse parses an encoded message, checking its body length and checksum. fields can be separated by SOH
+// or, as in most logs, by |
+func Parse(data []byte) (*Message, error) {
+	if bytes.IndexByte(data, soh) < 0 {
+		data = bytes.ReplaceAll(data, []byte{'|'}, []byte{soh})
+	}
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 || data[len(data)-1] != soh {
+		data = append(data, soh)
+	}
+
+	m := &Message{}
+	bodyStart, trailerStart := 0, 0
+	for pos := 0; pos < len(data); {
+		end := pos + bytes.IndexByte(data[pos:], soh)
+		eq := bytes.IndexByte(data[pos:end], '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid field %q", data[pos:end])
+		}
+		tag, err := strconv.Atoi(string(data[pos : pos+eq]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag %q", data[pos:pos+eq])
+		}
+		switch {
+		case len(m.Fields) == 0 && tag != Tag.BeginString:
+			return nil, fmt.Errorf("message doesn't start with BeginString (8)")
+		case len(m.Fields) == 1 && tag != Tag.BodyLength:
+			return nil, fmt.Errorf("BeginString (8) isn't followed by BodyLength (9)")
+		case len(m.Fields) == 1:
+			bodyStart = end + 1
+		case tag == Tag.CheckSum:
+			trailerStart = pos
+		}
+		m.Fields = append(m.Fields, Field{Tag: tag, Val: string(data[pos+eq+1 : end])})
+		pos = end + 1
+		if tag == Tag.CheckSum && pos < len(data) {
+			return nil, fmt.Errorf("fields found after CheckSum (10)")
+		}
+	}
+	if trailerStart == 0 {
+		return nil, fmt.Errorf("message has no CheckSum (10)")
+	}
+
+	if bodyLength, err := strconv.Atoi(m.Get(Tag.BodyLength)); err != nil || bodyLength != trailerStart-bodyStart {
+		return nil, fmt.Errorf("BodyLength (9) is %s, expected %d", m.Get(Tag.BodyLength), trailerStart-bodyStart)
+	}
+	if sum, err := strconv.Atoi(m.Get(Tag.CheckSum)); err != nil || sum != checkSum(data[:trailerStart]) {
+		return nil, fmt.Errorf("CheckSum (10) is %s, expected %03d", m.Get(Tag.CheckSum), checkSum(data[:trailerStart]))
+	}
+	if m.Type() == "" {
+		return nil, fmt.Errorf("message has no MsgType (35)")
+	}
+
+	return m, nil
+}
+
+// Reader reads messages from a stream, such as a session's connection
+type Reader struct {
+	br *bufio.Reader
+}
+
+// NewReader creates a Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// ReadMessage reads the next message, through its checksum field
+func (r *Reader) ReadMessage() (*Message, error) {
+	var data []byte
+	for {
+		field, err := r.br.ReadBytes(soh)
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(data) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		data = append(data, field...)
+		if bytes.HasPrefix(field, []byte("10=")) {
+			return Parse(data)
+		}
+	}
+}
+
+// ReadLog reads the messages from a FIX log. each line holds a message, which may be preceded by anything
+// (e.g., a timestamp) up to its BeginString; lines without a message are skipped
+func ReadLog(r io.Reader) ([]*Message, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var msgs []*Message
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		start := strings.Index(text, "8=FIX")
+		if start < 0 {
+			continue
+		}
+		m, err := Parse([]byte(text[start:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		m.Line = line
+		msgs = append(msgs, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading fix log: %w", err)
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,66 @@
+package fix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	m := &Message{}
+	m.Set(Tag.BeginString, "FIX.4.2")
+	m.Set(Tag.MsgType, MsgType.ExecutionReport)
+	m.Set(Tag.ExecID, "E1")
+	m.Set(Tag.LastPx, "101.25")
+
+	parsed, err := Parse(m.Bytes())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if parsed.Type() != MsgType.ExecutionReport || parsed.Get(Tag.ExecID) != "E1" || parsed.Get(Tag.LastPx) != "101.25" {
+		t.Errorf("Parse incorrect, got: %v", parsed)
+	}
+
+	// logs separate fields with |
+	if _, err = Parse([]byte(m.String())); err != nil {
+		t.Errorf("Parse of a | separated message failed: %v", err)
+	}
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"35=8|8=FIX.4.2|10=000|", "doesn't start with BeginString"},
+		{"8=FIX.4.2|35=8|10=000|", "isn't followed by BodyLength"},
+		{"8=FIX.4.2|9=5|35=8|", "no CheckSum"},
+		{"8=FIX.4.2|9=4|35=8|10=000|", "BodyLength (9) is 4"},
+		{"8=FIX.4.2|9=5|35=8|10=000|", "CheckSum (10) is 000"},
+		{"8=FIX.4.2|9=5|35=8|10=000|55=X|", "after CheckSum"},
+	}
+	for _, test := range tests {
+		if _, err := Parse([]byte(test.data)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("Parse(%q) error incorrect, got: %v, want: %s", test.data, err, test.want)
+		}
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	m := &Message{}
+	m.Set(Tag.BeginString, "FIX.4.4")
+	m.Set(Tag.MsgType, MsgType.Heartbeat)
+	log := "session started\n" +
+		"20210301-14:30:00.000 : " + m.String() + "\n" +
+		"\n" +
+		string(m.Bytes()) + "\n"
+
+	msgs, err := ReadLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("ReadLog failed: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Line != 2 || msgs[1].Line != 4 {
+		t.Errorf("ReadLog incorrect, got %d messages: %v", len(msgs), msgs)
+	}
+
+	if _, err = ReadLog(strings.NewReader("8=FIX.4.4|9=1|35=0|10=000|\n")); err == nil || !strings.HasPrefix(err.Error(), "line 1") {
+		t.Errorf("ReadLog of a bad message error incorrect, got: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package guard enforces lock dates, rejecting changes on or before a legal entity's or account's lock
+// date unless the caller has permission to override it. every override is recorded in the Lock store, with
+// the database transaction of the change it lets through when the guard is bound to one
+package guard
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/config"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// UserKey is the metadata key identifying the caller
+	UserKey = "x-user-id"
+	// OverrideKey is the metadata key a caller passes the reason for overriding a lock date in
+	OverrideKey = "x-lock-override"
+)
+
+// Target is the legal entity and account a change is made to
+type Target struct {
+	LeOrgID string
+	AcctID  string
+}
+
+// Guard checks changes against the lock dates in the Lock store
+type Guard struct {
+	lockStore     lockStore.Store
+	overrideUsers map[string]bool
+}
+
+// NewGuard creates a new Guard. only the users passed in may override a lock date
+func NewGuard(lockStore lockStore.Store, overrideUsers []string) *Guard {
+	g := &Guard{
+		lockStore:     lockStore,
+		overrideUsers: make(map[string]bool, len(overrideUsers)),
+	}
+	for _, user := range overrideUsers {
+		if user != "" {
+			g.overrideUsers[user] = true
+		}
+	}
+
+	return g
+}
+
+// WithTx gets a copy of the guard bound to a database transaction, so the overrides it records are only
+// kept if the change they let through commits
+func (g *Guard) WithTx(tx *pg.Tx) *Guard {
+	return &Guard{
+		lockStore:     g.lockStore.WithTx(tx),
+		overrideUsers: g.overrideUsers,
+	}
+}
+
+// Check makes sure a change on a date to a legal entity or account isn't on or before its lock date. refID
+// identifies what's being changed in the override record, if there is one
+func (g *Guard) Check(ctx context.Context, target Target, dt string, refID string) error {
+	return g.check(ctx, targetMatch(target), dt, refID)
+}
+
+// CheckAll makes sure a change on a date that touches every legal entity and account isn't on or before
+// any lock date
+func (g *Guard) CheckAll(ctx context.Context, dt string, refID string) error {
+	return g.check(ctx, func(lock *storage.Lock) bool { return true }, dt, refID)
+}
+
+// Locked gets the lock date a change on a date to a legal entity or account falls on or before, or "" if
+// it isn't locked. unlike Check it doesn't consider or record overrides
+func (g *Guard) Locked(ctx context.Context, target Target, dt string) (string, error) {
+	lock, err := g.find(ctx, targetMatch(target), dateOnly(dt))
+	if err != nil || lock == nil {
+		return "", err
+	}
+
+	return dateOnly(lock.GetLockDt()), nil
+}
+
+// targetMatch matches the locks of a target's legal entity or account
+func targetMatch(target Target) func(lock *storage.Lock) bool {
+	return func(lock *storage.Lock) bool {
+		return (lock.GetLeOrgId() != "" && lock.GetLeOrgId() == target.LeOrgID) ||
+			(lock.GetAcctId() != "" && lock.GetAcctId() == target.AcctID)
+	}
+}
+
+// find finds the latest matching lock a date falls on or before, if there is one
+func (g *Guard) find(ctx context.Context, match func(lock *storage.Lock) bool, dt string) (*storage.Lock, error) {
+	if dt == "" {
+		return nil, nil
+	}
+
+	locks, _, err := g.lockStore.ListLocks(ctx, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	var lock *storage.Lock
+	for _, l := range locks {
+		lockDt := dateOnly(l.GetLockDt())
+		if match(l) && dt <= lockDt && (lock == nil || lockDt > dateOnly(lock.GetLockDt())) {
+			lock = l
+		}
+	}
+
+	return lock, nil
+}
+
+// check finds the latest matching lock a date falls on or before and, if there is one, lets the change
+// through only for a permitted caller passing an override reason
+func (g *Guard) check(ctx context.Context, match func(lock *storage.Lock) bool, dt string, refID string) error {
+	dt = dateOnly(dt)
+	lock, err := g.find(ctx, match, dt)
+	if err != nil || lock == nil {
+		return err
+	}
+
+	user, reason := caller(ctx)
+	if reason == "" {
+		return status.Errorf(codes.FailedPrecondition, "%s is on or before the lock date %s", dt, dateOnly(lock.GetLockDt()))
+	}
+	if !g.overrideUsers[user] {
+		return status.Errorf(codes.PermissionDenied, "user %q can't override the lock date %s", user, dateOnly(lock.GetLockDt()))
+	}
+
+	// record the override
+	method, _ := grpc.Method(ctx)
+	_, err = g.lockStore.CreateLockOverride(ctx, &storage.LockOverride{
+		LockId: lock.GetId(),
+		UserId: user,
+		Method: method,
+		RefId:  refID,
+		Dt:     dt,
+		Reason: reason,
+	})
+
+	return err
+}
+
+// caller gets the calling user and lock override reason from the incoming metadata. the user is trusted as
+// is, so it must only be set by the gateway's trusted proxy (or the command line)
+func caller(ctx context.Context) (string, string) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", ""
+	}
+
+	return first(md.Get(UserKey)), first(md.Get(OverrideKey))
+}
+
+// first gets the first of a set of metadata values
+func first(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// dateOnly strips any time portion from an api date
+func dateOnly(dt string) string {
+	if len(dt) > len(config.APIFormats.DateFmt) {
+		return dt[:len(config.APIFormats.DateFmt)]
+	}
+	return dt
+}
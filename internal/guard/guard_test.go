@@ -0,0 +1,161 @@
+package guard
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStore keeps locks and the overrides recorded against them in memory
+type fakeStore struct {
+	locks     []*storage.Lock
+	overrides []*storage.LockOverride
+}
+
+func (f *fakeStore) GetLock(ctx context.Context, id string) (*storage.Lock, error) {
+	for _, lock := range f.locks {
+		if lock.GetId() == id {
+			return lock, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "lock %s not found", id)
+}
+
+func (f *fakeStore) ListLocks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Lock, string, error) {
+	return f.locks, "", nil
+}
+
+func (f *fakeStore) SetLock(ctx context.Context, lock *storage.Lock) (*storage.Lock, error) {
+	f.locks = append(f.locks, lock)
+	return lock, nil
+}
+
+func (f *fakeStore) DeleteLock(ctx context.Context, id string) error {
+	return nil
+}
+
+func (f *fakeStore) CreateLockOverride(ctx context.Context, lockOverride *storage.LockOverride) (*storage.LockOverride, error) {
+	f.overrides = append(f.overrides, lockOverride)
+	return lockOverride, nil
+}
+
+func (f *fakeStore) ListLockOverrides(ctx context.Context, pageSize int32, pageToken string, lockID string) ([]*storage.LockOverride, string, error) {
+	return f.overrides, "", nil
+}
+
+func (f *fakeStore) WithTx(tx *pg.Tx) lockStore.Store {
+	return f
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{locks: []*storage.Lock{
+		{Id: "lock_org", LeOrgId: "org_a", LockDt: "2020-03-31T00:00:00Z"},
+		{Id: "lock_acct", AcctId: "acct_a", LockDt: "2020-06-30"},
+	}}
+}
+
+// callerCtx creates an incoming context for a caller, leaving out any metadata that's empty
+func callerCtx(user string, reason string) context.Context {
+	md := metadata.MD{}
+	if user != "" {
+		md.Set(UserKey, user)
+	}
+	if reason != "" {
+		md.Set(OverrideKey, reason)
+	}
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestCheck(t *testing.T) {
+	g := NewGuard(newFakeStore(), nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		target Target
+		dt     string
+		code   codes.Code
+	}{
+		{Target{LeOrgID: "org_a"}, "2020-03-31", codes.FailedPrecondition},
+		{Target{LeOrgID: "org_a"}, "2020-03-31T12:00:00Z", codes.FailedPrecondition},
+		{Target{LeOrgID: "org_a"}, "2020-04-01", codes.OK},
+		{Target{LeOrgID: "org_a", AcctID: "acct_a"}, "2020-06-30", codes.FailedPrecondition},
+		{Target{LeOrgID: "org_b", AcctID: "acct_b"}, "2019-01-01", codes.OK},
+		{Target{LeOrgID: "org_a"}, "", codes.OK},
+	}
+	for _, test := range tests {
+		if err := g.Check(ctx, test.target, test.dt, ""); status.Code(err) != test.code {
+			t.Errorf("Check(%v, %s) got: %v, want: %v", test.target, test.dt, err, test.code)
+		}
+	}
+
+	if err := g.CheckAll(ctx, "2020-06-30", ""); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CheckAll on a lock date expected a failed precondition error, got: %v", err)
+	}
+	if err := g.CheckAll(ctx, "2020-07-01", ""); err != nil {
+		t.Errorf("CheckAll after every lock date got: %v", err)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	g := NewGuard(newFakeStore(), nil)
+
+	// the latest lock date of the legal entity and account wins
+	lockDt, err := g.Locked(context.Background(), Target{LeOrgID: "org_a", AcctID: "acct_a"}, "2020-01-01")
+	if err != nil || lockDt != "2020-06-30" {
+		t.Errorf("Locked got: %q, %v, want: 2020-06-30", lockDt, err)
+	}
+	lockDt, err = g.Locked(context.Background(), Target{LeOrgID: "org_a"}, "2020-04-01")
+	if err != nil || lockDt != "" {
+		t.Errorf("Locked after the lock date got: %q, %v, want it unlocked", lockDt, err)
+	}
+}
+
+func TestOverride(t *testing.T) {
+	store := newFakeStore()
+	g := NewGuard(store, []string{"controller", ""})
+	target := Target{AcctID: "acct_a"}
+
+	tests := []struct {
+		name   string
+		user   string
+		reason string
+		code   codes.Code
+	}{
+		{"no reason", "controller", "", codes.FailedPrecondition},
+		{"not allowed", "trader", "late fill", codes.PermissionDenied},
+		// the gateway drops the caller header unless a trusted proxy sets it, leaving no user
+		{"untrusted header", "", "late fill", codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		if err := g.Check(callerCtx(test.user, test.reason), target, "2020-06-01", "txn_a"); status.Code(err) != test.code {
+			t.Errorf("%s got: %v, want: %v", test.name, err, test.code)
+		}
+	}
+	if len(store.overrides) != 0 {
+		t.Fatalf("rejected overrides were recorded: %v", store.overrides)
+	}
+
+	// an allowed user passing a reason gets through, and the override is recorded
+	if err := g.Check(callerCtx("controller", "late fill"), target, "2020-06-01T09:30:00Z", "txn_a"); err != nil {
+		t.Fatalf("override got: %v", err)
+	}
+	if len(store.overrides) != 1 {
+		t.Fatalf("got %d overrides recorded, want: 1", len(store.overrides))
+	}
+	override := store.overrides[0]
+	if override.GetLockId() != "lock_acct" || override.GetUserId() != "controller" || override.GetRefId() != "txn_a" ||
+		override.GetDt() != "2020-06-01" || override.GetReason() != "late fill" {
+		t.Errorf("recorded override got: %v", override)
+	}
+
+	// nothing is recorded for a change that isn't locked
+	if err := g.Check(callerCtx("controller", "late fill"), target, "2020-07-01", "txn_a"); err != nil || len(store.overrides) != 1 {
+		t.Errorf("unlocked change got: %v with %d overrides recorded, want: 1", err, len(store.overrides))
+	}
+}
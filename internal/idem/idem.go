@@ -0,0 +1,190 @@
+// Package idem makes the create and process rpcs safe to retry. a caller passes an idempotency key with a
+// request and the response is kept under the key for a retention window, so a retry with the same key and
+// request gets the original response back instead of running again
+package idem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/wolfinger/varangian/generated/storage"
+	idemStore "github.com/wolfinger/varangian/idem/store"
+	"github.com/wolfinger/varangian/internal/guard"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	// Key is the metadata key (and http header) a caller passes an idempotency key in
+	Key = "idempotency-key"
+	// MaxKeyLen is the longest an idempotency key can be
+	MaxKeyLen = 255
+	// DefaultRetention is how long responses are kept for when no retention window is set
+	DefaultRetention = 24 * time.Hour
+	// DefaultLease is how long a request has to finish when no lease is set, before a retry with its key
+	// can take the key over
+	DefaultLease = 5 * time.Minute
+)
+
+// prefixes of the rpcs that take an idempotency key
+var prefixes = []string{"Create", "BatchCreate", "Process"}
+
+// Keeper keeps the responses of requests made with an idempotency key in the Idempotency Key store
+type Keeper struct {
+	idemStore idemStore.Store
+	retention time.Duration
+	lease     time.Duration
+}
+
+// NewKeeper creates a new Keeper, keeping responses for the retention window passed in. a request that
+// hasn't kept its response by the end of its lease (e.g. its server went down) is taken to have stopped,
+// so the lease has to outlast the slowest request
+func NewKeeper(idemStore idemStore.Store, retention time.Duration, lease time.Duration) *Keeper {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+
+	return &Keeper{
+		idemStore: idemStore,
+		retention: retention,
+		lease:     lease,
+	}
+}
+
+// Interceptor runs the create and process rpcs at most once per idempotency key. requests without a key and
+// the other rpcs are run as they are
+func (k *Keeper) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := incomingKey(ctx)
+	msg, ok := req.(proto.Message)
+	if key == "" || !ok || !covered(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	if len(key) > MaxKeyLen {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key can be at most %d characters", MaxKeyLen)
+	}
+
+	reqHash, err := hash(ctx, info.FullMethod, msg)
+	if err != nil {
+		return nil, err
+	}
+	idemKey, reserved, err := k.idemStore.ReserveIdemKey(ctx, &storage.IdemKey{Key: key, ReqHash: reqHash}, k.retention, k.lease)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key was used before, so replay its response
+	if !reserved {
+		switch {
+		case idemKey.GetReqHash() != reqHash:
+			return nil, status.Errorf(codes.InvalidArgument, "idempotency key %s was already used for a different request", key)
+		case len(idemKey.GetResp()) == 0:
+			return nil, status.Errorf(codes.Aborted, "a request with idempotency key %s is still running, retry it later", key)
+		}
+		var resp anypb.Any
+		if err = protov2.Unmarshal(idemKey.GetResp(), &resp); err != nil {
+			return nil, err
+		}
+		orig, err := resp.UnmarshalNew()
+		if err != nil {
+			return nil, err
+		}
+		return proto.MessageV1(orig), nil
+	}
+
+	// a request that fails isn't kept, so it can be retried with the same key. every rpc taking a key makes
+	// all of its changes or none of them, so running it again doesn't repeat anything it did
+	resp, err := handler(ctx, req)
+	if err != nil {
+		if relErr := k.idemStore.DeleteIdemKey(context.Background(), key, idemKey.GetLeaseId()); relErr != nil {
+			log.Print(relErr)
+		}
+		return nil, err
+	}
+
+	// the request went through, so a failure to keep its response is only logged. retries are told it's
+	// still running until its lease runs out, when one of them takes the key over and runs it again
+	if err = k.save(key, idemKey.GetLeaseId(), resp); err != nil {
+		log.Printf("keeping response of %s: %v", info.FullMethod, err)
+	}
+
+	return resp, nil
+}
+
+// save keeps a response under its idempotency key. the caller may have already given up waiting, so it's kept
+// whether or not the request's context is done
+func (k *Keeper) save(key string, leaseID string, resp interface{}) error {
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "response of type %T can't be kept", resp)
+	}
+	packed, err := anypb.New(proto.MessageV2(msg))
+	if err != nil {
+		return err
+	}
+	b, err := protov2.Marshal(packed)
+	if err != nil {
+		return err
+	}
+
+	return k.idemStore.SaveIdemKey(context.Background(), key, leaseID, b)
+}
+
+// Purge removes the responses that have outlived the retention window
+func (k *Keeper) Purge(ctx context.Context) (int, error) {
+	return k.idemStore.PurgeIdemKeys(ctx, k.retention)
+}
+
+// incomingKey gets the idempotency key from the incoming metadata
+func incomingKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if vals := md.Get(Key); len(vals) > 0 {
+		return strings.TrimSpace(vals[0])
+	}
+	return ""
+}
+
+// covered tells if an rpc takes an idempotency key
+func covered(fullMethod string) bool {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	for _, pfx := range prefixes {
+		if strings.HasPrefix(method, pfx) {
+			return true
+		}
+	}
+	return false
+}
+
+// hash fingerprints a request along with its rpc and caller, so a key can't be reused for another request
+func hash(ctx context.Context, fullMethod string, req proto.Message) (string, error) {
+	b, err := protov2.MarshalOptions{Deterministic: true}.Marshal(proto.MessageV2(req))
+	if err != nil {
+		return "", err
+	}
+
+	var user string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(guard.UserKey); len(vals) > 0 {
+			user = vals[0]
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(fullMethod + "\x00" + user + "\x00"))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
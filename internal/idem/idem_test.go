@@ -0,0 +1,159 @@
+package idem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wolfinger/varangian/generated/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeStore keeps idempotency keys in memory the way the database does, with leases that only run out
+// when the test expires them
+type fakeStore struct {
+	keys    map[string]*storage.IdemKey
+	expired map[string]bool
+	leases  int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{keys: map[string]*storage.IdemKey{}, expired: map[string]bool{}}
+}
+
+func (f *fakeStore) ReserveIdemKey(ctx context.Context, idemKey *storage.IdemKey, retention time.Duration, lease time.Duration) (*storage.IdemKey, bool, error) {
+	f.leases++
+	leaseID := fmt.Sprintf("lease_%d", f.leases)
+
+	existing, ok := f.keys[idemKey.GetKey()]
+	if !ok {
+		f.keys[idemKey.GetKey()] = &storage.IdemKey{Key: idemKey.GetKey(), ReqHash: idemKey.GetReqHash(), LeaseId: leaseID}
+		return &storage.IdemKey{Key: idemKey.GetKey(), ReqHash: idemKey.GetReqHash(), LeaseId: leaseID}, true, nil
+	}
+	if existing.GetReqHash() == idemKey.GetReqHash() && existing.GetResp() == nil && f.expired[idemKey.GetKey()] {
+		existing.LeaseId = leaseID
+		f.expired[idemKey.GetKey()] = false
+		return &storage.IdemKey{Key: idemKey.GetKey(), ReqHash: idemKey.GetReqHash(), LeaseId: leaseID}, true, nil
+	}
+
+	return &storage.IdemKey{Key: existing.GetKey(), ReqHash: existing.GetReqHash(), Resp: existing.GetResp()}, false, nil
+}
+
+func (f *fakeStore) SaveIdemKey(ctx context.Context, key string, leaseID string, resp []byte) error {
+	existing, ok := f.keys[key]
+	if !ok || existing.GetLeaseId() != leaseID {
+		return fmt.Errorf("saving idempotency key %s: lease %s was taken over", key, leaseID)
+	}
+	existing.Resp = resp
+	return nil
+}
+
+func (f *fakeStore) DeleteIdemKey(ctx context.Context, key string, leaseID string) error {
+	if existing, ok := f.keys[key]; ok && existing.GetLeaseId() == leaseID {
+		delete(f.keys, key)
+	}
+	return nil
+}
+
+func (f *fakeStore) PurgeIdemKeys(ctx context.Context, retention time.Duration) (int, error) {
+	return 0, nil
+}
+
+var createInfo = &grpc.UnaryServerInfo{FullMethod: "/api.v1.TxnService/CreateTxn"}
+
+// call runs a request with an idempotency key through the interceptor, answering with the response passed in
+func call(k *Keeper, key string, req string, resp string, err error) (string, error) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(Key, key))
+	out, err := k.Interceptor(ctx, wrapperspb.String(req), createInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+		return wrapperspb.String(resp), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return out.(*wrapperspb.StringValue).GetValue(), nil
+}
+
+func TestReplay(t *testing.T) {
+	k := NewKeeper(newFakeStore(), 0, 0)
+
+	if resp, err := call(k, "key_a", "txn_a", "first", nil); err != nil || resp != "first" {
+		t.Fatalf("first call got: %q, %v, want: first", resp, err)
+	}
+	if resp, err := call(k, "key_a", "txn_a", "second", nil); err != nil || resp != "first" {
+		t.Errorf("retry got: %q, %v, want the first response replayed", resp, err)
+	}
+	if _, err := call(k, "key_a", "txn_b", "second", nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("retry with another request expected an invalid argument error, got: %v", err)
+	}
+}
+
+func TestFailedRequest(t *testing.T) {
+	k := NewKeeper(newFakeStore(), 0, 0)
+
+	if _, err := call(k, "key_a", "txn_a", "", errors.New("failed")); err == nil {
+		t.Fatal("failed call expected an error")
+	}
+	if resp, err := call(k, "key_a", "txn_a", "second", nil); err != nil || resp != "second" {
+		t.Errorf("retry of a failed request got: %q, %v, want it run again", resp, err)
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	for _, origErr := range []error{nil, errors.New("failed")} {
+		store := newFakeStore()
+		k := NewKeeper(store, 0, 0)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(Key, "key_a"))
+
+		// the original request is still running when its lease runs out and a retry takes the key over
+		_, err := k.Interceptor(ctx, wrapperspb.String("txn_a"), createInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if _, err := call(k, "key_a", "txn_a", "retry", nil); status.Code(err) != codes.Aborted {
+				t.Errorf("retry while running expected an aborted error, got: %v", err)
+			}
+			store.expired["key_a"] = true
+			if resp, err := call(k, "key_a", "txn_a", "retry", nil); err != nil || resp != "retry" {
+				t.Errorf("retry after the lease ran out got: %q, %v, want it run", resp, err)
+			}
+			if origErr != nil {
+				return nil, origErr
+			}
+			return wrapperspb.String("orig"), nil
+		})
+		if err != origErr {
+			t.Errorf("original request got: %v, want: %v", err, origErr)
+		}
+
+		// the original request neither released the retry's key nor replaced its response
+		if resp, err := call(k, "key_a", "txn_a", "third", nil); err != nil || resp != "retry" {
+			t.Errorf("call after the original request stopped (%v) got: %q, %v, want: retry", origErr, resp, err)
+		}
+	}
+}
+
+func TestUncovered(t *testing.T) {
+	k := NewKeeper(newFakeStore(), 0, 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(Key, "key_a"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.v1.TxnService/UpdateTxn"}
+
+	runs := 0
+	for i := 0; i < 2; i++ {
+		_, err := k.Interceptor(ctx, wrapperspb.String("txn_a"), info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			runs++
+			return wrapperspb.String("updated"), nil
+		})
+		if err != nil {
+			t.Fatalf("UpdateTxn failed: %v", err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("UpdateTxn ran %d times, want: 2", runs)
+	}
+}
@@ -0,0 +1,121 @@
+package imp
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wolfinger/varangian/generated/storage"
+)
+
+// ParseCSV parses txns from a csv file with a header line. the mapping maps csv columns to the fields they
+// are imported into; without a mapping the columns must be named after the fields. columns left out of a
+// mapping are ignored. an error is only returned if the file itself can't be read; problems with a row are
+// recorded on the row
+func ParseCSV(r io.Reader, mapping map[string]string) ([]*Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("csv has no header")
+		}
+		return nil, fmt.Errorf("reading csv header: %w", err)
+	}
+	fields, err := mapColumns(header, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*Row
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading csv line %d: %w", line, err)
+		}
+		if blank(record) {
+			continue
+		}
+
+		row := &Row{
+			Line: line,
+			Txn:  &storage.Txn{},
+		}
+		if len(record) > len(header) {
+			row.Fail("", "expected %d columns, found %d", len(header), len(record))
+		}
+		for i, val := range record {
+			if i < len(fields) && fields[i] != "" {
+				row.set(fields[i], val)
+			}
+		}
+		row.check()
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// mapColumns maps each csv column to the field it's imported into, or "" if it isn't imported
+func mapColumns(header []string, mapping map[string]string) ([]string, error) {
+	fields := make([]string, len(header))
+	mapped := make(map[string]string, len(header))
+	for i, col := range header {
+		col = column(col)
+		field := col
+		if mapping != nil {
+			field = mapping[col]
+			if field == "" {
+				continue
+			}
+		}
+		if !validField(field) {
+			return nil, fmt.Errorf("column %q maps to unknown field %q", col, field)
+		}
+		if prev, ok := mapped[field]; ok {
+			return nil, fmt.Errorf("columns %q and %q both map to field %q", prev, col, field)
+		}
+		mapped[field] = col
+		fields[i] = field
+	}
+
+	for col := range mapping {
+		if !contains(header, col) {
+			return nil, fmt.Errorf("mapped column %q not found in csv header", col)
+		}
+	}
+
+	return fields, nil
+}
+
+// blank checks if every value of a csv record is empty
+func blank(record []string) bool {
+	for _, val := range record {
+		if strings.TrimSpace(val) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// contains checks if a csv header contains a column
+func contains(header []string, col string) bool {
+	for _, h := range header {
+		if column(h) == col {
+			return true
+		}
+	}
+	return false
+}
+
+// column cleans up a csv column name, dropping any byte order mark left at the start of the file
+func column(col string) string {
+	return strings.TrimSpace(strings.TrimPrefix(col, "\ufeff"))
+}
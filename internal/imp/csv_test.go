@@ -0,0 +1,70 @@
+package imp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	data := `Trade Date,Type,Side,Symbol,Quantity,Net Amount,Account,Notes
+2021-03-01,trade,BUY,AAPL,100,"12,000.50",Main,first
+2021-03-02,trade,sell,MSFT,abc,500,Main,
+,,,,,,,
+2021-13-01,trade,buy,IBM,10,100,Main,
+`
+	mapping := map[string]string{
+		"Trade Date": "txn_dt",
+		"Type":       "txn_type",
+		"Side":       "txn_sub_type",
+		"Symbol":     "ticker",
+		"Quantity":   "txn_size",
+		"Net Amount": "trade_amt_net",
+		"Account":    "acct_name",
+	}
+
+	rows, err := ParseCSV(strings.NewReader(data), mapping)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("ParseCSV found %d rows, want: 3 (blank lines skipped)", len(rows))
+	}
+
+	first := rows[0]
+	if len(first.Errs) != 0 {
+		t.Errorf("ParseCSV line 2 has errors: %v", first.Errs)
+	}
+	if first.Txn.GetTxnSubType() != "buy" || first.Txn.GetTxnSize() != 100 || first.Txn.GetTradeAmtNet() != 12000.50 {
+		t.Errorf("ParseCSV line 2 incorrect, got: %v", first.Txn)
+	}
+	if first.Refs.Ticker != "AAPL" || first.Refs.AcctName != "Main" {
+		t.Errorf("ParseCSV line 2 refs incorrect, got: %+v", first.Refs)
+	}
+
+	if len(rows[1].Errs) != 1 || rows[1].Errs[0].Field != "txn_size" || rows[1].Errs[0].Line != 3 {
+		t.Errorf("ParseCSV line 3 errors incorrect, got: %v", rows[1].Errs)
+	}
+	if len(rows[2].Errs) != 2 || rows[2].Line != 5 {
+		t.Errorf("ParseCSV line 5 errors incorrect, got: %v", rows[2].Errs)
+	}
+}
+
+func TestParseCSVHeader(t *testing.T) {
+	tests := []struct {
+		data    string
+		mapping map[string]string
+		ok      bool
+	}{
+		{"txn_dt,txn_type\n", nil, true},
+		{"txn_dt,bogus\n", nil, false},
+		{"a,b\n", map[string]string{"a": "txn_dt", "b": "txn_dt"}, false},
+		{"a,b\n", map[string]string{"c": "txn_dt"}, false},
+		{"", nil, false},
+	}
+	for _, test := range tests {
+		_, err := ParseCSV(strings.NewReader(test.data), test.mapping)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseCSV(%q) error incorrect, got: %v", test.data, err)
+		}
+	}
+}
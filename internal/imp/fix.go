@@ -0,0 +1,131 @@
+package imp
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/fix"
+)
+
+var (
+	// fixSides maps FIX sides to trade sub types. short sales are sells
+	fixSides = map[string]string{
+		"1": "buy",
+		"2": "sell",
+		"5": "sell",
+		"6": "sell",
+	}
+)
+
+// ParseFIX parses txns from the ExecutionReports in a FIX log (or a single message). each fill (ExecType F
+// in FIX 4.4, or 1 and 2 in 4.2) is a trade, referencing its order by OrderID as its parent and its account
+// by Account. the ExecID is the txn's ext_id. other messages and execution reports are skipped; trade
+// corrections and cancels are problems
+func ParseFIX(r io.Reader) ([]*Row, error) {
+	msgs, err := fix.ReadLog(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading fix log: %w", err)
+	}
+
+	var rows []*Row
+	for _, m := range msgs {
+		if m.Type() != fix.MsgType.ExecutionReport {
+			continue
+		}
+		row := &Row{
+			Line: m.Line,
+			Txn:  &storage.Txn{},
+		}
+		switch fill, bust := fixFill(m); {
+		case bust:
+			row.Fail("ExecType", "trade corrections and cancels aren't supported, book them by hand")
+		case fill:
+			row.fixExec(m)
+			row.check()
+		default:
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// fixFill checks if an ExecutionReport is a fill, or busts one (a trade correction or cancel)
+func fixFill(m *fix.Message) (fill bool, bust bool) {
+	execType, execTransType := m.Get(fix.Tag.ExecType), m.Get(fix.Tag.ExecTransType)
+	switch {
+	case execType == "G" || execType == "H" || execTransType == "1" || execTransType == "2":
+		return false, true
+	case execTransType == "3":
+		return false, false
+	}
+
+	return execType == "F" || execType == "1" || execType == "2", false
+}
+
+// fixExec maps a fill onto the row
+func (r *Row) fixExec(m *fix.Message) {
+	txn := r.Txn
+	txn.TxnType = "trade"
+	txn.TxnSubType = fixSides[m.Get(fix.Tag.Side)]
+	if txn.GetTxnSubType() == "" {
+		r.Fail("Side", "unsupported side %q", m.Get(fix.Tag.Side))
+	}
+	txn.ExtId = m.Get(fix.Tag.ExecID)
+	if txn.GetExtId() == "" {
+		r.Fail("ExecID", "required")
+	}
+	r.Refs.ParentRef = m.Get(fix.Tag.OrderID)
+	if r.Refs.ParentRef == "" {
+		r.Fail("OrderID", "required")
+	}
+	r.Refs.AcctRef = m.Get(fix.Tag.Account)
+	if r.Refs.AcctRef == "" {
+		r.Fail("Account", "required")
+	}
+
+	// dates. the trade date falls back to the transaction time's date
+	if tradeDt := m.Get(fix.Tag.TradeDate); tradeDt != "" {
+		txn.TxnDt = r.basicDate("TradeDate", tradeDt)
+	} else {
+		txn.TxnDt = r.basicDate("TransactTime", m.Get(fix.Tag.TransactTime))
+	}
+	txn.SettleDt = r.basicDate("SettlDate", m.Get(fix.Tag.SettlDate))
+
+	// security, by cusip if that's the security id's source, and by symbol
+	if m.Get(fix.Tag.IDSource) == "1" {
+		r.Refs.Cusip = m.Get(fix.Tag.SecurityID)
+	}
+	r.Refs.Ticker = m.Get(fix.Tag.Symbol)
+	if r.Refs.Cusip == "" && r.Refs.Ticker == "" {
+		r.Fail("Symbol", "required")
+	}
+	r.Refs.TradeCcy = m.Get(fix.Tag.Currency)
+	r.Refs.SettleCcy = r.Refs.TradeCcy
+
+	// amounts. commission is either per unit (CommType 1) or absolute (3, the default)
+	qty := r.decimal("LastShares", m.Get(fix.Tag.LastShares))
+	if qty <= 0 {
+		r.Fail("LastShares", "must be positive")
+	}
+	gross := qty * r.decimal("LastPx", m.Get(fix.Tag.LastPx))
+	comm := r.decimal("Commission", m.Get(fix.Tag.Commission))
+	switch commType := m.Get(fix.Tag.CommType); commType {
+	case "", "3":
+	case "1":
+		comm *= qty
+	default:
+		r.Fail("CommType", "unsupported commission type %q", commType)
+	}
+	net := gross + comm
+	if txn.GetTxnSubType() == "sell" {
+		net = gross - comm
+	}
+	txn.TxnSize = qty
+	txn.TradeAmtGross = gross
+	txn.TradeAmtNet = net
+	txn.SettleAmtGross = gross
+	txn.SettleAmtNet = net
+}
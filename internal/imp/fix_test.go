@@ -0,0 +1,83 @@
+package imp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wolfinger/varangian/internal/fix"
+)
+
+// execReport formats an ExecutionReport the way a FIX log would
+func execReport(fields map[int]string) string {
+	m := &fix.Message{}
+	m.Set(fix.Tag.BeginString, "FIX.4.4")
+	m.Set(fix.Tag.MsgType, fix.MsgType.ExecutionReport)
+	for _, tag := range []int{fix.Tag.OrderID, fix.Tag.ExecID, fix.Tag.ExecType, fix.Tag.ExecTransType, fix.Tag.Account,
+		fix.Tag.Side, fix.Tag.Symbol, fix.Tag.IDSource, fix.Tag.SecurityID, fix.Tag.LastShares, fix.Tag.LastPx,
+		fix.Tag.Commission, fix.Tag.CommType, fix.Tag.Currency, fix.Tag.TransactTime, fix.Tag.TradeDate, fix.Tag.SettlDate} {
+		if val, ok := fields[tag]; ok {
+			m.Set(tag, val)
+		}
+	}
+	return m.String()
+}
+
+func TestParseFIX(t *testing.T) {
+	fill := func(execID string, execType string, side string, qty string) map[int]string {
+		return map[int]string{
+			fix.Tag.OrderID:      "O1",
+			fix.Tag.ExecID:       execID,
+			fix.Tag.ExecType:     execType,
+			fix.Tag.Account:      "12345",
+			fix.Tag.Side:         side,
+			fix.Tag.Symbol:       "AAPL",
+			fix.Tag.LastShares:   qty,
+			fix.Tag.LastPx:       "120.5",
+			fix.Tag.Commission:   "0.01",
+			fix.Tag.CommType:     "1",
+			fix.Tag.Currency:     "USD",
+			fix.Tag.TransactTime: "20210301-14:30:00.000",
+			fix.Tag.SettlDate:    "20210303",
+		}
+	}
+	cancel := fill("E4", "H", "1", "10")
+	log := strings.Join([]string{
+		execReport(map[int]string{fix.Tag.OrderID: "O1", fix.Tag.ExecID: "E0", fix.Tag.ExecType: "0"}),
+		execReport(fill("E1", "F", "1", "100")),
+		"8=FIX.4.4|9=5|35=0|10=163|",
+		execReport(fill("E2", "1", "2", "50")),
+		execReport(fill("E3", "F", "X", "0")),
+		execReport(cancel),
+	}, "\n")
+
+	rows, err := ParseFIX(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("ParseFIX failed: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("ParseFIX found %d rows, want: 4 (new orders and heartbeats skipped)", len(rows))
+	}
+
+	buy := rows[0]
+	if len(buy.Errs) != 0 {
+		t.Errorf("ParseFIX buy has errors: %v", buy.Errs)
+	}
+	if buy.Line != 2 || buy.Txn.GetTxnType() != "trade" || buy.Txn.GetTxnSubType() != "buy" || buy.Txn.GetExtId() != "E1" ||
+		buy.Txn.GetTxnDt() != "2021-03-01" || buy.Txn.GetSettleDt() != "2021-03-03" || buy.Txn.GetTxnSize() != 100 ||
+		buy.Txn.GetTradeAmtGross() != 12050 || buy.Txn.GetTradeAmtNet() != 12051 {
+		t.Errorf("ParseFIX buy incorrect, got: line %d %v", buy.Line, buy.Txn)
+	}
+	if buy.Refs.ParentRef != "O1" || buy.Refs.AcctRef != "12345" || buy.Refs.Ticker != "AAPL" || buy.Refs.TradeCcy != "USD" {
+		t.Errorf("ParseFIX buy refs incorrect, got: %+v", buy.Refs)
+	}
+
+	if sell := rows[1]; sell.Txn.GetTxnSubType() != "sell" || sell.Txn.GetTradeAmtNet() != 6024.5 {
+		t.Errorf("ParseFIX sell incorrect, got: %v", sell.Txn)
+	}
+	if len(rows[2].Errs) != 2 {
+		t.Errorf("ParseFIX bad fill errors incorrect, got: %v", rows[2].Errs)
+	}
+	if len(rows[3].Errs) != 1 || rows[3].Errs[0].Field != "ExecType" {
+		t.Errorf("ParseFIX trade cancel errors incorrect, got: %v", rows[3].Errs)
+	}
+}
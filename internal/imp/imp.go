@@ -0,0 +1,197 @@
+// Package imp parses txns from import files. parsing doesn't touch the data store: references to other
+// resources by name (e.g., an instrument's ticker) are returned alongside each txn to be resolved by the
+// caller, and every problem with a row is collected so a whole file can be reported on at once
+package imp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/config"
+)
+
+// Refs are references to other resources by name, resolved to ids by the caller. ParentRef groups txns
+// under a parent txn by the parent's ext_id (e.g., the fills of an order)
+type Refs struct {
+	Ticker    string
+	Cusip     string
+	Isin      string
+	AcctName  string
+	AcctRef   string
+	TradeCcy  string
+	SettleCcy string
+	ParentRef string
+}
+
+// Error is a problem with a field of a row
+type Error struct {
+	Line  int
+	Field string
+	Msg   string
+}
+
+// Error formats the problem with its line and field
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Msg)
+}
+
+// Row is a txn parsed from a line of an import file, along with its references and any problems found
+type Row struct {
+	Line int
+	Txn  *storage.Txn
+	Refs Refs
+	Errs []*Error
+}
+
+// Fail records a problem with a field of the row
+func (r *Row) Fail(field string, format string, args ...interface{}) {
+	r.Errs = append(r.Errs, &Error{Line: r.Line, Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// setter sets a txn field, or a reference, from its text value
+type setter func(r *Row, val string) error
+
+var (
+	// setters lists the fields a value can be imported into: txn fields by name, plus references to
+	// instruments by ticker (ticker, trade_amt_ccy, settle_amt_ccy), cusip (cusip) or isin (isin) and to
+	// accounts by name (acct_name) or external reference (acct_ref)
+	setters = map[string]setter{
+		"txn_dt":            dateSetter(func(r *Row, v string) { r.Txn.TxnDt = v }),
+		"settle_dt":         dateSetter(func(r *Row, v string) { r.Txn.SettleDt = v }),
+		"txn_type":          textSetter(func(r *Row, v string) { r.Txn.TxnType = strings.ToLower(v) }),
+		"txn_sub_type":      textSetter(func(r *Row, v string) { r.Txn.TxnSubType = strings.ToLower(v) }),
+		"txn_size":          numSetter(func(r *Row, v float64) { r.Txn.TxnSize = v }),
+		"inst_id":           textSetter(func(r *Row, v string) { r.Txn.InstId = v }),
+		"parent_id":         textSetter(func(r *Row, v string) { r.Txn.ParentId = v }),
+		"src_lot_id":        textSetter(func(r *Row, v string) { r.Txn.SrcLotId = v }),
+		"tgt_lot_id":        textSetter(func(r *Row, v string) { r.Txn.TgtLotId = v }),
+		"trade_amt_ccy_id":  textSetter(func(r *Row, v string) { r.Txn.TradeAmtCcyId = v }),
+		"trade_amt_gross":   numSetter(func(r *Row, v float64) { r.Txn.TradeAmtGross = v }),
+		"trade_amt_net":     numSetter(func(r *Row, v float64) { r.Txn.TradeAmtNet = v }),
+		"settle_amt_ccy_id": textSetter(func(r *Row, v string) { r.Txn.SettleAmtCcyId = v }),
+		"settle_amt_gross":  numSetter(func(r *Row, v float64) { r.Txn.SettleAmtGross = v }),
+		"settle_amt_net":    numSetter(func(r *Row, v float64) { r.Txn.SettleAmtNet = v }),
+		"acct_id":           textSetter(func(r *Row, v string) { r.Txn.AcctId = v }),
+		"le_org_id":         textSetter(func(r *Row, v string) { r.Txn.LeOrgId = v }),
+		"port_id":           textSetter(func(r *Row, v string) { r.Txn.PortId = v }),
+		"strat_id":          textSetter(func(r *Row, v string) { r.Txn.StratId = v }),
+		"ext_id":            textSetter(func(r *Row, v string) { r.Txn.ExtId = v }),
+		"ticker":            textSetter(func(r *Row, v string) { r.Refs.Ticker = v }),
+		"cusip":             textSetter(func(r *Row, v string) { r.Refs.Cusip = v }),
+		"isin":              textSetter(func(r *Row, v string) { r.Refs.Isin = v }),
+		"acct_name":         textSetter(func(r *Row, v string) { r.Refs.AcctName = v }),
+		"acct_ref":          textSetter(func(r *Row, v string) { r.Refs.AcctRef = v }),
+		"trade_amt_ccy":     textSetter(func(r *Row, v string) { r.Refs.TradeCcy = v }),
+		"settle_amt_ccy":    textSetter(func(r *Row, v string) { r.Refs.SettleCcy = v }),
+	}
+)
+
+// validField checks a field can be imported into
+func validField(field string) bool {
+	_, ok := setters[field]
+	return ok
+}
+
+// set sets a field of a row from its text value, recording a problem if the value can't be parsed. empty
+// values are skipped
+func (r *Row) set(field string, val string) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return
+	}
+	if err := setters[field](r, val); err != nil {
+		r.Fail(field, "%v", err)
+	}
+}
+
+// textSetter sets a text field
+func textSetter(f func(r *Row, v string)) setter {
+	return func(r *Row, val string) error {
+		f(r, val)
+		return nil
+	}
+}
+
+// dateSetter sets a date field, accepting only api formatted dates
+func dateSetter(f func(r *Row, v string)) setter {
+	return func(r *Row, val string) error {
+		if _, err := time.Parse(config.APIFormats.DateFmt, val); err != nil {
+			return fmt.Errorf("invalid date %q", val)
+		}
+		f(r, val)
+		return nil
+	}
+}
+
+// numSetter sets a numeric field, ignoring thousands separators
+func numSetter(f func(r *Row, v float64)) setter {
+	return func(r *Row, val string) error {
+		num, err := strconv.ParseFloat(strings.ReplaceAll(val, ",", ""), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", val)
+		}
+		f(r, num)
+		return nil
+	}
+}
+
+// check records the problems every imported txn is checked for: a txn date and type, and either an
+// instrument id or a ticker but not both (likewise for accounts and currencies)
+func (r *Row) check() {
+	if r.Txn.GetTxnDt() == "" {
+		r.Fail("txn_dt", "required")
+	}
+	if r.Txn.GetTxnType() == "" {
+		r.Fail("txn_type", "required")
+	}
+	r.checkOne("inst_id", r.Txn.GetInstId(), "ticker", r.Refs.Ticker)
+	r.checkOne("inst_id", r.Txn.GetInstId(), "cusip", r.Refs.Cusip)
+	r.checkOne("inst_id", r.Txn.GetInstId(), "isin", r.Refs.Isin)
+	r.checkOne("acct_id", r.Txn.GetAcctId(), "acct_name", r.Refs.AcctName)
+	r.checkOne("acct_id", r.Txn.GetAcctId(), "acct_ref", r.Refs.AcctRef)
+	r.checkOne("acct_name", r.Refs.AcctName, "acct_ref", r.Refs.AcctRef)
+	r.checkOne("trade_amt_ccy_id", r.Txn.GetTradeAmtCcyId(), "trade_amt_ccy", r.Refs.TradeCcy)
+	r.checkOne("settle_amt_ccy_id", r.Txn.GetSettleAmtCcyId(), "settle_amt_ccy", r.Refs.SettleCcy)
+}
+
+// checkOne records a problem if both an id and a reference to the same resource are set
+func (r *Row) checkOne(idField string, id string, refField string, ref string) {
+	if id != "" && ref != "" {
+		r.Fail(refField, "only one of %s or %s is expected", idField, refField)
+	}
+}
+
+// basicDate converts a date starting in yyyymmdd format (e.g., 20210301, 20210301120000.000[-5:EST], or
+// 20210301-12:00:00) to an api date
+func (r *Row) basicDate(field string, val string) string {
+	if val == "" {
+		return ""
+	}
+	if len(val) >= 8 {
+		if dt, err := time.Parse("20060102", val[:8]); err == nil {
+			return dt.Format(config.APIFormats.DateFmt)
+		}
+	}
+	r.Fail(field, "invalid date %q", val)
+
+	return ""
+}
+
+// decimal converts a number, which may use a comma as the decimal point
+func (r *Row) decimal(field string, val string) float64 {
+	if val == "" {
+		return 0
+	}
+	num, err := strconv.ParseFloat(strings.Replace(val, ",", ".", 1), 64)
+	if err != nil {
+		r.Fail(field, "invalid number %q", val)
+	}
+
+	return num
+}
@@ -0,0 +1,292 @@
+package imp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/wolfinger/varangian/generated/storage"
+)
+
+// ofxNode is an element of an ofx document. aggregates have kids; elements have a value
+type ofxNode struct {
+	name string
+	val  string
+	line int
+	kids []*ofxNode
+}
+
+// kid gets the first kid of a node with a name, following a path of names
+func (n *ofxNode) kid(path ...string) *ofxNode {
+	cur := n
+	for _, name := range path {
+		if cur == nil {
+			return nil
+		}
+		var next *ofxNode
+		for _, k := range cur.kids {
+			if k.name == name {
+				next = k
+				break
+			}
+		}
+		cur = next
+	}
+
+	return cur
+}
+
+// text gets the value of the element at a path below a node, or "" if there isn't one
+func (n *ofxNode) text(path ...string) string {
+	if k := n.kid(path...); k != nil {
+		return k.val
+	}
+	return ""
+}
+
+// find finds every node with a name anywhere below a node, in document order
+func (n *ofxNode) find(name string) []*ofxNode {
+	var found []*ofxNode
+	for _, k := range n.kids {
+		if k.name == name {
+			found = append(found, k)
+		}
+		found = append(found, k.find(name)...)
+	}
+
+	return found
+}
+
+var (
+	// ofxEntities decodes the character entities allowed in ofx values
+	ofxEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&", "&nbsp;", " ")
+
+	// ofxBuys and ofxSells are the buy and sell records mapped to trades, with the aggregate holding the
+	// trade details
+	ofxBuys = map[string]string{
+		"BUYSTOCK": "INVBUY",
+		"BUYMF":    "INVBUY",
+		"BUYDEBT":  "INVBUY",
+		"BUYOTHER": "INVBUY",
+	}
+	ofxSells = map[string]string{
+		"SELLSTOCK": "INVSELL",
+		"SELLMF":    "INVSELL",
+		"SELLDEBT":  "INVSELL",
+		"SELLOTHER": "INVSELL",
+	}
+)
+
+// parseOFXTree parses an ofx document, either sgml (ofx 1.x, where elements needn't be closed) or xml
+// (ofx 2.x), into a tree. the headers before the <OFX> tag are skipped
+func parseOFXTree(data string) (*ofxNode, error) {
+	start := strings.Index(strings.ToUpper(data), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("ofx has no <OFX> element")
+	}
+	line := 1 + strings.Count(data[:start], "\n")
+	data = data[start:]
+
+	root := &ofxNode{}
+	stack := []*ofxNode{root}
+	for pos := 0; pos < len(data); {
+		open := strings.IndexByte(data[pos:], '<')
+		if open < 0 {
+			break
+		}
+		line += strings.Count(data[pos:pos+open], "\n")
+		pos += open
+		end := strings.IndexByte(data[pos:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unterminated tag", line)
+		}
+		tag := strings.TrimSpace(data[pos+1 : pos+end])
+		pos += end + 1
+
+		// the value runs up to the next tag
+		next := strings.IndexByte(data[pos:], '<')
+		if next < 0 {
+			next = len(data) - pos
+		}
+		val := strings.TrimSpace(ofxEntities.Replace(data[pos : pos+next]))
+
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+			continue
+		case tag[0] == '/':
+			// close the aggregate, and any left open inside it. closing an element already closed by its
+			// value is ignored
+			name := strings.ToUpper(tag[1:])
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		default:
+			selfClosed := strings.HasSuffix(tag, "/")
+			name := strings.ToUpper(strings.Fields(strings.TrimSuffix(tag, "/"))[0])
+			n := &ofxNode{name: name, val: val, line: line}
+			top := stack[len(stack)-1]
+			top.kids = append(top.kids, n)
+			if val == "" && !selfClosed {
+				stack = append(stack, n)
+			}
+		}
+	}
+	if root.kid("OFX") == nil {
+		return nil, fmt.Errorf("ofx has no <OFX> element")
+	}
+
+	return root, nil
+}
+
+// ParseOFX parses txns from the investment statements (INVSTMTRS) of an ofx or qfx file. buys and sells
+// map to trades, INCOME to income, REINVEST to reinvest trades, and TRANSFER to transfers; other records
+// are skipped. each txn's FITID is its ext_id, its account is referenced by the statement's ACCTID, and its
+// instrument by cusip (when the security id is one) and by ticker from the file's security list. a row's
+// line is the line its record starts on
+func ParseOFX(r io.Reader) ([]*Row, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading ofx: %w", err)
+	}
+	root, err := parseOFXTree(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// tickers by security id from the security list
+	tickers := make(map[string]string)
+	for _, secInfo := range root.find("SECINFO") {
+		if id, ticker := secInfo.text("SECID", "UNIQUEID"), secInfo.text("TICKER"); id != "" && ticker != "" {
+			tickers[id] = ticker
+		}
+	}
+
+	var rows []*Row
+	for _, stmt := range root.find("INVSTMTRS") {
+		ccy := stmt.text("CURDEF")
+		acctRef := stmt.text("INVACCTFROM", "ACCTID")
+		tranList := stmt.kid("INVTRANLIST")
+		if tranList == nil {
+			continue
+		}
+		for _, rec := range tranList.kids {
+			row := &Row{
+				Line: rec.line,
+				Txn:  &storage.Txn{},
+				Refs: Refs{AcctRef: acctRef},
+			}
+			if !row.ofxRecord(rec, ccy, tickers) {
+				continue
+			}
+			if row.Refs.AcctRef == "" {
+				row.Fail("ACCTID", "statement has no account id")
+			}
+			row.check()
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// ofxRecord maps an investment transaction record onto the row, returning false for records that aren't
+// imported
+func (r *Row) ofxRecord(rec *ofxNode, ccy string, tickers map[string]string) bool {
+	txn := r.Txn
+	detail := rec
+	switch {
+	case ofxBuys[rec.name] != "":
+		detail = rec.kid(ofxBuys[rec.name])
+		txn.TxnType, txn.TxnSubType = "trade", "buy"
+	case ofxSells[rec.name] != "":
+		detail = rec.kid(ofxSells[rec.name])
+		txn.TxnType, txn.TxnSubType = "trade", "sell"
+	case rec.name == "INCOME":
+		txn.TxnType = "income"
+		switch incomeType := rec.text("INCOMETYPE"); incomeType {
+		case "DIV", "CGLONG", "CGSHORT":
+			txn.TxnSubType = "dividend"
+		case "INTEREST":
+			txn.TxnSubType = "interest"
+		default:
+			r.Fail("INCOMETYPE", "unsupported income type %q", incomeType)
+		}
+	case rec.name == "REINVEST":
+		txn.TxnType, txn.TxnSubType = "trade", "reinvest"
+	case rec.name == "TRANSFER":
+		txn.TxnType = "xfer"
+		switch action := rec.text("TFERACTION"); action {
+		case "IN":
+			txn.TxnSubType = "xfin"
+		case "OUT":
+			txn.TxnSubType = "xfout"
+		default:
+			r.Fail("TFERACTION", "unsupported transfer action %q", action)
+		}
+	default:
+		return false
+	}
+	if detail == nil {
+		r.Fail(rec.name, "record has no trade details")
+		return true
+	}
+
+	// transaction details
+	txn.ExtId = detail.text("INVTRAN", "FITID")
+	if txn.GetExtId() == "" {
+		r.Fail("FITID", "required")
+	}
+	txn.TxnDt = r.basicDate("DTTRADE", detail.text("INVTRAN", "DTTRADE"))
+	txn.SettleDt = r.basicDate("DTSETTLE", detail.text("INVTRAN", "DTSETTLE"))
+
+	// security
+	if id := detail.text("SECID", "UNIQUEID"); id != "" {
+		if strings.EqualFold(detail.text("SECID", "UNIQUEIDTYPE"), "CUSIP") {
+			r.Refs.Cusip = id
+		}
+		r.Refs.Ticker = tickers[id]
+		if r.Refs.Cusip == "" && r.Refs.Ticker == "" {
+			r.Fail("SECID", "security %q has no cusip or ticker", id)
+		}
+	}
+
+	// currency, which can be set per record
+	if cur := detail.text("CURRENCY", "CURSYM"); cur != "" {
+		ccy = cur
+	}
+	if cur := detail.text("ORIGCURRENCY", "CURSYM"); cur != "" {
+		ccy = cur
+	}
+	r.Refs.TradeCcy, r.Refs.SettleCcy = ccy, ccy
+
+	// amounts, which ofx signs by direction
+	units := math.Abs(r.decimal("UNITS", detail.text("UNITS")))
+	total := math.Abs(r.decimal("TOTAL", detail.text("TOTAL")))
+	switch txn.GetTxnType() {
+	case "trade":
+		txn.TxnSize = units
+		txn.TradeAmtGross = units * math.Abs(r.decimal("UNITPRICE", detail.text("UNITPRICE")))
+		txn.TradeAmtNet = total
+		txn.SettleAmtGross = txn.GetTradeAmtGross()
+		txn.SettleAmtNet = total
+	case "income":
+		txn.TxnSize = total
+		txn.SettleAmtGross = total
+		txn.SettleAmtNet = total
+		if txn.GetSettleDt() == "" {
+			txn.SettleDt = txn.GetTxnDt()
+		}
+	case "xfer":
+		txn.TxnSize = units
+		if avgCost := detail.text("AVGCOSTBASIS"); avgCost != "" {
+			txn.TradeAmtNet = units * math.Abs(r.decimal("AVGCOSTBASIS", avgCost))
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,152 @@
+package imp
+
+import (
+	"strings"
+	"testing"
+)
+
+const testOFX = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<INVSTMTMSGSRSV1>
+<INVSTMTTRNRS>
+<INVSTMTRS>
+<CURDEF>USD
+<INVACCTFROM><BROKERID>broker.com<ACCTID>12345</INVACCTFROM>
+<INVTRANLIST>
+<DTSTART>20210301
+<DTEND>20210331
+<BUYSTOCK>
+<INVBUY>
+<INVTRAN><FITID>T1<DTTRADE>20210301120000.000[-5:EST]<DTSETTLE>20210303</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>100<UNITPRICE>120.50<COMMISSION>5<TOTAL>-12055
+<SUBACCTSEC>CASH<SUBACCTFUND>CASH
+</INVBUY>
+<BUYTYPE>BUY
+</BUYSTOCK>
+<SELLSTOCK>
+<INVSELL>
+<INVTRAN><FITID>T2<DTTRADE>20210305<DTSETTLE>20210309</INVTRAN>
+<SECID><UNIQUEID>594918104<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>-10<UNITPRICE>230<TOTAL>2295
+</INVSELL>
+<SELLTYPE>SELL
+</SELLSTOCK>
+<INCOME>
+<INVTRAN><FITID>T3<DTTRADE>20210310</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<INCOMETYPE>DIV<TOTAL>20.50
+</INCOME>
+<INCOME>
+<INVTRAN><FITID>T4<DTTRADE>20210311</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<INCOMETYPE>MISC<TOTAL>1
+</INCOME>
+<TRANSFER>
+<INVTRAN><FITID>T5<DTTRADE>20210312</INVTRAN>
+<SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID>
+<UNITS>5<TFERACTION>IN<POSTYPE>LONG<AVGCOSTBASIS>100
+</TRANSFER>
+<INVBANKTRAN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20210315<TRNAMT>1000<FITID>T6</STMTTRN>
+</INVBANKTRAN>
+</INVTRANLIST>
+</INVSTMTRS>
+</INVSTMTTRNRS>
+</INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1>
+<SECLIST>
+<STOCKINFO><SECINFO><SECID><UNIQUEID>037833100<UNIQUEIDTYPE>CUSIP</SECID><SECNAME>Apple &amp; Co<TICKER>AAPL</SECINFO></STOCKINFO>
+</SECLIST>
+</SECLISTMSGSRSV1>
+</OFX>
+`
+
+func TestParseOFX(t *testing.T) {
+	rows, err := ParseOFX(strings.NewReader(testOFX))
+	if err != nil {
+		t.Fatalf("ParseOFX failed: %v", err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("ParseOFX found %d rows, want: 5 (bank txns skipped)", len(rows))
+	}
+
+	buy := rows[0]
+	if len(buy.Errs) != 0 {
+		t.Errorf("ParseOFX buy has errors: %v", buy.Errs)
+	}
+	if buy.Line != 14 {
+		t.Errorf("ParseOFX buy line incorrect, got: %d, want: 14", buy.Line)
+	}
+	if buy.Txn.GetTxnType() != "trade" || buy.Txn.GetTxnSubType() != "buy" || buy.Txn.GetExtId() != "T1" ||
+		buy.Txn.GetTxnDt() != "2021-03-01" || buy.Txn.GetSettleDt() != "2021-03-03" || buy.Txn.GetTxnSize() != 100 ||
+		buy.Txn.GetTradeAmtGross() != 12050 || buy.Txn.GetTradeAmtNet() != 12055 {
+		t.Errorf("ParseOFX buy incorrect, got: %v", buy.Txn)
+	}
+	if buy.Refs.Cusip != "037833100" || buy.Refs.Ticker != "AAPL" || buy.Refs.AcctRef != "12345" || buy.Refs.TradeCcy != "USD" {
+		t.Errorf("ParseOFX buy refs incorrect, got: %+v", buy.Refs)
+	}
+
+	sell := rows[1]
+	if sell.Txn.GetTxnSubType() != "sell" || sell.Txn.GetTxnSize() != 10 || sell.Txn.GetSettleAmtNet() != 2295 ||
+		sell.Refs.Ticker != "" || sell.Refs.Cusip != "594918104" {
+		t.Errorf("ParseOFX sell incorrect, got: %v %+v", sell.Txn, sell.Refs)
+	}
+
+	div := rows[2]
+	if div.Txn.GetTxnType() != "income" || div.Txn.GetTxnSubType() != "dividend" || div.Txn.GetTxnSize() != 20.5 ||
+		div.Txn.GetSettleDt() != "2021-03-10" {
+		t.Errorf("ParseOFX income incorrect, got: %v", div.Txn)
+	}
+
+	if len(rows[3].Errs) != 1 || rows[3].Errs[0].Field != "INCOMETYPE" {
+		t.Errorf("ParseOFX misc income errors incorrect, got: %v", rows[3].Errs)
+	}
+
+	xfer := rows[4]
+	if xfer.Txn.GetTxnType() != "xfer" || xfer.Txn.GetTxnSubType() != "xfin" || xfer.Txn.GetTxnSize() != 5 ||
+		xfer.Txn.GetTradeAmtNet() != 500 {
+		t.Errorf("ParseOFX transfer incorrect, got: %v", xfer.Txn)
+	}
+}
+
+func TestParseOFXXML(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><INVSTMTMSGSRSV1><INVSTMTTRNRS><INVSTMTRS>
+<CURDEF>CAD</CURDEF>
+<INVACCTFROM><BROKERID>b</BROKERID><ACCTID>A1</ACCTID></INVACCTFROM>
+<INVTRANLIST>
+<REINVEST>
+<INVTRAN><FITID>R1</FITID><DTTRADE>20210401</DTTRADE></INVTRAN>
+<SECID><UNIQUEID>X1</UNIQUEID><UNIQUEIDTYPE>OTHER</UNIQUEIDTYPE></SECID>
+<INCOMETYPE>DIV</INCOMETYPE><TOTAL>-50</TOTAL><UNITS>2</UNITS><UNITPRICE>25</UNITPRICE>
+</REINVEST>
+</INVTRANLIST>
+</INVSTMTRS></INVSTMTTRNRS></INVSTMTMSGSRSV1>
+<SECLISTMSGSRSV1><SECLIST><MFINFO><SECINFO><SECID><UNIQUEID>X1</UNIQUEID><UNIQUEIDTYPE>OTHER</UNIQUEIDTYPE></SECID><TICKER>XFND</TICKER></SECINFO></MFINFO></SECLIST></SECLISTMSGSRSV1>
+</OFX>
+`
+	rows, err := ParseOFX(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseOFX failed: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("ParseOFX found %d rows, want: 1", len(rows))
+	}
+	row := rows[0]
+	if len(row.Errs) != 0 {
+		t.Errorf("ParseOFX reinvest has errors: %v", row.Errs)
+	}
+	if row.Txn.GetTxnSubType() != "reinvest" || row.Txn.GetTxnSize() != 2 || row.Txn.GetTradeAmtNet() != 50 ||
+		row.Refs.Ticker != "XFND" || row.Refs.Cusip != "" || row.Refs.TradeCcy != "CAD" || row.Refs.AcctRef != "A1" {
+		t.Errorf("ParseOFX reinvest incorrect, got: %v %+v", row.Txn, row.Refs)
+	}
+
+	if _, err := ParseOFX(strings.NewReader("not ofx")); err == nil {
+		t.Errorf("ParseOFX of a file without <OFX> expected an error")
+	}
+}
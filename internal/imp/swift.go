@@ -0,0 +1,87 @@
+package imp
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/swift"
+)
+
+// ParseSWIFT parses txns from the statements of transactions (MT536) in a file of SWIFT MT messages; other
+// messages are skipped. a movement against payment is a trade (a buy when received, a sell when delivered)
+// and a free one a transfer in or out. each txn's reference is its ext_id, its account is referenced by the
+// safekeeping account, and its instrument by isin or cusip. problems are reported against the message
+// field they're with
+func ParseSWIFT(r io.Reader) ([]*Row, error) {
+	msgs, err := swift.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading swift: %w", err)
+	}
+
+	var rows []*Row
+	for _, m := range msgs {
+		if m.Type != swift.MTType.MT536 {
+			continue
+		}
+		movements, errs := swift.ParseMT536(m)
+
+		// problems with the message as a whole (e.g., a missing general information sequence) get a row of
+		// their own so they're still reported
+		if len(errs) > 0 {
+			rows = append(rows, &Row{Line: m.Line, Txn: &storage.Txn{}, Errs: swiftErrs(errs)})
+		}
+		for _, mv := range movements {
+			row := &Row{Line: mv.Line, Txn: &storage.Txn{}, Errs: swiftErrs(mv.Errs)}
+			row.swiftMovement(mv)
+			row.check()
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+// swiftErrs converts the problems with a message's fields to problems with a row
+func swiftErrs(errs []*swift.Error) []*Error {
+	var converted []*Error
+	for _, e := range errs {
+		converted = append(converted, &Error{Line: e.Line, Field: e.Field, Msg: e.Msg})
+	}
+	return converted
+}
+
+// swiftMovement maps a movement onto the row
+func (r *Row) swiftMovement(mv *swift.Movement) {
+	txn := r.Txn
+	switch {
+	case !mv.Free && mv.Receive:
+		txn.TxnType, txn.TxnSubType = "trade", "buy"
+	case !mv.Free:
+		txn.TxnType, txn.TxnSubType = "trade", "sell"
+	case mv.Receive:
+		txn.TxnType, txn.TxnSubType = "xfer", "xfin"
+	default:
+		txn.TxnType, txn.TxnSubType = "xfer", "xfout"
+	}
+	txn.ExtId = mv.Ref
+	txn.TxnDt = mv.TradeDt
+	txn.SettleDt = mv.SettleDt
+	r.Refs.AcctRef = mv.Acct
+
+	switch mv.Type {
+	case "isin":
+		r.Refs.Isin = mv.ID
+	case "cusip":
+		r.Refs.Cusip = mv.ID
+	}
+	r.Refs.TradeCcy, r.Refs.SettleCcy = mv.Ccy, mv.Ccy
+
+	txn.TxnSize = mv.Qty
+	if !mv.Free {
+		txn.TradeAmtGross = mv.Amt
+		txn.TradeAmtNet = mv.Amt
+		txn.SettleAmtGross = mv.Amt
+		txn.SettleAmtNet = mv.Amt
+	}
+}
@@ -0,0 +1,85 @@
+package imp
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSWIFT = `{1:F01CUSTUS33AXXX0000000000}{2:O5361200210301BANKUS33XXXX00000000002103011200N}{4:
+:16R:GENL
+:28E:1/ONLY
+:20C::SEME//STMT002
+:23G:NEWM
+:97A::SAFE//12345
+:16S:GENL
+:16R:SUBSAFE
+:16R:FIN
+:35B:ISIN US0378331005
+:16R:TRAN
+:16R:LINK
+:20C::RELA//TRADE1
+:16S:LINK
+:16R:TRANSDET
+:36B::PSTA//UNIT/100,
+:19A::PSTA//USD12051,
+:22H::REDE//RECE
+:22H::PAYM//APMT
+:98A::ESET//20210303
+:98A::TRAD//20210301
+:16S:TRANSDET
+:16S:TRAN
+:16S:FIN
+:16R:FIN
+:35B:/US/594918104
+:16R:TRAN
+:16R:TRANSDET
+:20C::RELA//XFER1
+:36B::PSTA//UNIT/5,
+:22H::REDE//DELI
+:22H::PAYM//FREE
+:98A::ESET//20210304
+:16S:TRANSDET
+:16S:TRAN
+:16S:FIN
+:16S:SUBSAFE
+-}
+{1:F01CUSTUS33AXXX0000000000}{2:O9501200210301BANKUS33XXXX00000000002103011200N}{4:
+:20:CASH001
+:25:12345
+-}
+{1:F01CUSTUS33AXXX0000000000}{2:O5361200210301BANKUS33XXXX00000000002103011200N}{4:
+:20C::SEME//STMT003
+-}`
+
+func TestParseSWIFT(t *testing.T) {
+	rows, err := ParseSWIFT(strings.NewReader(testSWIFT))
+	if err != nil {
+		t.Fatalf("ParseSWIFT failed: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("ParseSWIFT found %d rows, want: 3 (cash statements skipped)", len(rows))
+	}
+
+	buy := rows[0]
+	if len(buy.Errs) != 0 {
+		t.Errorf("ParseSWIFT buy has errors: %v", buy.Errs)
+	}
+	if buy.Txn.GetTxnType() != "trade" || buy.Txn.GetTxnSubType() != "buy" || buy.Txn.GetExtId() != "TRADE1" ||
+		buy.Txn.GetTxnDt() != "2021-03-01" || buy.Txn.GetSettleDt() != "2021-03-03" || buy.Txn.GetTxnSize() != 100 ||
+		buy.Txn.GetTradeAmtNet() != 12051 || buy.Txn.GetSettleAmtNet() != 12051 {
+		t.Errorf("ParseSWIFT buy incorrect, got: %v", buy.Txn)
+	}
+	if buy.Refs.Isin != "US0378331005" || buy.Refs.AcctRef != "12345" || buy.Refs.TradeCcy != "USD" {
+		t.Errorf("ParseSWIFT buy refs incorrect, got: %+v", buy.Refs)
+	}
+
+	xfer := rows[1]
+	if len(xfer.Errs) != 0 || xfer.Txn.GetTxnType() != "xfer" || xfer.Txn.GetTxnSubType() != "xfout" ||
+		xfer.Txn.GetTxnDt() != "2021-03-04" || xfer.Refs.Cusip != "594918104" || xfer.Txn.GetTradeAmtNet() != 0 {
+		t.Errorf("ParseSWIFT transfer incorrect, got: %v %+v errors: %v", xfer.Txn, xfer.Refs, xfer.Errs)
+	}
+
+	if bad := rows[2]; bad.Line != 43 || len(bad.Errs) != 1 || bad.Errs[0].Field != ":16R:" {
+		t.Errorf("ParseSWIFT message errors incorrect, got: line %d %v", bad.Line, bad.Errs)
+	}
+}
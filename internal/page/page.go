@@ -0,0 +1,333 @@
+// Package page pages through lists with keyset pagination: a list is sorted by a set of keys and each page
+// starts after the sort key of the last row of the page before, so pages stay consistent while rows are
+// added and deep pages are as quick as the first. page tokens carry that sort key and are signed, so a
+// client can't forge one
+package page
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v10/orm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultSize is the number of rows in a page when the caller doesn't ask for a size
+	DefaultSize = 100
+
+	// MaxSize is the most rows a page can have, whatever the caller asks for
+	MaxSize = 1000
+)
+
+// secret signs page tokens
+var secret = newSecret()
+
+// newSecret creates a random secret, used until one is set
+func newSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// SetSecret sets the secret page tokens are signed with. tokens signed with another secret are rejected, so
+// every server behind a load balancer needs the same one. until one is set a random secret is used and
+// tokens only work against the server that issued them
+func SetSecret(s []byte) {
+	secret = s
+}
+
+// Size gets the size of the page a caller asked for: the default if they didn't ask and at most MaxSize
+func Size(size int32) int32 {
+	switch {
+	case size <= 0:
+		return DefaultSize
+	case size > MaxSize:
+		return MaxSize
+	}
+	return size
+}
+
+// Key is a column a list is sorted by. the last key of a list has to be unique, usually the id, so the
+// order is total
+type Key struct {
+	Col  string
+	Desc bool
+}
+
+// Pager pages through a list sorted by a set of keys. query is whatever else decides which rows are
+// listed, e.g. the filter, and a token only works with the query it was issued for. a pager without a
+// size lists every row
+type Pager struct {
+	size  int32
+	keys  []Key
+	query string
+	dates map[string]bool
+}
+
+// NewPager creates a new Pager
+func NewPager(size int32, keys []Key, query string) *Pager {
+	return &Pager{
+		size:  size,
+		keys:  keys,
+		query: query,
+	}
+}
+
+// Query joins the arguments that decide which rows a list has (e.g. its date range and accounts) into the
+// query a pager's tokens are issued for
+func Query(args ...string) string {
+	return strings.Join(args, "\x00")
+}
+
+// Dates marks the columns the list selects cast to dates (e.g. `txn_dt::date`). they're sorted and compared
+// as dates too, as that's what the tokens issued for the list hold
+func (p *Pager) Dates(cols ...string) *Pager {
+	if p.dates == nil {
+		p.dates = make(map[string]bool, len(cols))
+	}
+	for _, col := range cols {
+		p.dates[col] = true
+	}
+
+	return p
+}
+
+// col gets the expression a key is sorted and compared on: its column in the query's table, so it can't be
+// confused with a column of the same name the query selects, cast to a date if it's listed as one
+func (p *Pager) col(key Key) string {
+	if p.dates[key.Col] {
+		return "?TableAlias." + key.Col + "::date"
+	}
+	return "?TableAlias." + key.Col
+}
+
+// token is what a page token holds: a hash of the query and sort order, and the sort key of the last row
+// of the page before. sort key values are nil for nulls
+type token struct {
+	Query string    `json:"q"`
+	Vals  []*string `json:"v"`
+}
+
+// Apply sorts a query by the pager's keys and, for a token, starts it after the row the token was issued
+// for. a page is fetched with an extra row, to tell if there's another page after it
+func (p *Pager) Apply(q *orm.Query, pageToken string) (*orm.Query, error) {
+	cols := make([]string, len(p.keys))
+	for i, key := range p.keys {
+		cols[i] = p.col(key)
+	}
+	if pageToken != "" {
+		vals, err := p.decode(pageToken)
+		if err != nil {
+			return nil, err
+		}
+		cond, params := after(p.keys, cols, vals)
+		q.Where(cond, params...)
+	}
+	for i, key := range p.keys {
+		if key.Desc {
+			q.OrderExpr(cols[i] + " DESC")
+		} else {
+			q.OrderExpr(cols[i] + " ASC")
+		}
+	}
+	if p.size > 0 {
+		q.Limit(int(p.size) + 1)
+	}
+
+	return q, nil
+}
+
+// Start gets the sort key values of the row a page token starts after, or nil for the first page. values are
+// nil for nulls
+func (p *Pager) Start(pageToken string) ([]*string, error) {
+	if pageToken == "" {
+		return nil, nil
+	}
+	return p.decode(pageToken)
+}
+
+// Next gets the number of rows fetched that are in the page and whether there's a page after it
+func (p *Pager) Next(n int) (int, bool) {
+	if p.size > 0 && n > int(p.size) {
+		return int(p.size), true
+	}
+	return n, false
+}
+
+// Token creates the token for the page after a row, given the row's sort key values. empty values are
+// nulls, as that's how the datastore stores them
+func (p *Pager) Token(vals ...string) string {
+	tok := token{
+		Query: p.hash(),
+		Vals:  make([]*string, len(vals)),
+	}
+	for i := range vals {
+		if vals[i] != "" {
+			tok.Vals[i] = &vals[i]
+		}
+	}
+	payload, _ := json.Marshal(tok)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sign(payload))
+}
+
+// decode gets the sort key values out of a token, making sure it was issued by a server with the same
+// secret for the same query and sort order
+func (p *Pager) decode(pageToken string) ([]*string, error) {
+	invalid := status.Error(codes.InvalidArgument, "invalid page_token")
+	parts := strings.Split(pageToken, ".")
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, invalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, sign(payload)) {
+		return nil, invalid
+	}
+
+	var tok token
+	if err = json.Unmarshal(payload, &tok); err != nil || len(tok.Vals) != len(p.keys) {
+		return nil, invalid
+	}
+	if tok.Query != p.hash() {
+		return nil, status.Error(codes.InvalidArgument, "page_token was issued for a different filter or order")
+	}
+
+	return tok.Vals, nil
+}
+
+// hash fingerprints the query and sort order a token is issued for
+func (p *Pager) hash() string {
+	var b bytes.Buffer
+	b.WriteString(p.query)
+	for _, key := range p.keys {
+		b.WriteByte(0)
+		b.WriteString(key.Col)
+		if key.Desc {
+			b.WriteString(" desc")
+		}
+	}
+	sum := sha256.Sum256(b.Bytes())
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// sign signs a token's payload
+func sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// after builds the condition that a row sorts after a sort key: it's after on the first key, or ties on
+// the first key and is after on the second, and so on. cols are the expressions the keys are compared on.
+// nulls sort last ascending and first descending, as they do in postgres
+func after(keys []Key, cols []string, vals []*string) (string, []interface{}) {
+	var ors []string
+	var params []interface{}
+	for i, key := range keys {
+		var ands []string
+		var andParams []interface{}
+		for j := 0; j < i; j++ {
+			if vals[j] == nil {
+				ands = append(ands, cols[j]+" IS NULL")
+			} else {
+				ands = append(ands, cols[j]+" = ?")
+				andParams = append(andParams, *vals[j])
+			}
+		}
+
+		switch {
+		case vals[i] == nil && !key.Desc:
+			// nothing sorts after a null ascending
+			continue
+		case vals[i] == nil:
+			ands = append(ands, cols[i]+" IS NOT NULL")
+		case key.Desc:
+			ands = append(ands, cols[i]+" < ?")
+			andParams = append(andParams, *vals[i])
+		default:
+			ands = append(ands, "("+cols[i]+" > ? OR "+cols[i]+" IS NULL)")
+			andParams = append(andParams, *vals[i])
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		params = append(params, andParams...)
+	}
+	if len(ors) == 0 {
+		return "FALSE", nil
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", params
+}
+
+// Order parses an order_by, e.g. `txn_dt desc, id`, into the keys to sort by. only the columns in cols can
+// be sorted by, and id is added as the last key if it isn't there already so the order is total. an empty
+// order_by sorts by the default keys
+func Order(orderBy string, defaults []Key, cols []string) ([]Key, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return defaults, nil
+	}
+
+	var keys []Key
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid order_by %q, expected columns separated by commas, each followed by asc or desc if needed", orderBy)
+		}
+
+		key := Key{Col: words[0]}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				key.Desc = true
+			default:
+				return nil, status.Errorf(codes.InvalidArgument, "invalid order_by direction %q, expected asc or desc", words[1])
+			}
+		}
+		if !contains(cols, key.Col) {
+			return nil, status.Errorf(codes.InvalidArgument, "can't order by %s, expected one of %s", key.Col, strings.Join(cols, ", "))
+		}
+		if seen[key.Col] {
+			return nil, status.Errorf(codes.InvalidArgument, "order_by has %s more than once", key.Col)
+		}
+		seen[key.Col] = true
+		keys = append(keys, key)
+	}
+	if !seen["id"] {
+		keys = append(keys, Key{Col: "id"})
+	}
+
+	return keys, nil
+}
+
+// Float writes a number for a token. zeros are nulls, as that's how the datastore stores them
+func Float(f float64) string {
+	if f == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// contains tells if a slice of strings contains a string
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
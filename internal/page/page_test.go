@@ -0,0 +1,160 @@
+package page
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-pg/pg/v10/orm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSize(t *testing.T) {
+	for size, want := range map[int32]int32{-1: DefaultSize, 0: DefaultSize, 5: 5, MaxSize + 1: MaxSize} {
+		if got := Size(size); got != want {
+			t.Errorf("Size(%d) got: %d, want: %d", size, got, want)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	keys := []Key{{Col: "txn_dt"}, {Col: "id"}}
+	p := NewPager(10, keys, `{"AcctID":["acct_a"]}`)
+	tok := p.Token("", "5f0c")
+
+	vals, err := p.decode(tok)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(vals) != 2 || vals[0] != nil || *vals[1] != "5f0c" {
+		t.Errorf("decode incorrect, got: %v", vals)
+	}
+
+	for _, bad := range []string{
+		"x",
+		tok + "x",
+		"e30." + tok[len(tok)-43:],
+		NewPager(10, keys, `{"AcctID":["acct_b"]}`).Token("", "5f0c"),
+		NewPager(10, []Key{{Col: "txn_dt", Desc: true}, {Col: "id"}}, `{"AcctID":["acct_a"]}`).Token("", "5f0c"),
+	} {
+		if _, err := p.decode(bad); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("decode(%q) expected an invalid argument error, got: %v", bad, err)
+		}
+	}
+
+	if vals, err := p.Start(tok); err != nil || len(vals) != 2 || *vals[1] != "5f0c" {
+		t.Errorf("Start got: %v, %v", vals, err)
+	}
+	if vals, err := p.Start(""); err != nil || vals != nil {
+		t.Errorf("Start without a token got: %v, %v, want: nil", vals, err)
+	}
+	q := NewPager(10, keys, Query("acct_a", "2021-01-01"))
+	if _, err := q.decode(NewPager(10, keys, Query("acct_a", "2021-01-02")).Token("", "5f0c")); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("decode of a token for another query expected an invalid argument error, got: %v", err)
+	}
+
+	if n, more := p.Next(11); n != 10 || !more {
+		t.Errorf("Next(11) got: %d, %v, want: 10, true", n, more)
+	}
+	if n, more := NewPager(0, keys, "").Next(11); n != 11 || more {
+		t.Errorf("Next(11) without a size got: %d, %v, want: 11, false", n, more)
+	}
+}
+
+func TestAfter(t *testing.T) {
+	dt, id := "2021-03-01", "5f0c"
+	tests := []struct {
+		keys   []Key
+		vals   []*string
+		cond   string
+		params []interface{}
+	}{
+		{
+			keys:   []Key{{Col: "txn_dt"}, {Col: "id"}},
+			vals:   []*string{&dt, &id},
+			cond:   "(((t.txn_dt > ? OR t.txn_dt IS NULL)) OR (t.txn_dt = ? AND (t.id > ? OR t.id IS NULL)))",
+			params: []interface{}{dt, dt, id},
+		},
+		{
+			keys:   []Key{{Col: "txn_dt"}, {Col: "id"}},
+			vals:   []*string{nil, &id},
+			cond:   "((t.txn_dt IS NULL AND (t.id > ? OR t.id IS NULL)))",
+			params: []interface{}{id},
+		},
+		{
+			keys:   []Key{{Col: "txn_dt", Desc: true}, {Col: "id", Desc: true}},
+			vals:   []*string{nil, &id},
+			cond:   "((t.txn_dt IS NOT NULL) OR (t.txn_dt IS NULL AND t.id < ?))",
+			params: []interface{}{id},
+		},
+		{
+			keys: []Key{{Col: "id"}},
+			vals: []*string{nil},
+			cond: "FALSE",
+		},
+	}
+	for _, test := range tests {
+		cols := make([]string, len(test.keys))
+		for i, key := range test.keys {
+			cols[i] = "t." + key.Col
+		}
+		cond, params := after(test.keys, cols, test.vals)
+		if cond != test.cond || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("after(%v) got: %s %v, want: %s %v", test.keys, cond, params, test.cond, test.params)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	type txn struct {
+		Id    string
+		TxnDt string
+	}
+
+	// txns are listed with their txn_dt cast to a date, which is what the token carries, so a page has to
+	// start after the date and not the timestamp stored or the rest of the day's txns come back again
+	p := NewPager(2, []Key{{Col: "txn_dt"}, {Col: "id"}}, "").Dates("txn_dt")
+	var txns []txn
+	q, err := p.Apply(orm.NewQuery(nil, &txns).ColumnExpr("*, txn_dt::date"), p.Token("2021-03-01", "5f0c"))
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	b, err := orm.NewSelectQuery(q).AppendQuery(orm.NewFormatter().WithModel(q), nil)
+	if err != nil {
+		t.Fatalf("formatting query failed: %v", err)
+	}
+
+	want := `SELECT *, txn_dt::date FROM "txns" AS "txn" WHERE ` +
+		`(((("txn".txn_dt::date > '2021-03-01' OR "txn".txn_dt::date IS NULL)) OR ` +
+		`("txn".txn_dt::date = '2021-03-01' AND ("txn".id > '5f0c' OR "txn".id IS NULL)))) ` +
+		`ORDER BY "txn".txn_dt::date ASC, "txn".id ASC LIMIT 3`
+	if string(b) != want {
+		t.Errorf("Apply incorrect, got: %s, want: %s", b, want)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	defaults := []Key{{Col: "txn_dt"}, {Col: "id"}}
+	cols := []string{"id", "txn_dt", "txn_size"}
+	tests := map[string][]Key{
+		"":                        defaults,
+		"txn_dt desc":             {{Col: "txn_dt", Desc: true}, {Col: "id"}},
+		" txn_size ASC , id desc": {{Col: "txn_size"}, {Col: "id", Desc: true}},
+	}
+	for orderBy, want := range tests {
+		keys, err := Order(orderBy, defaults, cols)
+		if err != nil || !reflect.DeepEqual(keys, want) {
+			t.Errorf("Order(%q) got: %v, %v, want: %v", orderBy, keys, err, want)
+		}
+	}
+
+	for _, bad := range []string{"ext_id", "txn_dt up", "txn_dt,", "txn_dt desc id", "txn_dt, txn_dt desc"} {
+		if _, err := Order(bad, defaults, cols); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Order(%q) expected an invalid argument error, got: %v", bad, err)
+		}
+	}
+
+	if Float(0) != "" || Float(1.5) != "1.5" {
+		t.Errorf("Float incorrect, got: %q and %q", Float(0), Float(1.5))
+	}
+}
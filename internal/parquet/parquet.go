@@ -0,0 +1,346 @@
+// Package parquet writes flat tables to parquet files: optional string, double, and date columns, plain
+// encoded and uncompressed so any reader can load them. rows are buffered a row group at a time, so a file
+// of any size is written in bounded memory
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+type colType struct {
+	String string
+	Double string
+	Date   string
+}
+
+var (
+	// Type defines the column types that can be written. strings are utf8 and dates are days since the
+	// epoch, given as yyyy-mm-dd
+	Type = colType{
+		String: "string",
+		Double: "double",
+		Date:   "date"}
+
+	// magic starts and ends every parquet file
+	magic = []byte("PAR1")
+)
+
+// parquet physical types, converted types, and encodings
+const (
+	physInt32     int32 = 1
+	physDouble    int32 = 5
+	physByteArray int32 = 6
+	convUTF8      int32 = 0
+	convDate      int32 = 6
+	encPlain      int32 = 0
+	encRLE        int32 = 3
+	repOptional   int32 = 1
+	pageData      int32 = 0
+)
+
+const (
+	// DefaultGroupRows is the number of rows buffered per row group unless a writer is given another
+	DefaultGroupRows = 64 * 1024
+
+	// secsPerDay converts unix times to the days since the epoch dates are stored as
+	secsPerDay = 24 * 60 * 60
+)
+
+// Column is a column of a table. every column is optional: empty strings and dates are written as nulls
+type Column struct {
+	Name string
+	Type string
+}
+
+// chunk buffers a row group's values for a column: a definition level per row (1 if the value isn't
+// null) and the plain encoded values
+type chunk struct {
+	defs []byte
+	vals bytes.Buffer
+}
+
+// chunkMeta is where a column chunk was written, for the file metadata
+type chunkMeta struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// groupMeta is where a row group was written, for the file metadata
+type groupMeta struct {
+	rows   int64
+	size   int64
+	chunks []chunkMeta
+}
+
+// Writer writes a table to a parquet file
+type Writer struct {
+	w         io.Writer
+	offset    int64
+	cols      []Column
+	groupRows int
+	chunks    []*chunk
+	rows      int
+	groups    []groupMeta
+}
+
+// NewWriter starts a parquet file of a set of columns, writing a row group every groupRows rows
+func NewWriter(w io.Writer, cols []Column, groupRows int) (*Writer, error) {
+	for _, col := range cols {
+		switch col.Type {
+		case Type.String, Type.Double, Type.Date:
+		default:
+			return nil, fmt.Errorf("column %s has unknown type %q", col.Name, col.Type)
+		}
+	}
+	if groupRows <= 0 {
+		groupRows = DefaultGroupRows
+	}
+
+	pw := &Writer{
+		w:         w,
+		cols:      cols,
+		groupRows: groupRows,
+		chunks:    make([]*chunk, len(cols)),
+	}
+	for i := range pw.chunks {
+		pw.chunks[i] = &chunk{}
+	}
+	if err := pw.write(magic); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// write writes to the file, keeping track of the offset
+func (pw *Writer) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing parquet: %w", err)
+	}
+	return nil
+}
+
+// Write adds a row: a string, float64, or nil (null) value per column. a row with a value that doesn't fit
+// its column isn't added
+func (pw *Writer) Write(row []interface{}) error {
+	if len(row) != len(pw.cols) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(pw.cols))
+	}
+	vals := make([][]byte, len(row))
+	for i, val := range row {
+		var err error
+		if vals[i], err = encode(pw.cols[i], val); err != nil {
+			return err
+		}
+	}
+	for i, val := range vals {
+		c := pw.chunks[i]
+		if val == nil {
+			c.defs = append(c.defs, 0)
+			continue
+		}
+		c.defs = append(c.defs, 1)
+		c.vals.Write(val)
+	}
+	pw.rows++
+	if pw.rows >= pw.groupRows {
+		return pw.flush()
+	}
+
+	return nil
+}
+
+// encode plain encodes a value for a column, or returns nil for a null
+func encode(col Column, val interface{}) ([]byte, error) {
+	if val == nil {
+		return nil, nil
+	}
+	switch col.Type {
+	case Type.Double:
+		num, ok := val.(float64)
+		if !ok {
+			return nil, fmt.Errorf("column %s expects a float64, got %T", col.Name, val)
+		}
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(num))
+		return b, nil
+	}
+
+	text, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("column %s expects a string, got %T", col.Name, val)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	if col.Type == Type.Date {
+		dt, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, fmt.Errorf("column %s has invalid date %q", col.Name, text)
+		}
+		return le32(int(dt.Unix() / secsPerDay)), nil
+	}
+
+	return append(le32(len(text)), text...), nil
+}
+
+// flush writes the buffered rows as a row group, a single data page per column
+func (pw *Writer) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	group := groupMeta{rows: int64(pw.rows)}
+	for _, c := range pw.chunks {
+		levels := rleLevels(c.defs)
+		page := make([]byte, 0, 4+len(levels)+c.vals.Len())
+		page = append(page, le32(len(levels))...)
+		page = append(page, levels...)
+		page = append(page, c.vals.Bytes()...)
+
+		hdr := newCompact()
+		hdr.i32(1, pageData)
+		hdr.i32(2, int32(len(page)))
+		hdr.i32(3, int32(len(page)))
+		hdr.begin(5)
+		hdr.i32(1, int32(len(c.defs)))
+		hdr.i32(2, encPlain)
+		hdr.i32(3, encRLE)
+		hdr.i32(4, encRLE)
+		hdr.end()
+		hdr.end()
+
+		meta := chunkMeta{
+			offset: pw.offset,
+			size:   int64(len(hdr.buf) + len(page)),
+			values: int64(len(c.defs)),
+		}
+		if err := pw.write(hdr.buf); err != nil {
+			return err
+		}
+		if err := pw.write(page); err != nil {
+			return err
+		}
+		group.size += meta.size
+		group.chunks = append(group.chunks, meta)
+
+		c.defs = c.defs[:0]
+		c.vals.Reset()
+	}
+	pw.groups = append(pw.groups, group)
+	pw.rows = 0
+
+	return nil
+}
+
+// rleLevels encodes definition levels (bit width 1) as runs of the rle/bit-packed hybrid encoding
+func rleLevels(defs []byte) []byte {
+	var buf []byte
+	var b [binary.MaxVarintLen64]byte
+	for i := 0; i < len(defs); {
+		j := i
+		for j < len(defs) && defs[j] == defs[i] {
+			j++
+		}
+		buf = append(buf, b[:binary.PutUvarint(b[:], uint64(j-i)<<1)]...)
+		buf = append(buf, defs[i])
+		i = j
+	}
+	return buf
+}
+
+// Close writes any buffered rows and the file metadata. it doesn't close the underlying writer
+func (pw *Writer) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, group := range pw.groups {
+		total += group.rows
+	}
+	meta := newCompact()
+	meta.i32(1, 1)
+	meta.list(2, tStruct, len(pw.cols)+1)
+	meta.begin(0)
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(pw.cols)))
+	meta.end()
+	for _, col := range pw.cols {
+		meta.begin(0)
+		meta.i32(1, physType(col.Type))
+		meta.i32(3, repOptional)
+		meta.str(4, col.Name)
+		switch col.Type {
+		case Type.String:
+			meta.i32(6, convUTF8)
+		case Type.Date:
+			meta.i32(6, convDate)
+		}
+		meta.end()
+	}
+	meta.i64(3, total)
+	meta.list(4, tStruct, len(pw.groups))
+	for _, group := range pw.groups {
+		meta.begin(0)
+		meta.list(1, tStruct, len(group.chunks))
+		for i, c := range group.chunks {
+			meta.begin(0)
+			meta.i64(2, c.offset)
+			meta.begin(3)
+			meta.i32(1, physType(pw.cols[i].Type))
+			meta.list(2, tI32, 2)
+			meta.elemI32(encPlain)
+			meta.elemI32(encRLE)
+			meta.list(3, tBinary, 1)
+			meta.elemStr(pw.cols[i].Name)
+			meta.i32(4, 0)
+			meta.i64(5, c.values)
+			meta.i64(6, c.size)
+			meta.i64(7, c.size)
+			meta.i64(9, c.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, group.size)
+		meta.i64(3, group.rows)
+		meta.end()
+	}
+	meta.str(6, "varangian")
+	meta.end()
+
+	if err := pw.write(meta.buf); err != nil {
+		return err
+	}
+	if err := pw.write(le32(len(meta.buf))); err != nil {
+		return err
+	}
+
+	return pw.write(magic)
+}
+
+// physType gets the physical type a column type is stored as
+func physType(typ string) int32 {
+	switch typ {
+	case Type.Double:
+		return physDouble
+	case Type.Date:
+		return physInt32
+	default:
+		return physByteArray
+	}
+}
+
+// le32 encodes a length as 4 little endian bytes
+func le32(n int) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(n))
+	return b[:]
+}
@@ -0,0 +1,170 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{
+		{Name: "id", Type: Type.String},
+		{Name: "dt", Type: Type.Date},
+		{Name: "size", Type: Type.Double},
+	}, 2)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for _, row := range [][]interface{}{
+		{"lot_a", "2021-03-01", 100.0},
+		{"", "1970-01-02", -5.5},
+		{"lot_c", "", nil},
+	} {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("Write(%v) failed: %v", row, err)
+		}
+	}
+	if len(w.groups) != 1 || w.rows != 1 {
+		t.Errorf("Write didn't flush a row group after 2 rows, got %d groups and %d rows", len(w.groups), w.rows)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// read the file back: the footer's metadata, then each column chunk's page through the offsets it gives
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, magic) || !bytes.HasSuffix(data, magic) {
+		t.Fatalf("file isn't framed by %s", magic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen <= 0 || footerLen > len(data)-12 {
+		t.Fatalf("footer length %d out of range", footerLen)
+	}
+	meta := (&decoder{buf: data[len(data)-8-footerLen : len(data)-8]}).strct()
+	if meta[1] != int64(1) || meta[3] != int64(3) {
+		t.Errorf("file metadata incorrect, got version %v and %v rows, want: 1 and 3", meta[1], meta[3])
+	}
+
+	// physical types byte_array 6, int32 1, and double 5, all optional (1), with converted types utf8 0 and
+	// date 6
+	schema := []interface{}{
+		map[int]interface{}{4: "schema", 5: int64(3)},
+		map[int]interface{}{1: int64(6), 3: int64(1), 4: "id", 6: int64(0)},
+		map[int]interface{}{1: int64(1), 3: int64(1), 4: "dt", 6: int64(6)},
+		map[int]interface{}{1: int64(5), 3: int64(1), 4: "size"},
+	}
+	if !reflect.DeepEqual(meta[2], schema) {
+		t.Errorf("schema incorrect, got: %v, want: %v", meta[2], schema)
+	}
+
+	groups, _ := meta[4].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("file has %d row groups, want: 2", len(groups))
+	}
+	cols := make([][]interface{}, 3)
+	for _, g := range groups {
+		chunks, _ := g.(map[int]interface{})[1].([]interface{})
+		if len(chunks) != len(cols) {
+			t.Fatalf("row group has %d column chunks, want: %d", len(chunks), len(cols))
+		}
+		for i, c := range chunks {
+			chunkMeta := c.(map[int]interface{})[3].(map[int]interface{})
+			vals := readPage(t, data, int(chunkMeta[9].(int64)), chunkMeta[1].(int64))
+			if chunkMeta[5] != int64(len(vals)) {
+				t.Errorf("column chunk has %d values, metadata says %v", len(vals), chunkMeta[5])
+			}
+			cols[i] = append(cols[i], vals...)
+		}
+	}
+	want := [][]interface{}{
+		{"lot_a", nil, "lot_c"},
+		{int32(18687), int32(1), nil},
+		{100.0, -5.5, nil},
+	}
+	if !reflect.DeepEqual(cols, want) {
+		t.Errorf("values incorrect, got: %v, want: %v", cols, want)
+	}
+
+	for _, row := range [][]interface{}{
+		{"a", "b"},
+		{"a", "2021-13-01", 1.0},
+		{"a", "2021-03-01", "1"},
+		{1, "2021-03-01", 1.0},
+	} {
+		if err := w.Write(row); err == nil {
+			t.Errorf("Write(%v) expected an error", row)
+		}
+	}
+	if _, err := NewWriter(&buf, []Column{{Name: "x", Type: "int"}}, 0); err == nil {
+		t.Errorf("NewWriter expected an error for an unknown type")
+	}
+}
+
+// readPage reads the plain encoded data page at an offset, returning a value (or nil for a null) per row
+func readPage(t *testing.T, data []byte, offset int, typ int64) []interface{} {
+	d := &decoder{buf: data, pos: offset}
+	hdr := d.strct()
+	dataHdr, _ := hdr[5].(map[int]interface{})
+	size, _ := hdr[3].(int64)
+	if hdr[1] != int64(0) || dataHdr == nil || dataHdr[2] != int64(0) || dataHdr[3] != int64(3) {
+		t.Fatalf("page at %d isn't a plain data page with rle levels, got: %v", offset, hdr)
+	}
+	page := data[d.pos : d.pos+int(size)]
+
+	// definition levels are rle runs of 1 bit values, each a varint count (shifted left one) and a byte
+	levelLen := int(binary.LittleEndian.Uint32(page))
+	levels := &decoder{buf: page[4 : 4+levelLen]}
+	var defs []byte
+	for levels.pos < len(levels.buf) {
+		run := levels.varint()
+		if run&1 != 0 {
+			t.Fatalf("page at %d has bit packed levels", offset)
+		}
+		def := levels.byte()
+		for i := uint64(0); i < run>>1; i++ {
+			defs = append(defs, def)
+		}
+	}
+	if int64(len(defs)) != dataHdr[1] {
+		t.Fatalf("page at %d has %d levels, header says %v", offset, len(defs), dataHdr[1])
+	}
+
+	vals := page[4+levelLen:]
+	var out []interface{}
+	for _, def := range defs {
+		if def == 0 {
+			out = append(out, nil)
+			continue
+		}
+		switch typ {
+		case 6:
+			n := int(binary.LittleEndian.Uint32(vals))
+			out = append(out, string(vals[4:4+n]))
+			vals = vals[4+n:]
+		case 1:
+			out = append(out, int32(binary.LittleEndian.Uint32(vals)))
+			vals = vals[4:]
+		case 5:
+			out = append(out, math.Float64frombits(binary.LittleEndian.Uint64(vals)))
+			vals = vals[8:]
+		default:
+			t.Fatalf("page at %d has unexpected physical type %d", offset, typ)
+		}
+	}
+	if len(vals) != 0 {
+		t.Errorf("page at %d has %d bytes left over", offset, len(vals))
+	}
+
+	return out
+}
+
+func TestRLELevels(t *testing.T) {
+	got := rleLevels([]byte{1, 1, 1, 0, 0, 1})
+	want := []byte{6, 1, 4, 0, 2, 1}
+	if !bytes.Equal(got, want) {
+		t.Errorf("rleLevels got: %v, want: %v", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// thrift compact protocol field types
+const (
+	tI32    byte = 5
+	tI64    byte = 6
+	tBinary byte = 8
+	tList   byte = 9
+	tStruct byte = 12
+)
+
+// compact encodes the thrift compact protocol parquet metadata is written in. fields are written in id
+// order; last holds the id of the last field written in each struct being written
+type compact struct {
+	buf  []byte
+	last []int
+}
+
+// newCompact starts encoding a top level struct
+func newCompact() *compact {
+	return &compact{last: []int{0}}
+}
+
+// varint appends an unsigned varint
+func (c *compact) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	c.buf = append(c.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+// field appends a field header, as a delta from the last field id when it fits
+func (c *compact) field(id int, typ byte) {
+	last := &c.last[len(c.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.buf = append(c.buf, byte(delta<<4)|typ)
+	} else {
+		c.buf = append(c.buf, typ)
+		c.varint(uint64((id << 1) ^ (id >> 63)))
+	}
+	*last = id
+}
+
+// i32 appends an i32 field
+func (c *compact) i32(id int, v int32) {
+	c.field(id, tI32)
+	c.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+// i64 appends an i64 field
+func (c *compact) i64(id int, v int64) {
+	c.field(id, tI64)
+	c.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+// str appends a string field
+func (c *compact) str(id int, v string) {
+	c.field(id, tBinary)
+	c.varint(uint64(len(v)))
+	c.buf = append(c.buf, v...)
+}
+
+// list appends the header of a list field of n elements of a type
+func (c *compact) list(id int, typ byte, n int) {
+	c.field(id, tList)
+	if n < 15 {
+		c.buf = append(c.buf, byte(n<<4)|typ)
+		return
+	}
+	c.buf = append(c.buf, 0xf0|typ)
+	c.varint(uint64(n))
+}
+
+// elemI32 appends an i32 list element
+func (c *compact) elemI32(v int32) {
+	c.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+// elemStr appends a string list element
+func (c *compact) elemStr(v string) {
+	c.varint(uint64(len(v)))
+	c.buf = append(c.buf, v...)
+}
+
+// begin starts a struct, either a struct field (id > 0) or a struct list element (id 0)
+func (c *compact) begin(id int) {
+	if id > 0 {
+		c.field(id, tStruct)
+	}
+	c.last = append(c.last, 0)
+}
+
+// end ends the struct being written
+func (c *compact) end() {
+	c.buf = append(c.buf, 0)
+	c.last = c.last[:len(c.last)-1]
+}
@@ -0,0 +1,131 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decoder decodes the thrift compact protocol independently of compact, to read back what it wrote.
+// structs decode to their fields by id, lists to slices, integers to int64, and binaries to strings
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+// byte reads a single byte
+func (d *decoder) byte() byte {
+	if d.pos >= len(d.buf) {
+		panic("thrift: unexpected end of data")
+	}
+	d.pos++
+	return d.buf[d.pos-1]
+}
+
+// varint reads an unsigned varint
+func (d *decoder) varint() uint64 {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		panic("thrift: invalid varint")
+	}
+	d.pos += n
+	return v
+}
+
+// zigzag reads a signed varint
+func (d *decoder) zigzag() int64 {
+	v := d.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// value reads a value of a type
+func (d *decoder) value(typ byte) interface{} {
+	switch typ {
+	case tI32, tI64:
+		return d.zigzag()
+	case tBinary:
+		n := int(d.varint())
+		if d.pos+n > len(d.buf) {
+			panic("thrift: binary past the end of data")
+		}
+		d.pos += n
+		return string(d.buf[d.pos-n : d.pos])
+	case tList:
+		hdr := d.byte()
+		n := int(hdr >> 4)
+		if n == 15 {
+			n = int(d.varint())
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			elems[i] = d.value(hdr & 0x0f)
+		}
+		return elems
+	case tStruct:
+		return d.strct()
+	}
+	panic(fmt.Sprintf("thrift: unexpected type %d", typ))
+}
+
+// strct reads a struct up to its stop field
+func (d *decoder) strct() map[int]interface{} {
+	fields := make(map[int]interface{})
+	last := 0
+	for {
+		hdr := d.byte()
+		if hdr == 0 {
+			return fields
+		}
+		id := last + int(hdr>>4)
+		if hdr>>4 == 0 {
+			id = int(d.zigzag())
+		}
+		fields[id] = d.value(hdr & 0x0f)
+		last = id
+	}
+}
+
+func TestCompact(t *testing.T) {
+	names := make([]string, 20)
+	for i := range names {
+		names[i] = strings.Repeat("x", i)
+	}
+
+	c := newCompact()
+	c.i32(1, -3)
+	c.str(2, "schema")
+	c.i64(40, 1<<40)
+	c.begin(41)
+	c.list(1, tI32, 2)
+	c.elemI32(0)
+	c.elemI32(-1)
+	c.end()
+	c.list(42, tBinary, len(names))
+	for _, name := range names {
+		c.elemStr(name)
+	}
+	c.list(43, tStruct, 1)
+	c.begin(0)
+	c.i32(2, 7)
+	c.end()
+	c.end()
+
+	elems := make([]interface{}, len(names))
+	for i, name := range names {
+		elems[i] = name
+	}
+	want := map[int]interface{}{
+		1:  int64(-3),
+		2:  "schema",
+		40: int64(1 << 40),
+		41: map[int]interface{}{1: []interface{}{int64(0), int64(-1)}},
+		42: elems,
+		43: []interface{}{map[int]interface{}{2: int64(7)}},
+	}
+	d := &decoder{buf: c.buf}
+	if got := d.strct(); !reflect.DeepEqual(got, want) || d.pos != len(c.buf) {
+		t.Errorf("compact round trip incorrect, got: %v (%d of %d bytes read), want: %v", got, d.pos, len(c.buf), want)
+	}
+}
@@ -0,0 +1,38 @@
+package perf
+
+// Normalize scales a set of weights so they sum to one, leaving weights that sum to zero as they are
+func Normalize(wts map[string]float64) map[string]float64 {
+	total := 0.0
+	for _, w := range wts {
+		total += w
+	}
+
+	norm := make(map[string]float64, len(wts))
+	for k, w := range wts {
+		norm[k] = w
+		if total != 0 {
+			norm[k] = w / total
+		}
+	}
+
+	return norm
+}
+
+// Drift calculates the return of a basket of constituents over a period from their weights at the start
+// and their returns, along with the weights the constituents drift to by the end
+func Drift(wts map[string]float64, rets map[string]float64) (float64, map[string]float64) {
+	ret := 0.0
+	for k, w := range wts {
+		ret += w * rets[k]
+	}
+
+	drifted := make(map[string]float64, len(wts))
+	for k, w := range wts {
+		drifted[k] = w * (1 + rets[k])
+		if ret != -1 {
+			drifted[k] /= 1 + ret
+		}
+	}
+
+	return ret, drifted
+}
@@ -0,0 +1,29 @@
+package perf
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	got := Normalize(map[string]float64{"a": 3, "b": 1})
+	if math.Abs(got["a"]-0.75) > 1e-12 || math.Abs(got["b"]-0.25) > 1e-12 {
+		t.Errorf("Normalize incorrect, got: %v, want: map[a:0.75 b:0.25]", got)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	ret, drifted := Drift(map[string]float64{"a": 0.5, "b": 0.5}, map[string]float64{"a": 0.1, "b": -0.1})
+	if math.Abs(ret) > 1e-12 {
+		t.Errorf("Drift return incorrect, got: %f, want: %f", ret, 0.0)
+	}
+	if math.Abs(drifted["a"]-0.55) > 1e-12 || math.Abs(drifted["b"]-0.45) > 1e-12 {
+		t.Errorf("Drift weights incorrect, got: %v, want: map[a:0.55 b:0.45]", drifted)
+	}
+
+	// drifted weights still sum to one
+	_, drifted = Drift(map[string]float64{"a": 0.6, "b": 0.4}, map[string]float64{"a": 0.2, "b": 0.05})
+	if total := drifted["a"] + drifted["b"]; math.Abs(total-1) > 1e-12 {
+		t.Errorf("Drift weights don't sum to one, got: %f", total)
+	}
+}
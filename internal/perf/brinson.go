@@ -0,0 +1,121 @@
+package perf
+
+import (
+	"math"
+	"sort"
+)
+
+// Segment holds the portfolio and benchmark weights and returns of a single group (e.g., a sector) over
+// a period, along with its attribution effects
+type Segment struct {
+	Key     string
+	PortWt  float64
+	PortRet float64
+	BmkWt   float64
+	BmkRet  float64
+
+	Allocation  float64
+	Selection   float64
+	Interaction float64
+}
+
+// Total returns the sum of the segment's effects
+func (s *Segment) Total() float64 {
+	return s.Allocation + s.Selection + s.Interaction
+}
+
+// Attribution breaks down the active return (portfolio return less benchmark return) of a period into
+// the effects of each segment
+type Attribution struct {
+	PortRet  float64
+	BmkRet   float64
+	Segments []*Segment
+}
+
+// ActiveRet returns the portfolio return less the benchmark return
+func (a *Attribution) ActiveRet() float64 {
+	return a.PortRet - a.BmkRet
+}
+
+// Attribute calculates brinson-fachler allocation, selection, and interaction effects for a single period
+// from each segment's weights and returns:
+//
+//	allocation  = (port wt - bmk wt) * (bmk segment ret - bmk total ret)
+//	selection   = bmk wt * (port ret - bmk ret)
+//	interaction = (port wt - bmk wt) * (port ret - bmk ret)
+func Attribute(segs []*Segment) *Attribution {
+	att := &Attribution{Segments: segs}
+	for _, seg := range segs {
+		att.PortRet += seg.PortWt * seg.PortRet
+		att.BmkRet += seg.BmkWt * seg.BmkRet
+	}
+
+	for _, seg := range segs {
+		seg.Allocation = (seg.PortWt - seg.BmkWt) * (seg.BmkRet - att.BmkRet)
+		seg.Selection = seg.BmkWt * (seg.PortRet - seg.BmkRet)
+		seg.Interaction = (seg.PortWt - seg.BmkWt) * (seg.PortRet - seg.BmkRet)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Key < segs[j].Key })
+
+	return att
+}
+
+// carino returns the carino log-linking coefficient for a portfolio and benchmark return
+func carino(portRet float64, bmkRet float64) float64 {
+	if portRet == bmkRet {
+		return 1 / (1 + portRet)
+	}
+	return (math.Log1p(portRet) - math.Log1p(bmkRet)) / (portRet - bmkRet)
+}
+
+// LinkCarino links a series of single period attributions into one using carino smoothing, so the linked
+// effects add up to the linked active return. linked segment weights are the average weight over the
+// periods and linked segment returns are compounded
+func LinkCarino(atts []*Attribution) *Attribution {
+	linked := &Attribution{}
+	portRets := make([]float64, len(atts))
+	bmkRets := make([]float64, len(atts))
+	for i, att := range atts {
+		portRets[i] = att.PortRet
+		bmkRets[i] = att.BmkRet
+	}
+	linked.PortRet = Link(portRets)
+	linked.BmkRet = Link(bmkRets)
+	if len(atts) == 0 {
+		return linked
+	}
+
+	k := carino(linked.PortRet, linked.BmkRet)
+	segMap := make(map[string]*Segment)
+	portGrowth := make(map[string]float64)
+	bmkGrowth := make(map[string]float64)
+	for _, att := range atts {
+		kt := carino(att.PortRet, att.BmkRet)
+		for _, seg := range att.Segments {
+			l, ok := segMap[seg.Key]
+			if !ok {
+				l = &Segment{Key: seg.Key}
+				segMap[seg.Key] = l
+				linked.Segments = append(linked.Segments, l)
+				portGrowth[seg.Key] = 1
+				bmkGrowth[seg.Key] = 1
+			}
+			l.PortWt += seg.PortWt / float64(len(atts))
+			l.BmkWt += seg.BmkWt / float64(len(atts))
+			portGrowth[seg.Key] *= 1 + seg.PortRet
+			bmkGrowth[seg.Key] *= 1 + seg.BmkRet
+
+			l.Allocation += seg.Allocation * kt / k
+			l.Selection += seg.Selection * kt / k
+			l.Interaction += seg.Interaction * kt / k
+		}
+	}
+
+	for _, l := range linked.Segments {
+		l.PortRet = portGrowth[l.Key] - 1
+		l.BmkRet = bmkGrowth[l.Key] - 1
+	}
+	sort.Slice(linked.Segments, func(i, j int) bool { return linked.Segments[i].Key < linked.Segments[j].Key })
+
+	return linked
+}
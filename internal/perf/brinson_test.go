@@ -0,0 +1,74 @@
+package perf
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAttribute(t *testing.T) {
+	att := Attribute([]*Segment{
+		{Key: "tech", PortWt: 0.6, PortRet: 0.10, BmkWt: 0.5, BmkRet: 0.08},
+		{Key: "energy", PortWt: 0.4, PortRet: 0.02, BmkWt: 0.5, BmkRet: 0.04},
+	})
+
+	if math.Abs(att.PortRet-0.068) > 1e-12 || math.Abs(att.BmkRet-0.06) > 1e-12 {
+		t.Errorf("Attribute returns incorrect, got: %f, %f, want: %f, %f", att.PortRet, att.BmkRet, 0.068, 0.06)
+	}
+
+	// segments are sorted by key
+	energy, tech := att.Segments[0], att.Segments[1]
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"tech allocation", tech.Allocation, 0.1 * 0.02},
+		{"tech selection", tech.Selection, 0.5 * 0.02},
+		{"tech interaction", tech.Interaction, 0.1 * 0.02},
+		{"energy allocation", energy.Allocation, -0.1 * -0.02},
+		{"energy selection", energy.Selection, 0.5 * -0.02},
+		{"energy interaction", energy.Interaction, -0.1 * -0.02},
+	}
+	for _, test := range tests {
+		if math.Abs(test.got-test.want) > 1e-12 {
+			t.Errorf("Attribute %s incorrect, got: %f, want: %f", test.name, test.got, test.want)
+		}
+	}
+
+	// effects add up to the active return
+	if total := tech.Total() + energy.Total(); math.Abs(total-att.ActiveRet()) > 1e-12 {
+		t.Errorf("Attribute effects don't add up, got: %f, want: %f", total, att.ActiveRet())
+	}
+}
+
+func TestLinkCarino(t *testing.T) {
+	atts := []*Attribution{
+		Attribute([]*Segment{
+			{Key: "tech", PortWt: 0.6, PortRet: 0.10, BmkWt: 0.5, BmkRet: 0.08},
+			{Key: "energy", PortWt: 0.4, PortRet: 0.02, BmkWt: 0.5, BmkRet: 0.04},
+		}),
+		Attribute([]*Segment{
+			{Key: "tech", PortWt: 0.7, PortRet: -0.05, BmkWt: 0.5, BmkRet: -0.03},
+			{Key: "energy", PortWt: 0.3, PortRet: 0.01, BmkWt: 0.5, BmkRet: 0.03},
+		}),
+	}
+	linked := LinkCarino(atts)
+
+	wantPort := (1+atts[0].PortRet)*(1+atts[1].PortRet) - 1
+	wantBmk := (1+atts[0].BmkRet)*(1+atts[1].BmkRet) - 1
+	if math.Abs(linked.PortRet-wantPort) > 1e-12 || math.Abs(linked.BmkRet-wantBmk) > 1e-12 {
+		t.Errorf("LinkCarino returns incorrect, got: %f, %f, want: %f, %f", linked.PortRet, linked.BmkRet, wantPort, wantBmk)
+	}
+
+	total := 0.0
+	for _, seg := range linked.Segments {
+		total += seg.Total()
+	}
+	if math.Abs(total-linked.ActiveRet()) > 1e-12 {
+		t.Errorf("LinkCarino effects don't add up, got: %f, want: %f", total, linked.ActiveRet())
+	}
+
+	if tech := linked.Segments[1]; math.Abs(tech.PortWt-0.65) > 1e-12 || math.Abs(tech.PortRet-(1.1*0.95-1)) > 1e-12 {
+		t.Errorf("LinkCarino tech segment incorrect, got: %f, %f, want: %f, %f", tech.PortWt, tech.PortRet, 0.65, 1.1*0.95-1)
+	}
+}
@@ -0,0 +1,86 @@
+// Package perf holds the performance calculations used by the Performance service
+package perf
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type period struct {
+	MTD string
+	QTD string
+	YTD string
+	ITD string
+}
+
+var (
+	// Period defines the list of relative periods supported
+	Period = period{
+		MTD: "mtd",
+		QTD: "qtd",
+		YTD: "ytd",
+		ITD: "itd"}
+)
+
+// Day holds the valuations and flows for a single day. flows are external (e.g., contributions and
+// transfers in are positive, withdrawals and transfers out are negative) and assumed to happen at the
+// start of the day. fees are paid out of the portfolio and are already reflected in the ending value
+type Day struct {
+	Dt   time.Time
+	BMV  float64
+	EMV  float64
+	Flow float64
+	Fee  float64
+}
+
+// DailyReturn calculates the gross and net of fee returns for a day. days without any capital invested
+// return zero
+func DailyReturn(d Day) (gross float64, net float64) {
+	denom := d.BMV + d.Flow
+	if denom <= 0 {
+		return 0, 0
+	}
+
+	net = (d.EMV - d.BMV - d.Flow) / denom
+	gross = (d.EMV - d.BMV - d.Flow + d.Fee) / denom
+
+	return gross, net
+}
+
+// Link chain-links a series of returns into a single cumulative return
+func Link(rets []float64) float64 {
+	cum := 1.0
+	for _, r := range rets {
+		cum *= 1 + r
+	}
+
+	return cum - 1
+}
+
+// PeriodStart returns the start date (exclusive, i.e. the prior day's close) of a relative period ending
+// on the end date. ITD periods start at inception
+func PeriodStart(p string, end time.Time, inception time.Time) (time.Time, error) {
+	var start time.Time
+	switch strings.ToLower(p) {
+	case Period.MTD:
+		start = time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Period.QTD:
+		qtrMonth := time.Month(((int(end.Month())-1)/3)*3 + 1)
+		start = time.Date(end.Year(), qtrMonth, 1, 0, 0, 0, 0, time.UTC)
+	case Period.YTD:
+		start = time.Date(end.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	case Period.ITD:
+		return inception, nil
+	default:
+		return time.Time{}, fmt.Errorf("unsupported period %s", p)
+	}
+
+	// periods are measured from the prior day's close
+	start = start.AddDate(0, 0, -1)
+	if start.Before(inception) {
+		start = inception
+	}
+
+	return start, nil
+}
@@ -0,0 +1,59 @@
+package perf
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDailyReturn(t *testing.T) {
+	// 100 start, 50 contributed, 5 fee paid, 160 end
+	gross, net := DailyReturn(Day{BMV: 100, EMV: 160, Flow: 50, Fee: 5})
+	if math.Abs(net-10.0/150) > 1e-12 {
+		t.Errorf("DailyReturn net incorrect, got: %f, want: %f", net, 10.0/150)
+	}
+	if math.Abs(gross-15.0/150) > 1e-12 {
+		t.Errorf("DailyReturn gross incorrect, got: %f, want: %f", gross, 15.0/150)
+	}
+
+	gross, net = DailyReturn(Day{BMV: 0, EMV: 0})
+	if gross != 0 || net != 0 {
+		t.Errorf("DailyReturn with no capital incorrect, got: %f, %f, want: 0, 0", gross, net)
+	}
+}
+
+func TestLink(t *testing.T) {
+	got := Link([]float64{0.1, -0.1, 0.05})
+	want := 1.1*0.9*1.05 - 1
+	if math.Abs(got-want) > 1e-12 {
+		t.Errorf("Link incorrect, got: %f, want: %f", got, want)
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	end := time.Date(2021, time.May, 14, 0, 0, 0, 0, time.UTC)
+	inception := time.Date(2020, time.June, 30, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		period string
+		want   time.Time
+	}{
+		{Period.MTD, time.Date(2021, time.April, 30, 0, 0, 0, 0, time.UTC)},
+		{Period.QTD, time.Date(2021, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		{Period.YTD, time.Date(2020, time.December, 31, 0, 0, 0, 0, time.UTC)},
+		{Period.ITD, inception},
+	}
+	for _, test := range tests {
+		got, err := PeriodStart(test.period, end, inception)
+		if err != nil {
+			t.Error(err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("PeriodStart %s incorrect, got: %s, want: %s", test.period, got, test.want)
+		}
+	}
+
+	if _, err := PeriodStart("bogus", end, inception); err == nil {
+		t.Error("PeriodStart expected error for unsupported period")
+	}
+}
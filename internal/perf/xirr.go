@@ -0,0 +1,160 @@
+package perf
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrNoSolution is returned when a set of cash flows has no internal rate of return
+	ErrNoSolution = errors.New("cash flows have no internal rate of return")
+)
+
+const (
+	xirrTol      = 1e-10
+	xirrMaxIter  = 100
+	xirrMinRate  = -0.9999
+	xirrMaxRate  = 1e4
+	xirrScanStep = 2000
+)
+
+// CashFlow is a dated cash flow from the investor's point of view (money put in is negative, money taken
+// out or left at the end is positive)
+type CashFlow struct {
+	Dt  time.Time
+	Amt float64
+}
+
+// npv returns the net present value of the cash flows at an annual rate along with its derivative. flows
+// are discounted back to the first flow's date on an act/365 basis
+func npv(flows []CashFlow, rate float64) (float64, float64) {
+	t0 := flows[0].Dt
+	v, dv := 0.0, 0.0
+	for _, f := range flows {
+		t := f.Dt.Sub(t0).Hours() / 24 / 365
+		df := math.Pow(1+rate, -t)
+		v += f.Amt * df
+		dv -= t * f.Amt * df / (1 + rate)
+	}
+
+	return v, dv
+}
+
+// XIRRRoots finds every internal rate of return of a set of irregularly dated cash flows. the rate range
+// is scanned for sign changes in the net present value and each bracketed root is refined with newton's
+// method, falling back to bisection whenever a newton step leaves the bracket
+func XIRRRoots(flows []CashFlow) []float64 {
+	if len(flows) < 2 {
+		return nil
+	}
+	flows = append([]CashFlow(nil), flows...)
+	sort.SliceStable(flows, func(i, j int) bool { return flows[i].Dt.Before(flows[j].Dt) })
+
+	// flows need to change sign at least once to have a root
+	pos, neg := false, false
+	for _, f := range flows {
+		pos = pos || f.Amt > 0
+		neg = neg || f.Amt < 0
+	}
+	if !pos || !neg {
+		return nil
+	}
+
+	// scan growth factors (1 + rate) on a log scale so low rates get the finest grid
+	var roots []float64
+	lo, hi := math.Log(1+xirrMinRate), math.Log(1+xirrMaxRate)
+	prevRate := xirrMinRate
+	prevV, _ := npv(flows, prevRate)
+	for i := 1; i <= xirrScanStep; i++ {
+		rate := math.Exp(lo+(hi-lo)*float64(i)/xirrScanStep) - 1
+		v, _ := npv(flows, rate)
+		switch {
+		case v == 0:
+			roots = append(roots, rate)
+		case prevV != 0 && (prevV < 0) != (v < 0):
+			roots = append(roots, solve(flows, prevRate, rate))
+		}
+		prevRate, prevV = rate, v
+	}
+
+	// a root where the npv only touches zero has no sign change, so try newton on its own as a last resort
+	if len(roots) == 0 {
+		if rate, ok := newton(flows, 0.1); ok {
+			roots = append(roots, rate)
+		}
+	}
+
+	return roots
+}
+
+// XIRR finds the internal rate of return of a set of irregularly dated cash flows. when there is more than
+// one root the one closest to zero is returned
+func XIRR(flows []CashFlow) (float64, error) {
+	roots := XIRRRoots(flows)
+	if len(roots) == 0 {
+		return 0, ErrNoSolution
+	}
+
+	irr := roots[0]
+	for _, r := range roots[1:] {
+		if math.Abs(r) < math.Abs(irr) {
+			irr = r
+		}
+	}
+
+	return irr, nil
+}
+
+// solve refines a root bracketed by lo and hi
+func solve(flows []CashFlow, lo float64, hi float64) float64 {
+	vLo, _ := npv(flows, lo)
+	rate := (lo + hi) / 2
+	for i := 0; i < xirrMaxIter; i++ {
+		v, dv := npv(flows, rate)
+		if math.Abs(v) < xirrTol {
+			return rate
+		}
+
+		// narrow the bracket
+		if (v < 0) == (vLo < 0) {
+			lo, vLo = rate, v
+		} else {
+			hi = rate
+		}
+
+		// take a newton step if it stays inside the bracket, otherwise bisect
+		next := rate - v/dv
+		if dv == 0 || next <= lo || next >= hi {
+			next = (lo + hi) / 2
+		}
+		if math.Abs(next-rate) < xirrTol {
+			return next
+		}
+		rate = next
+	}
+
+	return rate
+}
+
+// newton runs newton's method from a guess, returning false if it doesn't converge to a root
+func newton(flows []CashFlow, rate float64) (float64, bool) {
+	for i := 0; i < xirrMaxIter; i++ {
+		v, dv := npv(flows, rate)
+		if dv == 0 {
+			return 0, false
+		}
+		next := rate - v/dv
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			return 0, false
+		}
+		if math.Abs(next-rate) < xirrTol {
+			v, _ = npv(flows, next)
+			return next, math.Abs(v) < 1e-6
+		}
+		rate = next
+	}
+
+	return 0, false
+}
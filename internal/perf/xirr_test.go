@@ -0,0 +1,79 @@
+package perf
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestXIRR(t *testing.T) {
+	// invest 1000, double it over exactly one year (365 days)
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	got, err := XIRR([]CashFlow{
+		{Dt: start, Amt: -1000},
+		{Dt: start.AddDate(0, 0, 365), Amt: 2000},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if math.Abs(got-1) > 1e-8 {
+		t.Errorf("XIRR incorrect, got: %f, want: %f", got, 1.0)
+	}
+
+	// irregular flows (matches spreadsheet XIRR)
+	flows := []CashFlow{
+		{Dt: time.Date(2008, time.January, 1, 0, 0, 0, 0, time.UTC), Amt: -10000},
+		{Dt: time.Date(2008, time.March, 1, 0, 0, 0, 0, time.UTC), Amt: 2750},
+		{Dt: time.Date(2008, time.October, 30, 0, 0, 0, 0, time.UTC), Amt: 4250},
+		{Dt: time.Date(2009, time.February, 15, 0, 0, 0, 0, time.UTC), Amt: 3250},
+		{Dt: time.Date(2009, time.April, 1, 0, 0, 0, 0, time.UTC), Amt: 2750},
+	}
+	got, err = XIRR(flows)
+	if err != nil {
+		t.Error(err)
+	}
+	if math.Abs(got-0.373362535) > 1e-6 {
+		t.Errorf("XIRR incorrect, got: %f, want: %f", got, 0.373362535)
+	}
+	if v, _ := npv(flows, got); math.Abs(v) > 1e-6 {
+		t.Errorf("XIRR npv at irr not zero, got: %f", v)
+	}
+}
+
+func TestXIRRNoSolution(t *testing.T) {
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := XIRR([]CashFlow{
+		{Dt: start, Amt: -1000},
+		{Dt: start.AddDate(0, 6, 0), Amt: -500},
+	}); err != ErrNoSolution {
+		t.Errorf("XIRR expected ErrNoSolution, got: %v", err)
+	}
+	if _, err := XIRR([]CashFlow{{Dt: start, Amt: -1000}}); err != ErrNoSolution {
+		t.Errorf("XIRR expected ErrNoSolution for a single flow, got: %v", err)
+	}
+}
+
+func TestXIRRMultipleRoots(t *testing.T) {
+	// annual flows of -1, 5, -6 have roots at 100% and 200%
+	start := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	flows := []CashFlow{
+		{Dt: start, Amt: -1},
+		{Dt: start.AddDate(0, 0, 365), Amt: 5},
+		{Dt: start.AddDate(0, 0, 730), Amt: -6},
+	}
+	roots := XIRRRoots(flows)
+	if len(roots) != 2 {
+		t.Fatalf("XIRRRoots incorrect number of roots, got: %d, want: 2", len(roots))
+	}
+	if math.Abs(roots[0]-1) > 1e-8 || math.Abs(roots[1]-2) > 1e-8 {
+		t.Errorf("XIRRRoots incorrect, got: %v, want: [1 2]", roots)
+	}
+
+	got, err := XIRR(flows)
+	if err != nil {
+		t.Error(err)
+	}
+	if math.Abs(got-1) > 1e-8 {
+		t.Errorf("XIRR incorrect, got: %f, want: %f", got, 1.0)
+	}
+}
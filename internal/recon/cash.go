@@ -0,0 +1,210 @@
+package recon
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// maxGroup caps the number of items searched for a many-to-one match
+	maxGroup = 10
+)
+
+type matchType struct {
+	OneToOne  string
+	ManyToOne string
+}
+
+var (
+	// MatchType defines the kinds of cash matches. a many-to-one match pairs several items on one side
+	// (e.g., txns settled net by the custodian) with a single item on the other
+	MatchType = matchType{
+		OneToOne:  "one_to_one",
+		ManyToOne: "many_to_one"}
+)
+
+// Item is a cash movement of an account in a currency, from either the counterparty or the books. inflows
+// are positive and outflows negative
+type Item struct {
+	ID   string
+	Acct string
+	Ccy  string
+	Dt   time.Time
+	Amt  float64
+}
+
+// CashTol is a tolerance for matching cash movements: the amounts must be within tolerance and the dates no
+// more than a number of days apart
+type CashTol struct {
+	Amt  Tol
+	Days int
+}
+
+// CashMatch is a set of counterparty items matched with a set of book items
+type CashMatch struct {
+	Type    string
+	Cust    []*Item
+	Book    []*Item
+	CustAmt float64
+	BookAmt float64
+}
+
+// MatchCash matches counterparty cash movements with book cash movements of the same account and currency.
+// one-to-one matches are made first, closest amount then closest date; the remaining items are then matched
+// many book items to one counterparty item, then many counterparty items to one book item. the unmatched
+// items on each side are returned along with the matches
+func MatchCash(cust []*Item, book []*Item, tol CashTol) ([]*CashMatch, []*Item, []*Item) {
+	cust = sortItems(cust)
+	book = sortItems(book)
+	used := make(map[*Item]bool, len(cust)+len(book))
+
+	// one to one
+	var matches []*CashMatch
+	for _, c := range cust {
+		var best *Item
+		bestDiff, bestDays := 0.0, 0
+		for _, b := range book {
+			if used[b] || !tol.near(c, b) || !tol.Amt.Within(c.Amt, b.Amt) {
+				continue
+			}
+			diff, days := math.Abs(c.Amt-b.Amt), dayDiff(c, b)
+			if best == nil || diff < bestDiff || (diff == bestDiff && days < bestDays) {
+				best, bestDiff, bestDays = b, diff, days
+			}
+		}
+		if best != nil {
+			used[c], used[best] = true, true
+			matches = append(matches, newCashMatch(MatchType.OneToOne, []*Item{c}, []*Item{best}))
+		}
+	}
+
+	// many book items to one counterparty item
+	for _, c := range cust {
+		if used[c] {
+			continue
+		}
+		if set := tol.subset(c, book, used); set != nil {
+			matches = append(matches, newCashMatch(MatchType.ManyToOne, []*Item{c}, set))
+		}
+	}
+
+	// many counterparty items to one book item
+	for _, b := range book {
+		if used[b] {
+			continue
+		}
+		if set := tol.subset(b, cust, used); set != nil {
+			matches = append(matches, newCashMatch(MatchType.ManyToOne, set, []*Item{b}))
+		}
+	}
+
+	return matches, unused(cust, used), unused(book, used)
+}
+
+// near checks two items are of the same account and currency and within the date tolerance
+func (t CashTol) near(a *Item, b *Item) bool {
+	return a.Acct == b.Acct && a.Ccy == b.Ccy && dayDiff(a, b) <= t.Days
+}
+
+// subset finds the smallest set of two or more unused items that sum to the target item's amount within
+// tolerance, marking the target and the set used. only the items nearest the target's date are searched
+func (t CashTol) subset(target *Item, items []*Item, used map[*Item]bool) []*Item {
+	var cands []*Item
+	for _, item := range items {
+		if !used[item] && t.near(target, item) {
+			cands = append(cands, item)
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		return dayDiff(target, cands[i]) < dayDiff(target, cands[j])
+	})
+	if len(cands) > maxGroup {
+		cands = cands[:maxGroup]
+	}
+
+	for size := 2; size <= len(cands); size++ {
+		idx := make([]int, size)
+		if set := t.combos(target, cands, idx, 0, 0, 0); set != nil {
+			used[target] = true
+			for _, item := range set {
+				used[item] = true
+			}
+			return set
+		}
+	}
+
+	return nil
+}
+
+// combos searches the combinations of candidates filling idx from position pos onward for one summing to
+// the target's amount within tolerance
+func (t CashTol) combos(target *Item, cands []*Item, idx []int, pos int, start int, sum float64) []*Item {
+	if pos == len(idx) {
+		if !t.Amt.Within(target.Amt, sum) {
+			return nil
+		}
+		set := make([]*Item, len(idx))
+		for i, j := range idx {
+			set[i] = cands[j]
+		}
+		return set
+	}
+	for i := start; i <= len(cands)-(len(idx)-pos); i++ {
+		idx[pos] = i
+		if set := t.combos(target, cands, idx, pos+1, i+1, sum+cands[i].Amt); set != nil {
+			return set
+		}
+	}
+
+	return nil
+}
+
+// newCashMatch creates a match of counterparty and book items, totaling each side
+func newCashMatch(typ string, cust []*Item, book []*Item) *CashMatch {
+	m := &CashMatch{
+		Type: typ,
+		Cust: cust,
+		Book: book,
+	}
+	for _, c := range cust {
+		m.CustAmt += c.Amt
+	}
+	for _, b := range book {
+		m.BookAmt += b.Amt
+	}
+
+	return m
+}
+
+// dayDiff gets the number of days between the dates of two items
+func dayDiff(a *Item, b *Item) int {
+	return int(math.Round(math.Abs(a.Dt.Sub(b.Dt).Hours()) / 24))
+}
+
+// sortItems sorts a copy of a set of items by date then id, so matching doesn't depend on the order items
+// are passed in
+func sortItems(items []*Item) []*Item {
+	sorted := make([]*Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].Dt.Equal(sorted[j].Dt) {
+			return sorted[i].Dt.Before(sorted[j].Dt)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	return sorted
+}
+
+// unused gets the items not used in a match
+func unused(items []*Item, used map[*Item]bool) []*Item {
+	var left []*Item
+	for _, item := range items {
+		if !used[item] {
+			left = append(left, item)
+		}
+	}
+
+	return left
+}
@@ -0,0 +1,82 @@
+package recon
+
+import (
+	"testing"
+	"time"
+)
+
+func day(d int) time.Time {
+	return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMatchCash(t *testing.T) {
+	cust := []*Item{
+		{ID: "c1", Acct: "a", Ccy: "usd", Dt: day(2), Amt: -1000},
+		{ID: "c2", Acct: "a", Ccy: "usd", Dt: day(3), Amt: 350},
+		{ID: "c3", Acct: "a", Ccy: "usd", Dt: day(5), Amt: 40},
+		{ID: "c4", Acct: "a", Ccy: "usd", Dt: day(5), Amt: 60},
+		{ID: "c5", Acct: "a", Ccy: "usd", Dt: day(9), Amt: 25},
+		{ID: "c6", Acct: "a", Ccy: "eur", Dt: day(3), Amt: 350},
+	}
+	book := []*Item{
+		{ID: "b1", Acct: "a", Ccy: "usd", Dt: day(1), Amt: -1000.01},
+		{ID: "b2", Acct: "a", Ccy: "usd", Dt: day(3), Amt: 200},
+		{ID: "b3", Acct: "a", Ccy: "usd", Dt: day(3), Amt: 150},
+		{ID: "b4", Acct: "a", Ccy: "usd", Dt: day(5), Amt: 100},
+		{ID: "b5", Acct: "a", Ccy: "usd", Dt: day(4), Amt: 999},
+	}
+	tol := CashTol{Amt: Tol{Abs: 0.05}, Days: 1}
+
+	matches, custLeft, bookLeft := MatchCash(cust, book, tol)
+	want := []struct {
+		typ  string
+		cust []string
+		book []string
+	}{
+		{MatchType.OneToOne, []string{"c1"}, []string{"b1"}},
+		{MatchType.ManyToOne, []string{"c2"}, []string{"b2", "b3"}},
+		{MatchType.ManyToOne, []string{"c3", "c4"}, []string{"b4"}},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("MatchCash found %d matches, want: %d", len(matches), len(want))
+	}
+	for i, w := range want {
+		m := matches[i]
+		if m.Type != w.typ || !sameIDs(m.Cust, w.cust) || !sameIDs(m.Book, w.book) {
+			t.Errorf("MatchCash match %d incorrect, got: %s %v %v, want: %+v", i, m.Type, ids(m.Cust), ids(m.Book), w)
+		}
+	}
+	if !sameIDs(custLeft, []string{"c6", "c5"}) {
+		t.Errorf("MatchCash unmatched cust incorrect, got: %v", ids(custLeft))
+	}
+	if !sameIDs(bookLeft, []string{"b5"}) {
+		t.Errorf("MatchCash unmatched book incorrect, got: %v", ids(bookLeft))
+	}
+
+	// the date tolerance keeps c1 from matching b1
+	_, custLeft, _ = MatchCash(cust[:1], book[:1], CashTol{Amt: Tol{Abs: 0.05}})
+	if len(custLeft) != 1 {
+		t.Errorf("MatchCash without date tolerance matched items a day apart")
+	}
+}
+
+func ids(items []*Item) []string {
+	var out []string
+	for _, item := range items {
+		out = append(out, item.ID)
+	}
+	return out
+}
+
+func sameIDs(items []*Item, want []string) bool {
+	got := ids(items)
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+// Package recon compares positions reported by a counterparty (e.g., a custodian) with positions on the
+// books and finds the breaks between them
+package recon
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// zeroTol absorbs floating point noise when no tolerance is set
+	zeroTol = 1e-9
+)
+
+type breakType struct {
+	MissingBook string
+	MissingCust string
+	Qty         string
+	Unmapped    string
+}
+
+var (
+	// BreakType defines the kinds of breaks a comparison can find. unmapped breaks are counterparty positions
+	// that couldn't be mapped to an account and instrument on the books, so were never compared
+	BreakType = breakType{
+		MissingBook: "missing_book",
+		MissingCust: "missing_cust",
+		Qty:         "qty",
+		Unmapped:    "unmapped"}
+)
+
+// Key identifies a position by account and instrument
+type Key struct {
+	Acct string
+	Inst string
+}
+
+// Book is a position on the books, in total and settled
+type Book struct {
+	Size    float64
+	Settled float64
+}
+
+// Tol is a tolerance for differences in quantity. a difference within either the absolute tolerance or the
+// relative tolerance (a fraction of the larger of the two quantities) isn't a break
+type Tol struct {
+	Abs float64
+	Rel float64
+}
+
+// Within checks if the difference between two quantities is within tolerance
+func (t Tol) Within(a float64, b float64) bool {
+	diff := math.Abs(a - b)
+	if diff <= math.Max(t.Abs, zeroTol) {
+		return true
+	}
+	return diff <= t.Rel*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// Rule is a tolerance for an account and/or instrument. an empty account or instrument matches any
+type Rule struct {
+	Acct string
+	Inst string
+	Tol  Tol
+}
+
+// Match finds the most specific rule for a position: account and instrument, then instrument, then account,
+// then the default rule (neither). no match is a zero tolerance
+func Match(rules []Rule, key Key) Tol {
+	best := -1
+	var tol Tol
+	for _, r := range rules {
+		if (r.Acct != "" && r.Acct != key.Acct) || (r.Inst != "" && r.Inst != key.Inst) {
+			continue
+		}
+		rank := 0
+		if r.Inst != "" {
+			rank += 2
+		}
+		if r.Acct != "" {
+			rank++
+		}
+		if rank > best {
+			best = rank
+			tol = r.Tol
+		}
+	}
+
+	return tol
+}
+
+// Break is a difference between the counterparty's quantity and the books
+type Break struct {
+	Key
+	Type    string
+	CustQty float64
+	Book    Book
+	// Diff is the counterparty quantity less the book quantity compared against
+	Diff float64
+}
+
+// Compare compares counterparty quantities with book positions, against either the settled or total size
+// of the book position. positions only one side has are breaks unless their quantity is within tolerance
+// of zero. breaks are returned in account and instrument order
+func Compare(cust map[Key]float64, book map[Key]Book, settled bool, tol func(Key) Tol) []*Break {
+	keys := make([]Key, 0, len(cust)+len(book))
+	for k := range cust {
+		keys = append(keys, k)
+	}
+	for k := range book {
+		if _, ok := cust[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Acct != keys[j].Acct {
+			return keys[i].Acct < keys[j].Acct
+		}
+		return keys[i].Inst < keys[j].Inst
+	})
+
+	var breaks []*Break
+	for _, k := range keys {
+		custQty, custFlag := cust[k]
+		b, bookFlag := book[k]
+		bookQty := b.Size
+		if settled {
+			bookQty = b.Settled
+		}
+		if tol(k).Within(custQty, bookQty) {
+			continue
+		}
+
+		brk := &Break{
+			Key:     k,
+			Type:    BreakType.Qty,
+			CustQty: custQty,
+			Book:    b,
+			Diff:    custQty - bookQty,
+		}
+		if !bookFlag {
+			brk.Type = BreakType.MissingBook
+		} else if !custFlag {
+			brk.Type = BreakType.MissingCust
+		}
+		breaks = append(breaks, brk)
+	}
+
+	return breaks
+}
@@ -0,0 +1,98 @@
+package recon
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTolWithin(t *testing.T) {
+	tests := []struct {
+		tol  Tol
+		a, b float64
+		want bool
+	}{
+		{Tol{}, 100, 100, true},
+		{Tol{}, 100, 100.5, false},
+		{Tol{Abs: 1}, 100, 100.5, true},
+		{Tol{Rel: 0.001}, 1000, 1000.5, true},
+		{Tol{Rel: 0.001}, 1000, 1002, false},
+		{Tol{Abs: 0.5, Rel: 0.001}, 1000, 1000.8, true},
+	}
+	for _, test := range tests {
+		if got := test.tol.Within(test.a, test.b); got != test.want {
+			t.Errorf("Within(%f, %f) with %+v incorrect, got: %t, want: %t", test.a, test.b, test.tol, got, test.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	rules := []Rule{
+		{Tol: Tol{Abs: 1}},
+		{Acct: "a", Tol: Tol{Abs: 2}},
+		{Inst: "x", Tol: Tol{Abs: 3}},
+		{Acct: "a", Inst: "x", Tol: Tol{Abs: 4}},
+	}
+	tests := []struct {
+		key  Key
+		want float64
+	}{
+		{Key{"a", "x"}, 4},
+		{Key{"b", "x"}, 3},
+		{Key{"a", "y"}, 2},
+		{Key{"b", "y"}, 1},
+	}
+	for _, test := range tests {
+		if got := Match(rules, test.key); got.Abs != test.want {
+			t.Errorf("Match(%+v) incorrect, got: %f, want: %f", test.key, got.Abs, test.want)
+		}
+	}
+
+	if got := Match(nil, Key{"a", "x"}); got != (Tol{}) {
+		t.Errorf("Match with no rules incorrect, got: %+v, want: zero tolerance", got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cust := map[Key]float64{
+		{"a", "x"}: 100,
+		{"a", "y"}: 50,
+		{"a", "z"}: 10,
+	}
+	book := map[Key]Book{
+		{"a", "x"}: {Size: 100, Settled: 100},
+		{"a", "y"}: {Size: 60, Settled: 50},
+		{"a", "w"}: {Size: 5, Settled: 5},
+	}
+	noTol := func(Key) Tol { return Tol{} }
+
+	// against settled sizes only y matches
+	breaks := Compare(cust, book, true, noTol)
+	want := []struct {
+		key  Key
+		typ  string
+		diff float64
+	}{
+		{Key{"a", "w"}, BreakType.MissingCust, -5},
+		{Key{"a", "z"}, BreakType.MissingBook, 10},
+	}
+	if len(breaks) != len(want) {
+		t.Fatalf("Compare settled found %d breaks, want: %d", len(breaks), len(want))
+	}
+	for i, w := range want {
+		if breaks[i].Key != w.key || breaks[i].Type != w.typ || math.Abs(breaks[i].Diff-w.diff) > 1e-12 {
+			t.Errorf("Compare settled break %d incorrect, got: %+v, want: %+v", i, *breaks[i], w)
+		}
+	}
+
+	// against total sizes y breaks too
+	breaks = Compare(cust, book, false, noTol)
+	if len(breaks) != 3 || breaks[1].Key != (Key{"a", "y"}) || breaks[1].Type != BreakType.Qty || breaks[1].Diff != -10 {
+		t.Errorf("Compare total incorrect, got: %d breaks", len(breaks))
+	}
+
+	// a tolerance absorbs the small positions
+	breaks = Compare(cust, book, true, func(Key) Tol { return Tol{Abs: 10} })
+	if len(breaks) != 0 {
+		t.Errorf("Compare with tolerance found %d breaks, want: 0", len(breaks))
+	}
+}
@@ -0,0 +1,72 @@
+// Package sse serves the server-streaming rpcs through the gateway as server-sent events, for clients that
+// ask for them with an `Accept: text/event-stream` header. each message is sent as an event, with its
+// cursor as the event id so a client that reconnects picks up where it left off
+package sse
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+)
+
+const (
+	// ContentType is the content type of server-sent events
+	ContentType = "text/event-stream"
+)
+
+// Marshaler writes the messages of a stream as server-sent events, marshaling each one to json
+type Marshaler struct {
+	runtime.JSONPb
+}
+
+// cursored is a streamed message that can be resumed after
+type cursored interface {
+	GetCursor() string
+}
+
+// ContentType gets the content type of the stream
+func (m *Marshaler) ContentType() string {
+	return ContentType
+}
+
+// Marshal writes a message of a stream as an event. the gateway wraps each message as {"result": ...}, and
+// the error ending a stream as {"error": ...}, which is sent as an error event
+func (m *Marshaler) Marshal(v interface{}) ([]byte, error) {
+	var event, id string
+	switch chunk := v.(type) {
+	case map[string]interface{}:
+		if result, ok := chunk["result"]; ok {
+			v = result
+		}
+	case map[string]proto.Message:
+		if e, ok := chunk["error"]; ok {
+			event, v = "error", e
+		}
+	}
+	if c, ok := v.(cursored); ok {
+		id = c.GetCursor()
+	}
+
+	data, err := m.JSONPb.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", event)
+	}
+	if id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	fmt.Fprintf(&buf, "data: %s\n\n", data)
+
+	return buf.Bytes(), nil
+}
+
+// Delimiter gets what separates events, which is nothing as each event ends itself
+func (m *Marshaler) Delimiter() []byte {
+	return nil
+}
@@ -0,0 +1,19 @@
+package sse
+
+import (
+	"testing"
+
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+)
+
+func TestMarshal(t *testing.T) {
+	m := &Marshaler{}
+	resp := &v1.WatchTxnsResponse{Cursor: "42", Op: "create", Id: "txn_a"}
+	b, err := m.Marshal(map[string]interface{}{"result": resp})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := "id: 42\ndata: {\"cursor\":\"42\",\"op\":\"create\",\"id\":\"txn_a\"}\n\n"; string(b) != want {
+		t.Errorf("Marshal got: %q, want: %q", b, want)
+	}
+}
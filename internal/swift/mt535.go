@@ -0,0 +1,83 @@
+package swift
+
+import (
+	"strings"
+)
+
+// Holding is a position from a statement of holdings (MT535)
+type Holding struct {
+	SecID
+	Line int
+	Acct string
+	Dt   string
+	Qty  float64
+}
+
+// ParseMT535 parses the holdings of a statement of holdings. the statement date (:98A::STAT//) and
+// safekeeping account (:97A::SAFE//) come from the general information, and each financial instrument's
+// aggregate balance (:93B::AGGR//) is its quantity, in units or face amount. a sub-safekeeping account
+// overrides the statement's
+func ParseMT535(m *Message) ([]*Holding, []*Error) {
+	var e errs
+	genl := seqs(m.Fields, "GENL")
+	if len(genl) == 0 {
+		e.failMsg(m, ":16R:", "no general information (GENL) sequence")
+		return nil, e
+	}
+	dt := ""
+	if f := dtField(genl[0], "STAT"); f != nil {
+		dt = e.dateField(f)
+	} else {
+		e.failMsg(m, ":98A:", "statement date (STAT) required")
+	}
+	acct := safe(genl[0])
+
+	var holdings []*Holding
+	for _, subSafe := range seqs(m.Fields, "SUBSAFE") {
+		subAcct := acct
+		if sub := safe(subSafe); sub != "" {
+			subAcct = sub
+		}
+		for _, fin := range seqs(subSafe, "FIN") {
+			idField := get(fin, "35B", "")
+			if idField == nil {
+				e.failMsg(m, ":35B:", "financial instrument has no identification")
+				continue
+			}
+			h := &Holding{
+				SecID: e.secID(idField),
+				Line:  idField.Line,
+				Acct:  subAcct,
+				Dt:    dt,
+			}
+			if h.Acct == "" {
+				e.fail(idField, "no safekeeping account (SAFE)")
+			}
+			h.Qty = e.balance(idField, get(fin, "93B", "AGGR"))
+			holdings = append(holdings, h)
+		}
+	}
+
+	return holdings, e
+}
+
+// balance parses a balance (:93B::AGGR//UNIT/1500, or FAMT/), reporting a missing one against the field a
+// holding starts on
+func (e *errs) balance(start *Field, f *Field) float64 {
+	if f == nil {
+		e.fail(start, "no aggregate balance (AGGR)")
+		return 0
+	}
+	_, val := f.Qual()
+	parts := strings.SplitN(val, "/", 2)
+	if len(parts) != 2 || (parts[0] != "UNIT" && parts[0] != "FAMT") {
+		e.fail(f, "invalid balance %q, expected UNIT/ or FAMT/", val)
+		return 0
+	}
+	qty, err := amount(parts[1])
+	if err != nil {
+		e.fail(f, "%v", err)
+	}
+
+	return qty
+}
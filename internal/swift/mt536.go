@@ -0,0 +1,154 @@
+package swift
+
+import (
+	"strings"
+)
+
+// Movement is a settled securities movement from a statement of transactions (MT536). a receipt or
+// delivery is either against payment (with a settlement amount) or free of payment. Errs are the problems
+// with the movement
+type Movement struct {
+	SecID
+	Line     int
+	Acct     string
+	Ref      string
+	Receive  bool
+	Free     bool
+	Qty      float64
+	Ccy      string
+	Amt      float64
+	TradeDt  string
+	SettleDt string
+	Errs     []*Error
+}
+
+// ParseMT536 parses the movements of a statement of transactions. each transaction (TRAN) of a financial
+// instrument is a movement: its reference from the linkages (:20C:), and from its details (TRANSDET) the
+// posted quantity (:36B::PSTA//) and amount (:19A::PSTA//), receipt or delivery (:22H::REDE//), payment
+// (:22H::PAYM//), and the trade and effective settlement dates (:98A::TRAD// and ::ESET//). the problems
+// returned are with the message as a whole, or with an instrument that has no transactions
+func ParseMT536(m *Message) ([]*Movement, []*Error) {
+	var e errs
+	genl := seqs(m.Fields, "GENL")
+	if len(genl) == 0 {
+		e.failMsg(m, ":16R:", "no general information (GENL) sequence")
+		return nil, e
+	}
+	acct := safe(genl[0])
+
+	var movements []*Movement
+	for _, subSafe := range seqs(m.Fields, "SUBSAFE") {
+		subAcct := acct
+		if sub := safe(subSafe); sub != "" {
+			subAcct = sub
+		}
+		for _, fin := range seqs(subSafe, "FIN") {
+			idField := get(fin, "35B", "")
+			if idField == nil {
+				e.failMsg(m, ":35B:", "financial instrument has no identification")
+				continue
+			}
+			var idErrs errs
+			secID := idErrs.secID(idField)
+			trans := seqs(fin, "TRAN")
+			if len(trans) == 0 {
+				e = append(e, idErrs...)
+			}
+			for _, tran := range trans {
+				mvErrs := append(errs{}, idErrs...)
+				mv := mvErrs.movement(tran, secID, subAcct, idField)
+				mv.Errs = mvErrs
+				movements = append(movements, mv)
+			}
+		}
+	}
+
+	return movements, e
+}
+
+// movement parses a transaction of a financial instrument, reporting missing fields against the first field
+// of the transaction (or the instrument's identification, for an empty one)
+func (e *errs) movement(tran []*Field, secID SecID, acct string, start *Field) *Movement {
+	if len(tran) > 0 {
+		start = tran[0]
+	}
+	mv := &Movement{
+		SecID: secID,
+		Line:  start.Line,
+		Acct:  acct,
+	}
+	if mv.Acct == "" {
+		e.fail(start, "no safekeeping account (SAFE)")
+	}
+	for _, link := range seqs(tran, "LINK") {
+		if f := get(link, "20C", ""); f != nil && mv.Ref == "" {
+			_, mv.Ref = f.Qual()
+		}
+	}
+
+	details := seqs(tran, "TRANSDET")
+	if len(details) == 0 {
+		e.fail(start, "transaction has no details (TRANSDET)")
+		return mv
+	}
+	det := details[0]
+	if f := get(det, "20C", ""); f != nil && mv.Ref == "" {
+		_, mv.Ref = f.Qual()
+	}
+	if mv.Ref == "" {
+		e.fail(start, "transaction has no reference (:20C:)")
+	}
+	if f := get(det, "36B", "PSTA"); f != nil {
+		_, val := f.Qual()
+		parts := strings.SplitN(val, "/", 2)
+		if len(parts) != 2 || (parts[0] != "UNIT" && parts[0] != "FAMT") {
+			e.fail(f, "invalid quantity %q, expected UNIT/ or FAMT/", val)
+		} else if qty, err := amount(parts[1]); err != nil {
+			e.fail(f, "%v", err)
+		} else {
+			mv.Qty = qty
+		}
+	} else {
+		e.fail(start, "transaction has no posted quantity (PSTA)")
+	}
+
+	switch f := get(det, "22H", "REDE"); {
+	case f == nil:
+		e.fail(start, "transaction has no receive/deliver indicator (REDE)")
+	case strings.HasSuffix(f.Val, "//RECE"):
+		mv.Receive = true
+	case !strings.HasSuffix(f.Val, "//DELI"):
+		e.fail(f, "invalid receive/deliver indicator %q", f.Val)
+	}
+	switch f := get(det, "22H", "PAYM"); {
+	case f == nil, strings.HasSuffix(f.Val, "//FREE"):
+		mv.Free = true
+	case !strings.HasSuffix(f.Val, "//APMT"):
+		e.fail(f, "invalid payment indicator %q", f.Val)
+	}
+
+	if f := get(det, "19A", "PSTA"); f != nil {
+		_, val := f.Qual()
+		if len(val) < 4 {
+			e.fail(f, "invalid amount %q", val)
+		} else if amt, err := amount(strings.TrimPrefix(val, "N")[3:]); err != nil {
+			e.fail(f, "%v", err)
+		} else {
+			mv.Ccy, mv.Amt = strings.TrimPrefix(val, "N")[:3], amt
+		}
+	} else if !mv.Free {
+		e.fail(start, "transaction against payment has no posted amount (PSTA)")
+	}
+
+	if f := dtField(det, "ESET"); f != nil {
+		mv.SettleDt = e.dateField(f)
+	} else {
+		e.fail(start, "transaction has no effective settlement date (ESET)")
+	}
+	mv.TradeDt = e.dateField(dtField(det, "TRAD"))
+	if mv.TradeDt == "" {
+		mv.TradeDt = mv.SettleDt
+	}
+
+	return mv
+}
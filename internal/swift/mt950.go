@@ -0,0 +1,88 @@
+package swift
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// statementLine matches a statement line (:61:): value date, optional entry date, debit/credit mark,
+	// optional funds code, amount, transaction type, account owner's reference, and the bank's reference
+	statementLine = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d[\d,]*)([NSF][A-Z0-9]{3})([^/\n]{0,16})(?://([^\n]{0,16}))?(?:\n([\s\S]*))?$`)
+)
+
+// CashLine is a statement line from a cash statement (MT950). debits are negative
+type CashLine struct {
+	Line int
+	Acct string
+	Ccy  string
+	Dt   string
+	Amt  float64
+	Ref  string
+	Memo string
+}
+
+// ParseMT950 parses the statement lines of a cash statement. the account (:25:) and the currency of the
+// opening balance (:60F: or :60M:) apply to every line. a line's reference is the account owner's unless
+// that's NONREF, in which case it's the bank's; its memo is the supplementary details, or the transaction
+// type if there aren't any
+func ParseMT950(m *Message) ([]*CashLine, []*Error) {
+	var e errs
+	acct := ""
+	if f := m.Get("25"); f != nil {
+		acct = strings.TrimSpace(f.Val)
+	} else {
+		e.failMsg(m, ":25:", "account identification required")
+	}
+	ccy := ""
+	opening := m.Get("60F")
+	if opening == nil {
+		opening = m.Get("60M")
+	}
+	switch {
+	case opening == nil:
+		e.failMsg(m, ":60F:", "opening balance required")
+	case len(opening.Val) < 10:
+		e.fail(opening, "invalid opening balance %q", opening.Val)
+	default:
+		ccy = opening.Val[7:10]
+	}
+
+	var lines []*CashLine
+	for _, f := range m.Fields {
+		if f.Tag != "61" {
+			continue
+		}
+		match := statementLine.FindStringSubmatch(f.Val)
+		if match == nil {
+			e.fail(f, "invalid statement line %q", strings.SplitN(f.Val, "\n", 2)[0])
+			continue
+		}
+		cl := &CashLine{
+			Line: f.Line,
+			Acct: acct,
+			Ccy:  ccy,
+			Ref:  strings.TrimSpace(match[7]),
+			Memo: strings.Join(strings.Fields(match[9]), " "),
+		}
+		var err error
+		if cl.Dt, err = date("060102", match[1]); err != nil {
+			e.fail(f, "%v", err)
+		}
+		if cl.Amt, err = amount(match[5]); err != nil {
+			e.fail(f, "%v", err)
+		}
+		if match[3] == "D" || match[3] == "RC" {
+			cl.Amt = -cl.Amt
+		}
+		if cl.Ref == "" || cl.Ref == "NONREF" {
+			cl.Ref = strings.TrimSpace(match[8])
+		}
+		if cl.Memo == "" {
+			cl.Memo = match[6]
+		}
+		lines = append(lines, cl)
+	}
+
+	return lines, e
+}
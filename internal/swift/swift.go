@@ -0,0 +1,280 @@
+// Package swift parses the SWIFT MT messages custodians deliver statements in: statements of holdings
+// (MT535), statements of transactions (MT536), and cash statements (MT950). problems with a message are
+// reported by field so a whole file can be reported on at once
+package swift
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wolfinger/varangian/internal/config"
+)
+
+type mtType struct {
+	MT535 string
+	MT536 string
+	MT950 string
+}
+
+var (
+	// MTType defines the message types parsed
+	MTType = mtType{
+		MT535: "535",
+		MT536: "536",
+		MT950: "950"}
+
+	// fieldStart matches the start of a field in the text block, e.g. :20C: or :61:
+	fieldStart = regexp.MustCompile(`^:(\d{2}[A-Z]?):`)
+)
+
+// Error is a problem with a field of a message
+type Error struct {
+	Line  int
+	Field string
+	Msg   string
+}
+
+// Error formats the problem with its line and field
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Msg)
+}
+
+// Field is a field of a message's text block. values of more than one line are joined with newlines
+type Field struct {
+	Tag  string
+	Val  string
+	Line int
+}
+
+// Qual splits the value of a qualified field (e.g., :97A::SAFE//12345) into its qualifier and the rest
+func (f *Field) Qual() (string, string) {
+	if !strings.HasPrefix(f.Val, ":") {
+		return "", f.Val
+	}
+	val := f.Val[1:]
+	if i := strings.Index(val, "/"); i >= 0 {
+		return val[:i], strings.TrimLeft(val[i:], "/")
+	}
+	return val, ""
+}
+
+// Message is an MT message: its type from the application header, and the fields of its text block
+type Message struct {
+	Type   string
+	Line   int
+	Fields []*Field
+}
+
+// Parse parses the messages in a file, one after another. each message needs an application header
+// ({2:...}) and a text block ({4:...-}); other blocks are skipped
+func Parse(r io.Reader) ([]*Message, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading swift: %w", err)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	var msgs []*Message
+	for pos := 0; ; {
+		start := strings.Index(text[pos:], "{1:")
+		if start < 0 {
+			break
+		}
+		start += pos
+		line := 1 + strings.Count(text[:start], "\n")
+
+		m := &Message{Line: line}
+		appHdr := strings.Index(text[start:], "{2:")
+		block := strings.Index(text[start:], "{4:")
+		if appHdr < 0 || block < 0 || appHdr > block {
+			return nil, fmt.Errorf("line %d: message has no application header or text block", line)
+		}
+		hdr := text[start+appHdr+3:]
+		if len(hdr) < 4 || (hdr[0] != 'I' && hdr[0] != 'O') {
+			return nil, fmt.Errorf("line %d: invalid application header", line)
+		}
+		m.Type = hdr[1:4]
+
+		block += start + 3
+		end := strings.Index(text[block:], "\n-}")
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: text block isn't terminated with -}", line)
+		}
+		end += block
+		if err = m.parseFields(text[block:end], 1+strings.Count(text[:block], "\n")); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+		pos = end + 3
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("swift has no messages")
+	}
+
+	return msgs, nil
+}
+
+// parseFields splits a text block into its fields, starting at a line of the file
+func (m *Message) parseFields(block string, line int) error {
+	var cur *Field
+	for i, text := range strings.Split(block, "\n") {
+		if i == 0 && strings.TrimSpace(text) == "" {
+			continue
+		}
+		if match := fieldStart.FindStringSubmatch(text); match != nil {
+			cur = &Field{Tag: match[1], Val: text[len(match[0]):], Line: line + i}
+			m.Fields = append(m.Fields, cur)
+			continue
+		}
+		if cur == nil {
+			return fmt.Errorf("line %d: text block doesn't start with a field", line+i)
+		}
+		cur.Val += "\n" + text
+	}
+
+	return nil
+}
+
+// Get gets the first field with a tag, or nil if the message doesn't have one
+func (m *Message) Get(tag string) *Field {
+	return get(m.Fields, tag, "")
+}
+
+// get gets the first of a set of fields with a tag and, if one is given, a qualifier
+func get(fields []*Field, tag string, qual string) *Field {
+	for _, f := range fields {
+		if f.Tag != tag {
+			continue
+		}
+		if q, _ := f.Qual(); qual == "" || q == qual {
+			return f
+		}
+	}
+	return nil
+}
+
+// seqs finds the sequences of fields between :16R:name and the matching :16S:name, including nested ones
+func seqs(fields []*Field, name string) [][]*Field {
+	var found [][]*Field
+	start, depth := -1, 0
+	for i, f := range fields {
+		switch {
+		case f.Tag == "16R" && f.Val == name:
+			if depth == 0 {
+				start = i + 1
+			}
+			depth++
+		case f.Tag == "16S" && f.Val == name && depth > 0:
+			depth--
+			if depth == 0 {
+				found = append(found, fields[start:i])
+			}
+		}
+	}
+
+	return found
+}
+
+// errs collects the problems with a message
+type errs []*Error
+
+// fail records a problem with a field
+func (e *errs) fail(f *Field, format string, args ...interface{}) {
+	*e = append(*e, &Error{Line: f.Line, Field: ":" + f.Tag + ":", Msg: fmt.Sprintf(format, args...)})
+}
+
+// failMsg records a problem with a message as a whole, such as a missing field
+func (e *errs) failMsg(m *Message, field string, format string, args ...interface{}) {
+	*e = append(*e, &Error{Line: m.Line, Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// amount parses a swift amount, which uses a comma as the decimal point (e.g., 1234,56)
+func amount(val string) (float64, error) {
+	neg := strings.HasPrefix(val, "N")
+	num, err := strconv.ParseFloat(strings.Replace(strings.TrimPrefix(val, "N"), ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", val)
+	}
+	if neg {
+		num = -num
+	}
+	return num, nil
+}
+
+// date parses a swift date in the given layout to an api date
+func date(layout string, val string) (string, error) {
+	if len(val) < len(layout) {
+		return "", fmt.Errorf("invalid date %q", val)
+	}
+	dt, err := time.Parse(layout, val[:len(layout)])
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q", val)
+	}
+	return dt.Format(config.APIFormats.DateFmt), nil
+}
+
+// dtField gets a date field with a qualifier, either a date (:98A:) or a date and time (:98C:)
+func dtField(fields []*Field, qual string) *Field {
+	if f := get(fields, "98A", qual); f != nil {
+		return f
+	}
+	return get(fields, "98C", qual)
+}
+
+// dateField parses a qualified date field (:98A::STAT//20210301 or :98C::STAT//20210301120000)
+func (e *errs) dateField(f *Field) string {
+	if f == nil {
+		return ""
+	}
+	_, val := f.Qual()
+	dt, err := date("20060102", val)
+	if err != nil {
+		e.fail(f, "%v", err)
+	}
+	return dt
+}
+
+// SecID is the identification of a financial instrument (:35B:), by isin or, for a national code, by
+// cusip when the code is a us one
+type SecID struct {
+	ID   string
+	Type string
+	Desc string
+}
+
+// secID parses the identification of a financial instrument: ISIN US0378331005 or /US/037833100, followed
+// by lines of description
+func (e *errs) secID(f *Field) SecID {
+	lines := strings.SplitN(f.Val, "\n", 2)
+	id := SecID{}
+	if len(lines) > 1 {
+		id.Desc = strings.Join(strings.Fields(lines[1]), " ")
+	}
+	switch first := strings.TrimSpace(lines[0]); {
+	case strings.HasPrefix(first, "ISIN "):
+		id.ID, id.Type = strings.TrimSpace(first[5:]), "isin"
+	case strings.HasPrefix(first, "/US/"):
+		id.ID, id.Type = first[4:], "cusip"
+	default:
+		e.fail(f, "only isin and us national codes are supported, got %q", first)
+	}
+
+	return id
+}
+
+// safe gets the safekeeping account of a sequence (:97A::SAFE//)
+func safe(fields []*Field) string {
+	if f := get(fields, "97A", "SAFE"); f != nil {
+		_, acct := f.Qual()
+		return acct
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+package swift
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMT535 = `{1:F01CUSTUS33AXXX0000000000}{2:O5351200210301BANKUS33XXXX00000000002103011200N}{4:
+:16R:GENL
+:28E:1/ONLY
+:20C::SEME//STMT001
+:23G:NEWM
+:98A::STAT//20210301
+:22F::STTY//CUST
+:97A::SAFE//12345
+:17B::ACTI//Y
+:16S:GENL
+:16R:SUBSAFE
+:16R:FIN
+:35B:ISIN US0378331005
+APPLE INC
+:93B::AGGR//UNIT/1500,
+:16S:FIN
+:16R:FIN
+:35B:/US/594918104
+MICROSOFT CORP
+:93B::AGGR//FAMT/N25,5
+:16S:FIN
+:16R:FIN
+:35B:SEDOL 2046251
+:93B::AGGR//SHRS/10,
+:16S:FIN
+:16S:SUBSAFE
+-}`
+
+const testMT536 = `{1:F01CUSTUS33AXXX0000000000}{2:O5361200210301BANKUS33XXXX00000000002103011200N}{4:
+:16R:GENL
+:28E:1/ONLY
+:20C::SEME//STMT002
+:23G:NEWM
+:69A::STAT//20210301/20210301
+:97A::SAFE//12345
+:16S:GENL
+:16R:SUBSAFE
+:16R:FIN
+:35B:ISIN US0378331005
+:16R:TRAN
+:16R:LINK
+:20C::RELA//TRADE1
+:16S:LINK
+:16R:TRANSDET
+:36B::PSTA//UNIT/100,
+:19A::PSTA//USD12050,
+:22F::TRAN//SETT
+:22H::REDE//RECE
+:22H::PAYM//APMT
+:98A::ESET//20210303
+:98A::TRAD//20210301
+:16S:TRANSDET
+:16S:TRAN
+:16R:TRAN
+:16R:LINK
+:20C::RELA//XFER1
+:16S:LINK
+:16R:TRANSDET
+:36B::PSTA//UNIT/5,
+:22H::REDE//DELI
+:22H::PAYM//FREE
+:98A::ESET//20210304
+:16S:TRANSDET
+:16S:TRAN
+:16R:TRAN
+:16R:TRANSDET
+:36B::PSTA//UNIT/abc
+:22H::REDE//RECE
+:22H::PAYM//APMT
+:98A::ESET//20210399
+:16S:TRANSDET
+:16S:TRAN
+:16S:FIN
+:16S:SUBSAFE
+-}`
+
+const testMT950 = `{1:F01CUSTUS33AXXX0000000000}{2:O9501200210301BANKUS33XXXX00000000002103011200N}{4:
+:20:CASH001
+:25:12345
+:28C:1/1
+:60F:C210301USD1000,00
+:61:2103010301D12051,00NTRFTRADE1//B1
+AAPL PURCHASE
+:61:210302C20,50NDIVNONREF//DIV99
+:61:210302X10,00NMSCREF
+:62F:C210302USD-11030,50
+-}`
+
+func TestParse(t *testing.T) {
+	msgs, err := Parse(strings.NewReader(testMT535 + "\r\n$\r\n" + testMT950))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Type != MTType.MT535 || msgs[1].Type != MTType.MT950 {
+		t.Fatalf("Parse incorrect, got %d messages", len(msgs))
+	}
+	if f := msgs[0].Get("35B"); f == nil || f.Val != "ISIN US0378331005\nAPPLE INC" || f.Line != 13 {
+		t.Errorf("Parse fields incorrect, got: %+v", f)
+	}
+	if msgs[1].Line != 29 {
+		t.Errorf("Parse second message line incorrect, got: %d", msgs[1].Line)
+	}
+
+	for _, data := range []string{"", "{1:F01}{4:\n:20:X\n-}", "{1:F01}{2:O950}{4:\n:20:X\n"} {
+		if _, err := Parse(strings.NewReader(data)); err == nil {
+			t.Errorf("Parse(%q) expected an error", data)
+		}
+	}
+}
+
+func TestParseMT535(t *testing.T) {
+	msgs, err := Parse(strings.NewReader(testMT535))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	holdings, errs := ParseMT535(msgs[0])
+	if len(holdings) != 3 {
+		t.Fatalf("ParseMT535 found %d holdings, want: 3", len(holdings))
+	}
+	if h := holdings[0]; h.ID != "US0378331005" || h.Type != "isin" || h.Desc != "APPLE INC" || h.Acct != "12345" ||
+		h.Dt != "2021-03-01" || h.Qty != 1500 {
+		t.Errorf("ParseMT535 isin holding incorrect, got: %+v", h)
+	}
+	if h := holdings[1]; h.ID != "594918104" || h.Type != "cusip" || h.Qty != -25.5 {
+		t.Errorf("ParseMT535 cusip holding incorrect, got: %+v", h)
+	}
+	if len(errs) != 2 || errs[0].Field != ":35B:" || errs[1].Field != ":93B:" || errs[1].Line != 24 {
+		t.Errorf("ParseMT535 errors incorrect, got: %v", errs)
+	}
+}
+
+func TestParseMT536(t *testing.T) {
+	msgs, err := Parse(strings.NewReader(testMT536))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	movements, errs := ParseMT536(msgs[0])
+	if len(movements) != 3 {
+		t.Fatalf("ParseMT536 found %d movements, want: 3", len(movements))
+	}
+	if mv := movements[0]; mv.Ref != "TRADE1" || !mv.Receive || mv.Free || mv.Qty != 100 || mv.Ccy != "USD" ||
+		mv.Amt != 12050 || mv.TradeDt != "2021-03-01" || mv.SettleDt != "2021-03-03" || mv.Acct != "12345" {
+		t.Errorf("ParseMT536 purchase incorrect, got: %+v", mv)
+	}
+	if mv := movements[1]; mv.Ref != "XFER1" || mv.Receive || !mv.Free || mv.Qty != 5 || mv.TradeDt != "2021-03-04" {
+		t.Errorf("ParseMT536 delivery incorrect, got: %+v", mv)
+	}
+
+	if len(errs) != 0 || len(movements[0].Errs) != 0 || len(movements[1].Errs) != 0 {
+		t.Errorf("ParseMT536 errors incorrect, got: %v", errs)
+	}
+
+	// the last transaction has no reference, amount, or valid quantity and date
+	if mv := movements[2]; mv.Line != 38 || len(mv.Errs) != 4 {
+		t.Errorf("ParseMT536 invalid transaction incorrect, got line %d and errors: %v", mv.Line, mv.Errs)
+	}
+}
+
+func TestParseMT950(t *testing.T) {
+	msgs, err := Parse(strings.NewReader(testMT950))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	lines, errs := ParseMT950(msgs[0])
+	if len(lines) != 2 {
+		t.Fatalf("ParseMT950 found %d lines, want: 2", len(lines))
+	}
+	if l := lines[0]; l.Acct != "12345" || l.Ccy != "USD" || l.Dt != "2021-03-01" || l.Amt != -12051 || l.Ref != "TRADE1" ||
+		l.Memo != "AAPL PURCHASE" {
+		t.Errorf("ParseMT950 debit incorrect, got: %+v", l)
+	}
+	if l := lines[1]; l.Amt != 20.5 || l.Ref != "DIV99" || l.Memo != "NDIV" {
+		t.Errorf("ParseMT950 credit incorrect, got: %+v", l)
+	}
+	if len(errs) != 1 || errs[0].Field != ":61:" || errs[0].Line != 9 {
+		t.Errorf("ParseMT950 errors incorrect, got: %v", errs)
+	}
+}
@@ -0,0 +1,183 @@
+package valuation
+
+import (
+	"context"
+	"sort"
+
+	"github.com/wolfinger/varangian/generated/storage"
+)
+
+// Hist is the price and fx rate history of a set of instruments between two dates, used to value them on
+// every date in the range without going back to the price and fx rate stores for each one
+type Hist struct {
+	baseCcyID   string
+	prices      map[string][]*storage.Price
+	proxyPrices map[string][]*storage.Price
+	fxRates     map[fxPair][]*storage.FxRate
+}
+
+// fxPair is the base and quote currency of a stored fx rate
+type fxPair struct {
+	base  string
+	quote string
+}
+
+// NewHist creates a new Hist from prices and fx rates in date order. fx rates that aren't stored directly
+// are triangulated through baseCcyID
+func NewHist(prices []*storage.Price, fxRates []*storage.FxRate, baseCcyID string) *Hist {
+	h := &Hist{
+		baseCcyID:   baseCcyID,
+		prices:      make(map[string][]*storage.Price),
+		proxyPrices: make(map[string][]*storage.Price),
+		fxRates:     make(map[fxPair][]*storage.FxRate),
+	}
+	for _, price := range prices {
+		if price.GetProxyInstId() != "" {
+			h.proxyPrices[price.GetInstId()] = append(h.proxyPrices[price.GetInstId()], price)
+		} else {
+			h.prices[price.GetInstId()] = append(h.prices[price.GetInstId()], price)
+		}
+	}
+	for _, fxRate := range fxRates {
+		pair := fxPair{fxRate.GetBaseCcyId(), fxRate.GetQuoteCcyId()}
+		h.fxRates[pair] = append(h.fxRates[pair], fxRate)
+	}
+
+	return h
+}
+
+// LoadHist loads the price and fx rate history needed to value a set of instruments in the reporting
+// currency between two dates (inclusive)
+func (v *Valuer) LoadHist(ctx context.Context, instIDs []string, startDt string, endDt string, rptCcyID string) (*Hist, error) {
+	var ids []string
+	flags := make(map[string]bool)
+	add := func(id string) {
+		if id != "" && !flags[id] {
+			flags[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, instID := range instIDs {
+		add(instID)
+	}
+
+	prices, err := v.priceStore.ListPriceHist(ctx, ids, startDt, endDt)
+	if err != nil {
+		return nil, err
+	}
+
+	// rates are needed between the reporting currency and any currency the instruments are priced in (or
+	// the instruments themselves if they're currencies), directly or through the base currency
+	for _, price := range prices {
+		add(price.GetCcyId())
+	}
+	add(rptCcyID)
+	add(v.baseCcyID)
+	fxRates, err := v.fxStore.ListFxRateHist(ctx, ids, startDt, endDt)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHist(prices, fxRates, v.baseCcyID), nil
+}
+
+// Price gets the latest price of an instrument on or before a date, falling back to its proxy's price, or
+// nil if neither has a price
+func (h *Hist) Price(instID string, dt string) *storage.Price {
+	if price := asOfPrice(h.prices[instID], dt); price != nil {
+		return price
+	}
+
+	return asOfPrice(h.proxyPrices[instID], dt)
+}
+
+// asOfPrice finds the latest of a set of prices in date order on or before a date
+func asOfPrice(prices []*storage.Price, dt string) *storage.Price {
+	i := sort.Search(len(prices), func(i int) bool { return prices[i].GetPriceDt() > dt })
+	if i == 0 {
+		return nil
+	}
+
+	return prices[i-1]
+}
+
+// FxRate gets the rate to convert one unit of the from currency into the to currency on a date, returning
+// false if no rate exists. the stored rate is used directly or inverted, otherwise the rate is triangulated
+// through the base currency
+func (h *Hist) FxRate(fromID string, toID string, dt string) (float64, bool) {
+	if fromID == toID {
+		return 1, true
+	}
+
+	if rate := h.directRate(fromID, toID, dt); rate != 0 {
+		return rate, true
+	}
+
+	viaID := h.baseCcyID
+	if viaID != "" && viaID != fromID && viaID != toID {
+		fromRate := h.directRate(fromID, viaID, dt)
+		toRate := h.directRate(viaID, toID, dt)
+		if fromRate != 0 && toRate != 0 {
+			return fromRate * toRate, true
+		}
+	}
+
+	return 0, false
+}
+
+// directRate finds the latest stored rate on or before a date between two currencies (inverting it if stored
+// the other way around), returning a zero rate if none exists
+func (h *Hist) directRate(fromID string, toID string, dt string) float64 {
+	rate, rateDt := asOfRate(h.fxRates[fxPair{fromID, toID}], dt)
+	inv, invDt := asOfRate(h.fxRates[fxPair{toID, fromID}], dt)
+	if inv != 0 && (rate == 0 || invDt > rateDt) {
+		return 1 / inv
+	}
+
+	return rate
+}
+
+// asOfRate finds the latest of a set of fx rates in date order on or before a date
+func asOfRate(fxRates []*storage.FxRate, dt string) (float64, string) {
+	i := sort.Search(len(fxRates), func(i int) bool { return fxRates[i].GetRateDt() > dt })
+	if i == 0 {
+		return 0, ""
+	}
+
+	return fxRates[i-1].GetRate(), fxRates[i-1].GetRateDt()
+}
+
+// ValueInst values a size of an instrument on a date in the reporting currency, returning false if the
+// instrument couldn't be priced
+func (h *Hist) ValueInst(dt string, rptCcyID string, instID string, size float64) (float64, bool) {
+	px, ccyID := instPx(instID, h.Price(instID, dt))
+
+	rate := 1.0
+	if ccyID != "" && ccyID != rptCcyID {
+		var ok bool
+		rate, ok = h.FxRate(ccyID, rptCcyID, dt)
+		if !ok {
+			return 0, false
+		}
+	}
+
+	return size * px * rate, true
+}
+
+// ValueLots values a set of lot balances on a date in the reporting currency for lots that have already
+// been looked up
+func (h *Hist) ValueLots(dt string, rptCcyID string, lots []*storage.Lot, lotBals []*storage.LotBal) []*Val {
+	lotMap := make(map[string]*storage.Lot)
+	for _, lot := range lots {
+		lotMap[lot.GetId()] = lot
+	}
+
+	priceOf := func(instID string) *storage.Price {
+		return h.Price(instID, dt)
+	}
+	fxRate := func(ccyID string) (float64, bool) {
+		return h.FxRate(ccyID, rptCcyID, dt)
+	}
+
+	return valueLots(rptCcyID, lotMap, lotBals, priceOf, fxRate)
+}
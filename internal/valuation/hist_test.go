@@ -0,0 +1,121 @@
+package valuation
+
+import (
+	"math"
+	"testing"
+
+	"github.com/wolfinger/varangian/generated/storage"
+)
+
+func testHist() *Hist {
+	prices := []*storage.Price{
+		{InstId: "inst_a", PriceDt: "2021-01-04", Close: 10, CcyId: "inst_eur"},
+		{InstId: "inst_a", PriceDt: "2021-01-06", Mid: 12, CcyId: "inst_eur"},
+		{InstId: "inst_b", PriceDt: "2021-01-05", Close: 50, CcyId: "inst_usd", ProxyInstId: "inst_c"},
+		{InstId: "inst_b", PriceDt: "2021-01-07", Close: 20, CcyId: "inst_gbp"},
+	}
+	fxRates := []*storage.FxRate{
+		{BaseCcyId: "inst_eur", QuoteCcyId: "inst_usd", RateDt: "2021-01-04", Rate: 1.2},
+		{BaseCcyId: "inst_usd", QuoteCcyId: "inst_eur", RateDt: "2021-01-06", Rate: 0.8},
+		{BaseCcyId: "inst_gbp", QuoteCcyId: "inst_usd", RateDt: "2021-01-04", Rate: 1.5},
+	}
+
+	return NewHist(prices, fxRates, "inst_usd")
+}
+
+func TestHistPrice(t *testing.T) {
+	h := testHist()
+
+	tests := []struct {
+		instID string
+		dt     string
+		want   float64
+		proxy  bool
+	}{
+		{"inst_a", "2021-01-03", -1, false},
+		{"inst_a", "2021-01-04", 10, false},
+		{"inst_a", "2021-01-05", 10, false},
+		{"inst_a", "2021-01-08", 12, false},
+		{"inst_b", "2021-01-04", -1, false},
+		{"inst_b", "2021-01-06", 50, true},
+		{"inst_b", "2021-01-07", 20, false},
+	}
+	for _, test := range tests {
+		price := h.Price(test.instID, test.dt)
+		if test.want < 0 {
+			if price != nil {
+				t.Errorf("Price(%s, %s) incorrect, got: %v, want: nil", test.instID, test.dt, price)
+			}
+			continue
+		}
+		px, _ := instPx(test.instID, price)
+		if px != test.want || (price.GetProxyInstId() != "") != test.proxy {
+			t.Errorf("Price(%s, %s) incorrect, got: %f (proxy %s), want: %f", test.instID, test.dt, px, price.GetProxyInstId(), test.want)
+		}
+	}
+}
+
+func TestHistFxRate(t *testing.T) {
+	h := testHist()
+
+	tests := []struct {
+		fromID string
+		toID   string
+		dt     string
+		want   float64
+		ok     bool
+	}{
+		{"inst_eur", "inst_eur", "2021-01-01", 1, true},
+		{"inst_eur", "inst_usd", "2021-01-03", 0, false},
+		{"inst_eur", "inst_usd", "2021-01-05", 1.2, true},
+		{"inst_usd", "inst_eur", "2021-01-05", 1 / 1.2, true},
+		{"inst_eur", "inst_usd", "2021-01-06", 1 / 0.8, true},
+		{"inst_gbp", "inst_eur", "2021-01-06", 1.5 * 0.8, true},
+		{"inst_eur", "inst_gbp", "2021-01-05", 1.2 / 1.5, true},
+		{"inst_eur", "inst_jpy", "2021-01-05", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := h.FxRate(test.fromID, test.toID, test.dt)
+		if ok != test.ok || math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("FxRate(%s, %s, %s) incorrect, got: %f, %t, want: %f, %t", test.fromID, test.toID, test.dt, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestHistValueLots(t *testing.T) {
+	h := testHist()
+
+	lots := []*storage.Lot{
+		{Id: "lot_a", InstId: "inst_a"},
+		{Id: "lot_b", InstId: "inst_b"},
+		{Id: "lot_eur", InstId: "inst_eur"},
+		{Id: "lot_x", InstId: "inst_x"},
+	}
+	lotBals := []*storage.LotBal{
+		{LotId: "lot_a", LotSize: 2},
+		{LotId: "lot_b", LotSize: 3},
+		{LotId: "lot_eur", LotSize: 100},
+		{LotId: "lot_x", LotSize: 1},
+	}
+
+	vals := h.ValueLots("2021-01-07", "inst_usd", lots, lotBals)
+	wants := []struct {
+		priced bool
+		rptVal float64
+	}{
+		{true, 2 * 12 / 0.8},
+		{true, 3 * 20 * 1.5},
+		{true, 100 / 0.8},
+		{false, 0},
+	}
+	for i, want := range wants {
+		if vals[i].Priced != want.priced || math.Abs(vals[i].RptMktVal-want.rptVal) > 1e-9 {
+			t.Errorf("ValueLots %s incorrect, got: %t, %f, want: %t, %f", lotBals[i].GetLotId(), vals[i].Priced, vals[i].RptMktVal, want.priced, want.rptVal)
+		}
+	}
+
+	got, ok := h.ValueInst("2021-01-05", "inst_usd", "inst_a", 2)
+	if !ok || math.Abs(got-2*10*1.2) > 1e-12 {
+		t.Errorf("ValueInst incorrect, got: %f, %t, want: %f, true", got, ok, 2*10*1.2)
+	}
+}
@@ -0,0 +1,255 @@
+// Package valuation values lot balances in a reporting currency from the price and fx rate stores
+package valuation
+
+import (
+	"context"
+
+	fxStore "github.com/wolfinger/varangian/fx/store"
+	"github.com/wolfinger/varangian/generated/storage"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	priceStore "github.com/wolfinger/varangian/price/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Val is the valuation of a single lot balance on a date
+type Val struct {
+	Lot    *storage.Lot
+	LotBal *storage.LotBal
+	// Priced is false if no price (or fx rate) could be found for the lot's instrument
+	Priced bool
+	// Px is the price of the instrument in CcyID, the currency the instrument is priced in
+	Px    float64
+	CcyID string
+	// FxRate converts one unit of CcyID into the reporting currency
+	FxRate      float64
+	LocalMktVal float64
+	RptMktVal   float64
+}
+
+// Valuer values lot balances using the Lot, Price, and FX Rate stores
+type Valuer struct {
+	lotStore   lotStore.Store
+	priceStore priceStore.Store
+	fxStore    fxStore.Store
+	baseCcyID  string
+}
+
+// NewValuer creates a new Valuer. fx rates that aren't stored directly are triangulated through baseCcyID
+func NewValuer(lotStore lotStore.Store, priceStore priceStore.Store, fxStore fxStore.Store, baseCcyID string) *Valuer {
+	return &Valuer{
+		lotStore:   lotStore,
+		priceStore: priceStore,
+		fxStore:    fxStore,
+		baseCcyID:  baseCcyID,
+	}
+}
+
+// BaseCcyID returns the currency fx rates are triangulated through
+func (v *Valuer) BaseCcyID() string {
+	return v.baseCcyID
+}
+
+// FxRate gets the rate to convert one unit of the from currency into the to currency on a date
+func (v *Valuer) FxRate(ctx context.Context, fromID string, toID string, dt string) (float64, error) {
+	fxRate, err := v.fxStore.GetFxRate(ctx, fromID, toID, dt, v.baseCcyID)
+	if err != nil {
+		return 0, err
+	}
+
+	return fxRate.GetRate(), nil
+}
+
+// ValueInst values a size of an instrument on a date in the reporting currency, returning false if the
+// instrument couldn't be priced
+func (v *Valuer) ValueInst(ctx context.Context, dt string, rptCcyID string, instID string, size float64) (float64, bool, error) {
+	price, err := v.priceStore.GetPrice(ctx, instID, dt, nil)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return 0, false, err
+	}
+	px, ccyID := instPx(instID, price)
+
+	rate := 1.0
+	if ccyID != "" && ccyID != rptCcyID {
+		rate, err = v.FxRate(ctx, ccyID, rptCcyID, dt)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+	}
+
+	return size * px * rate, true, nil
+}
+
+// instPx gets the price of an instrument and the currency it's priced in from its price. an instrument
+// without a price is treated as a currency holding
+func instPx(instID string, price *storage.Price) (float64, string) {
+	if price == nil {
+		return 1, instID
+	}
+	px := price.GetClose()
+	if px == 0 {
+		px = price.GetMid()
+	}
+
+	return px, price.GetCcyId()
+}
+
+// ValueLotBals values a set of lot balances on a date in the reporting currency. instruments without a
+// price are treated as currency holdings (price of 1) if an fx rate to the reporting currency exists,
+// and prices without a currency are assumed to be quoted in the reporting currency
+func (v *Valuer) ValueLotBals(ctx context.Context, dt string, rptCcyID string, lotBals []*storage.LotBal) ([]*Val, error) {
+	if len(lotBals) == 0 {
+		return nil, nil
+	}
+
+	// get the lots to find the instrument each balance is in
+	lotIDs := make([]string, len(lotBals))
+	for i, lotBal := range lotBals {
+		lotIDs[i] = lotBal.GetLotId()
+	}
+	lots, err := v.ListLots(ctx, lotIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.ValueLots(ctx, dt, rptCcyID, lots, lotBals)
+}
+
+// ValueLots values a set of lot balances on a date in the reporting currency for lots that have already
+// been looked up. if no reporting currency is passed in, only local market values are calculated
+func (v *Valuer) ValueLots(ctx context.Context, dt string, rptCcyID string, lots []*storage.Lot, lotBals []*storage.LotBal) ([]*Val, error) {
+	lotMap := make(map[string]*storage.Lot)
+	instIDs := make([]string, 0, len(lots))
+	instFlags := make(map[string]bool)
+	for _, lot := range lots {
+		lotMap[lot.GetId()] = lot
+		if lot.GetInstId() != "" && !instFlags[lot.GetInstId()] {
+			instFlags[lot.GetInstId()] = true
+			instIDs = append(instIDs, lot.GetInstId())
+		}
+	}
+
+	// get prices for all instruments in one pass
+	priceMap := make(map[string]*storage.Price)
+	if len(instIDs) > 0 {
+		prices, _, err := v.priceStore.ListPrices(ctx, 0, "", instIDs, dt, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, price := range prices {
+			priceMap[price.GetInstId()] = price
+		}
+	}
+
+	// get fx rates by currency up front since most lots share a handful of currencies
+	fxMap := make(map[string]float64)
+	if rptCcyID != "" {
+		for _, lotBal := range lotBals {
+			instID := lotMap[lotBal.GetLotId()].GetInstId()
+			if instID == "" {
+				continue
+			}
+			ccyID := instID
+			if price, ok := priceMap[instID]; ok {
+				ccyID = price.GetCcyId()
+			}
+			if _, ok := fxMap[ccyID]; ok || ccyID == "" || ccyID == rptCcyID {
+				continue
+			}
+			rate, err := v.FxRate(ctx, ccyID, rptCcyID, dt)
+			if err != nil {
+				if status.Code(err) != codes.NotFound {
+					return nil, err
+				}
+				rate = 0
+			}
+			fxMap[ccyID] = rate
+		}
+	}
+
+	priceOf := func(instID string) *storage.Price {
+		return priceMap[instID]
+	}
+	fxRate := func(ccyID string) (float64, bool) {
+		return fxMap[ccyID], fxMap[ccyID] != 0
+	}
+
+	return valueLots(rptCcyID, lotMap, lotBals, priceOf, fxRate), nil
+}
+
+// valueLots values a set of lot balances in the reporting currency from the prices and fx rates of a single
+// date. prices without a currency are assumed to be quoted in the reporting currency
+func valueLots(rptCcyID string, lotMap map[string]*storage.Lot, lotBals []*storage.LotBal, priceOf func(instID string) *storage.Price, fxRate func(ccyID string) (float64, bool)) []*Val {
+	vals := make([]*Val, len(lotBals))
+	for i, lotBal := range lotBals {
+		val := &Val{
+			Lot:    lotMap[lotBal.GetLotId()],
+			LotBal: lotBal,
+		}
+		vals[i] = val
+		if val.Lot.GetInstId() == "" {
+			continue
+		}
+
+		instID := val.Lot.GetInstId()
+		price := priceOf(instID)
+		pxFlag := price != nil
+		if pxFlag {
+			val.Px, val.CcyID = instPx(instID, price)
+			if val.CcyID == "" && rptCcyID != "" {
+				val.CcyID = rptCcyID
+			}
+			val.LocalMktVal = lotBal.GetLotSize() * val.Px
+		} else {
+			// no price so check if the instrument is itself a currency
+			val.CcyID = instID
+		}
+
+		// local values only
+		if rptCcyID == "" {
+			val.Priced = pxFlag
+			if !pxFlag {
+				val.CcyID = ""
+			}
+			continue
+		}
+
+		rate, ok := 1.0, true
+		if val.CcyID != rptCcyID {
+			rate, ok = fxRate(val.CcyID)
+		}
+		if !ok {
+			if !pxFlag {
+				val.CcyID = ""
+			}
+			continue
+		}
+		if !pxFlag {
+			val.Px = 1
+			val.LocalMktVal = lotBal.GetLotSize()
+		}
+
+		val.Priced = true
+		val.FxRate = rate
+		val.RptMktVal = val.LocalMktVal * val.FxRate
+	}
+
+	return vals
+}
+
+// ListLots lists the lots for a set of lot ids
+func (v *Valuer) ListLots(ctx context.Context, lotIDs []string) ([]*storage.Lot, error) {
+	if len(lotIDs) == 0 {
+		return nil, nil
+	}
+
+	filter := lotStore.LotFilter{
+		ID: lotIDs,
+	}
+	lots, _, err := v.lotStore.ListLots(ctx, 0, "", filter.String(), "")
+
+	return lots, err
+}
@@ -0,0 +1,47 @@
+// Package version guards updates with optimistic concurrency. every row has a version, starting at 1 and
+// going up by one with each update, and an update only goes through if the row is still at the version the
+// caller read. a caller that loses a race to update a row gets an aborted error and has to read it again
+package version
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Update updates the row of a model, picked by its primary key, as long as the row is still at the version
+// the caller read. the model has to be at the next version already. name describes the row in errors
+func Update(ctx context.Context, db orm.DB, model interface{}, version int64, name string) error {
+	if version <= 0 {
+		return status.Errorf(codes.InvalidArgument, "version required to update %s", name)
+	}
+
+	res, err := db.ModelContext(ctx, model).WherePK().Where("version = ?", version).Update()
+	if err != nil {
+		return fmt.Errorf("update %s: %w", name, err)
+	}
+	if res.RowsAffected() > 0 {
+		return nil
+	}
+
+	return Conflict(ctx, db, model, version, name)
+}
+
+// Conflict works out why an update of the row of a model, picked by its primary key, didn't go through at
+// the version the caller read: the row is either gone or has moved past that version
+func Conflict(ctx context.Context, db orm.DB, model interface{}, version int64, name string) error {
+	var current int64
+	err := db.ModelContext(ctx, model).Column("version").WherePK().Select(&current)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return status.Errorf(codes.NotFound, "%s not found", name)
+		}
+		return fmt.Errorf("getting version of %s: %w", name, err)
+	}
+
+	return status.Errorf(codes.Aborted, "%s was changed after version %d and is now at version %d, get it again and retry", name, version, current)
+}
@@ -0,0 +1,82 @@
+package version
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// row is a versioned row in a temp table
+type row struct {
+	tableName struct{} `pg:"versioned_rows"`
+
+	Id      int64
+	Name    string
+	Version int64
+}
+
+// testConn connects to the database in TEST_DB_CONN_STR, skipping the test if it isn't set. rows are kept
+// in a temp table, so the pool is kept to a single connection
+func testConn(t *testing.T) *pg.DB {
+	connStr := os.Getenv("TEST_DB_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+
+	opt, err := pg.ParseURL(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.PoolSize = 1
+
+	conn := pg.Connect(opt)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Exec(`CREATE TEMP TABLE versioned_rows (
+		id bigint PRIMARY KEY,
+		name text,
+		version bigint NOT NULL)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	conn := testConn(t)
+	if _, err := conn.ModelContext(ctx, &row{Id: 1, Name: "a", Version: 1}).Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      int64
+		version int64
+		code    codes.Code
+	}{
+		{"no version", 1, 0, codes.InvalidArgument},
+		{"current version", 1, 1, codes.OK},
+		{"stale version", 1, 1, codes.Aborted},
+		{"missing row", 2, 1, codes.NotFound},
+	}
+	for _, test := range tests {
+		err := Update(ctx, conn, &row{Id: test.id, Name: test.name, Version: test.version + 1}, test.version, "row")
+		if status.Code(err) != test.code {
+			t.Errorf("%s got: %v, want: %v", test.name, err, test.code)
+		}
+	}
+
+	got := &row{Id: 1}
+	if err := conn.ModelContext(ctx, got).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "current version" || got.Version != 2 {
+		t.Errorf("row got: %+v, want only the update at the current version", got)
+	}
+}
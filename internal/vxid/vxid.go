@@ -22,6 +22,17 @@ type pfxMap struct {
 	Instrument   string
 	Transaction  string
 	Lot          string
+	Benchmark    string
+	BmkAssign    string
+	GlAcct       string
+	Journal      string
+	Lock         string
+	LockOverride string
+	CustPos      string
+	ReconTol     string
+	Break        string
+	CustCashTxn  string
+	CashMatch    string
 }
 
 var (
@@ -35,7 +46,18 @@ var (
 		Strategy:     "str",
 		Instrument:   "inst",
 		Transaction:  "txn",
-		Lot:          "lot"}
+		Lot:          "lot",
+		Benchmark:    "bmk",
+		BmkAssign:    "bmka",
+		GlAcct:       "gla",
+		Journal:      "jrnl",
+		Lock:         "lck",
+		LockOverride: "lcko",
+		CustPos:      "cpos",
+		ReconTol:     "rtol",
+		Break:        "brk",
+		CustCashTxn:  "cctx",
+		CashMatch:    "cmat"}
 )
 
 // Encode converts a internal id (vid) to an external id (vxid)
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Service interface used for implementing the Lock service
+type Service interface {
+	v1.LockServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Lock service
+func NewService(lockStore lockStore.Store) *LockServiceImpl {
+	return &LockServiceImpl{
+		lockStore: lockStore,
+	}
+}
+
+// LockServiceImpl data structure for implementing the Lock service
+type LockServiceImpl struct {
+	lockStore lockStore.Store
+}
+
+// RegisterServer registers the Lock service server
+func (s *LockServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterLockServiceServer(server, s)
+}
+
+// RegisterHandler registers the Lock service handler
+func (s *LockServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterLockServiceHandler(ctx, mux, conn)
+}
+
+// GetLock gets a lock from the Lock service
+func (s *LockServiceImpl) GetLock(ctx context.Context, request *v1.GetLockRequest) (*v1.GetLockResponse, error) {
+	lock, err := s.lockStore.GetLock(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetLockResponse{
+		Lock: lock,
+	}, nil
+}
+
+// ListLocks lists lock dates from the Lock service
+func (s *LockServiceImpl) ListLocks(ctx context.Context, request *v1.ListLocksRequest) (*v1.ListLocksResponse, error) {
+	locks, nextPageToken, err := s.lockStore.ListLocks(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListLocksResponse{
+		Locks:         locks,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// SetLock sets the lock date of a legal entity or account via the Lock service
+func (s *LockServiceImpl) SetLock(ctx context.Context, request *v1.SetLockRequest) (*v1.SetLockResponse, error) {
+	lock := request.GetLock()
+	if lock == nil {
+		return nil, status.Error(codes.InvalidArgument, "lock required in POST")
+	}
+	if lock.GetId() != "" {
+		return nil, status.Error(codes.InvalidArgument, "lock id is not expected in POST")
+	}
+	if (lock.GetLeOrgId() == "") == (lock.GetAcctId() == "") {
+		return nil, status.Error(codes.InvalidArgument, "lock expects exactly one of le_org_id or acct_id")
+	}
+	if _, err := time.Parse(config.APIFormats.DateFmt, lock.GetLockDt()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid lock date %s", lock.GetLockDt())
+	}
+
+	lock, err := s.lockStore.SetLock(ctx, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.SetLockResponse{
+		Lock: lock,
+	}, nil
+}
+
+// DeleteLock removes a lock from the Lock service
+func (s *LockServiceImpl) DeleteLock(ctx context.Context, request *v1.DeleteLockRequest) (*v1.DeleteLockResponse, error) {
+	if err := s.lockStore.DeleteLock(ctx, request.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteLockResponse{}, nil
+}
+
+// ListLockOverrides lists the recorded lock overrides from the Lock service
+func (s *LockServiceImpl) ListLockOverrides(ctx context.Context, request *v1.ListLockOverridesRequest) (*v1.ListLockOverridesResponse, error) {
+	lockOverrides, nextPageToken, err := s.lockStore.ListLockOverrides(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetLockId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListLockOverridesResponse{
+		LockOverrides: lockOverrides,
+		NextPageToken: nextPageToken,
+	}, nil
+}
@@ -0,0 +1,259 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/dbtx"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store interface used for implementing the Lock store
+type Store interface {
+	GetLock(ctx context.Context, id string) (*storage.Lock, error)
+	ListLocks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Lock, string, error)
+	SetLock(ctx context.Context, lock *storage.Lock) (*storage.Lock, error)
+	DeleteLock(ctx context.Context, id string) error
+	CreateLockOverride(ctx context.Context, lockOverride *storage.LockOverride) (*storage.LockOverride, error)
+	ListLockOverrides(ctx context.Context, pageSize int32, pageToken string, lockID string) ([]*storage.LockOverride, string, error)
+	WithTx(tx *pg.Tx) Store
+}
+
+// NewStore encapsulates Lock database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn dbtx.Conn
+}
+
+// WithTx gets a copy of the store bound to a database transaction, which its changes commit with
+func (s *storeImpl) WithTx(tx *pg.Tx) Store {
+	return &storeImpl{conn: dbtx.Shared(tx)}
+}
+
+var (
+	// lockKeys are the keys locks are listed in order of
+	lockKeys = []page.Key{{Col: "le_org_id"}, {Col: "acct_id"}, {Col: "id"}}
+
+	// lockOverrideKeys are the keys lock overrides are listed in order of
+	lockOverrideKeys = []page.Key{{Col: "created_at"}, {Col: "id"}}
+)
+
+// encodeLock converts the vids of a lock to vxids
+func encodeLock(lock *storage.Lock) error {
+	var err error
+	lock.Id, err = vxid.Encode(lock.GetId(), vxid.PfxMap.Lock)
+	if err != nil {
+		return err
+	}
+	lock.LeOrgId, err = vxid.Encode(lock.GetLeOrgId(), vxid.PfxMap.Organization)
+	if err != nil {
+		return err
+	}
+	lock.AcctId, err = vxid.Encode(lock.GetAcctId(), vxid.PfxMap.Account)
+
+	return err
+}
+
+// GetLock gets a lock from the Lock store
+func (s *storeImpl) GetLock(ctx context.Context, id string) (*storage.Lock, error) {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock storage.Lock
+	err = s.conn.ModelContext(ctx, &lock).ColumnExpr("*, lock_dt::date").Where("id = ?", vid).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "lock with id %s not found", id)
+		}
+		return nil, err
+	}
+
+	// convert vids to vxids
+	if err = encodeLock(&lock); err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// ListLocks lists a page of lock dates from the Lock store in order of legal entity and account. a page size of
+// 0 lists every lock
+func (s *storeImpl) ListLocks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Lock, string, error) {
+	var locks []*storage.Lock
+	p := page.NewPager(pageSize, lockKeys, "")
+	q, err := p.Apply(s.conn.ModelContext(ctx, &locks).ColumnExpr("*, lock_dt::date"), pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing locks: %w", err)
+	}
+
+	// the next page starts after the last lock of this one
+	var nextPageToken string
+	n, more := p.Next(len(locks))
+	locks = locks[:n]
+	if more {
+		nextPageToken = p.Token(locks[n-1].GetLeOrgId(), locks[n-1].GetAcctId(), locks[n-1].GetId())
+	}
+
+	for _, lock := range locks {
+		// convert vids to vxids
+		if err = encodeLock(lock); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return locks, nextPageToken, nil
+}
+
+// SetLock sets the lock date of a legal entity or account via the Lock store. each legal entity and
+// account has a single lock, so setting it again moves the lock date
+func (s *storeImpl) SetLock(ctx context.Context, lock *storage.Lock) (*storage.Lock, error) {
+	var err error
+
+	// convert vxids to vids
+	vLock := &storage.Lock{
+		LockDt: lock.GetLockDt(),
+	}
+	vLock.LeOrgId, err = vxid.Decode(lock.GetLeOrgId())
+	if err != nil {
+		return nil, err
+	}
+	vLock.AcctId, err = vxid.Decode(lock.GetAcctId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		q := tx.ModelContext(ctx, (*storage.Lock)(nil)).Column("id")
+		if vLock.GetLeOrgId() != "" {
+			q.Where("le_org_id = ?", vLock.GetLeOrgId())
+		} else {
+			q.Where("acct_id = ?", vLock.GetAcctId())
+		}
+		var id string
+		err := q.Select(pg.Scan(&id))
+		if err != nil && err != pg.ErrNoRows {
+			return err
+		}
+
+		// move the existing lock, otherwise add one
+		if id != "" {
+			vLock.Id = id
+			_, err = tx.ModelContext(ctx, vLock).Column("lock_dt").WherePK().Update()
+			return err
+		}
+		_, err = tx.ModelContext(ctx, vLock).Insert()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("setting lock: %w", err)
+	}
+
+	// convert vids to vxids
+	if err = encodeLock(vLock); err != nil {
+		return nil, err
+	}
+
+	return vLock, nil
+}
+
+// DeleteLock removes a lock from the Lock store
+func (s *storeImpl) DeleteLock(ctx context.Context, id string) error {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.Lock)(nil)).Where("id = ?", vid).Delete(); err != nil {
+		return fmt.Errorf("deleting lock %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// CreateLockOverride records a change made on or before a lock date via the Lock store
+func (s *storeImpl) CreateLockOverride(ctx context.Context, lockOverride *storage.LockOverride) (*storage.LockOverride, error) {
+	var err error
+
+	// convert vxids to vids
+	xLockID := lockOverride.GetLockId()
+	lockOverride.LockId, err = vxid.Decode(lockOverride.GetLockId())
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.conn.ModelContext(ctx, lockOverride).Value("created_at", "now()").Insert()
+	if err != nil {
+		return nil, fmt.Errorf("recording lock override for lock %s: %w", xLockID, err)
+	}
+
+	// convert vids to vxids
+	lockOverride.Id, err = vxid.Encode(lockOverride.GetId(), vxid.PfxMap.LockOverride)
+	if err != nil {
+		return nil, err
+	}
+	lockOverride.LockId = xLockID
+
+	return lockOverride, nil
+}
+
+// ListLockOverrides lists a page of the recorded lock overrides, optionally for a single lock, from the Lock
+// store in the order they were recorded. a page size of 0 lists every override
+func (s *storeImpl) ListLockOverrides(ctx context.Context, pageSize int32, pageToken string, lockID string) ([]*storage.LockOverride, string, error) {
+	var lockOverrides []*storage.LockOverride
+
+	q := s.conn.ModelContext(ctx, &lockOverrides)
+	if lockID != "" {
+		vid, err := vxid.Decode(lockID)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("lock_id = ?", vid)
+	}
+	p := page.NewPager(pageSize, lockOverrideKeys, page.Query(lockID))
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing lock overrides: %w", err)
+	}
+
+	// the next page starts after the last override of this one
+	var nextPageToken string
+	n, more := p.Next(len(lockOverrides))
+	lockOverrides = lockOverrides[:n]
+	if more {
+		nextPageToken = p.Token(lockOverrides[n-1].GetCreatedAt(), lockOverrides[n-1].GetId())
+	}
+
+	for _, lockOverride := range lockOverrides {
+		// convert vids to vxids
+		lockOverride.Id, err = vxid.Encode(lockOverride.GetId(), vxid.PfxMap.LockOverride)
+		if err != nil {
+			return nil, "", err
+		}
+		lockOverride.LockId, err = vxid.Encode(lockOverride.GetLockId(), vxid.PfxMap.Lock)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return lockOverrides, nextPageToken, nil
+}
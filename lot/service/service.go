@@ -2,11 +2,18 @@ package service
 
 import (
 	"context"
+	"strconv"
 	"time"
 
+	"github.com/go-pg/pg/v10"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	changeStore "github.com/wolfinger/varangian/change/store"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
 	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/feed"
+	"github.com/wolfinger/varangian/internal/guard"
+	"github.com/wolfinger/varangian/internal/page"
 	lotStore "github.com/wolfinger/varangian/lot/store"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	"google.golang.org/grpc"
@@ -21,15 +28,19 @@ type Service interface {
 }
 
 // NewService creates new Lot service
-func NewService(lotStore lotStore.Store) *LotServiceImpl {
+func NewService(lotStore lotStore.Store, guard *guard.Guard, feed *feed.Feed) *LotServiceImpl {
 	return &LotServiceImpl{
 		lotStore: lotStore,
+		guard:    guard,
+		feed:     feed,
 	}
 }
 
 // LotServiceImpl data structure for the implementing the Lot service
 type LotServiceImpl struct {
 	lotStore lotStore.Store
+	guard    *guard.Guard
+	feed     *feed.Feed
 }
 
 // RegisterServer registers the Lot service server
@@ -56,26 +67,55 @@ func (s *LotServiceImpl) GetLot(ctx context.Context, request *v1.GetLotRequest)
 
 // ListLots lists an array of lots from the Lot service
 func (s *LotServiceImpl) ListLots(ctx context.Context, request *v1.ListLotsRequest) (*v1.ListLotsResponse, error) {
-	lots, err := s.lotStore.ListLots(ctx, request.GetMaxPageSize(), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
+	lots, nextPageToken, err := s.lotStore.ListLots(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 
 	return &v1.ListLotsResponse{
-		Lots: lots,
+		Lots:          lots,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
+// WatchLotBals streams the changes to lot balances from the Lot service as they're made, after the cursor
+// passed in. each change comes with the lot balance as it is when it's sent, or without one if it's been
+// deleted
+func (s *LotServiceImpl) WatchLotBals(request *v1.WatchLotBalsRequest, stream v1.LotService_WatchLotBalsServer) error {
+	ctx := stream.Context()
+	return s.feed.Watch(ctx, changeStore.LotBal, request.GetCursor(), func(change *storage.Change) error {
+		resp := &v1.WatchLotBalsResponse{
+			Cursor: strconv.FormatInt(change.GetId(), 10),
+			Op:     change.GetOp(),
+			LotId:  change.GetRefId(),
+			LotDt:  change.GetRefDt(),
+		}
+		if change.GetOp() != changeStore.Delete {
+			lotBal, err := s.lotStore.GetLotBal(ctx, change.GetRefId(), change.GetRefDt())
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			resp.LotBal = lotBal
+		}
+		return stream.Send(resp)
+	})
+}
+
 // UpdateLot updates a lot via the Lot service
 func (s *LotServiceImpl) UpdateLot(ctx context.Context, request *v1.UpdateLotRequest) (*v1.UpdateLotResponse, error) {
 	// TODO: rewrite to allow for update both at the same time
 	request.GetLot().Id = request.GetId()
-
-	if err := s.lotStore.UpdateLot(ctx, request.GetLot(), request.GetUpdateMask().GetPaths()); err != nil {
+	err := s.inTx(ctx, func(s *LotServiceImpl) error {
+		if err := s.checkUpdateLock(ctx, request.GetLot(), request.GetUpdateMask().GetPaths()); err != nil {
+			return err
+		}
+		return s.lotStore.UpdateLot(ctx, request.GetLot(), request.GetUpdateMask().GetPaths())
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return &v1.UpdateLotResponse{}, nil
+	return &v1.UpdateLotResponse{Version: request.GetLot().GetVersion()}, nil
 }
 
 // CreateLot creates a new lot via the Lot service
@@ -88,7 +128,30 @@ func (s *LotServiceImpl) CreateLot(ctx context.Context, request *v1.CreateLotReq
 		return nil, status.Error(codes.InvalidArgument, "lot id is not expected in POST")
 	}
 
-	lot, err := s.lotStore.CreateLot(ctx, request.GetLot())
+	var lot *storage.Lot
+	err := s.inTx(ctx, func(s *LotServiceImpl) error {
+		// a new lot's balances start on its orig date, while balances added to an existing lot are checked
+		// against that lot's lock date
+		target := lotTarget(request.GetLot())
+		dt := request.GetLot().GetOrigDt()
+		if len(request.GetLot().GetBal()) > 0 {
+			dt = earliestBalDt(request.GetLot())
+			if request.GetLot().GetId() != "" {
+				origLot, err := s.lotStore.GetLot(ctx, request.GetLot().GetId(), "")
+				if err != nil {
+					return err
+				}
+				target = lotTarget(origLot)
+			}
+		}
+		if err := s.guard.Check(ctx, target, dt, request.GetLot().GetId()); err != nil {
+			return err
+		}
+
+		var err error
+		lot, err = s.lotStore.CreateLot(ctx, request.GetLot())
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -100,16 +163,153 @@ func (s *LotServiceImpl) CreateLot(ctx context.Context, request *v1.CreateLotReq
 
 // DeleteLot removes a lot from the Lot service
 func (s *LotServiceImpl) DeleteLot(ctx context.Context, request *v1.DeleteLotRequest) (*v1.DeleteLotResponse, error) {
-	// delete lot from store
-	var delLot storage.Lot
-	delLot.Id = request.GetId()
-	if err := s.lotStore.DeleteLot(ctx, &delLot); err != nil {
+	err := s.inTx(ctx, func(s *LotServiceImpl) error {
+		if err := s.checkDeleteLock(ctx, request.GetId()); err != nil {
+			return err
+		}
+
+		// delete lot from store
+		var delLot storage.Lot
+		delLot.Id = request.GetId()
+		return s.lotStore.DeleteLot(ctx, &delLot)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &v1.DeleteLotResponse{}, nil
 }
 
+// BatchCreateLots creates a set of new lots via the Lot service. the lots are returned in the order given,
+// and any that failed in a partial batch are returned without an id. balances are added to existing lots
+// with CreateLot. lock dates are checked as the lots are written, so an override is only recorded for one
+// that's created
+func (s *LotServiceImpl) BatchCreateLots(ctx context.Context, request *v1.BatchCreateLotsRequest) (*v1.BatchCreateLotsResponse, error) {
+	lots := request.GetLots()
+	errs, err := batch.Run(len(lots), request.GetPartial(),
+		func(i int) error {
+			if lots[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "lot id is not expected in POST")
+			}
+			if len(lots[i].GetBal()) > 0 {
+				return status.Error(codes.InvalidArgument, "lot balances are not expected in a batch")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			return s.inTx(ctx, func(s *LotServiceImpl) error {
+				batchLots := make([]*storage.Lot, len(idx))
+				for j, i := range idx {
+					if err := s.guard.Check(ctx, lotTarget(lots[i]), lots[i].GetOrigDt(), ""); err != nil {
+						return batch.ItemError(i, err)
+					}
+					batchLots[j] = lots[i]
+				}
+				_, err := s.lotStore.CreateLots(ctx, batchLots)
+				return err
+			})
+		},
+		func(i int) error {
+			return s.inTx(ctx, func(s *LotServiceImpl) error {
+				if err := s.guard.Check(ctx, lotTarget(lots[i]), lots[i].GetOrigDt(), ""); err != nil {
+					return err
+				}
+				_, err := s.lotStore.CreateLots(ctx, lots[i:i+1])
+				return err
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchCreateLotsResponse{
+		Lots:   lots,
+		Errors: errs,
+	}, nil
+}
+
+// BatchUpdateLots updates a set of lots via the Lot service
+func (s *LotServiceImpl) BatchUpdateLots(ctx context.Context, request *v1.BatchUpdateLotsRequest) (*v1.BatchUpdateLotsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			lot := requests[i].GetLot()
+			if lot == nil {
+				return status.Error(codes.InvalidArgument, "lot required in update")
+			}
+			lot.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			return s.inTx(ctx, func(s *LotServiceImpl) error {
+				lots := make([]*storage.Lot, len(idx))
+				fieldMasks := make([][]string, len(idx))
+				for j, i := range idx {
+					if err := s.checkUpdateLock(ctx, requests[i].GetLot(), requests[i].GetUpdateMask().GetPaths()); err != nil {
+						return batch.ItemError(i, err)
+					}
+					lots[j] = requests[i].GetLot()
+					fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
+				}
+				return s.lotStore.UpdateLots(ctx, lots, fieldMasks)
+			})
+		},
+		func(i int) error {
+			return s.inTx(ctx, func(s *LotServiceImpl) error {
+				if err := s.checkUpdateLock(ctx, requests[i].GetLot(), requests[i].GetUpdateMask().GetPaths()); err != nil {
+					return err
+				}
+				return s.lotStore.UpdateLot(ctx, requests[i].GetLot(), requests[i].GetUpdateMask().GetPaths())
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdateLotsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetLot().GetVersion()
+		}),
+	}, nil
+}
+
+// BatchDeleteLots removes a set of lots and all their balances from the Lot service
+func (s *LotServiceImpl) BatchDeleteLots(ctx context.Context, request *v1.BatchDeleteLotsRequest) (*v1.BatchDeleteLotsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			return nil
+		},
+		func(idx []int) error {
+			return s.inTx(ctx, func(s *LotServiceImpl) error {
+				batchIDs := make([]string, len(idx))
+				for j, i := range idx {
+					if err := s.checkDeleteLock(ctx, ids[i]); err != nil {
+						return batch.ItemError(i, err)
+					}
+					batchIDs[j] = ids[i]
+				}
+				return s.lotStore.DeleteLots(ctx, batchIDs)
+			})
+		},
+		func(i int) error {
+			return s.inTx(ctx, func(s *LotServiceImpl) error {
+				if err := s.checkDeleteLock(ctx, ids[i]); err != nil {
+					return err
+				}
+				return s.lotStore.DeleteLot(ctx, &storage.Lot{Id: ids[i]})
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchDeleteLotsResponse{
+		Errors: errs,
+	}, nil
+}
+
 // RollLots rolls forward or back one or more lots from one day to the next
 func (s *LotServiceImpl) RollLots(ctx context.Context, request *v1.RollLotsRequest) (*v1.RollLotsResponse, error) {
 	var err error
@@ -123,40 +323,176 @@ func (s *LotServiceImpl) RollLots(ctx context.Context, request *v1.RollLotsReque
 		direction = request.GetDirection()
 	}
 
-	if direction == "back" {
-		err = s.lotStore.DeleteLotBal(ctx, request.GetDt(), request.GetLots())
+	// rolling forward creates balances the next day, while rolling back removes the day's balances
+	lockDt := request.GetDt()
+	if direction != "back" {
+		dt, err := time.Parse("2006-01-02", request.GetDt())
 		if err != nil {
-			return nil, err
+			return nil, status.Errorf(codes.InvalidArgument, "invalid roll date %s", request.GetDt())
 		}
-	} else {
-		lotBals, err := s.lotStore.ListLotBals(ctx, request.GetDt(), request.GetLots())
-		if err != nil {
-			return nil, err
+		lockDt = dt.AddDate(0, 0, 1).Format("2006-01-02")
+	}
+	err = s.inTx(ctx, func(s *LotServiceImpl) error {
+		if err := s.checkRollLock(ctx, request.GetLots(), lockDt); err != nil {
+			return err
 		}
+		return s.rollLots(ctx, request.GetDt(), request.GetLots(), direction)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// increment date by one day
-		nextDT, err := time.Parse("2006-01-02", request.GetDt())
-		if err != nil {
-			return nil, err
-		}
-		nextDT = nextDT.AddDate(0, 0, 1)
-		nextDtStr := nextDT.Format(time.RFC3339)
-
-		// update lot balances with new date and insert into store
-		// TODO: a sql select into would be more efficient, but think this is needed
-		//       to abstract away the data back end. figure out if there is a better
-		//       way to handle at the service level while still abstracting backend
-		for _, lotBal := range lotBals {
-			// only roll lot if all balances are non-zero (zero bal lots are only left on their final day)
-			if (lotBal.LotSize != 0) || (lotBal.SettledSize != 0) || (lotBal.UnsettledSize != 0) {
-				lotBal.LotDt = nextDtStr
-				err = s.lotStore.CreateLotBal(ctx, lotBal)
-				if err != nil {
-					return nil, err
-				}
+	return &v1.RollLotsResponse{Status: "completed"}, nil
+}
+
+// rollLots rolls lots forward from a date, creating their balances the next day, or back, removing the
+// date's balances
+func (s *LotServiceImpl) rollLots(ctx context.Context, dt string, lotIDs []string, direction string) error {
+	if direction == "back" {
+		return s.lotStore.DeleteLotBal(ctx, dt, lotIDs)
+	}
+
+	lotBals, err := s.lotStore.ListLotBals(ctx, dt, lotIDs)
+	if err != nil {
+		return err
+	}
+
+	// increment date by one day
+	nextDT, err := time.Parse("2006-01-02", dt)
+	if err != nil {
+		return err
+	}
+	nextDT = nextDT.AddDate(0, 0, 1)
+	nextDtStr := nextDT.Format(time.RFC3339)
+
+	// update lot balances with new date and insert into store
+	// TODO: a sql select into would be more efficient, but think this is needed
+	//       to abstract away the data back end. figure out if there is a better
+	//       way to handle at the service level while still abstracting backend
+	for _, lotBal := range lotBals {
+		// only roll lot if all balances are non-zero (zero bal lots are only left on their final day)
+		if (lotBal.LotSize != 0) || (lotBal.SettledSize != 0) || (lotBal.UnsettledSize != 0) {
+			lotBal.LotDt = nextDtStr
+			if err = s.lotStore.CreateLotBal(ctx, lotBal); err != nil {
+				return err
 			}
 		}
 	}
 
-	return &v1.RollLotsResponse{Status: "completed"}, nil
+	return nil
+}
+
+// inTx runs fn with a copy of the service whose store and guard share a database transaction, so a change
+// and any lock override that lets it through commit together or not at all
+func (s *LotServiceImpl) inTx(ctx context.Context, fn func(s *LotServiceImpl) error) error {
+	return s.lotStore.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		txS := *s
+		txS.lotStore = s.lotStore.WithTx(tx)
+		txS.guard = s.guard.WithTx(tx)
+		return fn(&txS)
+	})
+}
+
+// checkUpdateLock makes sure a lot's balance updates aren't on or before its lock date. moving a lot to
+// another account or legal entity moves its whole balance history, so both the original and the new one
+// are checked from its orig date on
+func (s *LotServiceImpl) checkUpdateLock(ctx context.Context, lot *storage.Lot, fieldMask []string) error {
+	moved := len(lot.GetBal()) == 0 && (fieldMask == nil || inMask(fieldMask, "acct_id") || inMask(fieldMask, "le_org_id"))
+	if len(lot.GetBal()) == 0 && !moved {
+		return nil
+	}
+
+	origLot, err := s.lotStore.GetLot(ctx, lot.GetId(), "")
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return s.guard.Check(ctx, lotTarget(origLot), earliestBalDt(lot), origLot.GetId())
+	}
+
+	// only the fields in the mask are updated, or the whole lot without one
+	target := lotTarget(origLot)
+	newTarget := target
+	if fieldMask == nil || inMask(fieldMask, "acct_id") {
+		newTarget.AcctID = lot.GetAcctId()
+	}
+	if fieldMask == nil || inMask(fieldMask, "le_org_id") {
+		newTarget.LeOrgID = lot.GetLeOrgId()
+	}
+	if newTarget == target {
+		return nil
+	}
+	if err = s.guard.Check(ctx, target, origLot.GetOrigDt(), origLot.GetId()); err != nil {
+		return err
+	}
+	return s.guard.Check(ctx, newTarget, origLot.GetOrigDt(), origLot.GetId())
+}
+
+// checkDeleteLock makes sure a lot can be deleted. deleting a lot removes all its balances, so nothing from
+// its orig date on can be locked
+func (s *LotServiceImpl) checkDeleteLock(ctx context.Context, id string) error {
+	lot, err := s.lotStore.GetLot(ctx, id, "")
+	if err != nil {
+		return err
+	}
+	return s.guard.Check(ctx, lotTarget(lot), lot.GetOrigDt(), lot.GetId())
+}
+
+// checkRollLock makes sure the lots being rolled aren't locked on a date. rolling all lots is checked
+// against every lock
+func (s *LotServiceImpl) checkRollLock(ctx context.Context, lotIDs []string, dt string) error {
+	if len(lotIDs) == 0 {
+		return s.guard.CheckAll(ctx, dt, "")
+	}
+
+	filter := lotStore.LotFilter{
+		ID: lotIDs,
+	}
+	lots, _, err := s.lotStore.ListLots(ctx, 0, "", filter.String(), "")
+	if err != nil {
+		return err
+	}
+
+	checked := make(map[guard.Target]bool)
+	for _, lot := range lots {
+		target := lotTarget(lot)
+		if checked[target] {
+			continue
+		}
+		checked[target] = true
+		if err = s.guard.Check(ctx, target, dt, lot.GetId()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lotTarget gets the legal entity and account a lot belongs to
+func lotTarget(lot *storage.Lot) guard.Target {
+	return guard.Target{
+		LeOrgID: lot.GetLeOrgId(),
+		AcctID:  lot.GetAcctId(),
+	}
+}
+
+// inMask checks if a field is in an update mask
+func inMask(fieldMask []string, field string) bool {
+	for _, path := range fieldMask {
+		if path == field {
+			return true
+		}
+	}
+	return false
+}
+
+// earliestBalDt gets the earliest date of a lot's balances
+func earliestBalDt(lot *storage.Lot) string {
+	dt := ""
+	for _, lotBal := range lot.GetBal() {
+		if dt == "" || lotBal.GetLotDt() < dt {
+			dt = lotBal.GetLotDt()
+		}
+	}
+	return dt
 }
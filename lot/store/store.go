@@ -2,14 +2,19 @@ package store
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
-	"github.com/go-pg/urlstruct"
+	"github.com/golang/protobuf/proto"
+	changeStore "github.com/wolfinger/varangian/change/store"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/dbtx"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -20,16 +25,23 @@ import (
 // Store interface used for implementing the Lot store
 type Store interface {
 	GetLot(ctx context.Context, id string, dt string) (*storage.Lot, error)
-	ListLots(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Lot, error)
+	ListLots(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Lot, string, error)
 	UpdateLot(ctx context.Context, lot *storage.Lot, fieldMask []string) error
 	CreateLot(ctx context.Context, lot *storage.Lot) (*storage.Lot, error)
 	DeleteLot(ctx context.Context, lot *storage.Lot) error
+	StreamLots(ctx context.Context, filter string, fn func(lot *storage.Lot) error) error
+	CreateLots(ctx context.Context, lots []*storage.Lot) ([]*storage.Lot, error)
+	UpdateLots(ctx context.Context, lots []*storage.Lot, fieldMasks [][]string) error
+	DeleteLots(ctx context.Context, ids []string) error
 
 	GetLotBal(ctx context.Context, id string, dt string) (*storage.LotBal, error)
 	ListLotBals(ctx context.Context, dt string, ids []string) ([]*storage.LotBal, error)
 	UpdateLotBal(ctx context.Context, lotBal *storage.LotBal) error
 	CreateLotBal(ctx context.Context, lotBal *storage.LotBal) error
 	DeleteLotBal(ctx context.Context, dt string, ids []string) error
+	StreamLotBals(ctx context.Context, startDt string, endDt string, filter string, fn func(lotBal *storage.LotBal) error) error
+	RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error
+	WithTx(tx *pg.Tx) Store
 }
 
 // NewStore encapsulates Lot database operations
@@ -40,51 +52,76 @@ func NewStore(conn *pg.DB) Store {
 }
 
 type storeImpl struct {
-	conn *pg.DB
+	conn dbtx.Conn
 }
 
-// LotFilter provides custom filter for the Transaction store
-type LotFilter struct {
-	ID       []string
-	SrcTxnID []string
-	urlstruct.Pager
-	/*
-		InstID   string
-		OrigDT   string
-		OrigSize float64
-		LeOrgID  string
-		AcctID   string
-	*/
+// RunInTransaction runs fn in a database transaction that other stores can be bound to with WithTx
+func (s *storeImpl) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return s.conn.RunInTransaction(ctx, fn)
 }
 
-func (f *LotFilter) query(q *orm.Query) (*orm.Query, error) {
-	//q = q.Model((*storage.Lot)(nil))
-	// q = q.Relation("Lot")
+// WithTx gets a copy of the store bound to a database transaction, which its changes commit with
+func (s *storeImpl) WithTx(tx *pg.Tx) Store {
+	return &storeImpl{conn: dbtx.Shared(tx)}
+}
 
-	// ID filters
-	if f.ID != nil {
-		vids, err := vxid.Decodes(f.ID)
-		if err != nil {
-			return nil, err
-		}
-		q.Where("id IN (?)", pg.In(vids))
-	}
+// lotKeys are the keys lots are listed in order of
+var lotKeys = []page.Key{{Col: "orig_dt"}, {Col: "id"}}
 
-	// SrcTxnID filters
-	if f.SrcTxnID != nil {
-		vids, err := vxid.Decodes(f.SrcTxnID)
-		if err != nil {
-			return nil, err
+// lotOrderCols are the columns lots can be listed in order of
+var lotOrderCols = []string{"id", "orig_dt", "orig_size", "orig_cost"}
+
+// lotBalKey is what a deleted lot balance returns to record its delete by
+const lotBalKey = "lot_id, lot_dt::date AS lot_dt"
+
+// lotFields are the fields lots can be filtered on
+var lotFields = filterPkg.NewFields(&storage.Lot{}, map[string]string{
+	"id":         vxid.PfxMap.Lot,
+	"inst_id":    vxid.PfxMap.Instrument,
+	"src_txn_id": vxid.PfxMap.Transaction,
+	"le_org_id":  vxid.PfxMap.Organization,
+	"acct_id":    vxid.PfxMap.Account,
+	"port_id":    vxid.PfxMap.Portfolio,
+	"strat_id":   vxid.PfxMap.Strategy,
+})
+
+// LotFilter builds a filter for the Lot store, for callers listing lots from go rather than for a user
+type LotFilter struct {
+	ID       []string
+	SrcTxnID []string
+	InstID   []string
+	LeOrgID  []string
+	AcctID   []string
+	PortID   []string
+	StratID  []string
+}
 
+// String writes the filter out in the filter language
+func (f LotFilter) String() string {
+	var parts []string
+	in := func(field string, vals []string) {
+		if len(vals) > 0 {
+			parts = append(parts, filterPkg.In(field, vals...))
 		}
-		q.Where("src_txn_id IN (?)", pg.In(vids))
 	}
+	in("id", f.ID)
+	in("src_txn_id", f.SrcTxnID)
+	in("inst_id", f.InstID)
+	in("le_org_id", f.LeOrgID)
+	in("acct_id", f.AcctID)
+	in("port_id", f.PortID)
+	in("strat_id", f.StratID)
 
-	return q, nil
+	return strings.Join(parts, " AND ")
 }
 
 // GetLot retrieves a lot from the Lot service
 func (s *storeImpl) GetLot(ctx context.Context, id string, dt string) (*storage.Lot, error) {
+	return getLot(ctx, s.conn, id, dt)
+}
+
+// getLot gets a lot with either a connection or a database transaction
+func getLot(ctx context.Context, db orm.DB, id string, dt string) (*storage.Lot, error) {
 	// convert vxid to vid
 	vid, err := vxid.Decode(id)
 	if err != nil {
@@ -92,7 +129,7 @@ func (s *storeImpl) GetLot(ctx context.Context, id string, dt string) (*storage.
 	}
 
 	var lot storage.Lot
-	err = s.conn.ModelContext(ctx, &lot).ColumnExpr("*, orig_dt::date").Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &lot).ColumnExpr("*, orig_dt::date").Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "lot with id %s not found", id)
@@ -126,13 +163,25 @@ func (s *storeImpl) GetLot(ctx context.Context, id string, dt string) (*storage.
 			return nil, err
 		}
 	}
+	if lot.GetPortId() != "" {
+		lot.PortId, err = vxid.Encode(lot.GetPortId(), vxid.PfxMap.Portfolio)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if lot.GetStratId() != "" {
+		lot.StratId, err = vxid.Encode(lot.GetStratId(), vxid.PfxMap.Strategy)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// get balance data associated with lot if date is passed in
 	if dt != "" {
 		// TODO: parse query string to be able to pull date ranges
 		var lotBal storage.LotBal
 
-		err = s.conn.ModelContext(ctx, &lotBal).ColumnExpr("lot_dt::date, lot_size, settled_size, unsettled_size").Where("lot_id = ?", vid).Where("lot_dt = ?", dt).Select()
+		err = db.ModelContext(ctx, &lotBal).ColumnExpr("lot_dt::date, lot_size, settled_size, unsettled_size, version").Where("lot_id = ?", vid).Where("lot_dt = ?", dt).Select()
 		if err != nil {
 			if err == pg.ErrNoRows {
 				return nil, status.Errorf(codes.NotFound, "lot with id %s and date %s not found", id, dt)
@@ -145,8 +194,9 @@ func (s *storeImpl) GetLot(ctx context.Context, id string, dt string) (*storage.
 	return &lot, err
 }
 
-// ListLots lists an array of lots from the Lot store
-func (s *storeImpl) ListLots(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Lot, error) {
+// ListLots lists a page of the lots matching a filter from the Lot store, in order of origination date unless
+// ordered otherwise. a page size of 0 lists every lot
+func (s *storeImpl) ListLots(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Lot, string, error) {
 	// TODO: revisit if sending a date in should pull the balances for that date too
 	/*
 		dtFlag := false
@@ -155,15 +205,30 @@ func (s *storeImpl) ListLots(ctx context.Context, pageSize int32, pageToken stri
 		}
 	*/
 
-	// decode json filter string into struct
-	f := new(LotFilter)
-	json.Unmarshal([]byte(filter), &f)
+	keys, err := page.Order(orderBy, lotKeys, lotOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
 
 	var lots []*storage.Lot
-	q := s.conn.ModelContext(ctx, &lots).Apply(f.query)
-	err := q.Select()
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &lots).ColumnExpr("*, orig_dt::date"), filter, lotFields)
 	if err != nil {
-		return nil, fmt.Errorf("listing lots: %w", err)
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter).Dates("orig_dt")
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing lots: %w", err)
+	}
+
+	// the next page starts after the last lot of this one
+	var nextPageToken string
+	n, more := p.Next(len(lots))
+	lots = lots[:n]
+	if more {
+		nextPageToken = p.Token(lotVals(lots[n-1], keys)...)
 	}
 
 	/*
@@ -183,45 +248,206 @@ func (s *storeImpl) ListLots(ctx context.Context, pageSize int32, pageToken stri
 	*/
 
 	for _, lot := range lots {
-		// convert vids to vxids
-		lot.Id, err = vxid.Encode(lot.GetId(), vxid.PfxMap.Lot)
-		if err != nil {
-			return nil, err
+		if err = encodeLotIDs(lot); err != nil {
+			return nil, "", err
 		}
-		if lot.GetInstId() != "" {
-			lot.InstId, err = vxid.Encode(lot.GetInstId(), vxid.PfxMap.Instrument)
-			if err != nil {
-				return nil, err
-			}
+	}
+
+	return lots, nextPageToken, nil
+}
+
+// lotVals gets the values of the keys lots are listed in order of from a lot, for page tokens
+func lotVals(lot *storage.Lot, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = lot.GetId()
+		case "orig_dt":
+			vals[i] = lot.GetOrigDt()
+		case "orig_size":
+			vals[i] = page.Float(lot.GetOrigSize())
+		case "orig_cost":
+			vals[i] = page.Float(lot.GetOrigCost())
 		}
-		if lot.GetSrcTxnId() != "" {
-			lot.SrcTxnId, err = vxid.Encode(lot.GetSrcTxnId(), vxid.PfxMap.Transaction)
-			if err != nil {
-				return nil, err
+	}
+
+	return vals
+}
+
+// StreamLots calls a function with each lot matching a filter, in order of origination date, without
+// holding them all in memory. streaming stops at the first error the function returns
+func (s *storeImpl) StreamLots(ctx context.Context, filter string, fn func(lot *storage.Lot) error) error {
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, (*storage.Lot)(nil)).ColumnExpr("*, orig_dt::date"), filter, lotFields)
+	if err != nil {
+		return err
+	}
+
+	err = q.OrderExpr("lot.orig_dt, lot.id").
+		ForEach(func(lot *storage.Lot) error {
+			if err := encodeLotIDs(lot); err != nil {
+				return err
 			}
+			return fn(lot)
+		})
+	if err != nil {
+		return fmt.Errorf("streaming lots: %w", err)
+	}
+
+	return nil
+}
+
+// encodeLotIDs converts the vids of a lot to vxids
+func encodeLotIDs(lot *storage.Lot) error {
+	var err error
+	lot.Id, err = vxid.Encode(lot.GetId(), vxid.PfxMap.Lot)
+	if err != nil {
+		return err
+	}
+	if lot.GetInstId() != "" {
+		lot.InstId, err = vxid.Encode(lot.GetInstId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return err
 		}
-		if lot.GetLeOrgId() != "" {
-			lot.LeOrgId, err = vxid.Encode(lot.GetLeOrgId(), vxid.PfxMap.Organization)
-			if err != nil {
-				return nil, err
-			}
+	}
+	if lot.GetSrcTxnId() != "" {
+		lot.SrcTxnId, err = vxid.Encode(lot.GetSrcTxnId(), vxid.PfxMap.Transaction)
+		if err != nil {
+			return err
 		}
-		if lot.GetAcctId() != "" {
-			lot.AcctId, err = vxid.Encode(lot.GetAcctId(), vxid.PfxMap.Account)
-			if err != nil {
-				return nil, err
-			}
+	}
+	if lot.GetLeOrgId() != "" {
+		lot.LeOrgId, err = vxid.Encode(lot.GetLeOrgId(), vxid.PfxMap.Organization)
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetAcctId() != "" {
+		lot.AcctId, err = vxid.Encode(lot.GetAcctId(), vxid.PfxMap.Account)
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetPortId() != "" {
+		lot.PortId, err = vxid.Encode(lot.GetPortId(), vxid.PfxMap.Portfolio)
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetStratId() != "" {
+		lot.StratId, err = vxid.Encode(lot.GetStratId(), vxid.PfxMap.Strategy)
+		if err != nil {
+			return err
 		}
 	}
 
-	return lots, nil
+	return nil
+}
+
+// decodeLotIDs converts the vxids of a lot to vids
+func decodeLotIDs(lot *storage.Lot) error {
+	var err error
+	if lot.GetId() != "" {
+		lot.Id, err = vxid.Decode(lot.GetId())
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetInstId() != "" {
+		lot.InstId, err = vxid.Decode(lot.GetInstId())
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetSrcTxnId() != "" {
+		lot.SrcTxnId, err = vxid.Decode(lot.GetSrcTxnId())
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetLeOrgId() != "" {
+		lot.LeOrgId, err = vxid.Decode(lot.GetLeOrgId())
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetAcctId() != "" {
+		lot.AcctId, err = vxid.Decode(lot.GetAcctId())
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetPortId() != "" {
+		lot.PortId, err = vxid.Decode(lot.GetPortId())
+		if err != nil {
+			return err
+		}
+	}
+	if lot.GetStratId() != "" {
+		lot.StratId, err = vxid.Decode(lot.GetStratId())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // UpdateLot updates a lot via the Lot store
 func (s *storeImpl) UpdateLot(ctx context.Context, lot *storage.Lot, fieldMask []string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := updateLot(ctx, tx, lot, fieldMask); err != nil {
+			return err
+		}
+		return recordLotUpdates(ctx, tx, lot)
+	})
+	if err != nil {
+		return err
+	}
+	nextVersion(lot)
+
+	return nil
+}
+
+// UpdateLots updates a set of lots via the Lot store, each with its own field mask. either every lot is
+// updated or none are
+func (s *storeImpl) UpdateLots(ctx context.Context, lots []*storage.Lot, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for i, lot := range lots {
+			if err := updateLot(ctx, tx, lot, fieldMasks[i]); err != nil {
+				return err
+			}
+		}
+		return recordLotUpdates(ctx, tx, lots...)
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, lot := range lots {
+		nextVersion(lot)
+	}
+
+	return nil
+}
+
+// nextVersion moves an updated lot on to its next version, or its balances if they're what was updated
+func nextVersion(lot *storage.Lot) {
+	if len(lot.GetBal()) == 0 {
+		lot.Version++
+		return
+	}
+	for _, lotBal := range lot.GetBal() {
+		lotBal.Version++
+	}
+}
+
+// updateLot updates a lot's reference data or balances with either a connection or a database transaction
+func updateLot(ctx context.Context, db orm.DB, lot *storage.Lot, fieldMask []string) error {
 	// TODO: rewrite to allow for update both at the same time
 	var err error
-	tgtLot := lot
+	tgtLot := proto.Clone(lot).(*storage.Lot)
 
 	// determine if we're upating a lot's reference data or point-in-time (balance) data
 	balFlag := false
@@ -233,7 +459,7 @@ func (s *storeImpl) UpdateLot(ctx context.Context, lot *storage.Lot, fieldMask [
 		// copy over only the fields passed in from the field mask (if provided)
 		if fieldMask != nil {
 			// get original acct object to update
-			tgtLot, err = s.GetLot(ctx, lot.GetId(), "")
+			tgtLot, err = getLot(ctx, db, lot.GetId(), "")
 			if err != nil {
 				return err
 			}
@@ -246,46 +472,21 @@ func (s *storeImpl) UpdateLot(ctx context.Context, lot *storage.Lot, fieldMask [
 		}
 
 		// convert vxids to vids
-		tgtLot.Id, err = vxid.Decode(tgtLot.GetId())
-		if err != nil {
+		if err = decodeLotIDs(tgtLot); err != nil {
 			return err
 		}
-		if tgtLot.GetInstId() != "" {
-			tgtLot.InstId, err = vxid.Decode(tgtLot.GetInstId())
-			if err != nil {
-				return err
-			}
-		}
-		if tgtLot.GetSrcTxnId() != "" {
-			tgtLot.SrcTxnId, err = vxid.Decode(tgtLot.GetSrcTxnId())
-			if err != nil {
-				return err
-			}
-		}
-		if tgtLot.GetLeOrgId() != "" {
-			tgtLot.LeOrgId, err = vxid.Decode(tgtLot.GetLeOrgId())
-			if err != nil {
-				return err
-			}
-		}
-		if tgtLot.GetAcctId() != "" {
-			tgtLot.AcctId, err = vxid.Decode(tgtLot.GetAcctId())
-			if err != nil {
-				return err
-			}
-		}
 
-		// update lot in datastore
-		_, err = s.conn.ModelContext(ctx, tgtLot).WherePK().Update()
-		if err != nil {
-			return fmt.Errorf("update lot %s: %w", lot.Id, err)
+		// update lot in datastore if it's still at the version the caller read
+		tgtLot.Version = lot.GetVersion() + 1
+		if err = version.Update(ctx, db, tgtLot, lot.GetVersion(), "lot "+lot.GetId()); err != nil {
+			return err
 		}
 	} else {
 		// update lot balance(s)
 		lotBals := lot.GetBal()
 		for _, lotBal := range lotBals {
 			lotBal.LotId = lot.Id
-			err := s.UpdateLotBal(ctx, lotBal)
+			err := updateLotBal(ctx, db, lotBal)
 			if err != nil {
 				return err
 			}
@@ -297,6 +498,18 @@ func (s *storeImpl) UpdateLot(ctx context.Context, lot *storage.Lot, fieldMask [
 
 // CreateLot creates a new lot via the Lot store
 func (s *storeImpl) CreateLot(ctx context.Context, lot *storage.Lot) (*storage.Lot, error) {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return createLot(ctx, tx, lot)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lot, nil
+}
+
+// createLot creates a new lot, or new balances for an existing lot, with a database transaction
+func createLot(ctx context.Context, tx *pg.Tx, lot *storage.Lot) error {
 	var err error
 
 	// determine if we're inserting lot reference data or point-in-time data
@@ -312,37 +525,51 @@ func (s *storeImpl) CreateLot(ctx context.Context, lot *storage.Lot) (*storage.L
 		xLot.SrcTxnId = lot.GetSrcTxnId()
 		xLot.LeOrgId = lot.GetLeOrgId()
 		xLot.AcctId = lot.GetAcctId()
+		xLot.PortId = lot.GetPortId()
+		xLot.StratId = lot.GetStratId()
 
 		// convert vxids to vids
 		if lot.GetInstId() != "" {
 			lot.InstId, err = vxid.Decode(lot.GetInstId())
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 		if lot.GetSrcTxnId() != "" {
 			lot.SrcTxnId, err = vxid.Decode(lot.GetSrcTxnId())
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 		if lot.GetLeOrgId() != "" {
 			lot.LeOrgId, err = vxid.Decode(lot.GetLeOrgId())
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 		if lot.GetAcctId() != "" {
 			lot.AcctId, err = vxid.Decode(lot.GetAcctId())
 			if err != nil {
-				return nil, err
+				return err
+			}
+		}
+		if lot.GetPortId() != "" {
+			lot.PortId, err = vxid.Decode(lot.GetPortId())
+			if err != nil {
+				return err
+			}
+		}
+		if lot.GetStratId() != "" {
+			lot.StratId, err = vxid.Decode(lot.GetStratId())
+			if err != nil {
+				return err
 			}
 		}
 
 		// add lot to datastore
-		_, err = s.conn.ModelContext(ctx, lot).Insert()
+		_, err = tx.ModelContext(ctx, lot).Insert()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// generate initial balance for new lot
@@ -354,26 +581,31 @@ func (s *storeImpl) CreateLot(ctx context.Context, lot *storage.Lot) (*storage.L
 			SettledSize:   0,
 			UnsettledSize: lot.GetOrigSize(),
 		}
-		_, err = s.conn.ModelContext(ctx, lotBal).Insert()
+		_, err = tx.ModelContext(ctx, lotBal).Insert()
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err = recordLotBals(ctx, tx, changeStore.Create, lotBal); err != nil {
+			return err
 		}
 
 		// convert vids to vxids
 		lot.Id, err = vxid.Encode(lot.Id, vxid.PfxMap.Lot)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		lot.InstId = xLot.GetInstId()
 		lot.SrcTxnId = xLot.GetSrcTxnId()
 		lot.LeOrgId = xLot.GetLeOrgId()
 		lot.AcctId = xLot.GetAcctId()
+		lot.PortId = xLot.GetPortId()
+		lot.StratId = xLot.GetStratId()
 	} else {
 		// TODO: rewrite so new lots generate their initial lot bal here too
 		// insert lot balances
 		lot.Id, err = vxid.Decode(lot.GetId())
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		lotBals := lot.GetBal()
@@ -384,20 +616,79 @@ func (s *storeImpl) CreateLot(ctx context.Context, lot *storage.Lot) (*storage.L
 			lotBal.UnsettledSize = lotBal.GetLotSize()
 			// add lotbal to datastore
 			// TODO: call createlotbal funciton instead of calling the insert?
-			_, err = s.conn.ModelContext(ctx, lotBal).Insert()
+			_, err = tx.ModelContext(ctx, lotBal).Insert()
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
+		if err = recordLotBals(ctx, tx, changeStore.Create, lotBals...); err != nil {
+			return err
+		}
 
 		// convert vids to vxids
 		lot.Id, err = vxid.Encode(lot.Id, vxid.PfxMap.Lot)
 		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateLots creates a set of new lots and their initial balances via the Lot store, with a single insert
+// of each. either every lot is created or none are, and the lots passed in are only changed once they are
+func (s *storeImpl) CreateLots(ctx context.Context, lots []*storage.Lot) ([]*storage.Lot, error) {
+	if len(lots) == 0 {
+		return lots, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Lot, len(lots))
+	for i, lot := range lots {
+		rows[i] = proto.Clone(lot).(*storage.Lot)
+		if err := decodeLotIDs(rows[i]); err != nil {
 			return nil, err
 		}
 	}
 
-	return lot, nil
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// add lots to datastore
+		if _, err := tx.ModelContext(ctx, &rows).Insert(); err != nil {
+			return fmt.Errorf("creating lots: %w", err)
+		}
+
+		// generate initial balances for the new lots
+		// TODO: support settled vs. unsettled auto insert
+		lotBals := make([]*storage.LotBal, len(rows))
+		for i, row := range rows {
+			lotBals[i] = &storage.LotBal{
+				LotId:         row.GetId(),
+				LotDt:         row.GetOrigDt(),
+				LotSize:       row.GetOrigSize(),
+				SettledSize:   0,
+				UnsettledSize: row.GetOrigSize(),
+			}
+		}
+		if _, err := tx.ModelContext(ctx, &lotBals).Insert(); err != nil {
+			return fmt.Errorf("creating lot_bals: %w", err)
+		}
+		return recordLotBals(ctx, tx, changeStore.Create, lotBals...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Lot)
+		if err != nil {
+			return nil, err
+		}
+		lots[i].Id = id
+		lots[i].Version = row.GetVersion()
+	}
+
+	return lots, nil
 }
 
 // DeleteLot removes a lot from the Lot store
@@ -414,25 +705,56 @@ func (s *storeImpl) DeleteLot(ctx context.Context, lot *storage.Lot) error {
 		balFlag = true
 	}
 
-	if balFlag == false {
-		// delete any associated lot balances first
-		if _, err = s.conn.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_id = ?", vid).Delete(); err != nil {
-			return fmt.Errorf("deleting %s from lot_bals: %w", lot.GetId(), err)
-		}
-		// delete the lot
-		if _, err = s.conn.ModelContext(ctx, (*storage.Lot)(nil)).Where("id = ?", vid).Delete(); err != nil {
-			return fmt.Errorf("deleting %s from lots: %w", lot.GetId(), err)
-		}
-	} else {
-		lotBals := lot.GetBal()
-		for _, lotBal := range lotBals {
-			if _, err = s.conn.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_id = ?", vid).Where("lot_dt = ?", lotBal.LotDt).Delete(); err != nil {
-				return fmt.Errorf("deleting %s from lot_bals for dt %s: %w", lot.GetId(), lotBal.GetLotDt(), err)
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var deleted []*storage.LotBal
+		if balFlag == false {
+			// delete any associated lot balances first
+			q := tx.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_id = ?", vid)
+			if _, err = q.Returning(lotBalKey).Delete(&deleted); err != nil {
+				return fmt.Errorf("deleting %s from lot_bals: %w", lot.GetId(), err)
+			}
+			// delete the lot
+			if _, err = tx.ModelContext(ctx, (*storage.Lot)(nil)).Where("id = ?", vid).Delete(); err != nil {
+				return fmt.Errorf("deleting %s from lots: %w", lot.GetId(), err)
+			}
+		} else {
+			lotBals := lot.GetBal()
+			for _, lotBal := range lotBals {
+				q := tx.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_id = ?", vid).Where("lot_dt = ?", lotBal.LotDt)
+				if _, err = q.Returning(lotBalKey).Delete(&deleted); err != nil {
+					return fmt.Errorf("deleting %s from lot_bals for dt %s: %w", lot.GetId(), lotBal.GetLotDt(), err)
+				}
 			}
 		}
+
+		return recordLotBals(ctx, tx, changeStore.Delete, deleted...)
+	})
+}
+
+// DeleteLots removes a set of lots and all their balances from the Lot store, with a single delete of each
+func (s *storeImpl) DeleteLots(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
 
-	return nil
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		// delete any associated lot balances first
+		var deleted []*storage.LotBal
+		q := tx.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_id IN (?)", pg.In(vids))
+		if _, err := q.Returning(lotBalKey).Delete(&deleted); err != nil {
+			return fmt.Errorf("deleting lots from lot_bals: %w", err)
+		}
+		if _, err := tx.ModelContext(ctx, (*storage.Lot)(nil)).Where("id IN (?)", pg.In(vids)).Delete(); err != nil {
+			return fmt.Errorf("deleting lots: %w", err)
+		}
+		return recordLotBals(ctx, tx, changeStore.Delete, deleted...)
+	})
 }
 
 // GetLotBal gets a lot balance for a given lot id and date
@@ -493,19 +815,68 @@ func (s *storeImpl) ListLotBals(ctx context.Context, dt string, ids []string) ([
 	return lotBals, nil
 }
 
+// StreamLotBals calls a function with each balance between two dates (inclusive) of the lots matching a
+// filter, in order of date, without holding them all in memory. streaming stops at the first error the
+// function returns
+func (s *storeImpl) StreamLotBals(ctx context.Context, startDt string, endDt string, filter string, fn func(lotBal *storage.LotBal) error) error {
+	q := s.conn.ModelContext(ctx, (*storage.LotBal)(nil)).
+		ColumnExpr("lot_id, lot_dt::date, lot_size, settled_size, unsettled_size").
+		Where("lot_dt >= ?", startDt).
+		Where("lot_dt <= ?", endDt)
+	if filter != "" {
+		lots, err := filterPkg.Apply(s.conn.ModelContext(ctx, (*storage.Lot)(nil)).Column("id"), filter, lotFields)
+		if err != nil {
+			return err
+		}
+		q.Where("lot_id IN (?)", lots)
+	}
+
+	err := q.Order("lot_dt", "lot_id").
+		ForEach(func(lotBal *storage.LotBal) error {
+			var err error
+			lotBal.LotId, err = vxid.Encode(lotBal.GetLotId(), vxid.PfxMap.Lot)
+			if err != nil {
+				return err
+			}
+			return fn(lotBal)
+		})
+	if err != nil {
+		return fmt.Errorf("streaming lot bals: %w", err)
+	}
+
+	return nil
+}
+
 // UpdateLotBal updates a lot balance for a given date
 func (s *storeImpl) UpdateLotBal(ctx context.Context, lotBal *storage.LotBal) error {
-	// update lot balance(s)
-	var err error
-	vxLotID := lotBal.GetLotId()
-	lotBal.LotId, err = vxid.Decode(lotBal.GetLotId())
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := updateLotBal(ctx, tx, lotBal); err != nil {
+			return err
+		}
+		return recordLotUpdates(ctx, tx, &storage.Lot{Id: lotBal.GetLotId(), Bal: []*storage.LotBal{lotBal}})
+	})
 	if err != nil {
 		return err
 	}
+	lotBal.Version++
+
+	return nil
+}
 
-	_, err = s.conn.ModelContext(ctx, lotBal).WherePK().Update()
+// updateLotBal updates a lot balance with either a connection or a database transaction
+func updateLotBal(ctx context.Context, db orm.DB, lotBal *storage.LotBal) error {
+	var err error
+	tgtLotBal := proto.Clone(lotBal).(*storage.LotBal)
+	tgtLotBal.LotId, err = vxid.Decode(lotBal.GetLotId())
 	if err != nil {
-		return fmt.Errorf("update lot %s on %s: %w", vxLotID, lotBal.LotDt, err)
+		return err
+	}
+
+	// update lot balance in datastore if it's still at the version the caller read
+	tgtLotBal.Version = lotBal.GetVersion() + 1
+	name := fmt.Sprintf("lot %s on %s", lotBal.GetLotId(), lotBal.GetLotDt())
+	if err = version.Update(ctx, db, tgtLotBal, lotBal.GetVersion(), name); err != nil {
+		return err
 	}
 
 	return nil
@@ -521,12 +892,12 @@ func (s *storeImpl) CreateLotBal(ctx context.Context, lotBal *storage.LotBal) er
 	}
 
 	// add lotbal to datastore
-	_, err = s.conn.ModelContext(ctx, lotBal).Insert()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if _, err := tx.ModelContext(ctx, lotBal).Insert(); err != nil {
+			return err
+		}
+		return recordLotBals(ctx, tx, changeStore.Create, lotBal)
+	})
 }
 
 // DeleteLotBal deletes a lot balance
@@ -542,14 +913,43 @@ func (s *storeImpl) DeleteLotBal(ctx context.Context, dt string, ids []string) e
 		}
 	}
 
-	if ids == nil {
-		_, err = s.conn.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_dt = ?", dt).Delete()
-	} else {
-		_, err = s.conn.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_dt = ?", dt).Where("lot_id = ANY (?)", pg.In(vids)).Delete()
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var deleted []*storage.LotBal
+		q := tx.ModelContext(ctx, (*storage.LotBal)(nil)).Where("lot_dt = ?", dt)
+		if ids != nil {
+			q = q.Where("lot_id = ANY (?)", pg.In(vids))
+		}
+		if _, err = q.Returning(lotBalKey).Delete(&deleted); err != nil {
+			return fmt.Errorf("deleting from lot_bals where date is %s: %w", dt, err)
+		}
+		return recordLotBals(ctx, tx, changeStore.Delete, deleted...)
+	})
+}
+
+// recordLotUpdates records the updates to the balances of a set of lots, by their vxids, with the database
+// transaction that made them. updates to a lot's reference data aren't recorded
+func recordLotUpdates(ctx context.Context, tx *pg.Tx, lots ...*storage.Lot) error {
+	var lotBals []*storage.LotBal
+	for _, lot := range lots {
+		vid, err := vxid.Decode(lot.GetId())
+		if err != nil {
+			return err
+		}
+		for _, lotBal := range lot.GetBal() {
+			lotBals = append(lotBals, &storage.LotBal{LotId: vid, LotDt: lotBal.GetLotDt()})
+		}
 	}
-	if err != nil {
-		return fmt.Errorf("deleting from lot_bals where date is %s: %w", dt, err)
+
+	return recordLotBals(ctx, tx, changeStore.Update, lotBals...)
+}
+
+// recordLotBals records changes to a set of lot balances, by their lot's vid and date, with the database
+// transaction that made them
+func recordLotBals(ctx context.Context, tx *pg.Tx, op string, lotBals ...*storage.LotBal) error {
+	changes := make([]*storage.Change, len(lotBals))
+	for i, lotBal := range lotBals {
+		changes[i] = changeStore.New(changeStore.LotBal, op, lotBal.GetLotId(), lotBal.GetLotDt())
 	}
 
-	return nil
+	return changeStore.Record(ctx, tx, changes...)
 }
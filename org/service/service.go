@@ -5,6 +5,9 @@ import (
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/page"
 	orgStore "github.com/wolfinger/varangian/org/store"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	"google.golang.org/grpc"
@@ -54,12 +57,13 @@ func (s *OrgServiceImpl) GetOrg(ctx context.Context, request *v1.GetOrgRequest)
 
 // ListOrgs lists an array of organizations from the Organization service
 func (s *OrgServiceImpl) ListOrgs(ctx context.Context, request *v1.ListOrgsRequest) (*v1.ListOrgsResponse, error) {
-	orgs, err := s.orgStore.ListOrgs(ctx)
+	orgs, nextPageToken, err := s.orgStore.ListOrgs(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 	return &v1.ListOrgsResponse{
-		Orgs: orgs,
+		Orgs:          orgs,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -71,7 +75,7 @@ func (s *OrgServiceImpl) UpdateOrg(ctx context.Context, request *v1.UpdateOrgReq
 		return nil, err
 	}
 
-	return &v1.UpdateOrgResponse{}, nil
+	return &v1.UpdateOrgResponse{Version: request.GetOrg().GetVersion()}, nil
 }
 
 // CreateOrg creats a new organization via the Organization service
@@ -102,3 +106,101 @@ func (s *OrgServiceImpl) DeleteOrg(ctx context.Context, request *v1.DeleteOrgReq
 
 	return &v1.DeleteOrgResponse{}, nil
 }
+
+// BatchCreateOrgs creates a set of organizations via the Organization service. the organizations are returned
+// in the order given, and any that failed in a partial batch are returned without an id
+func (s *OrgServiceImpl) BatchCreateOrgs(ctx context.Context, request *v1.BatchCreateOrgsRequest) (*v1.BatchCreateOrgsResponse, error) {
+	orgs := request.GetOrgs()
+	errs, err := batch.Run(len(orgs), request.GetPartial(),
+		func(i int) error {
+			if orgs[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "org id is not expected in POST")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchOrgs := make([]*storage.Org, len(idx))
+			for j, i := range idx {
+				batchOrgs[j] = orgs[i]
+			}
+			_, err := s.orgStore.CreateOrgs(ctx, batchOrgs)
+			return err
+		},
+		func(i int) error {
+			_, err := s.orgStore.CreateOrgs(ctx, orgs[i:i+1])
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchCreateOrgsResponse{
+		Orgs:   orgs,
+		Errors: errs,
+	}, nil
+}
+
+// BatchUpdateOrgs updates a set of organizations via the Organization service
+func (s *OrgServiceImpl) BatchUpdateOrgs(ctx context.Context, request *v1.BatchUpdateOrgsRequest) (*v1.BatchUpdateOrgsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			org := requests[i].GetOrg()
+			if org == nil {
+				return status.Error(codes.InvalidArgument, "org required in update")
+			}
+			org.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			orgs := make([]*storage.Org, len(idx))
+			fieldMasks := make([][]string, len(idx))
+			for j, i := range idx {
+				orgs[j] = requests[i].GetOrg()
+				fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
+			}
+			return s.orgStore.UpdateOrgs(ctx, orgs, fieldMasks)
+		},
+		func(i int) error {
+			return s.orgStore.UpdateOrg(ctx, requests[i].GetOrg(), requests[i].GetUpdateMask().GetPaths())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdateOrgsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetOrg().GetVersion()
+		}),
+	}, nil
+}
+
+// BatchDeleteOrgs removes a set of organizations from the Organization service
+func (s *OrgServiceImpl) BatchDeleteOrgs(ctx context.Context, request *v1.BatchDeleteOrgsRequest) (*v1.BatchDeleteOrgsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			if ids[i] == "" {
+				return status.Error(codes.InvalidArgument, "org id required in delete")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchIDs := make([]string, len(idx))
+			for j, i := range idx {
+				batchIDs[j] = ids[i]
+			}
+			return s.orgStore.DeleteOrgs(ctx, batchIDs)
+		},
+		func(i int) error {
+			return s.orgStore.DeleteOrg(ctx, ids[i])
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchDeleteOrgsResponse{
+		Errors: errs,
+	}, nil
+}
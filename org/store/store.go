@@ -5,8 +5,13 @@ import (
 	"fmt"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,10 +22,13 @@ import (
 // Store interface used for implementing the Organization store
 type Store interface {
 	GetOrg(ctx context.Context, id string) (*storage.Org, error)
-	ListOrgs(ctx context.Context) ([]*storage.Org, error)
+	ListOrgs(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Org, string, error)
 	UpdateOrg(ctx context.Context, strat *storage.Org, fieldMask []string) error
 	CreateOrg(ctx context.Context, strat *storage.Org) (*storage.Org, error)
 	DeleteOrg(ctx context.Context, id string) error
+	CreateOrgs(ctx context.Context, orgs []*storage.Org) ([]*storage.Org, error)
+	UpdateOrgs(ctx context.Context, orgs []*storage.Org, fieldMasks [][]string) error
+	DeleteOrgs(ctx context.Context, ids []string) error
 }
 
 // NewStore encapsulates Organization database operations
@@ -34,8 +42,25 @@ type storeImpl struct {
 	conn *pg.DB
 }
 
+// orgKeys are the keys organizations are listed in order of
+var orgKeys = []page.Key{{Col: "id"}}
+
+// orgOrderCols are the columns organizations can be listed in order of
+var orgOrderCols = []string{"id", "name"}
+
+// orgFields are the fields organizations can be filtered on
+var orgFields = filterPkg.NewFields(&storage.Org{}, map[string]string{
+	"id":        vxid.PfxMap.Organization,
+	"parent_id": vxid.PfxMap.Organization,
+})
+
 // GetOrg gets an organization from the Organization store
 func (s *storeImpl) GetOrg(ctx context.Context, id string) (*storage.Org, error) {
+	return getOrg(ctx, s.conn, id)
+}
+
+// getOrg gets an organization with either a connection or a database transaction
+func getOrg(ctx context.Context, db orm.DB, id string) (*storage.Org, error) {
 	// convert vxids to vids
 	vid, err := vxid.Decode(id)
 	if err != nil {
@@ -43,7 +68,7 @@ func (s *storeImpl) GetOrg(ctx context.Context, id string) (*storage.Org, error)
 	}
 
 	var org storage.Org
-	err = s.conn.ModelContext(ctx, &org).Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &org).Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "org with id %s not found", id)
@@ -63,37 +88,111 @@ func (s *storeImpl) GetOrg(ctx context.Context, id string) (*storage.Org, error)
 	return &org, err
 }
 
-// ListOrgs lists an array of organizations from the Organization store
-func (s *storeImpl) ListOrgs(ctx context.Context) ([]*storage.Org, error) {
+// ListOrgs lists a page of the organizations matching a filter from the Organization store, in order of id
+// unless ordered otherwise. a page size of 0 lists every organization
+func (s *storeImpl) ListOrgs(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Org, string, error) {
+	keys, err := page.Order(orderBy, orgKeys, orgOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var orgs []*storage.Org
-	err := s.conn.ModelContext(ctx, &orgs).Select()
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &orgs), filter, orgFields)
+	if err != nil {
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter)
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing orgs %w", err)
+	}
+
+	// the next page starts after the last organization of this one
+	var nextPageToken string
+	n, more := p.Next(len(orgs))
+	orgs = orgs[:n]
+	if more {
+		nextPageToken = p.Token(orgVals(orgs[n-1], keys)...)
+	}
 
 	for _, org := range orgs {
 		org.Id, err = vxid.Encode(org.Id, vxid.PfxMap.Organization)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		// convert vids to vxids
 		if org.ParentId != "" {
 			org.ParentId, err = vxid.Encode(org.ParentId, vxid.PfxMap.Organization)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 	}
 
-	return orgs, nil
+	return orgs, nextPageToken, nil
+}
+
+// orgVals gets the values of the keys organizations are listed in order of from a organization, for page
+// tokens
+func orgVals(org *storage.Org, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = org.GetId()
+		case "name":
+			vals[i] = org.GetName()
+		}
+	}
+
+	return vals
 }
 
 // UpdateOrg updates an organization via the Organization store
 func (s *storeImpl) UpdateOrg(ctx context.Context, org *storage.Org, fieldMask []string) error {
+	if err := updateOrg(ctx, s.conn, org, fieldMask); err != nil {
+		return err
+	}
+	org.Version++
+
+	return nil
+}
+
+// UpdateOrgs updates a set of organizations via the Organization store, each with its own field mask. either
+// every organization is updated or none are
+func (s *storeImpl) UpdateOrgs(ctx context.Context, orgs []*storage.Org, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for i, org := range orgs {
+			if err := updateOrg(ctx, tx, org, fieldMasks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, org := range orgs {
+		org.Version++
+	}
+
+	return nil
+}
+
+// updateOrg updates an organization with either a connection or a database transaction. the organization
+// passed in isn't changed
+func updateOrg(ctx context.Context, db orm.DB, org *storage.Org, fieldMask []string) error {
 	var err error
-	tgtOrg := org
+	tgtOrg := proto.Clone(org).(*storage.Org)
 
 	// copy over only the fields passed in from the field mask (if provided)
 	if fieldMask != nil {
 		// get original org object to update
-		tgtOrg, err = s.GetOrg(ctx, org.GetId())
+		tgtOrg, err = getOrg(ctx, db, org.GetId())
 		if err != nil {
 			return err
 		}
@@ -117,10 +216,10 @@ func (s *storeImpl) UpdateOrg(ctx context.Context, org *storage.Org, fieldMask [
 		}
 	}
 
-	// update org in the datastore
-	_, err = s.conn.ModelContext(ctx, tgtOrg).WherePK().Update()
-	if err != nil {
-		return fmt.Errorf("update org %s %w", org.GetId(), err)
+	// update org in the datastore if it's still at the version the caller read
+	tgtOrg.Version = org.GetVersion() + 1
+	if err = version.Update(ctx, db, tgtOrg, org.GetVersion(), "org "+org.GetId()); err != nil {
+		return err
 	}
 
 	return nil
@@ -156,6 +255,44 @@ func (s *storeImpl) CreateOrg(ctx context.Context, org *storage.Org) (*storage.O
 	return org, nil
 }
 
+// CreateOrgs creates a set of organizations via the Organization store with a single insert. either every
+// organization is created or none are, and the organizations passed in are only changed once they are
+func (s *storeImpl) CreateOrgs(ctx context.Context, orgs []*storage.Org) ([]*storage.Org, error) {
+	if len(orgs) == 0 {
+		return orgs, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Org, len(orgs))
+	for i, org := range orgs {
+		rows[i] = proto.Clone(org).(*storage.Org)
+		if org.GetParentId() != "" {
+			var err error
+			rows[i].ParentId, err = vxid.Decode(org.GetParentId())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// insert orgs into datastore
+	if _, err := s.conn.ModelContext(ctx, &rows).Insert(); err != nil {
+		return nil, fmt.Errorf("creating orgs: %w", err)
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Organization)
+		if err != nil {
+			return nil, err
+		}
+		orgs[i].Id = id
+		orgs[i].Version = row.GetVersion()
+	}
+
+	return orgs, nil
+}
+
 // DeleteOrg removes an organization from the Organization store
 func (s *storeImpl) DeleteOrg(ctx context.Context, id string) error {
 	vid, err := vxid.Decode(id)
@@ -169,3 +306,22 @@ func (s *storeImpl) DeleteOrg(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// DeleteOrgs removes a set of organizations from the Organization store with a single delete
+func (s *storeImpl) DeleteOrgs(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.Org)(nil)).Where("id IN (?)", pg.In(vids)).Delete(); err != nil {
+		return fmt.Errorf("deleting orgs %w", err)
+	}
+
+	return nil
+}
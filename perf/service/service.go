@@ -0,0 +1,982 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	bmkStore "github.com/wolfinger/varangian/bmk/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	instStore "github.com/wolfinger/varangian/inst/store"
+	"github.com/wolfinger/varangian/internal/benchmark"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/perf"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	txnService "github.com/wolfinger/varangian/txn/service"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type irrFlowType struct {
+	Start string
+	Flow  string
+	End   string
+}
+
+var (
+	// IrrFlowType defines the list of cash flow types in an irr schedule
+	IrrFlowType = irrFlowType{
+		Start: "start",
+		Flow:  "flow",
+		End:   "end"}
+)
+
+type attrDim struct {
+	Sector     string
+	Country    string
+	AssetClass string
+	Strat      string
+	Inst       string
+}
+
+var (
+	// AttrDim defines the list of dimensions performance can be attributed by
+	AttrDim = attrDim{
+		Sector:     "sector",
+		Country:    "country",
+		AssetClass: "asset_class",
+		Strat:      "strat_id",
+		Inst:       "inst_id"}
+)
+
+// Service interface used for implementing the Performance service
+type Service interface {
+	v1.PerfServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Performance service
+func NewService(lotStore lotStore.Store, txnStore txnStore.Store, instStore instStore.Store, bmkStore bmkStore.Store, builder *benchmark.Builder, valuer *valuation.Valuer) *PerfServiceImpl {
+	return &PerfServiceImpl{
+		lotStore:  lotStore,
+		txnStore:  txnStore,
+		instStore: instStore,
+		bmkStore:  bmkStore,
+		builder:   builder,
+		valuer:    valuer,
+	}
+}
+
+// PerfServiceImpl data structure for implementing the Performance service
+type PerfServiceImpl struct {
+	lotStore  lotStore.Store
+	txnStore  txnStore.Store
+	instStore instStore.Store
+	bmkStore  bmkStore.Store
+	builder   *benchmark.Builder
+	valuer    *valuation.Valuer
+}
+
+// RegisterServer registers the Performance service server
+func (s *PerfServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterPerfServiceServer(server, s)
+}
+
+// RegisterHandler registers the Performance service handler
+func (s *PerfServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterPerfServiceHandler(ctx, mux, conn)
+}
+
+// entity is the account, portfolio, or strategy performance is measured for
+type entity struct {
+	acctID  string
+	portID  string
+	stratID string
+}
+
+// newEntity validates that exactly one of an account, portfolio, or strategy was requested
+func newEntity(acctID string, portID string, stratID string) (*entity, error) {
+	n := 0
+	for _, id := range []string{acctID, portID, stratID} {
+		if id != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of acct_id, port_id, or strat_id expected")
+	}
+
+	return &entity{
+		acctID:  acctID,
+		portID:  portID,
+		stratID: stratID,
+	}, nil
+}
+
+// lotFilter filters the lots held by the entity
+func lotFilter(ent *entity) lotStore.LotFilter {
+	filter := lotStore.LotFilter{}
+	switch {
+	case ent.acctID != "":
+		filter.AcctID = []string{ent.acctID}
+	case ent.portID != "":
+		filter.PortID = []string{ent.portID}
+	case ent.stratID != "":
+		filter.StratID = []string{ent.stratID}
+	}
+
+	return filter
+}
+
+// lots lists every lot ever held by the entity
+func (s *PerfServiceImpl) lots(ctx context.Context, ent *entity) ([]*storage.Lot, error) {
+	lots, _, err := s.lotStore.ListLots(ctx, 0, "", lotFilter(ent).String(), "")
+
+	return lots, err
+}
+
+// lotBals lists the balances of the entity's lots between two dates (inclusive) in one pass, by date
+func (s *PerfServiceImpl) lotBals(ctx context.Context, ent *entity, start time.Time, end time.Time) (map[string][]*storage.LotBal, error) {
+	lotBals := make(map[string][]*storage.LotBal)
+	err := s.lotStore.StreamLotBals(ctx, start.Format(config.APIFormats.DateFmt), end.Format(config.APIFormats.DateFmt), lotFilter(ent).String(), func(lotBal *storage.LotBal) error {
+		lotBals[lotBal.GetLotDt()] = append(lotBals[lotBal.GetLotDt()], lotBal)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lotBals, nil
+}
+
+// hist loads the price and fx rate history needed to value the lots and flow transactions from the start
+// date through the end date (or the last flow if it settles later)
+func (s *PerfServiceImpl) hist(ctx context.Context, lots []*storage.Lot, txns []*storage.Txn, start time.Time, end time.Time, rptCcyID string) (*valuation.Hist, error) {
+	instIDs := make([]string, 0, len(lots)+2*len(txns))
+	for _, lot := range lots {
+		instIDs = append(instIDs, lot.GetInstId())
+	}
+	endDt := end.Format(config.APIFormats.DateFmt)
+	for _, txn := range txns {
+		instIDs = append(instIDs, txn.GetInstId(), txn.GetSettleAmtCcyId())
+		if dt := flowDt(txn); dt > endDt {
+			endDt = dt
+		}
+	}
+
+	return s.valuer.LoadHist(ctx, instIDs, start.Format(config.APIFormats.DateFmt), endDt, rptCcyID)
+}
+
+// flowTxns lists the processed external flow and fee transactions for the entity between two dates
+func (s *PerfServiceImpl) flowTxns(ctx context.Context, ent *entity, start time.Time, end time.Time) ([]*storage.Txn, error) {
+	filter := txnStore.TxnFilter{
+		TxnType:  []string{txnService.TxnType.Transfer, txnService.TxnType.CashFlow, txnService.TxnType.Fee},
+		State:    []string{txnService.TxnState.Processed},
+		TxnDtGTE: start.Format(config.APIFormats.DateFmt),
+		TxnDtLTE: end.Format(config.APIFormats.DateFmt),
+	}
+	switch {
+	case ent.acctID != "":
+		filter.AcctID = []string{ent.acctID}
+	case ent.portID != "":
+		filter.PortID = []string{ent.portID}
+	case ent.stratID != "":
+		filter.StratID = []string{ent.stratID}
+	}
+	txns, _, err := s.txnStore.ListTxns(ctx, 0, "", filter.String(), "")
+
+	return txns, err
+}
+
+// flowDt is the date an external flow or fee transaction hits the lots. transfers of securities move on the
+// trade date, while cash flows and fees move cash on settlement
+func flowDt(txn *storage.Txn) string {
+	if txn.GetTxnType() == txnService.TxnType.Transfer || txn.GetSettleDt() == "" {
+		return txn.GetTxnDt()
+	}
+
+	return txn.GetSettleDt()
+}
+
+// flowAmt values an external flow or fee transaction in the reporting currency on the date it hits the
+// lots. inflows are positive and outflows are negative (fees are returned as a positive amount paid)
+func flowAmt(hist *valuation.Hist, txn *storage.Txn, rptCcyID string) (string, float64, error) {
+	var amt float64
+	var ok bool
+
+	dt := flowDt(txn)
+	switch txn.GetTxnType() {
+	case txnService.TxnType.Transfer:
+		// transfers of securities are valued at the market
+		amt, ok = hist.ValueInst(dt, rptCcyID, txn.GetInstId(), txn.GetTxnSize())
+	default:
+		amt, ok = hist.ValueInst(dt, rptCcyID, txn.GetSettleAmtCcyId(), txn.GetSettleAmtNet())
+	}
+	if !ok {
+		return "", 0, status.Errorf(codes.FailedPrecondition, "unable to value txn %s in the reporting currency on %s", txn.GetId(), dt)
+	}
+
+	switch txn.GetTxnSubType() {
+	case txnService.TxnSubType.Transfer.Out, txnService.TxnSubType.CashFlow.Withdrawal:
+		amt = -amt
+	}
+
+	return dt, amt, nil
+}
+
+// mktVal values the entity's lots on a date in the reporting currency, returning false if there are no
+// lot balances for the date
+func (s *PerfServiceImpl) mktVal(ctx context.Context, lots []*storage.Lot, dt time.Time, rptCcyID string) (float64, bool, error) {
+	if len(lots) == 0 {
+		return 0, false, nil
+	}
+	lotIDs := make([]string, len(lots))
+	for i, lot := range lots {
+		lotIDs[i] = lot.GetId()
+	}
+
+	dtStr := dt.Format(config.APIFormats.DateFmt)
+	lotBals, err := s.lotStore.ListLotBals(ctx, dtStr, lotIDs)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(lotBals) == 0 {
+		return 0, false, nil
+	}
+
+	vals, err := s.valuer.ValueLots(ctx, dtStr, rptCcyID, lots, lotBals)
+	if err != nil {
+		return 0, false, err
+	}
+	mv, err := sumMktVal(vals, dtStr)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return mv, true, nil
+}
+
+// sumMktVal totals the market value of a set of lot balances in the reporting currency, failing if any lot
+// with a balance has no price
+func sumMktVal(vals []*valuation.Val, dt string) (float64, error) {
+	mv := 0.0
+	for _, val := range vals {
+		if !val.Priced && val.LotBal.GetLotSize() != 0 {
+			return 0, status.Errorf(codes.FailedPrecondition, "lot %s has no price on %s", val.LotBal.GetLotId(), dt)
+		}
+		mv += val.RptMktVal
+	}
+
+	return mv, nil
+}
+
+// inception finds the earliest date any of the lots were opened
+func inception(lots []*storage.Lot) (time.Time, error) {
+	var first time.Time
+	for _, lot := range lots {
+		dt, err := parseDt(lot.GetOrigDt())
+		if err != nil {
+			return time.Time{}, err
+		}
+		if first.IsZero() || dt.Before(first) {
+			first = dt
+		}
+	}
+
+	return first, nil
+}
+
+// days builds the daily valuations and flows for the entity between two dates. only dates with lot
+// balances are valuation dates; flows on other dates roll into the next valuation date
+func (s *PerfServiceImpl) days(ctx context.Context, ent *entity, lots []*storage.Lot, start time.Time, end time.Time, rptCcyID string) ([]perf.Day, error) {
+	txns, err := s.flowTxns(ctx, ent, start.AddDate(0, 0, 1), end)
+	if err != nil {
+		return nil, err
+	}
+
+	// load the balances, prices, and fx rates for the whole range up front and walk the days in memory
+	lotBals, err := s.lotBals(ctx, ent, start, end)
+	if err != nil {
+		return nil, err
+	}
+	hist, err := s.hist(ctx, lots, txns, start, end, rptCcyID)
+	if err != nil {
+		return nil, err
+	}
+
+	// value and bucket flows by date
+	flows := make(map[time.Time]float64)
+	fees := make(map[time.Time]float64)
+	for _, txn := range txns {
+		flowDt, amt, err := flowAmt(hist, txn, rptCcyID)
+		if err != nil {
+			return nil, err
+		}
+		dt, err := parseDt(flowDt)
+		if err != nil {
+			return nil, err
+		}
+		if txn.GetTxnType() == txnService.TxnType.Fee {
+			fees[dt] += amt
+		} else {
+			flows[dt] += amt
+		}
+	}
+
+	var days []perf.Day
+	var bmv, flow, fee float64
+	for dt := start; !dt.After(end); dt = dt.AddDate(0, 0, 1) {
+		flow += flows[dt]
+		fee += fees[dt]
+
+		dtStr := dt.Format(config.APIFormats.DateFmt)
+		if len(lotBals[dtStr]) == 0 {
+			continue
+		}
+		mv, err := sumMktVal(hist.ValueLots(dtStr, rptCcyID, lots, lotBals[dtStr]), dtStr)
+		if err != nil {
+			return nil, err
+		}
+
+		// the first valuation date only sets the beginning market value
+		if dt.Equal(start) {
+			bmv = mv
+			flow, fee = 0, 0
+			continue
+		}
+
+		days = append(days, perf.Day{
+			Dt:   dt,
+			BMV:  bmv,
+			EMV:  mv,
+			Flow: flow,
+			Fee:  fee,
+		})
+		bmv = mv
+		flow, fee = 0, 0
+	}
+
+	return days, nil
+}
+
+// GetTwr calculates daily time-weighted returns for an account, portfolio, or strategy and chain-links
+// them over the requested periods
+func (s *PerfServiceImpl) GetTwr(ctx context.Context, request *v1.GetTwrRequest) (*v1.GetTwrResponse, error) {
+	ent, err := newEntity(request.GetAcctId(), request.GetPortId(), request.GetStratId())
+	if err != nil {
+		return nil, err
+	}
+	if request.GetRptCcyId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reporting currency expected in GET")
+	}
+	end, err := parseDt(request.GetEndDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end date %s", request.GetEndDt())
+	}
+
+	lots, err := s.lots(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return nil, status.Error(codes.NotFound, "no lots found to measure performance")
+	}
+	itd, err := inception(lots)
+	if err != nil {
+		return nil, err
+	}
+
+	// determine the start of each period
+	periods, first, err := periodRanges(request.GetPeriods(), request.GetStartDt(), end, itd)
+	if err != nil {
+		return nil, err
+	}
+
+	// build the daily returns once from the earliest start
+	days, err := s.days(ctx, ent, lots, first, end, request.GetRptCcyId())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.GetTwrResponse{}
+	grossRets := make([]float64, len(days))
+	netRets := make([]float64, len(days))
+	for i, d := range days {
+		grossRets[i], netRets[i] = perf.DailyReturn(d)
+		if request.GetIncludeDays() {
+			response.Days = append(response.Days, &v1.TwrDay{
+				Dt:       d.Dt.Format(config.APIFormats.DateFmt),
+				Bmv:      d.BMV,
+				Emv:      d.EMV,
+				Flow:     d.Flow,
+				Fee:      d.Fee,
+				GrossRet: grossRets[i],
+				NetRet:   netRets[i],
+			})
+		}
+	}
+
+	// link the daily returns over each period
+	for _, pr := range periods {
+		i := sort.Search(len(days), func(i int) bool { return days[i].Dt.After(pr.start) })
+		response.Periods = append(response.Periods, &v1.TwrPeriod{
+			Period:   pr.period,
+			StartDt:  pr.start.Format(config.APIFormats.DateFmt),
+			EndDt:    end.Format(config.APIFormats.DateFmt),
+			GrossRet: perf.Link(grossRets[i:]),
+			NetRet:   perf.Link(netRets[i:]),
+		})
+	}
+
+	return response, nil
+}
+
+// periodRange is a period to link daily returns over, starting at the close of the start date
+type periodRange struct {
+	period string
+	start  time.Time
+}
+
+// periodRanges determines the start of each requested period ending on the end date (itd if none are
+// requested) plus a custom period if a start date is passed in, along with the earliest start
+func periodRanges(reqPeriods []string, startDt string, end time.Time, itd time.Time) ([]periodRange, time.Time, error) {
+	var periods []periodRange
+	if startDt != "" {
+		start, err := parseDt(startDt)
+		if err != nil {
+			return nil, time.Time{}, status.Errorf(codes.InvalidArgument, "invalid start date %s", startDt)
+		}
+		if start.Before(itd) {
+			start = itd
+		}
+		periods = append(periods, periodRange{"custom", start})
+	}
+	if len(reqPeriods) == 0 && startDt == "" {
+		reqPeriods = []string{perf.Period.ITD}
+	}
+	for _, p := range reqPeriods {
+		start, err := perf.PeriodStart(p, end, itd)
+		if err != nil {
+			return nil, time.Time{}, status.Error(codes.InvalidArgument, err.Error())
+		}
+		periods = append(periods, periodRange{p, start})
+	}
+
+	first := end
+	for _, pr := range periods {
+		if pr.start.Before(first) {
+			first = pr.start
+		}
+	}
+
+	return periods, first, nil
+}
+
+// parseDt parses the date portion of a date or timestamp string
+func parseDt(dt string) (time.Time, error) {
+	if len(dt) > len(config.APIFormats.DateFmt) {
+		dt = dt[:len(config.APIFormats.DateFmt)]
+	}
+	t, err := time.Parse(config.APIFormats.DateFmt, dt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing date %s: %w", dt, err)
+	}
+
+	return t, nil
+}
+
+// GetIrr calculates the money-weighted return (xirr) for an account, portfolio, or strategy from its
+// starting value, the external flows in between, and its ending value
+func (s *PerfServiceImpl) GetIrr(ctx context.Context, request *v1.GetIrrRequest) (*v1.GetIrrResponse, error) {
+	ent, err := newEntity(request.GetAcctId(), request.GetPortId(), request.GetStratId())
+	if err != nil {
+		return nil, err
+	}
+	if request.GetRptCcyId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reporting currency expected in GET")
+	}
+	end, err := parseDt(request.GetEndDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end date %s", request.GetEndDt())
+	}
+
+	lots, err := s.lots(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return nil, status.Error(codes.NotFound, "no lots found to measure performance")
+	}
+
+	// measure from inception unless a later start date is passed in
+	start, err := inception(lots)
+	if err != nil {
+		return nil, err
+	}
+	if request.GetStartDt() != "" {
+		reqStart, err := parseDt(request.GetStartDt())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid start date %s", request.GetStartDt())
+		}
+		if reqStart.After(start) {
+			start = reqStart
+		}
+	}
+	if !start.Before(end) {
+		return nil, status.Error(codes.InvalidArgument, "start date must be before the end date")
+	}
+
+	// build the cash flow schedule from the investor's point of view (money put in is negative)
+	startMV, ok, err := s.mktVal(ctx, lots, start, request.GetRptCcyId())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "no lot balances on %s", start.Format(config.APIFormats.DateFmt))
+	}
+	endMV, ok, err := s.mktVal(ctx, lots, end, request.GetRptCcyId())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, status.Errorf(codes.FailedPrecondition, "no lot balances on %s", end.Format(config.APIFormats.DateFmt))
+	}
+
+	response := &v1.GetIrrResponse{}
+	flows := []perf.CashFlow{{Dt: start, Amt: -startMV}}
+	response.Flows = append(response.Flows, &v1.IrrFlow{
+		Dt:       start.Format(config.APIFormats.DateFmt),
+		Amt:      -startMV,
+		FlowType: IrrFlowType.Start,
+	})
+
+	txns, err := s.flowTxns(ctx, ent, start.AddDate(0, 0, 1), end)
+	if err != nil {
+		return nil, err
+	}
+	hist, err := s.hist(ctx, nil, txns, start, end, request.GetRptCcyId())
+	if err != nil {
+		return nil, err
+	}
+	for _, txn := range txns {
+		// fees stay inside the portfolio so the irr is net of fees
+		if txn.GetTxnType() == txnService.TxnType.Fee {
+			continue
+		}
+		flowDt, amt, err := flowAmt(hist, txn, request.GetRptCcyId())
+		if err != nil {
+			return nil, err
+		}
+		dt, err := parseDt(flowDt)
+		if err != nil {
+			return nil, err
+		}
+		if dt.After(end) {
+			continue
+		}
+		flows = append(flows, perf.CashFlow{Dt: dt, Amt: -amt})
+		response.Flows = append(response.Flows, &v1.IrrFlow{
+			Dt:       flowDt,
+			Amt:      -amt,
+			FlowType: IrrFlowType.Flow,
+			TxnId:    txn.GetId(),
+		})
+	}
+
+	flows = append(flows, perf.CashFlow{Dt: end, Amt: endMV})
+	response.Flows = append(response.Flows, &v1.IrrFlow{
+		Dt:       end.Format(config.APIFormats.DateFmt),
+		Amt:      endMV,
+		FlowType: IrrFlowType.End,
+	})
+	sort.SliceStable(response.Flows, func(i, j int) bool { return response.Flows[i].GetDt() < response.Flows[j].GetDt() })
+
+	// solve for the irr, returning the schedule even when there's no solution
+	response.Roots = perf.XIRRRoots(flows)
+	irr, err := perf.XIRR(flows)
+	if err != nil {
+		if errors.Is(err, perf.ErrNoSolution) {
+			return response, nil
+		}
+		return nil, err
+	}
+	response.Solved = true
+	response.Irr = irr
+	response.PeriodRet = math.Pow(1+irr, end.Sub(start).Hours()/24/365) - 1
+
+	return response, nil
+}
+
+// segVal is the beginning and ending value of a segment over a day, holding the beginning sizes constant
+type segVal struct {
+	bmv float64
+	emv float64
+}
+
+// segKeyer returns the attribution segment a lot or instrument falls in, caching instrument look ups
+type segKeyer struct {
+	s       *PerfServiceImpl
+	groupBy string
+	insts   map[string]*storage.Inst
+}
+
+func (k *segKeyer) key(ctx context.Context, instID string, stratID string) (string, error) {
+	switch k.groupBy {
+	case AttrDim.Strat:
+		return stratID, nil
+	case AttrDim.Inst:
+		return instID, nil
+	}
+
+	inst, ok := k.insts[instID]
+	if !ok {
+		var err error
+		inst, err = k.s.instStore.GetInst(ctx, instID)
+		if err != nil {
+			return "", err
+		}
+		k.insts[instID] = inst
+	}
+	switch k.groupBy {
+	case AttrDim.Country:
+		return inst.GetCountry(), nil
+	case AttrDim.AssetClass:
+		return inst.GetAssetClass(), nil
+	default:
+		return inst.GetSector(), nil
+	}
+}
+
+// lotSegVals values the lot balances held at the close of the prior date on both the prior and current
+// date, by segment
+func lotSegVals(ctx context.Context, keyer *segKeyer, hist *valuation.Hist, lots []*storage.Lot, lotBals []*storage.LotBal, prev string, cur string, rptCcyID string) (map[string]*segVal, error) {
+	bVals := hist.ValueLots(prev, rptCcyID, lots, lotBals)
+	eVals := hist.ValueLots(cur, rptCcyID, lots, lotBals)
+
+	segs := make(map[string]*segVal)
+	for i, bVal := range bVals {
+		eVal := eVals[i]
+		if bVal.Lot == nil || bVal.LotBal.GetLotSize() == 0 {
+			continue
+		}
+		if !bVal.Priced {
+			return nil, status.Errorf(codes.FailedPrecondition, "lot %s has no price on %s", bVal.LotBal.GetLotId(), prev)
+		}
+		if !eVal.Priced {
+			return nil, status.Errorf(codes.FailedPrecondition, "lot %s has no price on %s", eVal.LotBal.GetLotId(), cur)
+		}
+
+		key, err := keyer.key(ctx, bVal.Lot.GetInstId(), bVal.Lot.GetStratId())
+		if err != nil {
+			return nil, err
+		}
+		seg, ok := segs[key]
+		if !ok {
+			seg = &segVal{}
+			segs[key] = seg
+		}
+		seg.bmv += bVal.RptMktVal
+		seg.emv += eVal.RptMktVal
+	}
+
+	return segs, nil
+}
+
+// weightSegVals values a set of benchmark weights, rebalanced daily, on both the prior and current date,
+// by segment
+func weightSegVals(ctx context.Context, keyer *segKeyer, hist *valuation.Hist, weights []*v1.BmkWeight, prev string, cur string, rptCcyID string) (map[string]*segVal, error) {
+	segs := make(map[string]*segVal)
+	for _, w := range weights {
+		bPx, ok := hist.ValueInst(prev, rptCcyID, w.GetInstId(), 1)
+		if !ok || bPx == 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "benchmark inst %s has no price on %s", w.GetInstId(), prev)
+		}
+		ePx, ok := hist.ValueInst(cur, rptCcyID, w.GetInstId(), 1)
+		if !ok {
+			return nil, status.Errorf(codes.FailedPrecondition, "benchmark inst %s has no price on %s", w.GetInstId(), cur)
+		}
+
+		key, err := keyer.key(ctx, w.GetInstId(), "")
+		if err != nil {
+			return nil, err
+		}
+		seg, ok := segs[key]
+		if !ok {
+			seg = &segVal{}
+			segs[key] = seg
+		}
+		seg.bmv += w.GetWeight()
+		seg.emv += w.GetWeight() * ePx / bPx
+	}
+
+	return segs, nil
+}
+
+// segments combines the portfolio and benchmark segment values into weights and returns
+func segments(portSegs map[string]*segVal, bmkSegs map[string]*segVal) []*perf.Segment {
+	var portTotal, bmkTotal float64
+	for _, seg := range portSegs {
+		portTotal += seg.bmv
+	}
+	for _, seg := range bmkSegs {
+		bmkTotal += seg.bmv
+	}
+
+	segMap := make(map[string]*perf.Segment)
+	var segs []*perf.Segment
+	get := func(key string) *perf.Segment {
+		seg, ok := segMap[key]
+		if !ok {
+			seg = &perf.Segment{Key: key}
+			segMap[key] = seg
+			segs = append(segs, seg)
+		}
+		return seg
+	}
+	for key, val := range portSegs {
+		seg := get(key)
+		if portTotal != 0 {
+			seg.PortWt = val.bmv / portTotal
+		}
+		if val.bmv != 0 {
+			seg.PortRet = val.emv/val.bmv - 1
+		}
+	}
+	for key, val := range bmkSegs {
+		seg := get(key)
+		if bmkTotal != 0 {
+			seg.BmkWt = val.bmv / bmkTotal
+		}
+		if val.bmv != 0 {
+			seg.BmkRet = val.emv/val.bmv - 1
+		}
+	}
+
+	return segs
+}
+
+// attrSegments converts attribution segments for the response
+func attrSegments(segs []*perf.Segment) []*v1.AttrSegment {
+	attrSegs := make([]*v1.AttrSegment, len(segs))
+	for i, seg := range segs {
+		attrSegs[i] = &v1.AttrSegment{
+			Key:         seg.Key,
+			PortWt:      seg.PortWt,
+			PortRet:     seg.PortRet,
+			BmkWt:       seg.BmkWt,
+			BmkRet:      seg.BmkRet,
+			Allocation:  seg.Allocation,
+			Selection:   seg.Selection,
+			Interaction: seg.Interaction,
+			Total:       seg.Total(),
+		}
+	}
+
+	return attrSegs
+}
+
+// GetAttribution attributes the active return of a portfolio or strategy over a benchmark to allocation,
+// selection, and interaction effects (brinson-fachler) by segment, daily and linked over the requested periods
+func (s *PerfServiceImpl) GetAttribution(ctx context.Context, request *v1.GetAttributionRequest) (*v1.GetAttributionResponse, error) {
+	ent, err := newEntity("", request.GetPortId(), request.GetStratId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of port_id or strat_id expected")
+	}
+	if request.GetRptCcyId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reporting currency expected in POST")
+	}
+	end, err := parseDt(request.GetEndDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end date %s", request.GetEndDt())
+	}
+
+	// validate the grouping dimension (group by sector if none is passed in)
+	keyer := &segKeyer{s: s, groupBy: request.GetGroupBy(), insts: make(map[string]*storage.Inst)}
+	switch keyer.groupBy {
+	case "":
+		keyer.groupBy = AttrDim.Sector
+	case AttrDim.Sector, AttrDim.Country, AttrDim.AssetClass, AttrDim.Strat, AttrDim.Inst:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "cannot attribute performance by %s", keyer.groupBy)
+	}
+
+	// validate the benchmark is at most one of a benchmark, portfolio, strategy, or set of weights
+	n := 0
+	for _, id := range []string{request.GetBmkId(), request.GetBmkPortId(), request.GetBmkStratId()} {
+		if id != "" {
+			n++
+		}
+	}
+	if len(request.GetBmkWeights()) > 0 {
+		n++
+	}
+	if n > 1 {
+		return nil, status.Error(codes.InvalidArgument, "at most one of bmk_id, bmk_port_id, bmk_strat_id, or bmk_weights expected")
+	}
+
+	// fall back to the benchmark assigned to the portfolio or strategy as of the end date
+	bmkID := request.GetBmkId()
+	if n == 0 {
+		bmkAssigns, _, err := s.bmkStore.ListBmkAssigns(ctx, 0, "", ent.portID, ent.stratID, end.Format(config.APIFormats.DateFmt))
+		if err != nil {
+			return nil, err
+		}
+		if len(bmkAssigns) == 0 {
+			return nil, status.Error(codes.FailedPrecondition, "no benchmark passed in or assigned")
+		}
+		bmkID = bmkAssigns[0].GetBmkId()
+	}
+
+	var bmkEnt *entity
+	var bmkLots []*storage.Lot
+	if request.GetBmkPortId() != "" || request.GetBmkStratId() != "" {
+		bmkEnt = &entity{portID: request.GetBmkPortId(), stratID: request.GetBmkStratId()}
+		bmkLots, err = s.lots(ctx, bmkEnt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lots, err := s.lots(ctx, ent)
+	if err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return nil, status.Error(codes.NotFound, "no lots found to attribute performance")
+	}
+	itd, err := inception(lots)
+	if err != nil {
+		return nil, err
+	}
+	periods, first, err := periodRanges(request.GetPeriods(), request.GetStartDt(), end, itd)
+	if err != nil {
+		return nil, err
+	}
+
+	// build the benchmark's daily weights once (weights at the start of a day are the prior day's close)
+	bmkWts := make(map[time.Time]map[string]float64)
+	if bmkID != "" {
+		bmkDays, err := s.builder.Days(ctx, bmkID, first, end, request.GetRptCcyId())
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range bmkDays {
+			bmkWts[day.Dt.AddDate(0, 0, -1)] = day.Wts
+		}
+	}
+
+	// load the balances, prices, and fx rates for the whole range up front and walk the days in memory
+	lotBals, err := s.lotBals(ctx, ent, first, end)
+	if err != nil {
+		return nil, err
+	}
+	bmkLotBals := make(map[string][]*storage.LotBal)
+	if bmkEnt != nil {
+		bmkLotBals, err = s.lotBals(ctx, bmkEnt, first, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var instIDs []string
+	for _, lot := range lots {
+		instIDs = append(instIDs, lot.GetInstId())
+	}
+	for _, lot := range bmkLots {
+		instIDs = append(instIDs, lot.GetInstId())
+	}
+	for _, w := range request.GetBmkWeights() {
+		instIDs = append(instIDs, w.GetInstId())
+	}
+	for _, wts := range bmkWts {
+		for instID := range wts {
+			instIDs = append(instIDs, instID)
+		}
+	}
+	hist, err := s.valuer.LoadHist(ctx, instIDs, first.Format(config.APIFormats.DateFmt), end.Format(config.APIFormats.DateFmt), request.GetRptCcyId())
+	if err != nil {
+		return nil, err
+	}
+
+	// attribute each day between valuation dates (dates with lot balances)
+	response := &v1.GetAttributionResponse{}
+	var dts []time.Time
+	var atts []*perf.Attribution
+	var prev string
+	var prevBals []*storage.LotBal
+	for dt := first; !dt.After(end); dt = dt.AddDate(0, 0, 1) {
+		cur := dt.Format(config.APIFormats.DateFmt)
+		curBals := lotBals[cur]
+		if len(curBals) == 0 {
+			continue
+		}
+		if prevBals == nil {
+			prev, prevBals = cur, curBals
+			continue
+		}
+
+		portSegs, err := lotSegVals(ctx, keyer, hist, lots, prevBals, prev, cur, request.GetRptCcyId())
+		if err != nil {
+			return nil, err
+		}
+		var bmkSegs map[string]*segVal
+		switch {
+		case bmkID != "":
+			prevDt, _ := parseDt(prev)
+			var weights []*v1.BmkWeight
+			for instID, w := range bmkWts[prevDt] {
+				weights = append(weights, &v1.BmkWeight{InstId: instID, Weight: w})
+			}
+			bmkSegs, err = weightSegVals(ctx, keyer, hist, weights, prev, cur, request.GetRptCcyId())
+		case len(request.GetBmkWeights()) > 0:
+			bmkSegs, err = weightSegVals(ctx, keyer, hist, request.GetBmkWeights(), prev, cur, request.GetRptCcyId())
+		default:
+			if len(bmkLotBals[prev]) == 0 {
+				return nil, status.Errorf(codes.FailedPrecondition, "benchmark has no lot balances on %s", prev)
+			}
+			bmkSegs, err = lotSegVals(ctx, keyer, hist, bmkLots, bmkLotBals[prev], prev, cur, request.GetRptCcyId())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		att := perf.Attribute(segments(portSegs, bmkSegs))
+		dts = append(dts, dt)
+		atts = append(atts, att)
+		if request.GetIncludeDays() {
+			response.Days = append(response.Days, &v1.AttrDay{
+				Dt:        cur,
+				PortRet:   att.PortRet,
+				BmkRet:    att.BmkRet,
+				ActiveRet: att.ActiveRet(),
+				Segments:  attrSegments(att.Segments),
+			})
+		}
+		prev, prevBals = cur, curBals
+	}
+
+	// link the daily attributions over each period
+	for _, pr := range periods {
+		i := sort.Search(len(dts), func(i int) bool { return dts[i].After(pr.start) })
+		linked := perf.LinkCarino(atts[i:])
+		response.Periods = append(response.Periods, &v1.AttrPeriod{
+			Period:    pr.period,
+			StartDt:   pr.start.Format(config.APIFormats.DateFmt),
+			EndDt:     end.Format(config.APIFormats.DateFmt),
+			PortRet:   linked.PortRet,
+			BmkRet:    linked.BmkRet,
+			ActiveRet: linked.ActiveRet(),
+			Segments:  attrSegments(linked.Segments),
+		})
+	}
+
+	return response, nil
+}
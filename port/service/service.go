@@ -5,6 +5,9 @@ import (
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/page"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	portStore "github.com/wolfinger/varangian/port/store"
 	"google.golang.org/grpc"
@@ -53,14 +56,15 @@ func (s *PortServiceImpl) GetPort(ctx context.Context, request *v1.GetPortReques
 }
 
 // ListPorts lists an array of ports from the Portfolio service
-func (s *PortServiceImpl) ListPorts(ctx context.Context, empty *v1.ListPortsRequest) (*v1.ListPortsResponse, error) {
-	ports, err := s.portStore.ListPorts(ctx)
+func (s *PortServiceImpl) ListPorts(ctx context.Context, request *v1.ListPortsRequest) (*v1.ListPortsResponse, error) {
+	ports, nextPageToken, err := s.portStore.ListPorts(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 
 	return &v1.ListPortsResponse{
-		Ports: ports,
+		Ports:         ports,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -70,7 +74,7 @@ func (s *PortServiceImpl) UpdatePort(ctx context.Context, request *v1.UpdatePort
 		return nil, err
 	}
 
-	return &v1.UpdatePortResponse{}, nil
+	return &v1.UpdatePortResponse{Version: request.GetPort().GetVersion()}, nil
 }
 
 // CreatePort creates a new portfolio via the Portfolio service
@@ -99,3 +103,101 @@ func (s *PortServiceImpl) DeletePort(ctx context.Context, request *v1.DeletePort
 
 	return &v1.DeletePortResponse{}, nil
 }
+
+// BatchCreatePorts creates a set of portfolios via the Portfolio service. the portfolios are returned in the
+// order given, and any that failed in a partial batch are returned without an id
+func (s *PortServiceImpl) BatchCreatePorts(ctx context.Context, request *v1.BatchCreatePortsRequest) (*v1.BatchCreatePortsResponse, error) {
+	ports := request.GetPorts()
+	errs, err := batch.Run(len(ports), request.GetPartial(),
+		func(i int) error {
+			if ports[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "port id is not expected in POST")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchPorts := make([]*storage.Port, len(idx))
+			for j, i := range idx {
+				batchPorts[j] = ports[i]
+			}
+			_, err := s.portStore.CreatePorts(ctx, batchPorts)
+			return err
+		},
+		func(i int) error {
+			_, err := s.portStore.CreatePorts(ctx, ports[i:i+1])
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchCreatePortsResponse{
+		Ports:  ports,
+		Errors: errs,
+	}, nil
+}
+
+// BatchUpdatePorts updates a set of portfolios via the Portfolio service
+func (s *PortServiceImpl) BatchUpdatePorts(ctx context.Context, request *v1.BatchUpdatePortsRequest) (*v1.BatchUpdatePortsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			port := requests[i].GetPort()
+			if port == nil {
+				return status.Error(codes.InvalidArgument, "port required in update")
+			}
+			port.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			ports := make([]*storage.Port, len(idx))
+			fieldMasks := make([][]string, len(idx))
+			for j, i := range idx {
+				ports[j] = requests[i].GetPort()
+				fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
+			}
+			return s.portStore.UpdatePorts(ctx, ports, fieldMasks)
+		},
+		func(i int) error {
+			return s.portStore.UpdatePort(ctx, requests[i].GetPort(), requests[i].GetUpdateMask().GetPaths())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdatePortsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetPort().GetVersion()
+		}),
+	}, nil
+}
+
+// BatchDeletePorts removes a set of portfolios from the Portfolio service
+func (s *PortServiceImpl) BatchDeletePorts(ctx context.Context, request *v1.BatchDeletePortsRequest) (*v1.BatchDeletePortsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			if ids[i] == "" {
+				return status.Error(codes.InvalidArgument, "port id required in delete")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchIDs := make([]string, len(idx))
+			for j, i := range idx {
+				batchIDs[j] = ids[i]
+			}
+			return s.portStore.DeletePorts(ctx, batchIDs)
+		},
+		func(i int) error {
+			return s.portStore.DeletePort(ctx, ids[i])
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchDeletePortsResponse{
+		Errors: errs,
+	}, nil
+}
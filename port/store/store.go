@@ -5,8 +5,13 @@ import (
 	"fmt"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,10 +22,13 @@ import (
 // Store interface used for implementing the Portfolio store
 type Store interface {
 	GetPort(ctx context.Context, id string) (*storage.Port, error)
-	ListPorts(ctx context.Context) ([]*storage.Port, error)
+	ListPorts(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Port, string, error)
 	UpdatePort(ctx context.Context, strat *storage.Port, fieldMask []string) error
 	CreatePort(ctx context.Context, strat *storage.Port) (*storage.Port, error)
 	DeletePort(ctx context.Context, id string) error
+	CreatePorts(ctx context.Context, ports []*storage.Port) ([]*storage.Port, error)
+	UpdatePorts(ctx context.Context, ports []*storage.Port, fieldMasks [][]string) error
+	DeletePorts(ctx context.Context, ids []string) error
 }
 
 // NewStore encapsulates Portfolio database operations
@@ -34,8 +42,25 @@ type storeImpl struct {
 	conn *pg.DB
 }
 
+// portKeys are the keys portfolios are listed in order of
+var portKeys = []page.Key{{Col: "id"}}
+
+// portOrderCols are the columns portfolios can be listed in order of
+var portOrderCols = []string{"id", "name"}
+
+// portFields are the fields portfolios can be filtered on
+var portFields = filterPkg.NewFields(&storage.Port{}, map[string]string{
+	"id":        vxid.PfxMap.Portfolio,
+	"parent_id": vxid.PfxMap.Portfolio,
+})
+
 // GetPort gets a port from the Portfolio service
 func (s *storeImpl) GetPort(ctx context.Context, id string) (*storage.Port, error) {
+	return getPort(ctx, s.conn, id)
+}
+
+// getPort gets a portfolio with either a connection or a database transaction
+func getPort(ctx context.Context, db orm.DB, id string) (*storage.Port, error) {
 	// convert vxids to vids
 	vid, err := vxid.Decode(id)
 	if err != nil {
@@ -43,7 +68,7 @@ func (s *storeImpl) GetPort(ctx context.Context, id string) (*storage.Port, erro
 	}
 
 	var port storage.Port
-	err = s.conn.ModelContext(ctx, &port).Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &port).Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "port with id %s not found", id)
@@ -63,41 +88,111 @@ func (s *storeImpl) GetPort(ctx context.Context, id string) (*storage.Port, erro
 	return &port, nil
 }
 
-// ListPorts lists an array of ports from the Portfolio store
-func (s *storeImpl) ListPorts(ctx context.Context) ([]*storage.Port, error) {
+// ListPorts lists a page of the portfolios matching a filter from the Portfolio store, in order of id unless
+// ordered otherwise. a page size of 0 lists every portfolio
+func (s *storeImpl) ListPorts(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Port, string, error) {
+	keys, err := page.Order(orderBy, portKeys, portOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var ports []*storage.Port
-	err := s.conn.ModelContext(ctx, &ports).Select()
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &ports), filter, portFields)
 	if err != nil {
-		return nil, fmt.Errorf("listing ports %w", err)
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter)
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing ports %w", err)
+	}
+
+	// the next page starts after the last portfolio of this one
+	var nextPageToken string
+	n, more := p.Next(len(ports))
+	ports = ports[:n]
+	if more {
+		nextPageToken = p.Token(portVals(ports[n-1], keys)...)
 	}
 
 	for _, port := range ports {
 		// convert vids to vxids
 		port.Id, err = vxid.Encode(port.GetId(), vxid.PfxMap.Portfolio)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if port.GetParentId() != "" {
 			port.ParentId, err = vxid.Encode(port.GetParentId(), vxid.PfxMap.Portfolio)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 	}
 
-	return ports, nil
+	return ports, nextPageToken, nil
+}
+
+// portVals gets the values of the keys portfolios are listed in order of from a portfolio, for page tokens
+func portVals(port *storage.Port, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = port.GetId()
+		case "name":
+			vals[i] = port.GetName()
+		}
+	}
+
+	return vals
 }
 
 // UpdatePort updates a portfolio via the Portfolio store
 func (s *storeImpl) UpdatePort(ctx context.Context, port *storage.Port, fieldMask []string) error {
+	if err := updatePort(ctx, s.conn, port, fieldMask); err != nil {
+		return err
+	}
+	port.Version++
+
+	return nil
+}
+
+// UpdatePorts updates a set of portfolios via the Portfolio store, each with its own field mask. either every
+// portfolio is updated or none are
+func (s *storeImpl) UpdatePorts(ctx context.Context, ports []*storage.Port, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for i, port := range ports {
+			if err := updatePort(ctx, tx, port, fieldMasks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, port := range ports {
+		port.Version++
+	}
+
+	return nil
+}
+
+// updatePort updates a portfolio with either a connection or a database transaction. the portfolio passed in
+// isn't changed
+func updatePort(ctx context.Context, db orm.DB, port *storage.Port, fieldMask []string) error {
 	var err error
-	tgtPort := port
+	tgtPort := proto.Clone(port).(*storage.Port)
 
 	// copy over only the fields passed in from the field mask (if provided)
 	if fieldMask != nil {
 
 		// get original port object to update
-		tgtPort, err = s.GetPort(ctx, port.GetId())
+		tgtPort, err = getPort(ctx, db, port.GetId())
 		if err != nil {
 			return err
 		}
@@ -121,10 +216,10 @@ func (s *storeImpl) UpdatePort(ctx context.Context, port *storage.Port, fieldMas
 		}
 	}
 
-	// update port in datastore
-	_, err = s.conn.ModelContext(ctx, tgtPort).WherePK().Update()
-	if err != nil {
-		return fmt.Errorf("update port %s %w", port.GetId(), err)
+	// update port in datastore if it's still at the version the caller read
+	tgtPort.Version = port.GetVersion() + 1
+	if err = version.Update(ctx, db, tgtPort, port.GetVersion(), "port "+port.GetId()); err != nil {
+		return err
 	}
 
 	return nil
@@ -160,6 +255,44 @@ func (s *storeImpl) CreatePort(ctx context.Context, port *storage.Port) (*storag
 	return port, nil
 }
 
+// CreatePorts creates a set of portfolios via the Portfolio store with a single insert. either every
+// portfolio is created or none are, and the portfolios passed in are only changed once they are
+func (s *storeImpl) CreatePorts(ctx context.Context, ports []*storage.Port) ([]*storage.Port, error) {
+	if len(ports) == 0 {
+		return ports, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Port, len(ports))
+	for i, port := range ports {
+		rows[i] = proto.Clone(port).(*storage.Port)
+		if port.GetParentId() != "" {
+			var err error
+			rows[i].ParentId, err = vxid.Decode(port.GetParentId())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// insert ports into datastore
+	if _, err := s.conn.ModelContext(ctx, &rows).Insert(); err != nil {
+		return nil, fmt.Errorf("creating ports: %w", err)
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Portfolio)
+		if err != nil {
+			return nil, err
+		}
+		ports[i].Id = id
+		ports[i].Version = row.GetVersion()
+	}
+
+	return ports, nil
+}
+
 // DeletePort removes a portfolio from the Portfolio store
 func (s *storeImpl) DeletePort(ctx context.Context, id string) error {
 	// convert vxid to vid
@@ -174,3 +307,22 @@ func (s *storeImpl) DeletePort(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// DeletePorts removes a set of portfolios from the Portfolio store with a single delete
+func (s *storeImpl) DeletePorts(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.Port)(nil)).Where("id IN (?)", pg.In(vids)).Delete(); err != nil {
+		return fmt.Errorf("deleting ports %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type posDim struct {
+	Acct  string
+	LeOrg string
+	Port  string
+	Strat string
+	Inst  string
+}
+
+var (
+	// PosDim defines the list of dimensions positions can be grouped by
+	PosDim = posDim{
+		Acct:  "acct_id",
+		LeOrg: "le_org_id",
+		Port:  "port_id",
+		Strat: "strat_id",
+		Inst:  "inst_id"}
+)
+
+// Service interface used for implementing the Position service
+type Service interface {
+	v1.PosServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Position service
+func NewService(lotStore lotStore.Store, txnStore txnStore.Store, valuer *valuation.Valuer) *PosServiceImpl {
+	return &PosServiceImpl{
+		lotStore: lotStore,
+		txnStore: txnStore,
+		valuer:   valuer,
+	}
+}
+
+// PosServiceImpl data structure for implementing the Position service
+type PosServiceImpl struct {
+	lotStore lotStore.Store
+	txnStore txnStore.Store
+	valuer   *valuation.Valuer
+}
+
+// RegisterServer registers the Position service server
+func (s *PosServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterPosServiceServer(server, s)
+}
+
+// RegisterHandler registers the Position service handler
+func (s *PosServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterPosServiceHandler(ctx, mux, conn)
+}
+
+// ListPos lists positions for a date by aggregating lot balances by the requested dimensions
+func (s *PosServiceImpl) ListPos(ctx context.Context, request *v1.ListPosRequest) (*v1.ListPosResponse, error) {
+	if request.GetDt() == "" {
+		return nil, status.Error(codes.InvalidArgument, "position date expected in GET")
+	}
+
+	// validate the grouping dimensions (group by instrument if none are passed in)
+	groupBy := request.GetGroupBy()
+	if len(groupBy) == 0 {
+		groupBy = []string{PosDim.Inst}
+	}
+	dims := make(map[string]bool)
+	for _, dim := range groupBy {
+		switch dim {
+		case PosDim.Acct, PosDim.LeOrg, PosDim.Port, PosDim.Strat, PosDim.Inst:
+			dims[dim] = true
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "cannot group positions by %s", dim)
+		}
+	}
+
+	// get lots and their balances for the date
+	lots, lotBals, err := s.listLotBals(ctx, request.GetDt(), request.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	// value lot balances (local values only if no reporting currency is passed in)
+	vals, err := s.valuer.ValueLots(ctx, request.GetDt(), request.GetRptCcyId(), lots, lotBals)
+	if err != nil {
+		return nil, err
+	}
+
+	// find the currency each lot's cost is in
+	costCcys, err := s.costCcys(ctx, lots)
+	if err != nil {
+		return nil, err
+	}
+	costRates := make(map[string]float64)
+
+	// aggregate lot balances into positions
+	posMap := make(map[string]*v1.Pos)
+	var keys []string
+	for _, val := range vals {
+		lot := val.Lot
+		if lot == nil {
+			continue
+		}
+
+		pos := &v1.Pos{Priced: true, Costed: true}
+		if dims[PosDim.Acct] {
+			pos.AcctId = lot.GetAcctId()
+		}
+		if dims[PosDim.LeOrg] {
+			pos.LeOrgId = lot.GetLeOrgId()
+		}
+		if dims[PosDim.Port] {
+			pos.PortId = lot.GetPortId()
+		}
+		if dims[PosDim.Strat] {
+			pos.StratId = lot.GetStratId()
+		}
+		if dims[PosDim.Inst] {
+			pos.InstId = lot.GetInstId()
+		}
+		// lots that didn't come from a txn are assumed to cost the currency they're priced in
+		costCcyID := costCcys[lot.GetId()]
+		if costCcyID == "" {
+			costCcyID = val.CcyID
+		}
+
+		key := strings.Join([]string{pos.AcctId, pos.LeOrgId, pos.PortId, pos.StratId, pos.InstId}, "|")
+		if p, ok := posMap[key]; ok {
+			pos = p
+		} else {
+			pos.CcyId = val.CcyID
+			pos.CostCcyId = costCcyID
+			if request.GetRptCcyId() != "" {
+				pos.CcyId = request.GetRptCcyId()
+				pos.CostCcyId = request.GetRptCcyId()
+			}
+			posMap[key] = pos
+			keys = append(keys, key)
+		}
+
+		lotBal := val.LotBal
+		pos.LotSize += lotBal.GetLotSize()
+		pos.SettledSize += lotBal.GetSettledSize()
+		pos.UnsettledSize += lotBal.GetUnsettledSize()
+
+		// cost is converted into the reporting currency at the rate on the lot's orig date. for local values
+		// a position only has a cost if every lot in it costs the same currency
+		cost := 0.0
+		if lot.GetOrigSize() != 0 {
+			cost = lot.GetOrigCost() * lotBal.GetLotSize() / lot.GetOrigSize()
+		}
+		if request.GetRptCcyId() != "" {
+			rate, ok, err := s.costRate(ctx, costCcyID, request.GetRptCcyId(), lot.GetOrigDt(), costRates)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				pos.Costed = false
+			}
+			cost *= rate
+		} else if costCcyID != pos.CostCcyId {
+			pos.Costed = false
+		}
+		pos.Cost += cost
+
+		// a position is only priced if every lot in it is priced (in the same currency for local values)
+		if !val.Priced || (request.GetRptCcyId() == "" && val.CcyID != pos.CcyId) {
+			pos.Priced = false
+		}
+		if request.GetRptCcyId() != "" {
+			pos.MktVal += val.RptMktVal
+		} else {
+			pos.MktVal += val.LocalMktVal
+		}
+
+		// drill down to the constituent lots
+		pos.LotIds = append(pos.LotIds, lot.GetId())
+		if request.GetIncludeLots() {
+			lot.Bal = []*storage.LotBal{lotBal}
+			pos.Lots = append(pos.Lots, lot)
+		}
+	}
+
+	sort.Strings(keys)
+	response := &v1.ListPosResponse{}
+	for _, key := range keys {
+		pos := posMap[key]
+		if !pos.GetPriced() {
+			pos.MktVal = 0
+		}
+		if !pos.GetCosted() {
+			pos.Cost = 0
+		}
+		response.Pos = append(response.Pos, pos)
+	}
+
+	return response, nil
+}
+
+// costCcys gets the currency each lot's cost is in, keyed by lot id. a currency holding costs its own
+// currency, while any other lot costs the trade currency of the txn it came from. lots that didn't come from
+// a txn are left out
+func (s *PosServiceImpl) costCcys(ctx context.Context, lots []*storage.Lot) (map[string]string, error) {
+	var txnIDs []string
+	seen := make(map[string]bool)
+	for _, lot := range lots {
+		if lot.GetSrcTxnId() != "" && !seen[lot.GetSrcTxnId()] {
+			seen[lot.GetSrcTxnId()] = true
+			txnIDs = append(txnIDs, lot.GetSrcTxnId())
+		}
+	}
+	costCcys := make(map[string]string)
+	if len(txnIDs) == 0 {
+		return costCcys, nil
+	}
+
+	filter := txnStore.TxnFilter{
+		ID: txnIDs,
+	}
+	txns, _, err := s.txnStore.ListTxns(ctx, 0, "", filter.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	txnMap := make(map[string]*storage.Txn)
+	for _, txn := range txns {
+		txnMap[txn.GetId()] = txn
+	}
+
+	for _, lot := range lots {
+		txn, ok := txnMap[lot.GetSrcTxnId()]
+		if !ok {
+			continue
+		}
+		switch lot.GetInstId() {
+		case txn.GetTradeAmtCcyId(), txn.GetSettleAmtCcyId():
+			costCcys[lot.GetId()] = lot.GetInstId()
+		default:
+			costCcys[lot.GetId()] = txn.GetTradeAmtCcyId()
+			if costCcys[lot.GetId()] == "" {
+				costCcys[lot.GetId()] = txn.GetSettleAmtCcyId()
+			}
+		}
+	}
+
+	return costCcys, nil
+}
+
+// costRate gets the rate to convert a lot's cost into the reporting currency on the lot's orig date,
+// returning false if there isn't one. rates are cached by currency and date
+func (s *PosServiceImpl) costRate(ctx context.Context, ccyID string, rptCcyID string, dt string, rates map[string]float64) (float64, bool, error) {
+	switch ccyID {
+	case "":
+		return 0, false, nil
+	case rptCcyID:
+		return 1, true, nil
+	}
+
+	key := ccyID + "|" + dt
+	if rate, ok := rates[key]; ok {
+		return rate, rate != 0, nil
+	}
+	rate, err := s.valuer.FxRate(ctx, ccyID, rptCcyID, dt)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return 0, false, err
+	}
+	rates[key] = rate
+
+	return rate, rate != 0, nil
+}
+
+// listLotBals gets the lots matching a lot filter and their balances for a date
+func (s *PosServiceImpl) listLotBals(ctx context.Context, dt string, filter string) ([]*storage.Lot, []*storage.LotBal, error) {
+	// no filter so pull every balance for the date and the lots they belong to
+	if filter == "" {
+		lotBals, err := s.lotStore.ListLotBals(ctx, dt, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		lotIDs := make([]string, len(lotBals))
+		for i, lotBal := range lotBals {
+			lotIDs[i] = lotBal.GetLotId()
+		}
+		lots, err := s.valuer.ListLots(ctx, lotIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lots, lotBals, nil
+	}
+
+	lots, _, err := s.lotStore.ListLots(ctx, 0, "", filter, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lots) == 0 {
+		return nil, nil, nil
+	}
+	lotIDs := make([]string, len(lots))
+	for i, lot := range lots {
+		lotIDs[i] = lot.GetId()
+	}
+	lotBals, err := s.lotStore.ListLotBals(ctx, dt, lotIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lots, lotBals, nil
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	fxStore "github.com/wolfinger/varangian/fx/store"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/valuation"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	priceStore "github.com/wolfinger/varangian/price/store"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// has gets the values a filter built with the stores' filter helpers restricts a field to
+func has(filter string, field string) map[string]bool {
+	vals := make(map[string]bool)
+	if filter == "" {
+		return vals
+	}
+	e, err := filterPkg.Parse(filter)
+	if err != nil {
+		panic(err)
+	}
+	exprs := []*filterPkg.Expr{e}
+	if e.Op == filterPkg.And {
+		exprs = e.Exprs
+	}
+	for _, x := range exprs {
+		if x.Field == field {
+			for _, val := range x.Vals {
+				vals[val.Text] = true
+			}
+		}
+	}
+	return vals
+}
+
+// fakeLotStore keeps lots with their balances on a single date. it embeds the interface for the methods
+// positions don't use
+type fakeLotStore struct {
+	lotStore.Store
+	lots    []*storage.Lot
+	lotBals []*storage.LotBal
+}
+
+func (f *fakeLotStore) ListLots(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Lot, string, error) {
+	ids := has(filter, "id")
+	var lots []*storage.Lot
+	for _, lot := range f.lots {
+		if len(ids) == 0 || ids[lot.GetId()] {
+			lots = append(lots, lot)
+		}
+	}
+	return lots, "", nil
+}
+
+func (f *fakeLotStore) ListLotBals(ctx context.Context, dt string, ids []string) ([]*storage.LotBal, error) {
+	return f.lotBals, nil
+}
+
+// fakeTxnStore keeps the txns lots came from
+type fakeTxnStore struct {
+	txnStore.Store
+	txns []*storage.Txn
+}
+
+func (f *fakeTxnStore) ListTxns(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Txn, string, error) {
+	ids := has(filter, "id")
+	var txns []*storage.Txn
+	for _, txn := range f.txns {
+		if ids[txn.GetId()] {
+			txns = append(txns, txn)
+		}
+	}
+	return txns, "", nil
+}
+
+// fakePriceStore keeps one price per instrument
+type fakePriceStore struct {
+	priceStore.Store
+	prices []*storage.Price
+}
+
+func (f *fakePriceStore) ListPrices(ctx context.Context, pageSize int32, pageToken string, instIDs []string, dt string, sources []string) ([]*storage.Price, string, error) {
+	return f.prices, "", nil
+}
+
+// fakeFxStore keeps the rates stored directly, by currency pair and date
+type fakeFxStore struct {
+	fxStore.Store
+	rates map[string]float64
+}
+
+func (f *fakeFxStore) GetFxRate(ctx context.Context, fromID string, toID string, dt string, viaID string) (*storage.FxRate, error) {
+	rate, ok := f.rates[fromID+"/"+toID+"/"+dt]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "fx rate %s/%s not found on %s", fromID, toID, dt)
+	}
+	return &storage.FxRate{BaseCcyId: fromID, QuoteCcyId: toID, RateDt: dt, Rate: rate}, nil
+}
+
+// newTestService creates a Position service over two accounts holding inst_a, priced in usd, one bought in
+// eur and one in usd, and an unpriced inst_b
+func newTestService() *PosServiceImpl {
+	lots := &fakeLotStore{
+		lots: []*storage.Lot{
+			{Id: "lot_1", InstId: "inst_a", AcctId: "acct_a", SrcTxnId: "txn_1", OrigDt: "2021-01-04", OrigSize: 10, OrigCost: 100},
+			{Id: "lot_2", InstId: "inst_a", AcctId: "acct_b", SrcTxnId: "txn_2", OrigDt: "2021-01-05", OrigSize: 20, OrigCost: 300},
+			{Id: "lot_3", InstId: "inst_b", AcctId: "acct_a", SrcTxnId: "txn_3", OrigDt: "2021-01-05", OrigSize: 5, OrigCost: 50},
+		},
+		lotBals: []*storage.LotBal{
+			{LotId: "lot_1", LotSize: 5, SettledSize: 5},
+			{LotId: "lot_2", LotSize: 20, SettledSize: 10, UnsettledSize: 10},
+			{LotId: "lot_3", LotSize: 5, SettledSize: 5},
+		},
+	}
+	txns := &fakeTxnStore{txns: []*storage.Txn{
+		{Id: "txn_1", TradeAmtCcyId: "eur", SettleAmtCcyId: "eur"},
+		{Id: "txn_2", TradeAmtCcyId: "usd", SettleAmtCcyId: "usd"},
+		{Id: "txn_3", TradeAmtCcyId: "usd", SettleAmtCcyId: "usd"},
+	}}
+	prices := &fakePriceStore{prices: []*storage.Price{{InstId: "inst_a", Close: 16, CcyId: "usd"}}}
+	fx := &fakeFxStore{rates: map[string]float64{
+		"eur/usd/2021-01-04": 1.2,
+		"eur/usd/2021-01-08": 1.1,
+	}}
+
+	return NewService(lots, txns, valuation.NewValuer(lots, prices, fx, "usd"))
+}
+
+// findPos finds the position of an account (empty if not grouped by account) in an instrument
+func findPos(res *v1.ListPosResponse, acctID string, instID string) *v1.Pos {
+	for _, pos := range res.GetPos() {
+		if pos.GetAcctId() == acctID && pos.GetInstId() == instID {
+			return pos
+		}
+	}
+	return nil
+}
+
+func TestListPosGroupBy(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+
+	res, err := s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := findPos(res, "", "inst_a")
+	if len(res.GetPos()) != 2 || pos == nil || pos.GetLotSize() != 25 || pos.GetSettledSize() != 15 ||
+		pos.GetUnsettledSize() != 10 || len(pos.GetLotIds()) != 2 {
+		t.Errorf("positions by instrument got: %v, want inst_a summed across both accounts", res.GetPos())
+	}
+
+	res, err = s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08", GroupBy: []string{PosDim.Acct, PosDim.Inst}, IncludeLots: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos = findPos(res, "acct_b", "inst_a")
+	if len(res.GetPos()) != 3 || pos == nil || pos.GetLotSize() != 20 || len(pos.GetLots()) != 1 || pos.GetLots()[0].GetId() != "lot_2" {
+		t.Errorf("positions by account and instrument got: %v, want one per account and instrument", res.GetPos())
+	}
+
+	if _, err = s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08", GroupBy: []string{"txn_id"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("grouping by an unknown dimension got: %v, want: %v", err, codes.InvalidArgument)
+	}
+	if _, err = s.ListPos(ctx, &v1.ListPosRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("positions without a date got: %v, want: %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestListPosCost(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+
+	// lots costing eur and usd have no local cost between them, while each on its own does
+	res, err := s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := findPos(res, "", "inst_a")
+	if pos.GetCosted() || pos.GetCost() != 0 {
+		t.Errorf("mixed currency cost got: %v %s (costed %t), want it not costed", pos.GetCost(), pos.GetCostCcyId(), pos.GetCosted())
+	}
+	res, err = s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08", GroupBy: []string{PosDim.Acct, PosDim.Inst}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos = findPos(res, "acct_a", "inst_a"); !pos.GetCosted() || pos.GetCost() != 50 || pos.GetCostCcyId() != "eur" {
+		t.Errorf("eur cost got: %v %s (costed %t), want: 50 eur", pos.GetCost(), pos.GetCostCcyId(), pos.GetCosted())
+	}
+
+	// in a reporting currency, each lot's cost is converted at the rate on its orig date rather than the
+	// position date
+	res, err = s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08", RptCcyId: "usd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos = findPos(res, "", "inst_a")
+	if !pos.GetCosted() || math.Abs(pos.GetCost()-(50*1.2+300)) > 1e-9 || pos.GetCostCcyId() != "usd" {
+		t.Errorf("reporting currency cost got: %v %s (costed %t), want: %v usd", pos.GetCost(), pos.GetCostCcyId(), pos.GetCosted(), 50*1.2+300)
+	}
+	if !pos.GetPriced() || pos.GetMktVal() != 25*16 {
+		t.Errorf("reporting currency market value got: %v (priced %t), want: %v", pos.GetMktVal(), pos.GetPriced(), 25*16)
+	}
+
+	// without a rate on a lot's orig date its cost can't be converted
+	res, err = s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08", RptCcyId: "gbp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos = findPos(res, "", "inst_a"); pos.GetCosted() || pos.GetCost() != 0 {
+		t.Errorf("cost without an fx rate got: %v (costed %t), want it not costed", pos.GetCost(), pos.GetCosted())
+	}
+}
+
+func TestListPosUnpriced(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+
+	for _, rptCcyID := range []string{"", "usd"} {
+		res, err := s.ListPos(ctx, &v1.ListPosRequest{Dt: "2021-01-08", RptCcyId: rptCcyID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := findPos(res, "", "inst_b")
+		if pos == nil || pos.GetPriced() || pos.GetMktVal() != 0 || pos.GetLotSize() != 5 {
+			t.Errorf("unpriced position in %q got: %v, want it sized but not priced", rptCcyID, pos)
+		}
+		if !pos.GetCosted() || pos.GetCost() != 50 {
+			t.Errorf("unpriced position in %q got cost: %v (costed %t), want: 50", rptCcyID, pos.GetCost(), pos.GetCosted())
+		}
+	}
+}
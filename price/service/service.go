@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	priceStore "github.com/wolfinger/varangian/price/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Service interface used for implementing the Price service
+type Service interface {
+	v1.PriceServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Price service
+func NewService(priceStore priceStore.Store) *PriceServiceImpl {
+	return &PriceServiceImpl{
+		priceStore: priceStore,
+	}
+}
+
+// PriceServiceImpl data structure for implementing the Price service
+type PriceServiceImpl struct {
+	priceStore priceStore.Store
+}
+
+// RegisterServer registers the Price service server
+func (s *PriceServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterPriceServiceServer(server, s)
+}
+
+// RegisterHandler registers the Price service handler
+func (s *PriceServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterPriceServiceHandler(ctx, mux, conn)
+}
+
+// GetPrice gets the latest price for an instrument on or before a date from the Price service
+func (s *PriceServiceImpl) GetPrice(ctx context.Context, request *v1.GetPriceRequest) (*v1.GetPriceResponse, error) {
+	dt, err := priceDt(request.GetDt())
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := s.priceStore.GetPrice(ctx, request.GetInstId(), dt, request.GetSources())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetPriceResponse{
+		Price: price,
+	}, nil
+}
+
+// ListPrices lists the latest prices for a set of instruments on or before a date from the Price service
+func (s *PriceServiceImpl) ListPrices(ctx context.Context, request *v1.ListPricesRequest) (*v1.ListPricesResponse, error) {
+	dt, err := priceDt(request.GetDt())
+	if err != nil {
+		return nil, err
+	}
+
+	prices, nextPageToken, err := s.priceStore.ListPrices(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetInstIds(), dt, request.GetSources())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListPricesResponse{
+		Prices:        prices,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// UpsertPrices inserts or replaces a set of prices via the Price service
+func (s *PriceServiceImpl) UpsertPrices(ctx context.Context, request *v1.UpsertPricesRequest) (*v1.UpsertPricesResponse, error) {
+	if len(request.GetPrices()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "prices required in POST")
+	}
+
+	for i, price := range request.GetPrices() {
+		if price.GetInstId() == "" || price.GetPriceDt() == "" || price.GetSource() == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "price %d requires an inst_id, price_dt, and source", i)
+		}
+		if _, err := time.Parse(config.APIFormats.DateFmt, price.GetPriceDt()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "price %d has an invalid price_dt %s", i, price.GetPriceDt())
+		}
+	}
+
+	// copy the prices so the request is left untouched
+	prices := make([]*storage.Price, len(request.GetPrices()))
+	for i, price := range request.GetPrices() {
+		prices[i] = proto.Clone(price).(*storage.Price)
+
+		// derive the mid from the bid / ask if it wasn't passed in
+		if prices[i].GetMid() == 0 && prices[i].GetBid() != 0 && prices[i].GetAsk() != 0 {
+			prices[i].Mid = (prices[i].GetBid() + prices[i].GetAsk()) / 2
+		}
+	}
+
+	if err := s.priceStore.UpsertPrices(ctx, prices); err != nil {
+		return nil, err
+	}
+
+	return &v1.UpsertPricesResponse{
+		Count: int32(len(prices)),
+	}, nil
+}
+
+// DeletePrice removes a price from the Price service
+func (s *PriceServiceImpl) DeletePrice(ctx context.Context, request *v1.DeletePriceRequest) (*v1.DeletePriceResponse, error) {
+	if request.GetDt() == "" {
+		return nil, status.Error(codes.InvalidArgument, "price date expected in DELETE")
+	}
+
+	if err := s.priceStore.DeletePrice(ctx, request.GetInstId(), request.GetDt(), request.GetSource()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeletePriceResponse{}, nil
+}
+
+// priceDt validates a requested price date, defaulting to today
+func priceDt(dt string) (string, error) {
+	if dt == "" {
+		return time.Now().Format(config.APIFormats.DateFmt), nil
+	}
+	if _, err := time.Parse(config.APIFormats.DateFmt, dt); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid price date %s", dt)
+	}
+
+	return dt, nil
+}
@@ -0,0 +1,337 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/golang/protobuf/proto"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store interface used for implementing the Price store
+type Store interface {
+	GetPrice(ctx context.Context, instID string, dt string, sources []string) (*storage.Price, error)
+	ListPrices(ctx context.Context, pageSize int32, pageToken string, instIDs []string, dt string, sources []string) ([]*storage.Price, string, error)
+	ListPriceHist(ctx context.Context, instIDs []string, startDt string, endDt string) ([]*storage.Price, error)
+	UpsertPrices(ctx context.Context, prices []*storage.Price) error
+	DeletePrice(ctx context.Context, instID string, dt string, source string) error
+}
+
+// NewStore encapsulates Price database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn *pg.DB
+}
+
+// priceKeys are the keys the latest prices are listed in order of, as there's one for each instrument
+var priceKeys = []page.Key{{Col: "inst_id"}}
+
+// srcPriority returns the source hierarchy to order prices by and whether the sources should also filter
+func srcPriority(sources []string) ([]string, bool) {
+	if len(sources) > 0 {
+		return sources, true
+	}
+	return config.PriceSettings.SrcPriority, false
+}
+
+// GetPrice gets the latest price on or before a given date from the Price store, falling back to the
+// instrument's proxy if the instrument has no price of its own
+func (s *storeImpl) GetPrice(ctx context.Context, instID string, dt string, sources []string) (*storage.Price, error) {
+	// convert vxid to vid
+	vid, err := vxid.Decode(instID)
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := s.getPrice(ctx, vid, dt, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// fallback to the proxy instrument's price
+	if price == nil {
+		var proxyVid string
+		err = s.conn.ModelContext(ctx, (*storage.Inst)(nil)).Column("proxy_inst").Where("id = ?", vid).Select(&proxyVid)
+		if err != nil && err != pg.ErrNoRows {
+			return nil, err
+		}
+		if proxyVid != "" {
+			price, err = s.getPrice(ctx, proxyVid, dt, sources)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if price == nil {
+			return nil, status.Errorf(codes.NotFound, "price for inst %s on or before %s not found", instID, dt)
+		}
+		price.ProxyInstId, err = vxid.Encode(proxyVid, vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+	}
+	price.InstId = instID
+
+	// convert vids to vxids
+	if price.GetCcyId() != "" {
+		price.CcyId, err = vxid.Encode(price.GetCcyId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return price, nil
+}
+
+// getPrice selects the as-of price for an instrument vid, returning nil if no price exists
+func (s *storeImpl) getPrice(ctx context.Context, vid string, dt string, sources []string) (*storage.Price, error) {
+	srcs, filterFlag := srcPriority(sources)
+
+	var price storage.Price
+	q := s.conn.ModelContext(ctx, &price).ColumnExpr("*, price_dt::date").Where("inst_id = ?", vid).Where("price_dt <= ?", dt)
+	if filterFlag {
+		q.Where("source IN (?)", pg.In(srcs))
+	}
+	err := q.OrderExpr("price.price_dt DESC, array_position(?, source)", pg.Array(srcs)).Limit(1).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getting price: %w", err)
+	}
+
+	return &price, nil
+}
+
+// ListPrices lists a page of the latest prices on or before a given date for a set of instruments from the Price
+// store in order of instrument. a page size of 0 lists every price
+func (s *storeImpl) ListPrices(ctx context.Context, pageSize int32, pageToken string, instIDs []string, dt string, sources []string) ([]*storage.Price, string, error) {
+	var err error
+	var vids []string
+	var prices []*storage.Price
+
+	srcs, filterFlag := srcPriority(sources)
+
+	q := s.conn.ModelContext(ctx, &prices).DistinctOn("inst_id").ColumnExpr("*, price_dt::date").Where("price_dt <= ?", dt)
+	if len(instIDs) > 0 {
+		vids, err = vxid.Decodes(instIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("inst_id IN (?)", pg.In(vids))
+	}
+	if filterFlag {
+		q.Where("source IN (?)", pg.In(srcs))
+	}
+	p := page.NewPager(pageSize, priceKeys, page.Query(strings.Join(instIDs, ","), dt, strings.Join(sources, ",")))
+	start, err := p.Start(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	err = q.OrderExpr("price.price_dt DESC, array_position(?, source)", pg.Array(srcs)).Select()
+	if err != nil {
+		return nil, "", fmt.Errorf("listing prices: %w", err)
+	}
+
+	// the next page starts after the last instrument of this one
+	var nextPageToken, last string
+	n, more := p.Next(len(prices))
+	prices = prices[:n]
+	if more {
+		last = prices[n-1].GetInstId()
+		nextPageToken = p.Token(last)
+	}
+
+	found := make(map[string]bool)
+	for _, price := range prices {
+		found[price.GetInstId()] = true
+
+		// convert vids to vxids
+		price.InstId, err = vxid.Encode(price.GetInstId(), vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, "", err
+		}
+		if price.GetCcyId() != "" {
+			price.CcyId, err = vxid.Encode(price.GetCcyId(), vxid.PfxMap.Instrument)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	// look up proxy prices for any requested instruments in the page's range without a price of their own
+	for i, vid := range vids {
+		if found[vid] || (start != nil && vid <= *start[0]) || (last != "" && vid > last) {
+			continue
+		}
+		price, err := s.GetPrice(ctx, instIDs[i], dt, sources)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return nil, "", err
+		}
+		prices = append(prices, price)
+	}
+
+	return prices, nextPageToken, nil
+}
+
+// ListPriceHist lists the prices of a set of instruments between two dates (inclusive) from the Price store,
+// starting from the latest price on or before the start date, in date order for each instrument. only the
+// highest priority source is listed for each date, and instruments with a proxy also get the proxy's prices
+// with proxy_inst_id set
+func (s *storeImpl) ListPriceHist(ctx context.Context, instIDs []string, startDt string, endDt string) ([]*storage.Price, error) {
+	if len(instIDs) == 0 {
+		return nil, nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(instIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// look up the proxies of the instruments so their prices are selected along with the instruments'
+	var insts []*storage.Inst
+	err = s.conn.ModelContext(ctx, &insts).Column("id", "proxy_inst").Where("id IN (?)", pg.In(vids)).Where("proxy_inst IS NOT NULL").Select()
+	if err != nil {
+		return nil, fmt.Errorf("listing proxy insts: %w", err)
+	}
+	proxied := make(map[string][]string)
+	qVids := vids
+	for _, inst := range insts {
+		if _, ok := proxied[inst.GetProxyInst()]; !ok {
+			qVids = append(qVids, inst.GetProxyInst())
+		}
+		proxied[inst.GetProxyInst()] = append(proxied[inst.GetProxyInst()], inst.GetId())
+	}
+
+	var rows []*storage.Price
+	err = s.conn.ModelContext(ctx, &rows).
+		DistinctOn("inst_id, price.price_dt").
+		ColumnExpr("*, price_dt::date").
+		Where("inst_id IN (?)", pg.In(qVids)).
+		Where("price_dt <= ?", endDt).
+		Where("price_dt >= coalesce((SELECT max(p.price_dt) FROM prices AS p WHERE p.inst_id = price.inst_id AND p.price_dt <= ?), ?)", startDt, startDt).
+		OrderExpr("inst_id, price.price_dt, array_position(?, source)", pg.Array(config.PriceSettings.SrcPriority)).
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("listing price hist: %w", err)
+	}
+
+	requested := make(map[string]bool)
+	for _, vid := range vids {
+		requested[vid] = true
+	}
+
+	var prices []*storage.Price
+	for _, row := range rows {
+		// convert vids to vxids
+		vid := row.GetInstId()
+		row.InstId, err = vxid.Encode(vid, vxid.PfxMap.Instrument)
+		if err != nil {
+			return nil, err
+		}
+		if row.GetCcyId() != "" {
+			row.CcyId, err = vxid.Encode(row.GetCcyId(), vxid.PfxMap.Instrument)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if requested[vid] {
+			prices = append(prices, row)
+		}
+
+		// copy proxy prices to each instrument proxied by them
+		for _, instVid := range proxied[vid] {
+			price := proto.Clone(row).(*storage.Price)
+			price.ProxyInstId = row.GetInstId()
+			price.InstId, err = vxid.Encode(instVid, vxid.PfxMap.Instrument)
+			if err != nil {
+				return nil, err
+			}
+			prices = append(prices, price)
+		}
+	}
+
+	return prices, nil
+}
+
+// UpsertPrices inserts or replaces a set of prices in bulk via the Price store
+func (s *storeImpl) UpsertPrices(ctx context.Context, prices []*storage.Price) error {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Price, len(prices))
+	for i, price := range prices {
+		rows[i] = proto.Clone(price).(*storage.Price)
+		if err := decodePriceIDs(rows[i]); err != nil {
+			return err
+		}
+	}
+
+	// insert prices in datastore, replacing any existing price for the same inst, date, and source
+	_, err := s.conn.ModelContext(ctx, &rows).
+		OnConflict("(inst_id, price_dt, source) DO UPDATE").
+		Set("close = EXCLUDED.close, bid = EXCLUDED.bid, ask = EXCLUDED.ask, mid = EXCLUDED.mid, ccy_id = EXCLUDED.ccy_id").
+		Insert()
+	if err != nil {
+		return fmt.Errorf("upserting prices: %w", err)
+	}
+
+	return nil
+}
+
+// decodePriceIDs converts the vxids on a price to vids
+func decodePriceIDs(price *storage.Price) error {
+	var err error
+
+	price.InstId, err = vxid.Decode(price.GetInstId())
+	if err != nil {
+		return err
+	}
+	if price.GetCcyId() != "" {
+		price.CcyId, err = vxid.Decode(price.GetCcyId())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeletePrice removes a price from the Price store
+func (s *storeImpl) DeletePrice(ctx context.Context, instID string, dt string, source string) error {
+	// convert vxid to vid
+	vid, err := vxid.Decode(instID)
+	if err != nil {
+		return err
+	}
+
+	q := s.conn.ModelContext(ctx, (*storage.Price)(nil)).Where("inst_id = ?", vid).Where("price_dt = ?", dt)
+	if source != "" {
+		q.Where("source = ?", source)
+	}
+	if _, err = q.Delete(); err != nil {
+		return fmt.Errorf("deleting price for inst %s on %s: %w", instID, dt, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/vxid"
+)
+
+// testStore connects to the database in TEST_DB_CONN_STR, skipping the test if it isn't set. prices are
+// written to a temp table that shadows the real one, so the pool is kept to a single connection
+func testStore(t *testing.T) *storeImpl {
+	connStr := os.Getenv("TEST_DB_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+
+	opt, err := pg.ParseURL(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.PoolSize = 1
+
+	conn := pg.Connect(opt)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Exec(`CREATE TEMP TABLE prices (
+		inst_id uuid NOT NULL,
+		price_dt timestamptz NOT NULL,
+		source text NOT NULL,
+		close float8,
+		bid float8,
+		ask float8,
+		mid float8,
+		ccy_id uuid,
+		PRIMARY KEY (inst_id, price_dt, source))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &storeImpl{conn: conn}
+}
+
+func TestPrices(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t)
+
+	instID, _ := vxid.Encode("6f1c2bd4-9a55-4a7e-8f0e-0d3b1a2c4e51", vxid.PfxMap.Instrument)
+	prices := []*storage.Price{
+		{InstId: instID, PriceDt: "2021-01-04", Source: "vendor", Close: 10},
+		{InstId: instID, PriceDt: "2021-01-05", Source: "vendor", Close: 11},
+		{InstId: instID, PriceDt: "2021-01-05", Source: "manual", Close: 12},
+		{InstId: instID, PriceDt: "2021-01-07", Source: "model", Close: 13},
+	}
+	if err := s.UpsertPrices(ctx, prices); err != nil {
+		t.Fatal(err)
+	}
+	if prices[0].GetInstId() != instID {
+		t.Errorf("UpsertPrices changed inst_id to %s", prices[0].GetInstId())
+	}
+
+	tests := []struct {
+		dt      string
+		sources []string
+		source  string
+		priceDt string
+		close   float64
+	}{
+		{"2021-01-04", nil, "vendor", "2021-01-04", 10},
+		{"2021-01-05", nil, "manual", "2021-01-05", 12},
+		{"2021-01-06", nil, "manual", "2021-01-05", 12},
+		{"2021-01-06", []string{"vendor"}, "vendor", "2021-01-05", 11},
+		{"2021-01-08", nil, "model", "2021-01-07", 13},
+	}
+	for _, test := range tests {
+		price, err := s.GetPrice(ctx, instID, test.dt, test.sources)
+		if err != nil {
+			t.Fatalf("GetPrice(%s, %v): %v", test.dt, test.sources, err)
+		}
+		if price.GetSource() != test.source || price.GetPriceDt() != test.priceDt || price.GetClose() != test.close {
+			t.Errorf("GetPrice(%s, %v) = %s %s %v, want %s %s %v", test.dt, test.sources, price.GetSource(),
+				price.GetPriceDt(), price.GetClose(), test.source, test.priceDt, test.close)
+		}
+
+		list, _, err := s.ListPrices(ctx, 0, "", []string{instID}, test.dt, test.sources)
+		if err != nil {
+			t.Fatalf("ListPrices(%s, %v): %v", test.dt, test.sources, err)
+		}
+		if len(list) != 1 || list[0].GetSource() != test.source || list[0].GetClose() != test.close {
+			t.Errorf("ListPrices(%s, %v) = %v, want one %s price of %v", test.dt, test.sources, list, test.source,
+				test.close)
+		}
+	}
+}
@@ -0,0 +1,953 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	acctStore "github.com/wolfinger/varangian/acct/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	instStore "github.com/wolfinger/varangian/inst/store"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/recon"
+	"github.com/wolfinger/varangian/internal/swift"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
+	reconStore "github.com/wolfinger/varangian/recon/store"
+	txnService "github.com/wolfinger/varangian/txn/service"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type breakState struct {
+	Open      string
+	Explained string
+	Resolved  string
+}
+
+type reconBasis struct {
+	Settled string
+	Total   string
+}
+
+type secIDType struct {
+	Isin   string
+	Cusip  string
+	Ticker string
+}
+
+var (
+	// BreakState defines the workflow states of a break. new breaks are open; an explained break is
+	// understood but not yet fixed; a resolved break is closed, and reopens if it recurs on a rerun
+	BreakState = breakState{
+		Open:      "open",
+		Explained: "explained",
+		Resolved:  "resolved"}
+
+	// ReconBasis defines the lot balance sizes custodian positions can be compared against
+	ReconBasis = reconBasis{
+		Settled: "settled",
+		Total:   "total"}
+
+	// SecIDType defines the kinds of security identifiers a custodian can report positions by
+	SecIDType = secIDType{
+		Isin:   "isin",
+		Cusip:  "cusip",
+		Ticker: "ticker"}
+
+	// breakTransitions lists the states a break can move to from each state
+	breakTransitions = map[string][]string{
+		BreakState.Open:      {BreakState.Explained, BreakState.Resolved},
+		BreakState.Explained: {BreakState.Open, BreakState.Resolved},
+		BreakState.Resolved:  {BreakState.Open},
+	}
+)
+
+// Service interface used for implementing the Reconciliation service
+type Service interface {
+	v1.ReconServiceServer
+	grpcPkg.Service
+}
+
+// NewService creates new Reconciliation service
+func NewService(reconStore reconStore.Store, acctStore acctStore.Store, instStore instStore.Store, lotStore lotStore.Store, txnStore txnStore.Store) *ReconServiceImpl {
+	return &ReconServiceImpl{
+		reconStore: reconStore,
+		acctStore:  acctStore,
+		instStore:  instStore,
+		lotStore:   lotStore,
+		txnStore:   txnStore,
+	}
+}
+
+// ReconServiceImpl data structure for implementing the Reconciliation service
+type ReconServiceImpl struct {
+	reconStore reconStore.Store
+	acctStore  acctStore.Store
+	instStore  instStore.Store
+	lotStore   lotStore.Store
+	txnStore   txnStore.Store
+}
+
+// RegisterServer registers the Reconciliation service server
+func (s *ReconServiceImpl) RegisterServer(server *grpc.Server) {
+	v1.RegisterReconServiceServer(server, s)
+}
+
+// RegisterHandler registers the Reconciliation service handler
+func (s *ReconServiceImpl) RegisterHandler(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+	return v1.RegisterReconServiceHandler(ctx, mux, conn)
+}
+
+// LoadCustPoss loads a custodian's positions, mapping its account references and security identifiers to
+// accounts and instruments, via the Reconciliation service. positions that can't be mapped are still loaded
+// and come up as unmapped breaks when reconciled
+func (s *ReconServiceImpl) LoadCustPoss(ctx context.Context, request *v1.LoadCustPossRequest) (*v1.LoadCustPossResponse, error) {
+	custPoss := request.GetCustPoss()
+	if len(custPoss) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "cust_poss required in POST")
+	}
+	for _, custPos := range custPoss {
+		if custPos.GetId() != "" {
+			return nil, status.Error(codes.InvalidArgument, "cust pos id is not expected in POST")
+		}
+		if _, err := time.Parse(config.APIFormats.DateFmt, custPos.GetDt()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid cust pos date %s", custPos.GetDt())
+		}
+		if custPos.GetAcctRef() == "" || custPos.GetSecId() == "" {
+			return nil, status.Error(codes.InvalidArgument, "cust pos expects acct_ref and sec_id")
+		}
+		if !validSecIDType(custPos.GetSecIdType()) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid sec_id_type %s", custPos.GetSecIdType())
+		}
+	}
+
+	m, err := s.newMapper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var unmapped int32
+	for _, custPos := range custPoss {
+		custPos.AcctId = m.accts[custPos.GetAcctRef()]
+		custPos.InstId = m.inst(custPos.GetSecId(), custPos.GetSecIdType())
+		if custPos.GetAcctId() == "" || custPos.GetInstId() == "" {
+			unmapped++
+		}
+	}
+
+	custPoss, err = s.reconStore.LoadCustPoss(ctx, custPoss)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.LoadCustPossResponse{
+		CustPoss: custPoss,
+		Unmapped: unmapped,
+	}, nil
+}
+
+// ListCustPoss lists loaded custodian positions from the Reconciliation service
+func (s *ReconServiceImpl) ListCustPoss(ctx context.Context, request *v1.ListCustPossRequest) (*v1.ListCustPossResponse, error) {
+	custPoss, nextPageToken, err := s.reconStore.ListCustPoss(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetDt(), optIDs(request.GetAcctId()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListCustPossResponse{
+		CustPoss:      custPoss,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// ListReconTols lists the tolerance rules from the Reconciliation service
+func (s *ReconServiceImpl) ListReconTols(ctx context.Context, request *v1.ListReconTolsRequest) (*v1.ListReconTolsResponse, error) {
+	reconTols, nextPageToken, err := s.reconStore.ListReconTols(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListReconTolsResponse{
+		ReconTols:     reconTols,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// CreateReconTol creates a new tolerance rule via the Reconciliation service. a rule without an account or
+// instrument applies to any
+func (s *ReconServiceImpl) CreateReconTol(ctx context.Context, request *v1.CreateReconTolRequest) (*v1.CreateReconTolResponse, error) {
+	reconTol := request.GetReconTol()
+	if reconTol == nil {
+		return nil, status.Error(codes.InvalidArgument, "recon_tol required in POST")
+	}
+	if reconTol.GetId() != "" {
+		return nil, status.Error(codes.InvalidArgument, "recon tol id is not expected in POST")
+	}
+	if reconTol.GetAbsTol() < 0 || reconTol.GetRelTol() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "recon tol expects non-negative abs_tol and rel_tol")
+	}
+
+	reconTol, err := s.reconStore.CreateReconTol(ctx, reconTol)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateReconTolResponse{
+		ReconTol: reconTol,
+	}, nil
+}
+
+// DeleteReconTol removes a tolerance rule from the Reconciliation service
+func (s *ReconServiceImpl) DeleteReconTol(ctx context.Context, request *v1.DeleteReconTolRequest) (*v1.DeleteReconTolResponse, error) {
+	if err := s.reconStore.DeleteReconTol(ctx, request.GetId()); err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteReconTolResponse{}, nil
+}
+
+// RunRecon reconciles the custodian positions on a date with the lot balances on the books via the
+// Reconciliation service. breaks from an earlier run on the date are updated in place: ones that recur are
+// reopened if they were resolved, and ones that no longer break are resolved
+func (s *ReconServiceImpl) RunRecon(ctx context.Context, request *v1.RunReconRequest) (*v1.RunReconResponse, error) {
+	dt := request.GetDt()
+	if _, err := time.Parse(config.APIFormats.DateFmt, dt); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid recon date %s", dt)
+	}
+	basis := request.GetBasis()
+	if basis == "" {
+		basis = ReconBasis.Settled
+	}
+	if basis != ReconBasis.Settled && basis != ReconBasis.Total {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid basis %s", basis)
+	}
+
+	custPoss, _, err := s.reconStore.ListCustPoss(ctx, 0, "", dt, request.GetAcctIds())
+	if err != nil {
+		return nil, err
+	}
+
+	// custodian side, setting aside positions that couldn't be mapped
+	scope := make(map[string]bool)
+	for _, acctID := range request.GetAcctIds() {
+		scope[acctID] = true
+	}
+	cust := make(map[recon.Key]float64)
+	refs := make(map[recon.Key]*storage.CustPos)
+	var unmapped []*storage.CustPos
+	for _, custPos := range custPoss {
+		if custPos.GetAcctId() == "" || custPos.GetInstId() == "" {
+			unmapped = append(unmapped, custPos)
+			continue
+		}
+		key := recon.Key{Acct: custPos.GetAcctId(), Inst: custPos.GetInstId()}
+		cust[key] += custPos.GetQty()
+		refs[key] = custPos
+		scope[custPos.GetAcctId()] = true
+	}
+
+	// book side
+	book, err := s.bookPoss(ctx, dt, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// tolerances
+	reconTols, _, err := s.reconStore.ListReconTols(ctx, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]recon.Rule, 0, len(reconTols))
+	for _, reconTol := range reconTols {
+		rules = append(rules, recon.Rule{
+			Acct: reconTol.GetAcctId(),
+			Inst: reconTol.GetInstId(),
+			Tol:  recon.Tol{Abs: reconTol.GetAbsTol(), Rel: reconTol.GetRelTol()},
+		})
+	}
+
+	found := recon.Compare(cust, book, basis == ReconBasis.Settled, func(key recon.Key) recon.Tol {
+		return recon.Match(rules, key)
+	})
+	compared := len(cust)
+	for key := range book {
+		if _, ok := cust[key]; !ok {
+			compared++
+		}
+	}
+
+	breaks := make([]*storage.Break, 0, len(found)+len(unmapped))
+	for _, f := range found {
+		brk := &storage.Break{
+			Dt:          dt,
+			AcctId:      f.Acct,
+			InstId:      f.Inst,
+			BreakType:   f.Type,
+			Basis:       basis,
+			CustQty:     f.CustQty,
+			BookSize:    f.Book.Size,
+			BookSettled: f.Book.Settled,
+			Diff:        f.Diff,
+		}
+		if ref, ok := refs[f.Key]; ok {
+			brk.AcctRef = ref.GetAcctRef()
+			brk.SecId = ref.GetSecId()
+		}
+		breaks = append(breaks, brk)
+	}
+	for _, custPos := range unmapped {
+		breaks = append(breaks, &storage.Break{
+			Dt:        dt,
+			AcctId:    custPos.GetAcctId(),
+			InstId:    custPos.GetInstId(),
+			AcctRef:   custPos.GetAcctRef(),
+			SecId:     custPos.GetSecId(),
+			BreakType: recon.BreakType.Unmapped,
+			Basis:     basis,
+			CustQty:   custPos.GetQty(),
+			Diff:      custPos.GetQty(),
+		})
+	}
+
+	breaks, cleared, err := s.saveBreaks(ctx, dt, request.GetAcctIds(), scope, breaks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RunReconResponse{
+		Breaks:  breaks,
+		Matched: int32(compared - len(found)),
+		Cleared: cleared,
+	}, nil
+}
+
+// GetBreak gets a break from the Reconciliation service
+func (s *ReconServiceImpl) GetBreak(ctx context.Context, request *v1.GetBreakRequest) (*v1.GetBreakResponse, error) {
+	brk, err := s.reconStore.GetBreak(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.GetBreakResponse{
+		Break: brk,
+	}, nil
+}
+
+// ListBreaks lists breaks from the Reconciliation service
+func (s *ReconServiceImpl) ListBreaks(ctx context.Context, request *v1.ListBreaksRequest) (*v1.ListBreaksResponse, error) {
+	if request.GetState() != "" && breakTransitions[request.GetState()] == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid break state %s", request.GetState())
+	}
+
+	breaks, nextPageToken, err := s.reconStore.ListBreaks(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetDt(), optIDs(request.GetAcctId()), request.GetState())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListBreaksResponse{
+		Breaks:        breaks,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// UpdateBreak moves a break through its workflow via the Reconciliation service. only the state and note of
+// a break can be updated; the rest comes from the reconciliation
+func (s *ReconServiceImpl) UpdateBreak(ctx context.Context, request *v1.UpdateBreakRequest) (*v1.UpdateBreakResponse, error) {
+	brk := request.GetBreak()
+	if brk == nil {
+		return nil, status.Error(codes.InvalidArgument, "break required in PATCH")
+	}
+	brk.Id = request.GetId()
+
+	fieldMask := request.GetUpdateMask().GetPaths()
+	if len(fieldMask) == 0 {
+		fieldMask = []string{"state", "note"}
+	}
+	stateFlag := false
+	for _, path := range fieldMask {
+		switch path {
+		case "state":
+			stateFlag = true
+		case "note":
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "break field %s can't be updated", path)
+		}
+	}
+
+	if stateFlag {
+		orig, err := s.reconStore.GetBreak(ctx, brk.GetId())
+		if err != nil {
+			return nil, err
+		}
+		if brk.GetState() != orig.GetState() && !canTransition(orig.GetState(), brk.GetState()) {
+			return nil, status.Errorf(codes.FailedPrecondition, "break can't move from %s to %s", orig.GetState(), brk.GetState())
+		}
+	}
+
+	if err := s.reconStore.UpdateBreak(ctx, brk, fieldMask); err != nil {
+		return nil, err
+	}
+
+	return &v1.UpdateBreakResponse{Version: brk.GetVersion()}, nil
+}
+
+// LoadCustCashTxns loads a custodian's cash transactions, mapping its account references and currencies to
+// accounts and currency instruments, via the Reconciliation service. cash transactions that can't be mapped
+// are still loaded and come up unmatched when reconciled
+func (s *ReconServiceImpl) LoadCustCashTxns(ctx context.Context, request *v1.LoadCustCashTxnsRequest) (*v1.LoadCustCashTxnsResponse, error) {
+	custCashTxns := request.GetCustCashTxns()
+	if len(custCashTxns) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "cust_cash_txns required in POST")
+	}
+	for _, custCashTxn := range custCashTxns {
+		if custCashTxn.GetId() != "" {
+			return nil, status.Error(codes.InvalidArgument, "cust cash txn id is not expected in POST")
+		}
+		if _, err := time.Parse(config.APIFormats.DateFmt, custCashTxn.GetDt()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid cust cash txn date %s", custCashTxn.GetDt())
+		}
+		if custCashTxn.GetAcctRef() == "" || custCashTxn.GetCcy() == "" {
+			return nil, status.Error(codes.InvalidArgument, "cust cash txn expects acct_ref and ccy")
+		}
+	}
+
+	m, err := s.newMapper(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var unmapped int32
+	for _, custCashTxn := range custCashTxns {
+		custCashTxn.AcctId = m.accts[custCashTxn.GetAcctRef()]
+		custCashTxn.CcyId = m.inst(custCashTxn.GetCcy(), SecIDType.Ticker)
+		if custCashTxn.GetAcctId() == "" || custCashTxn.GetCcyId() == "" {
+			unmapped++
+		}
+	}
+
+	custCashTxns, err = s.reconStore.LoadCustCashTxns(ctx, custCashTxns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.LoadCustCashTxnsResponse{
+		CustCashTxns: custCashTxns,
+		Unmapped:     unmapped,
+	}, nil
+}
+
+// LoadSwiftStmts loads a custodian's SWIFT statements via the Reconciliation service: statements of holdings
+// (MT535) as custodian positions, by isin or cusip and safekeeping account, and cash statements (MT950) as
+// custodian cash transactions. statements of transactions (MT536) are imported as txns instead and are
+// skipped, as are other messages. problems are reported by message field and, if there are any, nothing is
+// loaded
+func (s *ReconServiceImpl) LoadSwiftStmts(ctx context.Context, request *v1.LoadSwiftStmtsRequest) (*v1.LoadSwiftStmtsResponse, error) {
+	msgs, err := swift.Parse(strings.NewReader(request.GetSwift()))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	var custPoss []*storage.CustPos
+	var custCashTxns []*storage.CustCashTxn
+	var errs []*swift.Error
+	for _, m := range msgs {
+		switch m.Type {
+		case swift.MTType.MT535:
+			holdings, msgErrs := swift.ParseMT535(m)
+			errs = append(errs, msgErrs...)
+			for _, h := range holdings {
+				custPoss = append(custPoss, &storage.CustPos{
+					Dt:        h.Dt,
+					Src:       request.GetSrc(),
+					AcctRef:   h.Acct,
+					SecId:     h.ID,
+					SecIdType: h.Type,
+					Qty:       h.Qty,
+				})
+			}
+		case swift.MTType.MT950:
+			lines, msgErrs := swift.ParseMT950(m)
+			errs = append(errs, msgErrs...)
+			for _, l := range lines {
+				custCashTxns = append(custCashTxns, &storage.CustCashTxn{
+					Dt:      l.Dt,
+					Src:     request.GetSrc(),
+					AcctRef: l.Acct,
+					ExtId:   l.Ref,
+					Ccy:     l.Ccy,
+					Amt:     l.Amt,
+					Memo:    l.Memo,
+				})
+			}
+		}
+	}
+
+	response := &v1.LoadSwiftStmtsResponse{}
+	for _, e := range errs {
+		response.Errors = append(response.Errors, &v1.ImportError{
+			Line:  int32(e.Line),
+			Field: e.Field,
+			Msg:   e.Msg,
+		})
+	}
+	if len(response.GetErrors()) > 0 {
+		return response, nil
+	}
+	if len(custPoss) == 0 && len(custCashTxns) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "swift has no statements of holdings or cash statements")
+	}
+
+	if len(custPoss) > 0 {
+		loaded, err := s.LoadCustPoss(ctx, &v1.LoadCustPossRequest{CustPoss: custPoss})
+		if err != nil {
+			return nil, err
+		}
+		response.CustPoss = loaded.GetCustPoss()
+		response.Unmapped += loaded.GetUnmapped()
+	}
+	if len(custCashTxns) > 0 {
+		loaded, err := s.LoadCustCashTxns(ctx, &v1.LoadCustCashTxnsRequest{CustCashTxns: custCashTxns})
+		if err != nil {
+			return nil, err
+		}
+		response.CustCashTxns = loaded.GetCustCashTxns()
+		response.Unmapped += loaded.GetUnmapped()
+	}
+
+	return response, nil
+}
+
+// ListCustCashTxns lists loaded custodian cash transactions from the Reconciliation service
+func (s *ReconServiceImpl) ListCustCashTxns(ctx context.Context, request *v1.ListCustCashTxnsRequest) (*v1.ListCustCashTxnsResponse, error) {
+	custCashTxns, nextPageToken, err := s.reconStore.ListCustCashTxns(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetStartDt(), request.GetEndDt(), optIDs(request.GetAcctId()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListCustCashTxnsResponse{
+		CustCashTxns:  custCashTxns,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// RunCashRecon matches the custodian cash transactions between two dates with the cash movements of
+// processed txns via the Reconciliation service. txns move cash by their net settle amount in the settle
+// currency on the settle date. matches replace any made by an earlier run over the same dates and accounts,
+// and the items left unmatched on each side are returned
+func (s *ReconServiceImpl) RunCashRecon(ctx context.Context, request *v1.RunCashReconRequest) (*v1.RunCashReconResponse, error) {
+	start, err := time.Parse(config.APIFormats.DateFmt, request.GetStartDt())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid start date %s", request.GetStartDt())
+	}
+	end, err := time.Parse(config.APIFormats.DateFmt, request.GetEndDt())
+	if err != nil || end.Before(start) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid end date %s", request.GetEndDt())
+	}
+	if request.GetAbsTol() < 0 || request.GetRelTol() < 0 || request.GetDtTol() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "cash recon expects non-negative abs_tol, rel_tol and dt_tol")
+	}
+	tol := recon.CashTol{
+		Amt:  recon.Tol{Abs: request.GetAbsTol(), Rel: request.GetRelTol()},
+		Days: int(request.GetDtTol()),
+	}
+
+	// custodian side, setting aside cash transactions that couldn't be mapped
+	custCashTxns, _, err := s.reconStore.ListCustCashTxns(ctx, 0, "", request.GetStartDt(), request.GetEndDt(), request.GetAcctIds())
+	if err != nil {
+		return nil, err
+	}
+	custRefs := make(map[string]*storage.CustCashTxn, len(custCashTxns))
+	var cust []*recon.Item
+	var unmatchedCust []*storage.CustCashTxn
+	for _, custCashTxn := range custCashTxns {
+		if custCashTxn.GetAcctId() == "" || custCashTxn.GetCcyId() == "" {
+			unmatchedCust = append(unmatchedCust, custCashTxn)
+			continue
+		}
+		dt, err := parseDt(custCashTxn.GetDt())
+		if err != nil {
+			return nil, err
+		}
+		custRefs[custCashTxn.GetId()] = custCashTxn
+		cust = append(cust, &recon.Item{
+			ID:   custCashTxn.GetId(),
+			Acct: custCashTxn.GetAcctId(),
+			Ccy:  custCashTxn.GetCcyId(),
+			Dt:   dt,
+			Amt:  custCashTxn.GetAmt(),
+		})
+	}
+
+	// book side, widened by the date tolerance so txns settling just outside the dates can still match
+	book, bookRefs, err := s.bookCash(ctx, start.AddDate(0, 0, -tol.Days), end.AddDate(0, 0, tol.Days), request.GetAcctIds())
+	if err != nil {
+		return nil, err
+	}
+
+	matches, custLeft, bookLeft := recon.MatchCash(cust, book, tol)
+
+	cashMatches := make([]*storage.CashMatch, 0, len(matches))
+	for _, m := range matches {
+		cashMatch := &storage.CashMatch{
+			AcctId:    m.Cust[0].Acct,
+			CcyId:     m.Cust[0].Ccy,
+			MatchType: m.Type,
+			CustAmt:   m.CustAmt,
+			BookAmt:   m.BookAmt,
+			Diff:      m.CustAmt - m.BookAmt,
+		}
+		for _, c := range m.Cust {
+			cashMatch.CustCashTxnIds = append(cashMatch.CustCashTxnIds, c.ID)
+			if dt := c.Dt.Format(config.APIFormats.DateFmt); dt > cashMatch.GetDt() {
+				cashMatch.Dt = dt
+			}
+		}
+		for _, b := range m.Book {
+			cashMatch.TxnIds = append(cashMatch.TxnIds, b.ID)
+		}
+		cashMatches = append(cashMatches, cashMatch)
+	}
+	cashMatches, err = s.reconStore.ReplaceCashMatches(ctx, request.GetStartDt(), request.GetEndDt(), request.GetAcctIds(), cashMatches)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range custLeft {
+		unmatchedCust = append(unmatchedCust, custRefs[c.ID])
+	}
+	var unmatchedBook []*storage.Txn
+	for _, b := range bookLeft {
+		// txns settling outside the dates are only matched, not flagged
+		if b.Dt.Before(start) || b.Dt.After(end) {
+			continue
+		}
+		unmatchedBook = append(unmatchedBook, bookRefs[b.ID])
+	}
+	sort.SliceStable(unmatchedCust, func(i, j int) bool {
+		return unmatchedCust[i].GetDt() < unmatchedCust[j].GetDt()
+	})
+
+	return &v1.RunCashReconResponse{
+		CashMatches:   cashMatches,
+		UnmatchedCust: unmatchedCust,
+		UnmatchedBook: unmatchedBook,
+	}, nil
+}
+
+// ListCashMatches lists cash matches from the Reconciliation service
+func (s *ReconServiceImpl) ListCashMatches(ctx context.Context, request *v1.ListCashMatchesRequest) (*v1.ListCashMatchesResponse, error) {
+	cashMatches, nextPageToken, err := s.reconStore.ListCashMatches(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(),
+		request.GetStartDt(), request.GetEndDt(), optIDs(request.GetAcctId()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListCashMatchesResponse{
+		CashMatches:   cashMatches,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// bookCash gets the cash movements of processed txns settling between two dates, optionally for a set of
+// accounts, along with the txns by id
+func (s *ReconServiceImpl) bookCash(ctx context.Context, start time.Time, end time.Time, acctIDs []string) ([]*recon.Item, map[string]*storage.Txn, error) {
+	filter := txnStore.TxnFilter{
+		State:       []string{txnService.TxnState.Processed},
+		SettleDtGTE: start.Format(config.APIFormats.DateFmt),
+		SettleDtLTE: end.Format(config.APIFormats.DateFmt),
+	}
+	if len(acctIDs) > 0 {
+		filter.AcctID = acctIDs
+	}
+	txns, _, err := s.txnStore.ListTxns(ctx, 0, "", filter.String(), "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var book []*recon.Item
+	refs := make(map[string]*storage.Txn, len(txns))
+	for _, txn := range txns {
+		sign := cashSign(txn)
+		if sign == 0 || txn.GetSettleAmtNet() == 0 {
+			continue
+		}
+		dt, err := parseDt(txn.GetSettleDt())
+		if err != nil {
+			return nil, nil, err
+		}
+		ccyID := txn.GetSettleAmtCcyId()
+		if ccyID == "" {
+			ccyID = txn.GetTradeAmtCcyId()
+		}
+		refs[txn.GetId()] = txn
+		book = append(book, &recon.Item{
+			ID:   txn.GetId(),
+			Acct: txn.GetAcctId(),
+			Ccy:  ccyID,
+			Dt:   dt,
+			Amt:  sign * txn.GetSettleAmtNet(),
+		})
+	}
+
+	return book, refs, nil
+}
+
+// cashSign gets the direction a txn moves cash in at settlement: 1 for inflows, -1 for outflows, and 0 for
+// txns that don't move cash at a custodian (e.g., reinvestments, sweeps, and the settle txns of trades,
+// whose cash is matched through the trade's payable/receivable)
+func cashSign(txn *storage.Txn) float64 {
+	switch txn.GetTxnType() {
+	case txnService.TxnType.Trade:
+		switch txn.GetTxnSubType() {
+		case txnService.TxnSubType.Trade.Buy:
+			return -1
+		case txnService.TxnSubType.Trade.Sell:
+			return 1
+		}
+	case txnService.TxnType.Income:
+		return 1
+	case txnService.TxnType.CashFlow:
+		switch txn.GetTxnSubType() {
+		case txnService.TxnSubType.CashFlow.Contribution:
+			return 1
+		case txnService.TxnSubType.CashFlow.Withdrawal:
+			return -1
+		}
+	case txnService.TxnType.Fee:
+		return -1
+	}
+
+	return 0
+}
+
+// parseDt parses an api date, ignoring any time portion
+func parseDt(dt string) (time.Time, error) {
+	if len(dt) > len(config.APIFormats.DateFmt) {
+		dt = dt[:len(config.APIFormats.DateFmt)]
+	}
+	return time.Parse(config.APIFormats.DateFmt, dt)
+}
+
+// bookPoss aggregates the lot balances on a date of the accounts in scope by account and instrument
+func (s *ReconServiceImpl) bookPoss(ctx context.Context, dt string, scope map[string]bool) (map[recon.Key]recon.Book, error) {
+	book := make(map[recon.Key]recon.Book)
+	if len(scope) == 0 {
+		return book, nil
+	}
+
+	filter := lotStore.LotFilter{}
+	for acctID := range scope {
+		filter.AcctID = append(filter.AcctID, acctID)
+	}
+	lots, _, err := s.lotStore.ListLots(ctx, 0, "", filter.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return book, nil
+	}
+
+	keys := make(map[string]recon.Key, len(lots))
+	lotIDs := make([]string, 0, len(lots))
+	for _, lot := range lots {
+		keys[lot.GetId()] = recon.Key{Acct: lot.GetAcctId(), Inst: lot.GetInstId()}
+		lotIDs = append(lotIDs, lot.GetId())
+	}
+	lotBals, err := s.lotStore.ListLotBals(ctx, dt, lotIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, lotBal := range lotBals {
+		key := keys[lotBal.GetLotId()]
+		b := book[key]
+		b.Size += lotBal.GetLotSize()
+		b.Settled += lotBal.GetSettledSize()
+		book[key] = b
+	}
+
+	return book, nil
+}
+
+// saveBreaks reconciles the breaks found on a date with the breaks already in the Reconciliation store,
+// returning the saved breaks and the number of earlier breaks resolved because they no longer break. a run
+// scoped to a set of accounts only touches breaks of those accounts. the breaks on the date are locked while
+// they're compared and saved, so concurrent runs can't both create the same break
+func (s *ReconServiceImpl) saveBreaks(ctx context.Context, dt string, acctIDs []string, scope map[string]bool, breaks []*storage.Break) ([]*storage.Break, int32, error) {
+	var saved []*storage.Break
+	var cleared int32
+	err := s.reconStore.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		reconTx := s.reconStore.WithTx(tx)
+		if err := reconTx.LockBreaks(ctx, dt); err != nil {
+			return err
+		}
+
+		existing, _, err := reconTx.ListBreaks(ctx, 0, "", dt, nil, "")
+		if err != nil {
+			return err
+		}
+		prior := make(map[string]*storage.Break, len(existing))
+		for _, brk := range existing {
+			if scope[brk.GetAcctId()] || (len(acctIDs) == 0 && brk.GetAcctId() == "") {
+				prior[breakKey(brk)] = brk
+			}
+		}
+
+		var created []*storage.Break
+		saved = make([]*storage.Break, 0, len(breaks))
+		for _, brk := range breaks {
+			key := breakKey(brk)
+			orig, ok := prior[key]
+			if !ok {
+				brk.State = BreakState.Open
+				created = append(created, brk)
+				continue
+			}
+			delete(prior, key)
+
+			brk.Id = orig.GetId()
+			brk.State = orig.GetState()
+			brk.Note = orig.GetNote()
+			brk.Version = orig.GetVersion()
+			if brk.GetState() == BreakState.Resolved {
+				brk.State = BreakState.Open
+			}
+			if err = reconTx.UpdateBreak(ctx, brk, nil); err != nil {
+				return err
+			}
+			saved = append(saved, brk)
+		}
+
+		created, err = reconTx.CreateBreaks(ctx, created)
+		if err != nil {
+			return err
+		}
+		saved = append(saved, created...)
+
+		// resolve the earlier breaks that didn't recur
+		for _, brk := range prior {
+			if brk.GetState() == BreakState.Resolved {
+				continue
+			}
+			brk.State = BreakState.Resolved
+			brk.Note = "cleared on rerun"
+			if err = reconTx.UpdateBreak(ctx, brk, []string{"state", "note"}); err != nil {
+				return err
+			}
+			cleared++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return saved, cleared, nil
+}
+
+// breakKey identifies the position a break is on. unmapped breaks are identified by the custodian's
+// references
+func breakKey(brk *storage.Break) string {
+	if brk.GetBreakType() == recon.BreakType.Unmapped {
+		return strings.Join([]string{brk.GetBreakType(), brk.GetAcctRef(), brk.GetSecId()}, "|")
+	}
+	return strings.Join([]string{brk.GetAcctId(), brk.GetInstId()}, "|")
+}
+
+// canTransition checks a break can move from one state to another
+func canTransition(from string, to string) bool {
+	for _, state := range breakTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// validSecIDType checks a security identifier type is in the list of types. an empty type matches any
+func validSecIDType(secIDType string) bool {
+	switch secIDType {
+	case "", SecIDType.Isin, SecIDType.Cusip, SecIDType.Ticker:
+		return true
+	}
+	return false
+}
+
+// optIDs wraps an optional id in a list
+func optIDs(id string) []string {
+	if id == "" {
+		return nil
+	}
+	return []string{id}
+}
+
+// mapper maps custodian account references and security identifiers to accounts and instruments
+type mapper struct {
+	accts map[string]string
+	ids   map[string]map[string]string
+}
+
+// newMapper builds a mapper from the accounts and instruments on the books
+func (s *ReconServiceImpl) newMapper(ctx context.Context) (*mapper, error) {
+	accts, _, err := s.acctStore.ListAccts(ctx, 0, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	insts, _, err := s.instStore.ListInsts(ctx, 0, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mapper{
+		accts: make(map[string]string, len(accts)),
+		ids: map[string]map[string]string{
+			SecIDType.Isin:   make(map[string]string),
+			SecIDType.Cusip:  make(map[string]string),
+			SecIDType.Ticker: make(map[string]string),
+		},
+	}
+	for _, acct := range accts {
+		if acct.GetExtRef() != "" {
+			m.accts[acct.GetExtRef()] = acct.GetId()
+		}
+	}
+	for _, inst := range insts {
+		for secIDType, secID := range map[string]string{
+			SecIDType.Isin:   inst.GetIsin(),
+			SecIDType.Cusip:  inst.GetCusip(),
+			SecIDType.Ticker: inst.GetTickerLocal(),
+		} {
+			if secID != "" {
+				m.ids[secIDType][secID] = inst.GetId()
+			}
+		}
+		// the varangian ticker wins over a local ticker
+		if inst.GetTickerVgn() != "" {
+			m.ids[SecIDType.Ticker][inst.GetTickerVgn()] = inst.GetId()
+		}
+	}
+
+	return m, nil
+}
+
+// inst maps a security identifier to an instrument. without a type the identifier is tried as an isin, then
+// a cusip, then a ticker
+func (m *mapper) inst(secID string, secIDType string) string {
+	if secIDType != "" {
+		return m.ids[secIDType][secID]
+	}
+	for _, t := range []string{SecIDType.Isin, SecIDType.Cusip, SecIDType.Ticker} {
+		if instID, ok := m.ids[t][secID]; ok {
+			return instID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,732 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/dbtx"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	fieldmask_utils "github.com/mennanov/fieldmask-utils"
+)
+
+// Store interface used for implementing the Reconciliation store
+type Store interface {
+	LoadCustPoss(ctx context.Context, custPoss []*storage.CustPos) ([]*storage.CustPos, error)
+	ListCustPoss(ctx context.Context, pageSize int32, pageToken string, dt string, acctIDs []string) ([]*storage.CustPos, string, error)
+	ListReconTols(ctx context.Context, pageSize int32, pageToken string) ([]*storage.ReconTol, string, error)
+	CreateReconTol(ctx context.Context, reconTol *storage.ReconTol) (*storage.ReconTol, error)
+	DeleteReconTol(ctx context.Context, id string) error
+	GetBreak(ctx context.Context, id string) (*storage.Break, error)
+	ListBreaks(ctx context.Context, pageSize int32, pageToken string, dt string, acctIDs []string, state string) ([]*storage.Break, string, error)
+	CreateBreaks(ctx context.Context, breaks []*storage.Break) ([]*storage.Break, error)
+	UpdateBreak(ctx context.Context, brk *storage.Break, fieldMask []string) error
+	LoadCustCashTxns(ctx context.Context, custCashTxns []*storage.CustCashTxn) ([]*storage.CustCashTxn, error)
+	ListCustCashTxns(ctx context.Context, pageSize int32, pageToken string, startDt string, endDt string, acctIDs []string) ([]*storage.CustCashTxn, string, error)
+	ListCashMatches(ctx context.Context, pageSize int32, pageToken string, startDt string, endDt string, acctIDs []string) ([]*storage.CashMatch, string, error)
+	ReplaceCashMatches(ctx context.Context, startDt string, endDt string, acctIDs []string, cashMatches []*storage.CashMatch) ([]*storage.CashMatch, error)
+	LockBreaks(ctx context.Context, dt string) error
+	RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error
+	WithTx(tx *pg.Tx) Store
+}
+
+// NewStore encapsulates Reconciliation database operations
+func NewStore(conn *pg.DB) Store {
+	return &storeImpl{
+		conn: conn,
+	}
+}
+
+type storeImpl struct {
+	conn dbtx.Conn
+}
+
+// RunInTransaction runs fn in a database transaction that other stores can be bound to with WithTx
+func (s *storeImpl) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return s.conn.RunInTransaction(ctx, fn)
+}
+
+// WithTx gets a copy of the store bound to a database transaction, which its changes commit with
+func (s *storeImpl) WithTx(tx *pg.Tx) Store {
+	return &storeImpl{conn: dbtx.Shared(tx)}
+}
+
+var (
+	// custPosKeys are the keys custodian positions are listed in order of
+	custPosKeys = []page.Key{{Col: "dt"}, {Col: "acct_ref"}, {Col: "sec_id"}, {Col: "id"}}
+
+	// reconTolKeys are the keys tolerance rules are listed in order of
+	reconTolKeys = []page.Key{{Col: "id"}}
+
+	// breakKeys are the keys breaks are listed in order of
+	breakKeys = []page.Key{{Col: "dt"}, {Col: "acct_id"}, {Col: "inst_id"}, {Col: "id"}}
+
+	// custCashTxnKeys are the keys custodian cash transactions are listed in order of
+	custCashTxnKeys = []page.Key{{Col: "dt"}, {Col: "acct_ref"}, {Col: "ext_id"}, {Col: "id"}}
+
+	// cashMatchKeys are the keys cash matches are listed in order of
+	cashMatchKeys = []page.Key{{Col: "dt"}, {Col: "acct_id"}, {Col: "id"}}
+)
+
+// encodeCustPos converts the vids of a custodian position to vxids
+func encodeCustPos(custPos *storage.CustPos) error {
+	var err error
+	custPos.Id, err = vxid.Encode(custPos.GetId(), vxid.PfxMap.CustPos)
+	if err != nil {
+		return err
+	}
+	custPos.AcctId, err = vxid.Encode(custPos.GetAcctId(), vxid.PfxMap.Account)
+	if err != nil {
+		return err
+	}
+	custPos.InstId, err = vxid.Encode(custPos.GetInstId(), vxid.PfxMap.Instrument)
+
+	return err
+}
+
+// encodeReconTol converts the vids of a tolerance rule to vxids
+func encodeReconTol(reconTol *storage.ReconTol) error {
+	var err error
+	reconTol.Id, err = vxid.Encode(reconTol.GetId(), vxid.PfxMap.ReconTol)
+	if err != nil {
+		return err
+	}
+	reconTol.AcctId, err = vxid.Encode(reconTol.GetAcctId(), vxid.PfxMap.Account)
+	if err != nil {
+		return err
+	}
+	reconTol.InstId, err = vxid.Encode(reconTol.GetInstId(), vxid.PfxMap.Instrument)
+
+	return err
+}
+
+// encodeBreak converts the vids of a break to vxids
+func encodeBreak(brk *storage.Break) error {
+	var err error
+	brk.Id, err = vxid.Encode(brk.GetId(), vxid.PfxMap.Break)
+	if err != nil {
+		return err
+	}
+	brk.AcctId, err = vxid.Encode(brk.GetAcctId(), vxid.PfxMap.Account)
+	if err != nil {
+		return err
+	}
+	brk.InstId, err = vxid.Encode(brk.GetInstId(), vxid.PfxMap.Instrument)
+
+	return err
+}
+
+// decodeBreak converts the vxids of a break to vids
+func decodeBreak(brk *storage.Break) error {
+	var err error
+	brk.Id, err = vxid.Decode(brk.GetId())
+	if err != nil {
+		return err
+	}
+	brk.AcctId, err = vxid.Decode(brk.GetAcctId())
+	if err != nil {
+		return err
+	}
+	brk.InstId, err = vxid.Decode(brk.GetInstId())
+
+	return err
+}
+
+// encodeCustCashTxn converts the vids of a custodian cash transaction to vxids
+func encodeCustCashTxn(custCashTxn *storage.CustCashTxn) error {
+	var err error
+	custCashTxn.Id, err = vxid.Encode(custCashTxn.GetId(), vxid.PfxMap.CustCashTxn)
+	if err != nil {
+		return err
+	}
+	custCashTxn.AcctId, err = vxid.Encode(custCashTxn.GetAcctId(), vxid.PfxMap.Account)
+	if err != nil {
+		return err
+	}
+	custCashTxn.CcyId, err = vxid.Encode(custCashTxn.GetCcyId(), vxid.PfxMap.Instrument)
+
+	return err
+}
+
+// encodeCashMatch converts the vids of a cash match to vxids
+func encodeCashMatch(cashMatch *storage.CashMatch) error {
+	var err error
+	cashMatch.Id, err = vxid.Encode(cashMatch.GetId(), vxid.PfxMap.CashMatch)
+	if err != nil {
+		return err
+	}
+	cashMatch.AcctId, err = vxid.Encode(cashMatch.GetAcctId(), vxid.PfxMap.Account)
+	if err != nil {
+		return err
+	}
+	cashMatch.CcyId, err = vxid.Encode(cashMatch.GetCcyId(), vxid.PfxMap.Instrument)
+	if err != nil {
+		return err
+	}
+	for i, id := range cashMatch.GetCustCashTxnIds() {
+		cashMatch.CustCashTxnIds[i], err = vxid.Encode(id, vxid.PfxMap.CustCashTxn)
+		if err != nil {
+			return err
+		}
+	}
+	for i, id := range cashMatch.GetTxnIds() {
+		cashMatch.TxnIds[i], err = vxid.Encode(id, vxid.PfxMap.Transaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeCashMatch converts the vxids of a cash match to vids
+func decodeCashMatch(cashMatch *storage.CashMatch) error {
+	var err error
+	cashMatch.AcctId, err = vxid.Decode(cashMatch.GetAcctId())
+	if err != nil {
+		return err
+	}
+	cashMatch.CcyId, err = vxid.Decode(cashMatch.GetCcyId())
+	if err != nil {
+		return err
+	}
+	for i, id := range cashMatch.GetCustCashTxnIds() {
+		cashMatch.CustCashTxnIds[i], err = vxid.Decode(id)
+		if err != nil {
+			return err
+		}
+	}
+	for i, id := range cashMatch.GetTxnIds() {
+		cashMatch.TxnIds[i], err = vxid.Decode(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadCustPoss loads custodian positions into the Reconciliation store. a statement replaces any positions
+// already loaded from the same source for the same account reference and date
+func (s *storeImpl) LoadCustPoss(ctx context.Context, custPoss []*storage.CustPos) ([]*storage.CustPos, error) {
+	if len(custPoss) == 0 {
+		return custPoss, nil
+	}
+
+	// convert vxids to vids
+	var err error
+	for _, custPos := range custPoss {
+		custPos.AcctId, err = vxid.Decode(custPos.GetAcctId())
+		if err != nil {
+			return nil, err
+		}
+		custPos.InstId, err = vxid.Decode(custPos.GetInstId())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		replaced := make(map[string]bool)
+		for _, custPos := range custPoss {
+			key := custPos.GetSrc() + "|" + custPos.GetAcctRef() + "|" + custPos.GetDt()
+			if replaced[key] {
+				continue
+			}
+			replaced[key] = true
+			_, err := tx.ModelContext(ctx, (*storage.CustPos)(nil)).
+				Where("coalesce(src, '') = ?", custPos.GetSrc()).
+				Where("acct_ref = ?", custPos.GetAcctRef()).
+				Where("dt = ?", custPos.GetDt()).
+				Delete()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ModelContext(ctx, &custPoss).Insert()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading cust poss: %w", err)
+	}
+
+	for _, custPos := range custPoss {
+		// convert vids to vxids
+		if err = encodeCustPos(custPos); err != nil {
+			return nil, err
+		}
+	}
+
+	return custPoss, nil
+}
+
+// ListCustPoss lists a page of custodian positions on a date, optionally for a set of accounts, from the
+// Reconciliation store in order of date, account ref and security id. a page size of 0 lists every position
+func (s *storeImpl) ListCustPoss(ctx context.Context, pageSize int32, pageToken string, dt string, acctIDs []string) ([]*storage.CustPos, string, error) {
+	var custPoss []*storage.CustPos
+
+	q := s.conn.ModelContext(ctx, &custPoss).ColumnExpr("*, dt::date")
+	if dt != "" {
+		q.Where("dt = ?", dt)
+	}
+	if len(acctIDs) > 0 {
+		vids, err := vxid.Decodes(acctIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("acct_id IN (?)", pg.In(vids))
+	}
+	p := page.NewPager(pageSize, custPosKeys, page.Query(dt, strings.Join(acctIDs, ","))).Dates("dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing cust poss: %w", err)
+	}
+
+	// the next page starts after the last position of this one
+	var nextPageToken string
+	n, more := p.Next(len(custPoss))
+	custPoss = custPoss[:n]
+	if more {
+		last := custPoss[n-1]
+		nextPageToken = p.Token(last.GetDt(), last.GetAcctRef(), last.GetSecId(), last.GetId())
+	}
+
+	for _, custPos := range custPoss {
+		// convert vids to vxids
+		if err = encodeCustPos(custPos); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return custPoss, nextPageToken, nil
+}
+
+// ListReconTols lists a page of the tolerance rules from the Reconciliation store in order of id. a page size
+// of 0 lists every rule
+func (s *storeImpl) ListReconTols(ctx context.Context, pageSize int32, pageToken string) ([]*storage.ReconTol, string, error) {
+	var reconTols []*storage.ReconTol
+	p := page.NewPager(pageSize, reconTolKeys, "")
+	q, err := p.Apply(s.conn.ModelContext(ctx, &reconTols), pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing recon tols: %w", err)
+	}
+
+	// the next page starts after the last rule of this one
+	var nextPageToken string
+	n, more := p.Next(len(reconTols))
+	reconTols = reconTols[:n]
+	if more {
+		nextPageToken = p.Token(reconTols[n-1].GetId())
+	}
+
+	for _, reconTol := range reconTols {
+		// convert vids to vxids
+		if err = encodeReconTol(reconTol); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return reconTols, nextPageToken, nil
+}
+
+// CreateReconTol creates a new tolerance rule via the Reconciliation store
+func (s *storeImpl) CreateReconTol(ctx context.Context, reconTol *storage.ReconTol) (*storage.ReconTol, error) {
+	var err error
+
+	// convert vxids to vids
+	reconTol.AcctId, err = vxid.Decode(reconTol.GetAcctId())
+	if err != nil {
+		return nil, err
+	}
+	reconTol.InstId, err = vxid.Decode(reconTol.GetInstId())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, reconTol).Insert(); err != nil {
+		return nil, fmt.Errorf("creating recon tol: %w", err)
+	}
+
+	// convert vids to vxids
+	if err = encodeReconTol(reconTol); err != nil {
+		return nil, err
+	}
+
+	return reconTol, nil
+}
+
+// DeleteReconTol removes a tolerance rule from the Reconciliation store
+func (s *storeImpl) DeleteReconTol(ctx context.Context, id string) error {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.ReconTol)(nil)).Where("id = ?", vid).Delete(); err != nil {
+		return fmt.Errorf("deleting recon tol %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// GetBreak gets a break from the Reconciliation store
+func (s *storeImpl) GetBreak(ctx context.Context, id string) (*storage.Break, error) {
+	// convert vxid to vid
+	vid, err := vxid.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var brk storage.Break
+	err = s.conn.ModelContext(ctx, &brk).ColumnExpr("*, dt::date").Where("id = ?", vid).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "break with id %s not found", id)
+		}
+		return nil, err
+	}
+
+	// convert vids to vxids
+	if err = encodeBreak(&brk); err != nil {
+		return nil, err
+	}
+
+	return &brk, nil
+}
+
+// ListBreaks lists a page of breaks, optionally on a date, for a set of accounts, and in a state, from the
+// Reconciliation store in order of date, account and instrument. a page size of 0 lists every break
+func (s *storeImpl) ListBreaks(ctx context.Context, pageSize int32, pageToken string, dt string, acctIDs []string, state string) ([]*storage.Break, string, error) {
+	var breaks []*storage.Break
+
+	q := s.conn.ModelContext(ctx, &breaks).ColumnExpr("*, dt::date")
+	if dt != "" {
+		q.Where("dt = ?", dt)
+	}
+	if len(acctIDs) > 0 {
+		vids, err := vxid.Decodes(acctIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("acct_id IN (?)", pg.In(vids))
+	}
+	if state != "" {
+		q.Where("state = ?", state)
+	}
+	p := page.NewPager(pageSize, breakKeys, page.Query(dt, strings.Join(acctIDs, ","), state)).Dates("dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing breaks: %w", err)
+	}
+
+	// the next page starts after the last break of this one
+	var nextPageToken string
+	n, more := p.Next(len(breaks))
+	breaks = breaks[:n]
+	if more {
+		last := breaks[n-1]
+		nextPageToken = p.Token(last.GetDt(), last.GetAcctId(), last.GetInstId(), last.GetId())
+	}
+
+	for _, brk := range breaks {
+		// convert vids to vxids
+		if err = encodeBreak(brk); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return breaks, nextPageToken, nil
+}
+
+// CreateBreaks creates a set of breaks via the Reconciliation store
+func (s *storeImpl) CreateBreaks(ctx context.Context, breaks []*storage.Break) ([]*storage.Break, error) {
+	if len(breaks) == 0 {
+		return breaks, nil
+	}
+
+	// convert vxids to vids
+	var err error
+	for _, brk := range breaks {
+		if err = decodeBreak(brk); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = s.conn.ModelContext(ctx, &breaks).Insert(); err != nil {
+		return nil, fmt.Errorf("creating breaks: %w", err)
+	}
+
+	for _, brk := range breaks {
+		// convert vids to vxids
+		if err = encodeBreak(brk); err != nil {
+			return nil, err
+		}
+	}
+
+	return breaks, nil
+}
+
+// LockBreaks locks the breaks on a date until the database transaction the store is bound to ends, so runs
+// reconciling the same date save their breaks one at a time rather than each creating the same break. the
+// store must be bound to a transaction with WithTx, or the lock is released as soon as it's taken
+func (s *storeImpl) LockBreaks(ctx context.Context, dt string) error {
+	if _, err := s.conn.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(?))", "breaks|"+dt); err != nil {
+		return fmt.Errorf("locking breaks on %s: %w", dt, err)
+	}
+
+	return nil
+}
+
+// UpdateBreak updates a break via the Reconciliation store
+func (s *storeImpl) UpdateBreak(ctx context.Context, brk *storage.Break, fieldMask []string) error {
+	var err error
+	tgtBreak := brk
+	id, ver := brk.GetId(), brk.GetVersion()
+
+	// copy over only the fields passed in from the field mask (if provided)
+	if fieldMask != nil {
+		// get original break object to update
+		tgtBreak, err = s.GetBreak(ctx, brk.GetId())
+		if err != nil {
+			return err
+		}
+
+		mask, err := fieldmask_utils.MaskFromPaths(fieldMask, casing.Camel)
+		if err != nil {
+			return err
+		}
+		fieldmask_utils.StructToStruct(mask, brk, tgtBreak)
+	}
+
+	// convert vxids to vids
+	if err = decodeBreak(tgtBreak); err != nil {
+		return err
+	}
+
+	// update break in datastore if it's still at the version the caller read
+	tgtBreak.Version = ver + 1
+	if err = version.Update(ctx, s.conn, tgtBreak, ver, "break "+id); err != nil {
+		return err
+	}
+	brk.Version = tgtBreak.GetVersion()
+
+	// convert vids back to vxids
+	return encodeBreak(tgtBreak)
+}
+
+// LoadCustCashTxns loads custodian cash transactions into the Reconciliation store. a statement replaces any
+// cash transactions already loaded from the same source for the same account reference and date
+func (s *storeImpl) LoadCustCashTxns(ctx context.Context, custCashTxns []*storage.CustCashTxn) ([]*storage.CustCashTxn, error) {
+	if len(custCashTxns) == 0 {
+		return custCashTxns, nil
+	}
+
+	// convert vxids to vids
+	var err error
+	for _, custCashTxn := range custCashTxns {
+		custCashTxn.AcctId, err = vxid.Decode(custCashTxn.GetAcctId())
+		if err != nil {
+			return nil, err
+		}
+		custCashTxn.CcyId, err = vxid.Decode(custCashTxn.GetCcyId())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		replaced := make(map[string]bool)
+		for _, custCashTxn := range custCashTxns {
+			key := custCashTxn.GetSrc() + "|" + custCashTxn.GetAcctRef() + "|" + custCashTxn.GetDt()
+			if replaced[key] {
+				continue
+			}
+			replaced[key] = true
+			_, err := tx.ModelContext(ctx, (*storage.CustCashTxn)(nil)).
+				Where("coalesce(src, '') = ?", custCashTxn.GetSrc()).
+				Where("acct_ref = ?", custCashTxn.GetAcctRef()).
+				Where("dt = ?", custCashTxn.GetDt()).
+				Delete()
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ModelContext(ctx, &custCashTxns).Insert()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading cust cash txns: %w", err)
+	}
+
+	for _, custCashTxn := range custCashTxns {
+		// convert vids to vxids
+		if err = encodeCustCashTxn(custCashTxn); err != nil {
+			return nil, err
+		}
+	}
+
+	return custCashTxns, nil
+}
+
+// ListCustCashTxns lists a page of custodian cash transactions between two dates, optionally for a set of
+// accounts, from the Reconciliation store in order of date, account ref and external id. a page size of 0 lists
+// every cash transaction
+func (s *storeImpl) ListCustCashTxns(ctx context.Context, pageSize int32, pageToken string, startDt string, endDt string, acctIDs []string) ([]*storage.CustCashTxn, string, error) {
+	var custCashTxns []*storage.CustCashTxn
+
+	q := s.conn.ModelContext(ctx, &custCashTxns).ColumnExpr("*, dt::date")
+	if startDt != "" {
+		q.Where("dt >= ?", startDt)
+	}
+	if endDt != "" {
+		q.Where("dt <= ?", endDt)
+	}
+	if len(acctIDs) > 0 {
+		vids, err := vxid.Decodes(acctIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("acct_id IN (?)", pg.In(vids))
+	}
+	p := page.NewPager(pageSize, custCashTxnKeys, page.Query(startDt, endDt, strings.Join(acctIDs, ","))).Dates("dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing cust cash txns: %w", err)
+	}
+
+	// the next page starts after the last cash transaction of this one
+	var nextPageToken string
+	n, more := p.Next(len(custCashTxns))
+	custCashTxns = custCashTxns[:n]
+	if more {
+		last := custCashTxns[n-1]
+		nextPageToken = p.Token(last.GetDt(), last.GetAcctRef(), last.GetExtId(), last.GetId())
+	}
+
+	for _, custCashTxn := range custCashTxns {
+		// convert vids to vxids
+		if err = encodeCustCashTxn(custCashTxn); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return custCashTxns, nextPageToken, nil
+}
+
+// ListCashMatches lists a page of cash matches between two dates, optionally for a set of accounts, from the
+// Reconciliation store in order of date and account. a page size of 0 lists every match
+func (s *storeImpl) ListCashMatches(ctx context.Context, pageSize int32, pageToken string, startDt string, endDt string, acctIDs []string) ([]*storage.CashMatch, string, error) {
+	var cashMatches []*storage.CashMatch
+
+	q := s.conn.ModelContext(ctx, &cashMatches).ColumnExpr("*, dt::date")
+	if startDt != "" {
+		q.Where("dt >= ?", startDt)
+	}
+	if endDt != "" {
+		q.Where("dt <= ?", endDt)
+	}
+	if len(acctIDs) > 0 {
+		vids, err := vxid.Decodes(acctIDs)
+		if err != nil {
+			return nil, "", err
+		}
+		q.Where("acct_id IN (?)", pg.In(vids))
+	}
+	p := page.NewPager(pageSize, cashMatchKeys, page.Query(startDt, endDt, strings.Join(acctIDs, ","))).Dates("dt")
+	q, err := p.Apply(q, pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing cash matches: %w", err)
+	}
+
+	// the next page starts after the last match of this one
+	var nextPageToken string
+	n, more := p.Next(len(cashMatches))
+	cashMatches = cashMatches[:n]
+	if more {
+		last := cashMatches[n-1]
+		nextPageToken = p.Token(last.GetDt(), last.GetAcctId(), last.GetId())
+	}
+
+	for _, cashMatch := range cashMatches {
+		// convert vids to vxids
+		if err = encodeCashMatch(cashMatch); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return cashMatches, nextPageToken, nil
+}
+
+// ReplaceCashMatches replaces the cash matches between two dates, optionally for a set of accounts, with a
+// new set of matches in the Reconciliation store
+func (s *storeImpl) ReplaceCashMatches(ctx context.Context, startDt string, endDt string, acctIDs []string, cashMatches []*storage.CashMatch) ([]*storage.CashMatch, error) {
+	var err error
+	var vids []string
+	if len(acctIDs) > 0 {
+		vids, err = vxid.Decodes(acctIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// convert vxids to vids
+	for _, cashMatch := range cashMatches {
+		if err = decodeCashMatch(cashMatch); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		q := tx.ModelContext(ctx, (*storage.CashMatch)(nil)).Where("dt >= ?", startDt).Where("dt <= ?", endDt)
+		if len(vids) > 0 {
+			q.Where("acct_id IN (?)", pg.In(vids))
+		}
+		if _, err := q.Delete(); err != nil {
+			return err
+		}
+		if len(cashMatches) == 0 {
+			return nil
+		}
+
+		_, err := tx.ModelContext(ctx, &cashMatches).Insert()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("replacing cash matches: %w", err)
+	}
+
+	for _, cashMatch := range cashMatches {
+		// convert vids to vxids
+		if err = encodeCashMatch(cashMatch); err != nil {
+			return nil, err
+		}
+	}
+
+	return cashMatches, nil
+}
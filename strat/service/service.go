@@ -5,6 +5,9 @@ import (
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/page"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	stratStore "github.com/wolfinger/varangian/strat/store"
 	"google.golang.org/grpc"
@@ -54,13 +57,14 @@ func (s *StratServiceImpl) GetStrat(ctx context.Context, request *v1.GetStratReq
 
 // ListStrats lists an array of strategies from the Strategy service
 func (s *StratServiceImpl) ListStrats(ctx context.Context, request *v1.ListStratsRequest) (*v1.ListStratsResponse, error) {
-	strats, err := s.stratStore.ListStrats(ctx)
+	strats, nextPageToken, err := s.stratStore.ListStrats(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 
 	return &v1.ListStratsResponse{
-		Strats: strats,
+		Strats:        strats,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -70,7 +74,7 @@ func (s *StratServiceImpl) UpdateStrat(ctx context.Context, request *v1.UpdateSt
 		return nil, err
 	}
 
-	return &v1.UpdateStratResponse{}, nil
+	return &v1.UpdateStratResponse{Version: request.GetStrat().GetVersion()}, nil
 }
 
 // CreateStrat creates a new Strategy via the Strategy service
@@ -101,3 +105,101 @@ func (s *StratServiceImpl) DeleteStrat(ctx context.Context, request *v1.DeleteSt
 
 	return &v1.DeleteStratResponse{}, nil
 }
+
+// BatchCreateStrats creates a set of strategies via the Strategy service. the strategies are returned in the
+// order given, and any that failed in a partial batch are returned without an id
+func (s *StratServiceImpl) BatchCreateStrats(ctx context.Context, request *v1.BatchCreateStratsRequest) (*v1.BatchCreateStratsResponse, error) {
+	strats := request.GetStrats()
+	errs, err := batch.Run(len(strats), request.GetPartial(),
+		func(i int) error {
+			if strats[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "strat id is not expected in POST")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchStrats := make([]*storage.Strat, len(idx))
+			for j, i := range idx {
+				batchStrats[j] = strats[i]
+			}
+			_, err := s.stratStore.CreateStrats(ctx, batchStrats)
+			return err
+		},
+		func(i int) error {
+			_, err := s.stratStore.CreateStrats(ctx, strats[i:i+1])
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchCreateStratsResponse{
+		Strats: strats,
+		Errors: errs,
+	}, nil
+}
+
+// BatchUpdateStrats updates a set of strategies via the Strategy service
+func (s *StratServiceImpl) BatchUpdateStrats(ctx context.Context, request *v1.BatchUpdateStratsRequest) (*v1.BatchUpdateStratsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			strat := requests[i].GetStrat()
+			if strat == nil {
+				return status.Error(codes.InvalidArgument, "strat required in update")
+			}
+			strat.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			strats := make([]*storage.Strat, len(idx))
+			fieldMasks := make([][]string, len(idx))
+			for j, i := range idx {
+				strats[j] = requests[i].GetStrat()
+				fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
+			}
+			return s.stratStore.UpdateStrats(ctx, strats, fieldMasks)
+		},
+		func(i int) error {
+			return s.stratStore.UpdateStrat(ctx, requests[i].GetStrat(), requests[i].GetUpdateMask().GetPaths())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdateStratsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetStrat().GetVersion()
+		}),
+	}, nil
+}
+
+// BatchDeleteStrats removes a set of strategies from the Strategy service
+func (s *StratServiceImpl) BatchDeleteStrats(ctx context.Context, request *v1.BatchDeleteStratsRequest) (*v1.BatchDeleteStratsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			if ids[i] == "" {
+				return status.Error(codes.InvalidArgument, "strat id required in delete")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			batchIDs := make([]string, len(idx))
+			for j, i := range idx {
+				batchIDs[j] = ids[i]
+			}
+			return s.stratStore.DeleteStrats(ctx, batchIDs)
+		},
+		func(i int) error {
+			return s.stratStore.DeleteStrat(ctx, ids[i])
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchDeleteStratsResponse{
+		Errors: errs,
+	}, nil
+}
@@ -5,8 +5,13 @@ import (
 	"fmt"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"github.com/golang/protobuf/proto"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -17,10 +22,13 @@ import (
 // Store interface used for implementing the Strategy store
 type Store interface {
 	GetStrat(ctx context.Context, id string) (*storage.Strat, error)
-	ListStrats(ctx context.Context) ([]*storage.Strat, error)
+	ListStrats(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Strat, string, error)
 	UpdateStrat(ctx context.Context, strat *storage.Strat, fieldMask []string) error
 	CreateStrat(ctx context.Context, strat *storage.Strat) (*storage.Strat, error)
 	DeleteStrat(ctx context.Context, id string) error
+	CreateStrats(ctx context.Context, strats []*storage.Strat) ([]*storage.Strat, error)
+	UpdateStrats(ctx context.Context, strats []*storage.Strat, fieldMasks [][]string) error
+	DeleteStrats(ctx context.Context, ids []string) error
 }
 
 // NewStore encapsulates Strategy database operations
@@ -34,8 +42,25 @@ type storeImpl struct {
 	conn *pg.DB
 }
 
+// stratKeys are the keys strategies are listed in order of
+var stratKeys = []page.Key{{Col: "id"}}
+
+// stratOrderCols are the columns strategies can be listed in order of
+var stratOrderCols = []string{"id", "name"}
+
+// stratFields are the fields strategies can be filtered on
+var stratFields = filterPkg.NewFields(&storage.Strat{}, map[string]string{
+	"id":        vxid.PfxMap.Strategy,
+	"parent_id": vxid.PfxMap.Strategy,
+})
+
 // GetStrat gets a strategy from the Strategy store
 func (s *storeImpl) GetStrat(ctx context.Context, id string) (*storage.Strat, error) {
+	return getStrat(ctx, s.conn, id)
+}
+
+// getStrat gets a strategy with either a connection or a database transaction
+func getStrat(ctx context.Context, db orm.DB, id string) (*storage.Strat, error) {
 	// convert vxids to vids
 	vid, err := vxid.Decode(id)
 	if err != nil {
@@ -43,7 +68,7 @@ func (s *storeImpl) GetStrat(ctx context.Context, id string) (*storage.Strat, er
 	}
 
 	var strat storage.Strat
-	err = s.conn.ModelContext(ctx, &strat).Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &strat).Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "strat with id %s not found", id)
@@ -63,40 +88,110 @@ func (s *storeImpl) GetStrat(ctx context.Context, id string) (*storage.Strat, er
 	return &strat, err
 }
 
-// ListStrats lists an array of strategies from the Strategy store
-func (s *storeImpl) ListStrats(ctx context.Context) ([]*storage.Strat, error) {
+// ListStrats lists a page of the strategies matching a filter from the Strategy store, in order of id unless
+// ordered otherwise. a page size of 0 lists every strategy
+func (s *storeImpl) ListStrats(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Strat, string, error) {
+	keys, err := page.Order(orderBy, stratKeys, stratOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var strats []*storage.Strat
-	err := s.conn.ModelContext(ctx, &strats).Select()
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &strats), filter, stratFields)
 	if err != nil {
-		return nil, fmt.Errorf("listing strats %w", err)
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter)
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing strats %w", err)
+	}
+
+	// the next page starts after the last strategy of this one
+	var nextPageToken string
+	n, more := p.Next(len(strats))
+	strats = strats[:n]
+	if more {
+		nextPageToken = p.Token(stratVals(strats[n-1], keys)...)
 	}
 
 	for _, strat := range strats {
 		// convert vids to vxids
 		strat.Id, err = vxid.Encode(strat.GetId(), vxid.PfxMap.Strategy)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if strat.GetParentId() != "" {
 			strat.ParentId, err = vxid.Encode(strat.GetParentId(), vxid.PfxMap.Strategy)
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 	}
 
-	return strats, nil
+	return strats, nextPageToken, nil
+}
+
+// stratVals gets the values of the keys strategies are listed in order of from a strategy, for page tokens
+func stratVals(strat *storage.Strat, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = strat.GetId()
+		case "name":
+			vals[i] = strat.GetName()
+		}
+	}
+
+	return vals
 }
 
 // UpdateStrat updates a strategy via the Strategy store
 func (s *storeImpl) UpdateStrat(ctx context.Context, strat *storage.Strat, fieldMask []string) error {
+	if err := updateStrat(ctx, s.conn, strat, fieldMask); err != nil {
+		return err
+	}
+	strat.Version++
+
+	return nil
+}
+
+// UpdateStrats updates a set of strategies via the Strategy store, each with its own field mask. either every
+// strategy is updated or none are
+func (s *storeImpl) UpdateStrats(ctx context.Context, strats []*storage.Strat, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		for i, strat := range strats {
+			if err := updateStrat(ctx, tx, strat, fieldMasks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, strat := range strats {
+		strat.Version++
+	}
+
+	return nil
+}
+
+// updateStrat updates a strategy with either a connection or a database transaction. the strategy passed in
+// isn't changed
+func updateStrat(ctx context.Context, db orm.DB, strat *storage.Strat, fieldMask []string) error {
 	var err error
-	tgtStrat := strat
+	tgtStrat := proto.Clone(strat).(*storage.Strat)
 
 	// copy over only the fields passed in from the field mask (if provided)
 	if fieldMask != nil {
 		// get original strat object to update
-		tgtStrat, err = s.GetStrat(ctx, strat.GetId())
+		tgtStrat, err = getStrat(ctx, db, strat.GetId())
 		if err != nil {
 			return err
 		}
@@ -120,10 +215,10 @@ func (s *storeImpl) UpdateStrat(ctx context.Context, strat *storage.Strat, field
 		}
 	}
 
-	// update strat in datastore
-	_, err = s.conn.ModelContext(ctx, tgtStrat).WherePK().Update()
-	if err != nil {
-		return fmt.Errorf("update strat %s %w", strat.GetId(), err)
+	// update strat in datastore if it's still at the version the caller read
+	tgtStrat.Version = strat.GetVersion() + 1
+	if err = version.Update(ctx, db, tgtStrat, strat.GetVersion(), "strat "+strat.GetId()); err != nil {
+		return err
 	}
 
 	return nil
@@ -159,6 +254,44 @@ func (s *storeImpl) CreateStrat(ctx context.Context, strat *storage.Strat) (*sto
 	return strat, nil
 }
 
+// CreateStrats creates a set of strategies via the Strategy store with a single insert. either every
+// strategy is created or none are, and the strategies passed in are only changed once they are
+func (s *storeImpl) CreateStrats(ctx context.Context, strats []*storage.Strat) ([]*storage.Strat, error) {
+	if len(strats) == 0 {
+		return strats, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Strat, len(strats))
+	for i, strat := range strats {
+		rows[i] = proto.Clone(strat).(*storage.Strat)
+		if strat.GetParentId() != "" {
+			var err error
+			rows[i].ParentId, err = vxid.Decode(strat.GetParentId())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// insert strats into datastore
+	if _, err := s.conn.ModelContext(ctx, &rows).Insert(); err != nil {
+		return nil, fmt.Errorf("creating strats: %w", err)
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		strats[i].Id = id
+		strats[i].Version = row.GetVersion()
+	}
+
+	return strats, nil
+}
+
 // DeleteStrat removes a strategy from the Strategy store
 func (s *storeImpl) DeleteStrat(ctx context.Context, id string) error {
 	// convert vxid to vid
@@ -173,3 +306,22 @@ func (s *storeImpl) DeleteStrat(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// DeleteStrats removes a set of strategies from the Strategy store with a single delete
+func (s *storeImpl) DeleteStrats(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.conn.ModelContext(ctx, (*storage.Strat)(nil)).Where("id IN (?)", pg.In(vids)).Delete(); err != nil {
+		return fmt.Errorf("deleting strats %w", err)
+	}
+
+	return nil
+}
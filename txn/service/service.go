@@ -2,12 +2,28 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-pg/pg/v10"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	acctService "github.com/wolfinger/varangian/acct/service"
+	acctStore "github.com/wolfinger/varangian/acct/store"
+	changeStore "github.com/wolfinger/varangian/change/store"
 	v1 "github.com/wolfinger/varangian/generated/api/v1"
 	"github.com/wolfinger/varangian/generated/storage"
+	glService "github.com/wolfinger/varangian/gl/service"
+	glStore "github.com/wolfinger/varangian/gl/store"
+	instStore "github.com/wolfinger/varangian/inst/store"
+	"github.com/wolfinger/varangian/internal/batch"
+	"github.com/wolfinger/varangian/internal/config"
+	"github.com/wolfinger/varangian/internal/feed"
+	"github.com/wolfinger/varangian/internal/guard"
+	"github.com/wolfinger/varangian/internal/imp"
+	"github.com/wolfinger/varangian/internal/page"
 	lotStore "github.com/wolfinger/varangian/lot/store"
 	grpcPkg "github.com/wolfinger/varangian/pkg/grpc"
 	txnStore "github.com/wolfinger/varangian/txn/store"
@@ -17,12 +33,15 @@ import (
 )
 
 type txnType struct {
+	Multileg   string
 	Trade      string
 	Settle     string
 	Income     string
 	Sweep      string
 	Transfer   string
 	Allocation string
+	CashFlow   string
+	Fee        string
 }
 
 type txnSubType struct {
@@ -40,8 +59,19 @@ type txnSubType struct {
 		In  string
 		Out string
 	}
-	Transfer   string
+	Transfer struct {
+		In  string
+		Out string
+	}
 	Allocation string
+	CashFlow   struct {
+		Contribution string
+		Withdrawal   string
+	}
+	Fee struct {
+		Mgmt string
+		Perf string
+	}
 }
 
 type txnState struct {
@@ -53,12 +83,15 @@ type txnState struct {
 var (
 	// TxnType defines lists of transaction types supported
 	TxnType = txnType{
+		Multileg:   "multileg",
 		Trade:      "trade",
 		Settle:     "settle",
 		Income:     "income",
 		Sweep:      "sweep",
 		Transfer:   "xfer",
-		Allocation: "allocation"}
+		Allocation: "allocation",
+		CashFlow:   "cashflow",
+		Fee:        "fee"}
 
 	// TxnSubType defines lists of transaction subtypes supported
 	TxnSubType = txnSubType{
@@ -83,14 +116,48 @@ var (
 		}{
 			In:  "in",
 			Out: "out"},
-		Transfer:   TxnType.Transfer,
-		Allocation: TxnType.Allocation}
+		Transfer: struct {
+			In  string
+			Out string
+		}{
+			In:  "xfin",
+			Out: "xfout"},
+		Allocation: TxnType.Allocation,
+		CashFlow: struct {
+			Contribution string
+			Withdrawal   string
+		}{
+			Contribution: "contribution",
+			Withdrawal:   "withdrawal"},
+		Fee: struct {
+			Mgmt string
+			Perf string
+		}{
+			Mgmt: "mgmt",
+			Perf: "perf"}}
+
+	// txnSubTypes lists the sub types of each transaction type. settle and allocation txns don't need a
+	// sub type
+	txnSubTypes = map[string][]string{
+		TxnType.Trade:      {TxnSubType.Trade.Buy, TxnSubType.Trade.Sell, TxnSubType.Trade.Reinvest},
+		TxnType.Settle:     {"", TxnSubType.Settle},
+		TxnType.Income:     {TxnSubType.Income.Dividend, TxnSubType.Income.Interest},
+		TxnType.Sweep:      {TxnSubType.Sweep.In, TxnSubType.Sweep.Out},
+		TxnType.Transfer:   {TxnSubType.Transfer.In, TxnSubType.Transfer.Out},
+		TxnType.Allocation: {"", TxnSubType.Allocation},
+		TxnType.CashFlow:   {TxnSubType.CashFlow.Contribution, TxnSubType.CashFlow.Withdrawal},
+		TxnType.Fee:        {TxnSubType.Fee.Mgmt, TxnSubType.Fee.Perf},
+	}
 
 	// TxnState defines the list of transaction states supported
 	TxnState = txnState{
 		Open:      "open",
 		Pending:   "pending",
 		Processed: "processed"}
+
+	// errRowsLocked rolls back an import when a row fails its lock check, along with any overrides recorded
+	// for the rows before it
+	errRowsLocked = errors.New("import rows locked")
 )
 
 // Service interface used for implementing the Transaction service
@@ -100,17 +167,27 @@ type Service interface {
 }
 
 // NewService creates new Transaction service
-func NewService(txnStore txnStore.Store, lotStore lotStore.Store) *TxnServiceImpl {
+func NewService(txnStore txnStore.Store, lotStore lotStore.Store, glStore glStore.Store, acctStore acctStore.Store, instStore instStore.Store, guard *guard.Guard, feed *feed.Feed) *TxnServiceImpl {
 	return &TxnServiceImpl{
-		txnStore: txnStore,
-		lotStore: lotStore,
+		txnStore:  txnStore,
+		lotStore:  lotStore,
+		glStore:   glStore,
+		acctStore: acctStore,
+		instStore: instStore,
+		guard:     guard,
+		feed:      feed,
 	}
 }
 
 // TxnServiceImpl data structure for implementing the Transaction service
 type TxnServiceImpl struct {
-	txnStore txnStore.Store
-	lotStore lotStore.Store
+	txnStore  txnStore.Store
+	lotStore  lotStore.Store
+	glStore   glStore.Store
+	acctStore acctStore.Store
+	instStore instStore.Store
+	guard     *guard.Guard
+	feed      *feed.Feed
 }
 
 // RegisterServer registers the Transaction service server
@@ -139,25 +216,56 @@ func (s *TxnServiceImpl) GetTxn(ctx context.Context, request *v1.GetTxnRequest)
 func (s *TxnServiceImpl) ListTxns(ctx context.Context, request *v1.ListTxnsRequest) (*v1.ListTxnsResponse, error) {
 	// base64.RawURLEncoding.DecodeString(request.GetFilter()) -- implement for converting base64
 
-	txns, err := s.txnStore.ListTxns(ctx, request.GetMaxPageSize(), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
+	txns, nextPageToken, err := s.txnStore.ListTxns(ctx, page.Size(request.GetMaxPageSize()), request.GetPageToken(), request.GetFilter(), request.GetOrderBy())
 	if err != nil {
 		return nil, err
 	}
 
 	return &v1.ListTxnsResponse{
-		Txns: txns,
+		Txns:          txns,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
+// WatchTxns streams the changes to transactions from the Transaction service as they're made, after the
+// cursor passed in. each change comes with the transaction as it is when it's sent, or without one if it's
+// been deleted
+func (s *TxnServiceImpl) WatchTxns(request *v1.WatchTxnsRequest, stream v1.TxnService_WatchTxnsServer) error {
+	ctx := stream.Context()
+	return s.feed.Watch(ctx, changeStore.Txn, request.GetCursor(), func(change *storage.Change) error {
+		resp := &v1.WatchTxnsResponse{
+			Cursor: strconv.FormatInt(change.GetId(), 10),
+			Op:     change.GetOp(),
+			Id:     change.GetRefId(),
+		}
+		if change.GetOp() != changeStore.Delete {
+			txn, err := s.txnStore.GetTxn(ctx, change.GetRefId())
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			resp.Txn = txn
+		}
+		return stream.Send(resp)
+	})
+}
+
 // UpdateTxn updates a transaction via the Transaction service
 func (s *TxnServiceImpl) UpdateTxn(ctx context.Context, request *v1.UpdateTxnRequest) (*v1.UpdateTxnResponse, error) {
+	if request.GetTxn() == nil {
+		return nil, status.Error(codes.InvalidArgument, "txn required in PATCH")
+	}
 	request.GetTxn().Id = request.GetId()
-
-	if err := s.txnStore.UpdateTxn(ctx, request.GetTxn(), request.GetUpdateMask().GetPaths()); err != nil {
+	err := s.inTx(ctx, func(s *TxnServiceImpl) error {
+		if err := s.checkUpdateLock(ctx, request.GetTxn()); err != nil {
+			return err
+		}
+		return s.txnStore.UpdateTxn(ctx, request.GetTxn(), request.GetUpdateMask().GetPaths())
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return &v1.UpdateTxnResponse{}, nil
+	return &v1.UpdateTxnResponse{Version: request.GetTxn().GetVersion()}, nil
 }
 
 // CreateTxn creates a new transaction via the Transaction service
@@ -170,7 +278,15 @@ func (s *TxnServiceImpl) CreateTxn(ctx context.Context, request *v1.CreateTxnReq
 		return nil, status.Error(codes.InvalidArgument, "txn id is not expected in POST")
 	}
 
-	txn, err := s.txnStore.CreateTxn(ctx, request.GetTxn())
+	var txn *storage.Txn
+	err := s.inTx(ctx, func(s *TxnServiceImpl) error {
+		if err := s.checkLock(ctx, request.GetTxn()); err != nil {
+			return err
+		}
+		var err error
+		txn, err = s.txnStore.CreateTxn(ctx, request.GetTxn())
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -182,309 +298,623 @@ func (s *TxnServiceImpl) CreateTxn(ctx context.Context, request *v1.CreateTxnReq
 
 // DeleteTxn removes a transaction from the Transaction service
 func (s *TxnServiceImpl) DeleteTxn(ctx context.Context, request *v1.DeleteTxnRequest) (*v1.DeleteTxnResponse, error) {
-	if err := s.txnStore.DeleteTxn(ctx, request.GetId()); err != nil {
+	err := s.inTx(ctx, func(s *TxnServiceImpl) error {
+		if err := s.checkDeleteLock(ctx, request.GetId()); err != nil {
+			return err
+		}
+		return s.txnStore.DeleteTxn(ctx, request.GetId())
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &v1.DeleteTxnResponse{}, nil
 }
 
-// ProcessTxn processes a transaction
-func (s *TxnServiceImpl) ProcessTxn(ctx context.Context, request *v1.ProcessTxnRequest) (*v1.ProcessTxnResponse, error) {
-	txn, err := s.txnStore.GetTxn(ctx, request.GetId())
+// BatchCreateTxns creates a set of transactions via the Transaction service. the transactions are
+// returned in the order given, and any that failed in a partial batch are returned without an id. lock
+// dates are checked as the transactions are written, so an override is only recorded for one that's created
+func (s *TxnServiceImpl) BatchCreateTxns(ctx context.Context, request *v1.BatchCreateTxnsRequest) (*v1.BatchCreateTxnsResponse, error) {
+	txns := request.GetTxns()
+	errs, err := batch.Run(len(txns), request.GetPartial(),
+		func(i int) error {
+			if txns[i].GetId() != "" {
+				return status.Error(codes.InvalidArgument, "txn id is not expected in POST")
+			}
+			return nil
+		},
+		func(idx []int) error {
+			return s.inTx(ctx, func(s *TxnServiceImpl) error {
+				batchTxns := make([]*storage.Txn, len(idx))
+				for j, i := range idx {
+					if err := s.checkLock(ctx, txns[i]); err != nil {
+						return batch.ItemError(i, err)
+					}
+					batchTxns[j] = txns[i]
+				}
+				_, err := s.txnStore.CreateTxns(ctx, batchTxns)
+				return err
+			})
+		},
+		func(i int) error {
+			return s.inTx(ctx, func(s *TxnServiceImpl) error {
+				if err := s.checkLock(ctx, txns[i]); err != nil {
+					return err
+				}
+				_, err := s.txnStore.CreateTxns(ctx, txns[i:i+1])
+				return err
+			})
+		})
 	if err != nil {
 		return nil, err
 	}
 
-	// only process open transactions
-	if txn.State == TxnState.Open {
-		switch txn.TxnType {
-		// trade
-		case TxnType.Trade:
-			switch txn.TxnSubType {
-			// buy
-			case TxnSubType.Trade.Buy:
-				var lot storage.Lot
-				lot.InstId = txn.InstId
-				lot.SrcTxnId = txn.Id
-				lot.OrigDt = txn.TxnDt
-				lot.OrigSize = txn.TxnSize
+	return &v1.BatchCreateTxnsResponse{
+		Txns:   txns,
+		Errors: errs,
+	}, nil
+}
 
-				// create new lot from buy transaction
-				_, err = s.lotStore.CreateLot(ctx, &lot)
-				if err != nil {
-					return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
+// BatchUpdateTxns updates a set of transactions via the Transaction service
+func (s *TxnServiceImpl) BatchUpdateTxns(ctx context.Context, request *v1.BatchUpdateTxnsRequest) (*v1.BatchUpdateTxnsResponse, error) {
+	requests := request.GetRequests()
+	errs, err := batch.Run(len(requests), request.GetPartial(),
+		func(i int) error {
+			txn := requests[i].GetTxn()
+			if txn == nil {
+				return status.Error(codes.InvalidArgument, "txn required in update")
+			}
+			txn.Id = requests[i].GetId()
+			return nil
+		},
+		func(idx []int) error {
+			return s.inTx(ctx, func(s *TxnServiceImpl) error {
+				txns := make([]*storage.Txn, len(idx))
+				fieldMasks := make([][]string, len(idx))
+				for j, i := range idx {
+					if err := s.checkUpdateLock(ctx, requests[i].GetTxn()); err != nil {
+						return batch.ItemError(i, err)
+					}
+					txns[j] = requests[i].GetTxn()
+					fieldMasks[j] = requests[i].GetUpdateMask().GetPaths()
 				}
-			// sell
-			case TxnSubType.Trade.Sell:
-				// determine lot list to sell against
-				saleLotIDs := request.GetLotIds()
-				if saleLotIDs == nil {
-					// TODO: do stuff to find lots
+				return s.txnStore.UpdateTxns(ctx, txns, fieldMasks)
+			})
+		},
+		func(i int) error {
+			return s.inTx(ctx, func(s *TxnServiceImpl) error {
+				if err := s.checkUpdateLock(ctx, requests[i].GetTxn()); err != nil {
+					return err
 				}
-				// reduce the lot balances
-				balRemaining := txn.GetTxnSize()
-				for _, lotID := range saleLotIDs {
-					lotBal, err := s.lotStore.GetLotBal(ctx, lotID, txn.TxnDt)
-					if err != nil {
-						return nil, err
-					}
-					var allocSize float64
-					if balRemaining < lotBal.LotSize {
-						allocSize = balRemaining
-						lotBal.LotSize -= balRemaining
-						lotBal.UnsettledSize -= balRemaining
-						balRemaining = 0
-					} else {
-						allocSize = lotBal.LotSize
-						balRemaining -= lotBal.LotSize
-						lotBal.LotSize = 0
-						lotBal.UnsettledSize = 0
-					}
-					err = s.lotStore.UpdateLotBal(ctx, lotBal)
-					if err != nil {
-						return nil, err
-					}
-					// generate allocating transaction
-					var allocTxn storage.Txn
-					allocTxn.TxnDt = txn.TxnDt
-					allocTxn.SettleDt = txn.TxnDt
-					allocTxn.TxnType = TxnType.Allocation
-					allocTxn.TxnSize = allocSize
-					// allocTxn.InstId = txn.InstId
-					allocTxn.ParentId = txn.Id
-					allocTxn.TgtLotId = lotID
-					allocTxn.State = TxnState.Processed
-					_, err = s.txnStore.CreateTxn(ctx, &allocTxn)
-					if err != nil {
-						return nil, err
-					}
+				return s.txnStore.UpdateTxn(ctx, requests[i].GetTxn(), requests[i].GetUpdateMask().GetPaths())
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.BatchUpdateTxnsResponse{
+		Errors: errs,
+		Versions: batch.Versions(len(requests), errs, func(i int) int64 {
+			return requests[i].GetTxn().GetVersion()
+		}),
+	}, nil
+}
 
-					// exit the loop once the size is fully allocated
-					if balRemaining == 0 {
-						break
+// BatchDeleteTxns removes a set of transactions from the Transaction service
+func (s *TxnServiceImpl) BatchDeleteTxns(ctx context.Context, request *v1.BatchDeleteTxnsRequest) (*v1.BatchDeleteTxnsResponse, error) {
+	ids := request.GetIds()
+	errs, err := batch.Run(len(ids), request.GetPartial(),
+		func(i int) error {
+			return nil
+		},
+		func(idx []int) error {
+			return s.inTx(ctx, func(s *TxnServiceImpl) error {
+				batchIDs := make([]string, len(idx))
+				for j, i := range idx {
+					if err := s.checkDeleteLock(ctx, ids[i]); err != nil {
+						return batch.ItemError(i, err)
 					}
+					batchIDs[j] = ids[i]
 				}
-			// reinvest
-			case TxnSubType.Trade.Reinvest:
-				// create new lot based on reinvestment
-				var lot storage.Lot
-				lot.InstId = txn.InstId
-				lot.SrcTxnId = txn.Id
-				lot.OrigDt = txn.TxnDt
-				lot.OrigSize = txn.TxnSize
-
-				// create lot
-				reinvestLot, err := s.lotStore.CreateLot(ctx, &lot)
-				if err != nil {
-					return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
+				return s.txnStore.DeleteTxns(ctx, batchIDs)
+			})
+		},
+		func(i int) error {
+			return s.inTx(ctx, func(s *TxnServiceImpl) error {
+				if err := s.checkDeleteLock(ctx, ids[i]); err != nil {
+					return err
 				}
+				return s.txnStore.DeleteTxn(ctx, ids[i])
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
 
-				// get lotBal and update to auto-settle
-				reinvestLotBal, err := s.lotStore.GetLotBal(ctx, reinvestLot.GetId(), txn.GetSettleDt())
-				if err != nil {
-					return nil, err
-				}
+	return &v1.BatchDeleteTxnsResponse{
+		Errors: errs,
+	}, nil
+}
+
+// ProcessTxn processes a transaction. the lots, allocating txns, and journals processing creates are
+// committed along with the transaction's new state, so a transaction that fails to process (or that was
+// processed by someone else in the meantime) leaves nothing behind
+func (s *TxnServiceImpl) ProcessTxn(ctx context.Context, request *v1.ProcessTxnRequest) (*v1.ProcessTxnResponse, error) {
+	var response *v1.ProcessTxnResponse
+	err := s.inTx(ctx, func(s *TxnServiceImpl) error {
+		var err error
+		response, err = s.processTxn(ctx, request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// processTxn processes a transaction with whatever database transaction the service's stores are bound to
+func (s *TxnServiceImpl) processTxn(ctx context.Context, request *v1.ProcessTxnRequest) (*v1.ProcessTxnResponse, error) {
+	txn, err := s.txnStore.GetTxn(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	// only open transactions are processed. processing one that's already processed again does nothing
+	if txn.State == TxnState.Processed {
+		return &v1.ProcessTxnResponse{
+			Id:    request.GetId(),
+			State: txn.State}, nil
+	}
+	if txn.State != TxnState.Open {
+		return nil, status.Errorf(codes.FailedPrecondition, "txn %s is %s, not %s", request.GetId(), txn.State, TxnState.Open)
+	}
+
+	var jrnl, cashJrnl *journal
+	if err = s.checkLock(ctx, txn); err != nil {
+		return nil, err
+	}
+
+	// resolve the chart of accounts up front so nothing is processed that can't be journaled
+	jrnl, err = s.newJournal(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch txn.TxnType {
+	// trade
+	case TxnType.Trade:
+		switch txn.TxnSubType {
+		// buy
+		case TxnSubType.Trade.Buy:
+			lot := txnLot(txn)
+			lot.InstId = txn.InstId
+			lot.OrigDt = txn.TxnDt
+			lot.OrigSize = txn.TxnSize
+			lot.OrigCost = txnCost(txn)
 
-				reinvestLotBal.SettledSize = reinvestLotBal.LotSize
-				reinvestLotBal.UnsettledSize = 0
+			// create new lot from buy transaction
+			buyLot, err := s.lotStore.CreateLot(ctx, lot)
+			if err != nil {
+				return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
+			}
+			jrnl.debit(glService.GlRole.SecuritiesCost, buyLot.GetId(), txnAmt(txn))
+		// sell
+		case TxnSubType.Trade.Sell:
+			// allocate the sale to its lots before any of them are touched
+			allocs, err := s.saleAllocs(ctx, txn, request.GetLotIds())
+			if err != nil {
+				return nil, err
+			}
 
-				err = s.lotStore.UpdateLotBal(ctx, reinvestLotBal)
+			// reduce the lot balances
+			costRelieved := 0.0
+			for _, alloc := range allocs {
+				lotBal := alloc.lotBal
+				if alloc.size < lotBal.LotSize {
+					lotBal.LotSize -= alloc.size
+					lotBal.UnsettledSize -= alloc.size
+				} else {
+					lotBal.LotSize = 0
+					lotBal.UnsettledSize = 0
+				}
+				err = s.lotStore.UpdateLotBal(ctx, lotBal)
 				if err != nil {
 					return nil, err
 				}
 
-				// find funding lot (using src_lot_id)
-				fundingLotBal, err := s.lotStore.GetLotBal(ctx, txn.GetSrcLotId(), txn.GetSettleDt())
+				// relieve the cost of the size sold
+				saleLot, err := s.lotStore.GetLot(ctx, alloc.lotID, "")
 				if err != nil {
 					return nil, err
 				}
+				cost := lotCost(saleLot, alloc.size)
+				costRelieved += cost
+				jrnl.credit(glService.GlRole.SecuritiesCost, alloc.lotID, cost)
 
-				// error check if funding lot bal is what we expect
-				if fundingLotBal.GetLotSize() != txn.GetSettleAmtNet() {
-					return nil, fmt.Errorf("funding lot %s for reinvest txn %s not the same size", fundingLotBal.GetLotId(), txn.GetId())
-				}
-
-				// update funding lot to 0
-				fundingLotBal.LotSize = 0
-				fundingLotBal.SettledSize = 0
-				fundingLotBal.UnsettledSize = 0
-				err = s.lotStore.UpdateLotBal(ctx, fundingLotBal)
+				// generate allocating transaction
+				var allocTxn storage.Txn
+				allocTxn.TxnDt = txn.TxnDt
+				allocTxn.SettleDt = txn.TxnDt
+				allocTxn.TxnType = TxnType.Allocation
+				allocTxn.TxnSize = alloc.size
+				// allocTxn.InstId = txn.InstId
+				allocTxn.ParentId = txn.Id
+				allocTxn.TgtLotId = alloc.lotID
+				allocTxn.State = TxnState.Processed
+				allocTxn.AcctId = txn.AcctId
+				allocTxn.LeOrgId = txn.LeOrgId
+				allocTxn.PortId = txn.PortId
+				allocTxn.StratId = txn.StratId
+				_, err = s.txnStore.CreateTxn(ctx, &allocTxn)
 				if err != nil {
 					return nil, err
 				}
 			}
-			// generate payable/receivable for non-reinvestment trades
-			if txn.TxnSubType != TxnSubType.Trade.Reinvest {
-				var payRecLot storage.Lot
-				payRecLot.InstId = txn.GetSettleAmtCcyId()
-				payRecLot.SrcTxnId = txn.GetId()
-				payRecLot.OrigDt = txn.GetTxnDt()
-				payRecLot.OrigSize = txn.GetSettleAmtNet()
-				_, err = s.lotStore.CreateLot(ctx, &payRecLot)
-				if err != nil {
-					return nil, fmt.Errorf("creating payable/receivable lot from processing txn %s: %w", request.GetId(), err)
-				}
-			}
-		// settle
-		case TxnType.Settle:
-			// get the original txn for settlement
-			origTxn, err := s.txnStore.GetTxn(ctx, txn.GetParentId())
+			jrnl.credit(glService.GlRole.RealizedGain, "", txnAmt(txn)-costRelieved)
+		// reinvest
+		case TxnSubType.Trade.Reinvest:
+			// create new lot based on reinvestment
+			lot := txnLot(txn)
+			lot.InstId = txn.InstId
+			lot.OrigDt = txn.TxnDt
+			lot.OrigSize = txn.TxnSize
+			lot.OrigCost = txnCost(txn)
+
+			// create lot
+			reinvestLot, err := s.lotStore.CreateLot(ctx, lot)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
 			}
 
-			// get allocating txns for the settlement
-			// TODO: lookup how to write the function to ignore pagination / sort fields
-			filter := txnStore.TxnFilter{
-				TxnType:  []string{TxnType.Allocation},
-				ParentID: []string{origTxn.GetId()},
-			}
-			filterJSON, err := json.Marshal(filter)
+			// get lotBal and update to auto-settle
+			reinvestLotBal, err := s.lotStore.GetLotBal(ctx, reinvestLot.GetId(), txn.GetSettleDt())
 			if err != nil {
 				return nil, err
 			}
-			allocTxns, err := s.txnStore.ListTxns(ctx, 0, "", string(filterJSON), "")
+
+			reinvestLotBal.SettledSize = reinvestLotBal.LotSize
+			reinvestLotBal.UnsettledSize = 0
+
+			err = s.lotStore.UpdateLotBal(ctx, reinvestLotBal)
 			if err != nil {
 				return nil, err
 			}
 
-			// calc total allocation size found
-			allocTotTxnSize := 0.0
-			for _, allocTxn := range allocTxns {
-				allocTotTxnSize += allocTxn.TxnSize
-			}
-
-			// verify allocating txns total to expected settlement amount
-			if origTxn.GetTxnSize() != allocTotTxnSize {
-				return nil, fmt.Errorf("finding allocating txns; expecting %f, found %f", origTxn.GetTxnSize(), allocTotTxnSize)
+			// find funding lot (using src_lot_id)
+			fundingLotBal, err := s.lotStore.GetLotBal(ctx, txn.GetSrcLotId(), txn.GetSettleDt())
+			if err != nil {
+				return nil, err
 			}
 
-			// loop thru allocating txns to update lotbals
-			for _, allocTxn := range allocTxns {
-				lotBal, err := s.lotStore.GetLotBal(ctx, allocTxn.GetTgtLotId(), txn.GetSettleDt())
-				if err != nil {
-					return nil, err
-				}
-
-				// update the settled size (decrease for sells, increase for buys/reinvests)
-				multiplier := 1.0
-				if origTxn.TxnSubType == TxnSubType.Trade.Sell {
-					multiplier = -1.0
-				}
-				settleSize := allocTxn.GetTxnSize() * multiplier
-				lotBal.SettledSize += settleSize
-				// update lot bal in the data store
-				err = s.lotStore.UpdateLotBal(ctx, lotBal)
-				if err != nil {
-					return nil, err
-				}
+			// error check if funding lot bal is what we expect
+			if fundingLotBal.GetLotSize() != txn.GetSettleAmtNet() {
+				return nil, fmt.Errorf("funding lot %s for reinvest txn %s not the same size", fundingLotBal.GetLotId(), txn.GetId())
 			}
 
-			// find payable/receivable lot using src_txn_id in lot
-			lotFilter := lotStore.LotFilter{
-				SrcTxnID: []string{txn.GetParentId()},
-			}
-			lotFilterJSON, err := json.Marshal(lotFilter)
+			// update funding lot to 0
+			fundingLotBal.LotSize = 0
+			fundingLotBal.SettledSize = 0
+			fundingLotBal.UnsettledSize = 0
+			err = s.lotStore.UpdateLotBal(ctx, fundingLotBal)
 			if err != nil {
 				return nil, err
 			}
-			payRecLots, err := s.lotStore.ListLots(ctx, 0, "", string(lotFilterJSON), "")
+			jrnl.debit(glService.GlRole.SecuritiesCost, reinvestLot.GetId(), txnAmt(txn))
+			jrnl.credit(glService.GlRole.Cash, txn.GetSrcLotId(), txnAmt(txn))
+		}
+		// generate payable/receivable for non-reinvestment trades
+		if txn.TxnSubType != TxnSubType.Trade.Reinvest {
+			payRecLot := txnLot(txn)
+			payRecLot.InstId = txn.GetSettleAmtCcyId()
+			payRecLot.OrigDt = txn.GetTxnDt()
+			payRecLot.OrigSize = txn.GetSettleAmtNet()
+			payRecLot.OrigCost = txn.GetSettleAmtNet()
+			payRecLot, err = s.lotStore.CreateLot(ctx, payRecLot)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("creating payable/receivable lot from processing txn %s: %w", request.GetId(), err)
 			}
-			if len(payRecLots) > 1 {
-				return nil, fmt.Errorf("Found more than one payable/receivable processing txn: %s with parent id: %s", txn.GetId(), txn.GetParentId())
+			if txn.TxnSubType == TxnSubType.Trade.Sell {
+				jrnl.debit(glService.GlRole.Receivable, payRecLot.GetId(), txnAmt(txn))
+			} else {
+				jrnl.credit(glService.GlRole.Payable, payRecLot.GetId(), txnAmt(txn))
 			}
+		}
+	// settle
+	case TxnType.Settle:
+		// get the original txn for settlement
+		origTxn, err := s.txnStore.GetTxn(ctx, txn.GetParentId())
+		if err != nil {
+			return nil, err
+		}
 
-			// update payable/receivable settle size which will implicitly turn it into a normal currency holding
-			payRecLot := payRecLots[0]
-			payRecLotBal, err := s.lotStore.GetLotBal(ctx, payRecLot.GetId(), txn.GetSettleDt())
-			payRecLotBal.SettledSize = payRecLotBal.GetLotSize()
-			payRecLotBal.UnsettledSize = 0
-			err = s.lotStore.UpdateLotBal(ctx, payRecLotBal)
+		// get allocating txns for the settlement
+		// TODO: lookup how to write the function to ignore pagination / sort fields
+		filter := txnStore.TxnFilter{
+			TxnType:  []string{TxnType.Allocation},
+			ParentID: []string{origTxn.GetId()},
+		}
+		allocTxns, _, err := s.txnStore.ListTxns(ctx, 0, "", filter.String(), "")
+		if err != nil {
+			return nil, err
+		}
+
+		// calc total allocation size found
+		allocTotTxnSize := 0.0
+		for _, allocTxn := range allocTxns {
+			allocTotTxnSize += allocTxn.TxnSize
+		}
+
+		// verify allocating txns total to expected settlement amount
+		if origTxn.GetTxnSize() != allocTotTxnSize {
+			return nil, fmt.Errorf("finding allocating txns; expecting %f, found %f", origTxn.GetTxnSize(), allocTotTxnSize)
+		}
+
+		// loop thru allocating txns to update lotbals
+		for _, allocTxn := range allocTxns {
+			lotBal, err := s.lotStore.GetLotBal(ctx, allocTxn.GetTgtLotId(), txn.GetSettleDt())
 			if err != nil {
 				return nil, err
 			}
-		// sweep
-		case TxnType.Sweep:
-			var sweepLotBalID string
-			var cashLotBalID string
 
-			// determine which txn lotbal id is the sweep and cash
-			if txn.GetTxnSubType() == TxnSubType.Sweep.In {
-				sweepLotBalID = txn.GetTgtLotId()
-				cashLotBalID = txn.GetSrcLotId()
-			} else {
-				sweepLotBalID = txn.GetSrcLotId()
-				cashLotBalID = txn.GetTgtLotId()
+			// update the settled size (decrease for sells, increase for buys/reinvests)
+			multiplier := 1.0
+			if origTxn.TxnSubType == TxnSubType.Trade.Sell {
+				multiplier = -1.0
 			}
-
-			// get source lot id size, settled size, and unsettled size
-			cashLotBal, err := s.lotStore.GetLotBal(ctx, cashLotBalID, txn.GetSettleDt())
+			settleSize := allocTxn.GetTxnSize() * multiplier
+			lotBal.SettledSize += settleSize
+			// update lot bal in the data store
+			err = s.lotStore.UpdateLotBal(ctx, lotBal)
 			if err != nil {
 				return nil, err
 			}
+		}
 
-			// if unsettled size is != zero, error out (can't sweep unsettled cash)
-			if cashLotBal.GetUnsettledSize() != 0 {
-				return nil, fmt.Errorf("source cash lot: %s has unsettled size while processing txn: %s", cashLotBalID, txn.GetId())
-			}
+		// find payable/receivable lot using src_txn_id in lot
+		lotFilter := lotStore.LotFilter{
+			SrcTxnID: []string{txn.GetParentId()},
+		}
+		payRecLots, _, err := s.lotStore.ListLots(ctx, 0, "", lotFilter.String(), "")
+		if err != nil {
+			return nil, err
+		}
+		if len(payRecLots) > 1 {
+			return nil, fmt.Errorf("Found more than one payable/receivable processing txn: %s with parent id: %s", txn.GetId(), txn.GetParentId())
+		}
 
-			// get target lot id record
-			sweepLotBal, err := s.lotStore.GetLotBal(ctx, sweepLotBalID, txn.GetSettleDt())
-			if err != nil {
-				return nil, err
+		// update payable/receivable settle size which will implicitly turn it into a normal currency holding
+		payRecLot := payRecLots[0]
+		payRecLotBal, err := s.lotStore.GetLotBal(ctx, payRecLot.GetId(), txn.GetSettleDt())
+		payRecLotBal.SettledSize = payRecLotBal.GetLotSize()
+		payRecLotBal.UnsettledSize = 0
+		err = s.lotStore.UpdateLotBal(ctx, payRecLotBal)
+		if err != nil {
+			return nil, err
+		}
+
+		// the payable/receivable turns into cash
+		jrnl.ccyID = txnCcy(origTxn)
+		if origTxn.TxnSubType == TxnSubType.Trade.Sell {
+			jrnl.debit(glService.GlRole.Cash, payRecLot.GetId(), txnAmt(origTxn))
+			jrnl.credit(glService.GlRole.Receivable, payRecLot.GetId(), txnAmt(origTxn))
+		} else {
+			jrnl.debit(glService.GlRole.Payable, payRecLot.GetId(), txnAmt(origTxn))
+			jrnl.credit(glService.GlRole.Cash, payRecLot.GetId(), txnAmt(origTxn))
+		}
+	// sweep
+	case TxnType.Sweep:
+		var sweepLotBalID string
+		var cashLotBalID string
+
+		// determine which txn lotbal id is the sweep and cash
+		if txn.GetTxnSubType() == TxnSubType.Sweep.In {
+			sweepLotBalID = txn.GetTgtLotId()
+			cashLotBalID = txn.GetSrcLotId()
+		} else {
+			sweepLotBalID = txn.GetSrcLotId()
+			cashLotBalID = txn.GetTgtLotId()
+		}
+
+		// get source lot id size, settled size, and unsettled size
+		cashLotBal, err := s.lotStore.GetLotBal(ctx, cashLotBalID, txn.GetSettleDt())
+		if err != nil {
+			return nil, err
+		}
+
+		// if unsettled size is != zero, error out (can't sweep unsettled cash)
+		if cashLotBal.GetUnsettledSize() != 0 {
+			return nil, fmt.Errorf("source cash lot: %s has unsettled size while processing txn: %s", cashLotBalID, txn.GetId())
+		}
+
+		// get target lot id record
+		sweepLotBal, err := s.lotStore.GetLotBal(ctx, sweepLotBalID, txn.GetSettleDt())
+		if err != nil {
+			return nil, err
+		}
+
+		// cash moves between the cash and sweep lots
+		jrnl.debit(glService.GlRole.Cash, txn.GetTgtLotId(), cashLotBal.GetLotSize())
+		jrnl.credit(glService.GlRole.Cash, txn.GetSrcLotId(), cashLotBal.GetLotSize())
+
+		// update target lot size and settled size based on source lot size
+		sweepLotBal.LotSize += cashLotBal.LotSize
+		sweepLotBal.SettledSize = sweepLotBal.LotSize
+		if err = s.lotStore.UpdateLotBal(ctx, sweepLotBal); err != nil {
+			return nil, err
+		}
+
+		// update source lot size and settled size to 0
+		cashLotBal.LotSize = 0
+		cashLotBal.SettledSize = 0
+		if err = s.lotStore.UpdateLotBal(ctx, cashLotBal); err != nil {
+			return nil, err
+		}
+	// income
+	case TxnType.Income:
+		switch txn.TxnSubType {
+		// dividend / interest
+		case TxnSubType.Income.Dividend, TxnSubType.Income.Interest:
+			// an accrued dividend's receivable lot turns into cash
+			var incomeLot *storage.Lot
+			if jrnl.acctBasis == acctService.AcctBasis.Accrual {
+				incomeLot, err = s.receivableLot(ctx, txn)
+				if err != nil {
+					return nil, err
+				}
 			}
+			recLotID := incomeLot.GetId()
 
-			// update target lot size and settled size based on source lot size
-			sweepLotBal.LotSize += cashLotBal.LotSize
-			sweepLotBal.SettledSize = sweepLotBal.LotSize
-			s.lotStore.UpdateLotBal(ctx, sweepLotBal)
-
-			// update source lot size and settled size to 0
-			cashLotBal.LotSize = 0
-			cashLotBal.SettledSize = 0
-			s.lotStore.UpdateLotBal(ctx, cashLotBal)
-		// income
-		case TxnType.Income:
-			switch txn.TxnSubType {
-			// dividend
-			case TxnSubType.Income.Dividend:
-				var lot storage.Lot
+			if incomeLot != nil {
+				recLotBal, err := s.lotStore.GetLotBal(ctx, incomeLot.GetId(), txn.GetSettleDt())
+				if err != nil {
+					return nil, err
+				}
+				recLotBal.LotSize = txn.GetTxnSize()
+				recLotBal.SettledSize = recLotBal.GetLotSize()
+				recLotBal.UnsettledSize = 0
+				if err = s.lotStore.UpdateLotBal(ctx, recLotBal); err != nil {
+					return nil, err
+				}
+			} else {
+				lot := txnLot(txn)
 				lot.InstId = txn.SettleAmtCcyId
-				lot.SrcTxnId = txn.Id
 				lot.OrigDt = txn.SettleDt
 				lot.OrigSize = txn.TxnSize
+				lot.OrigCost = txn.TxnSize
 
-				// create new lot from dividend transaction
-				divLot, err := s.lotStore.CreateLot(ctx, &lot)
+				// create new lot from income transaction
+				incomeLot, err = s.lotStore.CreateLot(ctx, lot)
 				if err != nil {
 					return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
 				}
 
 				// get the lotBal to update the settled/unsettled size
-				divLotBal, err := s.lotStore.GetLotBal(ctx, divLot.GetId(), divLot.GetOrigDt())
+				incomeLotBal, err := s.lotStore.GetLotBal(ctx, incomeLot.GetId(), incomeLot.GetOrigDt())
 				if err != nil {
 					return nil, err
 				}
 
 				// update lotBal settled/unsettled amts (same day settle)
-				divLotBal.SettledSize = divLotBal.GetLotSize()
-				divLotBal.UnsettledSize = 0
+				incomeLotBal.SettledSize = incomeLotBal.GetLotSize()
+				incomeLotBal.UnsettledSize = 0
 
 				// update lotBal
-				err = s.lotStore.UpdateLotBal(ctx, divLotBal)
+				err = s.lotStore.UpdateLotBal(ctx, incomeLotBal)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if jrnl.acctBasis == acctService.AcctBasis.Accrual {
+				accrued, _, err := s.accrued(ctx, jrnl)
 				if err != nil {
 					return nil, err
 				}
+
+				// the cash basis recognizes the income when it's paid, while the accrual basis releases the
+				// receivable and trues income up to what was paid
+				cashJrnl = jrnl.fork(glService.GlBasis.Cash)
+				cashJrnl.debit(glService.GlRole.Cash, incomeLot.GetId(), txn.GetTxnSize())
+				cashJrnl.credit(glService.GlRole.Income, "", txn.GetTxnSize())
+
+				jrnl.basis = glService.GlBasis.Accrual
+				jrnl.debit(glService.GlRole.Cash, incomeLot.GetId(), txn.GetTxnSize())
+				jrnl.credit(glService.GlRole.Receivable, recLotID, accrued)
+				jrnl.credit(glService.GlRole.Income, "", txn.GetTxnSize()-accrued)
+			} else {
+				jrnl.debit(glService.GlRole.Cash, incomeLot.GetId(), txn.GetTxnSize())
+				jrnl.credit(glService.GlRole.Income, "", txn.GetTxnSize())
+			}
+		}
+	// transfer
+	case TxnType.Transfer:
+		switch txn.TxnSubType {
+		// transfer in
+		case TxnSubType.Transfer.In:
+			lot := txnLot(txn)
+			lot.InstId = txn.GetInstId()
+			lot.OrigDt = txn.GetTxnDt()
+			lot.OrigSize = txn.GetTxnSize()
+			lot.OrigCost = txnCost(txn)
+
+			// create new (settled) lot from the transfer
+			xferLot, err := s.createSettledLot(ctx, lot)
+			if err != nil {
+				return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
+			}
+			jrnl.ccyID = txn.GetTradeAmtCcyId()
+			jrnl.debit(glService.GlRole.SecuritiesCost, xferLot.GetId(), lot.GetOrigCost())
+			jrnl.credit(glService.GlRole.Capital, "", lot.GetOrigCost())
+		// transfer out
+		case TxnSubType.Transfer.Out:
+			err = s.reduceLotBal(ctx, txn.GetSrcLotId(), txn.GetTxnDt(), txn.GetTxnSize())
+			if err != nil {
+				return nil, fmt.Errorf("reducing lot from processing txn %s: %w", request.GetId(), err)
+			}
+
+			// relieve the cost of the size transferred
+			xferLot, err := s.lotStore.GetLot(ctx, txn.GetSrcLotId(), "")
+			if err != nil {
+				return nil, err
+			}
+			cost := lotCost(xferLot, txn.GetTxnSize())
+			jrnl.ccyID = txn.GetTradeAmtCcyId()
+			jrnl.debit(glService.GlRole.Capital, "", cost)
+			jrnl.credit(glService.GlRole.SecuritiesCost, txn.GetSrcLotId(), cost)
+		}
+	// cash flow
+	case TxnType.CashFlow:
+		switch txn.TxnSubType {
+		// contribution
+		case TxnSubType.CashFlow.Contribution:
+			lot := txnLot(txn)
+			lot.InstId = txn.GetSettleAmtCcyId()
+			lot.OrigDt = txn.GetSettleDt()
+			lot.OrigSize = txn.GetSettleAmtNet()
+			lot.OrigCost = txn.GetSettleAmtNet()
+
+			// create new (settled) cash lot from the contribution
+			cashLot, err := s.createSettledLot(ctx, lot)
+			if err != nil {
+				return nil, fmt.Errorf("creating lot from processing txn %s: %w", request.GetId(), err)
+			}
+			jrnl.debit(glService.GlRole.Cash, cashLot.GetId(), txn.GetSettleAmtNet())
+			jrnl.credit(glService.GlRole.Capital, "", txn.GetSettleAmtNet())
+		// withdrawal
+		case TxnSubType.CashFlow.Withdrawal:
+			err = s.reduceLotBal(ctx, txn.GetSrcLotId(), txn.GetSettleDt(), txn.GetSettleAmtNet())
+			if err != nil {
+				return nil, fmt.Errorf("reducing lot from processing txn %s: %w", request.GetId(), err)
 			}
+			jrnl.debit(glService.GlRole.Capital, "", txn.GetSettleAmtNet())
+			jrnl.credit(glService.GlRole.Cash, txn.GetSrcLotId(), txn.GetSettleAmtNet())
+		}
+	// fee
+	case TxnType.Fee:
+		// fees are paid out of a cash lot
+		err = s.reduceLotBal(ctx, txn.GetSrcLotId(), txn.GetSettleDt(), txn.GetSettleAmtNet())
+		if err != nil {
+			return nil, fmt.Errorf("reducing lot from processing txn %s: %w", request.GetId(), err)
+		}
+		jrnl.debit(glService.GlRole.Fee, "", txn.GetSettleAmtNet())
+		jrnl.credit(glService.GlRole.Cash, txn.GetSrcLotId(), txn.GetSettleAmtNet())
+	}
+
+	// post the journal for everything processed
+	if _, err = s.postJournal(ctx, jrnl); err != nil {
+		return nil, err
+	}
+	if cashJrnl != nil {
+		if _, err = s.postJournal(ctx, cashJrnl); err != nil {
+			return nil, err
 		}
 	}
 
 	// update transaction state to processed if all went well
 	txn.State = TxnState.Processed
-	err = s.txnStore.UpdateTxn(ctx, txn, nil)
+	err = s.txnStore.ProcessTxn(ctx, txn)
 	if err != nil {
 		return nil, fmt.Errorf("updating transaction %s state to %s: %w", request.GetId(), TxnState.Processed, err)
 	}
@@ -493,3 +923,1077 @@ func (s *TxnServiceImpl) ProcessTxn(ctx context.Context, request *v1.ProcessTxnR
 		Id:    request.GetId(),
 		State: TxnState.Processed}, nil
 }
+
+// AccrueIncome accrues an open income transaction for an account kept on an accrual basis. dividends are
+// booked in full on the ex date (the txn date) as a receivable lot, while interest accrues daily from the
+// start of the accrual period through the pay date (the settle date), or an earlier thru date. the
+// receivable is released when the income transaction is processed. the receivable lot and journals accruing
+// creates are committed together, so an accrual that fails leaves nothing behind to be accrued again
+func (s *TxnServiceImpl) AccrueIncome(ctx context.Context, request *v1.AccrueIncomeRequest) (*v1.AccrueIncomeResponse, error) {
+	var response *v1.AccrueIncomeResponse
+	err := s.inTx(ctx, func(s *TxnServiceImpl) error {
+		var err error
+		response, err = s.accrueIncome(ctx, request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// accrueIncome accrues an income transaction with whatever database transaction the service's stores are bound to
+func (s *TxnServiceImpl) accrueIncome(ctx context.Context, request *v1.AccrueIncomeRequest) (*v1.AccrueIncomeResponse, error) {
+	txn, err := s.txnStore.GetTxn(ctx, request.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if txn.GetTxnType() != TxnType.Income {
+		return nil, status.Errorf(codes.InvalidArgument, "txn %s is not an income txn", txn.GetId())
+	}
+	if txn.GetState() != TxnState.Open {
+		return nil, status.Errorf(codes.FailedPrecondition, "txn %s is not open", txn.GetId())
+	}
+
+	jrnl, err := s.newJournal(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+	if jrnl.acctBasis != acctService.AcctBasis.Accrual {
+		return nil, status.Errorf(codes.FailedPrecondition, "acct %s is not kept on an accrual basis", txn.GetAcctId())
+	}
+	accrued, lastDt, err := s.accrued(ctx, jrnl)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.AccrueIncomeResponse{
+		Id: txn.GetId(),
+	}
+	switch txn.GetTxnSubType() {
+	// dividend
+	case TxnSubType.Income.Dividend:
+		if lastDt != "" {
+			return nil, status.Errorf(codes.FailedPrecondition, "txn %s has already been accrued", txn.GetId())
+		}
+		exDt := dateOnly(txn.GetTxnDt())
+		if err = s.checkOpen(ctx, txn, exDt); err != nil {
+			return nil, err
+		}
+		if err = s.guard.Check(ctx, txnTarget(txn), exDt, txn.GetId()); err != nil {
+			return nil, err
+		}
+
+		lot := txnLot(txn)
+		lot.InstId = txn.GetSettleAmtCcyId()
+		lot.OrigDt = exDt
+		lot.OrigSize = txn.GetTxnSize()
+		lot.OrigCost = txn.GetTxnSize()
+
+		// create new (unsettled) receivable lot on the ex date
+		recLot, err := s.lotStore.CreateLot(ctx, lot)
+		if err != nil {
+			return nil, fmt.Errorf("creating receivable lot from accruing txn %s: %w", txn.GetId(), err)
+		}
+
+		dayJrnl := jrnl.fork(glService.GlBasis.Accrual)
+		dayJrnl.dt = exDt
+		dayJrnl.memo = "accrual " + txn.GetTxnSubType()
+		dayJrnl.debit(glService.GlRole.Receivable, recLot.GetId(), txn.GetTxnSize())
+		dayJrnl.credit(glService.GlRole.Income, "", txn.GetTxnSize())
+		posted, err := s.postJournal(ctx, dayJrnl)
+		if err != nil {
+			return nil, err
+		}
+		accrued += txn.GetTxnSize()
+		response.Journals = append(response.Journals, posted)
+	// interest
+	case TxnSubType.Income.Interest:
+		start, err := time.Parse(config.APIFormats.DateFmt, dateOnly(request.GetAccrualStartDt()))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid accrual start date %s", request.GetAccrualStartDt())
+		}
+		end, err := time.Parse(config.APIFormats.DateFmt, dateOnly(txn.GetSettleDt()))
+		if err != nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "txn %s needs a settle (pay) date to accrue interest", txn.GetId())
+		}
+		if !end.After(start) {
+			return nil, status.Error(codes.InvalidArgument, "accrual start date is not before the pay date")
+		}
+		thru := end
+		if request.GetThruDt() != "" {
+			thru, err = time.Parse(config.APIFormats.DateFmt, dateOnly(request.GetThruDt()))
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid thru date %s", request.GetThruDt())
+			}
+			if thru.After(end) {
+				thru = end
+			}
+		}
+
+		// pick up the day after the last accrual
+		from := start.AddDate(0, 0, 1)
+		if lastDt != "" {
+			last, err := time.Parse(config.APIFormats.DateFmt, lastDt)
+			if err != nil {
+				return nil, err
+			}
+			from = last.AddDate(0, 0, 1)
+		}
+		if err = s.checkOpen(ctx, txn, from.Format(config.APIFormats.DateFmt)); err != nil {
+			return nil, err
+		}
+		if err = s.guard.Check(ctx, txnTarget(txn), from.Format(config.APIFormats.DateFmt), txn.GetId()); err != nil {
+			return nil, err
+		}
+
+		days := end.Sub(start).Hours() / 24
+		daily := txn.GetTxnSize() / days
+		for dt := from; !dt.After(thru); dt = dt.AddDate(0, 0, 1) {
+			// the last day picks up any rounding so the full amount is accrued by the pay date
+			amt := daily
+			if dt.Equal(end) {
+				amt = txn.GetTxnSize() - accrued
+			}
+
+			dayJrnl := jrnl.fork(glService.GlBasis.Accrual)
+			dayJrnl.dt = dt.Format(config.APIFormats.DateFmt)
+			dayJrnl.memo = "accrual " + txn.GetTxnSubType()
+			dayJrnl.debit(glService.GlRole.Receivable, "", amt)
+			dayJrnl.credit(glService.GlRole.Income, "", amt)
+			posted, err := s.postJournal(ctx, dayJrnl)
+			if err != nil {
+				return nil, err
+			}
+			accrued += amt
+			if posted != nil {
+				response.Journals = append(response.Journals, posted)
+			}
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "txn sub type %s can't be accrued", txn.GetTxnSubType())
+	}
+	response.AccruedAmt = accrued
+
+	return response, nil
+}
+
+// ImportTxns imports a csv, ofx/qfx, FIX, or SWIFT file of transactions via the Transaction service. every row is
+// validated and, unless it's a dry run, either every row is created or, if any row has a problem, none are.
+// the problems found are reported by line. rows with an ext_id already imported into the same account are
+// skipped, so a file can be imported again safely
+func (s *TxnServiceImpl) ImportTxns(ctx context.Context, request *v1.ImportTxnsRequest) (*v1.ImportTxnsResponse, error) {
+	formats := 0
+	for _, data := range []string{request.GetCsv(), request.GetOfx(), request.GetFix(), request.GetSwift()} {
+		if data != "" {
+			formats++
+		}
+	}
+	if formats > 1 {
+		return nil, status.Error(codes.InvalidArgument, "only one of csv, ofx, fix, or swift is expected")
+	}
+	if len(request.GetMapping()) > 0 && request.GetCsv() == "" {
+		return nil, status.Error(codes.InvalidArgument, "mapping is only used for csv imports")
+	}
+
+	var rows []*imp.Row
+	var err error
+	switch {
+	case request.GetOfx() != "":
+		rows, err = imp.ParseOFX(strings.NewReader(request.GetOfx()))
+	case request.GetFix() != "":
+		rows, err = imp.ParseFIX(strings.NewReader(request.GetFix()))
+		if err == nil && len(rows) == 0 {
+			// most FIX messages aren't fills, which is fine for a drop copy
+			return &v1.ImportTxnsResponse{}, nil
+		}
+	case request.GetSwift() != "":
+		rows, err = imp.ParseSWIFT(strings.NewReader(request.GetSwift()))
+	default:
+		mapping := request.GetMapping()
+		if len(mapping) == 0 {
+			mapping = nil
+		}
+		rows, err = imp.ParseCSV(strings.NewReader(request.GetCsv()), mapping)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// statements reference securities the data store may not have yet, so they're created as needed
+	return s.importRows(ctx, rows, request.GetDryRun(), request.GetOfx() != "")
+}
+
+// importRows resolves and validates parsed import rows and, unless it's a dry run and as long as no row
+// has a problem, creates their transactions in one go. lock dates are checked without recording overrides
+// on a dry run. if createInsts is set, instruments that can't be resolved are created along with the
+// transactions rather than reported as problems. rows referencing a parent (the fills of an order) are
+// created under a multileg parent txn
+func (s *TxnServiceImpl) importRows(ctx context.Context, rows []*imp.Row, dryRun bool, createInsts bool) (*v1.ImportTxnsResponse, error) {
+	if len(rows) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "import has no rows")
+	}
+
+	res, err := s.newResolver(ctx, createInsts)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		res.resolve(row)
+		checkTxn(row)
+	}
+	rows, skipped, err := s.dedupe(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.groupOrders(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	txns := make([]*storage.Txn, 0, len(rows))
+	for _, row := range rows {
+		if dryRun && len(row.Errs) == 0 {
+			locked, err := s.guard.Locked(ctx, txnTarget(row.Txn), lockDt(row.Txn))
+			if err != nil {
+				return nil, err
+			}
+			if locked != "" {
+				row.Fail("", "on or before the lock date %s", locked)
+			}
+		}
+		txns = append(txns, row.Txn)
+	}
+
+	response := &v1.ImportTxnsResponse{
+		Txns:    txns,
+		Errors:  importErrors(rows),
+		Skipped: int32(skipped),
+	}
+	if dryRun || len(response.GetErrors()) > 0 {
+		return response, nil
+	}
+
+	// every row may have been imported already. the lock dates are checked once every row is valid, in the
+	// same transaction as the txns so overrides are only recorded if they're imported. instruments are only
+	// created along with the txns, and a txn imported by someone else since it was deduped is skipped
+	if len(txns) > 0 {
+		err = s.inTx(ctx, func(s *TxnServiceImpl) error {
+			for _, row := range rows {
+				if err := s.checkLock(ctx, row.Txn); err != nil {
+					switch status.Code(err) {
+					case codes.FailedPrecondition, codes.PermissionDenied:
+						row.Fail("", "%s", status.Convert(err).Message())
+					default:
+						return err
+					}
+				}
+			}
+			if response.Errors = importErrors(rows); len(response.GetErrors()) > 0 {
+				return errRowsLocked
+			}
+
+			if err := s.createInsts(ctx, res); err != nil {
+				return err
+			}
+			n, err := s.txnStore.CreateTxnGroups(ctx, txnGroups(txns, orders))
+			if err != nil {
+				return err
+			}
+			response.Skipped += int32(n)
+			return nil
+		})
+		if err == errRowsLocked {
+			return response, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	response.Committed = true
+
+	return response, nil
+}
+
+// order is the fills of an order being imported, along with the order's multileg parent txn if it was
+// imported before
+type order struct {
+	ref    string
+	parent *storage.Txn
+	rows   []*imp.Row
+}
+
+// groupOrders groups import rows referencing a parent into orders by account and the parent's ext_id,
+// finding the parents imported already. the fills of an order have to share its side and instrument
+func (s *TxnServiceImpl) groupOrders(ctx context.Context, rows []*imp.Row) ([]*order, error) {
+	var orders []*order
+	var refs []string
+	byKey := make(map[string]*order)
+	for _, row := range rows {
+		ref := row.Refs.ParentRef
+		if ref == "" || row.Txn.GetAcctId() == "" {
+			continue
+		}
+		key := row.Txn.GetAcctId() + "/" + ref
+		if byKey[key] == nil {
+			byKey[key] = &order{ref: ref}
+			orders = append(orders, byKey[key])
+			if !containsStr(refs, ref) {
+				refs = append(refs, ref)
+			}
+		}
+		byKey[key].rows = append(byKey[key].rows, row)
+	}
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	filter := txnStore.TxnFilter{ExtID: refs, TxnType: []string{TxnType.Multileg}}
+	parents, _, err := s.txnStore.ListTxns(ctx, 0, "", filter.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		if o := byKey[extKey(parent)]; o != nil {
+			o.parent = parent
+		}
+	}
+
+	for _, o := range orders {
+		first := o.rows[0].Txn
+		if o.parent != nil {
+			first = o.parent
+		}
+		for _, row := range o.rows {
+			if row.Txn.GetTxnSubType() != first.GetTxnSubType() {
+				row.Fail("txn_sub_type", "%s doesn't match the %s side of order %s", row.Txn.GetTxnSubType(), first.GetTxnSubType(), o.ref)
+			}
+			if row.Txn.GetInstId() != "" && first.GetInstId() != "" && row.Txn.GetInstId() != first.GetInstId() {
+				row.Fail("inst_id", "doesn't match the instrument of order %s", o.ref)
+			}
+		}
+	}
+
+	return orders, nil
+}
+
+// txnGroups groups imported transactions for creation: the fills of each order under its parent, and the
+// rest on their own
+func txnGroups(txns []*storage.Txn, orders []*order) []*txnStore.TxnGroup {
+	groups := []*txnStore.TxnGroup{{}}
+	filled := make(map[*storage.Txn]bool)
+	for _, o := range orders {
+		group := &txnStore.TxnGroup{Parent: orderParent(o)}
+		for _, row := range o.rows {
+			group.Kids = append(group.Kids, row.Txn)
+			filled[row.Txn] = true
+		}
+		groups = append(groups, group)
+	}
+	for _, txn := range txns {
+		if !filled[txn] {
+			groups[0].Kids = append(groups[0].Kids, txn)
+		}
+	}
+
+	return groups
+}
+
+// orderParent totals the fills of an order into its multileg parent txn. a new parent takes its details
+// from the fills and, as it only groups them, is never processed itself. for an existing parent only the
+// totals of the new fills are returned, to be added to the parent's as long as it's still at the version
+// read
+func orderParent(o *order) *storage.Txn {
+	first := o.rows[0].Txn
+	parent := &storage.Txn{}
+	if o.parent != nil {
+		parent.Id = o.parent.GetId()
+		parent.Version = o.parent.GetVersion()
+	} else {
+		parent.TxnType = TxnType.Multileg
+		parent.TxnSubType = first.GetTxnSubType()
+		parent.State = TxnState.Processed
+		parent.ExtId = o.ref
+		parent.InstId = first.GetInstId()
+		parent.TradeAmtCcyId = first.GetTradeAmtCcyId()
+		parent.SettleAmtCcyId = first.GetSettleAmtCcyId()
+		parent.AcctId = first.GetAcctId()
+		parent.LeOrgId = first.GetLeOrgId()
+		parent.PortId = first.GetPortId()
+		parent.StratId = first.GetStratId()
+	}
+	for _, row := range o.rows {
+		txn := row.Txn
+		if o.parent == nil {
+			if parent.GetTxnDt() == "" || txn.GetTxnDt() < parent.GetTxnDt() {
+				parent.TxnDt = txn.GetTxnDt()
+			}
+			if parent.GetSettleDt() == "" || (txn.GetSettleDt() != "" && txn.GetSettleDt() < parent.GetSettleDt()) {
+				parent.SettleDt = txn.GetSettleDt()
+			}
+		}
+		parent.TxnSize += txn.GetTxnSize()
+		parent.TradeAmtGross += txn.GetTradeAmtGross()
+		parent.TradeAmtNet += txn.GetTradeAmtNet()
+		parent.SettleAmtGross += txn.GetSettleAmtGross()
+		parent.SettleAmtNet += txn.GetSettleAmtNet()
+	}
+
+	return parent
+}
+
+// dedupe drops valid import rows with an ext_id already imported into the same account, either earlier in
+// the file or by a previous import, returning the rows left and how many were dropped
+func (s *TxnServiceImpl) dedupe(ctx context.Context, rows []*imp.Row) ([]*imp.Row, int, error) {
+	var extIDs []string
+	for _, row := range rows {
+		if row.Txn.GetExtId() != "" && !containsStr(extIDs, row.Txn.GetExtId()) {
+			extIDs = append(extIDs, row.Txn.GetExtId())
+		}
+	}
+	if len(extIDs) == 0 {
+		return rows, 0, nil
+	}
+
+	filter := txnStore.TxnFilter{ExtID: extIDs}
+	existing, _, err := s.txnStore.ListTxns(ctx, 0, "", filter.String(), "")
+	if err != nil {
+		return nil, 0, err
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, txn := range existing {
+		seen[extKey(txn)] = true
+	}
+
+	kept := rows[:0]
+	skipped := 0
+	for _, row := range rows {
+		if row.Txn.GetExtId() != "" && row.Txn.GetAcctId() != "" && len(row.Errs) == 0 {
+			key := extKey(row.Txn)
+			if seen[key] {
+				skipped++
+				continue
+			}
+			seen[key] = true
+		}
+		kept = append(kept, row)
+	}
+
+	return kept, skipped, nil
+}
+
+// extKey identifies an imported transaction by its account and ext_id
+func extKey(txn *storage.Txn) string {
+	return txn.GetAcctId() + "/" + txn.GetExtId()
+}
+
+// createInsts creates the instruments queued by a resolver, setting the ids of the transactions that
+// reference them
+func (s *TxnServiceImpl) createInsts(ctx context.Context, res *resolver) error {
+	for _, newInst := range res.newInsts {
+		inst, err := s.instStore.CreateInst(ctx, newInst.inst)
+		if err != nil {
+			return err
+		}
+		for _, txn := range newInst.txns {
+			txn.InstId = inst.GetId()
+		}
+	}
+
+	return nil
+}
+
+// importErrors collects the problems found in a set of import rows
+func importErrors(rows []*imp.Row) []*v1.ImportError {
+	var errs []*v1.ImportError
+	for _, row := range rows {
+		for _, e := range row.Errs {
+			errs = append(errs, &v1.ImportError{
+				Line:  int32(e.Line),
+				Field: e.Field,
+				Msg:   e.Msg,
+			})
+		}
+	}
+
+	return errs
+}
+
+// checkTxn records any problems with an imported transaction's type and sub type. imported transactions
+// are always open
+func checkTxn(row *imp.Row) {
+	txn := row.Txn
+	if subTypes, ok := txnSubTypes[txn.GetTxnType()]; !ok {
+		if txn.GetTxnType() != "" {
+			row.Fail("txn_type", "unknown txn type %q", txn.GetTxnType())
+		}
+	} else if !containsStr(subTypes, txn.GetTxnSubType()) {
+		row.Fail("txn_sub_type", "unknown sub type %q for txn type %s", txn.GetTxnSubType(), txn.GetTxnType())
+	}
+	txn.State = TxnState.Open
+}
+
+// containsStr checks if a list of strings contains a string
+func containsStr(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// resolver resolves references in import rows: instruments by isin, cusip or ticker (local or varangian)
+// and accounts by name or external reference, ignoring case
+type resolver struct {
+	insts    map[string][]string
+	cusips   map[string][]string
+	isins    map[string][]string
+	accts    map[string][]string
+	acctRefs map[string][]string
+	create   bool
+	newInsts map[string]*newInst
+}
+
+// newInst is an instrument to be created for an import, along with the transactions referencing it
+type newInst struct {
+	inst *storage.Inst
+	txns []*storage.Txn
+}
+
+// newResolver builds a resolver from the instruments and accounts in the data store. if create is set,
+// instruments that can't be resolved are queued to be created instead of being reported
+func (s *TxnServiceImpl) newResolver(ctx context.Context, create bool) (*resolver, error) {
+	insts, _, err := s.instStore.ListInsts(ctx, 0, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	accts, _, err := s.acctStore.ListAccts(ctx, 0, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	res := &resolver{
+		insts:    make(map[string][]string),
+		cusips:   make(map[string][]string),
+		isins:    make(map[string][]string),
+		accts:    make(map[string][]string),
+		acctRefs: make(map[string][]string),
+		create:   create,
+		newInsts: make(map[string]*newInst),
+	}
+	for _, inst := range insts {
+		for _, ticker := range []string{inst.GetTickerLocal(), inst.GetTickerVgn()} {
+			key := strings.ToLower(ticker)
+			if ticker != "" && !containsStr(res.insts[key], inst.GetId()) {
+				res.insts[key] = append(res.insts[key], inst.GetId())
+			}
+		}
+		if inst.GetCusip() != "" {
+			key := strings.ToLower(inst.GetCusip())
+			res.cusips[key] = append(res.cusips[key], inst.GetId())
+		}
+		if inst.GetIsin() != "" {
+			key := strings.ToLower(inst.GetIsin())
+			res.isins[key] = append(res.isins[key], inst.GetId())
+		}
+	}
+	for _, acct := range accts {
+		if acct.GetName() != "" {
+			key := strings.ToLower(acct.GetName())
+			res.accts[key] = append(res.accts[key], acct.GetId())
+		}
+		if acct.GetExtRef() != "" {
+			key := strings.ToLower(acct.GetExtRef())
+			res.acctRefs[key] = append(res.acctRefs[key], acct.GetId())
+		}
+	}
+
+	return res, nil
+}
+
+// resolve sets the ids referenced by an import row, recording a problem for any reference that matches
+// nothing or more than one thing
+func (r *resolver) resolve(row *imp.Row) {
+	txn := row.Txn
+	txn.InstId = r.inst(row)
+	txn.AcctId = r.lookup(row, "acct_name", row.Refs.AcctName, r.accts, txn.GetAcctId())
+	txn.AcctId = r.lookup(row, "acct_ref", row.Refs.AcctRef, r.acctRefs, txn.GetAcctId())
+	txn.TradeAmtCcyId = r.lookup(row, "trade_amt_ccy", row.Refs.TradeCcy, r.insts, txn.GetTradeAmtCcyId())
+	txn.SettleAmtCcyId = r.lookup(row, "settle_amt_ccy", row.Refs.SettleCcy, r.insts, txn.GetSettleAmtCcyId())
+}
+
+// inst resolves an import row's instrument by isin or cusip, falling back to its ticker. an instrument
+// matching none is queued to be created if the resolver allows it
+func (r *resolver) inst(row *imp.Row) string {
+	isin, cusip, ticker := row.Refs.Isin, row.Refs.Cusip, row.Refs.Ticker
+	switch {
+	case isin != "":
+		return r.lookup(row, "isin", isin, r.isins, row.Txn.GetInstId())
+	case cusip != "" && (!r.create || len(r.cusips[strings.ToLower(cusip)]) > 0):
+		return r.lookup(row, "cusip", cusip, r.cusips, row.Txn.GetInstId())
+	case ticker != "" && (!r.create || len(r.insts[strings.ToLower(ticker)]) > 0):
+		return r.lookup(row, "ticker", ticker, r.insts, row.Txn.GetInstId())
+	case cusip != "" || ticker != "":
+		key := strings.ToLower(coalesce(cusip, ticker))
+		if r.newInsts[key] == nil {
+			r.newInsts[key] = &newInst{inst: &storage.Inst{Cusip: cusip, TickerLocal: ticker}}
+		}
+		r.newInsts[key].txns = append(r.newInsts[key].txns, row.Txn)
+	}
+
+	return row.Txn.GetInstId()
+}
+
+// lookup looks up a single reference, falling back to the id already set if there's no reference
+func (r *resolver) lookup(row *imp.Row, field string, ref string, ids map[string][]string, id string) string {
+	if ref == "" {
+		return id
+	}
+	switch matches := ids[strings.ToLower(ref)]; len(matches) {
+	case 0:
+		row.Fail(field, "%q not found", ref)
+	case 1:
+		return matches[0]
+	default:
+		row.Fail(field, "%q is ambiguous, matching %d records", ref, len(matches))
+	}
+
+	return id
+}
+
+// txnTarget gets the legal entity and account a transaction changes
+func txnTarget(txn *storage.Txn) guard.Target {
+	return guard.Target{
+		LeOrgID: txn.GetLeOrgId(),
+		AcctID:  txn.GetAcctId(),
+	}
+}
+
+// inTx runs fn with a copy of the service whose stores and guard share a database transaction, so
+// everything fn changes through them, lock overrides included, commits together or not at all
+func (s *TxnServiceImpl) inTx(ctx context.Context, fn func(s *TxnServiceImpl) error) error {
+	return s.txnStore.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		txS := *s
+		txS.txnStore = s.txnStore.WithTx(tx)
+		txS.lotStore = s.lotStore.WithTx(tx)
+		txS.glStore = s.glStore.WithTx(tx)
+		txS.instStore = s.instStore.WithTx(tx)
+		txS.guard = s.guard.WithTx(tx)
+		return fn(&txS)
+	})
+}
+
+// checkLock makes sure the earliest date a transaction touches (its txn or settle date) isn't locked
+func (s *TxnServiceImpl) checkLock(ctx context.Context, txn *storage.Txn) error {
+	return s.guard.Check(ctx, txnTarget(txn), lockDt(txn), txn.GetId())
+}
+
+// checkUpdateLock makes sure neither where a transaction is nor where an update moves it to is locked
+func (s *TxnServiceImpl) checkUpdateLock(ctx context.Context, txn *storage.Txn) error {
+	origTxn, err := s.txnStore.GetTxn(ctx, txn.GetId())
+	if err != nil {
+		return err
+	}
+	if err = s.checkLock(ctx, origTxn); err != nil {
+		return err
+	}
+	return s.checkLock(ctx, &storage.Txn{
+		Id:       origTxn.GetId(),
+		TxnDt:    coalesce(txn.GetTxnDt(), origTxn.GetTxnDt()),
+		SettleDt: coalesce(txn.GetSettleDt(), origTxn.GetSettleDt()),
+		LeOrgId:  coalesce(txn.GetLeOrgId(), origTxn.GetLeOrgId()),
+		AcctId:   coalesce(txn.GetAcctId(), origTxn.GetAcctId()),
+	})
+}
+
+// checkDeleteLock makes sure the transaction being deleted isn't locked
+func (s *TxnServiceImpl) checkDeleteLock(ctx context.Context, id string) error {
+	txn, err := s.txnStore.GetTxn(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.checkLock(ctx, txn)
+}
+
+// lockDt gets the earliest date a transaction touches, checked against lock dates
+func lockDt(txn *storage.Txn) string {
+	dt := dateOnly(txn.GetTxnDt())
+	if settleDt := dateOnly(txn.GetSettleDt()); settleDt != "" && (dt == "" || settleDt < dt) {
+		dt = settleDt
+	}
+
+	return dt
+}
+
+// coalesce returns the first non-empty string
+func coalesce(vals ...string) string {
+	for _, val := range vals {
+		if val != "" {
+			return val
+		}
+	}
+	return ""
+}
+
+// txnLot creates a new lot sourced from a transaction, carrying over the transaction's account, legal
+// entity, portfolio, and strategy
+func txnLot(txn *storage.Txn) *storage.Lot {
+	return &storage.Lot{
+		SrcTxnId: txn.GetId(),
+		AcctId:   txn.GetAcctId(),
+		LeOrgId:  txn.GetLeOrgId(),
+		PortId:   txn.GetPortId(),
+		StratId:  txn.GetStratId(),
+	}
+}
+
+// txnCost determines the cost of a lot created by a trade transaction (net trade amount, falling back
+// to the gross trade amount)
+func txnCost(txn *storage.Txn) float64 {
+	if txn.GetTradeAmtNet() != 0 {
+		return txn.GetTradeAmtNet()
+	}
+	return txn.GetTradeAmtGross()
+}
+
+// createSettledLot creates a new lot with its initial balance fully settled
+func (s *TxnServiceImpl) createSettledLot(ctx context.Context, lot *storage.Lot) (*storage.Lot, error) {
+	newLot, err := s.lotStore.CreateLot(ctx, lot)
+	if err != nil {
+		return nil, err
+	}
+
+	lotBal, err := s.lotStore.GetLotBal(ctx, newLot.GetId(), newLot.GetOrigDt())
+	if err != nil {
+		return nil, err
+	}
+	lotBal.SettledSize = lotBal.GetLotSize()
+	lotBal.UnsettledSize = 0
+
+	return newLot, s.lotStore.UpdateLotBal(ctx, lotBal)
+}
+
+// reduceLotBal reduces the (settled) balance of a lot on a date
+func (s *TxnServiceImpl) reduceLotBal(ctx context.Context, lotID string, dt string, size float64) error {
+	if lotID == "" {
+		return status.Error(codes.InvalidArgument, "src_lot_id required to reduce a lot")
+	}
+
+	lotBal, err := s.lotStore.GetLotBal(ctx, lotID, dt)
+	if err != nil {
+		return err
+	}
+	if lotBal.GetSettledSize() < size {
+		return fmt.Errorf("lot %s has a settled size of %f on %s, less than %f", lotID, lotBal.GetSettledSize(), dt, size)
+	}
+	lotBal.LotSize -= size
+	lotBal.SettledSize -= size
+
+	return s.lotStore.UpdateLotBal(ctx, lotBal)
+}
+
+// txnAmt determines the amount a transaction is journaled at (net settle amount, falling back to the
+// transaction's cost)
+func txnAmt(txn *storage.Txn) float64 {
+	if txn.GetSettleAmtNet() != 0 {
+		return txn.GetSettleAmtNet()
+	}
+	return txnCost(txn)
+}
+
+// txnCcy determines the currency a transaction is journaled in (settle currency, falling back to the
+// trade currency)
+func txnCcy(txn *storage.Txn) string {
+	if txn.GetSettleAmtCcyId() != "" {
+		return txn.GetSettleAmtCcyId()
+	}
+	return txn.GetTradeAmtCcyId()
+}
+
+// saleAlloc is the size of a sale allocated to a lot's balance on the trade date
+type saleAlloc struct {
+	lotID  string
+	lotBal *storage.LotBal
+	size   float64
+}
+
+// saleAllocs allocates the size of a sell transaction to its sale lots in order, failing if there are no
+// sale lots or their balances don't cover the full size sold
+func (s *TxnServiceImpl) saleAllocs(ctx context.Context, txn *storage.Txn, lotIDs []string) ([]*saleAlloc, error) {
+	// TODO: find lots to sell against (e.g., fifo) when none are passed in
+	if len(lotIDs) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "lot_ids required to process sell txn %s", txn.GetId())
+	}
+
+	var allocs []*saleAlloc
+	balRemaining := txn.GetTxnSize()
+	for _, lotID := range lotIDs {
+		// stop once the size is fully allocated
+		if balRemaining == 0 {
+			break
+		}
+
+		lotBal, err := s.lotStore.GetLotBal(ctx, lotID, txn.GetTxnDt())
+		if err != nil {
+			return nil, err
+		}
+		alloc := &saleAlloc{lotID: lotID, lotBal: lotBal}
+		if balRemaining < lotBal.GetLotSize() {
+			alloc.size = balRemaining
+			balRemaining = 0
+		} else {
+			alloc.size = lotBal.GetLotSize()
+			balRemaining -= lotBal.GetLotSize()
+		}
+		allocs = append(allocs, alloc)
+	}
+	if balRemaining > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "sale lots cover %v of the %v sold by txn %s", txn.GetTxnSize()-balRemaining, txn.GetTxnSize(), txn.GetId())
+	}
+
+	return allocs, nil
+}
+
+// lotCost determines the cost of a size of a lot, pro rata to the lot's original cost
+func lotCost(lot *storage.Lot, size float64) float64 {
+	if lot.GetOrigSize() == 0 {
+		return 0
+	}
+	return lot.GetOrigCost() * size / lot.GetOrigSize()
+}
+
+// journalRoles lists the gl roles a transaction posts to when processed for an account kept on a basis
+func journalRoles(txn *storage.Txn, basis string) []string {
+	switch txn.GetTxnType() {
+	case TxnType.Trade:
+		switch txn.GetTxnSubType() {
+		case TxnSubType.Trade.Buy:
+			return []string{glService.GlRole.SecuritiesCost, glService.GlRole.Payable}
+		case TxnSubType.Trade.Sell:
+			return []string{glService.GlRole.SecuritiesCost, glService.GlRole.Receivable, glService.GlRole.RealizedGain}
+		case TxnSubType.Trade.Reinvest:
+			return []string{glService.GlRole.SecuritiesCost, glService.GlRole.Cash}
+		}
+	case TxnType.Settle:
+		return []string{glService.GlRole.Cash, glService.GlRole.Payable, glService.GlRole.Receivable}
+	case TxnType.Sweep:
+		return []string{glService.GlRole.Cash}
+	case TxnType.Income:
+		if basis == acctService.AcctBasis.Accrual {
+			return []string{glService.GlRole.Cash, glService.GlRole.Receivable, glService.GlRole.Income}
+		}
+		return []string{glService.GlRole.Cash, glService.GlRole.Income}
+	case TxnType.Transfer:
+		return []string{glService.GlRole.SecuritiesCost, glService.GlRole.Capital}
+	case TxnType.CashFlow:
+		return []string{glService.GlRole.Cash, glService.GlRole.Capital}
+	case TxnType.Fee:
+		return []string{glService.GlRole.Fee, glService.GlRole.Cash}
+	}
+	return nil
+}
+
+// journal collects the debits and credits from processing a transaction
+type journal struct {
+	txn       *storage.Txn
+	acctBasis string
+	ccyID     string
+	glAcct    map[string]string
+	dt        string
+	memo      string
+	// basis is the gl basis the journal is posted under, empty if it's part of both
+	basis string
+	lines []*storage.JournalLine
+}
+
+// journalDt gets the date a transaction is journaled on. settlements and income post on the settle date
+func journalDt(txn *storage.Txn) string {
+	dt := txn.GetTxnDt()
+	if (txn.GetTxnType() == TxnType.Settle || txn.GetTxnType() == TxnType.Income) && txn.GetSettleDt() != "" {
+		dt = txn.GetSettleDt()
+	}
+
+	return dateOnly(dt)
+}
+
+// dateOnly strips any time portion from an api date
+func dateOnly(dt string) string {
+	if len(dt) > len(config.APIFormats.DateFmt) {
+		return dt[:len(config.APIFormats.DateFmt)]
+	}
+	return dt
+}
+
+// acctBasis gets the basis a transaction's account is kept on
+func (s *TxnServiceImpl) acctBasis(ctx context.Context, txn *storage.Txn) (string, error) {
+	if txn.GetAcctId() == "" {
+		return acctService.AcctBasis.Cash, nil
+	}
+	acct, err := s.acctStore.GetAcct(ctx, txn.GetAcctId())
+	if err != nil {
+		return "", err
+	}
+	if acct.GetBasis() == "" {
+		return acctService.AcctBasis.Cash, nil
+	}
+
+	return acct.GetBasis(), nil
+}
+
+// newJournal starts a journal for a transaction, making sure the chart of accounts for the transaction's
+// legal entity has an account for each role the transaction posts to
+func (s *TxnServiceImpl) newJournal(ctx context.Context, txn *storage.Txn) (*journal, error) {
+	basis, err := s.acctBasis(ctx, txn)
+	if err != nil {
+		return nil, err
+	}
+	glAccts, err := s.glStore.ResolveGlAccts(ctx, txn.GetLeOrgId())
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range journalRoles(txn, basis) {
+		if glAccts[role] == "" {
+			return nil, status.Errorf(codes.FailedPrecondition, "no gl acct with role %s to journal txn %s", role, txn.GetId())
+		}
+	}
+
+	// fail before any lots change if the journal would land in a closed period
+	if err = s.checkOpen(ctx, txn, journalDt(txn)); err != nil {
+		return nil, err
+	}
+
+	return &journal{
+		txn:       txn,
+		acctBasis: basis,
+		ccyID:     txnCcy(txn),
+		glAcct:    glAccts,
+		dt:        journalDt(txn),
+		memo:      strings.TrimSpace(txn.GetTxnType() + " " + txn.GetTxnSubType()),
+	}, nil
+}
+
+// checkOpen makes sure a date isn't in a closed period for a transaction's legal entity
+func (s *TxnServiceImpl) checkOpen(ctx context.Context, txn *storage.Txn, dt string) error {
+	closedThru, err := s.glStore.ClosedThru(ctx, txn.GetLeOrgId())
+	if err != nil {
+		return err
+	}
+	if closedThru != "" && dt <= closedThru {
+		return status.Errorf(codes.FailedPrecondition, "txn %s falls in a period closed through %s", txn.GetId(), closedThru)
+	}
+
+	return nil
+}
+
+// fork starts a new, empty journal for the same transaction and chart of accounts under a basis
+func (j *journal) fork(basis string) *journal {
+	return &journal{
+		txn:       j.txn,
+		acctBasis: j.acctBasis,
+		ccyID:     j.ccyID,
+		glAcct:    j.glAcct,
+		dt:        j.dt,
+		memo:      j.memo,
+		basis:     basis,
+	}
+}
+
+// post adds a line to the journal. positive amounts are debits and negative amounts are credits
+func (j *journal) post(role string, lotID string, amt float64) {
+	if amt == 0 {
+		return
+	}
+	line := &storage.JournalLine{
+		GlAcctId: j.glAcct[role],
+		LotId:    lotID,
+		CcyId:    j.ccyID,
+	}
+	if amt > 0 {
+		line.Debit = amt
+	} else {
+		line.Credit = -amt
+	}
+	j.lines = append(j.lines, line)
+}
+
+// debit adds a debit line to the journal
+func (j *journal) debit(role string, lotID string, amt float64) {
+	j.post(role, lotID, amt)
+}
+
+// credit adds a credit line to the journal
+func (j *journal) credit(role string, lotID string, amt float64) {
+	j.post(role, lotID, -amt)
+}
+
+// postJournal posts a transaction's journal to the General Ledger store
+func (s *TxnServiceImpl) postJournal(ctx context.Context, j *journal) (*storage.Journal, error) {
+	if len(j.lines) == 0 {
+		return nil, nil
+	}
+
+	posted, err := s.glStore.PostJournal(ctx, &storage.Journal{
+		EntryDt: j.dt,
+		TxnId:   j.txn.GetId(),
+		Memo:    j.memo,
+		LeOrgId: j.txn.GetLeOrgId(),
+		AcctId:  j.txn.GetAcctId(),
+		PortId:  j.txn.GetPortId(),
+		StratId: j.txn.GetStratId(),
+		Basis:   j.basis,
+		Lines:   j.lines,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("journaling txn %s: %w", j.txn.GetId(), err)
+	}
+
+	return posted, nil
+}
+
+// accrued sums what's been accrued to the receivable for an income transaction, along with the last date
+// it was accrued through
+func (s *TxnServiceImpl) accrued(ctx context.Context, j *journal) (float64, string, error) {
+	journals, _, err := s.glStore.ListJournals(ctx, 0, "", j.txn.GetId(), "", "", "")
+	if err != nil {
+		return 0, "", err
+	}
+
+	amt := 0.0
+	lastDt := ""
+	for _, posted := range journals {
+		if posted.GetBasis() != glService.GlBasis.Accrual {
+			continue
+		}
+		for _, line := range posted.GetLines() {
+			if line.GetGlAcctId() == j.glAcct[glService.GlRole.Receivable] {
+				amt += line.GetDebit() - line.GetCredit()
+			}
+		}
+		if dt := dateOnly(posted.GetEntryDt()); dt > lastDt {
+			lastDt = dt
+		}
+	}
+
+	return amt, lastDt, nil
+}
+
+// receivableLot finds the receivable lot booked when a dividend was accrued, if there is one
+func (s *TxnServiceImpl) receivableLot(ctx context.Context, txn *storage.Txn) (*storage.Lot, error) {
+	lotFilter := lotStore.LotFilter{
+		SrcTxnID: []string{txn.GetId()},
+	}
+	lots, _, err := s.lotStore.ListLots(ctx, 0, "", lotFilter.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(lots) == 0 {
+		return nil, nil
+	}
+	if len(lots) > 1 {
+		return nil, fmt.Errorf("found more than one receivable lot for txn %s", txn.GetId())
+	}
+
+	return lots[0], nil
+}
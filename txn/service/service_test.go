@@ -0,0 +1,838 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/golang/protobuf/proto"
+	acctService "github.com/wolfinger/varangian/acct/service"
+	acctStore "github.com/wolfinger/varangian/acct/store"
+	v1 "github.com/wolfinger/varangian/generated/api/v1"
+	"github.com/wolfinger/varangian/generated/storage"
+	glService "github.com/wolfinger/varangian/gl/service"
+	glStore "github.com/wolfinger/varangian/gl/store"
+	instStore "github.com/wolfinger/varangian/inst/store"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/guard"
+	lockStore "github.com/wolfinger/varangian/lock/store"
+	lotStore "github.com/wolfinger/varangian/lot/store"
+	txnStore "github.com/wolfinger/varangian/txn/store"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDB keeps everything the service's stores write in memory. a database transaction snapshots it and
+// puts the snapshot back if it fails, so a failed change leaves nothing behind the way the database does.
+// overrides recorded through a lock store that isn't bound to the transaction commit on their own
+type fakeDB struct {
+	txns       map[string]*storage.Txn
+	lots       map[string]*storage.Lot
+	lotBals    map[string]*storage.LotBal
+	journals   []*storage.Journal
+	glAccts    map[string]string
+	closedThru string
+	accts      map[string]*storage.Acct
+	locks      []*storage.Lock
+	overrides  []*storage.LockOverride
+	unbound    []*storage.LockOverride
+	ids        int
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		txns:    make(map[string]*storage.Txn),
+		lots:    make(map[string]*storage.Lot),
+		lotBals: make(map[string]*storage.LotBal),
+		glAccts: map[string]string{
+			"securities_cost": "gl_sec",
+			"payable":         "gl_pay",
+			"receivable":      "gl_rec",
+			"realized_gain":   "gl_gain",
+			"cash":            "gl_cash",
+			"income":          "gl_inc",
+			"capital":         "gl_cap",
+			"fee":             "gl_fee",
+		},
+		accts: map[string]*storage.Acct{"acct_a": {Id: "acct_a"}},
+	}
+}
+
+// newID gets the next id for a resource
+func (db *fakeDB) newID(pfx string) string {
+	db.ids++
+	return fmt.Sprintf("%s_%d", pfx, db.ids)
+}
+
+// balKey keys a lot's balance on a date
+func balKey(lotID string, dt string) string {
+	return lotID + "/" + dateOnly(dt)
+}
+
+// clone copies everything in the database
+func (db *fakeDB) clone() *fakeDB {
+	c := *db
+	c.txns = make(map[string]*storage.Txn)
+	for id, txn := range db.txns {
+		c.txns[id] = proto.Clone(txn).(*storage.Txn)
+	}
+	c.lots = make(map[string]*storage.Lot)
+	for id, lot := range db.lots {
+		c.lots[id] = proto.Clone(lot).(*storage.Lot)
+	}
+	c.lotBals = make(map[string]*storage.LotBal)
+	for key, lotBal := range db.lotBals {
+		c.lotBals[key] = proto.Clone(lotBal).(*storage.LotBal)
+	}
+	c.journals = append([]*storage.Journal(nil), db.journals...)
+	c.overrides = append([]*storage.LockOverride(nil), db.overrides...)
+	c.unbound = append([]*storage.LockOverride(nil), db.unbound...)
+	return &c
+}
+
+// runInTx runs fn in a fake database transaction
+func (db *fakeDB) runInTx(fn func(tx *pg.Tx) error) error {
+	snapshot := db.clone()
+	if err := fn(nil); err != nil {
+		unbound := db.unbound[len(snapshot.unbound):]
+		*db = *snapshot
+		db.overrides = append(db.overrides, unbound...)
+		db.unbound = append(db.unbound, unbound...)
+		return err
+	}
+	return nil
+}
+
+// matches checks if a resource's fields pass a filter built with the stores' filter helpers
+func matches(filter string, fields map[string]string) bool {
+	if filter == "" {
+		return true
+	}
+	e, err := filterPkg.Parse(filter)
+	if err != nil {
+		panic(err)
+	}
+	return eval(e, fields)
+}
+
+func eval(e *filterPkg.Expr, fields map[string]string) bool {
+	switch e.Op {
+	case filterPkg.And:
+		for _, x := range e.Exprs {
+			if !eval(x, fields) {
+				return false
+			}
+		}
+		return true
+	case filterPkg.Or:
+		for _, x := range e.Exprs {
+			if eval(x, fields) {
+				return true
+			}
+		}
+		return false
+	case filterPkg.Not:
+		return !eval(e.Exprs[0], fields)
+	}
+
+	v := fields[e.Field]
+	for _, val := range e.Vals {
+		switch e.Op {
+		case filterPkg.Has, filterPkg.Eq:
+			if v == val.Text {
+				return true
+			}
+		case filterPkg.Gte:
+			return v >= val.Text
+		case filterPkg.Lte:
+			return v <= val.Text
+		}
+	}
+	return false
+}
+
+// fakeTxnStore keeps txns in the fake database. the stores embed their interface for the methods the
+// service doesn't use here
+type fakeTxnStore struct {
+	txnStore.Store
+	*fakeDB
+}
+
+func (f *fakeTxnStore) GetTxn(ctx context.Context, id string) (*storage.Txn, error) {
+	txn := f.txns[id]
+	if txn == nil {
+		return nil, status.Errorf(codes.NotFound, "txn %s not found", id)
+	}
+	return proto.Clone(txn).(*storage.Txn), nil
+}
+
+func (f *fakeTxnStore) ListTxns(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Txn, string, error) {
+	var txns []*storage.Txn
+	for _, txn := range f.txns {
+		fields := map[string]string{
+			"id":        txn.GetId(),
+			"txn_type":  txn.GetTxnType(),
+			"parent_id": txn.GetParentId(),
+			"state":     txn.GetState(),
+		}
+		if matches(filter, fields) {
+			txns = append(txns, proto.Clone(txn).(*storage.Txn))
+		}
+	}
+	return txns, "", nil
+}
+
+func (f *fakeTxnStore) ProcessTxn(ctx context.Context, txn *storage.Txn) error {
+	f.txns[txn.GetId()].State = txn.GetState()
+	f.txns[txn.GetId()].Version++
+	return nil
+}
+
+func (f *fakeTxnStore) CreateTxn(ctx context.Context, txn *storage.Txn) (*storage.Txn, error) {
+	txn.Id = f.newID("txn")
+	f.txns[txn.GetId()] = proto.Clone(txn).(*storage.Txn)
+	return txn, nil
+}
+
+func (f *fakeTxnStore) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return f.runInTx(fn)
+}
+
+func (f *fakeTxnStore) WithTx(tx *pg.Tx) txnStore.Store {
+	return f
+}
+
+// fakeLotStore keeps lots and their balances in the fake database
+type fakeLotStore struct {
+	lotStore.Store
+	*fakeDB
+}
+
+func (f *fakeLotStore) GetLot(ctx context.Context, id string, dt string) (*storage.Lot, error) {
+	lot := f.lots[id]
+	if lot == nil {
+		return nil, status.Errorf(codes.NotFound, "lot %s not found", id)
+	}
+	return proto.Clone(lot).(*storage.Lot), nil
+}
+
+func (f *fakeLotStore) ListLots(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Lot, string, error) {
+	var lots []*storage.Lot
+	for _, lot := range f.lots {
+		fields := map[string]string{
+			"id":         lot.GetId(),
+			"src_txn_id": lot.GetSrcTxnId(),
+			"inst_id":    lot.GetInstId(),
+			"acct_id":    lot.GetAcctId(),
+		}
+		if matches(filter, fields) {
+			lots = append(lots, proto.Clone(lot).(*storage.Lot))
+		}
+	}
+	return lots, "", nil
+}
+
+// CreateLot creates a lot with an unsettled balance on its orig date, like the real store
+func (f *fakeLotStore) CreateLot(ctx context.Context, lot *storage.Lot) (*storage.Lot, error) {
+	lot.Id = f.newID("lot")
+	f.lots[lot.GetId()] = proto.Clone(lot).(*storage.Lot)
+	f.lotBals[balKey(lot.GetId(), lot.GetOrigDt())] = &storage.LotBal{
+		LotId:         lot.GetId(),
+		LotDt:         dateOnly(lot.GetOrigDt()),
+		LotSize:       lot.GetOrigSize(),
+		UnsettledSize: lot.GetOrigSize(),
+	}
+	return lot, nil
+}
+
+func (f *fakeLotStore) GetLotBal(ctx context.Context, id string, dt string) (*storage.LotBal, error) {
+	lotBal := f.lotBals[balKey(id, dt)]
+	if lotBal == nil {
+		return nil, status.Errorf(codes.NotFound, "lotBal with id %s not found", id)
+	}
+	return proto.Clone(lotBal).(*storage.LotBal), nil
+}
+
+func (f *fakeLotStore) UpdateLotBal(ctx context.Context, lotBal *storage.LotBal) error {
+	key := balKey(lotBal.GetLotId(), lotBal.GetLotDt())
+	if f.lotBals[key] == nil {
+		return status.Errorf(codes.NotFound, "lotBal with id %s not found", lotBal.GetLotId())
+	}
+	f.lotBals[key] = proto.Clone(lotBal).(*storage.LotBal)
+	return nil
+}
+
+func (f *fakeLotStore) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return f.runInTx(fn)
+}
+
+func (f *fakeLotStore) WithTx(tx *pg.Tx) lotStore.Store {
+	return f
+}
+
+// fakeGlStore keeps journals in the fake database, with one chart of accounts for every legal entity
+type fakeGlStore struct {
+	glStore.Store
+	*fakeDB
+}
+
+func (f *fakeGlStore) ResolveGlAccts(ctx context.Context, leOrgID string) (map[string]string, error) {
+	return f.glAccts, nil
+}
+
+func (f *fakeGlStore) ListJournals(ctx context.Context, pageSize int32, pageToken string, txnID string, leOrgID string, startDt string, endDt string) ([]*storage.Journal, string, error) {
+	var journals []*storage.Journal
+	for _, journal := range f.journals {
+		if txnID == "" || journal.GetTxnId() == txnID {
+			journals = append(journals, journal)
+		}
+	}
+	return journals, "", nil
+}
+
+func (f *fakeGlStore) PostJournal(ctx context.Context, journal *storage.Journal) (*storage.Journal, error) {
+	journal.Id = f.newID("jrnl")
+	f.journals = append(f.journals, journal)
+	return journal, nil
+}
+
+func (f *fakeGlStore) ClosedThru(ctx context.Context, leOrgID string) (string, error) {
+	return f.closedThru, nil
+}
+
+func (f *fakeGlStore) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return f.runInTx(fn)
+}
+
+func (f *fakeGlStore) WithTx(tx *pg.Tx) glStore.Store {
+	return f
+}
+
+// fakeAcctStore keeps accts in the fake database
+type fakeAcctStore struct {
+	acctStore.Store
+	*fakeDB
+}
+
+func (f *fakeAcctStore) GetAcct(ctx context.Context, id string) (*storage.Acct, error) {
+	acct := f.accts[id]
+	if acct == nil {
+		return nil, status.Errorf(codes.NotFound, "acct %s not found", id)
+	}
+	return acct, nil
+}
+
+// fakeInstStore isn't used by processing
+type fakeInstStore struct {
+	instStore.Store
+}
+
+func (f *fakeInstStore) WithTx(tx *pg.Tx) instStore.Store {
+	return f
+}
+
+// fakeLockStore keeps locks and the overrides recorded against them in the fake database
+type fakeLockStore struct {
+	lockStore.Store
+	*fakeDB
+	bound bool
+}
+
+func (f *fakeLockStore) ListLocks(ctx context.Context, pageSize int32, pageToken string) ([]*storage.Lock, string, error) {
+	return f.locks, "", nil
+}
+
+func (f *fakeLockStore) CreateLockOverride(ctx context.Context, lockOverride *storage.LockOverride) (*storage.LockOverride, error) {
+	f.overrides = append(f.overrides, lockOverride)
+	if !f.bound {
+		f.unbound = append(f.unbound, lockOverride)
+	}
+	return lockOverride, nil
+}
+
+func (f *fakeLockStore) WithTx(tx *pg.Tx) lockStore.Store {
+	return &fakeLockStore{fakeDB: f.fakeDB, bound: true}
+}
+
+// newFakeService creates a Transaction service backed by a fake database, letting the controller override
+// lock dates
+func newFakeService(db *fakeDB) *TxnServiceImpl {
+	g := guard.NewGuard(&fakeLockStore{fakeDB: db}, []string{"controller"})
+	return NewService(&fakeTxnStore{fakeDB: db}, &fakeLotStore{fakeDB: db}, &fakeGlStore{fakeDB: db},
+		&fakeAcctStore{fakeDB: db}, &fakeInstStore{}, g, nil)
+}
+
+// addTxn adds an open txn to the fake database
+func addTxn(db *fakeDB, txn *storage.Txn) *storage.Txn {
+	txn.Id = db.newID("txn")
+	txn.State = TxnState.Open
+	if txn.GetLeOrgId() == "" {
+		txn.LeOrgId = "org_a"
+	}
+	if txn.GetAcctId() == "" {
+		txn.AcctId = "acct_a"
+	}
+	db.txns[txn.GetId()] = txn
+	return txn
+}
+
+// overrideCtx is the context of the controller overriding a lock date
+func overrideCtx() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(guard.UserKey, "controller", guard.OverrideKey, "late fill"))
+}
+
+func TestProcessTxnOverride(t *testing.T) {
+	db := newFakeDB()
+	db.locks = []*storage.Lock{{Id: "lock_a", AcctId: "acct_a", LockDt: "2021-03-31"}}
+	s := newFakeService(db)
+
+	// a sell without lot ids fails after its lock date has been overridden
+	sell := addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Sell, TxnDt: "2021-03-15",
+		SettleDt: "2021-03-17", TxnSize: 10, SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+	_, err := s.ProcessTxn(overrideCtx(), &v1.ProcessTxnRequest{Id: sell.GetId()})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("sell without lot ids got: %v, want: %v", err, codes.InvalidArgument)
+	}
+	if len(db.overrides) != 0 || db.txns[sell.GetId()].GetState() != TxnState.Open || len(db.journals) != 0 {
+		t.Errorf("failed process left %d overrides and %d journals with the txn %s, want nothing changed",
+			len(db.overrides), len(db.journals), db.txns[sell.GetId()].GetState())
+	}
+
+	// a buy goes through, recording the override along with it
+	buy := addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Buy, InstId: "inst_a",
+		TxnDt: "2021-03-15", SettleDt: "2021-03-17", TxnSize: 10, SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+	if _, err = s.ProcessTxn(overrideCtx(), &v1.ProcessTxnRequest{Id: buy.GetId()}); err != nil {
+		t.Fatalf("buy got: %v", err)
+	}
+	if len(db.overrides) != 1 || db.overrides[0].GetRefId() != buy.GetId() {
+		t.Errorf("processed buy got overrides: %v, want one for %s", db.overrides, buy.GetId())
+	}
+
+	// without the override, the lock date rejects the txn
+	if _, err = s.ProcessTxn(context.Background(), &v1.ProcessTxnRequest{Id: sell.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("locked sell got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+	if !strings.Contains(fmt.Sprint(err), "2021-03-31") {
+		t.Errorf("locked sell error %v doesn't name the lock date", err)
+	}
+}
+
+func TestProcessTxnState(t *testing.T) {
+	db := newFakeDB()
+	s := newFakeService(db)
+	ctx := context.Background()
+
+	buy := addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Buy, InstId: "inst_a",
+		TxnDt: "2021-03-15", SettleDt: "2021-03-17", TxnSize: 10, SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+	if _, err := s.ProcessTxn(ctx, &v1.ProcessTxnRequest{Id: buy.GetId()}); err != nil {
+		t.Fatal(err)
+	}
+	version, lots, journals := db.txns[buy.GetId()].GetVersion(), len(db.lots), len(db.journals)
+
+	// processing it again changes nothing, even once its date is locked
+	db.locks = []*storage.Lock{{Id: "lock_a", AcctId: "acct_a", LockDt: "2021-03-31"}}
+	res, err := s.ProcessTxn(ctx, &v1.ProcessTxnRequest{Id: buy.GetId()})
+	if err != nil || res.GetState() != TxnState.Processed {
+		t.Errorf("processing a processed txn got: %v, %v, want it returned as processed", res, err)
+	}
+	if db.txns[buy.GetId()].GetVersion() != version || len(db.lots) != lots || len(db.journals) != journals {
+		t.Errorf("processing a processed txn moved it to version %d with %d lots and %d journals, want: %d, %d, %d",
+			db.txns[buy.GetId()].GetVersion(), len(db.lots), len(db.journals), version, lots, journals)
+	}
+
+	// a pending txn isn't processed
+	pending := addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Buy, InstId: "inst_a",
+		TxnDt: "2021-04-15", SettleDt: "2021-04-17", TxnSize: 10, SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+	pending.State = TxnState.Pending
+	if _, err = s.ProcessTxn(ctx, &v1.ProcessTxnRequest{Id: pending.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("processing a pending txn got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+	if db.txns[pending.GetId()].GetState() != TxnState.Pending || len(db.lots) != lots {
+		t.Errorf("processing a pending txn left it %s with %d lots, want it untouched", db.txns[pending.GetId()].GetState(), len(db.lots))
+	}
+}
+
+// addLot adds a lot to the fake database with its balances
+func addLot(db *fakeDB, lot *storage.Lot, lotBals ...*storage.LotBal) {
+	db.lots[lot.GetId()] = lot
+	for _, lotBal := range lotBals {
+		lotBal.LotId = lot.GetId()
+		db.lotBals[balKey(lot.GetId(), lotBal.GetLotDt())] = lotBal
+	}
+}
+
+// line is a journal line expected from processing a txn. a lot id of new:<inst id> is the lot of that
+// instrument created by the txn or the one it settles
+type line struct {
+	glAcctID string
+	lotID    string
+	debit    float64
+	credit   float64
+}
+
+// newLot finds the lot of an instrument created by a txn or its parent
+func newLot(db *fakeDB, txn *storage.Txn, instID string) string {
+	for _, lot := range db.lots {
+		if lot.GetInstId() == instID && lot.GetSrcTxnId() != "" && (lot.GetSrcTxnId() == txn.GetId() || lot.GetSrcTxnId() == txn.GetParentId()) {
+			return lot.GetId()
+		}
+	}
+	return ""
+}
+
+// txnJournals gets the journals posted for a txn
+func txnJournals(db *fakeDB, txnID string) []*storage.Journal {
+	var journals []*storage.Journal
+	for _, journal := range db.journals {
+		if journal.GetTxnId() == txnID {
+			journals = append(journals, journal)
+		}
+	}
+	return journals
+}
+
+// checkBalanced makes sure a journal's debits and credits balance in each currency
+func checkBalanced(t *testing.T, name string, journal *storage.Journal) {
+	t.Helper()
+	bals := make(map[string]float64)
+	for _, l := range journal.GetLines() {
+		bals[l.GetCcyId()] += l.GetDebit() - l.GetCredit()
+	}
+	for ccyID, bal := range bals {
+		if math.Abs(bal) > 1e-9 {
+			t.Errorf("%s journal is out of balance by %v %s: %v", name, bal, ccyID, journal.GetLines())
+		}
+	}
+}
+
+// checkLines makes sure a journal has the lines expected, in order, all in one currency
+func checkLines(t *testing.T, name string, db *fakeDB, txn *storage.Txn, journal *storage.Journal, ccyID string, want []line) {
+	t.Helper()
+	got := journal.GetLines()
+	if len(got) != len(want) {
+		t.Errorf("%s got lines: %v, want: %v", name, got, want)
+		return
+	}
+	for i, w := range want {
+		lotID := w.lotID
+		if strings.HasPrefix(lotID, "new:") {
+			lotID = newLot(db, txn, strings.TrimPrefix(lotID, "new:"))
+		}
+		g := got[i]
+		if g.GetGlAcctId() != w.glAcctID || g.GetLotId() != lotID || math.Abs(g.GetDebit()-w.debit) > 1e-9 ||
+			math.Abs(g.GetCredit()-w.credit) > 1e-9 || g.GetCcyId() != ccyID {
+			t.Errorf("%s line %d got: %v, want: %s %s (%s) %v/%v %s", name, i, g, w.glAcctID, w.lotID, lotID, w.debit, w.credit, ccyID)
+		}
+	}
+}
+
+func TestProcessTxnJournal(t *testing.T) {
+	// lot_1 holds 10 inst_a bought for 800, and lot_c 1000 settled usd
+	holdings := func(db *fakeDB) {
+		addLot(db, &storage.Lot{Id: "lot_1", InstId: "inst_a", AcctId: "acct_a", LeOrgId: "org_a", OrigDt: "2021-03-01", OrigSize: 10, OrigCost: 800},
+			&storage.LotBal{LotDt: "2021-03-15", LotSize: 10, SettledSize: 10})
+		addLot(db, &storage.Lot{Id: "lot_c", InstId: "usd", AcctId: "acct_a", LeOrgId: "org_a", OrigDt: "2021-03-01", OrigSize: 1000, OrigCost: 1000},
+			&storage.LotBal{LotDt: "2021-03-15", LotSize: 1000, SettledSize: 1000})
+	}
+	sell := func(db *fakeDB, amt float64) *storage.Txn {
+		return addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Sell, InstId: "inst_a", TxnDt: "2021-03-15",
+			SettleDt: "2021-03-15", TxnSize: 5, SettleAmtNet: amt, SettleAmtCcyId: "usd"})
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(db *fakeDB, s *TxnServiceImpl) *storage.Txn
+		lotIDs []string
+		dt     string
+		ccyID  string
+		lines  []line
+	}{
+		{
+			name: "buy",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Buy, InstId: "inst_a", TxnDt: "2021-03-15",
+					SettleDt: "2021-03-17", TxnSize: 10, TradeAmtNet: 990, TradeAmtCcyId: "usd", SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "usd",
+			lines: []line{{"gl_sec", "new:inst_a", 1000, 0}, {"gl_pay", "new:usd", 0, 1000}},
+		},
+		{
+			name: "sell at a gain",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				holdings(db)
+				return sell(db, 500)
+			},
+			lotIDs: []string{"lot_1"},
+			dt:     "2021-03-15",
+			ccyID:  "usd",
+			lines:  []line{{"gl_sec", "lot_1", 0, 400}, {"gl_gain", "", 0, 100}, {"gl_rec", "new:usd", 500, 0}},
+		},
+		{
+			name: "sell at a loss",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				holdings(db)
+				return sell(db, 300)
+			},
+			lotIDs: []string{"lot_1"},
+			dt:     "2021-03-15",
+			ccyID:  "usd",
+			lines:  []line{{"gl_sec", "lot_1", 0, 400}, {"gl_gain", "", 100, 0}, {"gl_rec", "new:usd", 300, 0}},
+		},
+		{
+			name: "reinvest",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				addLot(db, &storage.Lot{Id: "lot_d", InstId: "usd", AcctId: "acct_a", OrigDt: "2021-03-15", OrigSize: 200, OrigCost: 200},
+					&storage.LotBal{LotDt: "2021-03-15", LotSize: 200, SettledSize: 200})
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Reinvest, InstId: "inst_a", SrcLotId: "lot_d",
+					TxnDt: "2021-03-15", SettleDt: "2021-03-15", TxnSize: 2, TradeAmtNet: 200, SettleAmtNet: 200, SettleAmtCcyId: "usd"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "usd",
+			lines: []line{{"gl_sec", "new:inst_a", 200, 0}, {"gl_cash", "lot_d", 0, 200}},
+		},
+		{
+			name: "settle",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				holdings(db)
+				origTxn := sell(db, 500)
+				if _, err := s.ProcessTxn(context.Background(), &v1.ProcessTxnRequest{Id: origTxn.GetId(), LotIds: []string{"lot_1"}}); err != nil {
+					t.Fatal(err)
+				}
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Settle, ParentId: origTxn.GetId(), TxnDt: "2021-03-15", SettleDt: "2021-03-15"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "usd",
+			lines: []line{{"gl_cash", "new:usd", 500, 0}, {"gl_rec", "new:usd", 0, 500}},
+		},
+		{
+			name: "income",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Income, TxnSubType: TxnSubType.Income.Dividend, InstId: "inst_a",
+					TxnDt: "2021-03-12", SettleDt: "2021-03-20", TxnSize: 50, SettleAmtCcyId: "usd"})
+			},
+			dt:    "2021-03-20",
+			ccyID: "usd",
+			lines: []line{{"gl_cash", "new:usd", 50, 0}, {"gl_inc", "", 0, 50}},
+		},
+		{
+			name: "transfer in",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Transfer, TxnSubType: TxnSubType.Transfer.In, InstId: "inst_a",
+					TxnDt: "2021-03-15", TxnSize: 10, TradeAmtNet: 800, TradeAmtCcyId: "eur"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "eur",
+			lines: []line{{"gl_sec", "new:inst_a", 800, 0}, {"gl_cap", "", 0, 800}},
+		},
+		{
+			name: "transfer out",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				holdings(db)
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Transfer, TxnSubType: TxnSubType.Transfer.Out, InstId: "inst_a", SrcLotId: "lot_1",
+					TxnDt: "2021-03-15", TxnSize: 5, TradeAmtCcyId: "eur"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "eur",
+			lines: []line{{"gl_cap", "", 400, 0}, {"gl_sec", "lot_1", 0, 400}},
+		},
+		{
+			name: "contribution",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				return addTxn(db, &storage.Txn{TxnType: TxnType.CashFlow, TxnSubType: TxnSubType.CashFlow.Contribution,
+					TxnDt: "2021-03-15", SettleDt: "2021-03-15", SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "usd",
+			lines: []line{{"gl_cash", "new:usd", 1000, 0}, {"gl_cap", "", 0, 1000}},
+		},
+		{
+			name: "withdrawal",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				holdings(db)
+				return addTxn(db, &storage.Txn{TxnType: TxnType.CashFlow, TxnSubType: TxnSubType.CashFlow.Withdrawal, SrcLotId: "lot_c",
+					TxnDt: "2021-03-15", SettleDt: "2021-03-15", SettleAmtNet: 300, SettleAmtCcyId: "usd"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "usd",
+			lines: []line{{"gl_cap", "", 300, 0}, {"gl_cash", "lot_c", 0, 300}},
+		},
+		{
+			name: "fee",
+			setup: func(db *fakeDB, s *TxnServiceImpl) *storage.Txn {
+				holdings(db)
+				return addTxn(db, &storage.Txn{TxnType: TxnType.Fee, TxnSubType: TxnSubType.Fee.Mgmt, SrcLotId: "lot_c",
+					TxnDt: "2021-03-15", SettleDt: "2021-03-15", SettleAmtNet: 25, SettleAmtCcyId: "usd"})
+			},
+			dt:    "2021-03-15",
+			ccyID: "usd",
+			lines: []line{{"gl_fee", "", 25, 0}, {"gl_cash", "lot_c", 0, 25}},
+		},
+	}
+	for _, test := range tests {
+		db := newFakeDB()
+		s := newFakeService(db)
+		txn := test.setup(db, s)
+
+		if _, err := s.ProcessTxn(context.Background(), &v1.ProcessTxnRequest{Id: txn.GetId(), LotIds: test.lotIDs}); err != nil {
+			t.Errorf("%s got: %v", test.name, err)
+			continue
+		}
+		journals := txnJournals(db, txn.GetId())
+		if len(journals) != 1 {
+			t.Errorf("%s got %d journals, want: 1", test.name, len(journals))
+			continue
+		}
+		journal := journals[0]
+		if journal.GetEntryDt() != test.dt || journal.GetBasis() != "" || journal.GetLeOrgId() != "org_a" || journal.GetAcctId() != "acct_a" {
+			t.Errorf("%s got journal: %v, want it on %s for both bases", test.name, journal, test.dt)
+		}
+		checkBalanced(t, test.name, journal)
+		checkLines(t, test.name, db, txn, journal, test.ccyID, test.lines)
+	}
+}
+
+func TestProcessTxnClosedPeriod(t *testing.T) {
+	db := newFakeDB()
+	db.closedThru = "2021-03-31"
+	s := newFakeService(db)
+
+	buy := addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Buy, InstId: "inst_a",
+		TxnDt: "2021-03-15", SettleDt: "2021-03-17", TxnSize: 10, SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+	if _, err := s.ProcessTxn(context.Background(), &v1.ProcessTxnRequest{Id: buy.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("buy in a closed period got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+	if len(db.lots) != 0 || len(db.journals) != 0 || db.txns[buy.GetId()].GetState() != TxnState.Open {
+		t.Errorf("buy in a closed period left %d lots and %d journals with the txn %s, want nothing changed",
+			len(db.lots), len(db.journals), db.txns[buy.GetId()].GetState())
+	}
+
+	// the day after the period is open
+	buy = addTxn(db, &storage.Txn{TxnType: TxnType.Trade, TxnSubType: TxnSubType.Trade.Buy, InstId: "inst_a",
+		TxnDt: "2021-04-01", SettleDt: "2021-04-05", TxnSize: 10, SettleAmtNet: 1000, SettleAmtCcyId: "usd"})
+	if _, err := s.ProcessTxn(context.Background(), &v1.ProcessTxnRequest{Id: buy.GetId()}); err != nil {
+		t.Errorf("buy after the closed period got: %v", err)
+	}
+}
+
+// accrualDB creates a fake database with acct_a kept on an accrual basis
+func accrualDB() *fakeDB {
+	db := newFakeDB()
+	db.accts["acct_a"].Basis = acctService.AcctBasis.Accrual
+	return db
+}
+
+// basisJournal finds the journal posted for a txn on a date under a basis
+func basisJournal(db *fakeDB, txnID string, dt string, basis string) *storage.Journal {
+	for _, journal := range txnJournals(db, txnID) {
+		if journal.GetEntryDt() == dt && journal.GetBasis() == basis {
+			return journal
+		}
+	}
+	return &storage.Journal{}
+}
+
+func TestAccrueIncomeDividend(t *testing.T) {
+	db := accrualDB()
+	s := newFakeService(db)
+	ctx := context.Background()
+
+	// the dividend is booked in full on the ex date as a receivable lot
+	div := addTxn(db, &storage.Txn{TxnType: TxnType.Income, TxnSubType: TxnSubType.Income.Dividend, InstId: "inst_a",
+		TxnDt: "2021-03-12", SettleDt: "2021-03-20", TxnSize: 50, SettleAmtCcyId: "usd"})
+	res, err := s.AccrueIncome(ctx, &v1.AccrueIncomeRequest{Id: div.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recLotID := newLot(db, div, "usd")
+	if res.GetAccruedAmt() != 50 || len(res.GetJournals()) != 1 || recLotID == "" {
+		t.Fatalf("dividend accrual got: %v with receivable lot %q, want 50 accrued in one journal", res, recLotID)
+	}
+	journal := basisJournal(db, div.GetId(), "2021-03-12", glService.GlBasis.Accrual)
+	checkLines(t, "dividend accrual", db, div, journal, "usd", []line{{"gl_rec", recLotID, 50, 0}, {"gl_inc", "", 0, 50}})
+	if lotBal := db.lotBals[balKey(recLotID, "2021-03-12")]; lotBal.GetUnsettledSize() != 50 || db.lots[recLotID].GetOrigDt() != "2021-03-12" {
+		t.Errorf("receivable lot got: %v with balance %v, want 50 unsettled from the ex date", db.lots[recLotID], lotBal)
+	}
+	if _, err = s.AccrueIncome(ctx, &v1.AccrueIncomeRequest{Id: div.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("accruing a dividend again got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+
+	// processing the paid dividend turns the receivable lot, rolled forward to the pay date, into cash. on the
+	// accrual basis the receivable is released, while the cash basis recognizes the income
+	db.lotBals[balKey(recLotID, "2021-03-20")] = &storage.LotBal{LotId: recLotID, LotDt: "2021-03-20", LotSize: 50, UnsettledSize: 50}
+	if _, err = s.ProcessTxn(ctx, &v1.ProcessTxnRequest{Id: div.GetId()}); err != nil {
+		t.Fatal(err)
+	}
+	journal = basisJournal(db, div.GetId(), "2021-03-20", glService.GlBasis.Accrual)
+	checkLines(t, "paid dividend accrual basis", db, div, journal, "usd", []line{{"gl_cash", recLotID, 50, 0}, {"gl_rec", recLotID, 0, 50}})
+	journal = basisJournal(db, div.GetId(), "2021-03-20", glService.GlBasis.Cash)
+	checkLines(t, "paid dividend cash basis", db, div, journal, "usd", []line{{"gl_cash", recLotID, 50, 0}, {"gl_inc", "", 0, 50}})
+	if lotBal := db.lotBals[balKey(recLotID, "2021-03-20")]; lotBal.GetSettledSize() != 50 || lotBal.GetUnsettledSize() != 0 {
+		t.Errorf("paid receivable lot balance got: %v, want 50 settled", lotBal)
+	}
+
+	// only accounts kept on an accrual basis accrue income
+	db.accts["acct_a"].Basis = acctService.AcctBasis.Cash
+	div = addTxn(db, &storage.Txn{TxnType: TxnType.Income, TxnSubType: TxnSubType.Income.Dividend, InstId: "inst_a",
+		TxnDt: "2021-04-12", SettleDt: "2021-04-20", TxnSize: 50, SettleAmtCcyId: "usd"})
+	if _, err = s.AccrueIncome(ctx, &v1.AccrueIncomeRequest{Id: div.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("accruing for a cash basis acct got: %v, want: %v", err, codes.FailedPrecondition)
+	}
+}
+
+func TestAccrueIncomeInterest(t *testing.T) {
+	db := accrualDB()
+	s := newFakeService(db)
+	ctx := context.Background()
+	interest := func() *storage.Txn {
+		return addTxn(db, &storage.Txn{TxnType: TxnType.Income, TxnSubType: TxnSubType.Income.Interest, InstId: "inst_b",
+			TxnDt: "2021-04-03", SettleDt: "2021-04-03", TxnSize: 100, SettleAmtCcyId: "usd"})
+	}
+	daily := 100.0 / 3
+
+	// interest accrues a day at a time from the start of the period, picking up after the last accrual
+	txn := interest()
+	res, err := s.AccrueIncome(ctx, &v1.AccrueIncomeRequest{Id: txn.GetId(), AccrualStartDt: "2021-03-31", ThruDt: "2021-04-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetJournals()) != 1 || res.GetAccruedAmt() != daily {
+		t.Errorf("first day of interest got: %v, want: %v accrued", res, daily)
+	}
+	res, err = s.AccrueIncome(ctx, &v1.AccrueIncomeRequest{Id: txn.GetId(), AccrualStartDt: "2021-03-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetJournals()) != 2 || math.Abs(res.GetAccruedAmt()-100) > 1e-9 {
+		t.Fatalf("rest of the interest got: %v, want the full 100 accrued over two more days", res)
+	}
+	for i, dt := range []string{"2021-04-01", "2021-04-02", "2021-04-03"} {
+		journal := basisJournal(db, txn.GetId(), dt, glService.GlBasis.Accrual)
+		amt := daily
+		if i == 2 {
+			// the last day absorbs the rounding of the days before it
+			amt = 100 - 2*daily
+		}
+		checkLines(t, "interest accrual on "+dt, db, txn, journal, "usd", []line{{"gl_rec", "", amt, 0}, {"gl_inc", "", 0, amt}})
+	}
+
+	// paid after two of the three days are accrued, the receivable is released and income trued up to
+	// what was paid
+	txn = interest()
+	if _, err = s.AccrueIncome(ctx, &v1.AccrueIncomeRequest{Id: txn.GetId(), AccrualStartDt: "2021-03-31", ThruDt: "2021-04-02"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ProcessTxn(ctx, &v1.ProcessTxnRequest{Id: txn.GetId()}); err != nil {
+		t.Fatal(err)
+	}
+	journal := basisJournal(db, txn.GetId(), "2021-04-03", glService.GlBasis.Accrual)
+	checkBalanced(t, "paid interest accrual basis", journal)
+	checkLines(t, "paid interest accrual basis", db, txn, journal, "usd",
+		[]line{{"gl_cash", "new:usd", 100, 0}, {"gl_rec", "", 0, 2 * daily}, {"gl_inc", "", 0, 100 - 2*daily}})
+	journal = basisJournal(db, txn.GetId(), "2021-04-03", glService.GlBasis.Cash)
+	checkLines(t, "paid interest cash basis", db, txn, journal, "usd", []line{{"gl_cash", "new:usd", 100, 0}, {"gl_inc", "", 0, 100}})
+}
@@ -2,14 +2,20 @@ package store
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
-	"github.com/go-pg/urlstruct"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/uuid"
+	changeStore "github.com/wolfinger/varangian/change/store"
 	"github.com/wolfinger/varangian/generated/storage"
 	"github.com/wolfinger/varangian/internal/casing"
+	"github.com/wolfinger/varangian/internal/dbtx"
+	filterPkg "github.com/wolfinger/varangian/internal/filter"
+	"github.com/wolfinger/varangian/internal/page"
+	"github.com/wolfinger/varangian/internal/version"
 	"github.com/wolfinger/varangian/internal/vxid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -20,10 +26,18 @@ import (
 // Store interface used for implementing the Transaction store
 type Store interface {
 	GetTxn(ctx context.Context, id string) (*storage.Txn, error)
-	ListTxns(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Txn, error)
+	ListTxns(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Txn, string, error)
 	UpdateTxn(ctx context.Context, txn *storage.Txn, fieldMask []string) error
+	ProcessTxn(ctx context.Context, txn *storage.Txn) error
 	CreateTxn(ctx context.Context, txn *storage.Txn) (*storage.Txn, error)
+	CreateTxns(ctx context.Context, txns []*storage.Txn) ([]*storage.Txn, error)
+	CreateTxnGroups(ctx context.Context, groups []*TxnGroup) (int, error)
 	DeleteTxn(ctx context.Context, id string) error
+	UpdateTxns(ctx context.Context, txns []*storage.Txn, fieldMasks [][]string) error
+	DeleteTxns(ctx context.Context, ids []string) error
+	StreamTxns(ctx context.Context, filter string, fn func(txn *storage.Txn) error) error
+	RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error
+	WithTx(tx *pg.Tx) Store
 }
 
 // NewStore encapsulates Transaction database operations
@@ -34,68 +48,122 @@ func NewStore(conn *pg.DB) Store {
 }
 
 type storeImpl struct {
-	conn *pg.DB
+	conn dbtx.Conn
 }
 
-// TxnFilter provides custom filter for the Transaction store
+// RunInTransaction runs fn in a database transaction that other stores can be bound to with WithTx
+func (s *storeImpl) RunInTransaction(ctx context.Context, fn func(tx *pg.Tx) error) error {
+	return s.conn.RunInTransaction(ctx, fn)
+}
+
+// WithTx gets a copy of the store bound to a database transaction, which its changes commit with
+func (s *storeImpl) WithTx(tx *pg.Tx) Store {
+	return &storeImpl{conn: dbtx.Shared(tx)}
+}
+
+// txnKeys are the keys transactions are listed in order of
+var txnKeys = []page.Key{{Col: "txn_dt"}, {Col: "id"}}
+
+// txnOrderCols are the columns transactions can be listed in order of
+var txnOrderCols = []string{
+	"id",
+	"txn_dt",
+	"settle_dt",
+	"txn_type",
+	"txn_sub_type",
+	"txn_size",
+	"state",
+	"trade_amt_gross",
+	"trade_amt_net",
+	"settle_amt_gross",
+	"settle_amt_net",
+	"ext_id",
+}
+
+// TxnGroup is a set of transactions to be created under a parent transaction. a parent without an id is
+// created with its kids, while an existing parent's size and amounts are increased by the parent's, as long
+// as it's still at the parent's version. kids of a group without a parent are created as they are
+type TxnGroup struct {
+	Parent *storage.Txn
+	Kids   []*storage.Txn
+}
+
+// txnFields are the fields transactions can be filtered on
+var txnFields = filterPkg.NewFields(&storage.Txn{}, map[string]string{
+	"id":                vxid.PfxMap.Transaction,
+	"inst_id":           vxid.PfxMap.Instrument,
+	"parent_id":         vxid.PfxMap.Transaction,
+	"src_lot_id":        vxid.PfxMap.Lot,
+	"tgt_lot_id":        vxid.PfxMap.Lot,
+	"trade_amt_ccy_id":  vxid.PfxMap.Instrument,
+	"settle_amt_ccy_id": vxid.PfxMap.Instrument,
+	"acct_id":           vxid.PfxMap.Account,
+	"le_org_id":         vxid.PfxMap.Organization,
+	"port_id":           vxid.PfxMap.Portfolio,
+	"strat_id":          vxid.PfxMap.Strategy,
+})
+
+// TxnFilter builds a filter for the Transaction store, for callers listing transactions from go rather than
+// for a user
 type TxnFilter struct {
-	ID         []string
-	TxnType    []string
-	TxnTypeNEQ []string
-	ParentID   []string
-	urlstruct.Pager
-	/*
-		TxnDt          string
-		SettleDt       string
-		TxnSubType     string
-		TxnSize        float64
-		InstID         string
-		LotID          string
-		State          string
-		TradeAmtCcyID  string
-		TradeAmtGross  float64
-		TradeAmtNet    float64
-		SettleAmtCcyID string
-		SettleAmtGross float64
-		SettleAmtNet   float64
-	*/
-}
-
-func (f *TxnFilter) query(q *orm.Query) (*orm.Query, error) {
-	//q = q.Model((*storage.Txn)(nil))
-	// q = q.Relation("Txn")
-
-	// ID filters
-	if f.ID != nil {
-		vids, err := vxid.Decodes(f.ID)
-		if err != nil {
-			return nil, err
-		}
-		q.Where("id IN (?)", pg.In(vids))
-	}
+	ID          []string
+	TxnType     []string
+	TxnTypeNEQ  []string
+	TxnSubType  []string
+	ParentID    []string
+	State       []string
+	TxnDtGTE    string
+	TxnDtLTE    string
+	SettleDtGTE string
+	SettleDtLTE string
+	AcctID      []string
+	LeOrgID     []string
+	PortID      []string
+	StratID     []string
+	ExtID       []string
+}
 
-	// TxnType filters
-	if f.TxnType != nil {
-		q.Where("txn_type IN (?)", pg.In(f.TxnType))
-	}
-	if f.TxnTypeNEQ != nil {
-		q.Where("txn_type NOT IN (?)", pg.In(f.TxnTypeNEQ))
+// String writes the filter out in the filter language
+func (f TxnFilter) String() string {
+	var parts []string
+	in := func(field string, vals []string) {
+		if len(vals) > 0 {
+			parts = append(parts, filterPkg.In(field, vals...))
+		}
 	}
-
-	// ParentID filters
-	if f.ParentID != nil {
-		vids, err := vxid.Decodes(f.ParentID)
-		if err != nil {
-			return nil, err
+	compare := func(field string, op string, val string) {
+		if val != "" {
+			parts = append(parts, filterPkg.Compare(field, op, val))
 		}
-		q.Where("parent_id IN (?)", pg.In(vids))
 	}
-
-	return q, nil
+	in("id", f.ID)
+	in("txn_type", f.TxnType)
+	if len(f.TxnTypeNEQ) > 0 {
+		parts = append(parts, "NOT "+filterPkg.In("txn_type", f.TxnTypeNEQ...))
+	}
+	in("txn_sub_type", f.TxnSubType)
+	in("parent_id", f.ParentID)
+	in("state", f.State)
+	compare("txn_dt", filterPkg.Gte, f.TxnDtGTE)
+	compare("txn_dt", filterPkg.Lte, f.TxnDtLTE)
+	compare("settle_dt", filterPkg.Gte, f.SettleDtGTE)
+	compare("settle_dt", filterPkg.Lte, f.SettleDtLTE)
+	in("acct_id", f.AcctID)
+	in("le_org_id", f.LeOrgID)
+	in("port_id", f.PortID)
+	in("strat_id", f.StratID)
+	in("ext_id", f.ExtID)
+
+	return strings.Join(parts, " AND ")
 }
 
 // GetTxn gets a transaction from the Transaction store
 func (s *storeImpl) GetTxn(ctx context.Context, id string) (*storage.Txn, error) {
+	return getTxn(ctx, s.conn, id)
+}
+
+// getTxn gets a transaction with either a connection or a database transaction
+func getTxn(ctx context.Context, db orm.DB, id string) (*storage.Txn, error) {
 	// convert vxid to vid
 	vid, err := vxid.Decode(id)
 	if err != nil {
@@ -103,7 +171,7 @@ func (s *storeImpl) GetTxn(ctx context.Context, id string) (*storage.Txn, error)
 	}
 
 	var txn storage.Txn
-	err = s.conn.ModelContext(ctx, &txn).ColumnExpr("*, txn_dt::date, settle_dt::date").Where("id = ?", vid).Select()
+	err = db.ModelContext(ctx, &txn).ColumnExpr("*, txn_dt::date, settle_dt::date").Where("id = ?", vid).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil, status.Errorf(codes.NotFound, "transaction with id %s not found", id)
@@ -149,212 +217,620 @@ func (s *storeImpl) GetTxn(ctx context.Context, id string) (*storage.Txn, error)
 			return nil, err
 		}
 	}
+	if txn.GetAcctId() != "" {
+		txn.AcctId, err = vxid.Encode(txn.GetAcctId(), vxid.PfxMap.Account)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if txn.GetLeOrgId() != "" {
+		txn.LeOrgId, err = vxid.Encode(txn.GetLeOrgId(), vxid.PfxMap.Organization)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if txn.GetPortId() != "" {
+		txn.PortId, err = vxid.Encode(txn.GetPortId(), vxid.PfxMap.Portfolio)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if txn.GetStratId() != "" {
+		txn.StratId, err = vxid.Encode(txn.GetStratId(), vxid.PfxMap.Strategy)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &txn, err
 }
 
-// ListTxns lists an array of transacitons from the Transaction store
-func (s *storeImpl) ListTxns(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Txn, error) {
-	var txns []*storage.Txn
-
-	// decode json filter string into struct
-	f := new(TxnFilter)
-	json.Unmarshal([]byte(filter), &f)
+// ListTxns lists a page of the transactions matching a filter from the Transaction store, in order of txn
+// date unless ordered otherwise. a page size of 0 lists every transaction
+func (s *storeImpl) ListTxns(ctx context.Context, pageSize int32, pageToken string, filter string, orderBy string) ([]*storage.Txn, string, error) {
+	keys, err := page.Order(orderBy, txnKeys, txnOrderCols)
+	if err != nil {
+		return nil, "", err
+	}
 
-	q := s.conn.ModelContext(ctx, &txns).ColumnExpr("*, txn_dt::date, settle_dt::date").Apply(f.query)
-	err := q.Select()
+	var txns []*storage.Txn
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, &txns).ColumnExpr("*, txn_dt::date, settle_dt::date"), filter, txnFields)
 	if err != nil {
-		return nil, fmt.Errorf("listing txns: %w", err)
+		return nil, "", err
+	}
+	p := page.NewPager(pageSize, keys, filter).Dates("txn_dt", "settle_dt")
+	if q, err = p.Apply(q, pageToken); err != nil {
+		return nil, "", err
+	}
+	if err = q.Select(); err != nil {
+		return nil, "", fmt.Errorf("listing txns: %w", err)
+	}
+
+	// the next page starts after the last txn of this one
+	var nextPageToken string
+	n, more := p.Next(len(txns))
+	txns = txns[:n]
+	if more {
+		nextPageToken = p.Token(txnVals(txns[n-1], keys)...)
 	}
 
 	for _, txn := range txns {
-		// convert vids to vxids
-		txn.Id, err = vxid.Encode(txn.GetId(), vxid.PfxMap.Transaction)
-		if err != nil {
-			return nil, err
+		if err = encodeTxnIDs(txn); err != nil {
+			return nil, "", err
 		}
-		if txn.GetInstId() != "" {
-			txn.InstId, err = vxid.Encode(txn.GetInstId(), vxid.PfxMap.Instrument)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if txn.GetParentId() != "" {
-			txn.ParentId, err = vxid.Encode(txn.GetParentId(), vxid.PfxMap.Transaction)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if txn.GetSrcLotId() != "" {
-			txn.SrcLotId, err = vxid.Encode(txn.GetSrcLotId(), vxid.PfxMap.Lot)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if txn.GetTgtLotId() != "" {
-			txn.TgtLotId, err = vxid.Encode(txn.GetTgtLotId(), vxid.PfxMap.Lot)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if txn.GetTradeAmtCcyId() != "" {
-			txn.TradeAmtCcyId, err = vxid.Encode(txn.GetTradeAmtCcyId(), vxid.PfxMap.Instrument)
-			if err != nil {
-				return nil, err
-			}
+	}
+
+	return txns, nextPageToken, nil
+}
+
+// txnVals gets the values of the keys transactions are listed in order of from a transaction, for page tokens
+func txnVals(txn *storage.Txn, keys []page.Key) []string {
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		switch key.Col {
+		case "id":
+			vals[i] = txn.GetId()
+		case "txn_dt":
+			vals[i] = txn.GetTxnDt()
+		case "settle_dt":
+			vals[i] = txn.GetSettleDt()
+		case "txn_type":
+			vals[i] = txn.GetTxnType()
+		case "txn_sub_type":
+			vals[i] = txn.GetTxnSubType()
+		case "txn_size":
+			vals[i] = page.Float(txn.GetTxnSize())
+		case "state":
+			vals[i] = txn.GetState()
+		case "trade_amt_gross":
+			vals[i] = page.Float(txn.GetTradeAmtGross())
+		case "trade_amt_net":
+			vals[i] = page.Float(txn.GetTradeAmtNet())
+		case "settle_amt_gross":
+			vals[i] = page.Float(txn.GetSettleAmtGross())
+		case "settle_amt_net":
+			vals[i] = page.Float(txn.GetSettleAmtNet())
+		case "ext_id":
+			vals[i] = txn.GetExtId()
 		}
-		if txn.GetSettleAmtCcyId() != "" {
-			txn.SettleAmtCcyId, err = vxid.Encode(txn.GetSettleAmtCcyId(), vxid.PfxMap.Instrument)
-			if err != nil {
-				return nil, err
+	}
+
+	return vals
+}
+
+// StreamTxns calls a function with each txn matching a filter, in order of txn date, without holding them
+// all in memory. streaming stops at the first error the function returns
+func (s *storeImpl) StreamTxns(ctx context.Context, filter string, fn func(txn *storage.Txn) error) error {
+	q, err := filterPkg.Apply(s.conn.ModelContext(ctx, (*storage.Txn)(nil)).ColumnExpr("*, txn_dt::date, settle_dt::date"), filter, txnFields)
+	if err != nil {
+		return err
+	}
+
+	err = q.OrderExpr("txn.txn_dt, txn.id").
+		ForEach(func(txn *storage.Txn) error {
+			if err := encodeTxnIDs(txn); err != nil {
+				return err
 			}
-		}
+			return fn(txn)
+		})
+	if err != nil {
+		return fmt.Errorf("streaming txns: %w", err)
 	}
 
-	return txns, nil
+	return nil
 }
 
-// UpdateTxn updates a transaction via the Transaction store
-func (s *storeImpl) UpdateTxn(ctx context.Context, txn *storage.Txn, fieldMask []string) error {
+// encodeTxnIDs converts the vids of a txn to vxids
+func encodeTxnIDs(txn *storage.Txn) error {
 	var err error
-	tgtTxn := txn
-
-	// if not performing a full replace, copy over only the fields passed in from the field mask
-	if fieldMask != nil {
-		// get original txn object to update
-		tgtTxn, err = s.GetTxn(ctx, txn.GetId())
+	txn.Id, err = vxid.Encode(txn.GetId(), vxid.PfxMap.Transaction)
+	if err != nil {
+		return err
+	}
+	if txn.GetInstId() != "" {
+		txn.InstId, err = vxid.Encode(txn.GetInstId(), vxid.PfxMap.Instrument)
 		if err != nil {
 			return err
 		}
-
-		mask, err := fieldmask_utils.MaskFromPaths(fieldMask, casing.Camel)
+	}
+	if txn.GetParentId() != "" {
+		txn.ParentId, err = vxid.Encode(txn.GetParentId(), vxid.PfxMap.Transaction)
 		if err != nil {
 			return err
 		}
-		fieldmask_utils.StructToStruct(mask, txn, tgtTxn)
 	}
-
-	// convert vxids to vids
-	tgtTxn.Id, err = vxid.Decode(tgtTxn.GetId())
-	if err != nil {
-		return err
+	if txn.GetSrcLotId() != "" {
+		txn.SrcLotId, err = vxid.Encode(txn.GetSrcLotId(), vxid.PfxMap.Lot)
+		if err != nil {
+			return err
+		}
 	}
-	if tgtTxn.GetInstId() != "" {
-		tgtTxn.InstId, err = vxid.Decode(tgtTxn.GetInstId())
+	if txn.GetTgtLotId() != "" {
+		txn.TgtLotId, err = vxid.Encode(txn.GetTgtLotId(), vxid.PfxMap.Lot)
 		if err != nil {
 			return err
 		}
 	}
-	if tgtTxn.GetParentId() != "" {
-		tgtTxn.ParentId, err = vxid.Decode(tgtTxn.GetParentId())
+	if txn.GetTradeAmtCcyId() != "" {
+		txn.TradeAmtCcyId, err = vxid.Encode(txn.GetTradeAmtCcyId(), vxid.PfxMap.Instrument)
 		if err != nil {
 			return err
 		}
 	}
-	if tgtTxn.GetSrcLotId() != "" {
-		tgtTxn.SrcLotId, err = vxid.Decode(tgtTxn.GetSrcLotId())
+	if txn.GetSettleAmtCcyId() != "" {
+		txn.SettleAmtCcyId, err = vxid.Encode(txn.GetSettleAmtCcyId(), vxid.PfxMap.Instrument)
 		if err != nil {
 			return err
 		}
 	}
-	if tgtTxn.GetTgtLotId() != "" {
-		tgtTxn.TgtLotId, err = vxid.Decode(tgtTxn.GetTgtLotId())
+	if txn.GetAcctId() != "" {
+		txn.AcctId, err = vxid.Encode(txn.GetAcctId(), vxid.PfxMap.Account)
 		if err != nil {
 			return err
 		}
 	}
-	if tgtTxn.GetTradeAmtCcyId() != "" {
-		tgtTxn.TradeAmtCcyId, err = vxid.Decode(tgtTxn.GetTradeAmtCcyId())
+	if txn.GetLeOrgId() != "" {
+		txn.LeOrgId, err = vxid.Encode(txn.GetLeOrgId(), vxid.PfxMap.Organization)
 		if err != nil {
 			return err
 		}
 	}
-	if tgtTxn.GetSettleAmtCcyId() != "" {
-		tgtTxn.SettleAmtCcyId, err = vxid.Decode(tgtTxn.GetSettleAmtCcyId())
+	if txn.GetPortId() != "" {
+		txn.PortId, err = vxid.Encode(txn.GetPortId(), vxid.PfxMap.Portfolio)
 		if err != nil {
 			return err
 		}
 	}
-
-	// update txn in datastore
-	_, err = s.conn.ModelContext(ctx, tgtTxn).WherePK().Update()
-	if err != nil {
-		return fmt.Errorf("update txn %s %w", txn.GetId(), err)
+	if txn.GetStratId() != "" {
+		txn.StratId, err = vxid.Encode(txn.GetStratId(), vxid.PfxMap.Strategy)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// CreateTxn creates a new transaction via the Transaction store
-func (s *storeImpl) CreateTxn(ctx context.Context, txn *storage.Txn) (*storage.Txn, error) {
+// decodeTxnIDs converts the vxids of a txn to vids
+func decodeTxnIDs(txn *storage.Txn) error {
 	var err error
-
-	// save off vxids before converting them to vids to save some cycles
-	var xTxn storage.Txn
-	xTxn.InstId = txn.GetInstId()
-	xTxn.ParentId = txn.GetParentId()
-	xTxn.SrcLotId = txn.GetSrcLotId()
-	xTxn.TgtLotId = txn.GetTgtLotId()
-	xTxn.TradeAmtCcyId = txn.GetTradeAmtCcyId()
-	xTxn.SettleAmtCcyId = txn.GetSettleAmtCcyId()
-
-	// convert vxids to vids
+	if txn.GetId() != "" {
+		txn.Id, err = vxid.Decode(txn.GetId())
+		if err != nil {
+			return err
+		}
+	}
 	if txn.GetInstId() != "" {
-		txn.InstId, err = vxid.Decode(txn.InstId)
+		txn.InstId, err = vxid.Decode(txn.GetInstId())
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if txn.GetParentId() != "" {
 		txn.ParentId, err = vxid.Decode(txn.GetParentId())
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if txn.GetSrcLotId() != "" {
 		txn.SrcLotId, err = vxid.Decode(txn.GetSrcLotId())
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if txn.GetTgtLotId() != "" {
 		txn.TgtLotId, err = vxid.Decode(txn.GetTgtLotId())
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if txn.GetTradeAmtCcyId() != "" {
 		txn.TradeAmtCcyId, err = vxid.Decode(txn.GetTradeAmtCcyId())
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 	if txn.GetSettleAmtCcyId() != "" {
 		txn.SettleAmtCcyId, err = vxid.Decode(txn.GetSettleAmtCcyId())
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+	if txn.GetAcctId() != "" {
+		txn.AcctId, err = vxid.Decode(txn.GetAcctId())
+		if err != nil {
+			return err
+		}
+	}
+	if txn.GetLeOrgId() != "" {
+		txn.LeOrgId, err = vxid.Decode(txn.GetLeOrgId())
+		if err != nil {
+			return err
+		}
+	}
+	if txn.GetPortId() != "" {
+		txn.PortId, err = vxid.Decode(txn.GetPortId())
+		if err != nil {
+			return err
+		}
+	}
+	if txn.GetStratId() != "" {
+		txn.StratId, err = vxid.Decode(txn.GetStratId())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateTxn updates a transaction via the Transaction store
+func (s *storeImpl) UpdateTxn(ctx context.Context, txn *storage.Txn, fieldMask []string) error {
+	return s.saveTxn(ctx, txn, fieldMask, changeStore.Update)
+}
+
+// ProcessTxn saves a transaction that's been processed via the Transaction store
+func (s *storeImpl) ProcessTxn(ctx context.Context, txn *storage.Txn) error {
+	return s.saveTxn(ctx, txn, nil, changeStore.Process)
+}
+
+// saveTxn updates a transaction, recording the change as the operation passed in
+func (s *storeImpl) saveTxn(ctx context.Context, txn *storage.Txn, fieldMask []string, op string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := updateTxn(ctx, tx, txn, fieldMask); err != nil {
+			return err
 		}
+		return recordTxns(ctx, tx, op, txn.GetId())
+	})
+	if err != nil {
+		return err
+	}
+	txn.Version++
+
+	return nil
+}
+
+// UpdateTxns updates a set of transactions via the Transaction store, each with its own field mask. either
+// every transaction is updated or none are
+func (s *storeImpl) UpdateTxns(ctx context.Context, txns []*storage.Txn, fieldMasks [][]string) error {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		ids := make([]string, len(txns))
+		for i, txn := range txns {
+			if err := updateTxn(ctx, tx, txn, fieldMasks[i]); err != nil {
+				return err
+			}
+			ids[i] = txn.GetId()
+		}
+		return recordTxns(ctx, tx, changeStore.Update, ids...)
+	})
+	if err != nil {
+		return err
+	}
+
+	// versions only move once the whole set is in, so a set that failed can be retried as it is
+	for _, txn := range txns {
+		txn.Version++
+	}
+
+	return nil
+}
+
+// updateTxn updates a transaction with either a connection or a database transaction. the transaction
+// passed in isn't changed
+func updateTxn(ctx context.Context, db orm.DB, txn *storage.Txn, fieldMask []string) error {
+	var err error
+	tgtTxn := proto.Clone(txn).(*storage.Txn)
+
+	// if not performing a full replace, copy over only the fields passed in from the field mask
+	if fieldMask != nil {
+		// get original txn object to update
+		tgtTxn, err = getTxn(ctx, db, txn.GetId())
+		if err != nil {
+			return err
+		}
+
+		mask, err := fieldmask_utils.MaskFromPaths(fieldMask, casing.Camel)
+		if err != nil {
+			return err
+		}
+		fieldmask_utils.StructToStruct(mask, txn, tgtTxn)
+	}
+
+	// convert vxids to vids
+	if err = decodeTxnIDs(tgtTxn); err != nil {
+		return err
+	}
+
+	// update txn in datastore if it's still at the version the caller read
+	tgtTxn.Version = txn.GetVersion() + 1
+	if err = version.Update(ctx, db, tgtTxn, txn.GetVersion(), "txn "+txn.GetId()); err != nil {
+		return err
 	}
 
-	// insert txn in datastore
-	_, err = s.conn.ModelContext(ctx, txn).Insert()
+	return nil
+}
+
+// CreateTxn creates a new transaction via the Transaction store
+func (s *storeImpl) CreateTxn(ctx context.Context, txn *storage.Txn) (*storage.Txn, error) {
+	txns, err := s.CreateTxns(ctx, []*storage.Txn{txn})
 	if err != nil {
 		return nil, err
 	}
+	return txns[0], nil
+}
 
-	// convert vids to vxids
-	txn.Id, err = vxid.Encode(txn.GetId(), vxid.PfxMap.Transaction)
+// CreateTxns creates a set of transactions via the Transaction store with a single insert. either every
+// transaction is created or none are
+func (s *storeImpl) CreateTxns(ctx context.Context, txns []*storage.Txn) ([]*storage.Txn, error) {
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if _, err := insertTxns(ctx, tx, txns); err != nil {
+			return err
+		}
+		return recordTxns(ctx, tx, changeStore.Create, txnIDs(txns)...)
+	})
 	if err != nil {
 		return nil, err
 	}
-	txn.InstId = xTxn.GetInstId()
-	txn.ParentId = xTxn.GetParentId()
-	txn.SrcLotId = xTxn.GetSrcLotId()
-	txn.TgtLotId = xTxn.GetTgtLotId()
-	txn.TradeAmtCcyId = xTxn.GetTradeAmtCcyId()
-	txn.SettleAmtCcyId = xTxn.GetSettleAmtCcyId()
 
-	return txn, nil
+	return txns, nil
+}
+
+// CreateTxnGroups creates groups of transactions via the Transaction store, setting the parent id of each
+// kid. a kid with an ext_id already in its account is skipped and left out of its parent's totals, and a
+// new parent whose kids are all skipped isn't created. a new parent someone else created in the meantime
+// is added to like an existing one. either every other transaction is created, and every existing parent
+// updated, or none are. it returns how many kids were skipped
+func (s *storeImpl) CreateTxnGroups(ctx context.Context, groups []*TxnGroup) (int, error) {
+	skipped := 0
+	err := s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var created, updated []string
+		for _, group := range groups {
+			parent := group.Parent
+			newParent := parent != nil && parent.GetId() == ""
+			if newParent {
+				inserted, err := insertNewTxns(ctx, tx, []*storage.Txn{parent})
+				if err != nil {
+					return fmt.Errorf("creating parent txn %s: %w", parent.GetExtId(), err)
+				}
+				if len(inserted) > 0 {
+					created = append(created, parent.GetId())
+				} else {
+					// another import created the parent since it was looked for, so the kids are added to it
+					if err = findParent(ctx, tx, parent); err != nil {
+						return err
+					}
+					newParent = false
+				}
+			}
+			if parent != nil && !newParent {
+				if err := addTotals(ctx, tx, parent, parent, 1); err != nil {
+					return fmt.Errorf("updating parent txn %s: %w", parent.GetId(), err)
+				}
+				updated = append(updated, parent.GetId())
+			}
+
+			if parent != nil {
+				for _, kid := range group.Kids {
+					kid.ParentId = parent.GetId()
+				}
+			}
+			kids, err := insertNewTxns(ctx, tx, group.Kids)
+			if err != nil {
+				return err
+			}
+			created = append(created, txnIDs(kids)...)
+			n := len(group.Kids) - len(kids)
+			skipped += n
+			if parent == nil || n == 0 {
+				continue
+			}
+
+			// take the skipped kids back out of their parent
+			if newParent && len(kids) == 0 {
+				vid, err := vxid.Decode(parent.GetId())
+				if err != nil {
+					return err
+				}
+				if _, err = tx.ModelContext(ctx, (*storage.Txn)(nil)).Where("id = ?", vid).Delete(); err != nil {
+					return fmt.Errorf("deleting parent txn %s: %w", parent.GetExtId(), err)
+				}
+				created = created[:len(created)-1]
+				parent.Id = ""
+				continue
+			}
+			var less storage.Txn
+			for _, kid := range group.Kids {
+				if kid.GetId() == "" {
+					less.TxnSize += kid.GetTxnSize()
+					less.TradeAmtGross += kid.GetTradeAmtGross()
+					less.TradeAmtNet += kid.GetTradeAmtNet()
+					less.SettleAmtGross += kid.GetSettleAmtGross()
+					less.SettleAmtNet += kid.GetSettleAmtNet()
+				}
+			}
+			if err = addTotals(ctx, tx, parent, &less, -1); err != nil {
+				return fmt.Errorf("updating parent txn %s: %w", parent.GetId(), err)
+			}
+		}
+
+		if err := recordTxns(ctx, tx, changeStore.Create, created...); err != nil {
+			return err
+		}
+		return recordTxns(ctx, tx, changeStore.Update, updated...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return skipped, nil
+}
+
+// findParent gets the id and version of the multileg transaction in a new parent's account with its ext_id.
+// the ext_id can't be taken by any other kind of transaction
+func findParent(ctx context.Context, db orm.DB, parent *storage.Txn) error {
+	acctVid, err := vxid.Decode(parent.GetAcctId())
+	if err != nil {
+		return err
+	}
+
+	var existing storage.Txn
+	err = db.ModelContext(ctx, &existing).Column("id", "version").
+		Where("acct_id = ?", acctVid).
+		Where("ext_id = ?", parent.GetExtId()).
+		Where("txn_type = ?", "multileg").
+		Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return status.Errorf(codes.AlreadyExists, "ext_id %s of parent txn is taken by a txn that isn't a multileg", parent.GetExtId())
+		}
+		return fmt.Errorf("getting parent txn %s: %w", parent.GetExtId(), err)
+	}
+
+	parent.Id, err = vxid.Encode(existing.GetId(), vxid.PfxMap.Transaction)
+	if err != nil {
+		return err
+	}
+	parent.Version = existing.GetVersion()
+
+	return nil
+}
+
+// addTotals adds the size and amounts of a transaction, multiplied by sign, to a parent transaction's, as
+// long as the parent is still at its version. the parent moves on to the next version
+func addTotals(ctx context.Context, db orm.DB, parent *storage.Txn, totals *storage.Txn, sign float64) error {
+	if parent.GetVersion() <= 0 {
+		return status.Errorf(codes.InvalidArgument, "version required to update txn %s", parent.GetId())
+	}
+	vid, err := vxid.Decode(parent.GetId())
+	if err != nil {
+		return err
+	}
+
+	res, err := db.ModelContext(ctx, (*storage.Txn)(nil)).
+		Set("txn_size = coalesce(txn_size, 0) + ?", sign*totals.GetTxnSize()).
+		Set("trade_amt_gross = coalesce(trade_amt_gross, 0) + ?", sign*totals.GetTradeAmtGross()).
+		Set("trade_amt_net = coalesce(trade_amt_net, 0) + ?", sign*totals.GetTradeAmtNet()).
+		Set("settle_amt_gross = coalesce(settle_amt_gross, 0) + ?", sign*totals.GetSettleAmtGross()).
+		Set("settle_amt_net = coalesce(settle_amt_net, 0) + ?", sign*totals.GetSettleAmtNet()).
+		Set("version = version + 1").
+		Where("id = ?", vid).
+		Where("version = ?", parent.GetVersion()).
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return version.Conflict(ctx, db, &storage.Txn{Id: vid}, parent.GetVersion(), "txn "+parent.GetId())
+	}
+	parent.Version++
+
+	return nil
+}
+
+// insertTxns inserts a set of transactions into the datastore with a single insert, with either a
+// connection or a database transaction. the transactions passed in are only changed, getting their ids,
+// once they're inserted
+func insertTxns(ctx context.Context, db orm.DB, txns []*storage.Txn) ([]*storage.Txn, error) {
+	if len(txns) == 0 {
+		return txns, nil
+	}
+
+	// convert vxids to vids
+	rows := make([]*storage.Txn, len(txns))
+	for i, txn := range txns {
+		rows[i] = proto.Clone(txn).(*storage.Txn)
+		if err := decodeTxnIDs(rows[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// insert txns in datastore
+	if _, err := db.ModelContext(ctx, &rows).Insert(); err != nil {
+		return nil, fmt.Errorf("creating txns: %w", err)
+	}
+
+	// convert vids to vxids
+	for i, row := range rows {
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		txns[i].Id = id
+		txns[i].Version = row.GetVersion()
+	}
+
+	return txns, nil
+}
+
+// insertNewTxns inserts the transactions of a set that don't have an ext_id already in their account with
+// a single insert, with either a connection or a database transaction, returning the ones inserted. the
+// ones skipped are left without an id
+func insertNewTxns(ctx context.Context, db orm.DB, txns []*storage.Txn) ([]*storage.Txn, error) {
+	if len(txns) == 0 {
+		return nil, nil
+	}
+
+	// convert vxids to vids, giving each row its id up front to tell the rows inserted from the ones skipped
+	rows := make([]*storage.Txn, len(txns))
+	for i, txn := range txns {
+		rows[i] = proto.Clone(txn).(*storage.Txn)
+		if err := decodeTxnIDs(rows[i]); err != nil {
+			return nil, err
+		}
+		rows[i].Id = uuid.New().String()
+	}
+
+	// insert txns in datastore, skipping any that conflict on the unique (acct_id, ext_id) index
+	var inserted []*storage.Txn
+	_, err := db.ModelContext(ctx, &rows).
+		OnConflict("(acct_id, ext_id) WHERE ext_id IS NOT NULL DO NOTHING").
+		Returning("id, version").
+		Insert(&inserted)
+	if err != nil {
+		return nil, fmt.Errorf("creating txns: %w", err)
+	}
+	versions := make(map[string]int64, len(inserted))
+	for _, row := range inserted {
+		versions[row.GetId()] = row.GetVersion()
+	}
+
+	// convert vids to vxids
+	var created []*storage.Txn
+	for i, row := range rows {
+		v, ok := versions[row.GetId()]
+		if !ok {
+			continue
+		}
+		id, err := vxid.Encode(row.GetId(), vxid.PfxMap.Transaction)
+		if err != nil {
+			return nil, err
+		}
+		txns[i].Id = id
+		txns[i].Version = v
+		created = append(created, txns[i])
+	}
+
+	return created, nil
 }
 
 // DeleteTxn removes a transaction from the Transaction store
@@ -365,9 +841,66 @@ func (s *storeImpl) DeleteTxn(ctx context.Context, id string) error {
 		return err
 	}
 
-	if _, err = s.conn.ModelContext(ctx, (*storage.Txn)(nil)).Where("id = ?", vid).Delete(); err != nil {
-		return fmt.Errorf("deleting txn %s %w", id, err)
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var deleted []string
+		if _, err = tx.ModelContext(ctx, (*storage.Txn)(nil)).Where("id = ?", vid).Returning("id").Delete(&deleted); err != nil {
+			return fmt.Errorf("deleting txn %s %w", id, err)
+		}
+		return recordDeletes(ctx, tx, deleted)
+	})
+}
+
+// DeleteTxns removes a set of transactions from the Transaction store with a single delete
+func (s *storeImpl) DeleteTxns(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
 
-	return nil
+	// convert vxids to vids
+	vids, err := vxid.Decodes(ids)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		var deleted []string
+		if _, err = tx.ModelContext(ctx, (*storage.Txn)(nil)).Where("id IN (?)", pg.In(vids)).Returning("id").Delete(&deleted); err != nil {
+			return fmt.Errorf("deleting txns %w", err)
+		}
+		return recordDeletes(ctx, tx, deleted)
+	})
+}
+
+// txnIDs gets the ids of a set of transactions
+func txnIDs(txns []*storage.Txn) []string {
+	ids := make([]string, len(txns))
+	for i, txn := range txns {
+		ids[i] = txn.GetId()
+	}
+	return ids
+}
+
+// recordTxns records changes to a set of transactions, by their vxids, with the database transaction that
+// made them
+func recordTxns(ctx context.Context, tx *pg.Tx, op string, ids ...string) error {
+	changes := make([]*storage.Change, len(ids))
+	for i, id := range ids {
+		vid, err := vxid.Decode(id)
+		if err != nil {
+			return err
+		}
+		changes[i] = changeStore.New(changeStore.Txn, op, vid, "")
+	}
+
+	return changeStore.Record(ctx, tx, changes...)
+}
+
+// recordDeletes records the deletes of a set of transactions, by their vids
+func recordDeletes(ctx context.Context, tx *pg.Tx, vids []string) error {
+	changes := make([]*storage.Change, len(vids))
+	for i, vid := range vids {
+		changes[i] = changeStore.New(changeStore.Txn, changeStore.Delete, vid, "")
+	}
+
+	return changeStore.Record(ctx, tx, changes...)
 }
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/wolfinger/varangian/generated/storage"
+	"github.com/wolfinger/varangian/internal/vxid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testStore connects to the database in TEST_DB_CONN_STR, skipping the test if it isn't set. txns and the
+// changes recorded for them are written to temp tables that shadow the real ones, so the pool is kept to a
+// single connection
+func testStore(t *testing.T) *storeImpl {
+	connStr := os.Getenv("TEST_DB_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+
+	opt, err := pg.ParseURL(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.PoolSize = 1
+
+	conn := pg.Connect(opt)
+	t.Cleanup(func() { conn.Close() })
+
+	for _, stmt := range []string{
+		`CREATE TEMP TABLE txns (
+			id uuid PRIMARY KEY,
+			txn_dt date,
+			settle_dt date,
+			txn_type text,
+			txn_sub_type text,
+			txn_size float8,
+			inst_id uuid,
+			parent_id uuid,
+			src_lot_id uuid,
+			tgt_lot_id uuid,
+			state text,
+			trade_amt_ccy_id uuid,
+			trade_amt_gross float8,
+			trade_amt_net float8,
+			settle_amt_ccy_id uuid,
+			settle_amt_gross float8,
+			settle_amt_net float8,
+			acct_id uuid,
+			le_org_id uuid,
+			port_id uuid,
+			strat_id uuid,
+			ext_id text,
+			version bigint NOT NULL DEFAULT 1)`,
+		`CREATE UNIQUE INDEX ON txns (acct_id, ext_id) WHERE ext_id IS NOT NULL`,
+		`CREATE TEMP TABLE changes (
+			id bigserial PRIMARY KEY,
+			resource text NOT NULL,
+			op text NOT NULL,
+			ref_id uuid,
+			ref_dt date,
+			created_at timestamptz NOT NULL)`,
+	} {
+		if _, err = conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &storeImpl{conn: conn}
+}
+
+// order is a multileg parent with an ext_id and a fill under it
+func order(acctID string, extID string, fillID string) *TxnGroup {
+	return &TxnGroup{
+		Parent: &storage.Txn{TxnType: "multileg", AcctId: acctID, ExtId: extID, TxnDt: "2021-01-04", TxnSize: 10, TradeAmtNet: 100},
+		Kids:   []*storage.Txn{{TxnType: "trade", TxnSubType: "buy", AcctId: acctID, ExtId: fillID, TxnDt: "2021-01-04", TxnSize: 10, TradeAmtNet: 100}},
+	}
+}
+
+func TestCreateTxnGroupsParent(t *testing.T) {
+	ctx := context.Background()
+	s := testStore(t)
+	acctID, _ := vxid.Encode("3b0f7f2e-5d0c-4f6e-9a51-2f1d4c8e7a10", vxid.PfxMap.Account)
+
+	// an order's fills are added to a parent another import created first
+	if _, err := s.CreateTxnGroups(ctx, []*TxnGroup{order(acctID, "ord_1", "fill_1")}); err != nil {
+		t.Fatal(err)
+	}
+	group := order(acctID, "ord_1", "fill_2")
+	if _, err := s.CreateTxnGroups(ctx, []*TxnGroup{group}); err != nil {
+		t.Fatalf("fills for an existing parent got: %v", err)
+	}
+	parent, err := s.GetTxn(ctx, group.Parent.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.GetTxnSize() != 20 || parent.GetTradeAmtNet() != 200 || group.Kids[0].GetParentId() != parent.GetId() {
+		t.Errorf("existing parent got: %v with fill parent %s, want both fills under it", parent, group.Kids[0].GetParentId())
+	}
+
+	// an ext_id taken by a txn that isn't a multileg can't be a parent, and nothing in the group is created
+	trade := &TxnGroup{Kids: []*storage.Txn{{TxnType: "trade", TxnSubType: "buy", AcctId: acctID, ExtId: "ord_2", TxnDt: "2021-01-04", TxnSize: 5}}}
+	if _, err = s.CreateTxnGroups(ctx, []*TxnGroup{trade}); err != nil {
+		t.Fatal(err)
+	}
+	group = order(acctID, "ord_2", "fill_3")
+	if _, err = s.CreateTxnGroups(ctx, []*TxnGroup{group}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("parent with a trade's ext_id got: %v, want: %v", err, codes.AlreadyExists)
+	}
+	n, err := s.conn.ModelContext(ctx, (*storage.Txn)(nil)).Where("ext_id = ?", "fill_3").Count()
+	if err != nil || n != 0 {
+		t.Errorf("fill of a rejected parent got %d created (%v), want none", n, err)
+	}
+	got, err := s.GetTxn(ctx, trade.Kids[0].GetId())
+	if err != nil || got.GetTxnSize() != 5 || got.GetVersion() != 1 {
+		t.Errorf("trade with the parent's ext_id got: %v, %v, want it unchanged", got, err)
+	}
+}